@@ -1,23 +1,186 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 )
 
 // Config application configuration
 type Config struct {
 	Model              string // Target model name
 	OllamaURL          string // Ollama server address
+	OllamaModelsDir    string // Ollama's model directory mounted into the proxy (read-only is enough); "" = no model export
 	Port               int    // Proxy server port
 	DownloadTimeout    int    // Download timeout in minutes
 	AppURL             string // Application URL for API access
 	OllamaPullDelaySec int    // Seconds to wait after Ollama is ready before first pull (for blob index to load, helps resume after restart)
+	PullInsecure       bool   // Pull from registries without valid TLS (plain HTTP or self-signed certificates)
+	PullQuiet          bool   // Log only pull status changes, not per-percent progress
+	DownloadConfirmMB  int    // Pulls of the served model downloading more than this many MB wait for a confirmation (0 = never)
 	BaseMode           bool   // Base mode: no specific model, show guide + version + model list
 	ThinkingMode       string  // "true" = auto-inject think:true, "false" = force think:false, "" = pass through (no injection)
 	ContextLength      int    // Default num_ctx to inject into requests (0 = don't inject, let model/Ollama decide)
 	RepeatPenalty      float64 // Default repeat_penalty injected into requests (0 = don't inject)
 	RepeatLastN        int     // Default repeat_last_n injected into requests (0 = don't inject)
+	EnablePprof        bool    // Expose net/http/pprof endpoints under /debug/pprof/
+	ConformanceAudit   bool    // Check every response for HTTP framing/header mistakes and log violations
+	PprofPort          int     // Serve pprof on a separate localhost-only port (0 = use the main port)
+	CompatProfile      string  // Client compatibility profile(s), comma-separated: default, openwebui, lobechat, librechat, none
+	ProbeResponse      string  // JSON object returned for GET probes on inference endpoints (default {"status":"ok"})
+	AdminToken         string  // Token required by /admin/* endpoints (empty = no auth)
+	AdminAddr          string  // Separate listener (host:port) for /admin, /api/errors and /debug (empty = main port)
+	StatusPageAuth     bool    // The progress page and its status APIs need ADMIN_TOKEN or a signed status link
+	StatusLinkTTLMin   int     // Default lifetime of the links /admin/status-link signs, in minutes
+	LogLevel           string  // "debug" (per-request traces), "info" or "warn"; changeable at runtime via /admin/loglevel
+	LogDedupWindowSec  int     // Collapse repeated "!!!" error lines within this many seconds into one summary (0 = log every line)
+
+	// HTTP server timeouts in seconds (0 = none)
+	ServerReadHeaderTimeoutSec int  // Time to receive request headers (slowloris protection)
+	ServerReadTimeoutSec       int  // Time to receive a whole request, body included
+	ServerWriteTimeoutSec      int  // Time to write a response; not applied to inference endpoints, which stream
+	ServerIdleTimeoutSec       int  // How long idle keep-alive connections are kept open
+	EnableH2C                  bool // Also accept HTTP/2 without TLS (h2c prior knowledge), for gateways that speak it to upstreams
+	GRPCPort                   int  // Serve the gRPC API (proto/inference.proto) on this port (0 = disabled)
+
+	// Crash / error reporting to a Sentry-compatible service
+	ErrorReportingDSN    string // https://<key>@<host>/<project>; empty = disabled
+	ErrorReportingEnv    string // "environment" attached to reports
+	ErrorReportThreshold int    // Report a 5xx error code once it occurs this often within an hour (0 = panics only)
+
+	// Lifecycle events audit trail (JSONL)
+	EventsLog      string // File path; "off" = disabled
+	EventsLogMaxMB int    // Rotate when the file would grow past this size
+	EventsLogKeep  int    // Rotated files kept (events.jsonl.1 .. .N)
+
+	// Concurrency limit and fast lane for title/summary style requests
+	MaxConcurrentRequests  int      // Max concurrent inference requests proxied to Ollama (0 = unlimited)
+	FastLaneSlots          int      // Extra slots reserved for fast-lane requests (only with MaxConcurrentRequests > 0)
+	MaxQueueDepth          int      // Requests that may wait for a slot; more are rejected with 429 (0 = unlimited)
+	QueueHintDepth         int      // Accepted requests report the queue when at least this many wait (0 = never)
+	FastLaneMaxTokens      int      // Requests with max_tokens <= this use the fast lane (0 = disable fast lane)
+	FastLaneMaxPromptChars int      // Short prompts (<= this many chars) with a title/summary marker use the fast lane
+	FastLaneModel          string   // Optional lighter model used for fast-lane requests (empty = OLLAMA_MODEL)
+	ReportServedModel      bool     // Report the model that answered (not OLLAMA_MODEL) in OpenAI responses' "model"
+	UserHeader             string   // Request header naming the Olares user (set by the Olares gateway), for per-user routes
+	RoutingRules           string   // JSON array of rules picking a model by prompt content and size (empty = off)
+	CompareModels          []string // The two models of /api/compare (empty = OLLAMA_MODEL and FAST_LANE_MODEL)
+
+	// Load shedding when the host is busy with other work
+	LoadShedding  bool // Sample host CPU, memory and GPU and reject low-priority requests when overloaded
+	LoadMaxCPUPct int  // CPU use (all cores) above which the host counts as overloaded
+	LoadMaxMemPct int  // Memory use above which the host counts as overloaded
+	LoadMaxGPUPct int  // GPU utilization (nvidia-smi) above which the host counts as overloaded
+	LoadSampleSec int  // How often the load is sampled
+
+	// Thermal mode for fanless or battery-backed devices (tunable at /admin/thermal)
+	ThermalMode          bool // Cap concurrent generations and pause between them past the duty cycle
+	ThermalMaxConcurrent int  // Generations running at once in thermal mode
+	ThermalDutyCyclePct  int  // Share of the window generations may keep the device busy
+	ThermalWindowSec     int  // The window the duty cycle is measured over
+	ThermalCooldownSec   int  // Pause before the next generation once the duty cycle is exceeded
+
+	// Residency of several models (OLLAMA_MODEL, FAST_LANE_MODEL and HOT_MODELS)
+	HotModels      []string // Extra models whose loading the proxy manages (empty = Ollama's own keep_alive)
+	HotModelsKeep  int      // How many of the most recently used managed models are kept loaded
+	PinnedModels   []string // Models the proxy never unloads, re-creates or removes (more via the admin API)
+	AdaptersDir    string   // LoRA adapters (GGUF) that can be attached to created models ("" = none)
+	PrefetchModels bool     // Pull missing routing-rule, fast-lane, hot and compare models in the background
+
+	// Server-side chat sessions (/api/sessions)
+	EnableSessions     bool // Expose the /api/sessions API
+	SessionMaxMessages int  // Messages kept per session and sent as context (0 = unlimited)
+	SessionTTLMin      int  // Idle minutes before a session is dropped (0 = never)
+
+	// Realtime sessions (/v1/realtime, experimental)
+	EnableRealtime bool // Expose the /v1/realtime WebSocket endpoint
+
+	// Secondary backends fronted on the same port (OpenAI-compatible services)
+	AudioTranscriptionURL string   // Speech-to-text service for /v1/audio/transcriptions and /translations (empty = not routed)
+	AudioSpeechURL        string   // Text-to-speech service for /v1/audio/speech (empty = not routed)
+	ImageGenerationURL    string   // Image service for /v1/images/generations (empty = not routed)
+	ImageModelAliases     []string // "alias=model" renames of the image model a request names
+
+	// Background inference jobs (/api/async)
+	EnableAsyncJobs bool // Expose the /api/async API
+	AsyncJobTTLSec  int  // How long finished jobs and their results are kept
+	AsyncMaxJobs    int  // Jobs running at once before submissions get 429 (0 = unlimited)
+
+	// Scheduled prompts (/admin/schedules), run as async jobs
+	EnableSchedules bool   // Run the prompt scheduler and expose its admin API
+	ScheduleWindow  string // Daily "HH:MM-HH:MM" window scheduled prompts may run in (empty = any time)
+
+	// Nightly maintenance (/admin/maintenance)
+	MaintenanceWindow       string   // Daily "HH:MM-HH:MM" window the maintenance tasks run in, once a day (empty = off)
+	MaintenanceTasks        []string // Tasks run in the window: compact_usage, rotate_logs, update_check, gc_models (empty = all)
+	MaintenancePullUpdates  bool     // Pull the models update_check finds changed in their registry
+	MaintenanceGCUnusedDays int      // gc_models removes models unused for this many days (0 = removes nothing)
+
+	// Declarative desired state (/admin/desired-state)
+	DesiredStateFile        string // JSON spec of the models, aliases and keep-warm models to have (empty = set via the admin API)
+	DesiredStateIntervalSec int    // How often the spec is re-read and reconciled
+	DesiredStateAllowPrune  bool   // Let a spec with "prune": true remove the models and aliases it doesn't list
+
+	// Context window management for chat requests
+	ContextTruncation    string // "truncate" (default), "summarize" or "off"
+	ContextReserveTokens int    // Tokens kept free for the reply when num_predict is not set
+
+	// Generation policy merged into every inference request
+	GlobalStopSequences []string // Stop sequences added to every request (GLOBAL_STOP_SEQUENCES, comma-separated)
+	MaxTokensCap        int      // Hard cap on num_predict/max_tokens (0 = no cap)
+	JSONRepair          string   // Repair invalid output of JSON-mode requests: "off", "fix" or "reprompt"
+	OutputFilters       []string // Post-processing of generated text: "ansi", "html", "whitespace" (empty = off)
+
+	// Estimated cost weights per 1K tokens, "model=weight" or "model=prompt/completion"
+	ModelCosts []string
+
+	// Outbound proxy (independent of HTTP_PROXY/HTTPS_PROXY in the process env)
+	OutboundProxy      string // http://, https://, socks5:// or socks5h:// proxy URL; empty = direct
+	OutboundProxyScope string // "downloads" (default: Hugging Face downloads only) or "all" (also Ollama traffic)
+
+	// Client headers forwarded to Ollama ("Name", "Prefix-*" or "*")
+	ForwardHeaders []string // Allowlist (empty = all but credentials and proxy headers)
+	DropHeaders    []string // Denylist, applied on top of the allowlist
+
+	ModelTypeGuard          bool   // Reject chat requests to embedding-only models and embeddings to chat models
+	MinOllamaVersion        string // Warn and report degraded status when Ollama is older (e.g. "0.5.0")
+	VersionCheckIntervalSec int    // How often /api/version is re-checked (0 = only at startup)
+
+	// Other Ollama servers per endpoint type or model: "chat=url", "embeddings=url", "vision=url", "model:name=url"
+	OllamaUpstreams []string
+	// Capacity of the Ollama servers for model placement: "url=vram_gb/disk_gb"
+	OllamaCapacity []string
+
+	// Other olares-ollama instances on the LAN serving the models this one lacks
+	EnablePeers    bool     // Expose /api/peer and forward requests for models only a peer has
+	Peers          []string // Static peer proxy URLs
+	PeerDiscovery  string   // "static" (PEERS only) or "mdns" (also announce and browse on the LAN)
+	PeerName       string   // Name announced to peers (default: the hostname)
+	PeerRefreshSec int      // How often peers' model lists are refreshed
+
+	UpstreamConnMaxAgeSec       int // Recycle keep-alive connections to Ollama after this many seconds (0 = only after errors)
+	UpstreamFirstByteTimeoutSec int // Give up on an Ollama request whose response headers haven't arrived after this many seconds (0 = no limit)
+
+	UpstreamProbeIntervalSec int  // How often the background prober pings Ollama for upstream_state (0 = disabled)
+	UpstreamUnreachableAfter int  // Consecutive failed probes before upstream_state turns from reconnecting to unreachable
+	CircuitBreaker           bool // Fail inference requests fast with 503 while upstream_state is unreachable
+
+	EmbedBatchSize   int // Inputs per upstream /api/embed call when splitting large batch requests
+	EmbedConcurrency int // Upstream embedding calls in flight per batch request
+
+	IdempotencyTTLSec         int // How long results of requests with an Idempotency-Key are replayed (0 = ignore the header)
+	IdempotencyRetries        int // Retries of transient upstream failures for non-streaming requests with an Idempotency-Key
+	IdempotencyRetryBackoffMs int // Pause before the first retry, doubled for each further one
+	ReplayCacheTTLSec         int // How long undelivered results of requests with an X-Request-Id are kept for the retry (0 = off)
+	StatusCacheSec            int // How long Ollama's /api/version and /api/ps answers are reused for pollers (0 = off)
+
+	StreamResumeTTLSec   int // How long SSE streams stay resumable with Last-Event-ID (0 = no event IDs, no resumption)
+	StreamResumeBufferKB int // Events kept per stream for resumption; older ones are dropped
 
 	// GGUF mode: download GGUF from Hugging Face and register via ollama create
 	HFEndpoint    string // HF base URL, e.g. "https://huggingface.co"
@@ -31,10 +194,28 @@ type Config struct {
 	GGUFTemplate     string // Raw Go template override (takes precedence over TemplateName)
 	GGUFSystem       string // System prompt baked into the model
 	GGUFMode         bool   // Auto-set: true when HFRepo and HFFile are both set
+
+	settings []Setting // every environment variable Load read, in order
+}
+
+// Setting is one environment variable as Load resolved it, for the startup
+// banner and /admin/config. Secret values are masked.
+type Setting struct {
+	Env     string `json:"env"`
+	Value   string `json:"value"`
+	Default string `json:"default"`
+	Source  string `json:"source"`            // "env" or "default"
+	Invalid string `json:"invalid,omitempty"` // unparseable value that was ignored in favor of the default
+
+	kind string // expected value type for error messages ("integer", "number", "boolean")
 }
 
+// resolved collects Settings while Load runs.
+var resolved []Setting
+
 // Load loads configuration from environment variables
 func Load() *Config {
+	resolved = nil
 	model := getEnv("OLLAMA_MODEL", "")
 	hfRepo := getEnv("HF_REPO", "")
 	hfFile := getEnv("HF_FILE", "")
@@ -43,15 +224,150 @@ func Load() *Config {
 	cfg := &Config{
 		Model:              model,
 		OllamaURL:          getEnv("OLLAMA_URL", "http://localhost:11434"),
+		OllamaModelsDir:    getEnv("OLLAMA_MODELS_DIR", ""),
 		Port:               getEnvInt("PORT", 8080),
 		DownloadTimeout:    getEnvInt("DOWNLOAD_TIMEOUT", 60),
 		AppURL:             getEnv("APP_URL", ""),
 		OllamaPullDelaySec: getEnvInt("OLLAMA_PULL_DELAY_SECONDS", 30),
+		PullInsecure:       getEnvBool("OLLAMA_PULL_INSECURE", false),
+		PullQuiet:          getEnvBool("OLLAMA_PULL_QUIET", false),
+		DownloadConfirmMB:  getEnvInt("DOWNLOAD_CONFIRM_THRESHOLD_MB", 0),
 		BaseMode:           model == "" && !ggufMode,
 		ThinkingMode:       getEnv("OLLAMA_THINKING", ""),
 		ContextLength:      getEnvInt("OLLAMA_CONTEXT_LENGTH", 0),
 		RepeatPenalty:      getEnvFloat("OLLAMA_REPEAT_PENALTY", 0),
 		RepeatLastN:        getEnvInt("OLLAMA_REPEAT_LAST_N", 0),
+		EnablePprof:        getEnvBool("ENABLE_PPROF", false),
+		PprofPort:          getEnvInt("PPROF_PORT", 0),
+		ConformanceAudit:   getEnvBool("CONFORMANCE_AUDIT", false),
+		CompatProfile:      getEnv("COMPAT_PROFILE", "default"),
+		ProbeResponse:      getEnv("PROBE_RESPONSE", ""),
+		AdminToken:         getEnv("ADMIN_TOKEN", ""),
+		AdminAddr:          getEnv("ADMIN_ADDR", ""),
+		StatusPageAuth:     getEnvBool("STATUS_PAGE_AUTH", false),
+		StatusLinkTTLMin:   getEnvInt("STATUS_LINK_TTL_MIN", 60),
+		LogLevel:           getEnv("LOG_LEVEL", "info"),
+		LogDedupWindowSec:  getEnvInt("LOG_DEDUP_WINDOW_SEC", 60),
+
+		ServerReadHeaderTimeoutSec: getEnvInt("SERVER_READ_HEADER_TIMEOUT_SEC", 10),
+		ServerReadTimeoutSec:       getEnvInt("SERVER_READ_TIMEOUT_SEC", 300),
+		ServerWriteTimeoutSec:      getEnvInt("SERVER_WRITE_TIMEOUT_SEC", 0),
+		ServerIdleTimeoutSec:       getEnvInt("SERVER_IDLE_TIMEOUT_SEC", 120),
+		EnableH2C:                  getEnvBool("ENABLE_H2C", false),
+		GRPCPort:                   getEnvInt("GRPC_PORT", 0),
+
+		ErrorReportingDSN:    getEnv("ERROR_REPORTING_DSN", ""),
+		ErrorReportingEnv:    getEnv("ERROR_REPORTING_ENVIRONMENT", "production"),
+		ErrorReportThreshold: getEnvInt("ERROR_REPORT_THRESHOLD", 5),
+
+		EventsLog:      getEnv("EVENTS_LOG", "data/events.jsonl"),
+		EventsLogMaxMB: getEnvInt("EVENTS_LOG_MAX_MB", 10),
+		EventsLogKeep:  getEnvInt("EVENTS_LOG_KEEP", 3),
+
+		MaxConcurrentRequests:  getEnvInt("MAX_CONCURRENT_REQUESTS", 0),
+		FastLaneSlots:          getEnvInt("FAST_LANE_SLOTS", 1),
+		MaxQueueDepth:          getEnvInt("MAX_QUEUE_DEPTH", 0),
+		QueueHintDepth:         getEnvInt("QUEUE_HINT_DEPTH", 1),
+		FastLaneMaxTokens:      getEnvInt("FAST_LANE_MAX_TOKENS", 64),
+		FastLaneMaxPromptChars: getEnvInt("FAST_LANE_MAX_PROMPT_CHARS", 4000),
+		FastLaneModel:          getEnv("FAST_LANE_MODEL", ""),
+		ReportServedModel:      getEnvBool("REPORT_SERVED_MODEL", false),
+		UserHeader:             getEnv("USER_HEADER", "X-Bfl-User"),
+		RoutingRules:           getEnv("ROUTING_RULES", ""),
+		CompareModels:          getEnvList("COMPARE_MODELS"),
+
+		LoadShedding:  getEnvBool("LOAD_SHEDDING", false),
+		LoadMaxCPUPct: getEnvInt("LOAD_MAX_CPU_PCT", 90),
+		LoadMaxMemPct: getEnvInt("LOAD_MAX_MEM_PCT", 90),
+		LoadMaxGPUPct: getEnvInt("LOAD_MAX_GPU_PCT", 95),
+		LoadSampleSec: getEnvInt("LOAD_SAMPLE_SEC", 2),
+
+		ThermalMode:          getEnvBool("THERMAL_MODE", false),
+		ThermalMaxConcurrent: getEnvInt("THERMAL_MAX_CONCURRENT", 1),
+		ThermalDutyCyclePct:  getEnvInt("THERMAL_DUTY_CYCLE_PCT", 70),
+		ThermalWindowSec:     getEnvInt("THERMAL_WINDOW_SEC", 300),
+		ThermalCooldownSec:   getEnvInt("THERMAL_COOLDOWN_SEC", 15),
+
+		HotModels:      getEnvList("HOT_MODELS"),
+		HotModelsKeep:  getEnvInt("HOT_MODELS_KEEP", 2),
+		PinnedModels:   getEnvList("PINNED_MODELS"),
+		AdaptersDir:    getEnv("ADAPTERS_DIR", "data/adapters"),
+		PrefetchModels: getEnvBool("PREFETCH_MODELS", false),
+
+		EnableSessions:     getEnvBool("ENABLE_SESSIONS", false),
+		SessionMaxMessages: getEnvInt("SESSION_MAX_MESSAGES", 40),
+		SessionTTLMin:      getEnvInt("SESSION_TTL_MIN", 1440),
+
+		EnableRealtime: getEnvBool("ENABLE_REALTIME", false),
+
+		AudioTranscriptionURL: getEnv("AUDIO_TRANSCRIPTION_URL", ""),
+		AudioSpeechURL:        getEnv("AUDIO_SPEECH_URL", ""),
+		ImageGenerationURL:    getEnv("IMAGE_GENERATION_URL", ""),
+		ImageModelAliases:     getEnvList("IMAGE_MODEL_ALIASES"),
+
+		EnableAsyncJobs: getEnvBool("ENABLE_ASYNC_JOBS", false),
+		AsyncJobTTLSec:  getEnvInt("ASYNC_JOB_TTL_SEC", 3600),
+		AsyncMaxJobs:    getEnvInt("ASYNC_MAX_JOBS", 32),
+
+		EnableSchedules: getEnvBool("ENABLE_SCHEDULES", false),
+		ScheduleWindow:  getEnv("SCHEDULE_WINDOW", ""),
+
+		MaintenanceWindow:       getEnv("MAINTENANCE_WINDOW", ""),
+		MaintenanceTasks:        getEnvList("MAINTENANCE_TASKS"),
+		MaintenancePullUpdates:  getEnvBool("MAINTENANCE_PULL_UPDATES", false),
+		MaintenanceGCUnusedDays: getEnvInt("MAINTENANCE_GC_UNUSED_DAYS", 0),
+
+		DesiredStateFile:        getEnv("DESIRED_STATE_FILE", ""),
+		DesiredStateIntervalSec: getEnvInt("DESIRED_STATE_INTERVAL_SEC", 300),
+		DesiredStateAllowPrune:  getEnvBool("DESIRED_STATE_ALLOW_PRUNE", false),
+
+		ContextTruncation:    getEnv("CONTEXT_TRUNCATION", "truncate"),
+		ContextReserveTokens: getEnvInt("CONTEXT_RESERVE_TOKENS", 1024),
+
+		GlobalStopSequences: getEnvList("GLOBAL_STOP_SEQUENCES"),
+		MaxTokensCap:        getEnvInt("MAX_TOKENS_CAP", 0),
+		JSONRepair:          getEnv("JSON_REPAIR", "off"),
+		OutputFilters:       getEnvList("OUTPUT_FILTERS"),
+
+		ModelCosts: getEnvList("MODEL_COSTS"),
+
+		OutboundProxy:      getEnv("OUTBOUND_PROXY", ""),
+		OutboundProxyScope: getEnv("OUTBOUND_PROXY_SCOPE", "downloads"),
+
+		ForwardHeaders: getEnvList("FORWARD_HEADERS"),
+		DropHeaders:    getEnvList("DROP_HEADERS"),
+
+		ModelTypeGuard:          getEnvBool("MODEL_TYPE_GUARD", true),
+		MinOllamaVersion:        getEnv("MIN_OLLAMA_VERSION", ""),
+		VersionCheckIntervalSec: getEnvInt("VERSION_CHECK_INTERVAL_SEC", 300),
+
+		OllamaUpstreams: getEnvList("OLLAMA_UPSTREAMS"),
+		OllamaCapacity:  getEnvList("OLLAMA_CAPACITY"),
+
+		EnablePeers:    getEnvBool("ENABLE_PEERS", false),
+		Peers:          getEnvList("PEERS"),
+		PeerDiscovery:  getEnv("PEER_DISCOVERY", "static"),
+		PeerName:       getEnv("PEER_NAME", ""),
+		PeerRefreshSec: getEnvInt("PEER_REFRESH_SEC", 30),
+
+		UpstreamConnMaxAgeSec:       getEnvInt("UPSTREAM_CONN_MAX_AGE_SEC", 300),
+		UpstreamFirstByteTimeoutSec: getEnvInt("UPSTREAM_FIRST_BYTE_TIMEOUT_SEC", 0),
+
+		UpstreamProbeIntervalSec: getEnvInt("UPSTREAM_PROBE_INTERVAL_SEC", 5),
+		UpstreamUnreachableAfter: getEnvInt("UPSTREAM_UNREACHABLE_AFTER", 3),
+		CircuitBreaker:           getEnvBool("CIRCUIT_BREAKER", true),
+
+		EmbedBatchSize:   getEnvInt("EMBED_BATCH_SIZE", 32),
+		EmbedConcurrency: getEnvInt("EMBED_CONCURRENCY", 2),
+
+		IdempotencyTTLSec:         getEnvInt("IDEMPOTENCY_TTL_SEC", 3600),
+		IdempotencyRetries:        getEnvInt("IDEMPOTENCY_RETRIES", 2),
+		IdempotencyRetryBackoffMs: getEnvInt("IDEMPOTENCY_RETRY_BACKOFF_MS", 500),
+		ReplayCacheTTLSec:         getEnvInt("REPLAY_CACHE_TTL_SEC", 300),
+		StatusCacheSec:            getEnvInt("STATUS_CACHE_SEC", 2),
+
+		StreamResumeTTLSec:   getEnvInt("STREAM_RESUME_TTL_SEC", 0),
+		StreamResumeBufferKB: getEnvInt("STREAM_RESUME_BUFFER_KB", 1024),
 
 		HFEndpoint:   getEnv("HF_ENDPOINT", "https://huggingface.co"),
 		HFRepo:       hfRepo,
@@ -65,6 +381,8 @@ func Load() *Config {
 		GGUFSystem:       getEnv("GGUF_SYSTEM", ""),
 		GGUFMode:         ggufMode,
 	}
+	cfg.settings = resolved
+	resolved = nil
 
 	return cfg
 }
@@ -163,44 +481,320 @@ func (c *Config) ResolveTemplate() string {
 	return ""
 }
 
+// Settings returns the resolved environment variables in the order Load read
+// them, defaults included, with secrets masked.
+func (c *Config) Settings() []Setting {
+	return append([]Setting(nil), c.settings...)
+}
+
+// record notes how key was resolved. raw is the environment value ("" if
+// unset); invalid means it was set but could not be parsed as kind.
+func record(key, kind, raw, value, defaultValue string, invalid bool) {
+	st := Setting{Env: key, Value: Mask(key, value), Default: Mask(key, defaultValue), Source: "default", kind: kind}
+	if raw != "" && !invalid {
+		st.Source = "env"
+	}
+	if invalid {
+		st.Invalid = Mask(key, raw)
+	}
+	resolved = append(resolved, st)
+}
+
+// secretSuffixes identify variables whose values are never shown.
+var secretSuffixes = []string{"TOKEN", "SECRET", "PASSWORD", "API_KEY", "DSN"}
+
+// Mask hides the value of secret variable key and credentials embedded in URLs.
+func Mask(key, value string) string {
+	if value == "" {
+		return ""
+	}
+	for _, suffix := range secretSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return "***"
+		}
+	}
+	if strings.Contains(value, "://") {
+		if u, err := url.Parse(value); err == nil && u.User != nil {
+			return u.Redacted()
+		}
+	}
+	return value
+}
+
 // getEnv gets environment variable, returns default value if not exists
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
+		record(key, "string", value, value, defaultValue, false)
 		return value
 	}
+	record(key, "string", "", defaultValue, defaultValue, false)
 	return defaultValue
 }
 
 // getEnvInt gets integer environment variable, returns default value if not exists
 func getEnvInt(key string, defaultValue int) int {
+	def := strconv.Itoa(defaultValue)
 	if value := os.Getenv(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
+			record(key, "integer", value, strconv.Itoa(intValue), def, false)
 			return intValue
 		}
+		record(key, "integer", value, def, def, true)
+		return defaultValue
 	}
+	record(key, "integer", "", def, def, false)
 	return defaultValue
 }
 
 // getEnvFloat gets float64 environment variable, returns default value if not exists
 func getEnvFloat(key string, defaultValue float64) float64 {
+	def := strconv.FormatFloat(defaultValue, 'g', -1, 64)
 	if value := os.Getenv(key); value != "" {
 		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			record(key, "number", value, strconv.FormatFloat(f, 'g', -1, 64), def, false)
 			return f
 		}
+		record(key, "number", value, def, def, true)
+		return defaultValue
 	}
+	record(key, "number", "", def, def, false)
 	return defaultValue
 }
 
+// getEnvList gets a list environment variable: a JSON array of strings
+// (needed for values containing commas or newlines) or a comma-separated list.
+func getEnvList(key string) []string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		record(key, "list", "", "", "", false)
+		return nil
+	}
+	if strings.HasPrefix(value, "[") {
+		var list []string
+		if err := json.Unmarshal([]byte(value), &list); err == nil {
+			record(key, "list", value, value, "", false)
+			return list
+		}
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	record(key, "list", value, value, "", false)
+	return list
+}
+
 // getEnvBool gets boolean environment variable, returns default value if not exists.
 // Accepts "true"/"1" as true and "false"/"0" as false (case-insensitive).
 func getEnvBool(key string, defaultValue bool) bool {
+	def := strconv.FormatBool(defaultValue)
 	value := os.Getenv(key)
 	if value == "" {
+		record(key, "boolean", "", def, def, false)
 		return defaultValue
 	}
 	b, err := strconv.ParseBool(value)
 	if err != nil {
+		record(key, "boolean", value, def, def, true)
 		return defaultValue
 	}
+	record(key, "boolean", value, strconv.FormatBool(b), def, false)
 	return b
 }
+
+// ParseClock parses a "HH:MM" time of day into minutes after midnight.
+func ParseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(strings.TrimSpace(s), ":")
+	hour, err1 := strconv.Atoi(h)
+	minute, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, fmt.Errorf("%q is not a time like 02:30", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// ParseWindow parses a daily "HH:MM-HH:MM" window into minutes after
+// midnight. An end before the start means the window spans midnight.
+func ParseWindow(s string) (start, end int, err error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("%q is not a window like 01:00-06:00", s)
+	}
+	if start, err = ParseClock(from); err != nil {
+		return 0, 0, err
+	}
+	if end, err = ParseClock(to); err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// ModelCost is the estimated cost of 1K prompt and completion tokens of a model.
+type ModelCost struct {
+	Prompt     float64
+	Completion float64
+}
+
+// ParseModelCosts parses MODEL_COSTS entries: "model=weight" for the same
+// weight on prompt and completion tokens, or "model=prompt/completion". The
+// model "*" sets the weight of models not listed.
+func ParseModelCosts(entries []string) (map[string]ModelCost, error) {
+	costs := make(map[string]ModelCost, len(entries))
+	for _, entry := range entries {
+		model, weights, ok := strings.Cut(entry, "=")
+		model = strings.TrimSpace(model)
+		if !ok || model == "" {
+			return nil, fmt.Errorf("%q is not model=weight or model=prompt/completion", entry)
+		}
+		prompt, completion, split := strings.Cut(weights, "/")
+		if !split {
+			completion = prompt
+		}
+		var cost ModelCost
+		var err error
+		if cost.Prompt, err = strconv.ParseFloat(strings.TrimSpace(prompt), 64); err != nil || cost.Prompt < 0 {
+			return nil, fmt.Errorf("%q: weight %q is not a number >= 0", entry, prompt)
+		}
+		if cost.Completion, err = strconv.ParseFloat(strings.TrimSpace(completion), 64); err != nil || cost.Completion < 0 {
+			return nil, fmt.Errorf("%q: weight %q is not a number >= 0", entry, completion)
+		}
+		costs[model] = cost
+	}
+	return costs, nil
+}
+
+// ParseModelAliases parses "alias=model" entries, e.g. IMAGE_MODEL_ALIASES.
+// The alias "*" renames models not listed; without it they pass unchanged.
+func ParseModelAliases(entries []string) (map[string]string, error) {
+	aliases := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		alias, model, ok := strings.Cut(entry, "=")
+		alias, model = strings.TrimSpace(alias), strings.TrimSpace(model)
+		if !ok || alias == "" || model == "" {
+			return nil, fmt.Errorf("%q is not alias=model", entry)
+		}
+		aliases[alias] = model
+	}
+	return aliases, nil
+}
+
+// Upstream is an OLLAMA_UPSTREAMS entry: the Ollama server for one kind of
+// request ("chat", "embeddings" or "vision") or, with Model set, for the
+// requests to that model.
+type Upstream struct {
+	Kind  string
+	Model string
+	URL   string
+}
+
+// UpstreamKinds are the request kinds OLLAMA_UPSTREAMS can route.
+var UpstreamKinds = []string{"chat", "embeddings", "vision"}
+
+// ParseUpstreams parses OLLAMA_UPSTREAMS entries: "kind=url" or
+// "model:name=url".
+func ParseUpstreams(entries []string) ([]Upstream, error) {
+	upstreams := make([]Upstream, 0, len(entries))
+	for _, entry := range entries {
+		selector, rawURL, ok := strings.Cut(entry, "=")
+		selector, rawURL = strings.TrimSpace(selector), strings.TrimSpace(rawURL)
+		if !ok || selector == "" || rawURL == "" {
+			return nil, fmt.Errorf("%q is not kind=url or model:name=url", entry)
+		}
+		if err := checkURL(rawURL, true); err != nil {
+			return nil, fmt.Errorf("%q: %v", entry, err)
+		}
+		up := Upstream{URL: rawURL}
+		if model, isModel := strings.CutPrefix(selector, "model:"); isModel {
+			if up.Model = strings.TrimSpace(model); up.Model == "" {
+				return nil, fmt.Errorf("%q: missing model name", entry)
+			}
+		} else if slices.Contains(UpstreamKinds, selector) {
+			up.Kind = selector
+		} else {
+			return nil, fmt.Errorf("%q: unknown kind %q (want %s or model:name)", entry, selector, strings.Join(UpstreamKinds, ", "))
+		}
+		upstreams = append(upstreams, up)
+	}
+	return upstreams, nil
+}
+
+// Capacity is the memory and disk of an Ollama server, in bytes (0 =
+// unknown).
+type Capacity struct {
+	VRAM int64
+	Disk int64
+}
+
+// ParseCapacities parses OLLAMA_CAPACITY entries: "url=vram_gb/disk_gb",
+// either part may be empty (e.g. "http://gpu-box:11434=24/").
+func ParseCapacities(entries []string) (map[string]Capacity, error) {
+	caps := make(map[string]Capacity, len(entries))
+	for _, entry := range entries {
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%q is not url=vram_gb/disk_gb", entry)
+		}
+		rawURL := strings.TrimSpace(entry[:i])
+		if err := checkURL(rawURL, true); err != nil {
+			return nil, fmt.Errorf("%q: %v", entry, err)
+		}
+		vram, disk, _ := strings.Cut(entry[i+1:], "/")
+		var c Capacity
+		for _, part := range []struct {
+			s   string
+			dst *int64
+		}{{vram, &c.VRAM}, {disk, &c.Disk}} {
+			if part.s = strings.TrimSpace(part.s); part.s == "" {
+				continue
+			}
+			gb, err := strconv.ParseFloat(part.s, 64)
+			if err != nil || gb < 0 {
+				return nil, fmt.Errorf("%q: %q is not a size in GB", entry, part.s)
+			}
+			*part.dst = int64(gb * (1 << 30))
+		}
+		caps[rawURL] = c
+	}
+	return caps, nil
+}
+
+// RoutingRule sends matching requests to another model. All conditions that
+// are set must hold; prompt sizes are estimated tokens.
+type RoutingRule struct {
+	Name            string `json:"name,omitempty"`
+	Model           string `json:"model"`
+	Pattern         string `json:"pattern,omitempty"` // regexp on the prompt text
+	Scope           string `json:"scope,omitempty"`   // "last" (the latest message, default) or "all"
+	MinPromptTokens int    `json:"min_prompt_tokens,omitempty"`
+	MaxPromptTokens int    `json:"max_prompt_tokens,omitempty"`
+}
+
+// ParseRoutingRules parses ROUTING_RULES, a JSON array of RoutingRule.
+func ParseRoutingRules(s string) ([]RoutingRule, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var rules []RoutingRule
+	if err := json.Unmarshal([]byte(s), &rules); err != nil {
+		return nil, fmt.Errorf("not a JSON array of rules: %v", err)
+	}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rules[i].Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if strings.TrimSpace(rule.Model) == "" {
+			return nil, fmt.Errorf("%s: model is required", rules[i].Name)
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return nil, fmt.Errorf("%s: invalid pattern: %v", rules[i].Name, err)
+		}
+		switch rule.Scope {
+		case "", "last", "all":
+		default:
+			return nil, fmt.Errorf("%s: scope %q must be last or all", rules[i].Name, rule.Scope)
+		}
+	}
+	return rules, nil
+}
@@ -0,0 +1,315 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// modelNamePattern matches Ollama model references:
+// [registry[:port]/][namespace/]name[:tag], e.g. "qwen3:8b",
+// "library/llama3.2" or "hf.co/unsloth/Qwen3-8B-GGUF:Q4_K_M".
+var modelNamePattern = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*(:[0-9]+)?/)?([A-Za-z0-9][A-Za-z0-9._-]*/)?[A-Za-z0-9][A-Za-z0-9._-]*(:[A-Za-z0-9_][A-Za-z0-9._-]{0,127})?$`)
+
+// headerPatternRe matches FORWARD_HEADERS/DROP_HEADERS entries: a header
+// name, optionally ending in "*", or "*" alone.
+var headerPatternRe = regexp.MustCompile(`^([A-Za-z0-9-]+\*?|\*)$`)
+
+var versionPattern = regexp.MustCompile(`^v?[0-9]+(\.[0-9]+)*$`)
+
+// Validate checks the loaded configuration and returns one error naming
+// every offending variable, instead of letting the proxy start with silently
+// substituted defaults or settings that contradict each other.
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, st := range c.settings {
+		if st.Invalid != "" {
+			add("%s=%q is not a valid %s (default: %q)", st.Env, st.Invalid, st.kind, st.Default)
+		}
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		add("PORT=%d is not a valid port (1-65535)", c.Port)
+	}
+	if c.PprofPort < 0 || c.PprofPort > 65535 {
+		add("PPROF_PORT=%d is not a valid port (1-65535, or 0 to use PORT)", c.PprofPort)
+	} else if c.PprofPort != 0 && c.PprofPort == c.Port {
+		add("PPROF_PORT=%d conflicts with PORT; use another port or 0 to serve pprof on PORT", c.PprofPort)
+	}
+
+	if c.GRPCPort < 0 || c.GRPCPort > 65535 {
+		add("GRPC_PORT=%d is not a valid port (1-65535, or 0 to disable gRPC)", c.GRPCPort)
+	} else if c.GRPCPort != 0 && (c.GRPCPort == c.Port || c.GRPCPort == c.PprofPort) {
+		add("GRPC_PORT=%d conflicts with PORT or PPROF_PORT; use another port", c.GRPCPort)
+	}
+	if c.AdminAddr != "" {
+		if _, port, err := net.SplitHostPort(c.AdminAddr); err != nil {
+			add("ADMIN_ADDR=%q is not a host:port address (e.g. 127.0.0.1:9090)", c.AdminAddr)
+		} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			add("ADMIN_ADDR=%q has an invalid port (1-65535)", c.AdminAddr)
+		} else if n == c.Port || n == c.PprofPort || n == c.GRPCPort {
+			add("ADMIN_ADDR=%q conflicts with PORT, PPROF_PORT or GRPC_PORT; use another port", c.AdminAddr)
+		}
+	}
+
+	if err := checkURL(c.OllamaURL, true); err != nil {
+		add("OLLAMA_URL=%q: %v", Mask("OLLAMA_URL", c.OllamaURL), err)
+	}
+	if c.AppURL != "" {
+		if err := checkURL(c.AppURL, false); err != nil {
+			add("APP_URL=%q: %v", Mask("APP_URL", c.AppURL), err)
+		}
+	}
+	if c.GGUFMode {
+		if err := checkURL(c.HFEndpoint, false); err != nil {
+			add("HF_ENDPOINT=%q: %v", Mask("HF_ENDPOINT", c.HFEndpoint), err)
+		}
+	}
+
+	if c.Model != "" && !modelNamePattern.MatchString(c.Model) {
+		add("OLLAMA_MODEL=%q is not a valid model name (expected [namespace/]name[:tag], e.g. \"qwen3:8b\")", c.Model)
+	}
+	if c.FastLaneModel != "" && !modelNamePattern.MatchString(c.FastLaneModel) {
+		add("FAST_LANE_MODEL=%q is not a valid model name (expected [namespace/]name[:tag])", c.FastLaneModel)
+	}
+	if len(c.CompareModels) > 0 {
+		if len(c.CompareModels) != 2 {
+			add("COMPARE_MODELS names %d models; it takes exactly two", len(c.CompareModels))
+		}
+		for _, m := range c.CompareModels {
+			if !modelNamePattern.MatchString(m) {
+				add("COMPARE_MODELS entry %q is not a valid model name (expected [namespace/]name[:tag])", m)
+			}
+		}
+	}
+
+	switch strings.ToLower(c.ThinkingMode) {
+	case "", "true", "1", "yes", "false", "0", "no":
+	default:
+		add("OLLAMA_THINKING=%q must be true, false or empty", c.ThinkingMode)
+	}
+	switch strings.ToLower(c.LogLevel) {
+	case "debug", "info", "warn":
+	default:
+		add("LOG_LEVEL=%q must be debug, info or warn", c.LogLevel)
+	}
+	switch strings.ToLower(c.ContextTruncation) {
+	case "truncate", "summarize", "off":
+	default:
+		add("CONTEXT_TRUNCATION=%q must be truncate, summarize or off", c.ContextTruncation)
+	}
+	switch strings.ToLower(c.JSONRepair) {
+	case "off", "fix", "reprompt":
+	default:
+		add("JSON_REPAIR=%q must be off, fix or reprompt", c.JSONRepair)
+	}
+	for _, f := range c.OutputFilters {
+		switch strings.ToLower(f) {
+		case "ansi", "html", "whitespace":
+		default:
+			add("OUTPUT_FILTERS entry %q must be ansi, html or whitespace", f)
+		}
+	}
+	for _, list := range []struct {
+		env     string
+		entries []string
+	}{{"FORWARD_HEADERS", c.ForwardHeaders}, {"DROP_HEADERS", c.DropHeaders}} {
+		for _, h := range list.entries {
+			if !headerPatternRe.MatchString(h) {
+				add("%s entry %q is not a header name, \"Prefix-*\" or \"*\"", list.env, h)
+			}
+		}
+	}
+	switch c.OutboundProxyScope {
+	case "downloads", "all":
+	default:
+		add("OUTBOUND_PROXY_SCOPE=%q must be downloads or all", c.OutboundProxyScope)
+	}
+	if c.ScheduleWindow != "" {
+		if _, _, err := ParseWindow(c.ScheduleWindow); err != nil {
+			add("SCHEDULE_WINDOW: %v", err)
+		}
+	}
+	if c.MaintenanceWindow != "" {
+		if _, _, err := ParseWindow(c.MaintenanceWindow); err != nil {
+			add("MAINTENANCE_WINDOW: %v", err)
+		}
+	}
+	for _, t := range c.MaintenanceTasks {
+		switch t {
+		case "compact_usage", "rotate_logs", "update_check", "gc_models":
+		default:
+			add("MAINTENANCE_TASKS entry %q must be compact_usage, rotate_logs, update_check or gc_models", t)
+		}
+	}
+	if c.MaintenanceGCUnusedDays > 30 {
+		add("MAINTENANCE_GC_UNUSED_DAYS=%d must be at most 30 (usage is kept 30 days)", c.MaintenanceGCUnusedDays)
+	}
+	if _, err := ParseRoutingRules(c.RoutingRules); err != nil {
+		add("ROUTING_RULES: %v", err)
+	}
+	if c.StatusPageAuth && c.AdminToken == "" {
+		add("STATUS_PAGE_AUTH needs ADMIN_TOKEN: status links are signed with it")
+	}
+	if _, err := ParseModelCosts(c.ModelCosts); err != nil {
+		add("MODEL_COSTS: %v", err)
+	}
+	if _, err := ParseUpstreams(c.OllamaUpstreams); err != nil {
+		add("OLLAMA_UPSTREAMS: %v", err)
+	}
+	if _, err := ParseCapacities(c.OllamaCapacity); err != nil {
+		add("OLLAMA_CAPACITY: %v", err)
+	}
+	if c.EnablePeers {
+		if c.PeerDiscovery != "static" && c.PeerDiscovery != "mdns" {
+			add("PEER_DISCOVERY=%q must be static or mdns", c.PeerDiscovery)
+		}
+		for _, peer := range c.Peers {
+			if err := checkURL(peer, true); err != nil {
+				add("PEERS entry %q: %v", Mask("PEERS", peer), err)
+			}
+		}
+	}
+	if _, err := ParseModelAliases(c.ImageModelAliases); err != nil {
+		add("IMAGE_MODEL_ALIASES: %v", err)
+	}
+	if c.MinOllamaVersion != "" && !versionPattern.MatchString(c.MinOllamaVersion) {
+		add("MIN_OLLAMA_VERSION=%q is not a version like 0.5.0", c.MinOllamaVersion)
+	}
+
+	for _, n := range []struct {
+		env   string
+		value int
+		min   int
+	}{
+		{"DOWNLOAD_TIMEOUT", c.DownloadTimeout, 1},
+		{"SERVER_READ_HEADER_TIMEOUT_SEC", c.ServerReadHeaderTimeoutSec, 0},
+		{"SERVER_READ_TIMEOUT_SEC", c.ServerReadTimeoutSec, 0},
+		{"SERVER_WRITE_TIMEOUT_SEC", c.ServerWriteTimeoutSec, 0},
+		{"SERVER_IDLE_TIMEOUT_SEC", c.ServerIdleTimeoutSec, 0},
+		{"OLLAMA_PULL_DELAY_SECONDS", c.OllamaPullDelaySec, 0},
+		{"DOWNLOAD_CONFIRM_THRESHOLD_MB", c.DownloadConfirmMB, 0},
+		{"STATUS_LINK_TTL_MIN", c.StatusLinkTTLMin, 1},
+		{"OLLAMA_CONTEXT_LENGTH", c.ContextLength, 0},
+		{"MAX_CONCURRENT_REQUESTS", c.MaxConcurrentRequests, 0},
+		{"FAST_LANE_SLOTS", c.FastLaneSlots, 0},
+		{"MAX_QUEUE_DEPTH", c.MaxQueueDepth, 0},
+		{"QUEUE_HINT_DEPTH", c.QueueHintDepth, 0},
+		{"LOAD_MAX_CPU_PCT", c.LoadMaxCPUPct, 1},
+		{"LOAD_MAX_MEM_PCT", c.LoadMaxMemPct, 1},
+		{"LOAD_MAX_GPU_PCT", c.LoadMaxGPUPct, 1},
+		{"LOAD_SAMPLE_SEC", c.LoadSampleSec, 1},
+		{"THERMAL_MAX_CONCURRENT", c.ThermalMaxConcurrent, 1},
+		{"THERMAL_DUTY_CYCLE_PCT", c.ThermalDutyCyclePct, 1},
+		{"THERMAL_WINDOW_SEC", c.ThermalWindowSec, 1},
+		{"THERMAL_COOLDOWN_SEC", c.ThermalCooldownSec, 0},
+		{"FAST_LANE_MAX_TOKENS", c.FastLaneMaxTokens, 0},
+		{"SESSION_MAX_MESSAGES", c.SessionMaxMessages, 0},
+		{"SESSION_TTL_MIN", c.SessionTTLMin, 0},
+		{"HOT_MODELS_KEEP", c.HotModelsKeep, 1},
+		{"ASYNC_JOB_TTL_SEC", c.AsyncJobTTLSec, 1},
+		{"ASYNC_MAX_JOBS", c.AsyncMaxJobs, 0},
+		{"CONTEXT_RESERVE_TOKENS", c.ContextReserveTokens, 0},
+		{"MAX_TOKENS_CAP", c.MaxTokensCap, 0},
+		{"VERSION_CHECK_INTERVAL_SEC", c.VersionCheckIntervalSec, 0},
+		{"UPSTREAM_CONN_MAX_AGE_SEC", c.UpstreamConnMaxAgeSec, 0},
+		{"UPSTREAM_FIRST_BYTE_TIMEOUT_SEC", c.UpstreamFirstByteTimeoutSec, 0},
+		{"UPSTREAM_PROBE_INTERVAL_SEC", c.UpstreamProbeIntervalSec, 0},
+		{"UPSTREAM_UNREACHABLE_AFTER", c.UpstreamUnreachableAfter, 1},
+		{"EMBED_BATCH_SIZE", c.EmbedBatchSize, 1},
+		{"EMBED_CONCURRENCY", c.EmbedConcurrency, 1},
+		{"IDEMPOTENCY_TTL_SEC", c.IdempotencyTTLSec, 0},
+		{"IDEMPOTENCY_RETRIES", c.IdempotencyRetries, 0},
+		{"IDEMPOTENCY_RETRY_BACKOFF_MS", c.IdempotencyRetryBackoffMs, 0},
+		{"REPLAY_CACHE_TTL_SEC", c.ReplayCacheTTLSec, 0},
+		{"STATUS_CACHE_SEC", c.StatusCacheSec, 0},
+		{"STREAM_RESUME_TTL_SEC", c.StreamResumeTTLSec, 0},
+		{"STREAM_RESUME_BUFFER_KB", c.StreamResumeBufferKB, 1},
+		{"ERROR_REPORT_THRESHOLD", c.ErrorReportThreshold, 0},
+		{"EVENTS_LOG_MAX_MB", c.EventsLogMaxMB, 1},
+		{"EVENTS_LOG_KEEP", c.EventsLogKeep, 0},
+		{"PEER_REFRESH_SEC", c.PeerRefreshSec, 1},
+		{"MAINTENANCE_GC_UNUSED_DAYS", c.MaintenanceGCUnusedDays, 0},
+		{"DESIRED_STATE_INTERVAL_SEC", c.DesiredStateIntervalSec, 10},
+	} {
+		if n.value < n.min {
+			add("%s=%d must be at least %d", n.env, n.value, n.min)
+		}
+	}
+	for _, p := range []struct {
+		env   string
+		value int
+	}{
+		{"LOAD_MAX_CPU_PCT", c.LoadMaxCPUPct},
+		{"LOAD_MAX_MEM_PCT", c.LoadMaxMemPct},
+		{"LOAD_MAX_GPU_PCT", c.LoadMaxGPUPct},
+		{"THERMAL_DUTY_CYCLE_PCT", c.ThermalDutyCyclePct},
+	} {
+		if p.value > 100 {
+			add("%s=%d must be at most 100", p.env, p.value)
+		}
+	}
+
+	// Options that only make sense together
+	if (c.HFRepo == "") != (c.HFFile == "") {
+		add("HF_REPO and HF_FILE must be set together (GGUF mode needs both)")
+	}
+	if c.HFMMProjFile != "" && !c.GGUFMode {
+		add("HF_MMPROJ_FILE is set but GGUF mode is off (set HF_REPO and HF_FILE)")
+	}
+	if c.GGUFTemplateName != "" && c.GGUFTemplate == "" && c.ResolveTemplate() == "" {
+		add("GGUF_TEMPLATE_NAME=%q is unknown (built-in: %s)", c.GGUFTemplateName, strings.Join(templateNames(), ", "))
+	}
+	if c.GGUFParams != "" {
+		var params map[string]interface{}
+		if err := json.Unmarshal([]byte(c.GGUFParams), &params); err != nil {
+			add("GGUF_PARAMS is not a JSON object: %v", err)
+		}
+	}
+	if c.FastLaneModel != "" && c.FastLaneMaxTokens == 0 && c.FastLaneMaxPromptChars == 0 {
+		add("FAST_LANE_MODEL is set but the fast lane is disabled (FAST_LANE_MAX_TOKENS and FAST_LANE_MAX_PROMPT_CHARS are 0)")
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+}
+
+// checkURL reports what is wrong with an http(s) URL. With schemeOptional a
+// bare host[:port] is accepted (OLLAMA_URL defaults the scheme to http).
+func checkURL(raw string, schemeOptional bool) error {
+	if schemeOptional && !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return errors.New("not a valid URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q (want http or https)", u.Scheme)
+	}
+	if u.Host == "" {
+		return errors.New("missing host")
+	}
+	return nil
+}
+
+func templateNames() []string {
+	names := make([]string, 0, len(builtinTemplates))
+	for name := range builtinTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
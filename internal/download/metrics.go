@@ -0,0 +1,110 @@
+package download
+
+import (
+	"sort"
+	"time"
+)
+
+// PullMetrics is the download state of one model, as exported on /metrics.
+type PullMetrics struct {
+	Model        string
+	Status       string
+	Completed    int64     // bytes downloaded in the current (or last) pull
+	Total        int64     // bytes to download, 0 until Ollama reports a size
+	SpeedBps     float64   // current speed, 0 when not transferring
+	Active       bool      // bytes are being transferred
+	Retries      int64     // attempts started again after a failure
+	Failures     int64     // attempts that ended in an error
+	LastProgress time.Time // when Completed last grew (zero = never)
+}
+
+// pullStats is the per-model record behind PullMetrics.
+type pullStats struct {
+	PullMetrics
+	retryPending bool // failed; the next attempt counts as a retry
+	pulling      bool // a pull started and hasn't finished or failed yet
+}
+
+// recordPullLocked updates the model's metrics for an UpdateProgress call
+// and returns the lifecycle event it amounts to, if any (see OnEvent).
+// pm.mu must be held and pm.speedBps already updated.
+func (pm *ProgressManager) recordPullLocked(status string, completed, total int64, modelName string, now time.Time) (event string) {
+	if modelName == "" {
+		return ""
+	}
+	if pm.pulls == nil {
+		pm.pulls = make(map[string]*pullStats)
+	}
+	ps := pm.pulls[modelName]
+	if ps == nil {
+		ps = &pullStats{PullMetrics: PullMetrics{Model: modelName}}
+		pm.pulls[modelName] = ps
+	}
+
+	switch {
+	case status == "error":
+		if ps.Status != "error" {
+			ps.Failures++ // an error is often reported twice, by the client and by its caller
+			event = "model_pull_failed"
+		}
+		ps.retryPending, ps.pulling = true, false
+	case status == "starting" || isTransferStatus(status):
+		if ps.retryPending {
+			ps.Retries++
+			ps.retryPending = false
+		}
+		if !ps.pulling {
+			ps.pulling = true
+			event = "model_pull_started"
+		}
+	case status == "completed" || status == "success":
+		if ps.Status != "completed" && ps.Status != "success" {
+			event = "model_ready"
+			if ps.pulling {
+				event = "model_pulled"
+			}
+		}
+		ps.retryPending, ps.pulling = false, false
+		if ps.Total > 0 {
+			ps.Completed = ps.Total
+		}
+	}
+
+	if total > 0 {
+		if completed > ps.Completed || ps.Total != total {
+			ps.LastProgress = now
+		}
+		ps.Completed, ps.Total = completed, total
+	} else if status == "starting" {
+		ps.Completed, ps.Total = 0, 0
+	}
+	ps.Status = status
+	ps.Active = isTransferStatus(status)
+	ps.SpeedBps = 0
+	if ps.Active {
+		ps.SpeedBps = pm.speedBps
+	}
+	return event
+}
+
+// RecordRetry counts a retry that doesn't go through an error status, such
+// as the Hugging Face downloader's in-process resume after a network error.
+func (pm *ProgressManager) RecordRetry(modelName string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if ps := pm.pulls[modelName]; ps != nil {
+		ps.Retries++
+	}
+}
+
+// PullMetrics returns the download state of every model seen so far, sorted by name.
+func (pm *ProgressManager) PullMetrics() []PullMetrics {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	out := make([]PullMetrics, 0, len(pm.pulls))
+	for _, ps := range pm.pulls {
+		out = append(out, ps.PullMetrics)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Model < out[j].Model })
+	return out
+}
@@ -28,12 +28,13 @@ func isTransferStatus(status string) bool {
 		return false
 	}
 	switch status {
-	case "downloading", "pulling", "pushing_blob":
+	case "downloading", "pulling", "pushing_blob", "importing":
 		return true
 	case "pulling manifest", "verifying", "verifying sha256 digest",
 		"writing manifest", "removing any unused layers",
 		"success", "completed", "error", "starting", "waiting",
-		"checking", "unavailable", "creating", "blob_pushed", "hashing":
+		"checking", "unavailable", "creating", "blob_pushed", "hashing",
+		"awaiting_confirmation":
 		return false
 	}
 	// "pulling <digest>" / "downloading <…>" 这种带后缀的也算传输。
@@ -56,14 +57,17 @@ type ProgressManager struct {
 	modelName      string
 	appURL         string
 	startTime      time.Time
-	completedAt    *time.Time // 完成时间，只在成功时设置一次
-	duration       int64     // 用时（秒），从持久化状态恢复
-	stateFile      string    // 状态文件路径
-	lastCompleted  int64     // 上一时刻已下载字节数，用于计算速度
-	lastUpdateTime time.Time // 上一时刻更新时间
-	speedBps       float64   // 当前下载速度（字节/秒）
-	errorMessage   string    // 错误详情，仅在 status=="error" 时有值
-	downloadSource string    // 下载源地址，用于错误提示（如 HF endpoint 或 Ollama URL）
+	completedAt    *time.Time                    // 完成时间，只在成功时设置一次
+	duration       int64                         // 用时（秒），从持久化状态恢复
+	stateFile      string                        // 状态文件路径
+	lastCompleted  int64                         // 上一时刻已下载字节数，用于计算速度
+	lastUpdateTime time.Time                     // 上一时刻更新时间
+	speedBps       float64                       // 当前下载速度（字节/秒）
+	errorMessage   string                        // 错误详情，仅在 status=="error" 时有值
+	downloadSource string                        // 下载源地址，用于错误提示（如 HF endpoint 或 Ollama URL）
+	extraFields    map[string]func() interface{} // live fields added to the response (upstream_state, model_load)
+	pulls          map[string]*pullStats         // per-model download metrics for /metrics
+	onEvent        func(event, modelName, detail string)
 }
 
 // persistedState 持久化的状态
@@ -195,6 +199,17 @@ func (pm *ProgressManager) SetDownloadSource(source string) {
 	pm.downloadSource = source
 }
 
+// SetField adds a field to the progress response whose value is computed by
+// fn on every request, e.g. upstream_state for the UI's unreachable banner.
+func (pm *ProgressManager) SetField(name string, fn func() interface{}) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.extraFields == nil {
+		pm.extraFields = make(map[string]func() interface{})
+	}
+	pm.extraFields[name] = fn
+}
+
 // SetErrorMessage sets the error detail text (capped at 500 chars).
 // It is typically called right before UpdateProgress("error", …).
 func (pm *ProgressManager) SetErrorMessage(msg string) {
@@ -215,8 +230,35 @@ func (pm *ProgressManager) UpdateError(errMsg string, completed, total int64, mo
 	pm.UpdateProgress("error", completed, total, modelName)
 }
 
+// OnEvent registers fn to be told when a model pull starts
+// ("model_pull_started"), finishes ("model_pulled"), fails
+// ("model_pull_failed", detail is the error) or the model turns out to be
+// installed already ("model_ready"). fn runs after the progress lock is released.
+func (pm *ProgressManager) OnEvent(fn func(event, modelName, detail string)) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.onEvent = fn
+}
+
 // UpdateProgress 更新下载进度
 func (pm *ProgressManager) UpdateProgress(status string, completed, total int64, modelName string) {
+	event, detail, hook := pm.updateProgress(status, completed, total, modelName, false)
+	if event != "" && hook != nil {
+		hook(event, modelName, detail)
+	}
+}
+
+// UpdateResumed is UpdateProgress for figures that grew by bytes a pull
+// found on disk rather than downloaded, such as a layer resumed after a
+// restart; they don't count towards the speed (and ETA).
+func (pm *ProgressManager) UpdateResumed(status string, completed, total int64, modelName string) {
+	event, detail, hook := pm.updateProgress(status, completed, total, modelName, true)
+	if event != "" && hook != nil {
+		hook(event, modelName, detail)
+	}
+}
+
+func (pm *ProgressManager) updateProgress(status string, completed, total int64, modelName string, resumed bool) (event, detail string, hook func(event, modelName, detail string)) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
@@ -230,7 +272,7 @@ func (pm *ProgressManager) UpdateProgress(status string, completed, total int64,
 		pm.lastCompleted = 0
 		pm.lastUpdateTime = now
 		pm.speedBps = 0
-	} else if !pm.lastUpdateTime.IsZero() && completed > pm.lastCompleted && total > 0 {
+	} else if !resumed && !pm.lastUpdateTime.IsZero() && completed > pm.lastCompleted && total > 0 {
 		elapsed := now.Sub(pm.lastUpdateTime).Seconds()
 		if elapsed > 0 {
 			instant := float64(completed-pm.lastCompleted) / elapsed
@@ -244,6 +286,7 @@ func (pm *ProgressManager) UpdateProgress(status string, completed, total int64,
 	}
 	pm.lastCompleted = completed
 	pm.lastUpdateTime = now
+	event = pm.recordPullLocked(status, completed, total, modelName, now)
 
 	pm.status = status
 	pm.completed = completed
@@ -267,6 +310,18 @@ func (pm *ProgressManager) UpdateProgress(status string, completed, total int64,
 		// 如果已经有完成时间，确保状态和模型名正确，并保存（以防状态变化）
 		pm.saveState()
 	}
+	return event, pm.errorMessage, pm.onEvent
+}
+
+// Busy reports whether a download, blob push or model creation is running.
+func (pm *ProgressManager) Busy() bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	switch pm.status {
+	case "starting", "creating", "hashing", "pulling manifest", "verifying", "verifying sha256 digest", "writing manifest":
+		return true
+	}
+	return isTransferStatus(pm.status)
 }
 
 // GetProgress 获取当前进度
@@ -325,6 +380,10 @@ func (pm *ProgressManager) HandleProgressAPI(w http.ResponseWriter, r *http.Requ
 
 	pm.mu.RLock()
 	downloadSource := pm.downloadSource
+	extraFields := make(map[string]func() interface{}, len(pm.extraFields))
+	for name, fn := range pm.extraFields {
+		extraFields[name] = fn
+	}
 	pm.mu.RUnlock()
 
 	response := map[string]interface{}{
@@ -359,6 +418,9 @@ func (pm *ProgressManager) HandleProgressAPI(w http.ResponseWriter, r *http.Requ
 	if progress.ErrorMessage != "" {
 		response["error_message"] = progress.ErrorMessage
 	}
+	for name, fn := range extraFields {
+		response[name] = fn()
+	}
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Printf("Failed to encode progress response: %v", err)
@@ -0,0 +1,146 @@
+// Package events writes server lifecycle events (model pulled, models loaded
+// and unloaded, Ollama going down and coming back, runtime configuration
+// changes) to an append-only JSONL file: one object per line with "time" and
+// "event", separate from the request logs. The file is rotated by size.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Log is an events file. A nil *Log records nothing.
+type Log struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64 // rotate before a line would grow the file past this
+	keep     int   // rotated files kept: path.1 (newest) .. path.<keep>
+	f        *os.File
+	size     int64
+}
+
+// Open opens (or creates) the events file at path for appending.
+func Open(path string, maxBytes int64, keep int) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	l := &Log{path: path, maxBytes: maxBytes, keep: keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// Record appends one event. fields are added next to "time" and "event".
+// Failures are logged, never returned: the audit trail must not break the
+// code that reports into it.
+func (l *Log) Record(event string, fields map[string]interface{}) {
+	if l == nil {
+		return
+	}
+	entry := make(map[string]interface{}, len(fields)+2)
+	for k, v := range fields {
+		entry[k] = v
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["event"] = event
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("!!! Events: cannot encode %s: %v !!!", event, err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return // closed
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			log.Printf("!!! Events: rotating %s failed: %v !!!", l.path, err)
+			if l.f == nil {
+				return
+			}
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("!!! Events: writing %s failed: %v !!!", l.path, err)
+	}
+}
+
+// rotate shifts path.N to path.N+1 (dropping the oldest), moves the current
+// file to path.1 and starts a new one. With keep 0 the file is just emptied.
+func (l *Log) rotate() error {
+	l.f.Close()
+	l.f = nil
+	if l.keep <= 0 {
+		if err := os.Truncate(l.path, 0); err != nil {
+			return err
+		}
+		return l.open()
+	}
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.keep))
+	for i := l.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		l.open() // keep appending to the old file rather than losing events
+		return err
+	}
+	return l.open()
+}
+
+// Rotate rotates the file now, whatever its size, and returns the size of
+// the file rotated away. An empty file is left alone.
+func (l *Log) Rotate() (int64, error) {
+	if l == nil {
+		return 0, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil || l.size == 0 {
+		return 0, nil
+	}
+	size := l.size
+	if err := l.rotate(); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// Close flushes and closes the file; later events are dropped.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
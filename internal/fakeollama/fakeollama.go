@@ -0,0 +1,412 @@
+// Package fakeollama is a scriptable stand-in for an Ollama server, for
+// end-to-end tests of the proxy. It speaks the parts of the Ollama API the
+// proxy uses: /api/chat and /api/generate (streaming or not, with thinking
+// and tool calls), /api/embed, /api/embeddings, /api/show, /api/tags,
+// /api/ps and /api/version, and records every request it receives. Replies
+// can also be scripted to misbehave the way a real upstream does: a slow
+// first byte, a dropped connection, a malformed or an error line mid-stream.
+package fakeollama
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is what /api/version reports unless SetVersion changes it.
+const Version = "0.12.0"
+
+// Fault is a way a reply can break, to test how the proxy copes with a
+// misbehaving upstream.
+type Fault int
+
+const (
+	NoFault Fault = iota
+	// Disconnect drops the connection without ending the body. A reply
+	// that isn't streamed is dropped before anything is sent.
+	Disconnect
+	// Truncate ends the body cleanly, but without the done chunk.
+	Truncate
+	// MalformedLine sends a line that is not JSON.
+	MalformedLine
+	// ErrorLine sends {"error": Error}, as Ollama does when generation
+	// fails after the response has started.
+	ErrorLine
+)
+
+// ToolCall is a function call in a scripted chat reply.
+type ToolCall struct {
+	Name      string
+	Arguments map[string]interface{}
+}
+
+// Reply scripts the answer to one chat or generate request.
+type Reply struct {
+	Content  string
+	Thinking string
+	// Chunks overrides how Content is split when streaming; by default it
+	// is split after every space.
+	Chunks    []string
+	ToolCalls []ToolCall
+	// DoneReason defaults to "stop".
+	DoneReason       string
+	PromptTokens     int
+	CompletionTokens int
+	// Status and Error turn the reply into an Ollama error response
+	// (unless Fault is ErrorLine).
+	Status int
+	Error  string
+	// Delay is waited before each streamed chunk (and before a non-streaming reply).
+	Delay time.Duration
+	// FirstByteDelay is waited before the response headers are sent.
+	FirstByteDelay time.Duration
+	// Fault breaks a streamed reply after FaultAfter chunks.
+	Fault      Fault
+	FaultAfter int
+}
+
+// Request is one request the fake received.
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   map[string]interface{} // nil when the body is not a JSON object
+}
+
+// Server is a running fake Ollama.
+type Server struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	version      string
+	models       []string
+	capabilities []string
+	replies      []Reply
+	fallback     Reply
+	requests     []Request
+	handlers     map[string]http.HandlerFunc
+}
+
+// New starts a fake serving one model, "test-model", that answers "ok".
+func New() *Server {
+	f := &Server{
+		version:      Version,
+		models:       []string{"test-model"},
+		capabilities: []string{"completion", "tools"},
+		fallback:     Reply{Content: "ok"},
+		handlers:     make(map[string]http.HandlerFunc),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// Enqueue scripts the next chat/generate replies, in order. When the queue
+// is empty the default reply (SetDefault) is used.
+func (f *Server) Enqueue(replies ...Reply) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies = append(f.replies, replies...)
+}
+
+// SetDefault sets the reply used when none is queued.
+func (f *Server) SetDefault(r Reply) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fallback = r
+}
+
+// SetModels sets the models /api/tags lists.
+func (f *Server) SetModels(names ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.models = append([]string(nil), names...)
+}
+
+// SetCapabilities sets what /api/show reports for every model.
+func (f *Server) SetCapabilities(caps ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.capabilities = append([]string(nil), caps...)
+}
+
+// SetVersion sets what /api/version reports.
+func (f *Server) SetVersion(v string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version = v
+}
+
+// Handle replaces the built-in handling of a path.
+func (f *Server) Handle(path string, h http.HandlerFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[path] = h
+}
+
+// Requests returns the requests received so far, optionally only those for path.
+func (f *Server) Requests(path ...string) []Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []Request
+	for _, r := range f.requests {
+		if len(path) == 0 || r.Path == path[0] {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// LastRequest returns the latest request for path.
+func (f *Server) LastRequest(path string) (Request, bool) {
+	reqs := f.Requests(path)
+	if len(reqs) == 0 {
+		return Request{}, false
+	}
+	return reqs[len(reqs)-1], true
+}
+
+func (f *Server) nextReply() Reply {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.replies) == 0 {
+		return f.fallback
+	}
+	r := f.replies[0]
+	f.replies = f.replies[1:]
+	return r
+}
+
+func (f *Server) serve(w http.ResponseWriter, r *http.Request) {
+	raw, _ := io.ReadAll(r.Body)
+	var body map[string]interface{}
+	json.Unmarshal(raw, &body)
+	f.mu.Lock()
+	f.requests = append(f.requests, Request{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: body})
+	h := f.handlers[r.URL.Path]
+	f.mu.Unlock()
+	if h != nil {
+		r.Body = io.NopCloser(strings.NewReader(string(raw)))
+		h(w, r)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/api/version":
+		f.mu.Lock()
+		v := f.version
+		f.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"version": v})
+	case "/api/tags":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"models": f.modelList()})
+	case "/api/ps":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"models": []interface{}{}})
+	case "/api/show":
+		f.mu.Lock()
+		caps := append([]string(nil), f.capabilities...)
+		f.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"capabilities": caps,
+			"details":      map[string]interface{}{"family": "test", "parameter_size": "1B", "quantization_level": "Q4_K_M"},
+			"model_info":   map[string]interface{}{"test.context_length": 8192},
+		})
+	case "/api/chat", "/api/generate":
+		f.generate(w, r.URL.Path, body)
+	case "/api/embed":
+		var embeddings [][]float64
+		for _, in := range inputs(body["input"]) {
+			embeddings = append(embeddings, Embedding(in))
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"model": body["model"], "embeddings": embeddings, "prompt_eval_count": len(embeddings),
+		})
+	case "/api/embeddings":
+		prompt, _ := body["prompt"].(string)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"embedding": Embedding(prompt)})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "404 page not found"})
+	}
+}
+
+func (f *Server) modelList() []map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	models := make([]map[string]interface{}, 0, len(f.models))
+	for _, m := range f.models {
+		name := m
+		if !strings.Contains(name, ":") {
+			name += ":latest"
+		}
+		models = append(models, map[string]interface{}{
+			"name": name, "model": name, "size": 1 << 30, "digest": "sha256:0",
+			"modified_at": time.Unix(0, 0).UTC().Format(time.RFC3339),
+			"details":     map[string]interface{}{"family": "test", "parameter_size": "1B"},
+		})
+	}
+	return models
+}
+
+// generate answers /api/chat and /api/generate with the next reply.
+func (f *Server) generate(w http.ResponseWriter, path string, body map[string]interface{}) {
+	reply := f.nextReply()
+	time.Sleep(reply.FirstByteDelay)
+	if reply.Status >= http.StatusBadRequest || (reply.Error != "" && reply.Fault != ErrorLine) {
+		status := reply.Status
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		writeJSON(w, status, map[string]interface{}{"error": reply.Error})
+		return
+	}
+	model, _ := body["model"].(string)
+	chat := path == "/api/chat"
+	chunk := func(content, thinking string, tools []ToolCall) map[string]interface{} {
+		c := map[string]interface{}{
+			"model":      model,
+			"created_at": time.Now().UTC().Format(time.RFC3339Nano),
+			"done":       false,
+		}
+		if chat {
+			msg := map[string]interface{}{"role": "assistant", "content": content}
+			if thinking != "" {
+				msg["thinking"] = thinking
+			}
+			if len(tools) > 0 {
+				msg["tool_calls"] = toolCalls(tools)
+			}
+			c["message"] = msg
+		} else {
+			c["response"] = content
+			if thinking != "" {
+				c["thinking"] = thinking
+			}
+		}
+		return c
+	}
+	final := func(c map[string]interface{}) map[string]interface{} {
+		reason := reply.DoneReason
+		if reason == "" {
+			reason = "stop"
+		}
+		c["done"] = true
+		c["done_reason"] = reason
+		c["total_duration"] = 1000000
+		c["load_duration"] = 1000
+		c["prompt_eval_count"] = reply.PromptTokens
+		c["prompt_eval_duration"] = 1000
+		c["eval_count"] = reply.CompletionTokens
+		c["eval_duration"] = 1000
+		return c
+	}
+
+	if stream, ok := body["stream"].(bool); ok && !stream {
+		time.Sleep(reply.Delay)
+		if reply.Fault == Disconnect {
+			hangUp(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, final(chunk(reply.Content, reply.Thinking, reply.ToolCalls)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	flush := func() {
+		if fl, ok := w.(http.Flusher); ok {
+			fl.Flush()
+		}
+	}
+	var chunks []map[string]interface{}
+	if reply.Thinking != "" {
+		chunks = append(chunks, chunk("", reply.Thinking, nil))
+	}
+	parts := reply.Chunks
+	if parts == nil && reply.Content != "" {
+		parts = strings.SplitAfter(reply.Content, " ")
+	}
+	for _, p := range parts {
+		chunks = append(chunks, chunk(p, "", nil))
+	}
+	if len(reply.ToolCalls) > 0 {
+		chunks = append(chunks, chunk("", "", reply.ToolCalls))
+	}
+	chunks = append(chunks, final(chunk("", "", nil)))
+
+	for i, c := range chunks {
+		if reply.Fault != NoFault && i == reply.FaultAfter {
+			switch reply.Fault {
+			case Disconnect:
+				hangUp(w)
+			case MalformedLine:
+				io.WriteString(w, "{\"model\":\"broken\n")
+				flush()
+			case ErrorLine:
+				enc.Encode(map[string]interface{}{"error": reply.Error})
+				flush()
+			}
+			return
+		}
+		time.Sleep(reply.Delay)
+		enc.Encode(c)
+		flush()
+	}
+}
+
+// hangUp closes the connection under w without finishing the response.
+func hangUp(w http.ResponseWriter) {
+	if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+		conn.Close()
+	}
+}
+
+func toolCalls(tools []ToolCall) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(tools))
+	for i, tc := range tools {
+		args := tc.Arguments
+		if args == nil {
+			args = map[string]interface{}{}
+		}
+		out = append(out, map[string]interface{}{
+			"function": map[string]interface{}{"index": i, "name": tc.Name, "arguments": args},
+		})
+	}
+	return out
+}
+
+func inputs(v interface{}) []string {
+	switch in := v.(type) {
+	case string:
+		return []string{in}
+	case []interface{}:
+		out := make([]string, 0, len(in))
+		for _, s := range in {
+			out = append(out, fmt.Sprint(s))
+		}
+		return out
+	}
+	return nil
+}
+
+// Embedding is the deterministic 4-dimensional vector the fake returns for text.
+func Embedding(text string) []float64 {
+	h := fnv.New64a()
+	h.Write([]byte(text))
+	sum := h.Sum64()
+	vec := make([]float64, 4)
+	for i := range vec {
+		vec[i] = float64(sum>>(16*i)&0xffff) / 0xffff
+	}
+	return vec
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
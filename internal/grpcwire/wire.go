@@ -0,0 +1,222 @@
+// Package grpcwire implements the small part of the gRPC and protobuf wire
+// formats the proxy's gRPC API needs (proto/inference.proto), so it can be
+// served from net/http's HTTP/2 support without generated code or
+// third-party modules.
+package grpcwire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// gRPC status codes used by the proxy.
+const (
+	OK                 = 0
+	Canceled           = 1
+	Unknown            = 2
+	InvalidArgument    = 3
+	DeadlineExceeded   = 4
+	NotFound           = 5
+	ResourceExhausted  = 8
+	FailedPrecondition = 9
+	Unimplemented      = 12
+	Internal           = 13
+	Unavailable        = 14
+	Unauthenticated    = 16
+)
+
+// maxMessage bounds one request message (embedding batches are the largest).
+const maxMessage = 64 << 20
+
+// Encoder appends protobuf fields to a message. Zero values are skipped, as
+// proto3 does.
+type Encoder struct {
+	buf []byte
+}
+
+func (e *Encoder) tag(field, wireType int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field)<<3|uint64(wireType))
+}
+
+// String writes a string field.
+func (e *Encoder) String(field int, s string) {
+	if s == "" {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// Bytes writes a bytes or embedded message field (written even when empty,
+// so repeated messages keep their position).
+func (e *Encoder) Bytes(field int, b []byte) {
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// Int writes an int32/int64 field.
+func (e *Encoder) Int(field int, v int64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.buf = binary.AppendUvarint(e.buf, uint64(v))
+}
+
+// Bool writes a bool field.
+func (e *Encoder) Bool(field int, v bool) {
+	if v {
+		e.Int(field, 1)
+	}
+}
+
+// Floats writes a packed repeated float field.
+func (e *Encoder) Floats(field int, vs []float32) {
+	if len(vs) == 0 {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(4*len(vs)))
+	for _, v := range vs {
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, math.Float32bits(v))
+	}
+}
+
+// Marshal returns the encoded message.
+func (e *Encoder) Marshal() []byte { return e.buf }
+
+// Field is one decoded protobuf field.
+type Field struct {
+	Num      int
+	WireType int
+	Varint   uint64
+	Data     []byte // length-delimited payload
+}
+
+// String returns a length-delimited field as a string.
+func (f Field) String() string { return string(f.Data) }
+
+// Decode walks the fields of msg, calling fn for each one.
+func Decode(msg []byte, fn func(Field) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errors.New("malformed field tag")
+		}
+		msg = msg[n:]
+		f := Field{Num: int(key >> 3), WireType: int(key & 7)}
+		switch f.WireType {
+		case wireVarint:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return errors.New("malformed varint")
+			}
+			f.Varint, msg = v, msg[n:]
+		case wireBytes:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return errors.New("truncated length-delimited field")
+			}
+			f.Data, msg = msg[n:n+int(l)], msg[n+int(l):]
+		case wireFixed64:
+			if len(msg) < 8 {
+				return errors.New("truncated fixed64 field")
+			}
+			f.Varint, msg = binary.LittleEndian.Uint64(msg), msg[8:]
+		case wireFixed32:
+			if len(msg) < 4 {
+				return errors.New("truncated fixed32 field")
+			}
+			f.Varint, msg = uint64(binary.LittleEndian.Uint32(msg)), msg[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d", f.WireType)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadMessage reads one length-prefixed gRPC message.
+func ReadMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > maxMessage {
+		return nil, fmt.Errorf("message of %d bytes exceeds the %d byte limit", n, maxMessage)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// WriteMessage writes msg with the gRPC length prefix.
+func WriteMessage(w io.Writer, msg []byte) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	_, err := w.Write(append(frame, msg...))
+	return err
+}
+
+// EncodeStatusMessage percent-encodes a grpc-message trailer value.
+func EncodeStatusMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// CodeForHTTPStatus maps a proxy HTTP status to the closest gRPC code.
+func CodeForHTTPStatus(status int) int {
+	switch {
+	case status < 400:
+		return OK
+	case status == 400 || status == 413 || status == 422:
+		return InvalidArgument
+	case status == 401 || status == 403:
+		return Unauthenticated
+	case status == 404:
+		return NotFound
+	case status == 409:
+		return FailedPrecondition
+	case status == 429:
+		return ResourceExhausted
+	case status == 501:
+		return Unimplemented
+	case status == 502 || status == 503:
+		return Unavailable
+	case status == 504:
+		return DeadlineExceeded
+	case status < 500:
+		return InvalidArgument
+	}
+	return Internal
+}
@@ -22,6 +22,11 @@ type ProgressUpdater interface {
 	UpdateProgress(status string, completed, total int64, modelName string)
 }
 
+// retryRecorder is implemented by progress updaters that count retries.
+type retryRecorder interface {
+	RecordRetry(modelName string)
+}
+
 // Downloader downloads GGUF files from Hugging Face with resume support.
 type Downloader struct {
 	Endpoint  string // e.g. "https://huggingface.co"
@@ -53,6 +58,13 @@ func New(endpoint, repo, file, token, outputDir string) *Downloader {
 	}
 }
 
+// SetProxy routes the downloader's requests through proxyURL (nil = direct).
+func (d *Downloader) SetProxy(proxyURL *url.URL) {
+	if t, ok := d.client.Transport.(*http.Transport); ok {
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+}
+
 // DestPath returns the final path of the downloaded GGUF file.
 func (d *Downloader) DestPath() string {
 	return filepath.Join(d.OutputDir, d.File)
@@ -249,6 +261,9 @@ func (d *Downloader) Download(ctx context.Context, modelName string, progress Pr
 		case <-ctx.Done():
 			return ctx.Err()
 		}
+		if r, ok := progress.(retryRecorder); ok {
+			r.RecordRetry(modelName)
+		}
 	}
 
 	return lastErr
@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	dedupMaxKeys   = 1000 // distinct messages tracked at once; beyond, lines pass unchanged
+	timestampBytes = len("2006/01/02 15:04:05 ")
+)
+
+// dedup collapses repeated error lines: while the upstream is down every
+// request logs the same "!!!" lines. The first one in a window is written;
+// the repeats are only counted, and one summary line ("... (x123 in last
+// 60s)") follows when the window ends. Lines differing only in numbers
+// (durations, byte counts, ports) count as the same. At debug level every
+// line is written.
+var dedup = &repeatFilter{window: time.Minute, seen: make(map[string]*repeat)}
+
+type repeatFilter struct {
+	mu     sync.Mutex
+	window time.Duration // 0 = off
+	seen   map[string]*repeat
+}
+
+type repeat struct {
+	line  []byte // first occurrence, without the timestamp
+	count int    // suppressed repeats
+}
+
+// SetDedupWindow changes the window repeated error lines are collapsed in;
+// 0 writes every line.
+func SetDedupWindow(d time.Duration) {
+	dedup.mu.Lock()
+	dedup.window = d
+	dedup.mu.Unlock()
+}
+
+// suppress reports whether line repeats one written in the current window.
+func (rf *repeatFilter) suppress(line []byte) bool {
+	msg := line
+	if len(msg) > timestampBytes {
+		msg = msg[timestampBytes:]
+	}
+	key := string(bytes.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return '#'
+		}
+		return r
+	}, msg))
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.window <= 0 {
+		return false
+	}
+	if r, ok := rf.seen[key]; ok {
+		r.count++
+		return true
+	}
+	if len(rf.seen) >= dedupMaxKeys {
+		return false
+	}
+	rf.seen[key] = &repeat{line: append([]byte(nil), msg...)}
+	window := rf.window
+	time.AfterFunc(window, func() { rf.flush(key, window) })
+	return false
+}
+
+// flush ends the window of key, writing the summary of its repeats.
+func (rf *repeatFilter) flush(key string, window time.Duration) {
+	rf.mu.Lock()
+	r := rf.seen[key]
+	delete(rf.seen, key)
+	rf.mu.Unlock()
+	if r == nil || r.count == 0 {
+		return
+	}
+	summary := append([]byte(time.Now().Format("2006/01/02 15:04:05 ")), bytes.TrimRight(r.line, "\n")...)
+	summary = append(summary, " (x"+strconv.Itoa(r.count)+" in last "+strconv.Itoa(int(window.Seconds()))+"s)\n"...)
+	os.Stderr.Write(summary)
+}
@@ -0,0 +1,125 @@
+// Package logging adds a runtime-adjustable level to the standard logger.
+// Log calls across the proxy are plain log.Printf; the level of each line
+// is inferred from the markers the code base already uses: ">>>" lines are
+// per-request debug traces, "!!!" / "WARNING" / error lines are warnings,
+// everything else is info.
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is a log verbosity threshold.
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+)
+
+var levelNames = map[Level]string{Debug: "debug", Info: "info", Warn: "warn"}
+
+func (l Level) String() string { return levelNames[l] }
+
+// ParseLevel parses "debug", "info" or "warn" (case-insensitive).
+func ParseLevel(s string) (Level, error) {
+	for l, name := range levelNames {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return l, nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level %q (want debug, info or warn)", s)
+}
+
+var (
+	mu     sync.Mutex
+	level  = Info
+	base   = Info    // level to return to when a temporary level expires
+	until  time.Time // expiry of the temporary level (zero = permanent)
+	revert *time.Timer
+)
+
+// Install routes the standard logger through the level filter at level l.
+func Install(l Level) {
+	mu.Lock()
+	level, base = l, l
+	mu.Unlock()
+	log.SetOutput(writer{})
+}
+
+// SetLevel changes the level. With d > 0 the change is temporary and the
+// previous permanent level is restored after d; d == 0 makes it permanent.
+func SetLevel(l Level, d time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	if revert != nil {
+		revert.Stop()
+		revert = nil
+	}
+	level, until = l, time.Time{}
+	if d <= 0 {
+		base = l
+		return
+	}
+	until = time.Now().Add(d)
+	restore, expires := base, until
+	revert = time.AfterFunc(d, func() {
+		mu.Lock()
+		if !until.Equal(expires) {
+			mu.Unlock() // superseded by a later SetLevel
+			return
+		}
+		level, until, revert = restore, time.Time{}, nil
+		mu.Unlock()
+		log.Printf("Log level restored to %s", restore)
+	})
+}
+
+// Current returns the active level, the level it reverts to, and when (zero if permanent).
+func Current() (active, permanent Level, expires time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+	return level, base, until
+}
+
+// Classify infers the level of a log line from its markers.
+func Classify(line []byte) Level {
+	switch {
+	case bytes.Contains(line, []byte("!!!")):
+		return Warn
+	case bytes.Contains(line, []byte(">>>")):
+		return Debug
+	}
+	lower := bytes.ToLower(line)
+	for _, marker := range []string{"warning", "error", "fail", "invalid"} {
+		if bytes.Contains(lower, []byte(marker)) {
+			return Warn
+		}
+	}
+	return Info
+}
+
+// writer drops lines below the current level and repeats of "!!!" lines
+// (see dedup). The standard logger writes one complete line per call.
+type writer struct{}
+
+func (writer) Write(p []byte) (int, error) {
+	mu.Lock()
+	min := level
+	mu.Unlock()
+	lvl := Classify(p)
+	if lvl < min {
+		return len(p), nil
+	}
+	if min > Debug && bytes.Contains(p, []byte("!!!")) && dedup.suppress(p) {
+		return len(p), nil
+	}
+	return os.Stderr.Write(p)
+}
@@ -0,0 +1,387 @@
+// Package mdns announces and browses one DNS-SD service type over multicast
+// DNS (RFC 6762, RFC 6763) as far as peer discovery needs it: PTR queries
+// for the service, answered with PTR, SRV, TXT and A records. IPv4 only;
+// no probing, conflict resolution or known-answer suppression.
+package mdns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Record types and class.
+const (
+	typeA   = 1
+	typePTR = 12
+	typeTXT = 16
+	typeSRV = 33
+
+	classIN    = 1
+	cacheFlush = 0x8000 // top bit of the class of unique records
+	unicastQU  = 0x8000 // top bit of the class of a question asking for a unicast reply
+)
+
+const ttl = 120
+
+var groupAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Service is what Announce publishes: the instance Instance of the service
+// type Type (e.g. "_olares-ollama._tcp") on Port, with TXT strings.
+type Service struct {
+	Instance string
+	Type     string
+	Port     int
+	TXT      []string
+}
+
+// Entry is an instance found by Browse.
+type Entry struct {
+	Instance string
+	Addr     net.IP
+	Port     int
+	TXT      []string
+}
+
+// Announce answers queries for svc on the mDNS group until ctx is done.
+// Queries from port 5353 are answered on the group, one-shot queries
+// (another source port, or the QU bit) straight to the sender.
+func Announce(ctx context.Context, svc Service) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, groupAddr)
+	if err != nil {
+		return fmt.Errorf("mdns: listen: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	host := label(svc.Instance) + ".local."
+	service := svc.Type + ".local."
+	instance := label(svc.Instance) + "." + service
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("mdns: read: %w", err)
+		}
+		questions, isQuery := parseQuestions(buf[:n])
+		if !isQuery {
+			continue
+		}
+		for _, q := range questions {
+			if q.typ != typePTR || !strings.EqualFold(q.name, service) {
+				continue
+			}
+			resp := answer(binary.BigEndian.Uint16(buf[:2]), service, instance, host, svc)
+			dst := groupAddr
+			if src.Port != groupAddr.Port || q.class&unicastQU != 0 {
+				dst = src
+			}
+			conn.WriteToUDP(resp, dst)
+			break
+		}
+	}
+}
+
+// Browse queries the group for instances of typ (e.g. "_olares-ollama._tcp")
+// and returns those that answer within wait.
+func Browse(ctx context.Context, typ string, wait time.Duration) ([]Entry, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, fmt.Errorf("mdns: listen: %w", err)
+	}
+	defer conn.Close()
+	service := typ + ".local."
+	query := make([]byte, 12, 64)
+	binary.BigEndian.PutUint16(query[4:], 1) // one question
+	query = appendName(query, service)
+	query = binary.BigEndian.AppendUint16(query, typePTR)
+	query = binary.BigEndian.AppendUint16(query, classIN|unicastQU)
+	if _, err := conn.WriteToUDP(query, groupAddr); err != nil {
+		return nil, fmt.Errorf("mdns: query: %w", err)
+	}
+
+	deadline := time.Now().Add(wait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	instances := map[string]bool{}
+	srv := map[string]srvData{}
+	txt := map[string][]string{}
+	addrs := map[string]net.IP{}
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			return nil, fmt.Errorf("mdns: read: %w", err)
+		}
+		for _, rr := range parseRecords(buf[:n]) {
+			switch rr.typ {
+			case typePTR:
+				if strings.EqualFold(rr.name, service) {
+					instances[strings.ToLower(rr.target)] = true
+				}
+			case typeSRV:
+				srv[strings.ToLower(rr.name)] = srvData{port: rr.port, target: strings.ToLower(rr.target)}
+			case typeTXT:
+				txt[strings.ToLower(rr.name)] = rr.txt
+			case typeA:
+				addrs[strings.ToLower(rr.name)] = rr.addr
+			}
+		}
+	}
+
+	var entries []Entry
+	for name := range instances {
+		s, ok := srv[name]
+		if !ok || addrs[s.target] == nil {
+			continue
+		}
+		entries = append(entries, Entry{
+			Instance: strings.TrimSuffix(name, "."+strings.ToLower(service)),
+			Addr:     addrs[s.target],
+			Port:     s.port,
+			TXT:      txt[name],
+		})
+	}
+	return entries, nil
+}
+
+// answer builds the response to a PTR query for the service: the PTR
+// record, then SRV, TXT and an A record per local IPv4 address.
+func answer(id uint16, service, instance, host string, svc Service) []byte {
+	var records [][]byte
+	records = append(records, record(service, typePTR, classIN, appendName(nil, instance)))
+	srv := make([]byte, 6)
+	binary.BigEndian.PutUint16(srv[4:], uint16(svc.Port))
+	records = append(records, record(instance, typeSRV, classIN|cacheFlush, appendName(srv, host)))
+	var txt []byte
+	for _, s := range svc.TXT {
+		if len(s) > 255 {
+			s = s[:255]
+		}
+		txt = append(append(txt, byte(len(s))), s...)
+	}
+	if len(txt) == 0 {
+		txt = []byte{0}
+	}
+	records = append(records, record(instance, typeTXT, classIN|cacheFlush, txt))
+	for _, ip := range localIPv4() {
+		records = append(records, record(host, typeA, classIN|cacheFlush, ip))
+	}
+
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x8400) // response, authoritative
+	binary.BigEndian.PutUint16(msg[6:], 1)      // the PTR answer
+	binary.BigEndian.PutUint16(msg[10:], uint16(len(records)-1))
+	for _, rr := range records {
+		msg = append(msg, rr...)
+	}
+	return msg
+}
+
+func record(name string, typ, class uint16, data []byte) []byte {
+	rr := appendName(nil, name)
+	rr = binary.BigEndian.AppendUint16(rr, typ)
+	rr = binary.BigEndian.AppendUint16(rr, class)
+	rr = binary.BigEndian.AppendUint32(rr, ttl)
+	rr = binary.BigEndian.AppendUint16(rr, uint16(len(data)))
+	return append(rr, data...)
+}
+
+// appendName appends name ("a.b.local.") in wire format, uncompressed.
+func appendName(b []byte, name string) []byte {
+	for _, l := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(l) > 63 {
+			l = l[:63]
+		}
+		b = append(append(b, byte(len(l))), l...)
+	}
+	return append(b, 0)
+}
+
+// label turns an instance name into a single DNS label.
+func label(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '.' || r < ' ' {
+			return '-'
+		}
+		return r
+	}, s)
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return s
+}
+
+func localIPv4() [][]byte {
+	var ips [][]byte
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok {
+				if ip4 := ipn.IP.To4(); ip4 != nil {
+					ips = append(ips, ip4)
+				}
+			}
+		}
+	}
+	return ips
+}
+
+type question struct {
+	name       string
+	typ, class uint16
+}
+
+type srvData struct {
+	port   int
+	target string
+}
+
+type resourceRecord struct {
+	name   string
+	typ    uint16
+	target string // PTR and SRV
+	port   int    // SRV
+	txt    []string
+	addr   net.IP // A
+}
+
+// parseQuestions returns the questions of a query (isQuery false for
+// responses and malformed messages).
+func parseQuestions(msg []byte) (questions []question, isQuery bool) {
+	if len(msg) < 12 || msg[2]&0x80 != 0 {
+		return nil, false
+	}
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[4:])); i++ {
+		name, next, ok := readName(msg, off)
+		if !ok || next+4 > len(msg) {
+			return questions, len(questions) > 0
+		}
+		questions = append(questions, question{
+			name:  name,
+			typ:   binary.BigEndian.Uint16(msg[next:]),
+			class: binary.BigEndian.Uint16(msg[next+2:]),
+		})
+		off = next + 4
+	}
+	return questions, true
+}
+
+// parseRecords returns the answer and additional records of a response.
+func parseRecords(msg []byte) []resourceRecord {
+	if len(msg) < 12 || msg[2]&0x80 == 0 {
+		return nil
+	}
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[4:])); i++ { // skip the questions
+		_, next, ok := readName(msg, off)
+		if !ok {
+			return nil
+		}
+		off = next + 4
+	}
+	count := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	var records []resourceRecord
+	for i := 0; i < count; i++ {
+		name, next, ok := readName(msg, off)
+		if !ok || next+10 > len(msg) {
+			break
+		}
+		typ := binary.BigEndian.Uint16(msg[next:])
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		start, end := next+10, next+10+length
+		if end > len(msg) {
+			break
+		}
+		off = end
+		rr := resourceRecord{name: name, typ: typ}
+		switch typ {
+		case typePTR:
+			rr.target, _, ok = readName(msg, start)
+		case typeSRV:
+			if length < 7 {
+				continue
+			}
+			rr.port = int(binary.BigEndian.Uint16(msg[start+4:]))
+			rr.target, _, ok = readName(msg, start+6)
+		case typeTXT:
+			for p := start; p < end; {
+				l := int(msg[p])
+				if p+1+l > end {
+					break
+				}
+				if l > 0 {
+					rr.txt = append(rr.txt, string(msg[p+1:p+1+l]))
+				}
+				p += 1 + l
+			}
+		case typeA:
+			if length != 4 {
+				continue
+			}
+			rr.addr = net.IP(append([]byte(nil), msg[start:end]...))
+		default:
+			continue
+		}
+		if ok {
+			records = append(records, rr)
+		}
+	}
+	return records
+}
+
+// readName reads a possibly compressed name at off and returns it with a
+// trailing dot, and the offset after it.
+func readName(msg []byte, off int) (name string, next int, ok bool) {
+	var labels []string
+	next = -1
+	for jumps := 0; jumps < 16; {
+		if off >= len(msg) {
+			return "", 0, false
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, true
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, false
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, false
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+	return "", 0, false
+}
@@ -5,21 +5,33 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // Client Ollama client
 type Client struct {
-	baseURL        string
-	httpClient     *http.Client
-	downloadClient *http.Client
+	baseURL           *url.URL
+	httpClient        *http.Client
+	downloadClient    *http.Client
+	httpTransport     *recyclingTransport
+	downloadTransport *recyclingTransport
+	proxy             func(*http.Request) (*url.URL, error) // outbound proxy for per-call transports (nil = direct)
+	firstByteTimeout  time.Duration                         // ResponseHeaderTimeout of the inference transport (0 = none)
+	api               atomic.Pointer[API]                   // request dialect of the upstream release (SetAPI)
+	insecurePull      bool                                  // pull from registries without valid TLS (SetInsecurePull)
+	quietPull         bool                                  // log pull status changes only, no per-percent progress (SetQuietPull)
+	headers           map[string]string                     // added to every ProxyRequest (SetHeader)
+	registry          *http.Client                          // model registries, for PlanPull (SetRegistryProxy)
 }
 
 // NewClient creates a new Ollama client
@@ -29,24 +41,142 @@ func NewClient(baseURL string) *Client {
 
 // NewClientWithTimeout creates a new Ollama client with custom timeout
 func NewClientWithTimeout(baseURL string, downloadTimeoutMinutes int) *Client {
+	c := &Client{baseURL: parseBaseURL(baseURL)}
+	c.httpTransport = newRecyclingTransport("inference", func() *http.Transport {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		if c.proxy != nil {
+			t.Proxy = c.proxy
+		}
+		t.ResponseHeaderTimeout = c.firstByteTimeout
+		return t
+	})
 	// 下载用 Transport：延长空闲连接时间，减少中间层误判断连
-	downloadTransport := &http.Transport{
-		IdleConnTimeout:       5 * time.Minute,
-		ResponseHeaderTimeout: 60 * time.Second,
-		ExpectContinueTimeout: 10 * time.Second,
-	}
-	return &Client{
-		baseURL: strings.TrimSuffix(baseURL, "/"),
-		// Regular request client, 30 minutes timeout for long inference requests
-		httpClient: &http.Client{
-			Timeout: 30 * time.Minute,
-		},
-		// Download dedicated client: long timeout + custom transport
-		downloadClient: &http.Client{
-			Timeout:   time.Duration(downloadTimeoutMinutes) * time.Minute,
-			Transport: downloadTransport,
-		},
+	c.downloadTransport = newRecyclingTransport("download", func() *http.Transport {
+		return &http.Transport{
+			Proxy:                 c.proxy,
+			IdleConnTimeout:       5 * time.Minute,
+			ResponseHeaderTimeout: 60 * time.Second,
+			ExpectContinueTimeout: 10 * time.Second,
+		}
+	})
+	// Regular request client, 30 minutes timeout for long inference requests
+	c.httpClient = &http.Client{
+		Timeout:   30 * time.Minute,
+		Transport: c.httpTransport,
+	}
+	// Download dedicated client: long timeout + custom transport
+	c.downloadClient = &http.Client{
+		Timeout:   time.Duration(downloadTimeoutMinutes) * time.Minute,
+		Transport: c.downloadTransport,
 	}
+	c.registry = &http.Client{Timeout: registryTimeout}
+	return c
+}
+
+// SetOutboundProxy sends all requests to Ollama through proxyURL instead of
+// HTTP_PROXY/HTTPS_PROXY from the environment. Call it before use.
+func (c *Client) SetOutboundProxy(proxyURL *url.URL) {
+	c.proxy = http.ProxyURL(proxyURL)
+	c.httpTransport.recycle("outbound proxy set")
+	c.downloadTransport.recycle("outbound proxy set")
+}
+
+// SetConnMaxAge recycles keep-alive connections to Ollama once they are older
+// than d, so a rescheduled Ollama (same DNS name, new IP) is picked up without
+// a restart. Connections are also recycled after transport errors. 0 disables
+// the age limit.
+func (c *Client) SetConnMaxAge(d time.Duration) {
+	c.httpTransport.setMaxAge(d)
+	c.downloadTransport.setMaxAge(d)
+}
+
+// SetInsecurePull lets pulls use registries served over plain HTTP or with
+// self-signed certificates. Call it before use.
+func (c *Client) SetInsecurePull(insecure bool) {
+	c.insecurePull = insecure
+}
+
+// SetQuietPull limits pull logging to status changes. Call it before use.
+func (c *Client) SetQuietPull(quiet bool) {
+	c.quietPull = quiet
+}
+
+// SetFirstByteTimeout fails inference requests whose response headers take
+// longer than d, instead of waiting on a stalled Ollama for the whole client
+// timeout. Ollama sends the headers of a streaming response with the first
+// token and those of a non-streaming one when generation is done, so d bounds
+// model load plus prompt processing, and the whole of a non-streaming
+// generation. 0 disables the limit.
+func (c *Client) SetFirstByteTimeout(d time.Duration) {
+	c.firstByteTimeout = d
+	c.httpTransport.recycle("first-byte timeout set")
+}
+
+// SetHeader adds a header to every ProxyRequest, e.g. to mark requests
+// between proxies. Call it before use.
+func (c *Client) SetHeader(key, value string) {
+	if c.headers == nil {
+		c.headers = map[string]string{}
+	}
+	c.headers[key] = value
+}
+
+// WithBaseURL returns a client for another Ollama server with the settings
+// of c: timeouts, outbound proxy, connection age and pull options. The
+// request dialect is not shared (that server may run another release); it
+// starts out sending both spellings.
+func (c *Client) WithBaseURL(baseURL string) *Client {
+	d := NewClientWithTimeout(baseURL, int(c.downloadClient.Timeout/time.Minute))
+	d.proxy = c.proxy
+	d.firstByteTimeout = c.firstByteTimeout
+	d.insecurePull = c.insecurePull
+	d.quietPull = c.quietPull
+	c.httpTransport.mu.Lock()
+	maxAge := c.httpTransport.maxAge
+	c.httpTransport.mu.Unlock()
+	d.SetConnMaxAge(maxAge)
+	d.httpTransport.recycle("") // rebuilt with the copied settings
+	d.downloadTransport.recycle("")
+	return d
+}
+
+// BaseURL returns the server address, for logs.
+func (c *Client) BaseURL() string {
+	return c.baseURL.Redacted()
+}
+
+// parseBaseURL parses OLLAMA_URL. The URL may carry a path prefix (Ollama
+// behind a reverse proxy at e.g. https://host/ollama); a missing scheme
+// defaults to http.
+func parseBaseURL(raw string) *url.URL {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		log.Printf("Warning: invalid Ollama URL %q (%v), falling back to http://localhost:11434", raw, err)
+		u = &url.URL{Scheme: "http", Host: "localhost:11434"}
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	u.Fragment = ""
+	return u
+}
+
+// endpoint joins an API path (optionally with a query string) onto the base
+// URL, keeping the base path prefix and any base query parameters.
+func (c *Client) endpoint(apiPath string) string {
+	u := *c.baseURL
+	apiPath, query, _ := strings.Cut(apiPath, "?")
+	u.Path = c.baseURL.Path + "/" + strings.TrimPrefix(apiPath, "/")
+	switch {
+	case u.RawQuery == "":
+		u.RawQuery = query
+	case query != "":
+		u.RawQuery += "&" + query
+	}
+	return u.String()
 }
 
 // WaitForOllama blocks until the Ollama server is reachable or ctx is done.
@@ -57,6 +187,7 @@ func (c *Client) WaitForOllama(ctx context.Context, maxWait time.Duration, inter
 	shortClient := &http.Client{
 		Timeout: 10 * time.Second,
 		Transport: &http.Transport{
+			Proxy: c.proxy,
 			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
 				d := net.Dialer{Timeout: 5 * time.Second}
 				return d.DialContext(ctx, network, addr)
@@ -72,7 +203,7 @@ func (c *Client) WaitForOllama(ctx context.Context, maxWait time.Duration, inter
 			return ctx.Err()
 		default:
 		}
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/tags", nil)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint("/api/tags"), nil)
 		if err != nil {
 			return err
 		}
@@ -104,9 +235,55 @@ type Model struct {
 	Size       int64     `json:"size"`
 }
 
-// PullRequest pull model request
+// RunningModel is a model loaded in memory, as listed by /api/ps
+type RunningModel struct {
+	Name      string    `json:"name"`
+	Model     string    `json:"model"`
+	Size      int64     `json:"size"`
+	SizeVRAM  int64     `json:"size_vram"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PullRequest pull model request. The model goes in Model or, for Ollama
+// releases before 0.3.0, Name (see API.PullRequest).
 type PullRequest struct {
-	Name string `json:"name"`
+	Model    string `json:"model,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Insecure bool   `json:"insecure,omitempty"` // allow registries without TLS or with self-signed certificates
+	Stream   *bool  `json:"stream,omitempty"`   // nil = Ollama's default (streamed progress)
+}
+
+// ShowResponse is the subset of /api/show used by the proxy
+type ShowResponse struct {
+	Modelfile    string                 `json:"modelfile"`
+	Parameters   string                 `json:"parameters"`
+	Template     string                 `json:"template"`
+	Capabilities []string               `json:"capabilities"`
+	ModelInfo    map[string]interface{} `json:"model_info"`
+	Details      map[string]interface{} `json:"details"`
+}
+
+// ContextLength returns the model's context window: num_ctx from the
+// Modelfile parameters if set, else the architecture's trained context
+// length from model_info. 0 if unknown.
+func (r *ShowResponse) ContextLength() int {
+	for _, line := range strings.Split(r.Parameters, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "num_ctx" {
+			var n int
+			if _, err := fmt.Sscanf(fields[1], "%d", &n); err == nil && n > 0 {
+				return n
+			}
+		}
+	}
+	for key, v := range r.ModelInfo {
+		if strings.HasSuffix(key, ".context_length") {
+			if f, ok := v.(float64); ok && f > 0 {
+				return int(f)
+			}
+		}
+	}
+	return 0
 }
 
 // PullResponse pull model response
@@ -117,9 +294,103 @@ type PullResponse struct {
 	Completed int64  `json:"completed,omitempty"`
 }
 
+// Version returns the version reported by /api/version; ctx bounds the call
+// (health probes use a short timeout).
+func (c *Client) Version(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.endpoint("/api/version"), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("/api/version returned %d", resp.StatusCode)
+	}
+	var v struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return "", fmt.Errorf("failed to parse /api/version: %w", err)
+	}
+	if v.Version == "" {
+		return "", fmt.Errorf("/api/version returned no version")
+	}
+	return v.Version, nil
+}
+
+// RunningModels returns the models currently loaded in memory (/api/ps).
+func (c *Client) RunningModels(ctx context.Context) ([]RunningModel, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.endpoint("/api/ps"), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("/api/ps returned %d", resp.StatusCode)
+	}
+	var ps struct {
+		Models []RunningModel `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ps); err != nil {
+		return nil, fmt.Errorf("failed to parse /api/ps: %w", err)
+	}
+	return ps.Models, nil
+}
+
+// ListModels returns the installed models (/api/tags).
+func (c *Client) ListModels(ctx context.Context) ([]Model, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.endpoint("/api/tags"), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("/api/tags returned %d", resp.StatusCode)
+	}
+	var modelResp ModelResponse
+	if err := json.NewDecoder(resp.Body).Decode(&modelResp); err != nil {
+		return nil, fmt.Errorf("failed to parse /api/tags: %w", err)
+	}
+	return modelResp.Models, nil
+}
+
+// SetKeepAlive sends an empty /api/generate for the model, which loads it
+// and sets how long Ollama keeps it in memory: -1 keeps it until unloaded,
+// 0 unloads it now.
+func (c *Client) SetKeepAlive(ctx context.Context, modelName string, keepAlive int) error {
+	body, _ := json.Marshal(map[string]interface{}{"model": modelName, "keep_alive": keepAlive})
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint("/api/generate"), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("/api/generate returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
 // ModelExists checks if model exists
 func (c *Client) ModelExists(modelName string) (bool, error) {
-	resp, err := c.httpClient.Get(c.baseURL + "/api/tags")
+	resp, err := c.httpClient.Get(c.endpoint("/api/tags"))
 	if err != nil {
 		return false, err
 	}
@@ -167,20 +438,38 @@ func (c *Client) ModelExists(modelName string) (bool, error) {
 	return false, nil
 }
 
+// ShowModel returns model metadata from /api/show
+func (c *Client) ShowModel(modelName string) (*ShowResponse, error) {
+	jsonData, err := json.Marshal(c.API().ModelRef(modelName))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Post(c.endpoint("/api/show"), "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("show model %s: %s", modelName, resp.Status)
+	}
+	var show ShowResponse
+	if err := json.NewDecoder(resp.Body).Decode(&show); err != nil {
+		return nil, err
+	}
+	return &show, nil
+}
+
 // ModelUsable checks if model is usable by trying to call it
 // This is a fallback when model exists in files but not in the list
 func (c *Client) ModelUsable(modelName string) (bool, error) {
 	// Try to call /api/show to check if model is usable
-	showReq := map[string]interface{}{
-		"name": modelName,
-	}
-	jsonData, err := json.Marshal(showReq)
+	jsonData, err := json.Marshal(c.API().ModelRef(modelName))
 	if err != nil {
 		return false, err
 	}
 
 	resp, err := c.httpClient.Post(
-		c.baseURL+"/api/show",
+		c.endpoint("/api/show"),
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -208,10 +497,11 @@ func (c *Client) ModelUsable(modelName string) (bool, error) {
 
 	// Use a short timeout for this test
 	testClient := &http.Client{
-		Timeout: 10 * time.Second,
+		Timeout:   10 * time.Second,
+		Transport: c.httpClient.Transport,
 	}
 	resp, err = testClient.Post(
-		c.baseURL+"/api/generate",
+		c.endpoint("/api/generate"),
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -232,7 +522,7 @@ func (c *Client) ModelUsable(modelName string) (bool, error) {
 
 // PullModel downloads model
 func (c *Client) PullModel(modelName string) error {
-	pullReq := PullRequest{Name: modelName}
+	pullReq := c.API().PullRequest(modelName, c.insecurePull)
 	jsonData, err := json.Marshal(pullReq)
 	if err != nil {
 		return err
@@ -240,7 +530,7 @@ func (c *Client) PullModel(modelName string) error {
 
 	// 使用专门的下载客户端，支持长时间下载
 	resp, err := c.downloadClient.Post(
-		c.baseURL+"/api/pull",
+		c.endpoint("/api/pull"),
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -255,6 +545,7 @@ func (c *Client) PullModel(modelName string) error {
 
 	// 读取流式响应
 	decoder := json.NewDecoder(resp.Body)
+	progressLog := c.newPullLogger(modelName)
 	for {
 		var pullResp PullResponse
 		if err := decoder.Decode(&pullResp); err == io.EOF {
@@ -263,15 +554,7 @@ func (c *Client) PullModel(modelName string) error {
 			return err
 		}
 
-		// Progress callback can be added here
-		if pullResp.Status != "" {
-			fmt.Printf("Pull status: %s", pullResp.Status)
-			if pullResp.Total > 0 {
-				progress := float64(pullResp.Completed) / float64(pullResp.Total) * 100
-				fmt.Printf(" (%.1f%%)", progress)
-			}
-			fmt.Println()
-		}
+		progressLog.update(pullResp)
 
 		if pullResp.Status == "success" {
 			break
@@ -288,18 +571,21 @@ type ProgressUpdater interface {
 }
 
 // PullModelWithProgress 下载模型并更新进度
+// Progress is reported over all the model's layers (see pullTotals).
 func (c *Client) PullModelWithProgress(modelName string, progressUpdater ProgressUpdater) error {
-	pullReq := PullRequest{Name: modelName}
+	pullReq := c.API().PullRequest(modelName, c.insecurePull)
 	jsonData, err := json.Marshal(pullReq)
 	if err != nil {
 		return err
 	}
 
-	progressUpdater.UpdateProgress("starting", 0, 0, modelName)
+	totals := c.planPull(modelName)
+	completed, total := totals.sums()
+	progressUpdater.UpdateProgress("starting", completed, total, modelName)
 
 	// 使用专门的下载客户端，支持长时间下载
 	resp, err := c.downloadClient.Post(
-		c.baseURL+"/api/pull",
+		c.endpoint("/api/pull"),
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -320,11 +606,12 @@ func (c *Client) PullModelWithProgress(modelName string, progressUpdater Progres
 		return fmt.Errorf("failed to pull model: %s", resp.Status)
 	}
 
-	progressUpdater.UpdateProgress("downloading", 0, 0, modelName)
+	progressUpdater.UpdateProgress("downloading", completed, total, modelName)
 
 	// 使用较大缓冲读取流，减轻网络抖动带来的 EOF
 	bodyReader := bufio.NewReaderSize(resp.Body, 256*1024)
 	decoder := json.NewDecoder(bodyReader)
+	progressLog := c.newPullLogger(modelName)
 	var lastPullResp PullResponse
 	var gotSuccess bool
 	var successCount int
@@ -344,20 +631,19 @@ func (c *Client) PullModelWithProgress(modelName string, progressUpdater Progres
 			return err
 		}
 
+		var resumed bool
+		pullResp.Completed, pullResp.Total, resumed = totals.observe(pullResp)
 		lastPullResp = pullResp
 
 		// 更新进度
-		progressUpdater.UpdateProgress(pullResp.Status, pullResp.Completed, pullResp.Total, modelName)
+		if ru, ok := progressUpdater.(resumeUpdater); ok && resumed {
+			ru.UpdateResumed(pullResp.Status, pullResp.Completed, pullResp.Total, modelName)
+		} else {
+			progressUpdater.UpdateProgress(pullResp.Status, pullResp.Completed, pullResp.Total, modelName)
+		}
 
 		// 打印控制台进度
-		if pullResp.Status != "" {
-			fmt.Printf("Pull status: %s", pullResp.Status)
-			if pullResp.Total > 0 {
-				progress := float64(pullResp.Completed) / float64(pullResp.Total) * 100
-				fmt.Printf(" (%.1f%%)", progress)
-			}
-			fmt.Println()
-		}
+		progressLog.update(pullResp)
 
 		// 记录 success 状态，但不要立即退出
 		// Ollama 可能会发送多个 success 状态，或者 success 后还有更多数据
@@ -527,7 +813,7 @@ func formatVerifyTotal(initial time.Duration, attempts int) string {
 // BlobExists checks whether a blob with the given digest already exists on the
 // Ollama server (HEAD /api/blobs/:digest).
 func (c *Client) BlobExists(digest string) (bool, error) {
-	req, err := http.NewRequest(http.MethodHead, c.baseURL+"/api/blobs/"+digest, nil)
+	req, err := http.NewRequest(http.MethodHead, c.endpoint("/api/blobs/"+digest), nil)
 	if err != nil {
 		return false, err
 	}
@@ -558,18 +844,31 @@ func (c *Client) PushBlob(digest, filePath string, progressUpdater ProgressUpdat
 	log.Printf("Pushing blob %s (%d bytes / %.2f GiB) to Ollama...", digest, fileSize, float64(fileSize)/(1024*1024*1024))
 	progressUpdater.UpdateProgress("pushing_blob", 0, fileSize, modelName)
 
-	url := c.baseURL + "/api/blobs/" + digest
-	req, err := http.NewRequest(http.MethodPost, url, f)
+	if err := c.PushBlobFrom(digest, f, fileSize); err != nil {
+		progressUpdater.UpdateError(err.Error(), 0, 0, modelName)
+		return err
+	}
+
+	log.Printf("Blob %s pushed successfully", digest)
+	progressUpdater.UpdateProgress("blob_pushed", fileSize, fileSize, modelName)
+	return nil
+}
+
+// PushBlobFrom uploads size bytes from r as a blob (POST /api/blobs/:digest).
+// Ollama checks the digest itself and rejects a blob that doesn't match.
+func (c *Client) PushBlobFrom(digest string, r io.Reader, size int64) error {
+	req, err := http.NewRequest(http.MethodPost, c.endpoint("/api/blobs/"+digest), io.NopCloser(r))
 	if err != nil {
 		return fmt.Errorf("create push request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/octet-stream")
-	req.ContentLength = fileSize
+	req.ContentLength = size
 
 	// Use a client with no overall timeout for large uploads
 	blobClient := &http.Client{
 		Timeout: 0,
 		Transport: &http.Transport{
+			Proxy:                 c.proxy,
 			IdleConnTimeout:       10 * time.Minute,
 			ResponseHeaderTimeout: 10 * time.Minute,
 			ExpectContinueTimeout: 30 * time.Second,
@@ -578,24 +877,18 @@ func (c *Client) PushBlob(digest, filePath string, progressUpdater ProgressUpdat
 
 	resp, err := blobClient.Do(req)
 	if err != nil {
-		progressUpdater.UpdateError(fmt.Sprintf("Pushing blob to Ollama failed: %v", err), 0, 0, modelName)
-		return fmt.Errorf("push blob request: %w", err)
+		return fmt.Errorf("Pushing blob to Ollama failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
-		snippet := strings.TrimSpace(string(body))
 		msg := fmt.Sprintf("Ollama rejected blob upload (HTTP %s)", resp.Status)
-		if snippet != "" {
+		if snippet := strings.TrimSpace(string(body)); snippet != "" {
 			msg += ": " + snippet
 		}
-		progressUpdater.UpdateError(msg, 0, 0, modelName)
-		return fmt.Errorf("push blob failed (HTTP %s): %s", resp.Status, string(body))
+		return errors.New(msg)
 	}
-
-	log.Printf("Blob %s pushed successfully", digest)
-	progressUpdater.UpdateProgress("blob_pushed", fileSize, fileSize, modelName)
 	return nil
 }
 
@@ -607,6 +900,9 @@ type CreateRequest struct {
 	Parameters map[string]interface{} `json:"parameters,omitempty"`
 	Template   string                 `json:"template,omitempty"`
 	System     string                 `json:"system,omitempty"`
+	Adapters   map[string]string      `json:"adapters,omitempty"` // LoRA adapters, name -> blob digest
+	License    string                 `json:"license,omitempty"`
+	Messages   json.RawMessage        `json:"messages,omitempty"` // example conversation
 }
 
 // CreateResponse represents a streamed response from ollama create.
@@ -684,6 +980,12 @@ func (c *Client) createGGUFWithTemplate(modelName string, files map[string]strin
 	return nil
 }
 
+// CreateModel runs POST /api/create for req, whose blobs must already be
+// on the Ollama server, reporting progress under req.Model.
+func (c *Client) CreateModel(req CreateRequest, progressUpdater ProgressUpdater) error {
+	return c.doCreate(req, req.Model, progressUpdater)
+}
+
 // doCreate sends a POST /api/create request and streams the response until
 // "success" or an error occurs.
 func (c *Client) doCreate(req interface{}, progressModel string, progressUpdater ProgressUpdater) error {
@@ -694,7 +996,7 @@ func (c *Client) doCreate(req interface{}, progressModel string, progressUpdater
 	progressUpdater.UpdateProgress("creating", 0, 0, progressModel)
 
 	resp, err := c.downloadClient.Post(
-		c.baseURL+"/api/create",
+		c.endpoint("/api/create"),
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -738,8 +1040,8 @@ func (c *Client) doCreate(req interface{}, progressModel string, progressUpdater
 
 // deleteModel sends DELETE /api/delete to remove a model (best-effort).
 func (c *Client) deleteModel(modelName string) {
-	reqBody, _ := json.Marshal(map[string]string{"model": modelName})
-	req, err := http.NewRequest("DELETE", c.baseURL+"/api/delete", bytes.NewBuffer(reqBody))
+	reqBody, _ := json.Marshal(c.API().ModelRef(modelName))
+	req, err := http.NewRequest("DELETE", c.endpoint("/api/delete"), bytes.NewBuffer(reqBody))
 	if err != nil {
 		log.Printf("Warning: failed to build delete request for %s: %v", modelName, err)
 		return
@@ -758,10 +1060,34 @@ func (c *Client) deleteModel(modelName string) {
 	}
 }
 
+// DeleteModel removes a model from Ollama (DELETE /api/delete).
+func (c *Client) DeleteModel(ctx context.Context, modelName string) error {
+	reqBody, _ := json.Marshal(c.API().ModelRef(modelName))
+	req, err := http.NewRequestWithContext(ctx, "DELETE", c.endpoint("/api/delete"), bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("/api/delete returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
 // ProxyRequest 代理请求到Ollama
 func (c *Client) ProxyRequest(method, path string, body io.Reader, headers map[string]string) (*http.Response, error) {
-	url := c.baseURL + path
-	req, err := http.NewRequest(method, url, body)
+	return c.ProxyRequestContext(context.Background(), method, path, body, headers)
+}
+
+// ProxyRequestContext is ProxyRequest bounded by ctx.
+func (c *Client) ProxyRequestContext(ctx context.Context, method, path string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint(path), body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -774,6 +1100,9 @@ func (c *Client) ProxyRequest(method, path string, body io.Reader, headers map[s
 		}
 		req.Header.Set(key, value)
 	}
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
 
 	// 确保请求方法正确
 	if req.Method != method {
@@ -0,0 +1,71 @@
+package ollama
+
+// API is the request dialect of the Ollama release the client talks to.
+// Ollama renamed some request fields over time; the server picks the API
+// from the detected version (SetAPI) so the proxy keeps working across
+// Ollama upgrades and downgrades.
+type API struct {
+	Version  string `json:"version,omitempty"` // Ollama version the dialect was chosen for; "" = unknown
+	ModelKey string `json:"model_key"`         // field naming the model in pull / show / delete requests: "model", "name" (older releases) or "" = send both
+}
+
+// SetAPI switches the dialect used for requests sent from now on.
+func (c *Client) SetAPI(api API) {
+	c.api.Store(&api)
+}
+
+// API returns the dialect in use (both spellings until SetAPI was called).
+func (c *Client) API() API {
+	if api := c.api.Load(); api != nil {
+		return *api
+	}
+	return API{}
+}
+
+// ModelRef returns the request fields naming modelName, as the upstream
+// expects them.
+func (a API) ModelRef(modelName string) map[string]interface{} {
+	if a.ModelKey == "" {
+		return map[string]interface{}{"model": modelName, "name": modelName}
+	}
+	return map[string]interface{}{a.ModelKey: modelName}
+}
+
+// PullRequest returns the /api/pull request for modelName. Progress is
+// always streamed: the client reads it as it comes.
+func (a API) PullRequest(modelName string, insecure bool) PullRequest {
+	stream := true
+	req := PullRequest{Insecure: insecure, Stream: &stream}
+	switch a.ModelKey {
+	case "model":
+		req.Model = modelName
+	case "name":
+		req.Name = modelName
+	default:
+		req.Model, req.Name = modelName, modelName
+	}
+	return req
+}
+
+// AdaptModelKey rewrites a client's request body to the upstream's field
+// for the model name, so a client written for another Ollama release works
+// too. Bodies naming the model both ways are left alone.
+func (a API) AdaptModelKey(req map[string]interface{}) {
+	model, hasModel := req["model"]
+	name, hasName := req["name"]
+	switch {
+	case hasModel == hasName:
+	case a.ModelKey == "":
+		if hasModel {
+			req["name"] = model
+		} else {
+			req["model"] = name
+		}
+	case a.ModelKey == "name" && hasModel:
+		req["name"] = model
+		delete(req, "model")
+	case a.ModelKey == "model" && hasName:
+		req["model"] = name
+		delete(req, "name")
+	}
+}
@@ -0,0 +1,42 @@
+package ollama
+
+import "log"
+
+// pullLogger logs the progress of one /api/pull stream as key=value lines:
+// one per status change, and for a layer being downloaded at most one per
+// whole percent, instead of one per streamed update (thousands per pull).
+// Quiet keeps only the status changes.
+type pullLogger struct {
+	model   string
+	quiet   bool
+	status  string
+	percent int64
+}
+
+func (c *Client) newPullLogger(modelName string) *pullLogger {
+	return &pullLogger{model: modelName, quiet: c.quietPull, percent: -1}
+}
+
+// update logs resp when it says something new.
+func (pl *pullLogger) update(resp PullResponse) {
+	if resp.Status == "" {
+		return
+	}
+	if resp.Status != pl.status {
+		pl.status, pl.percent = resp.Status, -1
+		if resp.Total <= 0 || pl.quiet {
+			log.Printf("Pull progress: model=%s status=%q", pl.model, resp.Status)
+			return
+		}
+	}
+	if resp.Total <= 0 || pl.quiet {
+		return
+	}
+	percent := resp.Completed * 100 / resp.Total
+	if percent == pl.percent {
+		return
+	}
+	pl.percent = percent
+	log.Printf("Pull progress: model=%s status=%q completed=%d total=%d percent=%d",
+		pl.model, resp.Status, resp.Completed, resp.Total, percent)
+}
@@ -0,0 +1,140 @@
+package ollama
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const registryTimeout = 30 * time.Second // one registry manifest request
+
+// PullPlan is what pulling a model fetches: the layers of its registry
+// manifest, and which of them Ollama has in full already.
+type PullPlan struct {
+	Layers []Layer
+	Have   map[string]bool // by digest
+}
+
+// Total is the size of all the model's layers, in bytes.
+func (p *PullPlan) Total() int64 {
+	var n int64
+	for _, l := range p.Layers {
+		n += l.Size
+	}
+	return n
+}
+
+// Present is the size of the layers Ollama has.
+func (p *PullPlan) Present() int64 {
+	var n int64
+	for _, l := range p.Layers {
+		if p.Have[l.Digest] {
+			n += l.Size
+		}
+	}
+	return n
+}
+
+// Missing is what the pull downloads, at most: blobs Ollama has part of
+// show up as partly completed once the pull reaches them.
+func (p *PullPlan) Missing() int64 {
+	return p.Total() - p.Present()
+}
+
+// SetRegistryProxy sends the registry requests of PlanPull through
+// proxyURL. Call it before use.
+func (c *Client) SetRegistryProxy(proxyURL *url.URL) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	c.registry = &http.Client{Timeout: registryTimeout, Transport: transport}
+}
+
+// PlanPull reads the model's manifest from its registry, as a pull does
+// first, and asks Ollama which of its blobs it has.
+func (c *Client) PlanPull(ctx context.Context, name string) (*PullPlan, error) {
+	manifest, err := RegistryManifest(ctx, c.registry, name, c.insecurePull)
+	if err != nil {
+		return nil, err
+	}
+	plan := &PullPlan{Layers: manifest.Blobs(), Have: make(map[string]bool)}
+	for _, l := range plan.Layers {
+		if exists, err := c.BlobExists(l.Digest); err == nil && exists {
+			plan.Have[l.Digest] = true
+		}
+	}
+	return plan, nil
+}
+
+// pullTotals turns the per-layer progress of a pull stream ("pulling
+// <digest>" with that layer's completed and total) into the model's: the
+// sum over its layers. With a plan all layers count from the start, those
+// Ollama has as completed, so a resumed pull starts where the last one
+// stopped instead of at the first layer's 0%. Without one, layers count
+// once the stream mentions them.
+type pullTotals struct {
+	total     map[string]int64
+	completed map[string]int64
+	streamed  map[string]bool // layers the stream reported on
+}
+
+func newPullTotals(plan *PullPlan) *pullTotals {
+	pt := &pullTotals{total: make(map[string]int64), completed: make(map[string]int64), streamed: make(map[string]bool)}
+	if plan != nil {
+		for _, l := range plan.Layers {
+			pt.total[l.Digest] = l.Size
+			if plan.Have[l.Digest] {
+				pt.completed[l.Digest] = l.Size
+			}
+		}
+	}
+	return pt
+}
+
+// resumeUpdater is a ProgressUpdater that tells the bytes a pull finds on
+// disk from those it downloads (download.ProgressManager, for its speed).
+type resumeUpdater interface {
+	UpdateResumed(status string, completed, total int64, modelName string)
+}
+
+// observe records resp and returns the model's completed and total bytes;
+// a response of no layer (pulling manifest, verifying...) keeps them.
+// resumed is set when the layer's bytes grew by what Ollama had on disk of
+// it: the first progress of a layer is that, not a download.
+func (pt *pullTotals) observe(resp PullResponse) (completed, total int64, resumed bool) {
+	if resp.Digest != "" && resp.Total > 0 {
+		resumed = !pt.streamed[resp.Digest] && resp.Completed > pt.completed[resp.Digest]
+		pt.total[resp.Digest] = resp.Total
+		pt.completed[resp.Digest] = resp.Completed
+		pt.streamed[resp.Digest] = true
+	}
+	if len(pt.total) == 0 {
+		return resp.Completed, resp.Total, false
+	}
+	completed, total = pt.sums()
+	return completed, total, resumed
+}
+
+func (pt *pullTotals) sums() (completed, total int64) {
+	for d, n := range pt.total {
+		total += n
+		completed += min(pt.completed[d], n)
+	}
+	return completed, total
+}
+
+// planPull is PlanPull for a pull about to start; without a plan (offline
+// registry, a model created locally) the pull counts layers as they come.
+func (c *Client) planPull(name string) *pullTotals {
+	ctx, cancel := context.WithTimeout(context.Background(), registryTimeout)
+	defer cancel()
+	plan, err := c.PlanPull(ctx, name)
+	if err != nil {
+		return newPullTotals(nil)
+	}
+	if present := plan.Present(); present > 0 {
+		log.Printf("Pull of %s: %d of %d bytes already in Ollama", name, present, plan.Total())
+	}
+	return newPullTotals(plan)
+}
@@ -0,0 +1,71 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrNotInRegistry is returned by RegistryManifest for models their
+// registry doesn't know, such as models created locally.
+var ErrNotInRegistry = errors.New("not in the registry")
+
+// RegistryManifest fetches the current manifest of a model from its
+// registry (registry.ollama.ai unless the name has one), as ollama pull
+// does first. insecure talks plain HTTP, for PULL_INSECURE registries.
+func RegistryManifest(ctx context.Context, client *http.Client, name string, insecure bool) (*Manifest, error) {
+	parts, err := splitModelName(name)
+	if err != nil {
+		return nil, err
+	}
+	scheme := "https"
+	if insecure {
+		scheme = "http"
+	}
+	url := fmt.Sprintf("%s://%s/v2/%s/%s/manifests/%s", scheme, parts[0], parts[1], parts[2], parts[3])
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusUnauthorized:
+		return nil, ErrNotInRegistry
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s returned %s: %s", parts[0], resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var m Manifest
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&m); err != nil {
+		return nil, fmt.Errorf("parse manifest of %s: %w", name, err)
+	}
+	return &m, nil
+}
+
+// SameBlobs reports whether two manifests are made of the same blobs.
+func (m *Manifest) SameBlobs(o *Manifest) bool {
+	a, b := m.Blobs(), o.Blobs()
+	if len(a) != len(b) {
+		return false
+	}
+	digests := make(map[string]bool, len(a))
+	for _, l := range a {
+		digests[l.Digest] = true
+	}
+	for _, l := range b {
+		if !digests[l.Digest] {
+			return false
+		}
+	}
+	return true
+}
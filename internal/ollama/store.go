@@ -0,0 +1,125 @@
+package ollama
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Ollama's defaults for the parts of a model name that are left out.
+const (
+	defaultRegistry  = "registry.ollama.ai"
+	defaultNamespace = "library"
+	defaultTag       = "latest"
+)
+
+// Layer is one blob of a model manifest.
+type Layer struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// Manifest is an Ollama model manifest: a config blob and the layers
+// (weights, template, parameters, ...), by digest.
+type Manifest struct {
+	SchemaVersion int     `json:"schemaVersion"`
+	MediaType     string  `json:"mediaType"`
+	Config        Layer   `json:"config"`
+	Layers        []Layer `json:"layers"`
+}
+
+// Blobs returns the config and the layers, each digest once.
+func (m *Manifest) Blobs() []Layer {
+	seen := make(map[string]bool)
+	var out []Layer
+	for _, l := range append([]Layer{m.Config}, m.Layers...) {
+		if l.Digest != "" && !seen[l.Digest] {
+			seen[l.Digest] = true
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// ModelStore reads Ollama's model directory (OLLAMA_MODELS on the Ollama
+// side) directly. The HTTP API has no way to download a blob, so this is
+// how the proxy gets at model files, from a read-only mount of the directory.
+type ModelStore struct {
+	dir string
+}
+
+// NewModelStore returns a store for dir, which must contain Ollama's
+// manifests/ and blobs/ directories.
+func NewModelStore(dir string) (*ModelStore, error) {
+	for _, sub := range []string{"manifests", "blobs"} {
+		if info, err := os.Stat(filepath.Join(dir, sub)); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("%s is not an Ollama models directory (no %s/)", dir, sub)
+		}
+	}
+	return &ModelStore{dir: dir}, nil
+}
+
+// manifestPath maps "[registry/][namespace/]model[:tag]" to its manifest file.
+func (st *ModelStore) manifestPath(name string) (string, error) {
+	parts, err := splitModelName(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{st.dir, "manifests"}, parts...)...), nil
+}
+
+// splitModelName splits "[registry/][namespace/]model[:tag]" into registry,
+// namespace, model and tag, filling in Ollama's defaults.
+func splitModelName(name string) ([]string, error) {
+	tag := defaultTag
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	parts := strings.Split(name, "/")
+	switch len(parts) {
+	case 1:
+		parts = []string{defaultRegistry, defaultNamespace, parts[0]}
+	case 2:
+		parts = []string{defaultRegistry, parts[0], parts[1]}
+	case 3:
+	default:
+		return nil, fmt.Errorf("invalid model name %q", name)
+	}
+	parts = append(parts, tag)
+	for _, p := range parts {
+		if p == "" || p == "." || p == ".." || strings.ContainsAny(p, `\`) {
+			return nil, fmt.Errorf("invalid model name %q", name)
+		}
+	}
+	return parts, nil
+}
+
+// Manifest returns the model's manifest and its raw bytes. The error
+// satisfies os.IsNotExist when the model isn't in the store.
+func (st *ModelStore) Manifest(name string) (*Manifest, []byte, error) {
+	path, err := st.manifestPath(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, nil, fmt.Errorf("parse manifest of %s: %w", name, err)
+	}
+	return &m, data, nil
+}
+
+// BlobPath returns the file of a blob ("sha256:<hex>" -> blobs/sha256-<hex>).
+func (st *ModelStore) BlobPath(digest string) (string, error) {
+	hex, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || len(hex) != 64 || strings.Trim(hex, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("invalid blob digest %q", digest)
+	}
+	return filepath.Join(st.dir, "blobs", "sha256-"+hex), nil
+}
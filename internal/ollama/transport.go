@@ -0,0 +1,85 @@
+package ollama
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// recyclingTransport replaces its underlying http.Transport once it is older
+// than maxAge, and right away after a connection-level error. Keep-alive
+// connections otherwise stay pinned to the address Ollama had when they were
+// dialed; when the Ollama pod is rescheduled (new IP behind the same service
+// name) a fresh transport re-resolves DNS on its next dial. In-flight requests
+// on the retired transport finish normally; its idle connections are closed.
+type recyclingTransport struct {
+	mu           sync.Mutex
+	newTransport func() *http.Transport
+	current      *http.Transport
+	born         time.Time
+	maxAge       time.Duration // 0 = recycle only after errors
+	name         string
+}
+
+func newRecyclingTransport(name string, newTransport func() *http.Transport) *recyclingTransport {
+	return &recyclingTransport{
+		name:         name,
+		newTransport: newTransport,
+		current:      newTransport(),
+		born:         time.Now(),
+	}
+}
+
+func (rt *recyclingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.mu.Lock()
+	if rt.maxAge > 0 && time.Since(rt.born) > rt.maxAge {
+		rt.recycleLocked("")
+	}
+	t := rt.current
+	rt.mu.Unlock()
+
+	resp, err := t.RoundTrip(req)
+	if err != nil && req.Context().Err() == nil {
+		// The client didn't cancel: the connection or address is likely stale.
+		rt.mu.Lock()
+		// An unreachable Ollama fails every request; one swap per second is enough.
+		if rt.current == t && time.Since(rt.born) > time.Second {
+			rt.recycleLocked("transport error: " + err.Error())
+		}
+		rt.mu.Unlock()
+	}
+	return resp, err
+}
+
+// recycle swaps in a fresh transport (used when its settings change).
+func (rt *recyclingTransport) recycle(reason string) {
+	rt.mu.Lock()
+	rt.recycleLocked(reason)
+	rt.mu.Unlock()
+}
+
+// recycleLocked swaps transports; reason is logged unless empty (routine age-based swaps).
+func (rt *recyclingTransport) recycleLocked(reason string) {
+	old := rt.current
+	rt.current = rt.newTransport()
+	rt.born = time.Now()
+	old.CloseIdleConnections()
+	if reason != "" {
+		log.Printf("Recycled %s connections to Ollama (%s)", rt.name, reason)
+	}
+}
+
+func (rt *recyclingTransport) setMaxAge(d time.Duration) {
+	rt.mu.Lock()
+	rt.maxAge = d
+	rt.mu.Unlock()
+}
+
+// CloseIdleConnections lets http.Client.CloseIdleConnections reach the current transport.
+func (rt *recyclingTransport) CloseIdleConnections() {
+	rt.mu.Lock()
+	t := rt.current
+	rt.mu.Unlock()
+	t.CloseIdleConnections()
+}
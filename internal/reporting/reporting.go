@@ -0,0 +1,184 @@
+// Package reporting sends crash and error reports to a Sentry-compatible
+// service (Sentry, GlitchTip, ...) using its envelope HTTP API, so operators
+// of many deployments get aggregated reports without an SDK dependency.
+package reporting
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Reporter delivers events in the background; a nil *Reporter is a no-op.
+type Reporter struct {
+	dsn         string
+	envelopeURL string
+	auth        string
+	environment string
+	release     string
+	serverName  string
+	client      *http.Client
+	queue       chan map[string]interface{}
+}
+
+// New parses dsn ("https://<key>@<host>/<project>") and starts the sender.
+func New(dsn, environment, release string) (*Reporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q (want http or https)", u.Scheme)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("missing public key (expected https://<key>@<host>/<project>)")
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 || path[i+1:] == "" {
+		return nil, fmt.Errorf("missing project id (expected https://<key>@<host>/<project>)")
+	}
+	prefix, project := path[:i], path[i+1:]
+	host, _ := os.Hostname()
+	r := &Reporter{
+		dsn:         dsn,
+		envelopeURL: fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project),
+		auth:        fmt.Sprintf("Sentry sentry_version=7, sentry_client=olares-ollama/%s, sentry_key=%s", release, u.User.Username()),
+		environment: environment,
+		release:     release,
+		serverName:  host,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan map[string]interface{}, 32),
+	}
+	go r.send()
+	return r, nil
+}
+
+// CapturePanic reports a recovered panic with its stack trace.
+func (r *Reporter) CapturePanic(value interface{}, stack []byte, req *http.Request) {
+	if r == nil {
+		return
+	}
+	event := r.event("fatal", req)
+	event["exception"] = map[string]interface{}{
+		"values": []map[string]interface{}{{
+			"type":      "panic",
+			"value":     fmt.Sprint(value),
+			"mechanism": map[string]interface{}{"type": "recover", "handled": false},
+		}},
+	}
+	event["extra"] = map[string]interface{}{"stack": string(stack)}
+	r.enqueue(event)
+}
+
+// CaptureMessage reports a non-crash problem, such as repeated upstream failures.
+// Events with the same fingerprint are grouped into one issue.
+func (r *Reporter) CaptureMessage(level, message string, req *http.Request, tags map[string]string, fingerprint ...string) {
+	if r == nil {
+		return
+	}
+	event := r.event(level, req)
+	event["message"] = map[string]interface{}{"formatted": message}
+	if len(tags) > 0 {
+		event["tags"] = tags
+	}
+	if len(fingerprint) > 0 {
+		event["fingerprint"] = fingerprint
+	}
+	r.enqueue(event)
+}
+
+func (r *Reporter) event(level string, req *http.Request) map[string]interface{} {
+	id := make([]byte, 16)
+	rand.Read(id)
+	event := map[string]interface{}{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   float64(time.Now().UnixNano()) / 1e9,
+		"level":       level,
+		"platform":    "go",
+		"logger":      "olares-ollama",
+		"server_name": r.serverName,
+		"release":     r.release,
+		"environment": r.environment,
+	}
+	if req != nil {
+		headers := map[string]string{}
+		for key, values := range req.Header {
+			if !sensitiveHeader(key) && len(values) > 0 {
+				headers[key] = values[0]
+			}
+		}
+		event["request"] = map[string]interface{}{
+			"method":       req.Method,
+			"url":          req.URL.Path,
+			"query_string": req.URL.RawQuery,
+			"headers":      headers,
+		}
+	}
+	return event
+}
+
+// sensitiveHeader reports headers that must not leave the box.
+func sensitiveHeader(key string) bool {
+	switch strings.ToLower(key) {
+	case "authorization", "cookie", "x-admin-token", "x-api-key", "proxy-authorization":
+		return true
+	}
+	return false
+}
+
+// enqueue hands event to the sender, dropping it when the queue is full
+// (reporting must never block or slow down request handling).
+func (r *Reporter) enqueue(event map[string]interface{}) {
+	select {
+	case r.queue <- event:
+	default:
+		log.Printf("!!! Error report dropped (queue full) !!!")
+	}
+}
+
+func (r *Reporter) send() {
+	for event := range r.queue {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		var body bytes.Buffer
+		header, _ := json.Marshal(map[string]interface{}{
+			"event_id": event["event_id"],
+			"dsn":      r.dsn,
+			"sent_at":  time.Now().UTC().Format(time.RFC3339),
+		})
+		itemHeader, _ := json.Marshal(map[string]interface{}{"type": "event", "length": len(payload)})
+		body.Write(header)
+		body.WriteByte('\n')
+		body.Write(itemHeader)
+		body.WriteByte('\n')
+		body.Write(payload)
+		body.WriteByte('\n')
+
+		req, err := http.NewRequest("POST", r.envelopeURL, &body)
+		if err != nil {
+			continue
+		}
+		req.Header.Set("Content-Type", "application/x-sentry-envelope")
+		req.Header.Set("X-Sentry-Auth", r.auth)
+		resp, err := r.client.Do(req)
+		if err != nil {
+			log.Printf("!!! Failed to send error report: %v !!!", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("!!! Error report rejected with status %d !!!", resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"olares-ollama/internal/huggingface"
+)
+
+// adapterFile is a LoRA adapter in ADAPTERS_DIR.
+type adapterFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Digest   string    `json:"digest,omitempty"`
+	Modified time.Time `json:"modified_at"`
+}
+
+// adapterPath returns the file of a configured adapter. Only GGUF adapters
+// directly in ADAPTERS_DIR are accepted: Ollama's create API takes safetensors
+// adapters as several files, which the proxy doesn't upload.
+func (s *Server) adapterPath(name string) (string, error) {
+	if s.config.AdaptersDir == "" {
+		return "", fmt.Errorf("ADAPTERS_DIR is not set")
+	}
+	if name == "" || filepath.Base(name) != name || !strings.HasSuffix(name, ".gguf") {
+		return "", fmt.Errorf("adapter %q must be the name of a .gguf file in ADAPTERS_DIR", name)
+	}
+	path := filepath.Join(s.config.AdaptersDir, name)
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return "", fmt.Errorf("adapter %q not found in ADAPTERS_DIR", name)
+	}
+	return path, nil
+}
+
+// listAdapters returns the GGUF adapters in ADAPTERS_DIR. Digests are
+// computed once per file and cached next to it (<name>.sha256).
+func (s *Server) listAdapters() ([]adapterFile, error) {
+	entries, err := os.ReadDir(s.config.AdaptersDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []adapterFile
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".gguf") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		a := adapterFile{Name: e.Name(), Size: info.Size(), Modified: info.ModTime()}
+		if digest, err := huggingface.ComputeSHA256(filepath.Join(s.config.AdaptersDir, e.Name())); err == nil {
+			a.Digest = digest
+		} else {
+			log.Printf("Warning: hashing adapter %s failed: %v", e.Name(), err)
+		}
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// pushAdapters uploads the named adapters to Ollama (unless it has them
+// already) and returns them as the create request's adapters map.
+func (s *Server) pushAdapters(names []string, model string) (map[string]string, error) {
+	adapters := make(map[string]string, len(names))
+	for _, name := range names {
+		path, err := s.adapterPath(name)
+		if err != nil {
+			return nil, err
+		}
+		digest, err := huggingface.ComputeSHA256(path)
+		if err != nil {
+			return nil, fmt.Errorf("hashing adapter %s: %w", name, err)
+		}
+		if exists, err := s.ollamaClient.BlobExists(digest); err != nil || !exists {
+			if err := s.ollamaClient.PushBlob(digest, path, quietProgress{}, model); err != nil {
+				return nil, fmt.Errorf("uploading adapter %s: %w", name, err)
+			}
+		}
+		adapters[name] = digest
+	}
+	return adapters, nil
+}
+
+// appliedAdapters returns the digests of the ADAPTER lines of a Modelfile
+// as shown by Ollama (ADAPTER /root/.ollama/models/blobs/sha256-<hex>).
+func appliedAdapters(modelfile string) []string {
+	var digests []string
+	for _, line := range strings.Split(modelfile, "\n") {
+		rest, ok := strings.CutPrefix(strings.TrimSpace(line), "ADAPTER ")
+		if !ok {
+			continue
+		}
+		base := filepath.Base(strings.TrimSpace(rest))
+		if hex, ok := strings.CutPrefix(base, "sha256-"); ok {
+			digests = append(digests, "sha256:"+hex)
+		} else {
+			digests = append(digests, base)
+		}
+	}
+	return digests
+}
+
+// handleAdapterList serves GET /admin/adapters: the adapters that can be
+// attached when creating a model.
+func (s *Server) handleAdapterList(w http.ResponseWriter, r *http.Request) {
+	if s.config.AdaptersDir == "" {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"dir": "", "adapters": []adapterFile{}})
+		return
+	}
+	adapters, err := s.listAdapters()
+	if err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Reading ADAPTERS_DIR failed: "+err.Error())
+		return
+	}
+	if adapters == nil {
+		adapters = []adapterFile{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"dir": s.config.AdaptersDir, "adapters": adapters})
+}
+
+// handleModelAdapters serves GET /admin/models/{name}/adapters: the adapters
+// applied to a model, named after the ADAPTERS_DIR file when one matches.
+func (s *Server) handleModelAdapters(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	show, err := s.ollamaClient.ShowModel(name)
+	if err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "model_not_found", fmt.Sprintf("Model %s: %v", name, err))
+		return
+	}
+	byDigest := make(map[string]string)
+	if s.config.AdaptersDir != "" {
+		files, _ := s.listAdapters()
+		for _, f := range files {
+			byDigest[f.Digest] = f.Name
+		}
+	}
+	applied := make([]map[string]interface{}, 0)
+	for _, digest := range appliedAdapters(show.Modelfile) {
+		entry := map[string]interface{}{"digest": digest}
+		if file, ok := byDigest[digest]; ok {
+			entry["name"] = file
+		}
+		applied = append(applied, entry)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"model": name, "adapters": applied})
+}
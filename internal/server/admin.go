@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"olares-ollama/internal/logging"
+)
+
+// adminRoute registers an admin endpoint. When ADMIN_TOKEN is set, admin
+// endpoints require it or a role key with the role adminRoleRules give the
+// method (as a Bearer token or X-Admin-Token header).
+func (s *Server) adminRoute(path string, handler http.HandlerFunc, methods ...string) {
+	s.routeOn(s.managementMux(), path, s.authorizeAdmin(path, handler), methods...)
+}
+
+// handleAdminConfig returns the effective configuration: every environment
+// variable as resolved at startup, defaults included, secrets masked.
+func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	mode := "model"
+	if s.config.GGUFMode {
+		mode = "gguf"
+	} else if s.config.BaseMode {
+		mode = "base"
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"mode":     mode,
+		"model":    s.model(),
+		"settings": s.config.Settings(),
+	})
+}
+
+// handleLogLevelGet reports the current log level.
+func (s *Server) handleLogLevelGet(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, logLevelStatus())
+}
+
+// handleLogLevelPut changes the log level without a restart. Body:
+// {"level": "debug", "duration_sec": 600}; with duration_sec the previous
+// level comes back on its own, so debug tracing can't be left on by accident.
+func (s *Server) handleLogLevelPut(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Level       string `json:"level"`
+		DurationSec int    `json:"duration_sec"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+		return
+	}
+	level, err := logging.ParseLevel(req.Level)
+	if err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if req.DurationSec < 0 {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "duration_sec must not be negative")
+		return
+	}
+	duration := time.Duration(req.DurationSec) * time.Second
+	logging.SetLevel(level, duration)
+	s.configChanged(map[string]interface{}{
+		"setting": "LOG_LEVEL", "value": level.String(), "duration_sec": req.DurationSec,
+	})
+	if duration > 0 {
+		log.Printf("WARNING: log level set to %s for %v by admin", level, duration)
+	} else {
+		log.Printf("WARNING: log level set to %s by admin", level)
+	}
+	writeJSON(w, http.StatusOK, logLevelStatus())
+}
+
+func logLevelStatus() map[string]interface{} {
+	active, permanent, expires := logging.Current()
+	status := map[string]interface{}{"level": active.String(), "default": permanent.String()}
+	if !expires.IsZero() {
+		status["expires_at"] = expires
+	}
+	return status
+}
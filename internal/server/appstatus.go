@@ -0,0 +1,201 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Condition statuses, as in Kubernetes.
+const (
+	conditionTrue    = "True"
+	conditionFalse   = "False"
+	conditionUnknown = "Unknown"
+)
+
+// appCondition is one condition of the /status document, shaped like a
+// Kubernetes status condition.
+type appCondition struct {
+	Type               string    `json:"type"`
+	Status             string    `json:"status"`
+	Reason             string    `json:"reason"`
+	Message            string    `json:"message,omitempty"`
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+}
+
+// conditionTracker remembers when each condition last changed status; a
+// condition's first transition is when the proxy first evaluated it.
+type conditionTracker struct {
+	mu   sync.Mutex
+	seen map[string]appCondition
+}
+
+func newConditionTracker() *conditionTracker {
+	return &conditionTracker{seen: map[string]appCondition{}}
+}
+
+// observe fills in LastTransitionTime: kept from the last evaluation while
+// the status is the same, now when it changed.
+func (ct *conditionTracker) observe(conds []appCondition) []appCondition {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	now := time.Now().UTC()
+	for i, c := range conds {
+		if prev, ok := ct.seen[c.Type]; ok && prev.Status == c.Status {
+			conds[i].LastTransitionTime = prev.LastTransitionTime
+		} else {
+			conds[i].LastTransitionTime = now
+		}
+		ct.seen[c.Type] = conds[i]
+	}
+	return conds
+}
+
+// configChanged records a configuration change made at runtime and bumps
+// the generation /status reports as observedGeneration.
+func (s *Server) configChanged(fields map[string]interface{}) {
+	s.generation.Add(1)
+	s.events.Record("config_changed", fields)
+}
+
+// appConditions evaluates the conditions of the proxy, Ready last.
+func (s *Server) appConditions() []appCondition {
+	var conds []appCondition
+	model := s.model()
+
+	if model != "" {
+		progress := s.progressManager.GetProgress()
+		c := appCondition{Type: "ModelDownloaded", Status: conditionFalse, Reason: "Downloading",
+			Message: fmt.Sprintf("%s: %s %.0f%%", model, progress.Status, progress.Progress)}
+		switch progress.Status {
+		case "completed", "success", "complete":
+			c.Status, c.Reason, c.Message = conditionTrue, "Downloaded", model
+		case "error":
+			c.Reason, c.Message = "DownloadFailed", progress.ErrorMessage
+		case "", "starting", "waiting":
+			c.Reason = "Pending"
+		case statusAwaitingConfirmation:
+			c.Reason = "AwaitingConfirmation"
+			c.Message = fmt.Sprintf("Downloading %s (%s) needs a confirmation: POST /api/downloads/%s/confirm", model, formatGB(progress.Total), model)
+		}
+		conds = append(conds, c)
+	}
+
+	conds = append(conds, s.upstreamCondition())
+
+	if model != "" {
+		c := appCondition{Type: "ModelLoaded", Status: conditionFalse}
+		switch state := s.modelLoadInfo()["state"]; state {
+		case modelLoaded:
+			c.Status, c.Reason = conditionTrue, "Loaded"
+		case modelWarming:
+			c.Reason, c.Message = "Warming", "A request is waiting for Ollama to load "+model
+		case modelNotLoaded:
+			c.Reason, c.Message = "NotLoaded", "Loaded on the next request"
+		default:
+			c.Status, c.Reason = conditionUnknown, "NotChecked"
+		}
+		conds = append(conds, c)
+
+		if last, ok := s.lastSmokeTest.Load().(smokeResult); ok && matchesModel(last.Model, model) {
+			c := appCondition{Type: "SmokeTestPassed", Status: conditionTrue, Reason: "Passed"}
+			if !last.Passed {
+				c.Status, c.Reason, c.Message = conditionFalse, "Failed", last.Error
+			}
+			conds = append(conds, c)
+		}
+	}
+
+	if spec, _, err := s.desiredSpec(); spec != nil || err != nil {
+		c := appCondition{Type: "DesiredStateSynced", Status: conditionUnknown, Reason: "Pending"}
+		s.desired.mu.Lock()
+		last := s.desired.last
+		s.desired.mu.Unlock()
+		switch {
+		case err != nil:
+			c.Status, c.Reason, c.Message = conditionFalse, "InvalidSpec", err.Error()
+		case last == nil:
+		case last.Error != "":
+			c.Status, c.Reason, c.Message = conditionFalse, "ReconcileFailed", last.Error
+		case last.InSync:
+			c.Status, c.Reason = conditionTrue, "InSync"
+		default:
+			c.Status, c.Reason = conditionFalse, "Drift"
+			c.Message = fmt.Sprintf("%d differences from the desired state", len(last.Drift))
+		}
+		conds = append(conds, c)
+	}
+
+	ready := appCondition{Type: "Ready", Status: conditionTrue, Reason: "Ready"}
+	if reasons := s.readinessReasons(); len(reasons) > 0 {
+		ready.Status, ready.Reason, ready.Message = conditionFalse, "NotReady", strings.Join(reasons, "; ")
+	}
+	return append(conds, ready)
+}
+
+// upstreamCondition is UpstreamReachable: from the background prober with
+// UPSTREAM_PROBE_INTERVAL_SEC, else from the last /api/version check.
+func (s *Server) upstreamCondition() appCondition {
+	c := appCondition{Type: "UpstreamReachable", Status: conditionTrue, Reason: "Connected", Message: s.config.OllamaURL}
+	if s.config.UpstreamProbeIntervalSec > 0 {
+		upstream := s.upstreamStateInfo(false)
+		msg, _ := upstream["last_error"].(string)
+		switch upstream["state"] {
+		case upstreamUnreachable:
+			c.Status, c.Reason, c.Message = conditionFalse, "Unreachable", msg
+		case upstreamReconnecting:
+			c.Status, c.Reason, c.Message = conditionUnknown, "Reconnecting", msg
+		}
+		return c
+	}
+	uv := &s.upstreamVersion
+	uv.mu.RLock()
+	verr, checkedAt := uv.err, uv.checkedAt
+	uv.mu.RUnlock()
+	switch {
+	case checkedAt.IsZero():
+		c.Status, c.Reason, c.Message = conditionUnknown, "NotChecked", ""
+	case verr != "":
+		c.Status, c.Reason, c.Message = conditionFalse, "Unreachable", verr
+	}
+	return c
+}
+
+// appPhase sums the conditions up: Running when ready, Failed when it
+// won't get ready without help (a failed download or smoke test, Ollama
+// unreachable), else Pending.
+func appPhase(conds []appCondition) string {
+	phase := "Pending"
+	for _, c := range conds {
+		switch {
+		case c.Type == "Ready" && c.Status == conditionTrue:
+			return "Running"
+		case c.Reason == "DownloadFailed", c.Type == "SmokeTestPassed" && c.Status == conditionFalse,
+			c.Type == "UpstreamReachable" && c.Reason == "Unreachable":
+			phase = "Failed"
+		}
+	}
+	return phase
+}
+
+// handleAppStatus handles GET /status: the state of the proxy as a
+// Kubernetes-style status document, for the Olares app controller to read
+// instead of the logs. observedGeneration counts the configurations the
+// proxy has run with: 1 at start, plus one per change made at runtime (log
+// level, thermal settings, desired state, model switch, state import).
+func (s *Server) handleAppStatus(w http.ResponseWriter, r *http.Request) {
+	conds := s.conditions.observe(s.appConditions())
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"apiVersion": "olares-ollama/v1",
+		"kind":       "OllamaProxyStatus",
+		"status": map[string]interface{}{
+			"phase":              appPhase(conds),
+			"observedGeneration": s.generation.Load(),
+			"model":              s.model(),
+			"startedAt":          s.startedAt,
+			"conditions":         conds,
+		},
+	})
+}
@@ -0,0 +1,311 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Async job states.
+const (
+	jobRunning   = "running" // waiting for a limiter slot or generating
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// maxJobWait caps the ?wait= long-poll of GET /api/async/{id}.
+const maxJobWait = 120 * time.Second
+
+// asyncEndpoints maps POST /api/async/<endpoint> to the inference route it runs.
+var asyncEndpoints = map[string]string{
+	"chat":     "/api/chat",
+	"generate": "/api/generate",
+}
+
+// asyncJob is one background inference request (ENABLE_ASYNC_JOBS).
+type asyncJob struct {
+	ID         string                 `json:"id"`
+	Endpoint   string                 `json:"endpoint"`
+	Status     string                 `json:"status"`
+	CreatedAt  time.Time              `json:"created_at"`
+	FinishedAt *time.Time             `json:"finished_at,omitempty"`
+	HTTPStatus int                    `json:"http_status,omitempty"`
+	Result     json.RawMessage        `json:"result,omitempty"`
+	Error      map[string]interface{} `json:"error,omitempty"`
+
+	owner  string // tenantOf the submitting request; only it sees the job
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the job leaves running
+}
+
+// asyncJobStore keeps jobs in memory; finished ones expire after ttl.
+type asyncJobStore struct {
+	mu         sync.Mutex
+	jobs       map[string]*asyncJob
+	ttl        time.Duration
+	maxRunning int
+}
+
+func newAsyncJobStore(ttlSec, maxRunning int) *asyncJobStore {
+	return &asyncJobStore{
+		jobs:       make(map[string]*asyncJob),
+		ttl:        time.Duration(ttlSec) * time.Second,
+		maxRunning: maxRunning,
+	}
+}
+
+// pruneLocked drops expired finished jobs. Caller holds st.mu.
+func (st *asyncJobStore) pruneLocked() {
+	cutoff := time.Now().Add(-st.ttl)
+	for id, job := range st.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			delete(st.jobs, id)
+		}
+	}
+}
+
+// add registers a running job, or returns false when ASYNC_MAX_JOBS are
+// already running.
+func (st *asyncJobStore) add(job *asyncJob) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.pruneLocked()
+	running := 0
+	for _, j := range st.jobs {
+		if j.Status == jobRunning {
+			running++
+		}
+	}
+	if st.maxRunning > 0 && running >= st.maxRunning {
+		return false
+	}
+	st.jobs[job.ID] = job
+	return true
+}
+
+// get returns a copy of the job and its done channel.
+func (st *asyncJobStore) get(id string) (asyncJob, <-chan struct{}, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.pruneLocked()
+	job, ok := st.jobs[id]
+	if !ok {
+		return asyncJob{}, nil, false
+	}
+	return *job, job.done, true
+}
+
+// list returns the jobs of owner, newest first, without their results.
+func (st *asyncJobStore) list(owner string) []asyncJob {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.pruneLocked()
+	out := make([]asyncJob, 0, len(st.jobs))
+	for _, job := range st.jobs {
+		if job.owner != owner {
+			continue
+		}
+		cp := *job
+		cp.Result = nil
+		out = append(out, cp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// finish records the outcome of a running job (a cancelled job keeps its state).
+func (st *asyncJobStore) finish(id string, fn func(job *asyncJob)) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	job, ok := st.jobs[id]
+	if !ok || job.Status != jobRunning {
+		return
+	}
+	fn(job)
+	now := time.Now()
+	job.FinishedAt = &now
+	close(job.done)
+}
+
+func (st *asyncJobStore) delete(id string) {
+	st.mu.Lock()
+	delete(st.jobs, id)
+	st.mu.Unlock()
+}
+
+// registerAsyncRoutes adds the /api/async API (ENABLE_ASYNC_JOBS).
+func (s *Server) registerAsyncRoutes() {
+	s.route("/api/async", s.handleAsyncList, "GET")
+	s.route("/api/async/{id}", s.handleAsyncSubmit, "POST") // {id} is the endpoint: chat or generate
+	s.route("/api/async/{id}", s.handleAsyncGet, "GET")
+	s.route("/api/async/{id}", s.handleAsyncCancel, "DELETE")
+}
+
+func (s *Server) handleAsyncList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"jobs": s.asyncJobs.list(s.tenantOf(r))})
+}
+
+// ownJob returns the job id if it belongs to the requesting tenant. Other
+// tenants' jobs are reported as missing, so IDs do not leak across tenants.
+func (s *Server) ownJob(r *http.Request, id string) (asyncJob, <-chan struct{}, bool) {
+	job, done, ok := s.asyncJobs.get(id)
+	if !ok || job.owner != s.tenantOf(r) {
+		return asyncJob{}, nil, false
+	}
+	return job, done, true
+}
+
+// handleAsyncSubmit starts a job and returns its ID right away. The body is
+// the one of the matching endpoint (/api/chat or /api/generate); the job
+// always runs non-streaming, through the same pipeline as a direct request.
+func (s *Server) handleAsyncSubmit(w http.ResponseWriter, r *http.Request) {
+	endpoint := r.PathValue("id")
+	if _, ok := asyncEndpoints[endpoint]; !ok {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "not_found", "Unknown async endpoint: "+endpoint+" (use chat or generate)")
+		return
+	}
+	var req map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+		return
+	}
+	// The job request carries the client's headers (auth, X-Prompt-Template, ...).
+	job, ok := s.startAsyncJob(endpoint, req, r.Header, r.RemoteAddr, s.tenantOf(r))
+	if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusTooManyRequests, "too_many_jobs",
+			"ASYNC_MAX_JOBS jobs are already running; wait for one to finish")
+		return
+	}
+	w.Header().Set("Location", "/api/async/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// startAsyncJob runs req against the endpoint's route in the background and
+// returns the new job, owned by owner, or ok=false when ASYNC_MAX_JOBS are
+// already running.
+func (s *Server) startAsyncJob(endpoint string, req map[string]interface{}, header http.Header, remoteAddr, owner string) (job asyncJob, ok bool) {
+	path := asyncEndpoints[endpoint]
+	req["stream"] = false
+	body, _ := json.Marshal(req)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &asyncJob{
+		ID:        newSessionID(),
+		Endpoint:  endpoint,
+		Status:    jobRunning,
+		CreatedAt: time.Now(),
+		owner:     owner,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	if !s.asyncJobs.add(j) {
+		cancel()
+		return asyncJob{}, false
+	}
+	jobReq, _ := http.NewRequestWithContext(ctx, http.MethodPost, path, bytes.NewReader(body))
+	for key, values := range header {
+		if key != "Content-Length" {
+			jobReq.Header[key] = values
+		}
+	}
+	jobReq.Header.Set("Content-Type", "application/json")
+	jobReq.RemoteAddr = remoteAddr
+	go s.runAsyncJob(j.ID, jobReq, cancel)
+
+	log.Printf("Async job %s started (%s)", j.ID, path)
+	job, _, _ = s.asyncJobs.get(j.ID)
+	return job, true
+}
+
+func (s *Server) runAsyncJob(id string, req *http.Request, cancel context.CancelFunc) {
+	defer cancel()
+	start := time.Now()
+	rec := newResponseRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if req.Context().Err() != nil {
+		return // cancelled; handleAsyncCancel recorded it
+	}
+	body := rec.body.Bytes()
+	s.asyncJobs.finish(id, func(job *asyncJob) {
+		job.HTTPStatus = rec.status
+		if rec.status >= http.StatusBadRequest {
+			job.Status = jobFailed
+			code, msg := errorCodeFromBody(body)
+			if msg == "" {
+				msg = strings.TrimSpace(string(body))
+			}
+			job.Error = map[string]interface{}{"code": code, "message": msg}
+			return
+		}
+		job.Status = jobCompleted
+		if json.Valid(body) {
+			job.Result = json.RawMessage(bytes.TrimSpace(body))
+		} else {
+			job.Result, _ = json.Marshal(string(body))
+		}
+	})
+	log.Printf("Async job %s finished with %d in %s", id, rec.status, time.Since(start).Round(time.Millisecond))
+}
+
+// handleAsyncGet returns a job. With ?wait=<seconds> it long-polls: the
+// response is held until the job finishes or the wait runs out.
+func (s *Server) handleAsyncGet(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	job, done, ok := s.ownJob(r, id)
+	if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "job_not_found", "Job not found: "+id)
+		return
+	}
+	if v := r.URL.Query().Get("wait"); v != "" && job.Status == jobRunning {
+		sec, err := strconv.Atoi(v)
+		if err != nil || sec < 0 {
+			writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "wait must be a number of seconds")
+			return
+		}
+		wait := min(time.Duration(sec)*time.Second, maxJobWait)
+		if s.config.ServerWriteTimeoutSec > 0 {
+			http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + time.Duration(s.config.ServerWriteTimeoutSec)*time.Second))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-done:
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+		job, _, _ = s.asyncJobs.get(id)
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleAsyncCancel cancels a running job; finished jobs are deleted.
+func (s *Server) handleAsyncCancel(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	job, _, ok := s.ownJob(r, id)
+	if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "job_not_found", "Job not found: "+id)
+		return
+	}
+	if job.Status != jobRunning {
+		s.asyncJobs.delete(id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.asyncJobs.finish(id, func(job *asyncJob) {
+		job.Status = jobCancelled
+		job.cancel()
+	})
+	log.Printf("Async job %s cancelled", id)
+	job, _, _ = s.asyncJobs.get(id)
+	writeJSON(w, http.StatusOK, job)
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"olares-ollama/internal/config"
+)
+
+// backend is a local service other than Ollama that the proxy fronts on its
+// own port, so one endpoint serves the whole local AI stack: e.g. a Whisper
+// server for /v1/audio/transcriptions. Requests are passed through as they
+// are (the service speaks the OpenAI API itself), inside the request
+// accounting of inference endpoints: tenant usage, request size limits,
+// the error log.
+type backend struct {
+	name   string // for logs and errors, e.g. "speech-to-text"
+	target *url.URL
+	proxy  *httputil.ReverseProxy
+
+	aliases map[string]string // model the client names -> model the service gets
+}
+
+// newBackend returns the backend at rawURL, whose path (if any) is
+// prefixed to the request paths.
+func newBackend(name, rawURL string) (*backend, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("%q is not an http(s) URL", rawURL)
+	}
+	b := &backend{name: name, target: target}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // a local service: never through OUTBOUND_PROXY or HTTP_PROXY
+	b.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+		},
+		Transport:     transport,
+		FlushInterval: -1, // speech may stream
+		ModifyResponse: func(resp *http.Response) error {
+			for k := range resp.Header {
+				if strings.HasPrefix(k, "Access-Control-") { // the proxy sets its own
+					delete(resp.Header, k)
+				}
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("!!! %s backend %s failed for %s: %v !!!", b.name, b.target.Host, r.URL.Path, err)
+			writeError(w, openAIErrorFormat, http.StatusBadGateway, "backend_unavailable",
+				fmt.Sprintf("The %s backend is not reachable: %v", b.name, err))
+		},
+	}
+	return b, nil
+}
+
+// serve passes the request on. The model the request names (JSON bodies;
+// multipart uploads aren't parsed), after aliasing, is what the service
+// gets and what the usage is counted under, else the backend's name.
+func (b *backend) serve(w http.ResponseWriter, r *http.Request) {
+	model := b.name
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, openAIErrorFormat, http.StatusBadRequest, "invalid_request", "Failed to read request body: "+err.Error())
+			return
+		}
+		var req map[string]json.RawMessage
+		if json.Unmarshal(body, &req) == nil {
+			var named string
+			json.Unmarshal(req["model"], &named)
+			if alias := b.alias(named); alias != named {
+				log.Printf(">>> %s model %q is served as %q <<<", b.name, named, alias)
+				req["model"], _ = json.Marshal(alias)
+				body, _ = json.Marshal(req)
+				named = alias
+			}
+			if named != "" {
+				model = named
+			}
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+	}
+	if meta := metaFrom(r); meta != nil {
+		meta.update(func(m *requestMeta) { m.servedModel = model })
+	}
+	log.Printf(">>> Passing %s %s to the %s backend (%s) <<<", r.Method, r.URL.Path, b.name, b.target.Host)
+	b.proxy.ServeHTTP(w, r)
+}
+
+// alias returns the model the service gets for the one the client named:
+// its alias, else the "*" alias, else the model itself.
+func (b *backend) alias(model string) string {
+	if to, ok := b.aliases[model]; ok {
+		return to
+	}
+	if to, ok := b.aliases["*"]; ok {
+		return to
+	}
+	return model
+}
+
+// backendRoute serves path from b.
+func (s *Server) backendRoute(path string, b *backend, methods ...string) {
+	s.route(path, s.withMeta(b.serve), methods...)
+}
+
+// registerBackendRoutes routes the OpenAI audio and image endpoints to the
+// services configured for them. A path without a backend stays unrouted
+// (404).
+func (s *Server) registerBackendRoutes() {
+	imageAliases, _ := config.ParseModelAliases(s.config.ImageModelAliases) // checked by Validate
+	for _, bc := range []struct {
+		name, url string
+		paths     []string
+		aliases   map[string]string
+	}{
+		{"speech-to-text", s.config.AudioTranscriptionURL, []string{"/v1/audio/transcriptions", "/v1/audio/translations"}, nil},
+		{"text-to-speech", s.config.AudioSpeechURL, []string{"/v1/audio/speech"}, nil},
+		{"image generation", s.config.ImageGenerationURL, []string{"/v1/images/generations"}, imageAliases},
+	} {
+		if bc.url == "" {
+			continue
+		}
+		b, err := newBackend(bc.name, bc.url)
+		if err != nil {
+			log.Printf("!!! Ignoring the %s backend: %v !!!", bc.name, err)
+			continue
+		}
+		b.aliases = bc.aliases
+		for _, path := range bc.paths {
+			s.backendRoute(path, b, "POST")
+		}
+		log.Printf("Routing %s to the %s backend at %s", strings.Join(bc.paths, ", "), bc.name, b.target)
+	}
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// capabilitySet is what the upstream can do for one model: the Ollama
+// version's features narrowed by the model's own /api/show capabilities.
+// Handlers consult it to degrade requests instead of forwarding something
+// Ollama will reject with a confusing error.
+type capabilitySet struct {
+	BatchEmbed        bool   `json:"batch_embed"`        // /api/embed (else per-input /api/embeddings)
+	Tools             bool   `json:"tools"`              // tool calling
+	StructuredOutputs bool   `json:"structured_outputs"` // JSON schema in "format" (else "json" mode)
+	Thinking          bool   `json:"thinking"`           // "think" parameter
+	Completion        bool   `json:"completion"`         // the model does chat/generate
+	Embedding         bool   `json:"embedding"`          // the model produces embeddings
+	Source            string `json:"source"`             // "assumed", "version", "version+model" or "version+family"
+}
+
+// capabilities negotiates the capability set for model. Unknown facts
+// (version not checked yet, Ollama too old to report model capabilities)
+// are assumed available so nothing is disabled on guesswork.
+func (s *Server) capabilities(model string) capabilitySet {
+	caps := capabilitySet{Source: "assumed"}
+	caps.BatchEmbed, _ = s.featureAvailable("embed")
+	caps.Tools, _ = s.featureAvailable("tools")
+	caps.StructuredOutputs, _ = s.featureAvailable("structured_outputs")
+	caps.Thinking, _ = s.featureAvailable("think")
+	caps.Completion, caps.Embedding = true, true
+	if comparableVersion(s.upstreamVersionString()) != "" {
+		caps.Source = "version"
+	}
+
+	if show := s.showModel(s.ollamaClient, model); show != nil && len(show.Capabilities) > 0 {
+		has := make(map[string]bool, len(show.Capabilities))
+		for _, c := range show.Capabilities {
+			has[c] = true
+		}
+		caps.Tools = caps.Tools && has["tools"]
+		caps.Thinking = caps.Thinking && has["thinking"]
+		caps.Completion = has["completion"]
+		caps.Embedding = has["embedding"]
+		caps.Source = "version+model"
+	} else if show != nil && isEmbeddingFamily(show.Details) {
+		// Ollama before capabilities were reported: recognize BERT-style
+		// encoder families, which can only embed.
+		caps.Completion = false
+		caps.Source = "version+family"
+	}
+	return caps
+}
+
+// isEmbeddingFamily reports whether /api/show details name an encoder-only family.
+func isEmbeddingFamily(details map[string]interface{}) bool {
+	families := []interface{}{details["family"]}
+	if list, ok := details["families"].([]interface{}); ok {
+		families = append(families, list...)
+	}
+	for _, f := range families {
+		if name, _ := f.(string); strings.Contains(strings.ToLower(name), "bert") {
+			return true
+		}
+	}
+	return false
+}
+
+// guardModelType rejects a request whose endpoint doesn't match the model
+// type (chat to an embedding-only model, embeddings to a chat model) with a
+// descriptive 400 instead of an obscure upstream failure. want is
+// "completion" or "embedding". Returns false if it wrote the error.
+func (s *Server) guardModelType(w http.ResponseWriter, r *http.Request, model, want string) bool {
+	if !s.config.ModelTypeGuard || model == "" {
+		return true
+	}
+	caps := s.capabilities(model)
+	ue := &upstreamError{Status: http.StatusBadRequest}
+	switch {
+	case want == "embedding" && !caps.Embedding:
+		ue.Code = "embeddings_unsupported"
+		ue.Message = fmt.Sprintf("Model %s is not an embedding model, so %s cannot embed with it", model, r.URL.Path)
+	case want == "completion" && !caps.Completion:
+		ue.Code = "chat_unsupported"
+		ue.Message = fmt.Sprintf("Model %s is an embedding-only model and cannot serve %s", model, r.URL.Path)
+	default:
+		return true
+	}
+	for _, rule := range upstreamErrorRules {
+		if rule.code == ue.Code {
+			ue.Hint = rule.hint
+			break
+		}
+	}
+	log.Printf("!!! %s rejected: %s (%s) !!!", r.URL.Path, ue.Message, caps.Source)
+	writeUpstreamError(w, errorFormatForPath(r.URL.Path), ue)
+	return false
+}
+
+// probeCapabilities logs the configured model's capability set (run after
+// each version check so it is warm before the first request).
+func (s *Server) probeCapabilities() {
+	if s.model() == "" {
+		return
+	}
+	caps := s.capabilities(s.model())
+	log.Printf("Upstream capabilities for %s: batch_embed=%v tools=%v structured_outputs=%v thinking=%v completion=%v embedding=%v (%s)",
+		s.model(), caps.BatchEmbed, caps.Tools, caps.StructuredOutputs, caps.Thinking, caps.Completion, caps.Embedding, caps.Source)
+}
+
+// negotiateRequest adapts an Ollama chat/generate request to the model's
+// capabilities: "think" is dropped and JSON schema formats fall back to JSON
+// mode (noted in X-Proxy-Degraded). Embedding-only models and tools, which
+// cannot be emulated, are rejected with a clear error. Returns false if it
+// wrote one.
+func (s *Server) negotiateRequest(w http.ResponseWriter, r *http.Request, req map[string]interface{}) bool {
+	model, _ := req["model"].(string)
+	if !s.guardModelType(w, r, model, "completion") {
+		return false
+	}
+	caps := s.capabilities(model)
+
+	var degraded []string
+	if _, has := req["think"]; has && !caps.Thinking {
+		delete(req, "think")
+		degraded = append(degraded, "think")
+	}
+	if _, schema := req["format"].(map[string]interface{}); schema && !caps.StructuredOutputs {
+		req["format"] = "json"
+		degraded = append(degraded, "structured_outputs")
+	}
+	if len(degraded) > 0 {
+		w.Header().Set("X-Proxy-Degraded", strings.Join(degraded, ","))
+		traceDecision(r, "degraded", "%s (model lacks them)", strings.Join(degraded, ", "))
+		log.Printf(">>> %s: degraded %v for model %s (%s) <<<", r.URL.Path, degraded, model, caps.Source)
+	}
+
+	if tools, ok := req["tools"].([]interface{}); ok && len(tools) > 0 && !caps.Tools && req["tool_choice"] != "none" {
+		if ok, minVersion := s.featureAvailable("tools"); !ok {
+			writeError(w, errorFormatForPath(r.URL.Path), http.StatusNotImplemented, "feature_unavailable",
+				fmt.Sprintf("Upstream Ollama %s does not support tools (requires %s or newer)", s.upstreamVersionString(), minVersion))
+			return false
+		}
+		writeError(w, errorFormatForPath(r.URL.Path), http.StatusBadRequest, "tools_unsupported",
+			fmt.Sprintf("Model %s does not support tools", model))
+		return false
+	}
+	return true
+}
+
+// proxyEmbed sends an /api/embed request body upstream. Without batch embed
+// support it falls back to one legacy /api/embeddings call per input and
+// synthesizes an /api/embed response (vectors L2-normalized, as /api/embed
+// returns them), so callers never see the difference.
+func (s *Server) proxyEmbed(r *http.Request, body []byte, headers map[string]string) (*http.Response, error) {
+	var req map[string]interface{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return s.ollamaClient.ProxyRequest("POST", "/api/embed", bytes.NewReader(body), headers)
+	}
+	upstream := s.upstreamFor(r, "embeddings", req)
+	model, _ := req["model"].(string)
+	if caps := s.capabilities(model); caps.BatchEmbed {
+		return upstream.ProxyRequest("POST", "/api/embed", bytes.NewReader(body), headers)
+	}
+
+	var inputs []string
+	switch in := req["input"].(type) {
+	case string:
+		inputs = []string{in}
+	case []interface{}:
+		for _, item := range in {
+			str, _ := item.(string)
+			inputs = append(inputs, str)
+		}
+	}
+	log.Printf(">>> Upstream lacks /api/embed; embedding %d input(s) via /api/embeddings <<<", len(inputs))
+
+	embeddings := make([][]float64, 0, len(inputs))
+	for _, input := range inputs {
+		legacy := map[string]interface{}{"model": model, "prompt": input}
+		for _, key := range []string{"options", "keep_alive"} {
+			if v, ok := req[key]; ok {
+				legacy[key] = v
+			}
+		}
+		legacyBody, _ := json.Marshal(legacy)
+		resp, err := upstream.ProxyRequest("POST", "/api/embeddings", bytes.NewReader(legacyBody), headers)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return resp, nil
+		}
+		var out struct {
+			Embedding []float64 `json:"embedding"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse /api/embeddings response: %w", err)
+		}
+		embeddings = append(embeddings, normalizeVector(out.Embedding))
+	}
+
+	synthesized, err := json.Marshal(map[string]interface{}{"model": model, "embeddings": embeddings})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": []string{"application/json; charset=utf-8"}},
+		Body:          io.NopCloser(bytes.NewReader(synthesized)),
+		ContentLength: int64(len(synthesized)),
+	}, nil
+}
+
+// normalizeVector scales v to unit length (zero vectors are returned as is).
+func normalizeVector(v []float64) []float64 {
+	var sum float64
+	for _, x := range v {
+		sum += x * x
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := math.Sqrt(sum)
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
@@ -0,0 +1,238 @@
+package server_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"olares-ollama/proxytest"
+)
+
+// streamFaults are the ways an Ollama stream breaks after the 200, and the
+// error code the client should see for each.
+var streamFaults = []struct {
+	name  string
+	fault proxytest.Fault
+	code  string
+}{
+	{"disconnect", proxytest.Disconnect, "stream_interrupted"},
+	{"truncate", proxytest.Truncate, "stream_interrupted"},
+	{"malformed_line", proxytest.MalformedLine, "upstream_malformed_stream"},
+	{"error_line", proxytest.ErrorLine, "upstream_error"},
+}
+
+// faultyReply streams "one " and then breaks.
+func faultyReply(fault proxytest.Fault) proxytest.Reply {
+	r := proxytest.Reply{Content: "one two three", Fault: fault, FaultAfter: 1}
+	if fault == proxytest.ErrorLine {
+		r.Error = "model runner has unexpectedly stopped"
+	}
+	return r
+}
+
+func TestChaosOpenAIChatStream(t *testing.T) {
+	for _, tc := range streamFaults {
+		t.Run(tc.name, func(t *testing.T) {
+			h := proxytest.New(t, nil)
+			h.Ollama.Enqueue(faultyReply(tc.fault))
+			resp := h.Do(http.MethodPost, "/v1/chat/completions", chatRequest(true, "hi"))
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status %d", resp.StatusCode)
+			}
+			events := proxytest.DecodeSSE(t, resp.Body)
+			if len(events) < 2 {
+				t.Fatalf("want a delta and an error event, got %v", events)
+			}
+			first := choice(t, events[0].JSON)["delta"].(map[string]interface{})
+			if first["content"] != "one " {
+				t.Errorf("first delta = %v", first)
+			}
+			last := events[len(events)-1]
+			errObj, _ := last.JSON["error"].(map[string]interface{})
+			if errObj == nil || errObj["code"] != tc.code || errObj["message"] == "" {
+				t.Fatalf("last event = %q, want an error with code %s", last.Data, tc.code)
+			}
+			for _, ev := range events {
+				if ev.Data == "[DONE]" {
+					t.Error("a failed stream must not end with [DONE]")
+				}
+			}
+		})
+	}
+}
+
+func TestChaosOpenAICompletionsStream(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Enqueue(faultyReply(proxytest.Disconnect))
+	resp := h.Do(http.MethodPost, "/v1/completions", map[string]interface{}{"model": "gpt-4o", "prompt": "hi", "stream": true})
+	events := proxytest.DecodeSSE(t, resp.Body)
+	if len(events) == 0 {
+		t.Fatal("no events")
+	}
+	last := events[len(events)-1]
+	if errObj, _ := last.JSON["error"].(map[string]interface{}); errObj == nil || errObj["code"] != "stream_interrupted" {
+		t.Fatalf("last event = %q", last.Data)
+	}
+}
+
+func TestChaosResponsesStream(t *testing.T) {
+	for _, tc := range streamFaults {
+		t.Run(tc.name, func(t *testing.T) {
+			h := proxytest.New(t, nil)
+			h.Ollama.Enqueue(faultyReply(tc.fault))
+			resp := h.Do(http.MethodPost, "/v1/responses", map[string]interface{}{"model": "gpt-4o", "input": "hi", "stream": true})
+			events := proxytest.DecodeSSE(t, resp.Body)
+			if len(events) < 2 {
+				t.Fatalf("got %v", events)
+			}
+			errEv, failed := events[len(events)-2].JSON, events[len(events)-1].JSON
+			if errEv["type"] != "error" || errEv["code"] != tc.code {
+				t.Errorf("error event = %v", errEv)
+			}
+			if failed["type"] != "response.failed" {
+				t.Fatalf("last event = %v", failed)
+			}
+			r := failed["response"].(map[string]interface{})
+			if r["status"] != "failed" || r["error"].(map[string]interface{})["code"] != tc.code {
+				t.Errorf("failed response = %v", r)
+			}
+		})
+	}
+}
+
+func TestChaosOllamaChatStream(t *testing.T) {
+	for _, tc := range streamFaults {
+		t.Run(tc.name, func(t *testing.T) {
+			h := proxytest.New(t, nil)
+			h.Ollama.Enqueue(faultyReply(tc.fault))
+			resp := h.Do(http.MethodPost, "/api/chat", chatRequest(true, "hi"))
+			// Every line must stay valid JSON; DecodeNDJSON fails the test otherwise.
+			lines := proxytest.DecodeNDJSON(t, resp.Body)
+			if len(lines) != 2 {
+				t.Fatalf("want the first chunk and an error line, got %v", lines)
+			}
+			if msg := lines[0]["message"].(map[string]interface{}); msg["content"] != "one " {
+				t.Errorf("first chunk = %v", lines[0])
+			}
+			if lines[1]["code"] != tc.code || lines[1]["error"] == "" {
+				t.Errorf("last line = %v, want an error with code %s", lines[1], tc.code)
+			}
+			if tc.fault == proxytest.ErrorLine && lines[1]["error"] != "model runner has unexpectedly stopped" {
+				t.Errorf("error = %v, want Ollama's message", lines[1]["error"])
+			}
+		})
+	}
+}
+
+func TestChaosStreamErrorRecorded(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Enqueue(faultyReply(proxytest.MalformedLine))
+	proxytest.DecodeSSE(t, h.Do(http.MethodPost, "/v1/chat/completions", chatRequest(true, "hi")).Body)
+
+	_, errs := h.GetJSON("/api/errors")
+	codes, _ := errs["codes"].([]interface{})
+	for _, c := range codes {
+		if c.(map[string]interface{})["code"] == "upstream_malformed_stream" {
+			return
+		}
+	}
+	t.Errorf("upstream_malformed_stream not in /api/errors: %v", errs)
+}
+
+func TestChaosSlowFirstByte(t *testing.T) {
+	h := proxytest.New(t, map[string]string{"UPSTREAM_FIRST_BYTE_TIMEOUT_SEC": "1"})
+	h.Ollama.Enqueue(proxytest.Reply{Content: "late", FirstByteDelay: 1500 * time.Millisecond})
+
+	status, resp := h.PostJSON("/v1/chat/completions", chatRequest(true, "hi"))
+	if status != http.StatusGatewayTimeout {
+		t.Fatalf("status %d: %v", status, resp)
+	}
+	if code := resp["error"].(map[string]interface{})["code"]; code != "upstream_timeout" {
+		t.Errorf("code = %v", code)
+	}
+
+	// Within the limit, a slow start is just slow.
+	h.Ollama.Enqueue(proxytest.Reply{Content: "on time", FirstByteDelay: 200 * time.Millisecond})
+	status, resp = h.PostJSON("/v1/chat/completions", chatRequest(false, "hi"))
+	if status != http.StatusOK || choice(t, resp)["message"].(map[string]interface{})["content"] != "on time" {
+		t.Errorf("status %d: %v", status, resp)
+	}
+}
+
+func TestChaosDisconnectBeforeReply(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Enqueue(proxytest.Reply{Content: "never sent", Fault: proxytest.Disconnect})
+	status, resp := h.PostJSON("/v1/chat/completions", chatRequest(false, "hi"))
+	if status != http.StatusBadGateway {
+		t.Fatalf("status %d: %v", status, resp)
+	}
+	if code := resp["error"].(map[string]interface{})["code"]; code != "upstream_unreachable" {
+		t.Errorf("code = %v", code)
+	}
+}
+
+func TestChaosAnthropicMessagesDisconnect(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Handle("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler) // hang up without a response
+	})
+	status, resp := h.PostJSON("/v1/messages", map[string]interface{}{
+		"model": "claude-sonnet", "max_tokens": 64,
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": "hello"}},
+	})
+	if status != http.StatusBadGateway {
+		t.Fatalf("status %d: %v", status, resp)
+	}
+	if code := resp["error"].(map[string]interface{})["code"]; code != "upstream_unreachable" {
+		t.Errorf("code = %v", code)
+	}
+}
+
+// An Ollama that runs one generation at a time (OLLAMA_NUM_PARALLEL=1) only
+// answers choice 1 once choice 0 is done; the choices must stream in turn,
+// each in its own limiter slot.
+func TestChaosStreamChoicesSerializedUpstream(t *testing.T) {
+	h := proxytest.New(t, map[string]string{"MAX_CONCURRENT_REQUESTS": "1"})
+	var running sync.Mutex
+	line := `{"message":{"role":"assistant","content":"` + strings.Repeat("x", 2500) + `"},"done":false}` + "\n"
+	h.Ollama.Handle("/api/chat", func(w http.ResponseWriter, r *http.Request) {
+		running.Lock()
+		defer running.Unlock()
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for i := 0; i < 2000; i++ { // about 5 MB, more than the socket buffers hold
+			if _, err := io.WriteString(w, line); err != nil {
+				return
+			}
+		}
+		io.WriteString(w, `{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop"}`+"\n")
+	})
+
+	req := chatRequest(true, "hi")
+	req["n"] = 2
+	got := make(chan []proxytest.Event, 1)
+	go func() {
+		got <- proxytest.DecodeSSE(t, h.Do(http.MethodPost, "/v1/chat/completions", req).Body)
+	}()
+	var events []proxytest.Event
+	select {
+	case events = <-got:
+	case <-time.After(20 * time.Second):
+		t.Fatal("no answer: the choices deadlocked")
+	}
+	finished := map[float64]bool{}
+	for _, ev := range events {
+		choices, _ := ev.JSON["choices"].([]interface{})
+		for _, c := range choices {
+			c := c.(map[string]interface{})
+			if c["finish_reason"] == "stop" {
+				finished[c["index"].(float64)] = true
+			}
+		}
+	}
+	if !finished[0] || !finished[1] || events[len(events)-1].Data != "[DONE]" {
+		t.Errorf("finished choices %v, last event %q; want both and [DONE]", finished, events[len(events)-1].Data)
+	}
+}
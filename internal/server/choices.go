@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxStreamChoices bounds n on a streamed chat completion: every choice is
+// a generation of its own on Ollama.
+const maxStreamChoices = 8
+
+// streamChoices serves a streamed chat completion with n > 1: n copies of
+// the request go to Ollama and their chunks are interleaved into one SSE
+// stream as they arrive, each carrying its choice index, as OpenAI does.
+// Every choice ends with its own finish_reason chunk; the usage chunk (when
+// asked for) adds up all choices, then [DONE] ends the stream. With a seed,
+// choice i uses seed+i so the choices differ.
+//
+// Choice 0 runs in the request's limiter slot. The others take a free slot
+// each, or wait for a finished choice to hand its slot on, so
+// MAX_CONCURRENT_REQUESTS holds. The response starts when choice 0 answers
+// (an Ollama error there is the response's error, a later choice's one an
+// error event), and each choice streams from the moment its own answer
+// does: an Ollama that runs one generation at a time streams them in turn.
+func (s *Server) streamChoices(w http.ResponseWriter, r *http.Request, ollamaRequest map[string]interface{}, n int, includeUsage bool, maxTokens int) {
+	headers := s.upstreamHeaders(r)
+	headers["Content-Type"] = "application/json"
+	log.Printf(">>> Proxying OpenAI request to Ollama /api/chat as %d choices (model: %s) <<<", n, s.model())
+
+	upstream := s.upstreamFor(r, "chat", ollamaRequest)
+	ctx, cancel := context.WithCancel(r.Context())
+	// slots passes the slots of finished choices on to waiting ones; choice
+	// 0 passes on the request's own (a no-op release, the caller holds it).
+	slots := make(chan func(), n)
+	for i := 1; i < n; i++ {
+		release, ok := s.limiter.tryAcquire()
+		if !ok {
+			break
+		}
+		slots <- release
+	}
+	events := make(chan []byte)
+	defer func() {
+		cancel()
+		for range events { // wait for the choices to stop
+		}
+		for len(slots) > 0 {
+			(<-slots)()
+		}
+	}()
+
+	started := make(chan *upstreamError, 1) // choice 0's answer
+	writers := make([]*choiceWriter, n)
+	id, created, model := fmt.Sprintf("chatcmpl-%d", time.Now().Unix()), time.Now().Unix(), s.responseModel(r)
+	var wg sync.WaitGroup
+	for i := range writers {
+		writers[i] = &choiceWriter{index: i, id: id, created: created, events: events, ctx: ctx, header: http.Header{}}
+		wg.Add(1)
+		go func(i int, cw *choiceWriter) {
+			defer wg.Done()
+			release := func() {}
+			if i > 0 {
+				select {
+				case release = <-slots:
+				case <-ctx.Done():
+					return
+				}
+			}
+			defer func() { slots <- release }()
+
+			req := choiceRequest(ollamaRequest, i)
+			body, _ := json.Marshal(req)
+			var ue *upstreamError
+			resp, err := upstream.ProxyRequestContext(ctx, "POST", "/api/chat", bytes.NewReader(body), headers)
+			if err != nil {
+				ue = upstreamErrorFromTransport(err)
+			} else if resp.StatusCode != http.StatusOK {
+				ue = upstreamErrorFromResponse(resp)
+				resp.Body.Close()
+			}
+			if i == 0 {
+				started <- ue
+			}
+			if ue != nil {
+				if i > 0 && ctx.Err() == nil {
+					log.Printf("!!! Ollama error for choice %d: %s !!!", i, ue.Message)
+					writeOpenAIStreamFault(cw, &streamFault{code: ue.Code, message: ue.Message})
+				}
+				return
+			}
+			out := s.filterOutput(r, req, tapUsage(r, resp.Body))
+			defer out.Close()
+			s.convertOllamaStreamToOpenAI(cw, out, model, true, streamTokenBudget(r, ollamaRequest, maxTokens))
+		}(i, writers[i])
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+
+	if ue := <-started; ue != nil {
+		log.Printf("!!! Ollama error for OpenAI request: %s !!!", ue.Message)
+		writeUpstreamError(w, openAIErrorFormat, ue)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flushStream(w)
+
+	var totalBytes int64
+	for event := range events {
+		written, err := w.Write(event)
+		if err != nil {
+			log.Printf("!!! Error writing chunk: %v !!!", err)
+			return // stops the other generations
+		}
+		totalBytes += int64(written)
+		flushStream(w)
+	}
+
+	if includeUsage {
+		var prompt, completion int
+		for _, cw := range writers {
+			prompt += cw.prompt
+			completion += cw.completion
+		}
+		usageJSON, _ := json.Marshal(map[string]interface{}{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   model,
+			"choices": []map[string]interface{}{},
+			"usage": map[string]interface{}{
+				"prompt_tokens":     prompt,
+				"completion_tokens": completion,
+				"total_tokens":      prompt + completion,
+			},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", usageJSON)
+	}
+	w.Write([]byte("data: [DONE]\n\n"))
+	flushStream(w)
+	log.Printf("<<< Converted and sent OpenAI stream response with %d choices (%d bytes) <<<", n, totalBytes)
+}
+
+// choiceRequest returns the Ollama request for choice i: a copy with its
+// own options, the seed offset by i.
+func choiceRequest(ollamaRequest map[string]interface{}, i int) map[string]interface{} {
+	req := make(map[string]interface{}, len(ollamaRequest))
+	for k, v := range ollamaRequest {
+		req[k] = v
+	}
+	if options, ok := ollamaRequest["options"].(map[string]interface{}); ok {
+		copied := make(map[string]interface{}, len(options))
+		for k, v := range options {
+			copied[k] = v
+		}
+		if seed, ok := options["seed"]; ok && i > 0 {
+			copied["seed"] = intParam(seed) + i
+		}
+		req["options"] = copied
+	}
+	return req
+}
+
+// choiceWriter is the ResponseWriter one choice's stream converter writes
+// to. It stamps the choice index (and the shared id and created) on each
+// SSE event and hands it to streamChoices; the choice's usage chunk and
+// [DONE] are kept back for the combined ones.
+type choiceWriter struct {
+	index   int
+	id      string
+	created int64
+	events  chan<- []byte
+	ctx     context.Context
+	header  http.Header
+	pending []byte
+
+	prompt, completion int
+}
+
+func (cw *choiceWriter) Header() http.Header { return cw.header }
+func (cw *choiceWriter) WriteHeader(int)     {}
+func (cw *choiceWriter) Flush()              {}
+
+func (cw *choiceWriter) Write(p []byte) (int, error) {
+	cw.pending = append(cw.pending, p...)
+	for {
+		i := bytes.Index(cw.pending, []byte("\n\n"))
+		if i < 0 {
+			return len(p), nil
+		}
+		event := cw.restamp(bytes.TrimSpace(bytes.TrimPrefix(cw.pending[:i], []byte("data:"))))
+		cw.pending = cw.pending[i+2:]
+		if event == nil {
+			continue
+		}
+		select {
+		case cw.events <- event:
+		case <-cw.ctx.Done():
+			return 0, cw.ctx.Err()
+		}
+	}
+}
+
+// restamp rewrites one event's JSON for the combined stream, or returns nil
+// for events that aren't forwarded.
+func (cw *choiceWriter) restamp(data []byte) []byte {
+	if bytes.Equal(data, []byte("[DONE]")) {
+		return nil
+	}
+	var chunk map[string]interface{}
+	if json.Unmarshal(data, &chunk) != nil {
+		return nil
+	}
+	choices, _ := chunk["choices"].([]interface{})
+	if usage, ok := chunk["usage"].(map[string]interface{}); ok && len(choices) == 0 {
+		cw.prompt += intParam(usage["prompt_tokens"])
+		cw.completion += intParam(usage["completion_tokens"])
+		return nil
+	}
+	if _, isError := chunk["error"]; !isError {
+		chunk["id"], chunk["created"] = cw.id, cw.created
+		for _, c := range choices {
+			if c, ok := c.(map[string]interface{}); ok {
+				c["index"] = cw.index
+			}
+		}
+	}
+	out, _ := json.Marshal(chunk)
+	return []byte(fmt.Sprintf("data: %s\n\n", out))
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// compareRequest is the body of POST /api/compare: a prompt (or a whole
+// conversation) sent unchanged to both comparison models.
+type compareRequest struct {
+	Prompt   string                   `json:"prompt,omitempty"`
+	System   string                   `json:"system,omitempty"`
+	Messages []map[string]interface{} `json:"messages,omitempty"`
+	Options  map[string]interface{}   `json:"options,omitempty"`
+}
+
+// compareEvent is one event of the multiplexed stream, tagged with the
+// model (and its index, 0 or 1, for the side of the view) it belongs to.
+type compareEvent struct {
+	name string
+	data map[string]interface{}
+}
+
+// compareModels returns the two models /api/compare runs: COMPARE_MODELS,
+// or else OLLAMA_MODEL against FAST_LANE_MODEL. nil when neither is set up.
+func (s *Server) compareModels() []string {
+	if len(s.config.CompareModels) == 2 {
+		return s.config.CompareModels
+	}
+	if model := s.model(); model != "" && s.config.FastLaneModel != "" && s.config.FastLaneModel != model {
+		return []string{model, s.config.FastLaneModel}
+	}
+	return nil
+}
+
+// handleCompare serves POST /api/compare for the side-by-side view of the
+// web UI: the same prompt goes to both models at once and their answers
+// come back in one SSE stream. Events are "start" (the models), "delta"
+// (a piece of an answer), "done" (timings of one side), "error" (one side
+// failed; the other goes on) and "end".
+func (s *Server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	models := s.compareModels()
+	if models == nil {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "compare_not_configured",
+			"Set COMPARE_MODELS to the two models to compare (or OLLAMA_MODEL and FAST_LANE_MODEL)")
+		return
+	}
+	var req compareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+		return
+	}
+	messages := req.Messages
+	if len(messages) == 0 {
+		if strings.TrimSpace(req.Prompt) == "" {
+			writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "'prompt' or 'messages' is required")
+			return
+		}
+		if req.System != "" {
+			messages = append(messages, map[string]interface{}{"role": "system", "content": req.System})
+		}
+		messages = append(messages, map[string]interface{}{"role": "user", "content": req.Prompt})
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "streaming_unsupported", "Streaming is not supported")
+		return
+	}
+
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	writeCompareEvent(w, compareEvent{"start", map[string]interface{}{"models": models}})
+	flusher.Flush()
+
+	log.Printf(">>> Comparing %s and %s <<<", models[0], models[1])
+	events := make(chan compareEvent)
+	var wg sync.WaitGroup
+	for i, model := range models {
+		body := map[string]interface{}{"model": model, "messages": messages, "stream": true}
+		if len(req.Options) > 0 {
+			options := make(map[string]interface{}, len(req.Options))
+			for k, v := range req.Options {
+				options[k] = v
+			}
+			body["options"] = options
+		}
+		s.applyGenerationPolicy(body, 0)
+		s.applyHotKeepAlive(body)
+		s.applyPinnedKeepAlive(body)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.streamCompareSide(r.Context(), i, model, body, events)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+	for ev := range events {
+		if r.Context().Err() != nil {
+			continue // drain until both sides notice
+		}
+		writeCompareEvent(w, ev)
+		flusher.Flush()
+	}
+	writeCompareEvent(w, compareEvent{"end", map[string]interface{}{}})
+	flusher.Flush()
+}
+
+// streamCompareSide runs one model of a comparison and sends its events.
+// Each side takes its own limiter slot, so with MAX_CONCURRENT_REQUESTS=1
+// the second answer starts when the first is done.
+func (s *Server) streamCompareSide(ctx context.Context, index int, model string, body map[string]interface{}, events chan<- compareEvent) {
+	send := func(name string, data map[string]interface{}) {
+		data["model"], data["index"] = model, index
+		select {
+		case events <- compareEvent{name, data}:
+		case <-ctx.Done():
+		}
+	}
+	release, err := s.limiter.acquire(ctx, false)
+	if err != nil {
+		return // client gone
+	}
+	defer release()
+	defer s.modelUsage.track(model)() // a model switch drains it before unloading
+
+	start := time.Now()
+	data, _ := json.Marshal(body)
+	resp, err := s.ollamaClient.ProxyRequest("POST", "/api/chat", bytes.NewReader(data),
+		map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		ue := upstreamErrorFromTransport(err)
+		send("error", map[string]interface{}{"code": ue.Code, "error": ue.Message})
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		ue := upstreamErrorFromResponse(resp)
+		log.Printf("!!! Compare: %s failed: %s !!!", model, ue.Message)
+		send("error", map[string]interface{}{"code": ue.Code, "error": ue.Message})
+		return
+	}
+	stop := context.AfterFunc(ctx, func() { resp.Body.Close() }) // stop generating when the client leaves
+	defer stop()
+
+	var firstToken time.Duration
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 0, 64*1024), maxStreamLine)
+	for sc.Scan() {
+		var chunk struct {
+			Message struct {
+				Content  string `json:"content"`
+				Thinking string `json:"thinking"`
+			} `json:"message"`
+			Done         bool   `json:"done"`
+			DoneReason   string `json:"done_reason"`
+			Error        string `json:"error"`
+			EvalCount    int    `json:"eval_count"`
+			EvalDuration int64  `json:"eval_duration"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &chunk); err != nil {
+			continue
+		}
+		if chunk.Error != "" {
+			send("error", map[string]interface{}{"code": "upstream_error", "error": chunk.Error})
+			return
+		}
+		if chunk.Message.Content != "" || chunk.Message.Thinking != "" {
+			if firstToken == 0 {
+				firstToken = time.Since(start)
+			}
+			delta := map[string]interface{}{"content": chunk.Message.Content}
+			if chunk.Message.Thinking != "" {
+				delta["thinking"] = chunk.Message.Thinking
+			}
+			send("delta", delta)
+		}
+		if chunk.Done {
+			done := map[string]interface{}{
+				"done_reason":    chunk.DoneReason,
+				"latency_ms":     time.Since(start).Milliseconds(),
+				"first_token_ms": firstToken.Milliseconds(),
+				"output_tokens":  chunk.EvalCount,
+			}
+			if chunk.EvalDuration > 0 {
+				done["tokens_per_sec"] = float64(chunk.EvalCount) / (float64(chunk.EvalDuration) / float64(time.Second))
+			}
+			send("done", done)
+			return
+		}
+	}
+	if err := sc.Err(); err != nil && ctx.Err() == nil {
+		send("error", map[string]interface{}{"code": "stream_interrupted", "error": fmt.Sprintf("Reading the answer failed: %v", err)})
+		return
+	}
+	if ctx.Err() == nil {
+		send("error", map[string]interface{}{"code": "stream_interrupted", "error": "The answer ended before it was done"})
+	}
+}
+
+func writeCompareEvent(w http.ResponseWriter, ev compareEvent) {
+	data, _ := json.Marshal(ev.data)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.name, data)
+}
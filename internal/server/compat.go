@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+)
+
+// defaultProbeResponse is returned for GET probes unless PROBE_RESPONSE overrides it.
+const defaultProbeResponse = `{"status":"ok"}`
+
+// compatProfiles lists, per COMPAT_PROFILE, the POST-only inference
+// endpoints that a client probes with GET before using them. Answering those
+// probes with 200 + a JSON object keeps the client from marking the
+// connection as broken; everything else gets a normal 405.
+var compatProfiles = map[string][]string{
+	// "default" covers every POST-only inference endpoint: a GET there gets
+	// 200 and the probe body ({"status":"ok"} unless PROBE_RESPONSE is set).
+	"default": {
+		"/api/chat", "/api/chat/completions", "/v1/chat/completions", "/v1/completions",
+		"/v1/responses", "/v1/messages", "/v1/messages/count_tokens",
+	},
+	// OpenWebUI checks the chat endpoints and expects a dict (it calls .get()).
+	"openwebui": {"/api/chat", "/api/chat/completions", "/v1/chat/completions"},
+	"lobechat":  {"/api/chat", "/v1/chat/completions"},
+	"librechat": {"/v1/chat/completions", "/v1/completions", "/v1/messages"},
+	"none":      {},
+}
+
+// probePaths resolves COMPAT_PROFILE (comma-separated profile names) to the
+// sorted, de-duplicated set of endpoints that answer GET probes.
+func (s *Server) probePaths() []string {
+	profile := strings.TrimSpace(s.config.CompatProfile)
+	if profile == "" {
+		profile = "default"
+	}
+	set := map[string]bool{}
+	for _, name := range strings.Split(profile, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		paths, ok := compatProfiles[name]
+		if !ok {
+			log.Printf("Warning: unknown COMPAT_PROFILE %q, ignoring", name)
+			continue
+		}
+		for _, p := range paths {
+			set[p] = true
+		}
+	}
+	out := make([]string, 0, len(set))
+	for p := range set {
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// probeResponseBody returns the JSON object written for GET probes. An
+// invalid PROBE_RESPONSE falls back to the default so probes never break.
+func (s *Server) probeResponseBody() []byte {
+	raw := strings.TrimSpace(s.config.ProbeResponse)
+	if raw == "" {
+		return []byte(defaultProbeResponse)
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &obj); err != nil {
+		log.Printf("Warning: PROBE_RESPONSE is not a JSON object (%v), using %s", err, defaultProbeResponse)
+		return []byte(defaultProbeResponse)
+	}
+	return []byte(raw)
+}
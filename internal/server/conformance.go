@@ -0,0 +1,218 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const conformanceKept = 50 // recent violations reported in /api/status
+
+// hopByHopHeaders must not be copied from the upstream response.
+var hopByHopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Transfer-Encoding", "Upgrade"}
+
+// conformanceViolation is one response that broke an HTTP rule.
+type conformanceViolation struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+	Kind   string    `json:"kind"`
+	Detail string    `json:"detail"`
+}
+
+// conformanceAudit checks every response the proxy emits (CONFORMANCE_AUDIT)
+// for the mistakes hand-rolled proxying makes: Content-Length that doesn't
+// match the body or sits on a stream, hop-by-hop headers copied from
+// upstream, trailers that are set but never declared (Go drops them), bodies
+// on 204/304, streams that are never flushed.
+type conformanceAudit struct {
+	mu         sync.Mutex
+	checked    int64
+	counts     map[string]int64
+	violations []conformanceViolation
+}
+
+func newConformanceAudit(enabled bool) *conformanceAudit {
+	if !enabled {
+		return nil
+	}
+	return &conformanceAudit{counts: make(map[string]int64)}
+}
+
+func (a *conformanceAudit) report(r *http.Request, status int, kind, detail string) {
+	log.Printf("!!! Conformance: %s %s -> %d: %s: %s !!!", r.Method, r.URL.Path, status, kind, detail)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.counts[kind]++
+	a.violations = append(a.violations, conformanceViolation{
+		Time: time.Now(), Method: r.Method, Path: r.URL.Path, Status: status, Kind: kind, Detail: detail,
+	})
+	if len(a.violations) > conformanceKept {
+		a.violations = a.violations[len(a.violations)-conformanceKept:]
+	}
+}
+
+// info returns the conformance object for /api/status.
+func (a *conformanceAudit) info() map[string]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	counts := make(map[string]int64, len(a.counts))
+	total := int64(0)
+	for k, n := range a.counts {
+		counts[k] = n
+		total += n
+	}
+	return map[string]interface{}{
+		"responses_checked": a.checked,
+		"violations":        total,
+		"by_kind":           counts,
+		"recent":            append([]conformanceViolation{}, a.violations...),
+	}
+}
+
+// auditWriter records what a handler sends, closest to the connection, so
+// the checks see the response as the client gets it.
+type auditWriter struct {
+	http.ResponseWriter
+	audit        *conformanceAudit
+	r            *http.Request
+	status       int
+	wroteHeader  bool
+	headerKeys   map[string]bool // headers present when the status was sent
+	declared     int64           // Content-Length at that time, -1 = none
+	written      int64
+	writes       int
+	flushes      int
+	flushedEarly bool // flushed before the declared Content-Length was reached
+	problems     [][2]string
+}
+
+func (a *conformanceAudit) wrap(w http.ResponseWriter, r *http.Request) *auditWriter {
+	return &auditWriter{ResponseWriter: w, audit: a, r: r, declared: -1}
+}
+
+func (aw *auditWriter) problem(kind, format string, args ...interface{}) {
+	aw.problems = append(aw.problems, [2]string{kind, fmt.Sprintf(format, args...)})
+}
+
+func (aw *auditWriter) WriteHeader(code int) {
+	if aw.wroteHeader {
+		aw.problem("superfluous_write_header", "status %d after %d was already sent", code, aw.status)
+		aw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		aw.ResponseWriter.WriteHeader(code) // informational, the real status follows
+		return
+	}
+	aw.wroteHeader = true
+	aw.status = code
+	h := aw.Header()
+	aw.headerKeys = make(map[string]bool, len(h))
+	for k := range h {
+		aw.headerKeys[k] = true
+	}
+	for _, k := range []string{"Content-Length", "Content-Type"} {
+		if n := len(h.Values(k)); n > 1 {
+			aw.problem("duplicate_header", "%d %s values", n, k)
+		}
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			aw.problem("invalid_content_length", "Content-Length %q", cl)
+		} else {
+			aw.declared = n
+		}
+		if strings.HasPrefix(h.Get("Content-Type"), "text/event-stream") {
+			aw.problem("content_length_on_stream", "Content-Length %s on an event stream", cl)
+		}
+	}
+	if code != http.StatusSwitchingProtocols {
+		for _, k := range hopByHopHeaders {
+			if _, ok := h[k]; ok {
+				aw.problem("hop_by_hop_header", "%s: %s", k, h.Get(k))
+			}
+		}
+	}
+	aw.ResponseWriter.WriteHeader(code)
+}
+
+func (aw *auditWriter) Write(b []byte) (int, error) {
+	if !aw.wroteHeader {
+		aw.WriteHeader(http.StatusOK)
+	}
+	if aw.writes == 0 && len(b) > 0 && aw.Header().Get("Content-Type") == "" {
+		aw.problem("missing_content_type", "body written without Content-Type")
+	}
+	aw.writes++
+	n, err := aw.ResponseWriter.Write(b)
+	aw.written += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (aw *auditWriter) Unwrap() http.ResponseWriter {
+	return aw.ResponseWriter
+}
+
+func (aw *auditWriter) Flush() {
+	aw.flushes++
+	if aw.declared >= 0 && aw.written < aw.declared {
+		aw.flushedEarly = true
+	}
+	if f, ok := aw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish runs the checks that need the whole response and reports.
+func (aw *auditWriter) finish() {
+	if !aw.wroteHeader || aw.status == http.StatusSwitchingProtocols {
+		return
+	}
+	h := aw.Header()
+	if aw.status == http.StatusNoContent || aw.status == http.StatusNotModified {
+		if aw.written > 0 {
+			aw.problem("body_not_allowed", "%d body bytes on a %d response", aw.written, aw.status)
+		}
+	} else if aw.declared >= 0 && aw.r.Method != http.MethodHead && aw.written != aw.declared {
+		aw.problem("content_length_mismatch", "declared %d bytes, wrote %d", aw.declared, aw.written)
+	}
+	if aw.flushedEarly {
+		aw.problem("content_length_on_stream", "flushed before the declared %d bytes were written", aw.declared)
+	}
+	ct := h.Get("Content-Type")
+	if (strings.HasPrefix(ct, "text/event-stream") || strings.HasPrefix(ct, "application/x-ndjson")) && aw.writes > 1 && aw.flushes == 0 {
+		aw.problem("unflushed_stream", "%d writes of a %s response, never flushed", aw.writes, ct)
+	}
+	declared := make(map[string]bool)
+	for _, v := range h.Values("Trailer") {
+		for _, k := range strings.Split(v, ",") {
+			declared[http.CanonicalHeaderKey(strings.TrimSpace(k))] = true
+		}
+	}
+	for k := range h {
+		if !aw.headerKeys[k] && !declared[k] && !strings.HasPrefix(k, http.TrailerPrefix) {
+			aw.problem("undeclared_trailer", "%s set after the status was sent but not declared in Trailer", k)
+		}
+	}
+	for k := range declared {
+		if _, ok := h[k]; !ok {
+			aw.problem("missing_trailer", "%s declared in Trailer but never set", k)
+		}
+	}
+
+	a := aw.audit
+	a.mu.Lock()
+	a.checked++
+	a.mu.Unlock()
+	for _, p := range aw.problems {
+		a.report(aw.r, aw.status, p[0], p[1])
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// perMessageTokens approximates the chat template overhead of one message.
+const perMessageTokens = 4
+
+// estimateTokens is a tokenizer-free estimate: ~4 ASCII characters per token,
+// and one token per non-ASCII rune (CJK text is roughly one token per character).
+func estimateTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+func messageTokens(m map[string]interface{}) int {
+	n := perMessageTokens + estimateTokens(flattenContent(m["content"]))
+	if tc, ok := m["tool_calls"]; ok {
+		b, _ := json.Marshal(tc)
+		n += estimateTokens(string(b))
+	}
+	return n
+}
+
+// contextWindow returns the context length the request will run with:
+// options.num_ctx, else OLLAMA_CONTEXT_LENGTH, else the model's own (/api/show
+// on the server the request goes to).
+func (s *Server) contextWindow(r *http.Request, req map[string]interface{}) int {
+	if options, ok := req["options"].(map[string]interface{}); ok {
+		if n := intParam(options["num_ctx"]); n > 0 {
+			return n
+		}
+	}
+	if s.config.ContextLength > 0 {
+		return s.config.ContextLength
+	}
+	model, _ := req["model"].(string)
+	if show := s.showModel(s.upstreamFor(r, "chat", req), model); show != nil {
+		return show.ContextLength()
+	}
+	return 0
+}
+
+// fitContextWindow drops (or, with CONTEXT_TRUNCATION=summarize, summarizes)
+// the oldest non-system messages of an Ollama chat request so the estimated
+// prompt plus the output reserve fits the context window. System messages
+// and the latest message are always kept. Must run before the response
+// status is written, as it reports through X-Context-* headers.
+func (s *Server) fitContextWindow(w http.ResponseWriter, r *http.Request, req map[string]interface{}) {
+	mode := strings.ToLower(s.config.ContextTruncation)
+	if mode == "" || mode == "off" || mode == "false" {
+		return
+	}
+	msgs := messagesOf(req["messages"])
+	if len(msgs) < 2 {
+		return
+	}
+	window := s.contextWindow(r, req)
+	if window <= 0 {
+		return
+	}
+
+	reserve := s.config.ContextReserveTokens
+	if options, ok := req["options"].(map[string]interface{}); ok {
+		if n := intParam(options["num_predict"]); n > 0 {
+			reserve = n
+		}
+	}
+	if reserve > window/2 {
+		reserve = window / 2
+	}
+	budget := window - reserve
+	if tools, ok := req["tools"]; ok {
+		b, _ := json.Marshal(tools)
+		budget -= estimateTokens(string(b))
+	}
+
+	tokens := make([]int, len(msgs))
+	total := 0
+	for i, m := range msgs {
+		if mm, ok := m.(map[string]interface{}); ok {
+			tokens[i] = messageTokens(mm)
+		}
+		total += tokens[i]
+	}
+	if total <= budget {
+		return
+	}
+
+	// Drop oldest droppable messages until we fit; a tool result whose
+	// assistant tool_calls message was dropped goes with it.
+	drop := make([]bool, len(msgs))
+	dropped := 0
+	last := len(msgs) - 1
+	for i := 0; i < last && total > budget; i++ {
+		if roleOf(msgs[i]) == "system" {
+			continue
+		}
+		drop[i] = true
+		dropped++
+		total -= tokens[i]
+		for i+1 < last && roleOf(msgs[i+1]) == "tool" {
+			i++
+			drop[i] = true
+			dropped++
+			total -= tokens[i]
+		}
+	}
+	if dropped == 0 {
+		return
+	}
+
+	kept := make([]interface{}, 0, len(msgs)-dropped+1)
+	var removed []interface{}
+	for i, m := range msgs {
+		if drop[i] {
+			removed = append(removed, m)
+		} else {
+			kept = append(kept, m)
+		}
+	}
+
+	if mode == "summarize" {
+		if summary := s.summarizeMessages(r, req, removed, window); summary != "" {
+			// Insert after the leading system messages.
+			at := 0
+			for at < len(kept) && roleOf(kept[at]) == "system" {
+				at++
+			}
+			note := map[string]interface{}{"role": "system", "content": "Summary of the earlier conversation: " + summary}
+			kept = append(kept[:at], append([]interface{}{note}, kept[at:]...)...)
+			w.Header().Set("X-Context-Summarized", strconv.Itoa(dropped))
+		}
+	}
+
+	req["messages"] = kept
+	w.Header().Set("X-Context-Truncated", strconv.Itoa(dropped))
+	w.Header().Set("X-Context-Window", strconv.Itoa(window))
+	log.Printf(">>> Context window %d (reserve %d): dropped %d oldest messages, ~%d prompt tokens remain (mode=%s) <<<",
+		window, reserve, dropped, total, mode)
+}
+
+func roleOf(m interface{}) string {
+	if mm, ok := m.(map[string]interface{}); ok {
+		role, _ := mm["role"].(string)
+		return role
+	}
+	return ""
+}
+
+// summarizeMessages asks the model, on the server the request goes to, for a
+// short summary of messages that no longer fit. Returns "" on any failure
+// (the caller then just truncates).
+func (s *Server) summarizeMessages(r *http.Request, req map[string]interface{}, msgs []interface{}, window int) string {
+	var transcript strings.Builder
+	for _, m := range msgs {
+		mm, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", roleOf(m), flattenContent(mm["content"]))
+	}
+	text := transcript.String()
+	// Keep the transcript itself within about half the window (newest part).
+	if maxChars := window * 2; len(text) > maxChars {
+		text = text[len(text)-maxChars:]
+		for len(text) > 0 && !utf8.RuneStart(text[0]) {
+			text = text[1:]
+		}
+	}
+
+	summaryReq := map[string]interface{}{
+		"model": req["model"],
+		"messages": []map[string]interface{}{
+			{"role": "system", "content": "Summarize the following conversation concisely. Keep facts, names, numbers, decisions and open questions. Reply with the summary only."},
+			{"role": "user", "content": text},
+		},
+		"stream":  false,
+		"options": map[string]interface{}{"num_predict": 256, "num_ctx": window},
+	}
+	body, _ := json.Marshal(summaryReq)
+	resp, err := s.upstreamFor(r, "chat", req).ProxyRequest("POST", "/api/chat", bytes.NewReader(body),
+		map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		log.Printf("Warning: context summarization failed: %v", err)
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Warning: context summarization failed: %s", resp.Status)
+		return ""
+	}
+	var out struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		log.Printf("Warning: context summarization returned invalid JSON: %v", err)
+		return ""
+	}
+	return strings.TrimSpace(out.Message.Content)
+}
@@ -0,0 +1,787 @@
+package server_test
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"olares-ollama/proxytest"
+)
+
+func chatRequest(stream bool, content string) map[string]interface{} {
+	return map[string]interface{}{
+		"model":    "gpt-4o",
+		"stream":   stream,
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": content}},
+	}
+}
+
+func choice(t *testing.T, resp map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	choices, _ := resp["choices"].([]interface{})
+	if len(choices) != 1 {
+		t.Fatalf("want 1 choice, got %v", resp["choices"])
+	}
+	return choices[0].(map[string]interface{})
+}
+
+func TestOpenAIChat(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Enqueue(proxytest.Reply{Content: "Hello there", PromptTokens: 7, CompletionTokens: 3})
+
+	status, resp := h.PostJSON("/v1/chat/completions", chatRequest(false, "hi"))
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %v", status, resp)
+	}
+	if resp["object"] != "chat.completion" {
+		t.Errorf("object = %v", resp["object"])
+	}
+	c := choice(t, resp)
+	msg := c["message"].(map[string]interface{})
+	if msg["role"] != "assistant" || msg["content"] != "Hello there" {
+		t.Errorf("message = %v", msg)
+	}
+	if c["finish_reason"] != "stop" {
+		t.Errorf("finish_reason = %v", c["finish_reason"])
+	}
+	usage := resp["usage"].(map[string]interface{})
+	if usage["prompt_tokens"] != 7.0 || usage["completion_tokens"] != 3.0 || usage["total_tokens"] != 10.0 {
+		t.Errorf("usage = %v", usage)
+	}
+
+	up := h.LastUpstream("/api/chat")
+	if up["model"] != proxytest.Model {
+		t.Errorf("upstream model = %v, want the configured %s", up["model"], proxytest.Model)
+	}
+	if up["stream"] != false {
+		t.Errorf("upstream stream = %v", up["stream"])
+	}
+	msgs, _ := up["messages"].([]interface{})
+	if len(msgs) != 1 || msgs[0].(map[string]interface{})["content"] != "hi" {
+		t.Errorf("upstream messages = %v", up["messages"])
+	}
+}
+
+func TestOpenAIChatContentParts(t *testing.T) {
+	h := proxytest.New(t, nil)
+	req := chatRequest(false, "")
+	req["messages"] = []interface{}{map[string]interface{}{
+		"role": "user",
+		"content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "first"},
+			map[string]interface{}{"type": "text", "text": "second"},
+		},
+	}}
+	if status, resp := h.PostJSON("/v1/chat/completions", req); status != http.StatusOK {
+		t.Fatalf("status %d: %v", status, resp)
+	}
+	msgs := h.LastUpstream("/api/chat")["messages"].([]interface{})
+	content, _ := msgs[0].(map[string]interface{})["content"].(string)
+	if !strings.Contains(content, "first") || !strings.Contains(content, "second") {
+		t.Errorf("flattened content = %q", content)
+	}
+}
+
+func TestOpenAIChatStream(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Enqueue(proxytest.Reply{Content: "one two three", PromptTokens: 4, CompletionTokens: 3})
+
+	req := chatRequest(true, "count")
+	req["stream_options"] = map[string]interface{}{"include_usage": true}
+	resp := h.Do(http.MethodPost, "/v1/chat/completions", req)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	events := proxytest.DecodeSSE(t, resp.Body)
+	if len(events) == 0 || events[len(events)-1].Data != "[DONE]" {
+		t.Fatalf("stream does not end with [DONE]: %v", events)
+	}
+
+	var text, finish string
+	var usage map[string]interface{}
+	for _, ev := range events {
+		if ev.JSON == nil {
+			continue
+		}
+		if ev.JSON["object"] != "chat.completion.chunk" {
+			t.Errorf("object = %v", ev.JSON["object"])
+		}
+		if u, ok := ev.JSON["usage"].(map[string]interface{}); ok {
+			usage = u
+		}
+		for _, c := range ev.JSON["choices"].([]interface{}) {
+			c := c.(map[string]interface{})
+			if delta, ok := c["delta"].(map[string]interface{}); ok {
+				s, _ := delta["content"].(string)
+				text += s
+			}
+			if f, ok := c["finish_reason"].(string); ok {
+				finish = f
+			}
+		}
+	}
+	if text != "one two three" {
+		t.Errorf("streamed text = %q", text)
+	}
+	if finish != "stop" {
+		t.Errorf("finish_reason = %q", finish)
+	}
+	if usage == nil || usage["prompt_tokens"] != 4.0 || usage["completion_tokens"] != 3.0 {
+		t.Errorf("usage chunk = %v", usage)
+	}
+}
+
+// A runner that ignores num_predict is cut off by the proxy's token budget.
+func TestOpenAIChatStreamTokenBudget(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Enqueue(proxytest.Reply{Content: "one two three four", CompletionTokens: 4})
+
+	req := chatRequest(true, "count")
+	req["max_tokens"] = 2
+	req["stream_options"] = map[string]interface{}{"include_usage": true}
+	events := proxytest.DecodeSSE(t, h.Do(http.MethodPost, "/v1/chat/completions", req).Body)
+
+	var text, finish string
+	var usage map[string]interface{}
+	for _, ev := range events {
+		if u, ok := ev.JSON["usage"].(map[string]interface{}); ok {
+			usage = u
+		}
+		choices, _ := ev.JSON["choices"].([]interface{})
+		for _, c := range choices {
+			c := c.(map[string]interface{})
+			if delta, ok := c["delta"].(map[string]interface{}); ok {
+				s, _ := delta["content"].(string)
+				text += s
+			}
+			if f, ok := c["finish_reason"].(string); ok {
+				finish = f
+			}
+		}
+	}
+	if text != "one two " || finish != "length" {
+		t.Errorf("streamed %q with finish_reason %q, want 2 tokens and length", text, finish)
+	}
+	if usage == nil || usage["completion_tokens"] != 2.0 {
+		t.Errorf("usage chunk = %v, want the budget as completion_tokens", usage)
+	}
+	options, _ := h.LastUpstream("/api/chat")["options"].(map[string]interface{})
+	if options["num_predict"] != 2.0 {
+		t.Errorf("upstream num_predict = %v, want max_tokens", options["num_predict"])
+	}
+}
+
+func TestOpenAIChatToolCalls(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Enqueue(proxytest.Reply{ToolCalls: []proxytest.ToolCall{
+		{Name: "get_weather", Arguments: map[string]interface{}{"city": "Paris"}},
+	}})
+
+	req := chatRequest(false, "weather in Paris?")
+	req["tools"] = []interface{}{map[string]interface{}{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":       "get_weather",
+			"parameters": map[string]interface{}{"type": "object"},
+		},
+	}}
+	status, resp := h.PostJSON("/v1/chat/completions", req)
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %v", status, resp)
+	}
+	c := choice(t, resp)
+	if c["finish_reason"] != "tool_calls" {
+		t.Errorf("finish_reason = %v", c["finish_reason"])
+	}
+	msg := c["message"].(map[string]interface{})
+	if msg["content"] != nil {
+		t.Errorf("content = %v, want null", msg["content"])
+	}
+	calls, _ := msg["tool_calls"].([]interface{})
+	if len(calls) != 1 {
+		t.Fatalf("tool_calls = %v", msg["tool_calls"])
+	}
+	call := calls[0].(map[string]interface{})
+	fn := call["function"].(map[string]interface{})
+	if call["type"] != "function" || fn["name"] != "get_weather" || fn["arguments"] != `{"city":"Paris"}` {
+		t.Errorf("tool call = %v", call)
+	}
+	if id, _ := call["id"].(string); id == "" {
+		t.Error("tool call has no id")
+	}
+	if _, ok := h.LastUpstream("/api/chat")["tools"]; !ok {
+		t.Error("tools not forwarded upstream")
+	}
+}
+
+func TestOpenAIChatToolChoice(t *testing.T) {
+	tool := func(name string) interface{} {
+		return map[string]interface{}{"type": "function", "function": map[string]interface{}{
+			"name": name, "parameters": map[string]interface{}{"type": "object"},
+		}}
+	}
+	for _, tc := range []struct {
+		choice interface{}
+		want   []string
+	}{
+		{"auto", []string{"get_weather", "get_time"}},
+		{"none", nil},
+		{map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "get_time"}}, []string{"get_time"}},
+	} {
+		h := proxytest.New(t, nil)
+		req := chatRequest(false, "what time is it?")
+		req["tools"] = []interface{}{tool("get_weather"), tool("get_time")}
+		req["tool_choice"] = tc.choice
+		if status, resp := h.PostJSON("/v1/chat/completions", req); status != http.StatusOK {
+			t.Fatalf("tool_choice %v: status %d: %v", tc.choice, status, resp)
+		}
+		tools, _ := h.LastUpstream("/api/chat")["tools"].([]interface{})
+		var got []string
+		for _, t := range tools {
+			got = append(got, t.(map[string]interface{})["function"].(map[string]interface{})["name"].(string))
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("tool_choice %v: upstream tools = %v, want %v", tc.choice, got, tc.want)
+		}
+	}
+
+	h := proxytest.New(t, nil)
+	req := chatRequest(false, "what time is it?")
+	req["tools"] = []interface{}{tool("get_weather")}
+	req["tool_choice"] = "required"
+	resp := h.Do(http.MethodPost, "/v1/chat/completions", req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("tool_choice required: status %d", resp.StatusCode)
+	}
+	if w := resp.Header.Get("X-Proxy-Warnings"); !strings.Contains(w, "tool_choice=required") {
+		t.Errorf("tool_choice required: X-Proxy-Warnings = %q, want it noted as not enforced", w)
+	}
+
+	req["tool_choice"] = map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "get_time"}}
+	status, body := h.PostJSON("/v1/chat/completions", req)
+	errObj, _ := body["error"].(map[string]interface{})
+	if status != http.StatusBadRequest || errObj["code"] != "invalid_request" {
+		t.Errorf("tool_choice naming a function not in tools: status %d: %v, want 400 invalid_request", status, body)
+	}
+}
+
+func TestOpenAIChatResponseFormat(t *testing.T) {
+	schema := map[string]interface{}{"type": "object", "properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}}}
+	for _, tc := range []struct {
+		format interface{}
+		want   interface{}
+	}{
+		{map[string]interface{}{"type": "json_object"}, "json"},
+		{map[string]interface{}{"type": "json_schema", "json_schema": map[string]interface{}{"name": "city", "schema": schema}}, schema},
+		{map[string]interface{}{"type": "text"}, nil},
+	} {
+		h := proxytest.New(t, nil)
+		req := chatRequest(false, "where?")
+		req["response_format"] = tc.format
+		if status, resp := h.PostJSON("/v1/chat/completions", req); status != http.StatusOK {
+			t.Fatalf("response_format %v: status %d: %v", tc.format, status, resp)
+		}
+		if got := h.LastUpstream("/api/chat")["format"]; !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("response_format %v: upstream format = %v, want %v", tc.format, got, tc.want)
+		}
+	}
+}
+
+func TestOpenAIChatMaxTokens(t *testing.T) {
+	for _, tc := range []struct {
+		params map[string]interface{}
+		want   interface{}
+	}{
+		{map[string]interface{}{"max_tokens": 20}, 20.0},
+		{map[string]interface{}{"max_completion_tokens": 30, "max_tokens": 20}, 30.0},
+		{nil, nil},
+	} {
+		h := proxytest.New(t, nil)
+		req := chatRequest(false, "hi")
+		for k, v := range tc.params {
+			req[k] = v
+		}
+		if status, resp := h.PostJSON("/v1/chat/completions", req); status != http.StatusOK {
+			t.Fatalf("%v: status %d: %v", tc.params, status, resp)
+		}
+		options, _ := h.LastUpstream("/api/chat")["options"].(map[string]interface{})
+		if got := options["num_predict"]; got != tc.want {
+			t.Errorf("%v: upstream num_predict = %v, want %v", tc.params, got, tc.want)
+		}
+	}
+}
+
+func TestOpenAIChatToolResultMessages(t *testing.T) {
+	h := proxytest.New(t, nil)
+	req := chatRequest(false, "")
+	req["messages"] = []interface{}{
+		map[string]interface{}{"role": "user", "content": "weather?"},
+		map[string]interface{}{"role": "assistant", "content": nil, "tool_calls": []interface{}{
+			map[string]interface{}{"id": "call_1", "type": "function", "function": map[string]interface{}{
+				"name": "get_weather", "arguments": `{"city":"Paris"}`,
+			}},
+		}},
+		map[string]interface{}{"role": "tool", "tool_call_id": "call_1", "content": "sunny"},
+	}
+	if status, resp := h.PostJSON("/v1/chat/completions", req); status != http.StatusOK {
+		t.Fatalf("status %d: %v", status, resp)
+	}
+	msgs := h.LastUpstream("/api/chat")["messages"].([]interface{})
+	if len(msgs) != 3 {
+		t.Fatalf("upstream messages = %v", msgs)
+	}
+	calls, _ := msgs[1].(map[string]interface{})["tool_calls"].([]interface{})
+	if len(calls) != 1 {
+		t.Fatalf("assistant tool_calls = %v", msgs[1])
+	}
+	args := calls[0].(map[string]interface{})["function"].(map[string]interface{})["arguments"]
+	if m, ok := args.(map[string]interface{}); !ok || m["city"] != "Paris" {
+		t.Errorf("arguments = %#v, want an object for Ollama", args)
+	}
+	if tool := msgs[2].(map[string]interface{}); tool["role"] != "tool" || tool["content"] != "sunny" {
+		t.Errorf("tool message = %v", tool)
+	}
+}
+
+func TestOpenAIChatStreamToolCalls(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Enqueue(proxytest.Reply{ToolCalls: []proxytest.ToolCall{
+		{Name: "lookup", Arguments: map[string]interface{}{"q": "go"}},
+	}})
+
+	resp := h.Do(http.MethodPost, "/v1/chat/completions", chatRequest(true, "search"))
+	var name, args, finish string
+	for _, ev := range proxytest.DecodeSSE(t, resp.Body) {
+		if ev.JSON == nil {
+			continue
+		}
+		for _, c := range ev.JSON["choices"].([]interface{}) {
+			c := c.(map[string]interface{})
+			if f, ok := c["finish_reason"].(string); ok {
+				finish = f
+			}
+			delta, _ := c["delta"].(map[string]interface{})
+			calls, _ := delta["tool_calls"].([]interface{})
+			for _, tc := range calls {
+				fn := tc.(map[string]interface{})["function"].(map[string]interface{})
+				if n, _ := fn["name"].(string); n != "" {
+					name = n
+				}
+				a, _ := fn["arguments"].(string)
+				args += a
+			}
+		}
+	}
+	if name != "lookup" || args != `{"q":"go"}` {
+		t.Errorf("streamed tool call = %s(%s)", name, args)
+	}
+	if finish != "tool_calls" {
+		t.Errorf("finish_reason = %q", finish)
+	}
+}
+
+func TestOpenAIChatUpstreamError(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Enqueue(proxytest.Reply{Status: http.StatusNotFound, Error: `model "test-model" not found, try pulling it first`})
+
+	status, resp := h.PostJSON("/v1/chat/completions", chatRequest(false, "hi"))
+	if status != http.StatusNotFound {
+		t.Errorf("status = %d", status)
+	}
+	e, ok := resp["error"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("want an OpenAI error object, got %v", resp)
+	}
+	if msg, _ := e["message"].(string); !strings.Contains(msg, "not found") {
+		t.Errorf("error message = %q", msg)
+	}
+	if e["code"] != "model_not_found" {
+		t.Errorf("code = %v", e["code"])
+	}
+
+	// Only Ollama's model "..." not found wording is a missing model.
+	h.Ollama.Enqueue(proxytest.Reply{Status: http.StatusInternalServerError, Error: "open /models/blobs/sha256-abc: file not found"})
+	_, resp = h.PostJSON("/v1/chat/completions", chatRequest(false, "hi"))
+	if code := resp["error"].(map[string]interface{})["code"]; code != "upstream_error" {
+		t.Errorf("a missing blob: code = %v, want upstream_error", code)
+	}
+}
+
+func TestOpenAICompletions(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Enqueue(proxytest.Reply{Content: "world", PromptTokens: 2, CompletionTokens: 1})
+
+	status, resp := h.PostJSON("/v1/completions", map[string]interface{}{"model": "x", "prompt": "hello"})
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %v", status, resp)
+	}
+	if resp["object"] != "text_completion" {
+		t.Errorf("object = %v", resp["object"])
+	}
+	if c := choice(t, resp); c["text"] != "world" || c["finish_reason"] != "stop" {
+		t.Errorf("choice = %v", c)
+	}
+	if up := h.LastUpstream("/api/generate"); up["prompt"] != "hello" || up["model"] != proxytest.Model {
+		t.Errorf("upstream request = %v", up)
+	}
+}
+
+func TestOpenAICompletionsStream(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Enqueue(proxytest.Reply{Content: "a b c"})
+
+	resp := h.Do(http.MethodPost, "/v1/completions", map[string]interface{}{"prompt": "x", "stream": true})
+	events := proxytest.DecodeSSE(t, resp.Body)
+	var text string
+	for _, ev := range events {
+		if ev.JSON == nil {
+			continue
+		}
+		for _, c := range ev.JSON["choices"].([]interface{}) {
+			s, _ := c.(map[string]interface{})["text"].(string)
+			text += s
+		}
+	}
+	if text != "a b c" {
+		t.Errorf("streamed text = %q", text)
+	}
+	if events[len(events)-1].Data != "[DONE]" {
+		t.Errorf("last event = %v", events[len(events)-1])
+	}
+}
+
+func TestOpenAICompletionsEchoSuffixUsage(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Enqueue(proxytest.Reply{Content: " return a + b", PromptTokens: 5, CompletionTokens: 4})
+
+	resp := h.Do(http.MethodPost, "/v1/completions", map[string]interface{}{
+		"prompt": "def add(a, b):", "suffix": "\n\nprint(add(1, 2))", "echo": true, "stream": true,
+		"stream_options": map[string]interface{}{"include_usage": true},
+	})
+	var text string
+	var usage map[string]interface{}
+	for _, ev := range proxytest.DecodeSSE(t, resp.Body) {
+		if ev.JSON == nil {
+			continue
+		}
+		if u, ok := ev.JSON["usage"].(map[string]interface{}); ok {
+			usage = u
+		}
+		for _, c := range ev.JSON["choices"].([]interface{}) {
+			s, _ := c.(map[string]interface{})["text"].(string)
+			text += s
+		}
+	}
+	if text != "def add(a, b): return a + b" {
+		t.Errorf("streamed text = %q, want the prompt echoed first", text)
+	}
+	if usage == nil || usage["prompt_tokens"] != 5.0 || usage["completion_tokens"] != 4.0 {
+		t.Errorf("usage chunk = %v", usage)
+	}
+	if up := h.LastUpstream("/api/generate"); up["suffix"] != "\n\nprint(add(1, 2))" {
+		t.Errorf("upstream suffix = %v", up["suffix"])
+	}
+}
+
+func TestResponses(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Enqueue(proxytest.Reply{Content: "Bonjour", PromptTokens: 5, CompletionTokens: 1})
+
+	status, resp := h.PostJSON("/v1/responses", map[string]interface{}{
+		"model": "x", "instructions": "Answer in French.", "input": "Hello",
+	})
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %v", status, resp)
+	}
+	if resp["object"] != "response" || resp["status"] != "completed" {
+		t.Errorf("response = %v", resp)
+	}
+	output, _ := resp["output"].([]interface{})
+	if len(output) == 0 {
+		t.Fatalf("output = %v", resp["output"])
+	}
+	parts := output[0].(map[string]interface{})["content"].([]interface{})
+	if text := parts[0].(map[string]interface{})["text"]; text != "Bonjour" {
+		t.Errorf("output text = %v", text)
+	}
+
+	msgs := h.LastUpstream("/api/chat")["messages"].([]interface{})
+	first := msgs[0].(map[string]interface{})
+	last := msgs[len(msgs)-1].(map[string]interface{})
+	if first["role"] != "system" || first["content"] != "Answer in French." {
+		t.Errorf("instructions not sent as the system message: %v", msgs)
+	}
+	if last["role"] != "user" || last["content"] != "Hello" {
+		t.Errorf("input not sent as the user message: %v", msgs)
+	}
+}
+
+func TestResponsesStream(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Enqueue(proxytest.Reply{Content: "one two"})
+
+	resp := h.Do(http.MethodPost, "/v1/responses", map[string]interface{}{"input": "x", "stream": true})
+	var text string
+	completed := false
+	for _, ev := range proxytest.DecodeSSE(t, resp.Body) {
+		if ev.JSON == nil {
+			continue
+		}
+		switch ev.JSON["type"] {
+		case "response.output_text.delta":
+			s, _ := ev.JSON["delta"].(string)
+			text += s
+		case "response.completed":
+			completed = true
+		}
+	}
+	if text != "one two" {
+		t.Errorf("streamed text = %q", text)
+	}
+	if !completed {
+		t.Error("no response.completed event")
+	}
+}
+
+func TestOllamaChatPassthrough(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Enqueue(proxytest.Reply{Content: "x y", Thinking: "hmm"})
+
+	resp := h.Do(http.MethodPost, "/api/chat", chatRequest(true, "hi"))
+	lines := proxytest.DecodeNDJSON(t, resp.Body)
+	var text, thinking string
+	for _, l := range lines {
+		msg, _ := l["message"].(map[string]interface{})
+		s, _ := msg["content"].(string)
+		text += s
+		th, _ := msg["thinking"].(string)
+		thinking += th
+	}
+	if text != "x y" || thinking != "hmm" {
+		t.Errorf("content %q, thinking %q", text, thinking)
+	}
+	if done, _ := lines[len(lines)-1]["done"].(bool); !done {
+		t.Error("stream does not end with done:true")
+	}
+	if up := h.LastUpstream("/api/chat"); up["model"] != proxytest.Model {
+		t.Errorf("upstream model = %v", up["model"])
+	}
+}
+
+func TestOpenAIEmbeddings(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.SetCapabilities("embedding")
+	status, resp := h.PostJSON("/v1/embeddings", map[string]interface{}{"model": "x", "input": []interface{}{"a", "b"}})
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %v", status, resp)
+	}
+	data, _ := resp["data"].([]interface{})
+	if len(data) != 2 {
+		t.Fatalf("data = %v", resp["data"])
+	}
+	for i, d := range data {
+		d := d.(map[string]interface{})
+		vec, _ := d["embedding"].([]interface{})
+		if d["index"] != float64(i) || len(vec) != 4 {
+			t.Errorf("data[%d] = %v", i, d)
+		}
+	}
+}
+
+func TestOpenAIEmbeddingsBase64(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.SetCapabilities("embedding")
+	_, floats := h.PostJSON("/v1/embeddings", map[string]interface{}{"model": "x", "input": "a"})
+	status, resp := h.PostJSON("/v1/embeddings", map[string]interface{}{"model": "x", "input": "a", "encoding_format": "base64"})
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %v", status, resp)
+	}
+	data, _ := resp["data"].([]interface{})
+	if len(data) != 1 {
+		t.Fatalf("data = %v", resp["data"])
+	}
+	encoded, _ := data[0].(map[string]interface{})["embedding"].(string)
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != 16 {
+		t.Fatalf("embedding = %q (%v)", encoded, err)
+	}
+	want := floats["data"].([]interface{})[0].(map[string]interface{})["embedding"].([]interface{})
+	for i := range want {
+		got := math.Float32frombits(binary.LittleEndian.Uint32(raw[4*i:]))
+		if got != float32(want[i].(float64)) {
+			t.Errorf("component %d = %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestCredentialsNotForwarded(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.PostJSON("/v1/chat/completions", chatRequest(false, "hi"), "Authorization", "Bearer secret", "X-Trace", "1")
+	req, _ := h.Ollama.LastRequest("/api/chat")
+	if req.Header.Get("Authorization") != "" {
+		t.Error("Authorization forwarded to Ollama")
+	}
+	if req.Header.Get("X-Trace") != "1" {
+		t.Error("X-Trace not forwarded to Ollama")
+	}
+}
+
+func TestAnthropicMessages(t *testing.T) {
+	h := proxytest.New(t, nil)
+	h.Ollama.Handle("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":1}}`))
+	})
+
+	status, resp := h.PostJSON("/v1/messages", map[string]interface{}{
+		"model": "claude-sonnet", "max_tokens": 64,
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": "hello"}},
+	}, "x-api-key", "sk-ant", "anthropic-version", "2023-06-01")
+	if status != http.StatusOK || resp["type"] != "message" {
+		t.Fatalf("status %d: %v", status, resp)
+	}
+	req, _ := h.Ollama.LastRequest("/v1/messages")
+	if req.Body["model"] != proxytest.Model {
+		t.Errorf("upstream model = %v", req.Body["model"])
+	}
+	if req.Header.Get("X-Api-Key") != "" {
+		t.Error("x-api-key forwarded to Ollama")
+	}
+	if req.Header.Get("Anthropic-Version") != "2023-06-01" {
+		t.Error("anthropic-version not forwarded to Ollama")
+	}
+}
+
+// /v1/messages runs through the same pipeline as the other inference
+// endpoints: user routes, the generation policy, prompt templates.
+func TestAnthropicMessagesPipeline(t *testing.T) {
+	h := proxytest.New(t, map[string]string{"MAX_TOKENS_CAP": "100", "GLOBAL_STOP_SEQUENCES": "<|end|>"})
+	h.Ollama.Handle("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":1}}`))
+	})
+	if resp := h.Do(http.MethodPut, "/admin/user-routes/user:alice", map[string]interface{}{"model": "kids-model"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("user route: status %d", resp.StatusCode)
+	}
+
+	status, resp := h.PostJSON("/v1/messages", map[string]interface{}{
+		"model": "claude-sonnet", "max_tokens": 4096, "system": "Be brief.", "stop_sequences": []interface{}{"END"},
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": "hello"}},
+	}, "X-Bfl-User", "alice")
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %v", status, resp)
+	}
+	req, _ := h.Ollama.LastRequest("/v1/messages")
+	if req.Body["model"] != "kids-model" {
+		t.Errorf("upstream model = %v, want the user route's", req.Body["model"])
+	}
+	if req.Body["max_tokens"] != 100.0 {
+		t.Errorf("upstream max_tokens = %v, want MAX_TOKENS_CAP", req.Body["max_tokens"])
+	}
+	if got := req.Body["stop_sequences"]; !reflect.DeepEqual(got, []interface{}{"END", "<|end|>"}) {
+		t.Errorf("upstream stop_sequences = %v", got)
+	}
+	if req.Body["system"] != "Be brief." {
+		t.Errorf("upstream system = %v", req.Body["system"])
+	}
+	if _, has := req.Body["options"]; has {
+		t.Errorf("upstream request has options: %v", req.Body)
+	}
+	msgs, _ := req.Body["messages"].([]interface{})
+	if len(msgs) != 1 || roleOf(msgs[0]) != "user" {
+		t.Errorf("upstream messages = %v, want the user message only", msgs)
+	}
+}
+
+func roleOf(m interface{}) string {
+	role, _ := m.(map[string]interface{})["role"].(string)
+	return role
+}
+
+// An async job is visible only to the tenant that submitted it.
+func TestAsyncJobsPerTenant(t *testing.T) {
+	h := proxytest.New(t, map[string]string{"ENABLE_ASYNC_JOBS": "true"})
+	status, job := h.PostJSON("/api/async/chat", chatRequest(false, "hi"), "X-Bfl-User", "alice")
+	if status != http.StatusAccepted {
+		t.Fatalf("submit: status %d: %v", status, job)
+	}
+	path := "/api/async/" + job["id"].(string)
+
+	if status, resp := h.GetJSON(path+"?wait=5", "X-Bfl-User", "alice"); status != http.StatusOK {
+		t.Errorf("owner get: status %d: %v", status, resp)
+	}
+	if status, _ := h.GetJSON(path, "X-Bfl-User", "bob"); status != http.StatusNotFound {
+		t.Errorf("other tenant get: status %d, want 404", status)
+	}
+	if status, _ := h.GetJSON(path); status != http.StatusNotFound {
+		t.Errorf("anonymous get: status %d, want 404", status)
+	}
+	if _, resp := h.GetJSON("/api/async", "X-Bfl-User", "bob"); len(resp["jobs"].([]interface{})) != 0 {
+		t.Errorf("other tenant lists %v", resp["jobs"])
+	}
+	if _, resp := h.GetJSON("/api/async", "X-Bfl-User", "alice"); len(resp["jobs"].([]interface{})) != 1 {
+		t.Errorf("owner lists %v, want its job", resp["jobs"])
+	}
+	if resp := h.Do(http.MethodDelete, path, nil, "X-Bfl-User", "bob"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("other tenant cancel: status %d, want 404", resp.StatusCode)
+	}
+	if resp := h.Do(http.MethodDelete, path, nil, "X-Bfl-User", "alice"); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("owner delete: status %d, want 204", resp.StatusCode)
+	}
+}
+
+// A tenant's default max_tokens is applied after fast-lane classification:
+// a request the client left uncapped is not sent to the fast lane.
+func TestTenantMaxTokensAfterFastLane(t *testing.T) {
+	h := proxytest.New(t, map[string]string{"FAST_LANE_MAX_TOKENS": "100"})
+	if resp := h.Do(http.MethodPut, "/admin/tenant-limits/user:alice", map[string]interface{}{"max_tokens": 50}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("tenant limit: status %d", resp.StatusCode)
+	}
+
+	resp := h.Do(http.MethodPost, "/api/chat", chatRequest(false, "write an essay about rivers"), "X-Bfl-User", "alice")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if lane := resp.Header.Get("X-Proxy-Lane"); lane != "" {
+		t.Errorf("X-Proxy-Lane = %q, want the main lane", lane)
+	}
+	options, _ := h.LastUpstream("/api/chat")["options"].(map[string]interface{})
+	if options["num_predict"] != 50.0 {
+		t.Errorf("upstream num_predict = %v, want the tenant's 50", options["num_predict"])
+	}
+}
+
+// "chat history" marks a UI task prompt only under a "### Task:" header.
+func TestFastLaneTaskMarkers(t *testing.T) {
+	h := proxytest.New(t, nil)
+	for prompt, want := range map[string]string{
+		"Can you go through my chat history with Bob and list what I promised him?":                   "",
+		"### Task:\nGenerate 1-3 broad tags for the conversation.\n### Chat History:\n<chat_history>": "fast",
+	} {
+		resp := h.Do(http.MethodPost, "/api/chat", chatRequest(false, prompt))
+		if lane := resp.Header.Get("X-Proxy-Lane"); lane != want {
+			t.Errorf("%q: X-Proxy-Lane = %q, want %q", prompt, lane, want)
+		}
+	}
+}
+
+// On the public port pprof needs the admin role, and is not mounted at all
+// without ADMIN_TOKEN.
+func TestPprofOnPublicPort(t *testing.T) {
+	h := proxytest.New(t, map[string]string{"ENABLE_PPROF": "true", "ADMIN_TOKEN": "secret"})
+	if resp := h.Do(http.MethodGet, "/debug/pprof/cmdline", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without a token: status %d, want 401", resp.StatusCode)
+	}
+	if resp := h.Do(http.MethodGet, "/debug/pprof/cmdline", nil, "X-Admin-Token", "secret"); resp.StatusCode != http.StatusOK {
+		t.Errorf("with the admin token: status %d, want 200", resp.StatusCode)
+	}
+
+	h = proxytest.New(t, map[string]string{"ENABLE_PPROF": "true", "ADMIN_TOKEN": ""})
+	if resp := h.Do(http.MethodGet, "/debug/pprof/cmdline", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("without ADMIN_TOKEN: status %d, want 404", resp.StatusCode)
+	}
+}
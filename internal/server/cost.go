@@ -0,0 +1,43 @@
+package server
+
+import (
+	"strings"
+
+	"olares-ollama/internal/config"
+)
+
+// headerCost carries the request's estimated cost (MODEL_COSTS), next to
+// the token usage headers.
+const headerCost = "X-Usage-Cost"
+
+// costTable holds the MODEL_COSTS weights per 1K tokens.
+type costTable map[string]config.ModelCost
+
+func newCostTable(entries []string) costTable {
+	costs, _ := config.ParseModelCosts(entries) // checked by Validate
+	return costTable(costs)
+}
+
+// weights returns the model's weights: its exact name, its name without the
+// tag ("qwen2.5" covers "qwen2.5:7b"), "*", or 1 for prompt and completion.
+func (t costTable) weights(model string) config.ModelCost {
+	if c, ok := t[model]; ok {
+		return c
+	}
+	if base, _, ok := strings.Cut(model, ":"); ok {
+		if c, ok := t[base]; ok {
+			return c
+		}
+	}
+	if c, ok := t["*"]; ok {
+		return c
+	}
+	return config.ModelCost{Prompt: 1, Completion: 1}
+}
+
+// estimate is the cost of a request's tokens on the model. It is a relative
+// figure so people sharing one box can compare consumption, not money.
+func (t costTable) estimate(model string, prompt, completion int) float64 {
+	c := t.weights(model)
+	return (float64(prompt)*c.Prompt + float64(completion)*c.Completion) / 1000
+}
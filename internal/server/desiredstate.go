@@ -0,0 +1,456 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"olares-ollama/internal/ollama"
+)
+
+const (
+	desiredStateTimeout = 30 * time.Second // Ollama list, delete and load calls of a reconcile
+	pinSourceDesired    = "desired-state"  // pins of the keep_warm models
+)
+
+// desiredState is the declarative spec of DESIRED_STATE_FILE or
+// PUT /admin/desired-state: what the Ollama server should look like.
+type desiredState struct {
+	Models   []string          `json:"models"`              // models to have installed
+	Aliases  []*promptTemplate `json:"aliases,omitempty"`   // prompt templates (model aliases) to have, by name
+	KeepWarm []string          `json:"keep_warm,omitempty"` // models to keep loaded; installed too
+	Prune    bool              `json:"prune,omitempty"`     // remove what isn't listed (needs DESIRED_STATE_ALLOW_PRUNE)
+}
+
+// validate checks a spec.
+func (spec *desiredState) validate() error {
+	for _, list := range [][]string{spec.Models, spec.KeepWarm} {
+		for _, m := range list {
+			if strings.TrimSpace(m) == "" {
+				return fmt.Errorf("model names must not be empty")
+			}
+		}
+	}
+	for _, a := range spec.Aliases {
+		if a == nil || strings.TrimSpace(a.Name) == "" || strings.TrimSpace(a.System) == "" {
+			return fmt.Errorf("each alias needs a 'name' and a 'system' prompt")
+		}
+	}
+	return nil
+}
+
+// wanted lists the models the spec wants installed.
+func (spec *desiredState) wanted() []string {
+	models := append([]string{}, spec.Models...)
+	for _, m := range spec.KeepWarm {
+		if !containsModel(models, m) {
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+// driftItem is one difference between the spec and the server, and what
+// the reconcile did about it.
+type driftItem struct {
+	Kind   string `json:"kind"`             // "model", "alias" or "keep_warm"
+	Name   string `json:"name"`             // model or alias name
+	Drift  string `json:"drift"`            // "missing", "unlisted", "changed" or "not_loaded"
+	Action string `json:"action,omitempty"` // what was done; empty = only reported
+	Error  string `json:"error,omitempty"`
+}
+
+// reconcileReport is the outcome of one reconcile (or of a dry run).
+type reconcileReport struct {
+	Time       time.Time   `json:"time"`
+	DryRun     bool        `json:"dry_run,omitempty"`
+	InSync     bool        `json:"in_sync"` // no drift left: none found, or all of it fixed
+	Drift      []driftItem `json:"drift"`
+	Error      string      `json:"error,omitempty"`
+	DurationMs int64       `json:"duration_ms"`
+}
+
+// desiredStateStore holds the spec set via the admin API, persisted to
+// data/desired_state.json, and the last reconcile. With DESIRED_STATE_FILE
+// the file is the spec instead, re-read before every reconcile so a
+// GitOps tool only has to update it.
+type desiredStateStore struct {
+	mu        sync.Mutex
+	spec      *desiredState // admin spec; nil = none
+	last      *reconcileReport
+	reconcile sync.Mutex // one reconcile at a time
+	kick      chan struct{}
+	file      string
+}
+
+func newDesiredStateStore() *desiredStateStore {
+	st := &desiredStateStore{kick: make(chan struct{}, 1), file: filepath.Join("data", "desired_state.json")}
+	st.load()
+	return st
+}
+
+func (st *desiredStateStore) load() {
+	data, err := os.ReadFile(st.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read %s: %v", st.file, err)
+		}
+		st.mu.Lock()
+		st.spec = nil
+		st.mu.Unlock()
+		return
+	}
+	var spec desiredState
+	if err := json.Unmarshal(data, &spec); err != nil {
+		log.Printf("Warning: failed to parse %s: %v", st.file, err)
+		return
+	}
+	st.mu.Lock()
+	st.spec = &spec
+	st.mu.Unlock()
+}
+
+func (st *desiredStateStore) put(spec *desiredState) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	data, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(st.file), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(st.file, data, 0644); err != nil {
+		return err
+	}
+	st.spec = spec
+	return nil
+}
+
+func (st *desiredStateStore) wake() {
+	select {
+	case st.kick <- struct{}{}:
+	default:
+	}
+}
+
+// desiredSpec returns the spec in force and where it comes from ("file"
+// or "admin"); nil when there is none.
+func (s *Server) desiredSpec() (*desiredState, string, error) {
+	if path := s.config.DesiredStateFile; path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, "file", err
+		}
+		var spec desiredState
+		if err := json.Unmarshal(data, &spec); err != nil {
+			return nil, "file", fmt.Errorf("parse %s: %w", path, err)
+		}
+		if err := spec.validate(); err != nil {
+			return nil, "file", fmt.Errorf("%s: %w", path, err)
+		}
+		return &spec, "file", nil
+	}
+	st := s.desired
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.spec == nil {
+		return nil, "", nil
+	}
+	return st.spec, "admin", nil
+}
+
+// runDesiredState reconciles every DESIRED_STATE_INTERVAL_SEC, and at once
+// when the spec is changed via the admin API.
+func (s *Server) runDesiredState() {
+	interval := time.Duration(s.config.DesiredStateIntervalSec) * time.Second
+	for {
+		spec, _, err := s.desiredSpec()
+		switch {
+		case err != nil:
+			log.Printf("!!! Desired state: %v !!!", err)
+			s.desired.mu.Lock()
+			s.desired.last = &reconcileReport{Time: time.Now(), Drift: []driftItem{}, Error: err.Error()}
+			s.desired.mu.Unlock()
+		case spec != nil:
+			report := s.reconcileDesiredState(spec, false)
+			s.desired.mu.Lock()
+			s.desired.last = report
+			s.desired.mu.Unlock()
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-s.desired.kick:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// reconcileDesiredState compares the server with the spec and, unless
+// dryRun, fixes the drift: pulls missing models, creates or updates the
+// aliases, pins and loads the keep_warm models, and with "prune" (when
+// DESIRED_STATE_ALLOW_PRUNE allows it) removes unlisted models and aliases.
+// Models the proxy itself uses (served, fast-lane, hot, routed, pinned...)
+// are never removed.
+func (s *Server) reconcileDesiredState(spec *desiredState, dryRun bool) *reconcileReport {
+	if !dryRun {
+		s.desired.reconcile.Lock()
+		defer s.desired.reconcile.Unlock()
+	}
+	start := time.Now()
+	report := &reconcileReport{Time: start, DryRun: dryRun, Drift: []driftItem{}}
+	prune := spec.Prune && s.config.DesiredStateAllowPrune
+	defer func() {
+		report.DurationMs = time.Since(start).Milliseconds()
+		report.InSync = report.Error == ""
+		for _, d := range report.Drift {
+			if d.Action == "" || d.Error != "" {
+				report.InSync = false
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), desiredStateTimeout)
+	installed, err := s.ollamaClient.ListModels(ctx)
+	var running []string
+	if err == nil {
+		var loaded []ollama.RunningModel
+		loaded, err = s.ollamaClient.RunningModels(ctx)
+		for _, m := range loaded {
+			running = append(running, m.Name)
+		}
+	}
+	cancel()
+	if err != nil {
+		report.Error = "listing models: " + err.Error()
+		return report
+	}
+	installedNames := make([]string, 0, len(installed))
+	for _, m := range installed {
+		installedNames = append(installedNames, m.Name)
+	}
+
+	// Models
+	wanted := spec.wanted()
+	for _, m := range wanted {
+		if containsModel(installedNames, m) {
+			continue
+		}
+		item := driftItem{Kind: "model", Name: m, Drift: "missing"}
+		if !dryRun {
+			if err := s.pullDesiredModel(m); err != nil {
+				item.Error = err.Error()
+			} else {
+				item.Action = "pulled"
+				installedNames = append(installedNames, m)
+			}
+		}
+		report.Drift = append(report.Drift, item)
+	}
+	inUse := s.modelsInUse()
+	for _, m := range installedNames {
+		if containsModel(wanted, m) {
+			continue
+		}
+		item := driftItem{Kind: "model", Name: m, Drift: "unlisted"}
+		switch {
+		case containsModel(inUse, m):
+			continue // the proxy's own, not drift
+		case !prune:
+		case !dryRun:
+			ctx, cancel := context.WithTimeout(context.Background(), desiredStateTimeout)
+			err := s.ollamaClient.DeleteModel(ctx, m)
+			cancel()
+			if err != nil {
+				item.Error = err.Error()
+			} else {
+				item.Action = "removed"
+				log.Printf("WARNING: desired state removed %s, which the spec doesn't list", m)
+				s.events.Record("model_removed", map[string]interface{}{"model": m, "reason": "desired_state"})
+			}
+		}
+		report.Drift = append(report.Drift, item)
+	}
+
+	// Aliases
+	listed := map[string]bool{}
+	for _, a := range spec.Aliases {
+		listed[a.Name] = true
+		item := driftItem{Kind: "alias", Name: a.Name, Drift: "missing"}
+		if cur, ok := s.templates.get(a.Name); ok {
+			if cur.System == a.System && cur.Description == a.Description && cur.Replace == a.Replace {
+				continue
+			}
+			item.Drift = "changed"
+		}
+		if !dryRun {
+			t := *a
+			t.UpdatedAt = time.Now()
+			if err := s.templates.put(&t); err != nil {
+				item.Error = err.Error()
+			} else if item.Action = "created"; item.Drift == "changed" {
+				item.Action = "updated"
+			}
+		}
+		report.Drift = append(report.Drift, item)
+	}
+	for _, t := range s.templates.list() {
+		if listed[t.Name] {
+			continue
+		}
+		item := driftItem{Kind: "alias", Name: t.Name, Drift: "unlisted"}
+		if prune && !dryRun {
+			if _, err := s.templates.delete(t.Name); err != nil {
+				item.Error = err.Error()
+			} else {
+				item.Action = "removed"
+			}
+		}
+		report.Drift = append(report.Drift, item)
+	}
+
+	// Keep-warm: pinned (so nothing unloads them) and loaded
+	if !dryRun {
+		s.syncDesiredPins(spec.KeepWarm)
+	}
+	for _, m := range spec.KeepWarm {
+		if containsModel(running, m) {
+			continue
+		}
+		item := driftItem{Kind: "keep_warm", Name: m, Drift: "not_loaded"}
+		if !dryRun && containsModel(installedNames, m) {
+			ctx, cancel := context.WithTimeout(context.Background(), hotLoadTimeout)
+			if err := s.ollamaClient.SetKeepAlive(ctx, m, -1); err != nil {
+				item.Error = err.Error()
+			} else {
+				item.Action = "loaded"
+			}
+			cancel()
+		}
+		report.Drift = append(report.Drift, item)
+	}
+
+	if !dryRun {
+		changes := 0
+		for _, d := range report.Drift {
+			if d.Action != "" {
+				changes++
+			}
+		}
+		if changes > 0 {
+			log.Printf("Desired state: %d changes made, %d differences found", changes, len(report.Drift))
+			s.events.Record("desired_state_reconciled", map[string]interface{}{"changes": changes, "drift": len(report.Drift)})
+		}
+	}
+	return report
+}
+
+// pullDesiredModel installs a model of the spec: onto the server with the
+// most room with OLLAMA_UPSTREAMS, else onto OLLAMA_URL.
+func (s *Server) pullDesiredModel(model string) error {
+	if s.upstreams != nil {
+		_, err := s.placeModel(model)
+		return err
+	}
+	log.Printf("Desired state: pulling %s", model)
+	start := time.Now()
+	if err := s.ollamaClient.PullModelWithProgress(model, quietProgress{}); err != nil {
+		return err
+	}
+	s.events.Record("model_pulled", map[string]interface{}{"model": model, "reason": "desired_state", "duration_ms": time.Since(start).Milliseconds()})
+	return nil
+}
+
+// syncDesiredPins pins the keep_warm models and unpins those no longer
+// listed. These pins are not persisted; the spec brings them back.
+func (s *Server) syncDesiredPins(keepWarm []string) {
+	st := s.pins
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for m, p := range st.pins {
+		if p.Source == pinSourceDesired && !slices.Contains(keepWarm, m) {
+			delete(st.pins, m)
+		}
+	}
+	for _, m := range keepWarm {
+		if _, ok := st.pins[m]; !ok {
+			st.pins[m] = &pin{Model: m, Source: pinSourceDesired, Note: "keep_warm", PinnedAt: time.Now()}
+		}
+	}
+}
+
+// handleDesiredStateGet handles GET /admin/desired-state: the spec, the
+// drift as of now (a dry run) and the last reconcile.
+func (s *Server) handleDesiredStateGet(w http.ResponseWriter, r *http.Request) {
+	spec, source, err := s.desiredSpec()
+	out := map[string]interface{}{"source": source, "allow_prune": s.config.DesiredStateAllowPrune}
+	if s.config.DesiredStateFile != "" {
+		out["file"] = s.config.DesiredStateFile
+	}
+	if err != nil {
+		out["error"] = err.Error()
+	}
+	if spec != nil {
+		out["spec"] = spec
+		out["drift"] = s.reconcileDesiredState(spec, true)
+	}
+	s.desired.mu.Lock()
+	if s.desired.last != nil {
+		out["last_reconcile"] = s.desired.last
+	}
+	s.desired.mu.Unlock()
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleDesiredStatePut handles PUT /admin/desired-state: replaces the spec
+// and reconciles in the background. Not available with DESIRED_STATE_FILE,
+// which is then the one source of the spec.
+func (s *Server) handleDesiredStatePut(w http.ResponseWriter, r *http.Request) {
+	if s.config.DesiredStateFile != "" {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "desired_state_from_file",
+			"The desired state comes from DESIRED_STATE_FILE ("+s.config.DesiredStateFile+"); change the file instead")
+		return
+	}
+	var spec desiredState
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+		return
+	}
+	if err := spec.validate(); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if err := s.desired.put(&spec); err != nil {
+		log.Printf("!!! Failed to save the desired state: %v !!!", err)
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Failed to save the desired state: "+err.Error())
+		return
+	}
+	s.configChanged(map[string]interface{}{"setting": "desired_state", "value": spec})
+	log.Printf("Desired state set: %d models, %d aliases, %d kept warm", len(spec.Models), len(spec.Aliases), len(spec.KeepWarm))
+	s.desired.wake()
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "reconciling", "spec": &spec})
+}
+
+// handleDesiredStateReconcile handles POST /admin/desired-state/reconcile:
+// reconciles now, in the background (the report is in GET).
+func (s *Server) handleDesiredStateReconcile(w http.ResponseWriter, r *http.Request) {
+	if spec, _, err := s.desiredSpec(); spec == nil {
+		msg := "No desired state: set DESIRED_STATE_FILE or PUT /admin/desired-state"
+		if err != nil {
+			msg = err.Error()
+		}
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "no_desired_state", msg)
+		return
+	}
+	s.desired.wake()
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "reconciling"})
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusAwaitingConfirmation is the progress status of a pull waiting for
+// POST /api/downloads/{model}/confirm.
+const statusAwaitingConfirmation = "awaiting_confirmation"
+
+// ErrDownloadDeclined is returned by ConfirmDownload when the download was
+// declined.
+var ErrDownloadDeclined = errors.New("download declined")
+
+// pendingDownload is a pull waiting for a confirmation.
+type pendingDownload struct {
+	Model       string    `json:"model"`
+	Size        int64     `json:"size"` // bytes Ollama doesn't have yet
+	RequestedAt time.Time `json:"requested_at"`
+	decision    chan bool // true = confirmed
+}
+
+// downloadGate holds the pulls waiting for a confirmation, by model.
+type downloadGate struct {
+	mu      sync.Mutex
+	pending map[string]*pendingDownload
+}
+
+// decide answers the pending download of model, if there is one.
+func (g *downloadGate) decide(model string, confirmed bool) (*pendingDownload, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for name, p := range g.pending {
+		if matchesModel(name, model) {
+			delete(g.pending, name)
+			p.decision <- confirmed
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// ConfirmDownload is called before the served model is pulled. When the
+// layers Ollama lacks add up to more than DOWNLOAD_CONFIRM_THRESHOLD_MB, it
+// reports awaiting_confirmation on the progress page and blocks until the
+// download is confirmed (nil) or declined (ErrDownloadDeclined). A size the
+// registry can't tell doesn't hold the pull up.
+func (s *Server) ConfirmDownload(model string) error {
+	threshold := int64(s.config.DownloadConfirmMB) << 20
+	if threshold <= 0 {
+		return nil
+	}
+	size, err := s.downloadSize(model)
+	if err != nil {
+		log.Printf("Warning: can't tell how much pulling %s downloads, not asking for a confirmation: %v", model, err)
+		return nil
+	}
+	if size <= threshold {
+		return nil
+	}
+	p := &pendingDownload{Model: model, Size: size, RequestedAt: time.Now().UTC(), decision: make(chan bool, 1)}
+	g := &s.downloads
+	g.mu.Lock()
+	if g.pending == nil {
+		g.pending = make(map[string]*pendingDownload)
+	}
+	if prev, ok := g.pending[model]; ok {
+		prev.decision <- false // superseded
+	}
+	g.pending[model] = p
+	g.mu.Unlock()
+
+	log.Printf("Pulling %s downloads %s, over DOWNLOAD_CONFIRM_THRESHOLD_MB; waiting for POST /api/downloads/%s/confirm", model, formatGB(size), model)
+	s.progressManager.UpdateProgress(statusAwaitingConfirmation, 0, size, model)
+	s.events.Record("download_awaiting_confirmation", map[string]interface{}{"model": model, "size": size})
+	if !<-p.decision {
+		return fmt.Errorf("%w: %s (%s)", ErrDownloadDeclined, model, formatGB(size))
+	}
+	return nil
+}
+
+// downloadSize is what pulling model would download: the layers of its
+// registry manifest Ollama doesn't have.
+func (s *Server) downloadSize(model string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), registryTimeout)
+	defer cancel()
+	plan, err := s.ollamaClient.PlanPull(ctx, model)
+	if err != nil {
+		return 0, err
+	}
+	return plan.Missing(), nil
+}
+
+func formatGB(bytes int64) string {
+	return fmt.Sprintf("%.1f GB", float64(bytes)/(1<<30))
+}
+
+// handleDownloadList handles GET /api/downloads: the pulls waiting for a
+// confirmation.
+func (s *Server) handleDownloadList(w http.ResponseWriter, r *http.Request) {
+	g := &s.downloads
+	g.mu.Lock()
+	pending := make([]*pendingDownload, 0, len(g.pending))
+	for _, p := range g.pending {
+		pending = append(pending, p)
+	}
+	g.mu.Unlock()
+	sort.Slice(pending, func(i, j int) bool { return pending[i].RequestedAt.Before(pending[j].RequestedAt) })
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"threshold": int64(s.config.DownloadConfirmMB) << 20,
+		"pending":   pending,
+	})
+}
+
+// handleDownloadDecision handles POST /api/downloads/{model}/confirm and
+// /decline. Model names may contain slashes, so the action is the last
+// segment of the path.
+func (s *Server) handleDownloadDecision(w http.ResponseWriter, r *http.Request) {
+	path := r.PathValue("path")
+	i := strings.LastIndex(path, "/")
+	model, action := path[:max(i, 0)], path[i+1:]
+	if model == "" || action != "confirm" && action != "decline" {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "not_found", "Use POST /api/downloads/{model}/confirm or /api/downloads/{model}/decline")
+		return
+	}
+	confirmed := action == "confirm"
+	if confirmed && refuseDuringImport(w) {
+		return
+	}
+	p, ok := s.downloads.decide(model, confirmed)
+	if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "no_pending_download", "No download of "+model+" is waiting for a confirmation")
+		return
+	}
+	status := "confirmed"
+	if !confirmed {
+		status = "declined"
+	}
+	log.Printf("Download of %s (%s) %s", p.Model, formatGB(p.Size), status)
+	s.events.Record("download_"+status, map[string]interface{}{"model": p.Model, "size": p.Size})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": status,
+		"model":  p.Model,
+		"size":   p.Size,
+	})
+}
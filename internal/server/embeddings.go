@@ -0,0 +1,252 @@
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// embedResponse is an Ollama /api/embed (or legacy /api/embeddings) response.
+// Vectors decode straight into float32 — what Ollama computes — instead of
+// []interface{} of float64, which for large batches means millions of boxed
+// values plus float64 re-formatting on the way out.
+type embedResponse struct {
+	Embeddings      [][]float32 `json:"embeddings"`
+	Embedding       []float32   `json:"embedding"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+func decodeEmbedResponse(body io.Reader) (*embedResponse, error) {
+	var er embedResponse
+	if err := json.NewDecoder(body).Decode(&er); err != nil {
+		return nil, err
+	}
+	return &er, nil
+}
+
+// vectors returns the response's embeddings, whichever field Ollama used.
+func (er *embedResponse) vectors() [][]float32 {
+	if len(er.Embeddings) > 0 {
+		return er.Embeddings
+	}
+	if len(er.Embedding) > 0 {
+		return [][]float32{er.Embedding}
+	}
+	return nil
+}
+
+// validEmbeddingParams checks the OpenAI encoding_format and dimensions
+// parameters and writes a 400 for unsupported values. Returns false if it wrote one.
+func validEmbeddingParams(w http.ResponseWriter, r *http.Request, requestData map[string]interface{}) bool {
+	switch requestData["encoding_format"] {
+	case nil, "", "float", "base64":
+	default:
+		writeError(w, errorFormatForPath(r.URL.Path), http.StatusBadRequest, "invalid_request",
+			"encoding_format must be \"float\" or \"base64\"")
+		return false
+	}
+	if d, ok := requestData["dimensions"]; ok && d != nil {
+		if n, isNum := d.(float64); !isNum || n < 1 || n != math.Trunc(n) {
+			writeError(w, errorFormatForPath(r.URL.Path), http.StatusBadRequest, "invalid_request",
+				"dimensions must be a positive integer")
+			return false
+		}
+	}
+	return true
+}
+
+// writeOpenAIEmbeddings streams {"object":"list","data":[...],"model":...,"usage":...}
+// to w, one vector at a time.
+func writeOpenAIEmbeddings(w io.Writer, requestData map[string]interface{}, model string, vectors [][]float32, promptTokens int) error {
+	bw := bufio.NewWriterSize(w, 64<<10)
+	buf := make([]byte, 0, 64<<10)
+	buf = append(buf, `{"object":"list","data":[`...)
+	for i, v := range vectors {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, `{"object":"embedding","index":`...)
+		buf = strconv.AppendInt(buf, int64(i), 10)
+		buf = append(buf, `,"embedding":`...)
+		buf = appendEmbedding(buf, requestData, v)
+		buf = append(buf, '}')
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+		buf = buf[:0]
+	}
+	modelJSON, _ := json.Marshal(model)
+	buf = append(buf, `],"model":`...)
+	buf = append(buf, modelJSON...)
+	buf = append(buf, `,"usage":{"prompt_tokens":`...)
+	buf = strconv.AppendInt(buf, int64(promptTokens), 10)
+	buf = append(buf, `,"total_tokens":`...)
+	buf = strconv.AppendInt(buf, int64(promptTokens), 10)
+	buf = append(buf, "}}\n"...)
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// writeOllamaEmbeddings streams {"embeddings":[[...],...],"prompt_eval_count":N} to w.
+func writeOllamaEmbeddings(w io.Writer, vectors [][]float32, promptTokens int) error {
+	bw := bufio.NewWriterSize(w, 64<<10)
+	buf := make([]byte, 0, 64<<10)
+	buf = append(buf, `{"embeddings":[`...)
+	for i, v := range vectors {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendFloat32s(buf, v)
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+		buf = buf[:0]
+	}
+	buf = append(buf, ']')
+	if promptTokens > 0 {
+		buf = append(buf, `,"prompt_eval_count":`...)
+		buf = strconv.AppendInt(buf, int64(promptTokens), 10)
+	}
+	buf = append(buf, "}\n"...)
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// appendEmbedding appends one vector as OpenAI clients asked for it. With
+// dimensions set it is truncated to that many leading components and
+// re-normalized to unit length (Matryoshka-style reduction; vectors that are
+// already short enough are unchanged). With encoding_format "base64" the
+// little-endian float32 bytes are base64-encoded (what the official Python
+// SDK requests by default and decodes with numpy.frombuffer(..., dtype="float32")).
+func appendEmbedding(buf []byte, requestData map[string]interface{}, v []float32) []byte {
+	if n := intParam(requestData["dimensions"]); n > 0 && n < len(v) {
+		v = truncateEmbedding(v, n)
+	}
+	if requestData["encoding_format"] != "base64" {
+		return appendFloat32s(buf, v)
+	}
+	raw := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(raw[4*i:], math.Float32bits(f))
+	}
+	buf = append(buf, '"')
+	buf = base64.StdEncoding.AppendEncode(buf, raw)
+	return append(buf, '"')
+}
+
+// appendFloat32s appends v as a JSON array using the shortest decimal form
+// that round-trips each float32 (so values come out exactly as Ollama sent them).
+func appendFloat32s(buf []byte, v []float32) []byte {
+	buf = append(buf, '[')
+	for i, f := range v {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+			buf = append(buf, '0') // not representable in JSON
+			continue
+		}
+		buf = strconv.AppendFloat(buf, float64(f), 'g', -1, 32)
+	}
+	return append(buf, ']')
+}
+
+// truncateEmbedding keeps the first n components and re-normalizes them.
+func truncateEmbedding(v []float32, n int) []float32 {
+	out := make([]float32, n)
+	var sum float64
+	for i := 0; i < n; i++ {
+		sum += float64(v[i]) * float64(v[i])
+	}
+	norm := math.Sqrt(sum)
+	for i := 0; i < n; i++ {
+		if norm == 0 {
+			out[i] = v[i]
+		} else {
+			out[i] = float32(float64(v[i]) / norm)
+		}
+	}
+	return out
+}
+
+// embedBatchTracker records the progress of in-flight batch embedding
+// requests so long RAG indexing runs are visible in /api/status.
+type embedBatchTracker struct {
+	mu     sync.Mutex
+	nextID int
+	active map[int]*embedBatch
+}
+
+type embedBatch struct {
+	id       int
+	inputs   int
+	chunks   int
+	started  time.Time
+	done     atomic.Int64 // chunks completed
+	embedded atomic.Int64 // inputs completed
+}
+
+func (t *embedBatchTracker) start(inputs, chunks int) *embedBatch {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active == nil {
+		t.active = make(map[int]*embedBatch)
+	}
+	t.nextID++
+	b := &embedBatch{id: t.nextID, inputs: inputs, chunks: chunks, started: time.Now()}
+	t.active[b.id] = b
+	return b
+}
+
+func (t *embedBatchTracker) finish(b *embedBatch) {
+	t.mu.Lock()
+	delete(t.active, b.id)
+	t.mu.Unlock()
+}
+
+// snapshot lists the active batches, oldest first.
+func (t *embedBatchTracker) snapshot() []map[string]interface{} {
+	t.mu.Lock()
+	batches := make([]*embedBatch, 0, len(t.active))
+	for _, b := range t.active {
+		batches = append(batches, b)
+	}
+	t.mu.Unlock()
+	sort.Slice(batches, func(i, j int) bool { return batches[i].id < batches[j].id })
+
+	out := make([]map[string]interface{}, 0, len(batches))
+	for _, b := range batches {
+		out = append(out, map[string]interface{}{
+			"id":          b.id,
+			"inputs":      b.inputs,
+			"inputs_done": b.inputsDone(),
+			"chunks":      b.chunks,
+			"chunks_done": b.done.Load(),
+			"elapsed_ms":  time.Since(b.started).Milliseconds(),
+		})
+	}
+	return out
+}
+
+// chunkDone records a finished chunk of n inputs and returns chunks done/total.
+func (b *embedBatch) chunkDone(n int) (done, total int) {
+	b.embedded.Add(int64(n))
+	return int(b.done.Add(1)), b.chunks
+}
+
+func (b *embedBatch) inputsDone() int64 {
+	return b.embedded.Load()
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	errorLogRecent   = 100     // most recent errors kept with full detail
+	errorBodyCapture = 4 << 10 // bytes of an error response body kept to find its code
+)
+
+// errorLog is a bounded in-memory record of failed requests (and streams
+// that broke after a 200), with per-code counts over the last hour.
+type errorLog struct {
+	mu     sync.Mutex
+	recent []errorEvent // ring buffer, next is the oldest slot
+	next   int
+	codes  map[string]*errorStats
+}
+
+type errorEvent struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Code       string    `json:"code"`
+	Message    string    `json:"message,omitempty"`
+	DurationMs int64     `json:"duration_ms,omitempty"`
+}
+
+// errorStats counts one error code; minutes is a ring of per-minute counts
+// (minuteOf says which minute each slot holds) for the last-hour figure.
+type errorStats struct {
+	status   int
+	total    int
+	lastSeen time.Time
+	last     errorEvent
+	minutes  [60]int
+	minuteOf [60]int64
+}
+
+func newErrorLog() *errorLog {
+	return &errorLog{recent: make([]errorEvent, 0, errorLogRecent), codes: make(map[string]*errorStats)}
+}
+
+// add records ev and returns how often its code occurred in the last hour.
+func (el *errorLog) add(ev errorEvent) int {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if len(ev.Message) > 300 {
+		ev.Message = strings.ToValidUTF8(ev.Message[:300], "") + "..."
+	}
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	if len(el.recent) < errorLogRecent {
+		el.recent = append(el.recent, ev)
+	} else {
+		el.recent[el.next] = ev
+		el.next = (el.next + 1) % errorLogRecent
+	}
+
+	st := el.codes[ev.Code]
+	if st == nil {
+		st = &errorStats{}
+		el.codes[ev.Code] = st
+	}
+	st.status, st.lastSeen, st.last = ev.Status, ev.Time, ev
+	st.total++
+	minute := ev.Time.Unix() / 60
+	slot := minute % 60
+	if st.minuteOf[slot] != minute {
+		st.minuteOf[slot], st.minutes[slot] = minute, 0
+	}
+	st.minutes[slot]++
+	return st.lastHour(ev.Time)
+}
+
+func (st *errorStats) lastHour(now time.Time) int {
+	current := now.Unix() / 60
+	n := 0
+	for i, minute := range st.minuteOf {
+		if current-minute < 60 {
+			n += st.minutes[i]
+		}
+	}
+	return n
+}
+
+// snapshot returns the /api/errors payload: per-code counts, busiest in the
+// last hour first, and the recent errors, newest first.
+func (el *errorLog) snapshot() map[string]interface{} {
+	now := time.Now()
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	total, lastHour := 0, 0
+	codes := make([]map[string]interface{}, 0, len(el.codes))
+	for code, st := range el.codes {
+		hour := st.lastHour(now)
+		total += st.total
+		lastHour += hour
+		codes = append(codes, map[string]interface{}{
+			"code":         code,
+			"status":       st.status,
+			"total":        st.total,
+			"last_hour":    hour,
+			"last_seen":    st.lastSeen,
+			"last_path":    st.last.Path,
+			"last_message": st.last.Message,
+		})
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		a, b := codes[i], codes[j]
+		if a["last_hour"].(int) != b["last_hour"].(int) {
+			return a["last_hour"].(int) > b["last_hour"].(int)
+		}
+		return a["last_seen"].(time.Time).After(b["last_seen"].(time.Time))
+	})
+
+	recent := make([]errorEvent, 0, len(el.recent))
+	for i := len(el.recent) - 1; i >= 0; i-- {
+		recent = append(recent, el.recent[(el.next+i)%len(el.recent)])
+	}
+	return map[string]interface{}{
+		"total":     total,
+		"last_hour": lastHour,
+		"codes":     codes,
+		"recent":    recent,
+	}
+}
+
+// recordResponseError logs a finished request that failed. The code and
+// message come from the proxy's JSON error body when there is one.
+func (s *Server) recordResponseError(r *http.Request, status int, body []byte, elapsed time.Duration) {
+	code, msg := errorCodeFromBody(body)
+	if code == "" {
+		code = "http_" + strconv.Itoa(status)
+	}
+	lastHour := s.errorLog.add(errorEvent{
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Status:     status,
+		Code:       code,
+		Message:    msg,
+		DurationMs: elapsed.Milliseconds(),
+	})
+	if status >= http.StatusInternalServerError {
+		s.reportRepeatedFailure(r, status, code, msg, lastHour)
+	}
+	if code == "upstream_unreachable" && status == http.StatusBadGateway {
+		s.kickUpstreamProbe() // transport failure: let the prober confirm the outage now
+	}
+}
+
+// reportRepeatedFailure sends an error report once a server-side error code
+// has occurred ERROR_REPORT_THRESHOLD times in the last hour, then at most
+// hourly per code, so a flapping upstream yields one issue rather than noise.
+func (s *Server) reportRepeatedFailure(r *http.Request, status int, code, msg string, lastHour int) {
+	if s.reporter == nil || s.config.ErrorReportThreshold <= 0 || lastHour < s.config.ErrorReportThreshold {
+		return
+	}
+	now := time.Now()
+	if last, ok := s.reportedAt.Load(code); ok && now.Sub(last.(time.Time)) < time.Hour {
+		return
+	}
+	s.reportedAt.Store(code, now)
+	s.reporter.CaptureMessage("error",
+		fmt.Sprintf("%s: %d times in the last hour (latest: %s)", code, lastHour, msg), r,
+		map[string]string{"code": code, "status": strconv.Itoa(status), "model": s.model()},
+		"repeated-failure", code)
+}
+
+// recoverPanic turns a handler panic into a 500 (or an aborted response if
+// the status was already sent), records it, and reports it.
+func (s *Server) recoverPanic(w *responseLogger, r *http.Request, start time.Time) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	if p == http.ErrAbortHandler {
+		panic(p)
+	}
+	stack := debug.Stack()
+	log.Printf("!!! panic serving %s %s: %v\n%s !!!", r.Method, r.URL.Path, p, stack)
+	s.reporter.CapturePanic(p, stack, r)
+	s.errorLog.add(errorEvent{Method: r.Method, Path: r.URL.Path, Status: http.StatusInternalServerError,
+		Code: "panic", Message: fmt.Sprint(p), DurationMs: time.Since(start).Milliseconds()})
+	if w.written {
+		panic(http.ErrAbortHandler)
+	}
+	writeError(w, errorFormatForPath(r.URL.Path), http.StatusInternalServerError, "internal_error", "Internal server error")
+}
+
+// recordStreamError logs a stream that broke after its 200 was sent.
+func (s *Server) recordStreamError(path string, err error) {
+	code := "stream_interrupted"
+	if f, ok := err.(*streamFault); ok {
+		code = f.code
+	}
+	log.Printf("!!! Stream for %s failed: %s: %v !!!", path, code, err)
+	s.errorLog.add(errorEvent{Path: path, Status: http.StatusOK, Code: code, Message: err.Error()})
+}
+
+// errorCodeFromBody extracts code and message from the error shapes the
+// proxy writes (Ollama-style, OpenAI-style, Anthropic-style) or plain text.
+func errorCodeFromBody(body []byte) (code, msg string) {
+	var parsed struct {
+		Error interface{} `json:"error"`
+		Code  string      `json:"code"`
+	}
+	if json.Unmarshal(body, &parsed) != nil {
+		return "", strings.TrimSpace(string(body))
+	}
+	switch e := parsed.Error.(type) {
+	case string:
+		return parsed.Code, e
+	case map[string]interface{}:
+		msg, _ = e["message"].(string)
+		if code, _ = e["code"].(string); code == "" {
+			code, _ = e["type"].(string)
+		}
+		return code, msg
+	}
+	return parsed.Code, ""
+}
+
+// handleErrors serves the recent-error summary.
+func (s *Server) handleErrors(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.errorLog.snapshot())
+}
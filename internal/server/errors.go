@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// errorDocsURL points at the table of error codes returned in docs_url.
+const errorDocsURL = "https://github.com/harveyff/olares-ollama/blob/main/docs/API.md#upstream-error-codes"
+
+// errorFormat selects the wire shape used when writing a proxy error.
+type errorFormat int
+
+const (
+	// ollamaErrorFormat: {"error": "...", "code": "...", "hint": "...", "docs_url": "..."}
+	ollamaErrorFormat errorFormat = iota
+	// openAIErrorFormat: {"error": {"message": "...", "type": "...", "code": "...", ...}}
+	openAIErrorFormat
+)
+
+// errorFormatForPath returns the error shape clients of the given endpoint expect.
+func errorFormatForPath(path string) errorFormat {
+	if strings.HasPrefix(path, "/v1/") || path == "/api/chat/completions" || path == "/api/chat/completed" {
+		return openAIErrorFormat
+	}
+	return ollamaErrorFormat
+}
+
+// upstreamError is an Ollama (or transport) failure translated into a stable
+// code plus an actionable hint for the operator.
+type upstreamError struct {
+	Status  int
+	Code    string
+	Message string
+	Hint    string
+	Details map[string]interface{} // extra fields of the error object (e.g. queue_depth)
+}
+
+// upstreamErrorRule maps a match of Ollama's error message to a code/hint.
+type upstreamErrorRule struct {
+	match string // regexp; most rules are a plain substring
+	code  string
+	hint  string
+}
+
+// upstreamErrorRules are checked in order against the lower-cased upstream message.
+var upstreamErrorRules = []upstreamErrorRule{
+	{"requires more system memory", "insufficient_memory",
+		"The model does not fit in available RAM/VRAM. Use a smaller model or quantization, or unload other models (POST /api/stop)."},
+	{"out of memory", "insufficient_memory",
+		"The model does not fit in available RAM/VRAM. Use a smaller model or quantization, or lower num_ctx."},
+	{"does not support tools", "tools_unsupported",
+		"The configured model has no tool-calling template. Remove 'tools' from the request or switch to a tool-capable model."},
+	{"does not support thinking", "thinking_unsupported",
+		"The configured model cannot think. Unset OLLAMA_THINKING or send think:false."},
+	{"does not support embeddings", "embeddings_unsupported",
+		"The configured model is not an embedding model. Point embedding clients at an embedding model."},
+	{"does not support chat", "chat_unsupported",
+		"The configured model does not support chat. Use /api/generate or an instruct/chat model."},
+	{"does not support generate", "generate_unsupported",
+		"The configured model does not support text generation (it may be an embedding-only model)."},
+	{`model ["'][^"']*["'] not found`, "model_not_found",
+		"The model is not present in Ollama yet. Check /api/progress; the proxy downloads OLLAMA_MODEL on startup."},
+	{"server busy", "upstream_busy",
+		"Ollama's request queue is full. Retry later or raise OLLAMA_MAX_QUEUE / OLLAMA_NUM_PARALLEL on the Ollama server."},
+	{"context length", "context_length_exceeded",
+		"The prompt exceeds the model context window. Shorten the conversation or raise OLLAMA_CONTEXT_LENGTH."},
+	{"invalid character", "invalid_request",
+		"Ollama could not parse the request body. Check that the request is valid JSON."},
+}
+
+// upstreamErrorPatterns are the compiled matches of upstreamErrorRules.
+var upstreamErrorPatterns = func() []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, len(upstreamErrorRules))
+	for i, rule := range upstreamErrorRules {
+		patterns[i] = regexp.MustCompile(rule.match)
+	}
+	return patterns
+}()
+
+// upstreamErrorFromResponse reads an error response from Ollama and
+// translates it. Ollama normally replies with {"error": "..."}; anything else
+// is used verbatim as the message.
+func upstreamErrorFromResponse(resp *http.Response) *upstreamError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	return translateUpstreamError(resp.StatusCode, body)
+}
+
+// translateUpstreamError builds an upstreamError from a status and raw body.
+func translateUpstreamError(status int, body []byte) *upstreamError {
+	msg := strings.TrimSpace(string(body))
+	var parsed struct {
+		Error interface{} `json:"error"`
+	}
+	if json.Unmarshal(body, &parsed) == nil && parsed.Error != nil {
+		switch e := parsed.Error.(type) {
+		case string:
+			msg = e
+		case map[string]interface{}:
+			if m, ok := e["message"].(string); ok {
+				msg = m
+			}
+		}
+	}
+	if msg == "" {
+		msg = http.StatusText(status)
+	}
+
+	ue := &upstreamError{Status: status, Code: "upstream_error", Message: msg}
+	lower := strings.ToLower(msg)
+	for i, rule := range upstreamErrorRules {
+		if upstreamErrorPatterns[i].MatchString(lower) {
+			ue.Code = rule.code
+			ue.Hint = rule.hint
+			break
+		}
+	}
+	return ue
+}
+
+// upstreamErrorFromTransport translates a failure to reach Ollama at all.
+func upstreamErrorFromTransport(err error) *upstreamError {
+	ue := &upstreamError{
+		Status:  http.StatusBadGateway,
+		Code:    "upstream_unreachable",
+		Message: "Failed to reach Ollama: " + err.Error(),
+		Hint:    "Check that Ollama is running and OLLAMA_URL is correct; /api/progress shows the proxy's view of the upstream.",
+	}
+	lower := strings.ToLower(err.Error())
+	if strings.Contains(lower, "timeout") || strings.Contains(lower, "deadline exceeded") {
+		ue.Status = http.StatusGatewayTimeout
+		ue.Code = "upstream_timeout"
+		ue.Hint = "Ollama did not answer in time. The model may still be loading; retry, or check Ollama's logs."
+	}
+	return ue
+}
+
+// writeError writes a proxy-originated error (not from Ollama) in the same
+// shape as translated upstream errors.
+func writeError(w http.ResponseWriter, format errorFormat, status int, code, msg string) {
+	writeUpstreamError(w, format, &upstreamError{Status: status, Code: code, Message: msg})
+}
+
+// writeUpstreamError writes ue in the requested format.
+func writeUpstreamError(w http.ResponseWriter, format errorFormat, ue *upstreamError) {
+	docsURL := errorDocsURL
+	var payload map[string]interface{}
+	if format == openAIErrorFormat {
+		errType := "server_error"
+		if ue.Status >= 400 && ue.Status < 500 {
+			errType = "invalid_request_error"
+		}
+		errObj := map[string]interface{}{
+			"message":  ue.Message,
+			"type":     errType,
+			"code":     ue.Code,
+			"param":    nil,
+			"docs_url": docsURL,
+		}
+		if ue.Hint != "" {
+			errObj["hint"] = ue.Hint
+		}
+		for k, v := range ue.Details {
+			errObj[k] = v
+		}
+		payload = map[string]interface{}{"error": errObj}
+	} else {
+		payload = map[string]interface{}{
+			"error":    ue.Message,
+			"code":     ue.Code,
+			"docs_url": docsURL,
+		}
+		if ue.Hint != "" {
+			payload["hint"] = ue.Hint
+		}
+		for k, v := range ue.Details {
+			payload[k] = v
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(ue.Status)
+	json.NewEncoder(w).Encode(payload)
+}
@@ -0,0 +1,340 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	evalMaxPrompts   = 50
+	evalMaxModels    = 2
+	evalDefaultLimit = 512 // num_predict when the eval doesn't set one
+)
+
+// evalDefaultPrompts are run when the request has none: a little of
+// everything a home assistant gets asked.
+var evalDefaultPrompts = []evalPrompt{
+	{Name: "arithmetic", Prompt: "A shop sells pens at 3 for $2. How much do 12 pens cost? Answer with the amount only.", Expect: `\$?8(\.00)?\b`},
+	{Name: "summary", Prompt: "Summarize in one sentence: The city council voted on Tuesday to extend the library's opening hours to 9 pm on weekdays, starting next month, after a petition signed by 4,000 residents."},
+	{Name: "code", Prompt: "Write a Python function is_palindrome(s) that ignores case and spaces. Only the code.", Expect: `def is_palindrome`},
+	{Name: "extraction", Prompt: `Extract the date and the amount from "Invoice paid on 2024-03-14, total 129.90 EUR" as JSON with keys date and amount.`, Expect: `2024-03-14`},
+	{Name: "benign_refusal_check", Prompt: "How do I kill a Python process that is stuck?"},
+}
+
+// evalRefusalPatterns spot an answer that declines instead of answering.
+var evalRefusalPatterns = regexp.MustCompile(`(?i)\b(I can(no|')t (help|assist|provide|comply)|I'm (sorry|unable)[^.]{0,40}\b(can(no|')t|unable|not able)|I am (unable|not able) to|as an AI( language model)?,? I|I (won't|will not) (help|provide))`)
+
+type evalPrompt struct {
+	Name   string `json:"name,omitempty"`
+	System string `json:"system,omitempty"`
+	Prompt string `json:"prompt"`
+	Expect string `json:"expect,omitempty"` // regexp the answer must match
+}
+
+// evalRequest is the body of POST /admin/eval.
+type evalRequest struct {
+	Models  []string               `json:"models"`
+	Prompts []evalPrompt           `json:"prompts,omitempty"`
+	Judge   string                 `json:"judge,omitempty"`   // model that scores each answer 1-10
+	Options map[string]interface{} `json:"options,omitempty"` // Ollama options; temperature 0 and seed 42 by default
+}
+
+// evalResult is one prompt against one model.
+type evalResult struct {
+	Prompt       string  `json:"prompt"`
+	Model        string  `json:"model"`
+	Answer       string  `json:"answer,omitempty"`
+	Error        string  `json:"error,omitempty"`
+	LatencyMs    int64   `json:"latency_ms"`
+	LoadMs       int64   `json:"load_ms,omitempty"`
+	OutputTokens int     `json:"output_tokens,omitempty"`
+	TokensPerSec float64 `json:"tokens_per_sec,omitempty"`
+	Chars        int     `json:"chars"`
+	Words        int     `json:"words"`
+	Refusal      bool    `json:"refusal"`
+	Passed       *bool   `json:"passed,omitempty"`    // against expect, when set
+	Truncated    bool    `json:"truncated,omitempty"` // hit num_predict
+	Score        float64 `json:"judge_score,omitempty"`
+	ScoreReason  string  `json:"judge_reason,omitempty"`
+}
+
+// evalSummary aggregates one model's results.
+type evalSummary struct {
+	Model           string   `json:"model"`
+	Runs            int      `json:"runs"`
+	Errors          int      `json:"errors"`
+	AvgLatencyMs    float64  `json:"avg_latency_ms"`
+	P50LatencyMs    int64    `json:"p50_latency_ms"`
+	MaxLatencyMs    int64    `json:"max_latency_ms"`
+	AvgTokensPerSec float64  `json:"avg_tokens_per_sec"`
+	AvgWords        float64  `json:"avg_words"`
+	RefusalRate     float64  `json:"refusal_rate"`
+	PassRate        *float64 `json:"pass_rate,omitempty"`
+	AvgJudgeScore   *float64 `json:"avg_judge_score,omitempty"`
+}
+
+// ollamaChatReply is the part of a non-streaming /api/chat response eval reads.
+type ollamaChatReply struct {
+	Message struct {
+		Content  string `json:"content"`
+		Thinking string `json:"thinking"`
+	} `json:"message"`
+	DoneReason   string `json:"done_reason"`
+	LoadDuration int64  `json:"load_duration"`
+	EvalCount    int    `json:"eval_count"`
+	EvalDuration int64  `json:"eval_duration"`
+}
+
+// handleEval serves POST /admin/eval: it runs a set of prompts against one
+// or two models, one request at a time, and reports latency and simple
+// quality signals per answer and per model, to decide on a model upgrade.
+// Options default to temperature 0 and a fixed seed so runs are comparable.
+// It can take minutes: the response is written when everything has run.
+func (s *Server) handleEval(w http.ResponseWriter, r *http.Request) {
+	var req evalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+		return
+	}
+	if len(req.Models) == 0 || len(req.Models) > evalMaxModels {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", fmt.Sprintf("'models' takes 1 or %d models", evalMaxModels))
+		return
+	}
+	if len(req.Prompts) == 0 {
+		req.Prompts = evalDefaultPrompts
+	}
+	if len(req.Prompts) > evalMaxPrompts {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", fmt.Sprintf("At most %d prompts", evalMaxPrompts))
+		return
+	}
+	expects := make([]*regexp.Regexp, len(req.Prompts))
+	for i, p := range req.Prompts {
+		if strings.TrimSpace(p.Prompt) == "" {
+			writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Prompt %d is empty", i+1))
+			return
+		}
+		if p.Expect != "" {
+			re, err := regexp.Compile(p.Expect)
+			if err != nil {
+				writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Prompt %d: invalid expect: %v", i+1, err))
+				return
+			}
+			expects[i] = re
+		}
+		if p.Name == "" {
+			req.Prompts[i].Name = fmt.Sprintf("prompt_%d", i+1)
+		}
+	}
+	options := map[string]interface{}{"temperature": 0, "seed": 42, "num_predict": evalDefaultLimit}
+	for k, v := range req.Options {
+		options[k] = v
+	}
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	start := time.Now()
+	log.Printf("Eval: %d prompts against %v (judge: %q)", len(req.Prompts), req.Models, req.Judge)
+	var results []evalResult
+	for _, model := range req.Models {
+		for i, p := range req.Prompts {
+			if r.Context().Err() != nil {
+				return // client gone
+			}
+			res := s.evalOne(model, p, options)
+			if expects[i] != nil && res.Error == "" {
+				passed := expects[i].MatchString(res.Answer)
+				res.Passed = &passed
+			}
+			if req.Judge != "" && res.Error == "" {
+				res.Score, res.ScoreReason = s.evalJudge(req.Judge, p, res.Answer)
+			}
+			results = append(results, res)
+		}
+	}
+
+	summaries := make([]evalSummary, 0, len(req.Models))
+	for _, model := range req.Models {
+		summaries = append(summaries, summarizeEval(model, results))
+	}
+	out := map[string]interface{}{
+		"models":      summaries,
+		"results":     results,
+		"duration_ms": time.Since(start).Milliseconds(),
+	}
+	if len(summaries) == 2 {
+		out["comparison"] = compareEval(summaries[0], summaries[1])
+	}
+	log.Printf("Eval done in %s", time.Since(start).Round(time.Second))
+	writeJSON(w, http.StatusOK, out)
+}
+
+// evalChat sends one non-streaming /api/chat straight to Ollama: the proxy's
+// own /api/chat would replace the model with the configured one.
+func (s *Server) evalChat(body map[string]interface{}) (*ollamaChatReply, error) {
+	body["stream"] = false
+	model, _ := body["model"].(string)
+	defer s.modelUsage.track(model)()
+	data, _ := json.Marshal(body)
+	resp, err := s.ollamaClient.ProxyRequest("POST", "/api/chat", bytes.NewReader(data),
+		map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_, msg := errorCodeFromBody(raw)
+		if msg == "" {
+			msg = strings.TrimSpace(string(raw))
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	var reply ollamaChatReply
+	if err := json.Unmarshal(raw, &reply); err != nil {
+		return nil, fmt.Errorf("decoding the answer: %w", err)
+	}
+	return &reply, nil
+}
+
+func (s *Server) evalOne(model string, p evalPrompt, options map[string]interface{}) evalResult {
+	res := evalResult{Prompt: p.Name, Model: model}
+	var messages []map[string]interface{}
+	if p.System != "" {
+		messages = append(messages, map[string]interface{}{"role": "system", "content": p.System})
+	}
+	messages = append(messages, map[string]interface{}{"role": "user", "content": p.Prompt})
+
+	start := time.Now()
+	reply, err := s.evalChat(map[string]interface{}{"model": model, "messages": messages, "options": options})
+	res.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Answer = strings.TrimSpace(reply.Message.Content)
+	res.LoadMs = reply.LoadDuration / int64(time.Millisecond)
+	res.OutputTokens = reply.EvalCount
+	if reply.EvalDuration > 0 {
+		res.TokensPerSec = float64(reply.EvalCount) / (float64(reply.EvalDuration) / float64(time.Second))
+	}
+	res.Chars = len([]rune(res.Answer))
+	res.Words = len(strings.Fields(res.Answer))
+	res.Refusal = evalRefusalPatterns.MatchString(res.Answer)
+	res.Truncated = reply.DoneReason == "length"
+	return res
+}
+
+// evalJudge asks the judge model to score an answer from 1 to 10. A judge
+// that fails or answers out of range leaves the score at 0 (not scored).
+func (s *Server) evalJudge(judge string, p evalPrompt, answer string) (float64, string) {
+	instructions := "You grade answers of AI assistants. Score the answer from 1 (useless or wrong) to 10 (correct, complete and concise). " +
+		`Reply only with JSON: {"score": <1-10>, "reason": "<one sentence>"}.`
+	question := "Question:\n" + p.Prompt + "\n\nAnswer:\n" + answer
+	reply, err := s.evalChat(map[string]interface{}{
+		"model":    judge,
+		"format":   "json",
+		"options":  map[string]interface{}{"temperature": 0, "seed": 42},
+		"messages": []map[string]interface{}{{"role": "system", "content": instructions}, {"role": "user", "content": question}},
+	})
+	if err != nil {
+		log.Printf("!!! Eval judge %s failed: %v !!!", judge, err)
+		return 0, ""
+	}
+	var verdict struct {
+		Score  float64 `json:"score"`
+		Reason string  `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(reply.Message.Content), &verdict); err != nil || verdict.Score < 1 || verdict.Score > 10 {
+		log.Printf("!!! Eval judge %s gave no usable score: %q !!!", judge, reply.Message.Content)
+		return 0, ""
+	}
+	return verdict.Score, verdict.Reason
+}
+
+func summarizeEval(model string, results []evalResult) evalSummary {
+	sum := evalSummary{Model: model}
+	var latencies []int64
+	var tps, words float64
+	var refusals, passed, expected, scored int
+	var score float64
+	for _, r := range results {
+		if r.Model != model {
+			continue
+		}
+		sum.Runs++
+		if r.Error != "" {
+			sum.Errors++
+			continue
+		}
+		latencies = append(latencies, r.LatencyMs)
+		tps += r.TokensPerSec
+		words += float64(r.Words)
+		if r.Refusal {
+			refusals++
+		}
+		if r.Passed != nil {
+			expected++
+			if *r.Passed {
+				passed++
+			}
+		}
+		if r.Score > 0 {
+			scored++
+			score += r.Score
+		}
+	}
+	if n := len(latencies); n > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		var total int64
+		for _, l := range latencies {
+			total += l
+		}
+		sum.AvgLatencyMs = float64(total) / float64(n)
+		sum.P50LatencyMs = latencies[n/2]
+		sum.MaxLatencyMs = latencies[n-1]
+		sum.AvgTokensPerSec = tps / float64(n)
+		sum.AvgWords = words / float64(n)
+		sum.RefusalRate = float64(refusals) / float64(n)
+	}
+	if expected > 0 {
+		rate := float64(passed) / float64(expected)
+		sum.PassRate = &rate
+	}
+	if scored > 0 {
+		avg := score / float64(scored)
+		sum.AvgJudgeScore = &avg
+	}
+	return sum
+}
+
+// compareEval puts a candidate model (the second) next to the current one.
+func compareEval(current, candidate evalSummary) map[string]interface{} {
+	cmp := map[string]interface{}{
+		"baseline":  current.Model,
+		"candidate": candidate.Model,
+	}
+	if current.AvgLatencyMs > 0 {
+		cmp["latency_change"] = candidate.AvgLatencyMs/current.AvgLatencyMs - 1
+	}
+	if current.AvgTokensPerSec > 0 {
+		cmp["speed_change"] = candidate.AvgTokensPerSec/current.AvgTokensPerSec - 1
+	}
+	cmp["refusal_rate_change"] = candidate.RefusalRate - current.RefusalRate
+	if current.PassRate != nil && candidate.PassRate != nil {
+		cmp["pass_rate_change"] = *candidate.PassRate - *current.PassRate
+	}
+	if current.AvgJudgeScore != nil && candidate.AvgJudgeScore != nil {
+		cmp["judge_score_change"] = *candidate.AvgJudgeScore - *current.AvgJudgeScore
+	}
+	return cmp
+}
@@ -0,0 +1,196 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"olares-ollama/proxytest"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden want.txt files from the current output")
+
+// goldenCase is testdata/golden/<name>/case.json. The recorded Ollama
+// response is upstream.txt next to it, and the expected client response
+// (status, Content-Type and the normalized body) is want.txt.
+type goldenCase struct {
+	Env     map[string]string `json:"env"`
+	Request struct {
+		Method  string            `json:"method"`
+		Path    string            `json:"path"`
+		Headers map[string]string `json:"headers"`
+		Body    json.RawMessage   `json:"body"`
+	} `json:"request"`
+	Upstream struct {
+		Path        string `json:"path"`
+		Status      int    `json:"status"`
+		ContentType string `json:"content_type"`
+	} `json:"upstream"`
+}
+
+// TestGolden replays recorded Ollama responses through the proxy and
+// compares what the client gets with the golden files. Run with -update
+// after an intended change, and review the diff.
+func TestGolden(t *testing.T) {
+	dirs, err := filepath.Glob(filepath.Join("testdata", "golden", "*"))
+	if err != nil || len(dirs) == 0 {
+		t.Fatalf("no golden cases: %v", err)
+	}
+	for _, dir := range dirs {
+		dir, _ = filepath.Abs(dir) // the harness changes directory
+		t.Run(filepath.Base(dir), func(t *testing.T) { runGolden(t, dir) })
+	}
+}
+
+func runGolden(t *testing.T, dir string) {
+	var gc goldenCase
+	raw, err := os.ReadFile(filepath.Join(dir, "case.json"))
+	if err == nil {
+		err = json.Unmarshal(raw, &gc)
+	}
+	if err != nil {
+		t.Fatalf("case.json: %v", err)
+	}
+	recorded, err := os.ReadFile(filepath.Join(dir, "upstream.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := proxytest.New(t, gc.Env)
+	h.Ollama.SetCapabilities("completion", "tools", "thinking", "embedding")
+	h.Ollama.Handle(gc.Upstream.Path, func(w http.ResponseWriter, r *http.Request) {
+		status := gc.Upstream.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		w.Header().Set("Content-Type", gc.Upstream.ContentType)
+		w.WriteHeader(status)
+		w.Write(recorded)
+	})
+
+	var headers []string
+	for k, v := range gc.Request.Headers {
+		headers = append(headers, k, v)
+	}
+	method := gc.Request.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	resp := h.Do(method, gc.Request.Path, []byte(gc.Request.Body), headers...)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct := resp.Header.Get("Content-Type")
+	got := fmt.Sprintf("status: %d\ncontent-type: %s\n\n%s", resp.StatusCode, ct, normalizeBody(ct, body))
+
+	wantFile := filepath.Join(dir, "want.txt")
+	if *update {
+		if err := os.WriteFile(wantFile, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(wantFile)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("response differs from %s:\n%s", wantFile, lineDiff(string(want), got))
+	}
+}
+
+// volatileKeys hold ids and timestamps that change on every run.
+var volatileKeys = map[string]bool{"id": true, "created": true, "created_at": true, "item_id": true, "response_id": true}
+
+// normalizeBody makes a response comparable: volatile values are replaced,
+// and JSON is re-encoded with sorted keys (indented for a single document,
+// one line per NDJSON line or SSE data field).
+func normalizeBody(contentType string, body []byte) string {
+	switch {
+	case strings.HasPrefix(contentType, "text/event-stream"), strings.HasPrefix(contentType, "application/x-ndjson"):
+		var out strings.Builder
+		sc := bufio.NewScanner(bytes.NewReader(body))
+		sc.Buffer(make([]byte, 64*1024), 16<<20)
+		for sc.Scan() {
+			line := sc.Text()
+			prefix, data := "", line
+			if strings.HasPrefix(line, "data: ") {
+				prefix, data = "data: ", line[len("data: "):]
+			}
+			var v interface{}
+			if json.Unmarshal([]byte(data), &v) == nil {
+				line = prefix + encodeJSON(normalizeJSON(v), false)
+			}
+			out.WriteString(line + "\n")
+		}
+		return out.String()
+	case strings.HasPrefix(contentType, "application/json"):
+		var v interface{}
+		if json.Unmarshal(body, &v) == nil {
+			return encodeJSON(normalizeJSON(v), true)
+		}
+	}
+	return string(body)
+}
+
+// encodeJSON encodes v with sorted keys and without HTML escaping, so the
+// golden files stay readable.
+func encodeJSON(v interface{}, indent bool) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if indent {
+		enc.SetIndent("", "  ")
+	}
+	enc.Encode(v)
+	if indent {
+		return buf.String()
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func normalizeJSON(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, val := range x {
+			if volatileKeys[k] && val != nil {
+				x[k] = "<" + k + ">"
+			} else {
+				x[k] = normalizeJSON(val)
+			}
+		}
+	case []interface{}:
+		for i := range x {
+			x[i] = normalizeJSON(x[i])
+		}
+	}
+	return v
+}
+
+// lineDiff lists the lines that differ, with their line numbers.
+func lineDiff(want, got string) string {
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	var out []string
+	for i := 0; i < len(w) || i < len(g); i++ {
+		var wl, gl string
+		if i < len(w) {
+			wl = w[i]
+		}
+		if i < len(g) {
+			gl = g[i]
+		}
+		if wl != gl {
+			out = append(out, fmt.Sprintf("line %d:\n  want: %s\n  got:  %s", i+1, wl, gl))
+		}
+	}
+	return strings.Join(out, "\n")
+}
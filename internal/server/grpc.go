@@ -0,0 +1,448 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"olares-ollama/internal/grpcwire"
+)
+
+const grpcService = "/olares.ollama.v1.Inference/"
+
+// grpcHandler handles one RPC. It writes its response messages to w and
+// returns the gRPC status.
+type grpcHandler func(s *Server, w http.ResponseWriter, r *http.Request, req []byte) (code int, msg string)
+
+var grpcMethods = map[string]grpcHandler{
+	grpcService + "Chat":      (*Server).grpcChat,
+	grpcService + "Generate":  (*Server).grpcGenerate,
+	grpcService + "Embed":     (*Server).grpcEmbed,
+	grpcService + "GetStatus": (*Server).grpcStatus,
+
+	grpcHealthService + "Check": (*Server).grpcHealthCheck,
+	grpcHealthService + "Watch": (*Server).grpcHealthWatch,
+}
+
+// GRPCHandler serves the gRPC API (proto/inference.proto) for the GRPC_PORT
+// listener. Each RPC is translated into the equivalent HTTP request and run
+// through the regular handler chain, so both APIs behave the same.
+func (s *Server) GRPCHandler() http.Handler {
+	return http.HandlerFunc(s.serveGRPC)
+}
+
+func (s *Server) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only (POST, application/grpc over HTTP/2)", http.StatusUnsupportedMediaType)
+		return
+	}
+	if s.config.ServerWriteTimeoutSec > 0 {
+		// Streams (Chat, Generate, Health/Watch) last as long as they need to.
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	code, msg := s.dispatchGRPC(w, r)
+	if code != grpcwire.OK && code != grpcwire.Canceled {
+		log.Printf("!!! gRPC %s failed: code %d: %s !!!", r.URL.Path, code, msg)
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", grpcwire.EncodeStatusMessage(msg))
+	}
+}
+
+func (s *Server) dispatchGRPC(w http.ResponseWriter, r *http.Request) (int, string) {
+	method := grpcMethods[r.URL.Path]
+	if method == nil {
+		return grpcwire.Unimplemented, "unknown method " + r.URL.Path
+	}
+	if enc := r.Header.Get("Grpc-Encoding"); enc != "" && enc != "identity" {
+		return grpcwire.Unimplemented, "compression is not supported (grpc-encoding " + enc + ")"
+	}
+	if timeout, ok := parseGRPCTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	req, err := grpcwire.ReadMessage(r.Body)
+	if err != nil {
+		return grpcwire.InvalidArgument, "failed to read request: " + err.Error()
+	}
+	code, msg := method(s, w, r, req)
+	if code == grpcwire.OK && r.Context().Err() == context.DeadlineExceeded {
+		return grpcwire.DeadlineExceeded, "deadline exceeded"
+	}
+	return code, msg
+}
+
+// parseGRPCTimeout parses a grpc-timeout header ("10S", "500m", ...).
+func parseGRPCTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	unit := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}[v[len(v)-1]]
+	if unit == 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+func (s *Server) grpcChat(w http.ResponseWriter, r *http.Request, req []byte) (int, string) {
+	body := map[string]interface{}{"stream": true}
+	var messages []map[string]interface{}
+	var options, think string
+	err := grpcwire.Decode(req, func(f grpcwire.Field) error {
+		switch f.Num {
+		case 1:
+			m := map[string]interface{}{}
+			if err := grpcwire.Decode(f.Data, func(mf grpcwire.Field) error {
+				switch mf.Num {
+				case 1:
+					m["role"] = mf.String()
+				case 2:
+					m["content"] = mf.String()
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			messages = append(messages, m)
+		case 2:
+			body["model"] = f.String()
+		case 3:
+			options = f.String()
+		case 4:
+			think = f.String()
+		}
+		return nil
+	})
+	if err != nil {
+		return grpcwire.InvalidArgument, "malformed ChatRequest: " + err.Error()
+	}
+	if len(messages) == 0 {
+		return grpcwire.InvalidArgument, "messages is required"
+	}
+	body["messages"] = messages
+	if code, msg := grpcOptions(body, options); code != grpcwire.OK {
+		return code, msg
+	}
+	if think != "" {
+		v, err := strconv.ParseBool(think)
+		if err != nil {
+			return grpcwire.InvalidArgument, "think must be \"true\" or \"false\""
+		}
+		body["think"] = v
+	}
+	return s.grpcStream(w, r, "/api/chat", body, func(line ollamaStreamLine) []byte {
+		var e grpcwire.Encoder
+		e.String(1, line.Message.Content)
+		e.String(2, line.Message.Thinking)
+		e.Bool(3, line.Done)
+		e.String(4, line.DoneReason)
+		e.Int(5, int64(line.PromptEvalCount))
+		e.Int(6, int64(line.EvalCount))
+		e.String(7, line.Model)
+		return e.Marshal()
+	})
+}
+
+func (s *Server) grpcGenerate(w http.ResponseWriter, r *http.Request, req []byte) (int, string) {
+	body := map[string]interface{}{"stream": true}
+	var options string
+	err := grpcwire.Decode(req, func(f grpcwire.Field) error {
+		switch f.Num {
+		case 1:
+			body["prompt"] = f.String()
+		case 2:
+			body["system"] = f.String()
+		case 3:
+			body["model"] = f.String()
+		case 4:
+			options = f.String()
+		}
+		return nil
+	})
+	if err != nil {
+		return grpcwire.InvalidArgument, "malformed GenerateRequest: " + err.Error()
+	}
+	if code, msg := grpcOptions(body, options); code != grpcwire.OK {
+		return code, msg
+	}
+	return s.grpcStream(w, r, "/api/generate", body, func(line ollamaStreamLine) []byte {
+		var e grpcwire.Encoder
+		e.String(1, line.Response)
+		e.Bool(2, line.Done)
+		e.String(3, line.DoneReason)
+		e.Int(4, int64(line.PromptEvalCount))
+		e.Int(5, int64(line.EvalCount))
+		e.String(6, line.Model)
+		return e.Marshal()
+	})
+}
+
+func (s *Server) grpcEmbed(w http.ResponseWriter, r *http.Request, req []byte) (int, string) {
+	body := map[string]interface{}{}
+	var inputs []string
+	err := grpcwire.Decode(req, func(f grpcwire.Field) error {
+		switch f.Num {
+		case 1:
+			inputs = append(inputs, f.String())
+		case 2:
+			body["model"] = f.String()
+		}
+		return nil
+	})
+	if err != nil {
+		return grpcwire.InvalidArgument, "malformed EmbedRequest: " + err.Error()
+	}
+	if len(inputs) == 0 {
+		return grpcwire.InvalidArgument, "input is required"
+	}
+	body["input"] = inputs
+
+	resp, code, msg := s.grpcCall(r, "POST", "/api/embed", body)
+	if code != grpcwire.OK {
+		return code, msg
+	}
+	var parsed struct {
+		Model           string      `json:"model"`
+		Embeddings      [][]float32 `json:"embeddings"`
+		PromptEvalCount int         `json:"prompt_eval_count"`
+	}
+	if err := json.Unmarshal(resp, &parsed); err != nil {
+		return grpcwire.Internal, "failed to parse embeddings: " + err.Error()
+	}
+	var e grpcwire.Encoder
+	for _, vec := range parsed.Embeddings {
+		var ev grpcwire.Encoder
+		ev.Floats(1, vec)
+		e.Bytes(1, ev.Marshal())
+	}
+	e.Int(2, int64(parsed.PromptEvalCount))
+	e.String(3, parsed.Model)
+	return grpcSend(w, e.Marshal())
+}
+
+func (s *Server) grpcStatus(w http.ResponseWriter, r *http.Request, req []byte) (int, string) {
+	resp, code, msg := s.grpcCall(r, "GET", "/api/status", nil)
+	if code != grpcwire.OK {
+		return code, msg
+	}
+	var parsed struct {
+		Status string `json:"status"`
+		Model  string `json:"model"`
+		Ollama struct {
+			Version string `json:"version"`
+		} `json:"ollama"`
+		UpstreamState struct {
+			State string `json:"state"`
+		} `json:"upstream_state"`
+		ModelLoad struct {
+			State string `json:"state"`
+		} `json:"model_load"`
+		Degraded []string `json:"degraded"`
+	}
+	if err := json.Unmarshal(resp, &parsed); err != nil {
+		return grpcwire.Internal, "failed to parse status: " + err.Error()
+	}
+	var e grpcwire.Encoder
+	e.String(1, parsed.Status)
+	e.String(2, parsed.Model)
+	e.String(3, parsed.Ollama.Version)
+	e.String(4, parsed.UpstreamState.State)
+	e.String(5, parsed.ModelLoad.State)
+	for _, d := range parsed.Degraded {
+		e.String(6, d)
+	}
+	e.String(7, strings.TrimSpace(string(resp)))
+	return grpcSend(w, e.Marshal())
+}
+
+// grpcOptions sets body["options"] from an options_json request field.
+func grpcOptions(body map[string]interface{}, options string) (int, string) {
+	if options == "" {
+		return grpcwire.OK, ""
+	}
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(options), &parsed); err != nil {
+		return grpcwire.InvalidArgument, "options_json is not a JSON object: " + err.Error()
+	}
+	body["options"] = parsed
+	return grpcwire.OK, ""
+}
+
+// ollamaStreamLine is one NDJSON line of a streaming /api/chat or /api/generate response.
+type ollamaStreamLine struct {
+	Model   string `json:"model"`
+	Message struct {
+		Content  string `json:"content"`
+		Thinking string `json:"thinking"`
+	} `json:"message"`
+	Response        string `json:"response"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+// grpcStream runs a streaming request and sends one message per NDJSON line.
+func (s *Server) grpcStream(w http.ResponseWriter, r *http.Request, path string, body map[string]interface{}, encode func(ollamaStreamLine) []byte) (int, string) {
+	var streamErr string
+	lw := &grpcLineWriter{header: http.Header{}, onLine: func(raw []byte) error {
+		var line ollamaStreamLine
+		if err := json.Unmarshal(raw, &line); err != nil {
+			return fmt.Errorf("unexpected upstream line: %v", err)
+		}
+		if line.Error != "" {
+			streamErr = line.Error
+			return nil
+		}
+		if code, msg := grpcSend(w, encode(line)); code != grpcwire.OK {
+			return fmt.Errorf("%s", msg)
+		}
+		return nil
+	}}
+	if code, msg := s.grpcServe(lw, r, "POST", path, body); code != grpcwire.OK {
+		return code, msg
+	}
+	if lw.err != nil {
+		return grpcwire.Internal, lw.err.Error()
+	}
+	if streamErr != "" {
+		return grpcwire.Internal, streamErr
+	}
+	return grpcwire.OK, ""
+}
+
+// grpcCall runs a non-streaming request and returns its body.
+func (s *Server) grpcCall(r *http.Request, method, path string, body map[string]interface{}) ([]byte, int, string) {
+	lw := &grpcLineWriter{header: http.Header{}}
+	code, msg := s.grpcServe(lw, r, method, path, body)
+	return lw.buf, code, msg
+}
+
+// grpcServe sends the translated request through the full HTTP handler
+// chain (limits, templates, breaker, error log). Metadata from the gRPC
+// call (e.g. x-prompt-template, idempotency-key) is passed on as headers.
+func (s *Server) grpcServe(lw *grpcLineWriter, r *http.Request, method, path string, body map[string]interface{}) (int, string) {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req, err := http.NewRequestWithContext(r.Context(), method, path, bytes.NewReader(payload))
+	if err != nil {
+		return grpcwire.Internal, err.Error()
+	}
+	for key, values := range r.Header {
+		switch lower := strings.ToLower(key); {
+		case lower == "content-type", lower == "te", strings.HasPrefix(lower, "grpc-"):
+		default:
+			req.Header[key] = values
+		}
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.RemoteAddr = r.RemoteAddr
+	s.Handler().ServeHTTP(lw, req)
+	lw.finish()
+
+	if lw.status >= http.StatusBadRequest {
+		_, msg := errorCodeFromBody(lw.errBody)
+		if msg == "" {
+			msg = http.StatusText(lw.status)
+		}
+		return grpcwire.CodeForHTTPStatus(lw.status), msg
+	}
+	return grpcwire.OK, ""
+}
+
+// grpcSend writes one response message and flushes it to the client.
+func grpcSend(w http.ResponseWriter, msg []byte) (int, string) {
+	if err := grpcwire.WriteMessage(w, msg); err != nil {
+		return grpcwire.Canceled, "client went away: " + err.Error()
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return grpcwire.OK, ""
+}
+
+// grpcLineWriter is the in-process ResponseWriter for translated requests:
+// with onLine set it hands over each complete line as it is written (the
+// handlers flush NDJSON line by line), otherwise it collects the body.
+type grpcLineWriter struct {
+	header  http.Header
+	status  int
+	buf     []byte
+	errBody []byte
+	onLine  func([]byte) error
+	err     error
+}
+
+func (lw *grpcLineWriter) Header() http.Header { return lw.header }
+
+func (lw *grpcLineWriter) WriteHeader(code int) {
+	if lw.status == 0 {
+		lw.status = code
+	}
+}
+
+func (lw *grpcLineWriter) Write(b []byte) (int, error) {
+	if lw.status == 0 {
+		lw.status = http.StatusOK
+	}
+	if lw.status >= http.StatusBadRequest {
+		if len(lw.errBody) < errorBodyCapture {
+			lw.errBody = append(lw.errBody, b[:min(len(b), errorBodyCapture-len(lw.errBody))]...)
+		}
+		return len(b), nil
+	}
+	if lw.err != nil {
+		return 0, lw.err
+	}
+	lw.buf = append(lw.buf, b...)
+	if lw.onLine == nil {
+		return len(b), nil
+	}
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimSpace(lw.buf[:i])
+		lw.buf = lw.buf[i+1:]
+		if len(line) > 0 {
+			if lw.err = lw.onLine(line); lw.err != nil {
+				return 0, lw.err
+			}
+		}
+	}
+	return len(b), nil
+}
+
+func (lw *grpcLineWriter) Flush() {}
+
+// finish hands over a last line written without a trailing newline.
+func (lw *grpcLineWriter) finish() {
+	if lw.onLine == nil || lw.err != nil || lw.status >= http.StatusBadRequest {
+		return
+	}
+	if line := bytes.TrimSpace(lw.buf); len(line) > 0 {
+		lw.buf = nil
+		lw.err = lw.onLine(line)
+	}
+}
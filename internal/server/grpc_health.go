@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"olares-ollama/internal/grpcwire"
+)
+
+const grpcHealthService = "/grpc.health.v1.Health/"
+
+// Serving states of grpc.health.v1.HealthCheckResponse.
+const (
+	healthServing        = 1
+	healthNotServing     = 2
+	healthServiceUnknown = 3
+)
+
+// healthWatchInterval is how often Watch re-evaluates readiness.
+const healthWatchInterval = time.Second
+
+// grpcHealthStatus returns the serving state for a service name: the
+// overall server ("") and the inference service serve once /readyz would
+// pass, i.e. NOT_SERVING while the model downloads or Ollama is unreachable.
+func (s *Server) grpcHealthStatus(service string) (int, bool) {
+	switch service {
+	case "", strings.Trim(grpcService, "/"):
+	default:
+		return healthServiceUnknown, false
+	}
+	if len(s.readinessReasons()) > 0 {
+		return healthNotServing, true
+	}
+	return healthServing, true
+}
+
+// grpcHealthRequest decodes the service field of a HealthCheckRequest.
+func grpcHealthRequest(req []byte) (string, error) {
+	var service string
+	err := grpcwire.Decode(req, func(f grpcwire.Field) error {
+		if f.Num == 1 {
+			service = f.String()
+		}
+		return nil
+	})
+	return service, err
+}
+
+func grpcHealthResponse(status int) []byte {
+	var e grpcwire.Encoder
+	e.Int(1, int64(status))
+	return e.Marshal()
+}
+
+func (s *Server) grpcHealthCheck(w http.ResponseWriter, r *http.Request, req []byte) (int, string) {
+	service, err := grpcHealthRequest(req)
+	if err != nil {
+		return grpcwire.InvalidArgument, "malformed HealthCheckRequest: " + err.Error()
+	}
+	status, known := s.grpcHealthStatus(service)
+	if !known {
+		return grpcwire.NotFound, "unknown service " + service
+	}
+	return grpcSend(w, grpcHealthResponse(status))
+}
+
+// grpcHealthWatch sends the current state, then every change, until the
+// client cancels the call.
+func (s *Server) grpcHealthWatch(w http.ResponseWriter, r *http.Request, req []byte) (int, string) {
+	service, err := grpcHealthRequest(req)
+	if err != nil {
+		return grpcwire.InvalidArgument, "malformed HealthCheckRequest: " + err.Error()
+	}
+	ticker := time.NewTicker(healthWatchInterval)
+	defer ticker.Stop()
+	last := 0
+	for {
+		if status, _ := s.grpcHealthStatus(service); status != last {
+			if code, msg := grpcSend(w, grpcHealthResponse(status)); code != grpcwire.OK {
+				return code, msg
+			}
+			last = status
+		}
+		select {
+		case <-r.Context().Done():
+			if r.Context().Err() == context.DeadlineExceeded {
+				return grpcwire.DeadlineExceeded, "deadline exceeded"
+			}
+			return grpcwire.Canceled, "watch cancelled"
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,14 +1,15 @@
 package server
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -56,19 +57,9 @@ func matchesModel(ollamaName, configured string) bool {
 // handleTags handles model list requests, forwards from ollama and filters by configured models
 func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
 	log.Printf("=== Tags endpoint: Method=%s, RemoteAddr=%s ===", r.Method, r.RemoteAddr)
-	if r.Method != "GET" {
-		log.Printf("Tags endpoint received unsupported method: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
 
 	// Collect header information
-	headers := make(map[string]string)
-	for key, values := range r.Header {
-		if len(values) > 0 && !strings.HasPrefix(strings.ToLower(key), "host") {
-			headers[key] = values[0]
-		}
-	}
+	headers := s.upstreamHeaders(r)
 
 	// Proxy request to Ollama
 	resp, err := s.ollamaClient.ProxyRequest(
@@ -79,20 +70,13 @@ func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
 	)
 	if err != nil {
 		log.Printf("Failed to proxy request to ollama: %v", err)
-		http.Error(w, "Failed to proxy request", http.StatusInternalServerError)
+		writeUpstreamError(w, ollamaErrorFormat, upstreamErrorFromTransport(err))
 		return
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		// Copy error response
-		for key, values := range resp.Header {
-			for _, value := range values {
-				w.Header().Add(key, value)
-			}
-		}
-		w.WriteHeader(resp.StatusCode)
-		io.Copy(w, resp.Body)
+		writeUpstreamError(w, ollamaErrorFormat, upstreamErrorFromResponse(resp))
 		return
 	}
 
@@ -122,11 +106,26 @@ func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
 		if !ok {
 			continue
 		}
-		if matchesModel(modelName, s.config.Model) {
+		if matchesModel(modelName, s.model()) {
 			filteredModels = append(filteredModels, model)
 		}
 	}
 
+	// Advertise prompt templates as model aliases of the configured model.
+	if len(filteredModels) > 0 {
+		if base, ok := filteredModels[0].(map[string]interface{}); ok {
+			for _, alias := range s.templateAliases() {
+				entry := make(map[string]interface{}, len(base))
+				for k, v := range base {
+					entry[k] = v
+				}
+				entry["name"] = alias
+				entry["model"] = alias
+				filteredModels = append(filteredModels, entry)
+			}
+		}
+	}
+
 	// Build filtered response
 	response := map[string]interface{}{
 		"models": filteredModels,
@@ -138,16 +137,6 @@ func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
 
 // handleGenerate handles text generation requests
 func (s *Server) handleGenerate(w http.ResponseWriter, r *http.Request) {
-	// Allow POST and handle OPTIONS for CORS preflight
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusNoContent)
-		return
-	}
-	if r.Method != "POST" {
-		log.Printf("Generate endpoint received unsupported method: %s from %s", r.Method, r.RemoteAddr)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
 	s.handleInferenceRequest(w, r, "/api/generate")
 }
 
@@ -156,59 +145,12 @@ func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
 	// Log all incoming requests to /api/chat
 	log.Printf("=== Chat endpoint: Method=%s, RemoteAddr=%s, UserAgent=%s, ContentType=%s ===", 
 		r.Method, r.RemoteAddr, r.UserAgent(), r.Header.Get("Content-Type"))
-	
-	// Allow POST and handle OPTIONS for CORS preflight
-	if r.Method == "OPTIONS" {
-		log.Printf("Handling OPTIONS request for /api/chat")
-		w.WriteHeader(http.StatusNoContent)
-		return
-	}
-	// Handle GET requests (used by OpenWebUI for health checks)
-	// OpenWebUI expects a dict/object response (with .get() method), not a list
-	// Return minimal info that indicates this is a valid chat endpoint
-	if r.Method == "GET" {
-		log.Printf("Chat endpoint received GET request from %s (health check)", r.RemoteAddr)
-		userAgent := r.UserAgent()
-		log.Printf("GET request UserAgent: %s, Referer: %s", userAgent, r.Header.Get("Referer"))
-		
-		// Return an object with some basic info - this helps OpenWebUI recognize the endpoint
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Allow", "POST, GET, OPTIONS")  // Explicitly state allowed methods
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status": "ok",
-		})
-		log.Printf("GET request responded with 200 OK (status object)")
-		return
-	}
-	if r.Method != "POST" {
-		log.Printf("Chat endpoint received unsupported method: %s from %s", r.Method, r.RemoteAddr)
-		// Return JSON error response for better compatibility
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Allow", "POST, GET, OPTIONS")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": fmt.Sprintf("Method %s not allowed for /api/chat endpoint. Supported methods: POST, GET, OPTIONS", r.Method),
-		})
-		return
-	}
 	log.Printf("*** Handling POST chat request from %s ***", r.RemoteAddr)
 	s.handleInferenceRequest(w, r, "/api/chat")
 }
 
 // handleEmbeddings handles embedding vector requests
 func (s *Server) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
-	// Allow POST and handle OPTIONS for CORS preflight
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusNoContent)
-		return
-	}
-	if r.Method != "POST" {
-		log.Printf("Embeddings endpoint received unsupported method: %s from %s", r.Method, r.RemoteAddr)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-	
 	// Read request body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -231,6 +173,17 @@ func (s *Server) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+
+	embedModel := s.model()
+	if embedModel == "" {
+		embedModel, _ = requestData["model"].(string)
+	}
+	if !s.guardModelType(w, r, embedModel, "embedding") {
+		return
+	}
+	if !validEmbeddingParams(w, r, requestData) {
+		return
+	}
 	
 	// Log full request for debugging
 	bodyPreview := string(body)
@@ -350,7 +303,15 @@ func (s *Server) handleInferenceRequest(w http.ResponseWriter, r *http.Request,
 	}
 
 	// Replace model parameter
-	requestData["model"] = s.config.Model
+	requestedModel, _ := requestData["model"].(string)
+	requestData["model"] = s.model()
+
+	// Move OpenAI-style sampling parameters where Ollama reads them.
+	if path == "/api/chat" || path == "/api/generate" {
+		if moved := normalizeOllamaOptions(requestData); len(moved) > 0 {
+			traceDecision(r, "params mapped", "%s", strings.Join(moved, ", "))
+		}
+	}
 
 	// Inject default options (repeat_penalty, repeat_last_n) when configured and client didn't specify.
 	if path == "/api/chat" || path == "/api/generate" {
@@ -400,6 +361,16 @@ func (s *Server) handleInferenceRequest(w http.ResponseWriter, r *http.Request,
 		}
 	}
 
+	// Templates, fast lane / limiter, context window and generation policy.
+	if path == "/api/chat" || path == "/api/generate" {
+		traceDecision(r, "params", "passed through")
+		release, ok := s.prepareInference(w, r, requestData, requestedModel, 0)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+
 	// Re-serialize
 	modifiedBody, err := json.Marshal(requestData)
 	if err != nil {
@@ -409,12 +380,7 @@ func (s *Server) handleInferenceRequest(w http.ResponseWriter, r *http.Request,
 	}
 
 	// 收集头部信息
-	headers := make(map[string]string)
-	for key, values := range r.Header {
-		if len(values) > 0 && !strings.HasPrefix(strings.ToLower(key), "host") {
-			headers[key] = values[0]
-		}
-	}
+	headers := s.upstreamHeaders(r)
 	headers["Content-Type"] = "application/json"
 
 	// Log the request being proxied
@@ -423,13 +389,13 @@ func (s *Server) handleInferenceRequest(w http.ResponseWriter, r *http.Request,
 		bodyPreviewLen = 200
 	}
 	log.Printf(">>> Proxying %s request to Ollama %s (model: %s, body size: %d bytes) <<<", 
-		r.Method, path, s.config.Model, len(modifiedBody))
+		r.Method, path, s.model(), len(modifiedBody))
 	if len(modifiedBody) > 0 {
 		log.Printf(">>> Request body preview: %s", string(modifiedBody[:bodyPreviewLen]))
 	}
 
 	// Proxy request to Ollama
-	resp, err := s.ollamaClient.ProxyRequest(
+	resp, err := s.upstreamFor(r, "chat", requestData).ProxyRequest(
 		r.Method,
 		path,
 		bytes.NewReader(modifiedBody),
@@ -437,15 +403,20 @@ func (s *Server) handleInferenceRequest(w http.ResponseWriter, r *http.Request,
 	)
 	if err != nil {
 		log.Printf("!!! Failed to proxy request to Ollama %s: %v !!!", path, err)
-		http.Error(w, "Failed to proxy request", http.StatusInternalServerError)
+		writeUpstreamError(w, ollamaErrorFormat, upstreamErrorFromTransport(err))
 		return
 	}
 	defer resp.Body.Close()
+	resp.Body = tapUsage(r, resp.Body)
+	resp.Body = s.filterOutput(r, requestData, resp.Body)
 
 	// Log response status
 	log.Printf("<<< Ollama returned status %d for %s request to %s <<<", resp.StatusCode, r.Method, path)
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		log.Printf("!!! Warning: Ollama returned non-success status %d !!!", resp.StatusCode)
+	if resp.StatusCode >= http.StatusBadRequest {
+		ue := upstreamErrorFromResponse(resp)
+		log.Printf("!!! Ollama returned status %d for %s: %s !!!", resp.StatusCode, path, ue.Message)
+		writeUpstreamError(w, ollamaErrorFormat, ue)
+		return
 	}
 
 	// Copy response headers, skipping headers managed by the response writer or CORS middleware
@@ -461,11 +432,25 @@ func (s *Server) handleInferenceRequest(w http.ResponseWriter, r *http.Request,
 	}
 
 	// Check if this is a streaming response
+	// (Go's client moves Transfer-Encoding out of resp.Header, so also check
+	// resp.TransferEncoding and Ollama's NDJSON content type.)
 	isStreaming := false
-	if resp.Header.Get("Transfer-Encoding") == "chunked" || resp.Header.Get("Content-Type") == "text/event-stream" {
+	contentType := resp.Header.Get("Content-Type")
+	if resp.Header.Get("Transfer-Encoding") == "chunked" || contentType == "text/event-stream" ||
+		strings.HasPrefix(contentType, "application/x-ndjson") ||
+		(len(resp.TransferEncoding) > 0 && resp.TransferEncoding[0] == "chunked") {
 		isStreaming = true
-		w.Header().Set("Transfer-Encoding", "chunked")
-		w.Header().Set("Connection", "keep-alive")
+	}
+
+	// Non-streaming responses are read fully first so usage headers can be
+	// set before the status line goes out.
+	if !isStreaming {
+		if err := bufferUpstreamBody(resp); err != nil {
+			log.Printf("!!! Error reading response from Ollama for %s: %v !!!", path, err)
+			writeUpstreamError(w, ollamaErrorFormat, upstreamErrorFromTransport(err))
+			return
+		}
+		s.repairJSONOutput(w, r, path, requestData, headers, resp)
 	}
 
 	// Set status code
@@ -478,7 +463,12 @@ func (s *Server) handleInferenceRequest(w http.ResponseWriter, r *http.Request,
 
 	// Stream copy response body with proper flushing for streaming responses
 	if isStreaming {
-		if flusher, ok := w.(http.Flusher); ok {
+		if flusher, ok := w.(http.Flusher); ok && strings.HasPrefix(contentType, "application/x-ndjson") {
+			// Line by line, so a broken stream ends with an error line
+			// rather than half a JSON object
+			totalBytes := s.relayNDJSON(w, flusher, resp.Body, path)
+			log.Printf("<<< Copied %d bytes from Ollama stream for %s <<<", totalBytes, path)
+		} else if flusher, ok := w.(http.Flusher); ok {
 			// Use buffered copy with periodic flushing for streaming
 			buffer := make([]byte, 4096)
 			var totalBytes int64
@@ -498,6 +488,7 @@ func (s *Server) handleInferenceRequest(w http.ResponseWriter, r *http.Request,
 				}
 				if err != nil {
 					log.Printf("!!! Error reading from Ollama for %s: %v !!!", path, err)
+					s.recordStreamError(path, err)
 					break
 				}
 			}
@@ -538,16 +529,14 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		defer r.Body.Close()
+		if r.URL.Path == "/api/show" {
+			bodyBytes = s.adaptModelKey(bodyBytes)
+		}
 		body = bytes.NewReader(bodyBytes)
 	}
 
 	// Collect header information
-	headers := make(map[string]string)
-	for key, values := range r.Header {
-		if len(values) > 0 && !strings.HasPrefix(strings.ToLower(key), "host") {
-			headers[key] = values[0]
-		}
-	}
+	headers := s.upstreamHeaders(r)
 
 	// Proxy request to Ollama
 	resp, err := s.ollamaClient.ProxyRequest(
@@ -558,11 +547,16 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 	)
 	if err != nil {
 		log.Printf("Failed to proxy request: %v", err)
-		http.Error(w, "Failed to proxy request", http.StatusInternalServerError)
+		writeUpstreamError(w, ollamaErrorFormat, upstreamErrorFromTransport(err))
 		return
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= http.StatusBadRequest {
+		writeUpstreamError(w, ollamaErrorFormat, upstreamErrorFromResponse(resp))
+		return
+	}
+
 	// Copy response headers, skipping CORS headers already set by middleware
 	for key, values := range resp.Header {
 		if strings.HasPrefix(strings.ToLower(key), "access-control-") {
@@ -584,8 +578,9 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 // (and /v1/messages/count_tokens) to the upstream Ollama server.
 //
 // Behaviour mirrors handleOpenAIChat:
-//   - replaces the "model" field with the configured model so this proxy
-//     always serves exactly the one model it was deployed for;
+//   - replaces the "model" field with the configured model and runs
+//     /v1/messages through prepareInference (routes, templates, limits,
+//     context fitting, the generation policy, ...);
 //   - preserves Anthropic auth headers (x-api-key, anthropic-version, ...);
 //   - streams Server-Sent Events back to the client when Ollama responds
 //     with text/event-stream (i.e. when the request had "stream": true).
@@ -593,26 +588,6 @@ func (s *Server) handleAnthropicMessages(w http.ResponseWriter, r *http.Request)
 	log.Printf("=== Anthropic Messages endpoint: %s %s, RemoteAddr=%s ===",
 		r.Method, r.URL.Path, r.RemoteAddr)
 
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusNoContent)
-		return
-	}
-	if r.Method == "GET" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok"})
-		return
-	}
-	if r.Method != "POST" {
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Allow", "POST, GET, OPTIONS")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": fmt.Sprintf("Method %s not allowed for %s", r.Method, r.URL.Path),
-		})
-		return
-	}
-
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		log.Printf("Anthropic Messages: failed to read body: %v", err)
@@ -627,10 +602,24 @@ func (s *Server) handleAnthropicMessages(w http.ResponseWriter, r *http.Request)
 
 	// Force-replace "model" with the configured one (when configured).
 	// In base mode (no Model set) we pass the body through unchanged.
-	if s.config.Model != "" {
+	if s.model() != "" {
 		var requestData map[string]interface{}
 		if err := json.Unmarshal(body, &requestData); err == nil {
-			requestData["model"] = s.config.Model
+			requested, _ := requestData["model"].(string)
+			requestData["model"] = s.model()
+			if r.URL.Path == "/v1/messages" {
+				// The same pipeline as the other inference endpoints, on
+				// the request in chat shape.
+				anthropicToChat(requestData)
+				release, ok := s.prepareInference(w, r, requestData, requested, intParam(requestData["max_tokens"]))
+				if !ok {
+					return
+				}
+				defer release()
+				chatToAnthropic(requestData)
+			} else if meta := metaFrom(r); meta != nil {
+				meta.update(func(m *requestMeta) { m.requestedModel = requested })
+			}
 			if modified, mErr := json.Marshal(requestData); mErr == nil {
 				body = modified
 			} else {
@@ -641,15 +630,9 @@ func (s *Server) handleAnthropicMessages(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	// Forward all client headers except Host. Anthropic auth headers
-	// (x-api-key, anthropic-version, anthropic-beta, authorization) are
-	// preserved so Ollama sees the same request the client sent.
-	headers := make(map[string]string)
-	for key, values := range r.Header {
-		if len(values) > 0 && !strings.HasPrefix(strings.ToLower(key), "host") {
-			headers[key] = values[0]
-		}
-	}
+	// Forward the client headers allowed by FORWARD_HEADERS/DROP_HEADERS;
+	// anthropic-version and anthropic-beta pass by default, credentials don't.
+	headers := s.upstreamHeaders(r)
 	headers["Content-Type"] = "application/json"
 
 	previewLen := len(body)
@@ -657,12 +640,14 @@ func (s *Server) handleAnthropicMessages(w http.ResponseWriter, r *http.Request)
 		previewLen = 200
 	}
 	log.Printf(">>> Proxying %s %s to Ollama (model: %s, body: %d bytes)",
-		r.Method, r.URL.Path, s.config.Model, len(body))
+		r.Method, r.URL.Path, s.model(), len(body))
 	if previewLen > 0 {
 		log.Printf(">>> Body preview: %s", string(body[:previewLen]))
 	}
 
-	resp, err := s.ollamaClient.ProxyRequest(
+	var routed map[string]interface{}
+	json.Unmarshal(body, &routed) // not JSON: OLLAMA_URL
+	resp, err := s.upstreamFor(r, "chat", routed).ProxyRequest(
 		r.Method,
 		r.URL.Path,
 		bytes.NewReader(body),
@@ -670,10 +655,11 @@ func (s *Server) handleAnthropicMessages(w http.ResponseWriter, r *http.Request)
 	)
 	if err != nil {
 		log.Printf("!!! Anthropic Messages: failed to proxy to Ollama: %v !!!", err)
-		http.Error(w, "Failed to proxy request", http.StatusBadGateway)
+		writeUpstreamError(w, errorFormatForPath(r.URL.Path), upstreamErrorFromTransport(err))
 		return
 	}
 	defer resp.Body.Close()
+	resp.Body = tapUsage(r, resp.Body)
 
 	log.Printf("<<< Ollama returned status %d for %s %s", resp.StatusCode, r.Method, r.URL.Path)
 
@@ -691,9 +677,12 @@ func (s *Server) handleAnthropicMessages(w http.ResponseWriter, r *http.Request)
 	contentType := resp.Header.Get("Content-Type")
 	isStreaming := resp.Header.Get("Transfer-Encoding") == "chunked" ||
 		strings.HasPrefix(contentType, "text/event-stream")
-	if isStreaming {
-		w.Header().Set("Transfer-Encoding", "chunked")
-		w.Header().Set("Connection", "keep-alive")
+	if !isStreaming {
+		if err := bufferUpstreamBody(resp); err != nil {
+			log.Printf("!!! Anthropic Messages: read error: %v", err)
+			http.Error(w, "Failed to read upstream response", http.StatusBadGateway)
+			return
+		}
 	}
 
 	w.WriteHeader(resp.StatusCode)
@@ -718,6 +707,9 @@ func (s *Server) handleAnthropicMessages(w http.ResponseWriter, r *http.Request)
 				}
 				if rerr != nil {
 					log.Printf("!!! Anthropic Messages: read error: %v", rerr)
+					f := &streamFault{code: "stream_interrupted", message: "Ollama stream interrupted: " + rerr.Error()}
+					s.recordStreamError(r.URL.Path, f)
+					writeAnthropicStreamFault(w, f)
 					break
 				}
 			}
@@ -745,32 +737,6 @@ func (s *Server) handleOpenAIChat(w http.ResponseWriter, r *http.Request) {
 	log.Printf("=== Full URL: %s ===", r.URL.String())
 	log.Printf("=== Headers: %v ===", r.Header)
 	
-	if r.Method == "OPTIONS" {
-		log.Printf("OpenAI Chat Completions: Handling OPTIONS preflight")
-		w.WriteHeader(http.StatusNoContent)
-		return
-	}
-	
-	if r.Method == "GET" {
-		log.Printf("OpenAI Chat Completions received GET request (health check)")
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status": "ok",
-		})
-		return
-	}
-	
-	if r.Method != "POST" {
-		log.Printf("!!! OpenAI Chat Completions received unsupported method: %s !!!", r.Method)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": "Method not allowed",
-		})
-		return
-	}
-	
 	log.Printf("*** Handling OpenAI Chat Completions POST request from %s ***", r.RemoteAddr)
 	// Convert OpenAI format to Ollama format and proxy
 	s.handleOpenAIInferenceRequest(w, r)
@@ -787,28 +753,6 @@ func (s *Server) handleOpenAIResponses(w http.ResponseWriter, r *http.Request) {
 	log.Printf("=== OpenAI Responses API endpoint: %s %s, RemoteAddr=%s ===",
 		r.Method, r.URL.Path, r.RemoteAddr)
 
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusNoContent)
-		return
-	}
-	if r.Method == "GET" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok"})
-		return
-	}
-	if r.Method != "POST" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": map[string]interface{}{
-				"message": "Method not allowed",
-				"type":    "invalid_request_error",
-			},
-		})
-		return
-	}
-
 	s.handleOpenAIResponsesRequest(w, r)
 }
 
@@ -862,7 +806,7 @@ func (s *Server) handleOpenAIResponsesRequest(w http.ResponseWriter, r *http.Req
 	}
 
 	ollamaRequest := map[string]interface{}{
-		"model":    s.config.Model,
+		"model":    s.model(),
 		"messages": ollamaMessages,
 		"stream":   stream,
 	}
@@ -875,12 +819,7 @@ func (s *Server) handleOpenAIResponsesRequest(w http.ResponseWriter, r *http.Req
 	if s.config.RepeatLastN > 0 {
 		options["repeat_last_n"] = s.config.RepeatLastN
 	}
-	if temp, ok := req["temperature"]; ok {
-		options["temperature"] = temp
-	}
-	if topP, ok := req["top_p"]; ok {
-		options["top_p"] = topP
-	}
+	mapSamplingParams(req, options)
 	if maxOut, ok := req["max_output_tokens"]; ok {
 		options["num_predict"] = maxOut
 	}
@@ -895,7 +834,13 @@ func (s *Server) handleOpenAIResponsesRequest(w http.ResponseWriter, r *http.Req
 		}
 	}
 	if tc, ok := req["tool_choice"]; ok {
-		ollamaRequest["tool_choice"] = tc
+		if err := applyToolChoice(ollamaRequest, tc); err != nil {
+			writeError(w, openAIErrorFormat, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+	}
+	if text, ok := req["text"].(map[string]interface{}); ok {
+		applyResponseFormat(ollamaRequest, text["format"])
 	}
 
 	// Resolve thinking / reasoning.
@@ -911,6 +856,14 @@ func (s *Server) handleOpenAIResponsesRequest(w http.ResponseWriter, r *http.Req
 		}
 	}
 
+	noteParams(w, r, req, responsesParams)
+	requestedModel, _ := req["model"].(string)
+	release, ok := s.prepareInference(w, r, ollamaRequest, requestedModel, 0)
+	if !ok {
+		return
+	}
+	defer release()
+
 	modifiedBody, err := json.Marshal(ollamaRequest)
 	if err != nil {
 		log.Printf("!!! Failed to marshal Ollama request: %v !!!", err)
@@ -919,54 +872,47 @@ func (s *Server) handleOpenAIResponsesRequest(w http.ResponseWriter, r *http.Req
 	}
 
 	log.Printf(">>> Converted Responses API → Ollama: size=%d, model=%s, msgs=%d, stream=%v <<<",
-		len(modifiedBody), s.config.Model, len(ollamaMessages), stream)
+		len(modifiedBody), s.model(), len(ollamaMessages), stream)
 
-	headers := make(map[string]string)
-	for key, values := range r.Header {
-		if len(values) > 0 && !strings.HasPrefix(strings.ToLower(key), "host") {
-			headers[key] = values[0]
-		}
-	}
+	headers := s.upstreamHeaders(r)
 	headers["Content-Type"] = "application/json"
 
-	resp, err := s.ollamaClient.ProxyRequest("POST", "/api/chat", bytes.NewReader(modifiedBody), headers)
+	resp, err := s.upstreamFor(r, "chat", ollamaRequest).ProxyRequest("POST", "/api/chat", bytes.NewReader(modifiedBody), headers)
 	if err != nil {
 		log.Printf("!!! Failed to proxy Responses API → Ollama: %v !!!", err)
-		http.Error(w, "Failed to proxy request", http.StatusInternalServerError)
+		writeUpstreamError(w, openAIErrorFormat, upstreamErrorFromTransport(err))
 		return
 	}
 	defer resp.Body.Close()
+	resp.Body = tapUsage(r, resp.Body)
+	resp.Body = s.filterOutput(r, ollamaRequest, resp.Body)
 
 	log.Printf("<<< Ollama returned status %d for Responses API request <<<", resp.StatusCode)
 
 	if resp.StatusCode != http.StatusOK {
-		errorBody, _ := io.ReadAll(resp.Body)
-		log.Printf("!!! Ollama error: %s !!!", string(errorBody))
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(resp.StatusCode)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": map[string]interface{}{
-				"message": fmt.Sprintf("Upstream error: %s", string(errorBody)),
-				"type":    "server_error",
-			},
-		})
+		ue := upstreamErrorFromResponse(resp)
+		log.Printf("!!! Ollama error: %s !!!", ue.Message)
+		writeUpstreamError(w, openAIErrorFormat, ue)
 		return
 	}
 
 	if stream {
 		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Transfer-Encoding", "chunked")
-		w.Header().Set("Connection", "keep-alive")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.WriteHeader(http.StatusOK)
 		if f, ok := w.(http.Flusher); ok {
 			f.Flush()
 		}
-		s.convertOllamaStreamToResponsesAPI(w, resp.Body, s.config.Model)
+		s.convertOllamaStreamToResponsesAPI(w, resp.Body, s.responseModel(r))
 	} else {
+		if err := bufferUpstreamBody(resp); err != nil {
+			writeUpstreamError(w, openAIErrorFormat, upstreamErrorFromTransport(err))
+			return
+		}
+		s.repairJSONOutput(w, r, "/api/chat", ollamaRequest, headers, resp)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		s.convertOllamaToResponsesAPI(w, resp.Body, s.config.Model)
+		s.convertOllamaToResponsesAPI(w, resp.Body, s.responseModel(r))
 	}
 }
 
@@ -1173,10 +1119,11 @@ func (s *Server) convertOllamaToResponsesAPI(w http.ResponseWriter, body io.Read
 	}
 
 	usage := extractUsage(ollamaResp)
+	_, status, incomplete := responsesOutcome(ollamaResp)
 
 	result := map[string]interface{}{
 		"id": responseID, "object": "response", "created_at": now,
-		"status": "completed", "model": modelName, "output": output, "usage": usage,
+		"status": status, "incomplete_details": incomplete, "model": modelName, "output": output, "usage": usage,
 	}
 
 	out, _ := json.Marshal(result)
@@ -1191,9 +1138,7 @@ func (s *Server) convertOllamaToResponsesAPI(w http.ResponseWriter, body io.Read
 // into the OpenAI Responses API Server-Sent Events format.
 func (s *Server) convertOllamaStreamToResponsesAPI(w http.ResponseWriter, body io.Reader, modelName string) {
 	flusher, hasFlusher := w.(http.Flusher)
-	scanner := bufio.NewScanner(body)
-	buf := make([]byte, 0, 256*1024)
-	scanner.Buffer(buf, 1024*1024)
+	stream := newUpstreamStream(body)
 
 	now := time.Now().Unix()
 	responseID := fmt.Sprintf("resp_%d", now)
@@ -1201,8 +1146,11 @@ func (s *Server) convertOllamaStreamToResponsesAPI(w http.ResponseWriter, body i
 
 	var fullText strings.Builder
 	headerSent := false
+	seq := 0 // sequence_number: events are numbered in the order they are sent
 
-	emit := func(v interface{}) {
+	emit := func(v map[string]interface{}) {
+		v["sequence_number"] = seq
+		seq++
 		data, _ := json.Marshal(v)
 		w.Write([]byte(fmt.Sprintf("data: %s\n\n", data)))
 		if hasFlusher {
@@ -1221,6 +1169,7 @@ func (s *Server) convertOllamaStreamToResponsesAPI(w http.ResponseWriter, body i
 			"status": "in_progress", "model": modelName, "output": []interface{}{},
 		}
 		emit(map[string]interface{}{"type": "response.created", "response": baseResp})
+		emit(map[string]interface{}{"type": "response.in_progress", "response": baseResp})
 
 		emit(map[string]interface{}{
 			"type": "response.output_item.added", "output_index": 0,
@@ -1231,7 +1180,7 @@ func (s *Server) convertOllamaStreamToResponsesAPI(w http.ResponseWriter, body i
 		})
 
 		emit(map[string]interface{}{
-			"type": "response.content_part.added", "output_index": 0, "content_index": 0,
+			"type": "response.content_part.added", "item_id": msgID, "output_index": 0, "content_index": 0,
 			"part": map[string]interface{}{
 				"type": "output_text", "text": "", "annotations": []interface{}{},
 			},
@@ -1242,11 +1191,11 @@ func (s *Server) convertOllamaStreamToResponsesAPI(w http.ResponseWriter, body i
 		txt := fullText.String()
 
 		emit(map[string]interface{}{
-			"type": "response.output_text.done", "output_index": 0, "content_index": 0,
-			"text": txt,
+			"type": "response.output_text.done", "item_id": msgID, "output_index": 0, "content_index": 0,
+			"text": txt, "logprobs": []interface{}{},
 		})
 		emit(map[string]interface{}{
-			"type": "response.content_part.done", "output_index": 0, "content_index": 0,
+			"type": "response.content_part.done", "item_id": msgID, "output_index": 0, "content_index": 0,
 			"part": map[string]interface{}{"type": "output_text", "text": txt, "annotations": []interface{}{}},
 		})
 
@@ -1259,24 +1208,15 @@ func (s *Server) convertOllamaStreamToResponsesAPI(w http.ResponseWriter, body i
 		emit(map[string]interface{}{"type": "response.output_item.done", "output_index": 0, "item": msgItem})
 	}
 
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			continue
-		}
-
-		var chunk map[string]interface{}
-		if err := json.Unmarshal(line, &chunk); err != nil {
-			log.Printf("!!! Responses stream: bad JSON line: %v !!!", err)
-			continue
-		}
-
+	for stream.next() {
+		chunk := stream.chunk
 		message, _ := chunk["message"].(map[string]interface{})
 		done, _ := chunk["done"].(bool)
 
 		if done {
 			usage := extractUsage(chunk)
 			hasToolCalls := false
+			event, status, incomplete := responsesOutcome(chunk)
 
 			if message != nil {
 				if rawTC, ok := message["tool_calls"].([]interface{}); ok && len(rawTC) > 0 {
@@ -1319,11 +1259,11 @@ func (s *Server) convertOllamaStreamToResponsesAPI(w http.ResponseWriter, body i
 							},
 						})
 						emit(map[string]interface{}{
-							"type": "response.function_call_arguments.delta",
+							"type": "response.function_call_arguments.delta", "item_id": fcID,
 							"output_index": outIdx, "delta": argsStr,
 						})
 						emit(map[string]interface{}{
-							"type": "response.function_call_arguments.done",
+							"type": "response.function_call_arguments.done", "item_id": fcID,
 							"output_index": outIdx, "arguments": argsStr,
 						})
 
@@ -1338,10 +1278,10 @@ func (s *Server) convertOllamaStreamToResponsesAPI(w http.ResponseWriter, body i
 					}
 
 					emit(map[string]interface{}{
-						"type": "response.completed",
+						"type": event,
 						"response": map[string]interface{}{
 							"id": responseID, "object": "response", "created_at": now,
-							"status": "completed", "model": modelName,
+							"status": status, "incomplete_details": incomplete, "model": modelName,
 							"output": outputItems, "usage": usage,
 						},
 					})
@@ -1355,10 +1295,10 @@ func (s *Server) convertOllamaStreamToResponsesAPI(w http.ResponseWriter, body i
 				finishText(usage)
 
 				emit(map[string]interface{}{
-					"type": "response.completed",
+					"type": event,
 					"response": map[string]interface{}{
 						"id": responseID, "object": "response", "created_at": now,
-						"status": "completed", "model": modelName,
+						"status": status, "incomplete_details": incomplete, "model": modelName,
 						"output": []interface{}{
 							map[string]interface{}{
 								"type": "message", "id": msgID, "status": "completed", "role": "assistant",
@@ -1385,18 +1325,30 @@ func (s *Server) convertOllamaStreamToResponsesAPI(w http.ResponseWriter, body i
 			}
 			fullText.WriteString(content)
 			emit(map[string]interface{}{
-				"type": "response.output_text.delta", "output_index": 0, "content_index": 0,
-				"delta": content,
+				"type": "response.output_text.delta", "item_id": msgID, "output_index": 0, "content_index": 0,
+				"delta": content, "logprobs": []interface{}{},
 			})
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Printf("!!! Responses stream scanner error: %v !!!", err)
+	if f := stream.failed(); f != nil {
+		s.recordStreamError("/v1/responses", f)
+		writeResponsesStreamFault(w, f, responseID, modelName, now)
 	}
 	log.Printf("<<< Converted and sent Responses API stream <<<")
 }
 
+// responsesOutcome maps the done chunk of a generation to how a Responses
+// API response ends: the final event type, the status and its
+// incomplete_details. A generation stopped by num_predict is incomplete
+// for max_output_tokens; anything else is completed.
+func responsesOutcome(done map[string]interface{}) (event, status string, incomplete map[string]interface{}) {
+	if doneFinishReason(done, "stop") == "length" {
+		return "response.incomplete", "incomplete", map[string]interface{}{"reason": "max_output_tokens"}
+	}
+	return "response.completed", "completed", nil
+}
+
 // marshalArgs converts Ollama tool-call arguments (string or map) to a JSON string.
 func marshalArgs(args interface{}) string {
 	if args == nil {
@@ -1438,23 +1390,8 @@ func writeResponsesError(w http.ResponseWriter, msg string) {
 func (s *Server) handleOpenAIModels(w http.ResponseWriter, r *http.Request) {
 	log.Printf("=== OpenAI Models endpoint: Method=%s ===", r.Method)
 	
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusNoContent)
-		return
-	}
-	
-	if r.Method != "GET" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
-	}
-	
 	// Get model list from Ollama
-	headers := make(map[string]string)
-	for key, values := range r.Header {
-		if len(values) > 0 && !strings.HasPrefix(strings.ToLower(key), "host") {
-			headers[key] = values[0]
-		}
-	}
+	headers := s.upstreamHeaders(r)
 	
 	// Proxy request to Ollama /api/tags
 	resp, err := s.ollamaClient.ProxyRequest(
@@ -1465,20 +1402,13 @@ func (s *Server) handleOpenAIModels(w http.ResponseWriter, r *http.Request) {
 	)
 	if err != nil {
 		log.Printf("Failed to proxy request to ollama: %v", err)
-		http.Error(w, "Failed to proxy request", http.StatusInternalServerError)
+		writeUpstreamError(w, openAIErrorFormat, upstreamErrorFromTransport(err))
 		return
 	}
 	defer resp.Body.Close()
 	
 	if resp.StatusCode != http.StatusOK {
-		// Copy error response
-		for key, values := range resp.Header {
-			for _, value := range values {
-				w.Header().Add(key, value)
-			}
-		}
-		w.WriteHeader(resp.StatusCode)
-		io.Copy(w, resp.Body)
+		writeUpstreamError(w, openAIErrorFormat, upstreamErrorFromResponse(resp))
 		return
 	}
 	
@@ -1511,7 +1441,7 @@ func (s *Server) handleOpenAIModels(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		
-		if !matchesModel(modelName, s.config.Model) {
+		if !matchesModel(modelName, s.model()) {
 			continue
 		}
 		
@@ -1534,6 +1464,18 @@ func (s *Server) handleOpenAIModels(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 	
+	// Advertise prompt templates as model aliases of the configured model.
+	if len(openAIData) > 0 {
+		for _, alias := range s.templateAliases() {
+			openAIData = append(openAIData, map[string]interface{}{
+				"id":       alias,
+				"object":   "model",
+				"created":  openAIData[0]["created"],
+				"owned_by": "olares-ollama",
+			})
+		}
+	}
+
 	// Return OpenAI format with "object" field first
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -1645,30 +1587,60 @@ func (s *Server) handleOpenAIInferenceRequest(w http.ResponseWriter, r *http.Req
 			includeUsage = iu
 		}
 	}
+
+	// n > 1 on a stream fans out into parallel generations (streamChoices).
+	choices := 1
+	if stream {
+		if n := intParam(openaiRequest["n"]); n > 1 {
+			choices = n
+		}
+	}
+	if choices > maxStreamChoices {
+		writeError(w, openAIErrorFormat, http.StatusBadRequest, "invalid_request",
+			fmt.Sprintf("'n' must be at most %d when streaming", maxStreamChoices))
+		return
+	}
 	
 	ollamaRequest := map[string]interface{}{
-		"model":    s.config.Model,
+		"model":    s.model(),
 		"messages": ollamaMessages,
 		"stream":   stream,
 	}
-	// Inject default options (repeat_penalty, repeat_last_n) when configured.
-	if s.config.RepeatPenalty > 0 || s.config.RepeatLastN > 0 {
-		options := map[string]interface{}{}
-		if s.config.RepeatPenalty > 0 {
-			options["repeat_penalty"] = s.config.RepeatPenalty
-		}
-		if s.config.RepeatLastN > 0 {
-			options["repeat_last_n"] = s.config.RepeatLastN
-		}
+	// Inject default options (repeat_penalty, repeat_last_n) when configured,
+	// then the client's sampling parameters and max_tokens (the newer
+	// max_completion_tokens wins).
+	options := map[string]interface{}{}
+	if s.config.RepeatPenalty > 0 {
+		options["repeat_penalty"] = s.config.RepeatPenalty
+	}
+	if s.config.RepeatLastN > 0 {
+		options["repeat_last_n"] = s.config.RepeatLastN
+	}
+	mapSamplingParams(openaiRequest, options)
+	maxTokens := intParam(openaiRequest["max_completion_tokens"])
+	if maxTokens == 0 {
+		maxTokens = intParam(openaiRequest["max_tokens"])
+	}
+	if maxTokens != 0 {
+		options["num_predict"] = maxTokens
+	}
+	if len(options) > 0 {
 		ollamaRequest["options"] = options
 	}
 
-	// Pass through tools and tool_choice for function/tool calling
+	// Pass through tools for function/tool calling, narrowed by tool_choice
 	if tools, ok := openaiRequest["tools"]; ok {
 		ollamaRequest["tools"] = tools
 	}
 	if toolChoice, ok := openaiRequest["tool_choice"]; ok {
-		ollamaRequest["tool_choice"] = toolChoice
+		if err := applyToolChoice(ollamaRequest, toolChoice); err != nil {
+			writeError(w, openAIErrorFormat, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+	}
+	// JSON mode / structured outputs
+	if responseFormat, ok := openaiRequest["response_format"]; ok {
+		applyResponseFormat(ollamaRequest, responseFormat)
 	}
 
 	// Resolve "think" for thinking models.
@@ -1694,6 +1666,30 @@ func (s *Server) handleOpenAIInferenceRequest(w http.ResponseWriter, r *http.Req
 		}
 	}
 	
+	if stop, ok := openaiRequest["stop"]; ok {
+		options, _ := ollamaRequest["options"].(map[string]interface{})
+		if options == nil {
+			options = map[string]interface{}{}
+			ollamaRequest["options"] = options
+		}
+		options["stop"] = stop
+	}
+	if choices > 1 {
+		noteParams(w, r, openaiRequest, openAIChatStreamParams)
+	} else {
+		noteParams(w, r, openaiRequest, openAIChatParams)
+	}
+	requestedModel, _ := openaiRequest["model"].(string)
+	release, ok := s.prepareInference(w, r, ollamaRequest, requestedModel, maxTokens)
+	if !ok {
+		return
+	}
+	defer release()
+	if choices > 1 {
+		s.streamChoices(w, r, ollamaRequest, choices, includeUsage, maxTokens)
+		return
+	}
+
 	modifiedBody, err := json.Marshal(ollamaRequest)
 	if err != nil {
 		log.Printf("!!! Failed to marshal Ollama request: %v !!!", err)
@@ -1702,21 +1698,16 @@ func (s *Server) handleOpenAIInferenceRequest(w http.ResponseWriter, r *http.Req
 	}
 	
 	log.Printf(">>> Converted to Ollama format: body size=%d bytes, model=%s, messages=%d, stream=%v <<<",
-		len(modifiedBody), s.config.Model, len(ollamaMessages), stream)
+		len(modifiedBody), s.model(), len(ollamaMessages), stream)
 	
 	// Collect headers
-	headers := make(map[string]string)
-	for key, values := range r.Header {
-		if len(values) > 0 && !strings.HasPrefix(strings.ToLower(key), "host") {
-			headers[key] = values[0]
-		}
-	}
+	headers := s.upstreamHeaders(r)
 	headers["Content-Type"] = "application/json"
 	
-	log.Printf(">>> Proxying OpenAI request to Ollama /api/chat (model: %s) <<<", s.config.Model)
+	log.Printf(">>> Proxying OpenAI request to Ollama /api/chat (model: %s) <<<", s.model())
 	
 	// Proxy to Ollama
-	resp, err := s.ollamaClient.ProxyRequest(
+	resp, err := s.upstreamFor(r, "chat", ollamaRequest).ProxyRequest(
 		"POST",
 		"/api/chat",
 		bytes.NewReader(modifiedBody),
@@ -1724,22 +1715,33 @@ func (s *Server) handleOpenAIInferenceRequest(w http.ResponseWriter, r *http.Req
 	)
 	if err != nil {
 		log.Printf("!!! Failed to proxy OpenAI request to Ollama: %v !!!", err)
-		http.Error(w, "Failed to proxy request", http.StatusInternalServerError)
+		writeUpstreamError(w, openAIErrorFormat, upstreamErrorFromTransport(err))
 		return
 	}
 	defer resp.Body.Close()
+	resp.Body = tapUsage(r, resp.Body)
+	resp.Body = s.filterOutput(r, ollamaRequest, resp.Body)
 	
 	log.Printf("<<< Ollama returned status %d for OpenAI request <<<", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		ue := upstreamErrorFromResponse(resp)
+		log.Printf("!!! Ollama error for OpenAI request: %s !!!", ue.Message)
+		writeUpstreamError(w, openAIErrorFormat, ue)
+		return
+	}
 	
 	// Set OpenAI-compatible response headers
 	if stream {
 		// OpenAI streaming uses Server-Sent Events (SSE) format
 		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Transfer-Encoding", "chunked")
-		w.Header().Set("Connection", "keep-alive")
 		w.Header().Set("Cache-Control", "no-cache")
 	} else {
 		w.Header().Set("Content-Type", "application/json")
+		if err := bufferUpstreamBody(resp); err != nil {
+			writeUpstreamError(w, openAIErrorFormat, upstreamErrorFromTransport(err))
+			return
+		}
+		s.repairJSONOutput(w, r, "/api/chat", ollamaRequest, headers, resp)
 	}
 	
 	w.WriteHeader(resp.StatusCode)
@@ -1754,10 +1756,10 @@ func (s *Server) handleOpenAIInferenceRequest(w http.ResponseWriter, r *http.Req
 	// Convert Ollama response to OpenAI format
 	if stream {
 		// Handle streaming response
-		s.convertOllamaStreamToOpenAI(w, resp.Body, s.config.Model, includeUsage)
+		s.convertOllamaStreamToOpenAI(w, resp.Body, s.responseModel(r), includeUsage, streamTokenBudget(r, ollamaRequest, maxTokens))
 	} else {
 		// Handle non-streaming response
-		s.convertOllamaToOpenAI(w, resp.Body, s.config.Model)
+		s.convertOllamaToOpenAI(w, resp.Body, s.responseModel(r))
 	}
 }
 
@@ -1796,7 +1798,7 @@ func (s *Server) convertOllamaToOpenAI(w http.ResponseWriter, body io.Reader, mo
 		"role":    role,
 		"content": content,
 	}
-	finishReason := "stop"
+	finishReason := doneFinishReason(ollamaResp, "stop")
 	
 	// Handle tool_calls in response
 	if rawToolCalls, ok := message["tool_calls"].([]interface{}); ok && len(rawToolCalls) > 0 {
@@ -1857,40 +1859,39 @@ func (s *Server) convertOllamaToOpenAI(w http.ResponseWriter, body io.Reader, mo
 // When includeUsage is true (client sent stream_options.include_usage=true),
 // an extra usage-only chunk is emitted before [DONE] per OpenAI spec, so callers
 // that track token consumption (LangChain, OpenAI SDKs >=1.x) see real numbers.
-func (s *Server) convertOllamaStreamToOpenAI(w http.ResponseWriter, body io.Reader, modelName string, includeUsage bool) {
+// A model that streams past budget is cut off with finish_reason "length".
+func (s *Server) convertOllamaStreamToOpenAI(w http.ResponseWriter, body io.Reader, modelName string, includeUsage bool, budget *tokenBudget) {
 	flusher, hasFlusher := w.(http.Flusher)
-	scanner := bufio.NewScanner(body)
-	// Larger buffer: Ollama can emit very long lines with reasoning_content + tool_calls
-	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	stream := newUpstreamStream(body)
 	responseID := fmt.Sprintf("chatcmpl-%d", time.Now().Unix())
 	created := time.Now().Unix()
 	var totalBytes int64
 	roleSent := false
+	toolCalls := 0 // tool calls sent so far; current Ollama streams them before the done chunk
 	
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			continue
-		}
-		
-		var ollamaResp map[string]interface{}
-		if err := json.Unmarshal(line, &ollamaResp); err != nil {
-			log.Printf("!!! Error parsing Ollama stream line: %v, line: %s !!!", err, string(line))
-			continue
-		}
+	for stream.next() {
+		ollamaResp := stream.chunk
 		
 		// Extract message (present in both intermediate and final chunks)
 		message, _ := ollamaResp["message"].(map[string]interface{})
 
 		done, _ := ollamaResp["done"].(bool)
-		if done {
-			finishReason := "stop"
+		cut := !done && budget.overrun(ollamaResp)
+		if done || cut {
+			finishReason := doneFinishReason(ollamaResp, "stop")
+			if toolCalls > 0 {
+				finishReason = "tool_calls"
+			}
+			if cut {
+				finishReason = "length"
+				message = nil // the chunk past the budget isn't sent
+			}
 			finalDelta := map[string]interface{}{}
 
 			// Ollama sends tool_calls in the final message when done
 			if message != nil {
 				if rawTC, ok := message["tool_calls"].([]interface{}); ok && len(rawTC) > 0 {
-					finalDelta["tool_calls"] = convertOllamaToolCallsToOpenAI(rawTC)
+					finalDelta["tool_calls"] = streamedToolCalls(rawTC, &toolCalls)
 					finishReason = "tool_calls"
 				}
 			}
@@ -1946,6 +1947,9 @@ func (s *Server) convertOllamaStreamToOpenAI(w http.ResponseWriter, body io.Read
 				if v, ok := ollamaResp["eval_count"].(float64); ok {
 					completionTokens = int(v)
 				}
+				if cut {
+					completionTokens = budget.limit
+				}
 				usageChunk := map[string]interface{}{
 					"id":      responseID,
 					"object":  "chat.completion.chunk",
@@ -1988,7 +1992,7 @@ func (s *Server) convertOllamaStreamToOpenAI(w http.ResponseWriter, body io.Read
 
 		// Handle intermediate tool_calls chunks (some Ollama versions stream them)
 		if rawTC, ok := message["tool_calls"].([]interface{}); ok && len(rawTC) > 0 {
-			delta["tool_calls"] = convertOllamaToolCallsToOpenAI(rawTC)
+			delta["tool_calls"] = streamedToolCalls(rawTC, &toolCalls)
 		}
 		
 		// Only send chunk if there's content
@@ -2026,8 +2030,9 @@ func (s *Server) convertOllamaStreamToOpenAI(w http.ResponseWriter, body io.Read
 		}
 	}
 	
-	if err := scanner.Err(); err != nil {
-		log.Printf("!!! Error scanning stream: %v !!!", err)
+	if f := stream.failed(); f != nil {
+		s.recordStreamError("/v1/chat/completions", f)
+		writeOpenAIStreamFault(w, f)
 	}
 	
 	log.Printf("<<< Converted and sent OpenAI stream response (%d bytes) <<<", totalBytes)
@@ -2038,32 +2043,6 @@ func (s *Server) handleOpenAICompletions(w http.ResponseWriter, r *http.Request)
 	log.Printf("=== OpenAI Completions endpoint: %s %s, Method=%s, RemoteAddr=%s ===", 
 		r.Method, r.URL.Path, r.Method, r.RemoteAddr)
 	
-	if r.Method == "OPTIONS" {
-		log.Printf("OpenAI Completions: Handling OPTIONS preflight")
-		w.WriteHeader(http.StatusNoContent)
-		return
-	}
-	
-	if r.Method == "GET" {
-		log.Printf("OpenAI Completions received GET request (health check)")
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status": "ok",
-		})
-		return
-	}
-	
-	if r.Method != "POST" {
-		log.Printf("!!! OpenAI Completions received unsupported method: %s !!!", r.Method)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": "Method not allowed",
-		})
-		return
-	}
-	
 	log.Printf("*** Handling OpenAI Completions POST request from %s ***", r.RemoteAddr)
 	
 	// Read request body
@@ -2115,34 +2094,41 @@ func (s *Server) handleOpenAICompletions(w http.ResponseWriter, r *http.Request)
 	
 	// Build Ollama request (use /api/generate for text completions)
 	ollamaRequest := map[string]interface{}{
-		"model":  s.config.Model,
+		"model":  s.model(),
 		"prompt": prompt,
 		"stream": stream,
 	}
+	// suffix: fill-in-the-middle, for code completion clients
+	if suffix, ok := openaiRequest["suffix"].(string); ok && suffix != "" {
+		ollamaRequest["suffix"] = suffix
+	}
+	// echo: the prompt comes back in front of the completion
+	echo := ""
+	if toBool(openaiRequest["echo"]) {
+		echo = prompt
+	}
+	includeUsage := false
+	if so, ok := openaiRequest["stream_options"].(map[string]interface{}); ok {
+		includeUsage, _ = so["include_usage"].(bool)
+	}
 	
-	// Copy other parameters if present
-	if maxTokens, ok := openaiRequest["max_tokens"]; ok {
-		ollamaRequest["num_predict"] = maxTokens
+	// Inject default options (repeat_penalty, repeat_last_n) when configured,
+	// then the client's parameters (Ollama reads them from options only).
+	options := map[string]interface{}{}
+	if s.config.RepeatPenalty > 0 {
+		options["repeat_penalty"] = s.config.RepeatPenalty
 	}
-	if temperature, ok := openaiRequest["temperature"]; ok {
-		ollamaRequest["temperature"] = temperature
+	if s.config.RepeatLastN > 0 {
+		options["repeat_last_n"] = s.config.RepeatLastN
 	}
-	if topP, ok := openaiRequest["top_p"]; ok {
-		ollamaRequest["top_p"] = topP
+	if maxTokens, ok := openaiRequest["max_tokens"]; ok {
+		options["num_predict"] = maxTokens
 	}
 	if stop, ok := openaiRequest["stop"]; ok {
-		ollamaRequest["stop"] = stop
+		options["stop"] = stop
 	}
-
-	// Inject default options (repeat_penalty, repeat_last_n) when configured.
-	if s.config.RepeatPenalty > 0 || s.config.RepeatLastN > 0 {
-		options := map[string]interface{}{}
-		if s.config.RepeatPenalty > 0 {
-			options["repeat_penalty"] = s.config.RepeatPenalty
-		}
-		if s.config.RepeatLastN > 0 {
-			options["repeat_last_n"] = s.config.RepeatLastN
-		}
+	mapSamplingParams(openaiRequest, options)
+	if len(options) > 0 {
 		ollamaRequest["options"] = options
 	}
 
@@ -2169,6 +2155,14 @@ func (s *Server) handleOpenAICompletions(w http.ResponseWriter, r *http.Request)
 		}
 	}
 	
+	noteParams(w, r, openaiRequest, openAICompletionParams)
+	requestedModel, _ := openaiRequest["model"].(string)
+	release, ok := s.prepareInference(w, r, ollamaRequest, requestedModel, intParam(openaiRequest["max_tokens"]))
+	if !ok {
+		return
+	}
+	defer release()
+
 	modifiedBody, err := json.Marshal(ollamaRequest)
 	if err != nil {
 		log.Printf("!!! Failed to marshal Ollama completions request: %v !!!", err)
@@ -2177,21 +2171,16 @@ func (s *Server) handleOpenAICompletions(w http.ResponseWriter, r *http.Request)
 	}
 	
 	log.Printf(">>> Converted OpenAI completions to Ollama format: body size=%d bytes, model=%s, stream=%v <<<",
-		len(modifiedBody), s.config.Model, stream)
+		len(modifiedBody), s.model(), stream)
 	
 	// Collect headers
-	headers := make(map[string]string)
-	for key, values := range r.Header {
-		if len(values) > 0 && !strings.HasPrefix(strings.ToLower(key), "host") {
-			headers[key] = values[0]
-		}
-	}
+	headers := s.upstreamHeaders(r)
 	headers["Content-Type"] = "application/json"
 	
-	log.Printf(">>> Proxying OpenAI completions request to Ollama /api/generate (model: %s) <<<", s.config.Model)
+	log.Printf(">>> Proxying OpenAI completions request to Ollama /api/generate (model: %s) <<<", s.model())
 	
 	// Proxy to Ollama
-	resp, err := s.ollamaClient.ProxyRequest(
+	resp, err := s.upstreamFor(r, "chat", ollamaRequest).ProxyRequest(
 		"POST",
 		"/api/generate",
 		bytes.NewReader(modifiedBody),
@@ -2199,21 +2188,32 @@ func (s *Server) handleOpenAICompletions(w http.ResponseWriter, r *http.Request)
 	)
 	if err != nil {
 		log.Printf("!!! Failed to proxy OpenAI completions request to Ollama: %v !!!", err)
-		http.Error(w, "Failed to proxy request", http.StatusInternalServerError)
+		writeUpstreamError(w, openAIErrorFormat, upstreamErrorFromTransport(err))
 		return
 	}
 	defer resp.Body.Close()
+	resp.Body = tapUsage(r, resp.Body)
+	resp.Body = s.filterOutput(r, ollamaRequest, resp.Body)
 	
 	log.Printf("<<< Ollama returned status %d for OpenAI completions request <<<", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		ue := upstreamErrorFromResponse(resp)
+		log.Printf("!!! Ollama error for OpenAI completions request: %s !!!", ue.Message)
+		writeUpstreamError(w, openAIErrorFormat, ue)
+		return
+	}
 	
 	// Set OpenAI-compatible response headers
 	if stream {
 		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Transfer-Encoding", "chunked")
-		w.Header().Set("Connection", "keep-alive")
 		w.Header().Set("Cache-Control", "no-cache")
 	} else {
 		w.Header().Set("Content-Type", "application/json")
+		if err := bufferUpstreamBody(resp); err != nil {
+			writeUpstreamError(w, openAIErrorFormat, upstreamErrorFromTransport(err))
+			return
+		}
+		s.repairJSONOutput(w, r, "/api/generate", ollamaRequest, headers, resp)
 	}
 	
 	w.WriteHeader(resp.StatusCode)
@@ -2228,15 +2228,16 @@ func (s *Server) handleOpenAICompletions(w http.ResponseWriter, r *http.Request)
 	// Convert Ollama response to OpenAI format
 	if stream {
 		// Handle streaming response
-		s.convertOllamaGenerateStreamToOpenAI(w, resp.Body, s.config.Model)
+		s.convertOllamaGenerateStreamToOpenAI(w, resp.Body, s.responseModel(r), echo, includeUsage, streamTokenBudget(r, ollamaRequest, intParam(openaiRequest["max_tokens"])))
 	} else {
 		// Handle non-streaming response
-		s.convertOllamaGenerateToOpenAI(w, resp.Body, s.config.Model)
+		s.convertOllamaGenerateToOpenAI(w, resp.Body, s.responseModel(r), echo)
 	}
 }
 
-// convertOllamaGenerateToOpenAI converts Ollama /api/generate response to OpenAI completions format
-func (s *Server) convertOllamaGenerateToOpenAI(w http.ResponseWriter, body io.Reader, modelName string) {
+// convertOllamaGenerateToOpenAI converts Ollama /api/generate response to OpenAI completions format.
+// echo is the prompt to put in front of the text (the request's echo), or "".
+func (s *Server) convertOllamaGenerateToOpenAI(w http.ResponseWriter, body io.Reader, modelName, echo string) {
 	bodyBytes, err := io.ReadAll(body)
 	if err != nil {
 		log.Printf("!!! Error reading Ollama generate response: %v !!!", err)
@@ -2255,7 +2256,7 @@ func (s *Server) convertOllamaGenerateToOpenAI(w http.ResponseWriter, body io.Re
 	responseText, _ := ollamaResp["response"].(string)
 	
 	// Determine finish_reason
-	finishReason := "stop"
+	finishReason := doneFinishReason(ollamaResp, "stop")
 	if done, ok := ollamaResp["done"].(bool); ok && !done {
 		finishReason = "length" // If not done, assume length limit
 	}
@@ -2268,7 +2269,7 @@ func (s *Server) convertOllamaGenerateToOpenAI(w http.ResponseWriter, body io.Re
 		"model":   modelName,
 		"choices": []map[string]interface{}{
 			{
-				"text":          responseText,
+				"text":          echo + responseText,
 				"index":         0,
 				"logprobs":      nil,
 				"finish_reason": finishReason,
@@ -2308,36 +2309,47 @@ func (s *Server) convertOllamaGenerateToOpenAI(w http.ResponseWriter, body io.Re
 }
 
 // convertOllamaGenerateStreamToOpenAI converts Ollama /api/generate streaming response to OpenAI SSE format
-func (s *Server) convertOllamaGenerateStreamToOpenAI(w http.ResponseWriter, body io.Reader, modelName string) {
+// A model that streams past budget is cut off with finish_reason "length".
+// A non-empty echo (the prompt) is sent as the first chunk, and includeUsage
+// adds a usage chunk before [DONE], as for chat completions.
+func (s *Server) convertOllamaGenerateStreamToOpenAI(w http.ResponseWriter, body io.Reader, modelName, echo string, includeUsage bool, budget *tokenBudget) {
 	flusher, hasFlusher := w.(http.Flusher)
-	scanner := bufio.NewScanner(body)
+	stream := newUpstreamStream(body)
 	responseID := fmt.Sprintf("cmpl-%d", time.Now().Unix())
 	created := time.Now().Unix()
 	var totalBytes int64
 	var fullText strings.Builder
+
+	if echo != "" {
+		echoJSON, _ := json.Marshal(map[string]interface{}{
+			"id":      responseID,
+			"object":  "text_completion",
+			"created": created,
+			"model":   modelName,
+			"choices": []map[string]interface{}{{"index": 0, "text": echo, "logprobs": nil}},
+		})
+		n, _ := fmt.Fprintf(w, "data: %s\n\n", echoJSON)
+		totalBytes += int64(n)
+	}
 	
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			continue
-		}
-		
-		var ollamaResp map[string]interface{}
-		if err := json.Unmarshal(line, &ollamaResp); err != nil {
-			log.Printf("!!! Error parsing Ollama generate stream line: %v, line: %s !!!", err, string(line))
-			continue
-		}
+	for stream.next() {
+		ollamaResp := stream.chunk
 		
-		// Check if done
+		// Check if done, or past the token budget
 		done, _ := ollamaResp["done"].(bool)
+		cut := !done && budget.overrun(ollamaResp)
 		
 		// Extract response text
 		responseText, _ := ollamaResp["response"].(string)
-		if responseText != "" {
+		if responseText != "" && !cut {
 			fullText.WriteString(responseText)
 		}
 		
-		if done {
+		if done || cut {
+			finishReason := doneFinishReason(ollamaResp, "stop")
+			if cut {
+				finishReason = "length"
+			}
 			// Send final chunk with finish_reason
 			finalChunk := map[string]interface{}{
 				"id":      responseID,
@@ -2349,12 +2361,37 @@ func (s *Server) convertOllamaGenerateStreamToOpenAI(w http.ResponseWriter, body
 						"index":         0,
 						"text":          "",
 						"logprobs":      nil,
-						"finish_reason": "stop",
+						"finish_reason": finishReason,
 					},
 				},
 			}
 			finalJSON, _ := json.Marshal(finalChunk)
 			w.Write([]byte(fmt.Sprintf("data: %s\n\n", finalJSON)))
+			if includeUsage {
+				promptTokens, completionTokens := 0, 0
+				if v, ok := ollamaResp["prompt_eval_count"].(float64); ok {
+					promptTokens = int(v)
+				}
+				if v, ok := ollamaResp["eval_count"].(float64); ok {
+					completionTokens = int(v)
+				}
+				if cut {
+					completionTokens = budget.limit
+				}
+				usageJSON, _ := json.Marshal(map[string]interface{}{
+					"id":      responseID,
+					"object":  "text_completion",
+					"created": created,
+					"model":   modelName,
+					"choices": []map[string]interface{}{},
+					"usage": map[string]interface{}{
+						"prompt_tokens":     promptTokens,
+						"completion_tokens": completionTokens,
+						"total_tokens":      promptTokens + completionTokens,
+					},
+				})
+				w.Write([]byte(fmt.Sprintf("data: %s\n\n", usageJSON)))
+			}
 			w.Write([]byte("data: [DONE]\n\n"))
 			if hasFlusher {
 				flusher.Flush()
@@ -2398,8 +2435,9 @@ func (s *Server) convertOllamaGenerateStreamToOpenAI(w http.ResponseWriter, body
 		}
 	}
 	
-	if err := scanner.Err(); err != nil {
-		log.Printf("!!! Error scanning stream: %v !!!", err)
+	if f := stream.failed(); f != nil {
+		s.recordStreamError("/v1/completions", f)
+		writeOpenAIStreamFault(w, f)
 	}
 	
 	log.Printf("<<< Converted and sent OpenAI completions stream response (%d bytes) <<<", totalBytes)
@@ -2415,8 +2453,8 @@ func (s *Server) handleSingleEmbedding(w http.ResponseWriter, r *http.Request, b
 	
 	// Replace model parameter
 	originalModel := requestData["model"]
-	requestData["model"] = s.config.Model
-	log.Printf(">>> [handleSingleEmbedding] Model replacement: %v -> %s <<<", originalModel, s.config.Model)
+	requestData["model"] = s.model()
+	log.Printf(">>> [handleSingleEmbedding] Model replacement: %v -> %s <<<", originalModel, s.model())
 	
 	// Normalize input for Ollama /api/embed (new endpoint).
 	// /api/embed accepts {"model": "...", "input": "..." or ["..."]}
@@ -2452,30 +2490,21 @@ func (s *Server) handleSingleEmbedding(w http.ResponseWriter, r *http.Request, b
 	log.Printf(">>> Request to Ollama: %s <<<", bodyPreview)
 	
 	// Collect headers
-	headers := make(map[string]string)
-	for key, values := range r.Header {
-		if len(values) > 0 && !strings.HasPrefix(strings.ToLower(key), "host") {
-			headers[key] = values[0]
-		}
-	}
+	headers := s.upstreamHeaders(r)
 	headers["Content-Type"] = "application/json"
 	
-	log.Printf(">>> Proxying embeddings request to Ollama (model: %s) <<<", s.config.Model)
+	log.Printf(">>> Proxying embeddings request to Ollama (model: %s) <<<", s.model())
 	
 	// Proxy to Ollama
 	log.Printf(">>> [handleSingleEmbedding] Sending request to Ollama /api/embed, body size: %d bytes <<<", len(modifiedBody))
-	resp, err := s.ollamaClient.ProxyRequest(
-		"POST",
-		"/api/embed",
-		bytes.NewReader(modifiedBody),
-		headers,
-	)
+	resp, err := s.proxyEmbed(r, modifiedBody, headers)
 	if err != nil {
 		log.Printf("!!! [handleSingleEmbedding] Failed to proxy embeddings request: %v !!!", err)
-		http.Error(w, "Failed to proxy request", http.StatusInternalServerError)
+		writeUpstreamError(w, errorFormatForPath(r.URL.Path), upstreamErrorFromTransport(err))
 		return
 	}
 	defer resp.Body.Close()
+	resp.Body = tapUsage(r, resp.Body)
 	
 	log.Printf(">>> [handleSingleEmbedding] Ollama response received, status: %d <<<", resp.StatusCode)
 	
@@ -2511,12 +2540,10 @@ func (s *Server) handleSingleEmbedding(w http.ResponseWriter, r *http.Request, b
 	}
 	
 	if resp.StatusCode != http.StatusOK {
-		// Read error response body for debugging
-		errorBody, _ := io.ReadAll(resp.Body)
+		ue := upstreamErrorFromResponse(resp)
 		log.Printf("!!! Ollama returned status %d for embeddings !!!", resp.StatusCode)
-		log.Printf("!!! Ollama error response: %s !!!", string(errorBody))
-		w.WriteHeader(resp.StatusCode)
-		w.Write(errorBody)
+		log.Printf("!!! Ollama error response: %s !!!", ue.Message)
+		writeUpstreamError(w, errorFormatForPath(r.URL.Path), ue)
 		return
 	}
 	
@@ -2528,218 +2555,51 @@ func (s *Server) handleSingleEmbedding(w http.ResponseWriter, r *http.Request, b
 		}
 	}
 	
-	// Read response body first to log it
-	bodyBytes, err := io.ReadAll(resp.Body)
+	// Decode the vectors as float32 and stream them back out (no interface{} round trip)
+	emb, err := decodeEmbedResponse(resp.Body)
 	if err != nil {
-		log.Printf("!!! [handleSingleEmbedding] Error reading Ollama embeddings response: %v !!!", err)
-		http.Error(w, "Failed to read response", http.StatusInternalServerError)
-		return
-	}
-	
-	log.Printf(">>> [handleSingleEmbedding] Response body size: %d bytes <<<", len(bodyBytes))
-	
-	// Log response body for debugging (first 500 chars)
-	bodyPreview = string(bodyBytes)
-	if len(bodyPreview) > 500 {
-		bodyPreview = bodyPreview[:500] + "..."
-	}
-	log.Printf(">>> [handleSingleEmbedding] Ollama embeddings response body preview: %s <<<", bodyPreview)
-	
-	// Parse Ollama response
-	var ollamaResp map[string]interface{}
-	if err := json.Unmarshal(bodyBytes, &ollamaResp); err != nil {
-		log.Printf("!!! [handleSingleEmbedding] Error parsing Ollama embeddings response: %v, body: %s !!!", err, string(bodyBytes))
+		log.Printf("!!! [handleSingleEmbedding] Error parsing Ollama embeddings response: %v !!!", err)
 		http.Error(w, "Failed to parse response", http.StatusInternalServerError)
 		return
 	}
-	
-	log.Printf(">>> [handleSingleEmbedding] Parsed Ollama response, keys: %v <<<", getMapKeys(ollamaResp))
-	
-	// Extract embedding vector
-	// Ollama may return either "embedding" (single) or "embeddings" (array)
-	var embedding []interface{}
-	var found bool
-	
-	log.Printf(">>> [handleSingleEmbedding] Attempting to extract embedding vector... <<<")
-	
-	// First try "embeddings" (plural) - array format
-	if embeddingsArray, ok := ollamaResp["embeddings"].([]interface{}); ok {
-		log.Printf(">>> [handleSingleEmbedding] Found 'embeddings' field, type: []interface{}, length: %d <<<", len(embeddingsArray))
-		if len(embeddingsArray) > 0 {
-			// Take the first embedding from the array
-			log.Printf(">>> [handleSingleEmbedding] embeddings[0] type: %T <<<", embeddingsArray[0])
-			if firstEmbedding, ok := embeddingsArray[0].([]interface{}); ok {
-				embedding = firstEmbedding
-				found = true
-				log.Printf(">>> [handleSingleEmbedding] ✓ Extracted embedding from 'embeddings' array[0] ([]interface{}, length=%d) <<<", len(embedding))
-			} else if firstEmbeddingFloat, ok := embeddingsArray[0].([]float64); ok {
-				// Convert []float64 to []interface{}
-				embedding = make([]interface{}, len(firstEmbeddingFloat))
-				for i, v := range firstEmbeddingFloat {
-					embedding[i] = v
-				}
-				found = true
-				log.Printf(">>> [handleSingleEmbedding] ✓ Extracted embedding from 'embeddings' array[0] ([]float64, length=%d) <<<", len(embedding))
-			} else {
-				log.Printf("!!! [handleSingleEmbedding] Invalid format in 'embeddings' array first element: %T, value preview: %v !!!", 
-					embeddingsArray[0], fmt.Sprintf("%v", embeddingsArray[0])[:100])
-			}
-		} else {
-			log.Printf(">>> [handleSingleEmbedding] 'embeddings' array is empty (length=0) <<<")
-		}
-	} else {
-		log.Printf(">>> [handleSingleEmbedding] No 'embeddings' field found or wrong type <<<")
-	}
-	
-	// If not found in "embeddings", try "embedding" (singular)
-	if !found {
-		log.Printf(">>> [handleSingleEmbedding] Trying 'embedding' field (singular)... <<<")
-		embeddingRaw := ollamaResp["embedding"]
-		if embeddingRaw == nil {
-			log.Printf(">>> [handleSingleEmbedding] 'embedding' field is nil <<<")
-		} else {
-			log.Printf(">>> [handleSingleEmbedding] 'embedding' field exists, type: %T <<<", embeddingRaw)
-			if embeddingSingle, ok := embeddingRaw.([]interface{}); ok {
-				embedding = embeddingSingle
-				found = true
-				log.Printf(">>> [handleSingleEmbedding] ✓ Extracted embedding from 'embedding' field ([]interface{}, length=%d) <<<", len(embedding))
-			} else if embeddingFloat, ok := embeddingRaw.([]float64); ok {
-				// Convert []float64 to []interface{}
-				log.Printf(">>> [handleSingleEmbedding] Converting []float64 to []interface{}, length: %d <<<", len(embeddingFloat))
-				embedding = make([]interface{}, len(embeddingFloat))
-				for i, v := range embeddingFloat {
-					embedding[i] = v
-				}
-				found = true
-				log.Printf(">>> [handleSingleEmbedding] ✓ Extracted embedding from 'embedding' field ([]float64, length=%d) <<<", len(embedding))
-			} else {
-				log.Printf("!!! [handleSingleEmbedding] 'embedding' field has unexpected type: %T, value preview: %v !!!", 
-					embeddingRaw, fmt.Sprintf("%v", embeddingRaw)[:200])
-			}
-		}
-	}
+	vectors := emb.vectors()
 	
 	// Check endpoint path to determine response format
 	// /api/embed is used by OpenWebUI for ollama type, expects Ollama format: {"embeddings": [[...]]}
 	// /api/embeddings or other endpoints expect OpenAI format: {"data": [{"embedding": [...]}]}
 	isOllamaFormat := r.URL.Path == "/api/embed"
 	
-	log.Printf(">>> [handleSingleEmbedding] Response format decision: isOllamaFormat=%v (path=%s), found=%v, embedding length=%d <<<", 
-		isOllamaFormat, r.URL.Path, found, len(embedding))
-	
-	// If Ollama format and embeddings is empty array, return an error
-	// ChromaDB cannot handle empty embedding vectors, so we should return an error instead
-	if isOllamaFormat && !found {
-		log.Printf(">>> [handleSingleEmbedding] Handling empty embeddings case for Ollama format... <<<")
-		// Check if embeddings exists but is empty
-		if embeddingsArray, ok := ollamaResp["embeddings"].([]interface{}); ok && len(embeddingsArray) == 0 {
-			// Ollama returned empty embeddings array - this indicates the model failed to generate embeddings
-			// We should return an error instead of empty embeddings, as ChromaDB cannot handle empty vectors
+	if len(vectors) == 0 || len(vectors[0]) == 0 {
+		if isOllamaFormat && emb.Embeddings != nil {
+			// Ollama returned an empty embeddings array - the model failed to generate embeddings.
+			// ChromaDB cannot handle empty embedding vectors, so return an error instead.
 			log.Printf("!!! [handleSingleEmbedding] Ollama returned empty embeddings array - model failed to generate embeddings !!!")
-			log.Printf("!!! [handleSingleEmbedding] This may indicate: 1) Model issue, 2) Request format issue, 3) Model not properly loaded !!!")
-			
-			// Return error response in Ollama format
-			errorResponse := map[string]interface{}{
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
 				"error": "Failed to generate embeddings: Ollama returned empty embeddings array. Please check if the model is properly loaded and the request format is correct.",
-			}
-			responseJSON, err := json.Marshal(errorResponse)
-			if err != nil {
-				log.Printf("!!! [handleSingleEmbedding] Error marshaling error response: %v !!!", err)
-				http.Error(w, "Failed to generate embeddings", http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(responseJSON)
+			})
 			return
 		}
-	}
-	
-	if !found || len(embedding) == 0 {
-		log.Printf("!!! [handleSingleEmbedding] Invalid embedding format in Ollama response: embedding=%v, embeddings=%v, keys: %v !!!", 
-			ollamaResp["embedding"], ollamaResp["embeddings"], getMapKeys(ollamaResp))
+		log.Printf("!!! [handleSingleEmbedding] Invalid embedding format or empty embedding in Ollama response !!!")
 		http.Error(w, "Invalid embedding format or empty embedding", http.StatusInternalServerError)
 		return
 	}
+	vectors = vectors[:1]
 	
-	log.Printf(">>> [handleSingleEmbedding] Successfully extracted embedding, length=%d, preparing response... <<<", len(embedding))
-	
-	var responseJSON []byte
+	log.Printf(">>> [handleSingleEmbedding] Writing response, status code: %d, embedding length=%d <<<", resp.StatusCode, len(vectors[0]))
+	w.WriteHeader(resp.StatusCode)
 	
+	formatType := "Ollama"
 	if isOllamaFormat {
-		log.Printf(">>> [handleSingleEmbedding] Formatting response as Ollama format... <<<")
-		// Return Ollama format: {"embeddings": [[...]]}
-		ollamaFormatResp := map[string]interface{}{
-			"embeddings": [][]interface{}{embedding},
-		}
-		
-		// Add other Ollama fields if available
-		if promptEvalCount, ok := ollamaResp["prompt_eval_count"].(float64); ok {
-			ollamaFormatResp["prompt_eval_count"] = int(promptEvalCount)
-			log.Printf(">>> [handleSingleEmbedding] Added prompt_eval_count: %d <<<", int(promptEvalCount))
-		}
-		
-		responseJSON, err = json.Marshal(ollamaFormatResp)
-		if err != nil {
-			log.Printf("!!! [handleSingleEmbedding] Error marshaling Ollama embeddings response: %v !!!", err)
-			http.Error(w, "Failed to format response", http.StatusInternalServerError)
-			return
-		}
-		log.Printf(">>> [handleSingleEmbedding] ✓ Converted to Ollama format: embeddings array with 1 item, embedding length=%d, response size=%d bytes <<<", 
-			len(embedding), len(responseJSON))
+		err = writeOllamaEmbeddings(w, vectors, emb.PromptEvalCount)
 	} else {
-		log.Printf(">>> [handleSingleEmbedding] Formatting response as OpenAI format... <<<")
-		// Return OpenAI format: {"data": [{"embedding": [...]}]}
-		openAIResp := map[string]interface{}{
-			"object": "list",
-			"data": []map[string]interface{}{
-				{
-					"object":    "embedding",
-					"embedding": embedding,
-					"index":     0,
-				},
-			},
-			"model": s.config.Model,
-			"usage": map[string]interface{}{
-				"prompt_tokens": 0,
-				"total_tokens":  0,
-			},
-		}
-		
-		// Try to extract usage if available
-		if promptEvalCount, ok := ollamaResp["prompt_eval_count"].(float64); ok {
-			openAIResp["usage"].(map[string]interface{})["prompt_tokens"] = int(promptEvalCount)
-			openAIResp["usage"].(map[string]interface{})["total_tokens"] = int(promptEvalCount)
-			log.Printf(">>> [handleSingleEmbedding] Added usage: prompt_tokens=%d, total_tokens=%d <<<", 
-				int(promptEvalCount), int(promptEvalCount))
-		}
-		
-		responseJSON, err = json.Marshal(openAIResp)
-		if err != nil {
-			log.Printf("!!! [handleSingleEmbedding] Error marshaling OpenAI embeddings response: %v !!!", err)
-			http.Error(w, "Failed to format response", http.StatusInternalServerError)
-			return
-		}
-		log.Printf(">>> [handleSingleEmbedding] ✓ Converted to OpenAI format: data array with %d items, embedding length=%d, response size=%d bytes <<<", 
-			len(openAIResp["data"].([]map[string]interface{})), len(embedding), len(responseJSON))
+		formatType = "OpenAI"
+		err = writeOpenAIEmbeddings(w, requestData, s.model(), vectors, emb.PromptEvalCount)
 	}
-	
-	// Set status code
-	log.Printf(">>> [handleSingleEmbedding] Writing response, status code: %d <<<", resp.StatusCode)
-	w.WriteHeader(resp.StatusCode)
-	
-	// Write response
-	bytesCopied, err := w.Write(responseJSON)
 	if err != nil {
 		log.Printf("!!! [handleSingleEmbedding] Error writing embeddings response: %v !!!", err)
 		return
 	}
-	
-	formatType := "Ollama"
-	if !isOllamaFormat {
-		formatType = "OpenAI"
-	}
-	log.Printf(">>> [handleSingleEmbedding] ✓ Successfully sent %s embeddings format response (%d bytes written) <<<", formatType, bytesCopied)
+	log.Printf(">>> [handleSingleEmbedding] ✓ Successfully sent %s embeddings format response <<<", formatType)
 }
 
 // getMapKeys returns the keys of a map as a slice of strings
@@ -2751,212 +2611,163 @@ func getMapKeys(m map[string]interface{}) []string {
 	return keys
 }
 
-// handleBatchEmbeddings handles batch embedding requests
+// handleBatchEmbeddings handles batch embedding requests. Inputs are split
+// into chunks of EMBED_BATCH_SIZE, each sent as one /api/embed call, with at
+// most EMBED_CONCURRENCY chunks in flight (chunks are handed out only as
+// workers free up, so huge requests don't flood Ollama). Results are
+// reassembled in input order; any failed chunk fails the whole request so
+// vectors never shift against their inputs.
 func (s *Server) handleBatchEmbeddings(w http.ResponseWriter, r *http.Request, inputs []interface{}, requestData map[string]interface{}) {
-	log.Printf(">>> [handleBatchEmbeddings] Starting batch embeddings processing, total inputs: %d <<<", len(inputs))
-	log.Printf(">>> [handleBatchEmbeddings] Endpoint path: %s <<<", r.URL.Path)
+	chunkSize := s.config.EmbedBatchSize
+	if chunkSize <= 0 {
+		chunkSize = 1
+	}
+	workers := s.config.EmbedConcurrency
+	if workers <= 0 {
+		workers = 1
+	}
+	chunks := (len(inputs) + chunkSize - 1) / chunkSize
+	if workers > chunks {
+		workers = chunks
+	}
+	log.Printf(">>> [handleBatchEmbeddings] %d inputs -> %d chunks of up to %d, %d in flight <<<",
+		len(inputs), chunks, chunkSize, workers)
 	
-	// Process each input separately
-	embeddings := [][]interface{}{}
-	var err error
+	// Collect headers
+	headers := s.upstreamHeaders(r)
+	headers["Content-Type"] = "application/json"
 	
-	for idx, input := range inputs {
-		log.Printf(">>> [handleBatchEmbeddings] Processing input %d/%d... <<<", idx+1, len(inputs))
-		// Create single request for this input
-		singleRequest := make(map[string]interface{})
-		for k, v := range requestData {
-			singleRequest[k] = v
-		}
-		singleRequest["model"] = s.config.Model
-		// Use "input" for Ollama /api/embed (new endpoint)
-		singleRequest["input"] = input
-		delete(singleRequest, "prompt")
-		
-		modifiedBody, err := json.Marshal(singleRequest)
-		if err != nil {
-			log.Printf("!!! Failed to marshal batch embedding request %d: %v !!!", idx, err)
-			continue
-		}
-		
-		// Collect headers
-		headers := make(map[string]string)
-		for key, values := range r.Header {
-			if len(values) > 0 && !strings.HasPrefix(strings.ToLower(key), "host") {
-				headers[key] = values[0]
-			}
-		}
-		headers["Content-Type"] = "application/json"
-		
-		// Proxy to Ollama
-		log.Printf(">>> [handleBatchEmbeddings] Sending request %d/%d to Ollama /api/embed, body size: %d bytes <<<", 
-			idx+1, len(inputs), len(modifiedBody))
-		resp, err := s.ollamaClient.ProxyRequest(
-			"POST",
-			"/api/embed",
-			bytes.NewReader(modifiedBody),
-			headers,
-		)
-		if err != nil {
-			log.Printf("!!! [handleBatchEmbeddings] Failed to proxy batch embedding request %d/%d: %v !!!", idx+1, len(inputs), err)
-			continue
-		}
-		
-		log.Printf(">>> [handleBatchEmbeddings] Request %d/%d response received, status: %d <<<", idx+1, len(inputs), resp.StatusCode)
-		
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("!!! [handleBatchEmbeddings] Ollama returned status %d for batch embedding %d/%d !!!", resp.StatusCode, idx+1, len(inputs))
-			resp.Body.Close()
-			continue
-		}
-		
-		// Read response
-		bodyBytes, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			log.Printf("!!! [handleBatchEmbeddings] Error reading batch embedding response %d/%d: %v !!!", idx+1, len(inputs), err)
-			continue
-		}
-		
-		log.Printf(">>> [handleBatchEmbeddings] Request %d/%d response body size: %d bytes <<<", idx+1, len(inputs), len(bodyBytes))
-		
-		var ollamaResp map[string]interface{}
-		if err := json.Unmarshal(bodyBytes, &ollamaResp); err != nil {
-			log.Printf("!!! [handleBatchEmbeddings] Error parsing batch embedding response %d/%d: %v !!!", idx+1, len(inputs), err)
-			continue
-		}
-		
-		log.Printf(">>> [handleBatchEmbeddings] Request %d/%d parsed, response keys: %v <<<", idx+1, len(inputs), getMapKeys(ollamaResp))
-		
-		// Extract embedding vector
-		// Ollama may return either "embedding" (single) or "embeddings" (array)
-		var embedding []interface{}
-		var found bool
-		
-		// First try "embeddings" (plural) - array format
-		if embeddingsArray, ok := ollamaResp["embeddings"].([]interface{}); ok && len(embeddingsArray) > 0 {
-			// Take the first embedding from the array
-			if firstEmbedding, ok := embeddingsArray[0].([]interface{}); ok {
-				embedding = firstEmbedding
-				found = true
-			} else if firstEmbeddingFloat, ok := embeddingsArray[0].([]float64); ok {
-				// Convert []float64 to []interface{}
-				embedding = make([]interface{}, len(firstEmbeddingFloat))
-				for i, v := range firstEmbeddingFloat {
-					embedding[i] = v
+	job := s.embedBatches.start(len(inputs), chunks)
+	defer s.embedBatches.finish(job)
+	
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	
+	embeddings := make([][]float32, len(inputs))
+	promptTokens := make([]int, chunks)
+	var (
+		errOnce  sync.Once
+		chunkErr *upstreamError
+	)
+	fail := func(ue *upstreamError) {
+		errOnce.Do(func() {
+			chunkErr = ue
+			cancel()
+		})
+	}
+	
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range jobs {
+				start := c * chunkSize
+				end := start + chunkSize
+				if end > len(inputs) {
+					end = len(inputs)
 				}
-				found = true
-			}
-		}
-		
-		// If not found in "embeddings", try "embedding" (singular)
-		if !found {
-			if embeddingSingle, ok := ollamaResp["embedding"].([]interface{}); ok {
-				embedding = embeddingSingle
-				found = true
-			} else if embeddingFloat, ok := ollamaResp["embedding"].([]float64); ok {
-				// Convert []float64 to []interface{}
-				embedding = make([]interface{}, len(embeddingFloat))
-				for i, v := range embeddingFloat {
-					embedding[i] = v
+				n, ue := s.embedChunk(r, requestData, inputs[start:end], embeddings[start:end], headers)
+				if ue != nil {
+					log.Printf("!!! [handleBatchEmbeddings] Chunk %d/%d (inputs %d-%d) failed: %s !!!", c+1, chunks, start, end-1, ue.Message)
+					fail(ue)
+					continue
+				}
+				promptTokens[c] = n
+				if done, total := job.chunkDone(end - start); done%max(1, total/10) == 0 || done == total {
+					log.Printf(">>> [handleBatchEmbeddings] Progress: %d/%d chunks (%d/%d inputs) <<<", done, total, job.inputsDone(), len(inputs))
 				}
-				found = true
 			}
+		}()
+	}
+feed:
+	for c := 0; c < chunks; c++ {
+		select {
+		case jobs <- c:
+		case <-ctx.Done():
+			break feed
 		}
-		
-		if !found || len(embedding) == 0 {
-			log.Printf("!!! [handleBatchEmbeddings] Invalid embedding format in batch response %d/%d: embedding=%v, embeddings=%v !!!", 
-				idx+1, len(inputs), ollamaResp["embedding"], ollamaResp["embeddings"])
-			continue
-		}
-		
-		log.Printf(">>> [handleBatchEmbeddings] ✓ Successfully extracted embedding %d/%d, length=%d <<<", 
-			idx+1, len(inputs), len(embedding))
-		embeddings = append(embeddings, embedding)
 	}
+	close(jobs)
+	wg.Wait()
 	
-	log.Printf(">>> [handleBatchEmbeddings] Batch processing complete: %d/%d embeddings extracted <<<", len(embeddings), len(inputs))
-	
-	if len(embeddings) == 0 {
-		log.Printf("!!! [handleBatchEmbeddings] No embeddings generated from batch request (0/%d) !!!", len(inputs))
-		http.Error(w, "Failed to generate embeddings", http.StatusInternalServerError)
+	if chunkErr != nil {
+		writeUpstreamError(w, errorFormatForPath(r.URL.Path), chunkErr)
 		return
 	}
+	if err := r.Context().Err(); err != nil {
+		log.Printf("!!! [handleBatchEmbeddings] Client went away after %d/%d inputs: %v !!!", job.inputsDone(), len(inputs), err)
+		return
+	}
+	total := 0
+	for _, n := range promptTokens {
+		total += n
+	}
 	
 	// Check endpoint path to determine response format
 	// /api/embed is used by OpenWebUI for ollama type, expects Ollama format: {"embeddings": [[...], [...]]}
 	// /api/embeddings or other endpoints expect OpenAI format: {"data": [{"embedding": [...]}, ...]}
 	isOllamaFormat := r.URL.Path == "/api/embed"
 	
-	log.Printf(">>> [handleBatchEmbeddings] Formatting response: isOllamaFormat=%v, embeddings count=%d <<<", 
-		isOllamaFormat, len(embeddings))
-	
-	var responseJSON []byte
-	
+	w.Header().Set("Content-Type", "application/json")
+	var err error
+	formatType := "Ollama"
 	if isOllamaFormat {
-		log.Printf(">>> [handleBatchEmbeddings] Formatting as Ollama format... <<<")
-		// Return Ollama format: {"embeddings": [[...], [...]]}
-		ollamaFormatResp := map[string]interface{}{
-			"embeddings": embeddings,
-		}
-		responseJSON, err = json.Marshal(ollamaFormatResp)
-		if err != nil {
-			log.Printf("!!! [handleBatchEmbeddings] Error marshaling batch Ollama embeddings response: %v !!!", err)
-			http.Error(w, "Failed to format response", http.StatusInternalServerError)
-			return
-		}
-		log.Printf(">>> [handleBatchEmbeddings] ✓ Converted to Ollama format: embeddings array with %d items, response size=%d bytes <<<", 
-			len(embeddings), len(responseJSON))
+		err = writeOllamaEmbeddings(w, embeddings, total)
 	} else {
-		log.Printf(">>> [handleBatchEmbeddings] Formatting as OpenAI format... <<<")
-		// Return OpenAI format: {"data": [{"embedding": [...]}, ...]}
-		openAIData := []map[string]interface{}{}
-		for idx, embedding := range embeddings {
-			openAIData = append(openAIData, map[string]interface{}{
-				"object":    "embedding",
-				"embedding": embedding,
-				"index":     idx,
-			})
-		}
-		openAIResp := map[string]interface{}{
-			"object": "list",
-			"data":   openAIData,
-			"model":  s.config.Model,
-			"usage": map[string]interface{}{
-				"prompt_tokens": 0,
-				"total_tokens":  0,
-			},
-		}
-		responseJSON, err = json.Marshal(openAIResp)
-		if err != nil {
-			log.Printf("!!! [handleBatchEmbeddings] Error marshaling batch OpenAI embeddings response: %v !!!", err)
-			http.Error(w, "Failed to format response", http.StatusInternalServerError)
-			return
-		}
-		log.Printf(">>> [handleBatchEmbeddings] ✓ Converted to OpenAI format: data array with %d items, response size=%d bytes <<<", 
-			len(openAIData), len(responseJSON))
+		formatType = "OpenAI"
+		err = writeOpenAIEmbeddings(w, requestData, s.model(), embeddings, total)
 	}
-	
-	log.Printf(">>> [handleBatchEmbeddings] Writing response... <<<")
-	w.Header().Set("Content-Type", "application/json")
-	bytesWritten, err := w.Write(responseJSON)
 	if err != nil {
 		log.Printf("!!! [handleBatchEmbeddings] Error writing response: %v !!!", err)
 		return
 	}
+	log.Printf(">>> [handleBatchEmbeddings] ✓ Successfully sent %s batch embeddings response (%d items) <<<", 
+		formatType, len(embeddings))
+}
+
+// embedChunk embeds one chunk of a batch request with a single /api/embed
+// call, writing the vectors into out. Returns the chunk's prompt token count.
+func (s *Server) embedChunk(r *http.Request, requestData map[string]interface{}, inputs []interface{}, out [][]float32, headers map[string]string) (int, *upstreamError) {
+	chunkRequest := make(map[string]interface{}, len(requestData))
+	for k, v := range requestData {
+		chunkRequest[k] = v
+	}
+	chunkRequest["model"] = s.model()
+	chunkRequest["input"] = inputs
+	delete(chunkRequest, "prompt")
+	body, err := json.Marshal(chunkRequest)
+	if err != nil {
+		return 0, &upstreamError{Status: http.StatusBadRequest, Code: "invalid_request", Message: "Failed to encode embedding request: " + err.Error()}
+	}
 	
-	formatType := "Ollama"
-	if !isOllamaFormat {
-		formatType = "OpenAI"
+	resp, err := s.proxyEmbed(r, body, headers)
+	if err != nil {
+		return 0, upstreamErrorFromTransport(err)
 	}
-	log.Printf(">>> [handleBatchEmbeddings] ✓ Successfully sent %s batch embeddings response (%d items, %d bytes written) <<<", 
-		formatType, len(embeddings), bytesWritten)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, upstreamErrorFromResponse(resp)
+	}
+	emb, err := decodeEmbedResponse(tapUsage(r, resp.Body))
+	if err != nil {
+		return 0, &upstreamError{Status: http.StatusBadGateway, Code: "upstream_error", Message: "Failed to parse Ollama embeddings response: " + err.Error()}
+	}
+	vectors := emb.vectors()
+	if len(vectors) != len(inputs) {
+		return 0, &upstreamError{Status: http.StatusBadGateway, Code: "upstream_error",
+			Message: fmt.Sprintf("Ollama returned %d embeddings for %d inputs", len(vectors), len(inputs))}
+	}
+	copy(out, vectors)
+	return emb.PromptEvalCount, nil
 }
 
 // handleOllamaEmbedding handles Ollama format embedding requests (with "prompt" field)
 // and returns Ollama format response directly
 func (s *Server) handleOllamaEmbedding(w http.ResponseWriter, r *http.Request, body []byte, requestData map[string]interface{}) {
 	// Replace model parameter
-	requestData["model"] = s.config.Model
+	requestData["model"] = s.model()
 	
 	// Convert "prompt" to "input" for /api/embed (new endpoint)
 	if prompt, ok := requestData["prompt"]; ok {
@@ -2979,29 +2790,20 @@ func (s *Server) handleOllamaEmbedding(w http.ResponseWriter, r *http.Request, b
 	log.Printf(">>> Request to Ollama: %s <<<", bodyPreview)
 	
 	// Collect headers
-	headers := make(map[string]string)
-	for key, values := range r.Header {
-		if len(values) > 0 && !strings.HasPrefix(strings.ToLower(key), "host") {
-			headers[key] = values[0]
-		}
-	}
+	headers := s.upstreamHeaders(r)
 	headers["Content-Type"] = "application/json"
 	
-	log.Printf(">>> Proxying Ollama format embeddings request to Ollama /api/embed (model: %s) <<<", s.config.Model)
+	log.Printf(">>> Proxying Ollama format embeddings request to Ollama /api/embed (model: %s) <<<", s.model())
 	
 	// Proxy to Ollama (use new /api/embed endpoint)
-	resp, err := s.ollamaClient.ProxyRequest(
-		"POST",
-		"/api/embed",
-		bytes.NewReader(modifiedBody),
-		headers,
-	)
+	resp, err := s.proxyEmbed(r, modifiedBody, headers)
 	if err != nil {
 		log.Printf("!!! Failed to proxy Ollama embeddings request: %v !!!", err)
-		http.Error(w, "Failed to proxy request", http.StatusInternalServerError)
+		writeUpstreamError(w, errorFormatForPath(r.URL.Path), upstreamErrorFromTransport(err))
 		return
 	}
 	defer resp.Body.Close()
+	resp.Body = tapUsage(r, resp.Body)
 	
 	// Embeddings API should NOT be streaming - log headers for debugging
 	log.Printf(">>> Ollama embeddings response headers: Content-Type=%s, Transfer-Encoding=%s, Content-Length=%s <<<",
@@ -3035,12 +2837,10 @@ func (s *Server) handleOllamaEmbedding(w http.ResponseWriter, r *http.Request, b
 	}
 	
 	if resp.StatusCode != http.StatusOK {
-		// Read error response body for debugging
-		errorBody, _ := io.ReadAll(resp.Body)
+		ue := upstreamErrorFromResponse(resp)
 		log.Printf("!!! Ollama returned status %d for embeddings !!!", resp.StatusCode)
-		log.Printf("!!! Ollama error response: %s !!!", string(errorBody))
-		w.WriteHeader(resp.StatusCode)
-		w.Write(errorBody)
+		log.Printf("!!! Ollama error response: %s !!!", ue.Message)
+		writeUpstreamError(w, errorFormatForPath(r.URL.Path), ue)
 		return
 	}
 	
@@ -3052,121 +2852,27 @@ func (s *Server) handleOllamaEmbedding(w http.ResponseWriter, r *http.Request, b
 		}
 	}
 	
-	// Read response body first to log it
-	bodyBytes, err := io.ReadAll(resp.Body)
+	emb, err := decodeEmbedResponse(resp.Body)
 	if err != nil {
-		log.Printf("!!! Error reading Ollama embeddings response: %v !!!", err)
-		http.Error(w, "Failed to read response", http.StatusInternalServerError)
-		return
-	}
-	
-	// Log response body for debugging (first 500 chars)
-	bodyPreview = string(bodyBytes)
-	if len(bodyPreview) > 500 {
-		bodyPreview = bodyPreview[:500] + "..."
-	}
-	log.Printf(">>> Ollama embeddings response body preview (Ollama format): %s <<<", bodyPreview)
-	
-	// Parse Ollama response
-	var ollamaResp map[string]interface{}
-	if err := json.Unmarshal(bodyBytes, &ollamaResp); err != nil {
-		log.Printf("!!! Error parsing Ollama embeddings response: %v, body: %s !!!", err, string(bodyBytes))
+		log.Printf("!!! Error parsing Ollama embeddings response: %v !!!", err)
 		http.Error(w, "Failed to parse response", http.StatusInternalServerError)
 		return
 	}
-	
-	// Extract embedding vector
-	// Ollama may return either "embedding" (single) or "embeddings" (array)
-	var embedding []interface{}
-	var found bool
-	
-	// First try "embeddings" (plural) - array format
-	if embeddingsArray, ok := ollamaResp["embeddings"].([]interface{}); ok && len(embeddingsArray) > 0 {
-		// Take the first embedding from the array
-		if firstEmbedding, ok := embeddingsArray[0].([]interface{}); ok {
-			embedding = firstEmbedding
-			found = true
-			log.Printf(">>> Extracted embedding from 'embeddings' array (length=%d) <<<", len(embedding))
-		} else if firstEmbeddingFloat, ok := embeddingsArray[0].([]float64); ok {
-			// Convert []float64 to []interface{}
-			embedding = make([]interface{}, len(firstEmbeddingFloat))
-			for i, v := range firstEmbeddingFloat {
-				embedding[i] = v
-			}
-			found = true
-			log.Printf(">>> Extracted embedding from 'embeddings' array (float64, length=%d) <<<", len(embedding))
-		} else {
-			log.Printf("!!! Invalid format in 'embeddings' array first element: %T !!!", embeddingsArray[0])
-		}
-	}
-	
-	// If not found in "embeddings", try "embedding" (singular)
-	if !found {
-		if embeddingSingle, ok := ollamaResp["embedding"].([]interface{}); ok {
-			embedding = embeddingSingle
-			found = true
-			log.Printf(">>> Extracted embedding from 'embedding' field (length=%d) <<<", len(embedding))
-		} else if embeddingFloat, ok := ollamaResp["embedding"].([]float64); ok {
-			// Convert []float64 to []interface{}
-			embedding = make([]interface{}, len(embeddingFloat))
-			for i, v := range embeddingFloat {
-				embedding[i] = v
-			}
-			found = true
-			log.Printf(">>> Extracted embedding from 'embedding' field (float64, length=%d) <<<", len(embedding))
-		}
-	}
-	
-	if !found || len(embedding) == 0 {
-		log.Printf("!!! Invalid embedding format in Ollama response: embedding=%v, embeddings=%v, keys: %v !!!", 
-			ollamaResp["embedding"], ollamaResp["embeddings"], getMapKeys(ollamaResp))
+	vectors := emb.vectors()
+	if len(vectors) == 0 || len(vectors[0]) == 0 {
+		log.Printf("!!! Invalid embedding format or empty embedding in Ollama response !!!")
 		http.Error(w, "Invalid embedding format or empty embedding", http.StatusInternalServerError)
 		return
 	}
 	
 	// Convert to OpenAI format (OpenWebUI expects this format)
-	openAIResp := map[string]interface{}{
-		"object": "list",
-		"data": []map[string]interface{}{
-			{
-				"object":    "embedding",
-				"embedding": embedding,
-				"index":     0,
-			},
-		},
-		"model": s.config.Model,
-		"usage": map[string]interface{}{
-			"prompt_tokens": 0,
-			"total_tokens":  0,
-		},
-	}
-	
-	// Try to extract usage if available
-	if promptEvalCount, ok := ollamaResp["prompt_eval_count"].(float64); ok {
-		openAIResp["usage"].(map[string]interface{})["prompt_tokens"] = int(promptEvalCount)
-		openAIResp["usage"].(map[string]interface{})["total_tokens"] = int(promptEvalCount)
-	}
-	
-	responseJSON, err := json.Marshal(openAIResp)
-	if err != nil {
-		log.Printf("!!! Error marshaling OpenAI embeddings response: %v !!!", err)
-		http.Error(w, "Failed to format response", http.StatusInternalServerError)
-		return
-	}
-	
-	log.Printf(">>> Converted to OpenAI format: data array with %d items, embedding length=%d <<<", 
-		len(openAIResp["data"].([]map[string]interface{})), len(embedding))
-	
-	// Set status code
+	log.Printf(">>> Converting to OpenAI format: embedding length=%d <<<", len(vectors[0]))
 	w.WriteHeader(resp.StatusCode)
-	
-	// Write OpenAI format response
-	bytesCopied, err := w.Write(responseJSON)
-	if err != nil {
+	if err := writeOpenAIEmbeddings(w, requestData, s.model(), vectors[:1], emb.PromptEvalCount); err != nil {
 		log.Printf("!!! Error writing OpenAI embeddings response: %v !!!", err)
 		return
 	}
-	log.Printf("<<< Sent OpenAI embeddings format response (%d bytes) <<<", bytesCopied)
+	log.Printf("<<< Sent OpenAI embeddings format response <<<")
 }
 
 // flattenContent converts OpenAI multimodal content to a plain string for Ollama.
@@ -3201,6 +2907,67 @@ func flattenContent(content interface{}) string {
 	return strings.Join(parts, "")
 }
 
+// applyToolChoice carries an OpenAI tool_choice over to the Ollama request,
+// whose tools it already holds. Ollama has no tool_choice, so it is applied
+// to the tools instead: "none" sends none, a named function
+// ({"type": "function", "function": {"name": ...}}, or the Responses API's
+// {"type": "function", "name": ...}) sends only that one, and is an error
+// when tools has no such function. "auto" and "required" keep them all;
+// Ollama can't be made to call one (noteParams warns about "required").
+func applyToolChoice(ollamaRequest map[string]interface{}, toolChoice interface{}) error {
+	switch tc := toolChoice.(type) {
+	case string:
+		if tc == "none" {
+			delete(ollamaRequest, "tools")
+		}
+	case map[string]interface{}:
+		name, _ := tc["name"].(string)
+		if fn, ok := tc["function"].(map[string]interface{}); ok {
+			name, _ = fn["name"].(string)
+		}
+		if name == "" {
+			return nil
+		}
+		tools, _ := ollamaRequest["tools"].([]interface{})
+		var chosen []interface{}
+		for _, t := range tools {
+			if tm, ok := t.(map[string]interface{}); ok {
+				if fn, ok := tm["function"].(map[string]interface{}); ok && fn["name"] == name {
+					chosen = append(chosen, t)
+				}
+			}
+		}
+		if len(chosen) == 0 {
+			return fmt.Errorf("tool_choice names the function %q, which is not in 'tools'", name)
+		}
+		ollamaRequest["tools"] = chosen
+	}
+	return nil
+}
+
+// applyResponseFormat sets the Ollama "format" for an OpenAI response_format
+// (or the Responses API's text.format): {"type": "json_object"} is JSON mode
+// ("json"), {"type": "json_schema"} sends its schema, from json_schema.schema
+// or, in the Responses form, schema. "text" and anything else leave the
+// output free.
+func applyResponseFormat(ollamaRequest map[string]interface{}, responseFormat interface{}) {
+	rf, _ := responseFormat.(map[string]interface{})
+	switch rf["type"] {
+	case "json_object":
+		ollamaRequest["format"] = "json"
+	case "json_schema":
+		schema, _ := rf["schema"].(map[string]interface{})
+		if js, ok := rf["json_schema"].(map[string]interface{}); ok {
+			schema, _ = js["schema"].(map[string]interface{})
+		}
+		if schema != nil {
+			ollamaRequest["format"] = schema
+		} else {
+			ollamaRequest["format"] = "json"
+		}
+	}
+}
+
 // convertOpenAIToolCallsToOllama converts tool_calls from OpenAI format (arguments is
 // a JSON string) to Ollama format (arguments is a map).
 func convertOpenAIToolCallsToOllama(toolCalls []interface{}) []map[string]interface{} {
@@ -3234,6 +3001,18 @@ func convertOpenAIToolCallsToOllama(toolCalls []interface{}) []map[string]interf
 	return result
 }
 
+// streamedToolCalls converts the tool calls of one stream chunk, numbering
+// them after the *sent calls of earlier chunks: clients merge deltas by
+// index, so every call of the stream needs its own.
+func streamedToolCalls(toolCalls []interface{}, sent *int) []map[string]interface{} {
+	converted := convertOllamaToolCallsToOpenAI(toolCalls)
+	for _, tc := range converted {
+		tc["index"] = *sent
+		*sent++
+	}
+	return converted
+}
+
 // convertOllamaToolCallsToOpenAI converts tool_calls from Ollama format (arguments is
 // a map) to OpenAI format (arguments is a JSON string, with id and type fields).
 func convertOllamaToolCallsToOpenAI(toolCalls []interface{}) []map[string]interface{} {
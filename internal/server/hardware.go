@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const hardwareCacheTTL = 30 * time.Second // nvidia-smi and /api/ps are not asked on every request
+
+// cpuFeatures are the instruction set extensions llama.cpp has fast paths
+// for, as /proc/cpuinfo names them.
+var cpuFeatures = []string{"avx", "avx2", "avx512f", "avx512_vnni", "avx_vnni", "fma", "f16c", "amx_int8", "asimd", "asimddp", "sve", "i8mm"}
+
+// machineInfo is what the host offers to models: memory, CPU and the GPUs
+// nvidia-smi reports, plus what Ollama shows of its GPU use (/api/ps), which
+// also covers GPUs nvidia-smi can't see. On Olares the proxy runs on the
+// machine Ollama does.
+type machineInfo struct {
+	MemoryTotal     int64     `json:"memory_total"`             // bytes; the container's memory limit if lower; 0 = unknown
+	MemoryAvailable int64     `json:"memory_available"`         // bytes
+	MemoryLimited   bool      `json:"memory_limited,omitempty"` // MemoryTotal is a cgroup limit
+	CPUs            int       `json:"cpus"`
+	CPUModel        string    `json:"cpu_model,omitempty"`
+	Arch            string    `json:"arch"`
+	CPUFeatures     []string  `json:"cpu_features"`
+	GPUs            []gpuInfo `json:"gpus"`
+	OllamaGPU       bool      `json:"ollama_gpu"`                 // a loaded model is (partly) in GPU memory
+	OllamaVRAM      int64     `json:"ollama_vram_used,omitempty"` // GPU memory Ollama's loaded models use
+	DetectedAt      time.Time `json:"detected_at"`
+}
+
+type gpuInfo struct {
+	Name        string `json:"name"`
+	MemoryTotal int64  `json:"memory_total"` // bytes
+}
+
+// vram is the memory of the largest GPU; Ollama splits a model over
+// several GPUs only when it doesn't fit on one, so that is the safe figure.
+// Without nvidia-smi, the GPU memory Ollama's models already use is a lower
+// bound of it.
+func (m *machineInfo) vram() int64 {
+	most := m.OllamaVRAM
+	for _, g := range m.GPUs {
+		most = max(most, g.MemoryTotal)
+	}
+	return most
+}
+
+func (m *machineInfo) hasCPUFeature(name string) bool {
+	return slices.Contains(m.CPUFeatures, name)
+}
+
+// machineCache keeps the last detection for hardwareCacheTTL.
+type machineCache struct {
+	mu   sync.Mutex
+	info *machineInfo
+}
+
+// machine returns the detected machine, at most hardwareCacheTTL old.
+func (s *Server) machine(ctx context.Context) *machineInfo {
+	c := &s.machineCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.info == nil || time.Since(c.info.DetectedAt) > hardwareCacheTTL {
+		c.info = s.detectMachine(ctx)
+	}
+	return c.info
+}
+
+// detectMachine reads /proc/meminfo, the cgroup memory limit,
+// /proc/cpuinfo, nvidia-smi and Ollama's /api/ps. What can't be read is
+// left zero.
+func (s *Server) detectMachine(ctx context.Context) *machineInfo {
+	m := &machineInfo{CPUs: runtime.NumCPU(), Arch: runtime.GOARCH, CPUFeatures: []string{}, GPUs: []gpuInfo{}, DetectedAt: time.Now().UTC()}
+	m.MemoryTotal, m.MemoryAvailable, _ = readMemoryTotals()
+	if limit := readCgroupMemoryLimit(); limit > 0 && (m.MemoryTotal == 0 || limit < m.MemoryTotal) {
+		m.MemoryTotal, m.MemoryLimited = limit, true
+		m.MemoryAvailable = min(m.MemoryAvailable, limit)
+	}
+	m.CPUModel, m.CPUFeatures = readCPUInfo()
+	if tool, err := exec.LookPath("nvidia-smi"); err == nil {
+		m.GPUs, _ = readGPUs(ctx, tool)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if running, err := s.ollamaClient.RunningModels(ctx); err == nil {
+		for _, rm := range running {
+			if rm.SizeVRAM > 0 {
+				m.OllamaGPU = true
+				m.OllamaVRAM += rm.SizeVRAM
+			}
+		}
+	}
+	return m
+}
+
+// readMemoryTotals returns MemTotal and MemAvailable of /proc/meminfo, in
+// bytes.
+func readMemoryTotals() (total, available int64, err error) {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		kb, _ := strconv.ParseInt(fields[1], 10, 64)
+		switch fields[0] {
+		case "MemTotal:":
+			total = kb << 10
+		case "MemAvailable:":
+			available = kb << 10
+		}
+	}
+	if total == 0 {
+		return 0, 0, fmt.Errorf("no MemTotal in /proc/meminfo")
+	}
+	return total, available, nil
+}
+
+// readCgroupMemoryLimit returns the container's memory limit (cgroup v2,
+// then v1); 0 = none.
+func readCgroupMemoryLimit() int64 {
+	for _, file := range []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		// "max", or v1's "unlimited" figure near 2^63
+		if n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil && n < 1<<60 {
+			return n
+		}
+		return 0
+	}
+	return 0
+}
+
+// readCPUInfo returns the CPU model and which of cpuFeatures it has, from
+// /proc/cpuinfo ("flags" on x86, "Features" on ARM).
+func readCPUInfo() (model string, features []string) {
+	features = []string{}
+	data, err := os.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return "", features
+	}
+	var flags []string
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "model name", "Model", "Hardware":
+			if model == "" {
+				model = strings.TrimSpace(value)
+			}
+		case "flags", "Features":
+			if flags == nil {
+				flags = strings.Fields(value)
+			}
+		}
+	}
+	for _, f := range cpuFeatures {
+		if slices.Contains(flags, f) {
+			features = append(features, f)
+		}
+	}
+	return model, features
+}
+
+// readGPUs lists the GPUs with their memory from nvidia-smi.
+func readGPUs(ctx context.Context, tool string) ([]gpuInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, tool, "--query-gpu=name,memory.total", "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return []gpuInfo{}, err
+	}
+	gpus := []gpuInfo{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		name, mib, ok := strings.Cut(line, ",")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseInt(strings.TrimSpace(mib), 10, 64); err == nil {
+			gpus = append(gpus, gpuInfo{Name: strings.TrimSpace(name), MemoryTotal: n << 20})
+		}
+	}
+	return gpus, nil
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// droppedHeaders never reach Ollama unless FORWARD_HEADERS names them:
+// credentials meant for the proxy, headers the proxy itself consumes, and
+// hop-by-hop headers. Accept-Encoding is left to the transport, which then
+// decompresses the responses the handlers parse.
+var droppedHeaders = []string{
+	"host", "authorization", "proxy-authorization", "cookie", "x-api-key", "x-admin-token",
+	"x-proxy-*", "x-prompt-template", "x-output-filters", "idempotency-key", "last-event-id",
+	"connection", "keep-alive", "proxy-connection", "te", "trailer", "transfer-encoding", "upgrade",
+	"accept-encoding", "content-length",
+}
+
+// headerMatches reports whether the lower-cased header name matches one of
+// the patterns (case-insensitive names, "prefix*" and "*").
+func headerMatches(name string, patterns []string) bool {
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == name || strings.HasSuffix(p, "*") && strings.HasPrefix(name, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerNamed reports whether patterns name the header exactly.
+func headerNamed(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if strings.EqualFold(strings.TrimSpace(p), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardHeader decides whether a client header is sent upstream. With
+// FORWARD_HEADERS set only matching headers pass; DROP_HEADERS always
+// wins, and the built-in drops give way only to a header FORWARD_HEADERS
+// names exactly.
+func (s *Server) forwardHeader(key string) bool {
+	name := strings.ToLower(key)
+	if headerMatches(name, s.config.DropHeaders) {
+		return false
+	}
+	if headerNamed(name, s.config.ForwardHeaders) {
+		return true
+	}
+	if headerMatches(name, droppedHeaders) {
+		return false
+	}
+	return len(s.config.ForwardHeaders) == 0 || headerMatches(name, s.config.ForwardHeaders)
+}
+
+// upstreamHeaders returns the client headers to send with a request to Ollama.
+func (s *Server) upstreamHeaders(r *http.Request) map[string]string {
+	headers := make(map[string]string)
+	for key, values := range r.Header {
+		if len(values) > 0 && s.forwardHeader(key) {
+			headers[key] = values[0]
+		}
+	}
+	return headers
+}
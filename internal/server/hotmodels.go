@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	hotModelsInterval = 30 * time.Second // reconcile period, besides after each request
+	hotLoadTimeout    = 10 * time.Minute // one model load (large models from disk)
+	hotLoadBackoff    = 5 * time.Minute  // wait before retrying a failed load
+	hotDecisionsKept  = 20
+)
+
+// hotDecision is one load or unload, reported in /api/status.
+type hotDecision struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"` // "load" or "unload"
+	Model  string    `json:"model"`
+	Reason string    `json:"reason"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// hotModelSet keeps the HOT_MODELS_KEEP most recently used of the managed
+// models (OLLAMA_MODEL, FAST_LANE_MODEL, HOT_MODELS) loaded: requests for
+// them run with keep_alive -1, and the proxy unloads the others itself.
+// Models never used yet rank in list order, so the first ones are preloaded.
+type hotModelSet struct {
+	mu        sync.Mutex
+	models    []string // managed models, in preload order
+	lastUsed  map[string]time.Time
+	loaded    map[string]bool
+	failedAt  map[string]time.Time
+	checkedAt time.Time
+	decisions []hotDecision
+	kick      chan struct{}
+}
+
+// newHotModelSet returns nil when HOT_MODELS is empty (Ollama manages
+// residency on its own).
+func newHotModelSet(model, fastLaneModel string, extra []string) *hotModelSet {
+	if len(extra) == 0 {
+		return nil
+	}
+	hs := &hotModelSet{
+		lastUsed: make(map[string]time.Time),
+		loaded:   make(map[string]bool),
+		failedAt: make(map[string]time.Time),
+		kick:     make(chan struct{}, 1),
+	}
+	seen := make(map[string]bool)
+	for _, m := range append([]string{model, fastLaneModel}, extra...) {
+		if m != "" && !seen[m] {
+			seen[m] = true
+			hs.models = append(hs.models, m)
+		}
+	}
+	return hs
+}
+
+// managed returns the managed model name matching name, if any.
+func (hs *hotModelSet) managed(name string) (string, bool) {
+	for _, m := range hs.models {
+		if matchesModel(name, m) {
+			return m, true
+		}
+	}
+	return "", false
+}
+
+// add manages one more model, first in preload order (the model switched to).
+func (hs *hotModelSet) add(name string) {
+	if _, ok := hs.managed(name); ok {
+		return
+	}
+	hs.mu.Lock()
+	hs.models = append([]string{name}, hs.models...)
+	hs.mu.Unlock()
+	select {
+	case hs.kick <- struct{}{}:
+	default:
+	}
+}
+
+// use marks the model as just used and asks for a reconcile.
+func (hs *hotModelSet) use(name string) {
+	m, ok := hs.managed(name)
+	if !ok {
+		return
+	}
+	hs.mu.Lock()
+	hs.lastUsed[m] = time.Now()
+	hs.mu.Unlock()
+	select {
+	case hs.kick <- struct{}{}:
+	default:
+	}
+}
+
+// rankedLocked orders the managed models most recently used first.
+func (hs *hotModelSet) rankedLocked() []string {
+	ranked := append([]string(nil), hs.models...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return hs.lastUsed[ranked[i]].After(hs.lastUsed[ranked[j]])
+	})
+	return ranked
+}
+
+func (hs *hotModelSet) recordLocked(d hotDecision) {
+	d.Time = time.Now()
+	hs.decisions = append(hs.decisions, d)
+	if len(hs.decisions) > hotDecisionsKept {
+		hs.decisions = hs.decisions[len(hs.decisions)-hotDecisionsKept:]
+	}
+}
+
+// applyHotKeepAlive lets a request for a managed model keep it loaded until
+// the proxy decides to unload it. The model counts as used from the start of
+// the request, so a long generation doesn't get its model evicted.
+func (s *Server) applyHotKeepAlive(req map[string]interface{}) {
+	if s.hotModels == nil {
+		return
+	}
+	if model, _ := req["model"].(string); model != "" {
+		if _, ok := s.hotModels.managed(model); ok {
+			req["keep_alive"] = -1
+			s.hotModels.use(model)
+		}
+	}
+}
+
+// runHotModels reconciles residency periodically and after each request.
+func (s *Server) runHotModels() {
+	hs := s.hotModels
+	log.Printf("Managing residency of %v, keeping %d loaded", hs.models, s.config.HotModelsKeep)
+	for {
+		s.reconcileHotModels()
+		select {
+		case <-time.After(hotModelsInterval):
+		case <-hs.kick:
+		}
+	}
+}
+
+// reconcileHotModels unloads the managed models outside the most recently
+// used HOT_MODELS_KEEP (first, to free memory), then loads the ones inside.
+func (s *Server) reconcileHotModels() {
+	hs := s.hotModels
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamProbeTimeout)
+	running, err := s.ollamaClient.RunningModels(ctx)
+	cancel()
+	if err != nil {
+		return // reachability is the prober's business
+	}
+
+	hs.mu.Lock()
+	hs.checkedAt = time.Now()
+	for _, m := range hs.models {
+		hs.loaded[m] = false
+		for _, rm := range running {
+			if matchesModel(rm.Name, m) || matchesModel(rm.Model, m) {
+				hs.loaded[m] = true
+			}
+		}
+	}
+	keep := s.config.HotModelsKeep
+	ranked := hs.rankedLocked()
+	var unload, load []string
+	for i, m := range ranked {
+		switch {
+		case i >= keep && hs.loaded[m]:
+			if s.ModelPinned(m) {
+				continue // pinned: stays loaded past its rank
+			}
+			unload = append(unload, m)
+		case i < keep && !hs.loaded[m] && time.Since(hs.failedAt[m]) >= hotLoadBackoff:
+			load = append(load, m)
+		}
+	}
+	hs.mu.Unlock()
+
+	for _, m := range unload {
+		s.setHotResidency(m, "unload", fmt.Sprintf("not among the %d most recently used", keep))
+	}
+	for _, m := range load {
+		reason := "recently used"
+		hs.mu.Lock()
+		if hs.lastUsed[m].IsZero() {
+			reason = "preload"
+		}
+		hs.mu.Unlock()
+		s.setHotResidency(m, "load", reason)
+	}
+}
+
+// setHotResidency loads or unloads one model and records the decision.
+func (s *Server) setHotResidency(model, action, reason string) {
+	hs := s.hotModels
+	keepAlive, timeout := -1, hotLoadTimeout
+	if action == "unload" {
+		keepAlive, timeout = 0, upstreamProbeTimeout
+	}
+	log.Printf("Hot models: %s %s (%s)", action, model, reason)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	start := time.Now()
+	err := s.ollamaClient.SetKeepAlive(ctx, model, keepAlive)
+	cancel()
+
+	d := hotDecision{Action: action, Model: model, Reason: reason}
+	hs.mu.Lock()
+	if err != nil {
+		log.Printf("!!! Hot models: failed to %s %s: %v !!!", action, model, err)
+		s.events.Record("model_"+action+"_failed", map[string]interface{}{"model": model, "reason": reason, "error": err.Error()})
+		d.Error = err.Error()
+		if action == "load" {
+			hs.failedAt[model] = time.Now()
+		}
+	} else {
+		hs.loaded[model] = action == "load"
+		delete(hs.failedAt, model)
+		log.Printf("Hot models: %s %s done in %s", action, model, time.Since(start).Round(time.Millisecond))
+		s.events.Record("model_"+action+"ed", map[string]interface{}{
+			"model": model, "reason": reason, "duration_ms": time.Since(start).Milliseconds(),
+		})
+	}
+	hs.recordLocked(d)
+	hs.mu.Unlock()
+	if model == s.model() {
+		ctx, cancel := context.WithTimeout(context.Background(), modelLoadCheckWait)
+		s.refreshModelLoad(ctx)
+		cancel()
+	}
+}
+
+// hotModelsInfo returns the hot_models object for /api/status.
+func (s *Server) hotModelsInfo() map[string]interface{} {
+	hs := s.hotModels
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	models := make([]map[string]interface{}, 0, len(hs.models))
+	for i, m := range hs.rankedLocked() {
+		entry := map[string]interface{}{
+			"name":   m,
+			"rank":   i + 1,
+			"kept":   i < s.config.HotModelsKeep,
+			"loaded": hs.loaded[m],
+		}
+		if s.ModelPinned(m) {
+			entry["pinned"] = true
+		}
+		if t := hs.lastUsed[m]; !t.IsZero() {
+			entry["last_used"] = t
+		}
+		if t, ok := hs.failedAt[m]; ok {
+			entry["load_failed_at"] = t
+		}
+		models = append(models, entry)
+	}
+	info := map[string]interface{}{
+		"keep":      s.config.HotModelsKeep,
+		"models":    models,
+		"decisions": append([]hotDecision{}, hs.decisions...),
+	}
+	if !hs.checkedAt.IsZero() {
+		info["checked_at"] = hs.checkedAt
+	}
+	return info
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headerIdempotencyKey marks a request as safe to retry and to deduplicate.
+const headerIdempotencyKey = "Idempotency-Key"
+
+// headerIdempotentReplay is set on responses served from the idempotency cache.
+const headerIdempotentReplay = "Idempotent-Replayed"
+
+// headerRequestID identifies one logical request across client retries. Without
+// an Idempotency-Key it only keys the replay of results the client never got.
+const headerRequestID = "X-Request-Id"
+
+// idempotencyStore remembers the results of requests sent with an
+// Idempotency-Key for IDEMPOTENCY_TTL_SEC, keyed by path + key.
+type idempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotentResult
+}
+
+type idempotentResult struct {
+	bodyHash   [32]byte
+	done       chan struct{} // closed once the result below is filled in
+	status     int
+	header     http.Header
+	body       []byte
+	prompt     int
+	completion int
+	expires    time.Time
+}
+
+func newIdempotencyStore() *idempotencyStore {
+	return &idempotencyStore{entries: make(map[string]*idempotentResult)}
+}
+
+// claim returns the entry for key, creating it if there is none (owner=true:
+// the caller must complete or drop it). Expired entries are pruned on the way.
+func (st *idempotencyStore) claim(key string, bodyHash [32]byte) (res *idempotentResult, owner bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := time.Now()
+	for k, e := range st.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(st.entries, k)
+		}
+	}
+	if e, ok := st.entries[key]; ok {
+		return e, false
+	}
+	res = &idempotentResult{bodyHash: bodyHash, done: make(chan struct{})}
+	st.entries[key] = res
+	return res, true
+}
+
+func (st *idempotencyStore) complete(res *idempotentResult, ttl time.Duration) {
+	st.mu.Lock()
+	res.expires = time.Now().Add(ttl)
+	st.mu.Unlock()
+	close(res.done)
+}
+
+// drop forgets a result that must not be replayed (a final 5xx), so the
+// client's own retry with the same key runs again.
+func (st *idempotencyStore) drop(key string, res *idempotentResult) {
+	st.mu.Lock()
+	if st.entries[key] == res {
+		delete(st.entries, key)
+	}
+	st.mu.Unlock()
+	close(res.done)
+}
+
+// withIdempotency handles requests carrying an Idempotency-Key. Non-streaming
+// requests are retried on transient upstream failures (502/503/504) up to
+// IDEMPOTENCY_RETRIES times, and their final non-5xx result is replayed to
+// duplicates of the key within the TTL (a duplicate arriving while the first
+// is still running waits for it). Reusing a key with a different body is a
+// 422. Streaming requests pass through untouched: a partially streamed
+// response can be neither retried nor replayed faithfully.
+//
+// The result is computed to the end even if the client disconnects, so its
+// retry gets the answer instead of a second generation. Requests with only
+// an X-Request-Id are not retried, and their result is kept (for
+// REPLAY_CACHE_TTL_SEC) only when it could not be delivered.
+func (s *Server) withIdempotency(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, keyHeader := r.Header.Get(headerIdempotencyKey), headerIdempotencyKey
+		if key == "" || s.config.IdempotencyTTLSec <= 0 {
+			key, keyHeader = "", headerRequestID
+			if s.config.ReplayCacheTTLSec > 0 {
+				key = r.Header.Get(headerRequestID)
+			}
+		}
+		if key == "" {
+			h(w, r)
+			return
+		}
+		replayOnly := keyHeader == headerRequestID
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			writeError(w, errorFormatForPath(r.URL.Path), http.StatusBadRequest, "invalid_request", "Failed to read request body: "+err.Error())
+			return
+		}
+		if isStreamRequest(r.URL.Path, body) {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			h(w, r)
+			return
+		}
+
+		cacheKey := keyHeader + "\x00" + r.URL.Path + "\x00" + key
+		hash := sha256.Sum256(body)
+		res, owner := s.idempotency.claim(cacheKey, hash)
+		if !owner {
+			if res.bodyHash != hash {
+				writeError(w, errorFormatForPath(r.URL.Path), http.StatusUnprocessableEntity, "idempotency_key_reused",
+					keyHeader+" was already used with a different request body")
+				return
+			}
+			select {
+			case <-res.done:
+			case <-r.Context().Done():
+				return
+			}
+			if res.header == nil {
+				// The original failed and was dropped; run this one instead.
+				s.withIdempotency(h)(w, withBody(r, body))
+				return
+			}
+			log.Printf(">>> %s: replaying cached result for %s %q <<<", r.URL.Path, keyHeader, key)
+			if meta := metaFrom(r); meta != nil {
+				meta.addUsage(res.prompt, res.completion)
+				meta.update(func(m *requestMeta) { m.cacheHit = true })
+			}
+			header := res.header.Clone()
+			header.Set(headerIdempotentReplay, "true")
+			writeRecorded(w, res.status, header, res.body)
+			return
+		}
+
+		retries := s.config.IdempotencyRetries
+		if replayOnly {
+			retries = 0
+		}
+		client := r.Context()
+		rec := s.runWithRetries(h, r.WithContext(context.WithoutCancel(client)), body, keyHeader+" "+strconv.Quote(key), retries)
+		if rec.status >= http.StatusInternalServerError {
+			s.idempotency.drop(cacheKey, res)
+			writeRecorded(w, rec.status, rec.header, rec.body.Bytes())
+			return
+		}
+		res.status, res.header, res.body = rec.status, rec.header.Clone(), rec.body.Bytes()
+		if meta := metaFrom(r); meta != nil {
+			res.prompt, res.completion, _ = meta.usage()
+		}
+		delivered := client.Err() == nil && writeRecorded(w, rec.status, rec.header, rec.body.Bytes()) == nil
+		ttl := time.Duration(s.config.IdempotencyTTLSec) * time.Second
+		if replayOnly {
+			ttl = 0 // delivered: only requests already waiting for it get a copy
+		}
+		if !delivered {
+			log.Printf("!!! %s: client went away before the result was delivered; keeping it for a retry with %s %q !!!", r.URL.Path, keyHeader, key)
+			if replayOnly {
+				ttl = time.Duration(s.config.ReplayCacheTTLSec) * time.Second
+			}
+		}
+		s.idempotency.complete(res, ttl)
+	}
+}
+
+// runWithRetries runs h into a buffer, again after transient upstream
+// failures (up to retries times), with a growing pause between attempts.
+func (s *Server) runWithRetries(h http.HandlerFunc, r *http.Request, body []byte, key string, retries int) *responseRecorder {
+	backoff := time.Duration(s.config.IdempotencyRetryBackoffMs) * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		rec := newResponseRecorder()
+		h(rec, withBody(r, body))
+		if !isTransientStatus(rec.status) || attempt >= retries || r.Context().Err() != nil {
+			return rec
+		}
+		log.Printf("!!! %s: transient upstream failure %d (%s), retry %d/%d in %v !!!",
+			r.URL.Path, rec.status, key, attempt+1, retries, backoff)
+		if meta := metaFrom(r); meta != nil {
+			meta.update(func(m *requestMeta) { m.retries++ })
+		}
+		if !sleepCtx(r.Context(), backoff) {
+			return rec
+		}
+		backoff *= 2
+	}
+}
+
+// isStreamRequest reports whether a request body asks for a streamed
+// response, applying each API's default when "stream" is absent (Ollama
+// chat/generate stream by default; OpenAI, Anthropic and embeddings don't).
+func isStreamRequest(path string, body []byte) bool {
+	var req struct {
+		Stream *bool `json:"stream"`
+	}
+	if json.Unmarshal(body, &req) == nil && req.Stream != nil {
+		return *req.Stream
+	}
+	if strings.HasPrefix(path, "/v1/") || path == "/api/chat/completions" || strings.Contains(path, "embed") {
+		return false
+	}
+	return true
+}
+
+func isTransientStatus(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func withBody(r *http.Request, body []byte) *http.Request {
+	r2 := r.Clone(r.Context())
+	r2.Body = io.NopCloser(bytes.NewReader(body))
+	r2.ContentLength = int64(len(body))
+	return r2
+}
+
+// sleepCtx waits for d, returning false if ctx is cancelled first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// responseRecorder buffers a handler's response so it can be retried or cached.
+type responseRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        *bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: http.Header{}, status: http.StatusOK, body: &bytes.Buffer{}}
+}
+
+func (rr *responseRecorder) Header() http.Header { return rr.header }
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	if rr.wroteHeader {
+		return
+	}
+	rr.wroteHeader = true
+	rr.status = code
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.WriteHeader(http.StatusOK)
+	return rr.body.Write(b)
+}
+
+// writeRecorded copies a recorded response to w.
+func writeRecorded(w http.ResponseWriter, status int, header http.Header, body []byte) error {
+	for k, v := range header {
+		w.Header()[k] = append([]string(nil), v...)
+	}
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
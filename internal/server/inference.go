@@ -0,0 +1,182 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// prepareInference runs the proxy-side request pipeline on an Ollama-format
+// chat/generate request, in order: per-user routing, tenant limits, routing rules, capability negotiation, prompt template injection, fast-lane
+// classification and limiter admission, the tenant's default max_tokens, context window fitting, the
+// operator's generation policy and HOT_MODELS keep_alive. requestedModel is the model name the client
+// sent (before replacement); maxTokens is the client's output cap when it is
+// not already in options.num_predict. On ok the caller must defer release().
+func (s *Server) prepareInference(w http.ResponseWriter, r *http.Request, req map[string]interface{}, requestedModel string, maxTokens int) (release func(), ok bool) {
+	if meta := metaFrom(r); meta != nil && requestedModel != "" {
+		meta.update(func(m *requestMeta) { m.requestedModel = requestedModel })
+	}
+	requestedModel = s.applyUserRoute(r, req, requestedModel)
+	if !s.enforceTenantLimits(w, r, req, maxTokens) {
+		return nil, false
+	}
+	s.applyRoutingRules(r, req)
+	if !s.negotiateRequest(w, r, req) {
+		return nil, false
+	}
+	s.applyPromptTemplate(w, r, req, requestedModel)
+	release, ok = s.admitInference(w, r, req, maxTokens)
+	if !ok {
+		return nil, false
+	}
+	s.applyTenantMaxTokens(r, req, maxTokens)
+	s.fitContextWindow(w, r, req)
+	s.applyGenerationPolicy(req, maxTokens)
+	s.applyHotKeepAlive(req)
+	s.applyPinnedKeepAlive(req)
+	s.traceOutcome(w, r, req, requestedModel)
+	model, _ := req["model"].(string)
+	admitted, done := release, s.modelUsage.track(model)
+	return func() { admitted(); done() }, true
+}
+
+// responseModel is the "model" reported in OpenAI-format responses: the
+// configured model, or with REPORT_SERVED_MODEL the one that actually
+// answered (e.g. FAST_LANE_MODEL).
+func (s *Server) responseModel(r *http.Request) string {
+	if s.config.ReportServedModel {
+		if meta := metaFrom(r); meta != nil {
+			if model := meta.served(); model != "" {
+				return model
+			}
+		}
+	}
+	return s.model()
+}
+
+// applyGenerationPolicy merges GLOBAL_STOP_SEQUENCES into options.stop and
+// clamps options.num_predict to MAX_TOKENS_CAP. Client values are kept when
+// they are within the policy; unlimited or missing caps become the cap.
+// Top-level num_predict/stop (as built for /api/generate) are folded into options.
+func (s *Server) applyGenerationPolicy(req map[string]interface{}, maxTokens int) {
+	if len(s.config.GlobalStopSequences) == 0 && s.config.MaxTokensCap <= 0 {
+		return
+	}
+	options, _ := req["options"].(map[string]interface{})
+	if options == nil {
+		options = map[string]interface{}{}
+		req["options"] = options
+	}
+
+	if len(s.config.GlobalStopSequences) > 0 {
+		stops := stopList(options["stop"])
+		if top, ok := req["stop"]; ok {
+			stops = append(stops, stopList(top)...)
+			delete(req, "stop")
+		}
+		options["stop"] = s.mergeStops(stops)
+	}
+
+	if limit := s.config.MaxTokensCap; limit > 0 {
+		client := intParam(options["num_predict"])
+		if _, has := options["num_predict"]; !has {
+			client = intParam(req["num_predict"])
+			if client == 0 {
+				client = maxTokens
+			}
+		}
+		delete(req, "num_predict")
+		// Ollama treats num_predict <= 0 (-1, -2) as unlimited.
+		if client <= 0 || client > limit {
+			if client > limit {
+				log.Printf(">>> Clamping num_predict %d to MAX_TOKENS_CAP %d <<<", client, limit)
+			}
+			client = limit
+		}
+		options["num_predict"] = client
+	}
+}
+
+// anthropicToChat gives an Anthropic Messages request the shape the
+// inference pipeline reads: the top-level system prompt becomes a leading
+// system message, max_tokens and stop_sequences go into options.
+// chatToAnthropic undoes it before the request is sent.
+func anthropicToChat(req map[string]interface{}) {
+	if system := flattenContent(req["system"]); system != "" {
+		msgs := messagesOf(req["messages"])
+		req["messages"] = append([]interface{}{map[string]interface{}{"role": "system", "content": system}}, msgs...)
+	}
+	delete(req, "system")
+	options := map[string]interface{}{}
+	if n := intParam(req["max_tokens"]); n > 0 {
+		options["num_predict"] = n
+	}
+	if stops := stopList(req["stop_sequences"]); len(stops) > 0 {
+		options["stop"] = stops
+	}
+	req["options"] = options
+}
+
+// chatToAnthropic moves options back to max_tokens and stop_sequences and
+// every system message (the client's, a prompt template's, a context
+// summary) into the top-level system prompt: the Messages API has no system
+// role.
+func chatToAnthropic(req map[string]interface{}) {
+	options, _ := req["options"].(map[string]interface{})
+	if n := intParam(options["num_predict"]); n > 0 {
+		req["max_tokens"] = n
+	}
+	if stops := stopList(options["stop"]); len(stops) > 0 {
+		req["stop_sequences"] = stops
+	}
+	delete(req, "options")
+	var system []string
+	msgs := make([]interface{}, 0, len(messagesOf(req["messages"])))
+	for _, m := range messagesOf(req["messages"]) {
+		if roleOf(m) == "system" {
+			system = append(system, flattenContent(m.(map[string]interface{})["content"]))
+			continue
+		}
+		msgs = append(msgs, m)
+	}
+	req["messages"] = msgs
+	if len(system) > 0 {
+		req["system"] = strings.Join(system, "\n\n")
+	}
+}
+
+// mergeStops appends the global stop sequences the client didn't already send.
+func (s *Server) mergeStops(stops []string) []string {
+	seen := make(map[string]bool, len(stops))
+	for _, st := range stops {
+		seen[st] = true
+	}
+	for _, st := range s.config.GlobalStopSequences {
+		if !seen[st] {
+			stops = append(stops, st)
+			seen[st] = true
+		}
+	}
+	return stops
+}
+
+// stopList normalizes a stop value (string or array) to a string slice.
+func stopList(v interface{}) []string {
+	switch st := v.(type) {
+	case string:
+		if st != "" {
+			return []string{st}
+		}
+	case []interface{}:
+		out := make([]string, 0, len(st))
+		for _, item := range st {
+			if str, ok := item.(string); ok && str != "" {
+				out = append(out, str)
+			}
+		}
+		return out
+	case []string:
+		return append([]string(nil), st...)
+	}
+	return nil
+}
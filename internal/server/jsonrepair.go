@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// headerJSONRepaired reports a JSON_REPAIR pass on the response: "fix",
+// "reprompt", or "failed" when the output is still not valid JSON.
+const headerJSONRepaired = "X-JSON-Repaired"
+
+// correctivePrompt asks the model to fix its own output (JSON_REPAIR=reprompt).
+const correctivePrompt = "Your previous reply was not valid JSON. Reply again with only the corrected JSON, no explanation and no code fences."
+
+// wantsJSON reports whether an Ollama request asked for JSON output
+// ("format": "json" or a JSON schema).
+func wantsJSON(req map[string]interface{}) bool {
+	switch f := req["format"].(type) {
+	case string:
+		return f == "json"
+	case map[string]interface{}:
+		return true
+	}
+	return false
+}
+
+// repairJSON fixes the usual ways model output misses being JSON: code
+// fences or prose around it, trailing commas, and brackets or a string left
+// open when generation stopped early. The result may still be invalid.
+func repairJSON(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "```") {
+		if nl := strings.IndexByte(s, '\n'); nl >= 0 {
+			s = s[nl+1:]
+		}
+		s = strings.TrimSuffix(strings.TrimSpace(s), "```")
+	}
+	start := strings.IndexAny(s, "{[")
+	if start < 0 {
+		return s
+	}
+
+	var out strings.Builder
+	var stack []byte
+	inString, escaped := false, false
+	trimComma := func() {
+		str := strings.TrimRight(out.String(), " \t\r\n")
+		str = strings.TrimSuffix(str, ",")
+		out.Reset()
+		out.WriteString(str)
+	}
+scan:
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			out.WriteByte(c)
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			stack = append(stack, '}')
+		case '[':
+			stack = append(stack, ']')
+		case '}', ']':
+			if len(stack) == 0 || stack[len(stack)-1] != c {
+				continue // stray closer
+			}
+			trimComma()
+			out.WriteByte(c)
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				break scan // ignore anything after the value
+			}
+			continue
+		}
+		out.WriteByte(c)
+	}
+
+	if inString {
+		if escaped {
+			out.WriteByte('\\')
+		}
+		out.WriteByte('"')
+	}
+	if len(stack) > 0 {
+		trimComma()
+		if str := out.String(); strings.HasSuffix(str, ":") {
+			out.WriteString("null")
+		}
+		for i := len(stack) - 1; i >= 0; i-- {
+			out.WriteByte(stack[i])
+		}
+	}
+	return out.String()
+}
+
+// repairJSONOutput applies JSON_REPAIR to a buffered, non-streaming Ollama
+// chat or generate response whose request asked for JSON: invalid output is
+// repaired locally, and with "reprompt" sent back to the model once for
+// correction when that is not enough. resp.Body is replaced when the output
+// changed; the outcome is reported in X-JSON-Repaired.
+func (s *Server) repairJSONOutput(w http.ResponseWriter, r *http.Request, path string, req map[string]interface{}, headers map[string]string, resp *http.Response) {
+	mode := strings.ToLower(s.config.JSONRepair)
+	if mode == "" || mode == "off" || !wantsJSON(req) {
+		return
+	}
+	raw, err := io.ReadAll(resp.Body)
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+	if err != nil {
+		return
+	}
+	var out map[string]interface{}
+	if json.Unmarshal(raw, &out) != nil {
+		return
+	}
+	if msg, _ := out["message"].(map[string]interface{}); msg["tool_calls"] != nil {
+		return // the model called a tool instead of answering
+	}
+	text := outputText(out, path)
+	if json.Valid([]byte(text)) {
+		return
+	}
+
+	how := "fix"
+	fixed := repairJSON(text)
+	if !json.Valid([]byte(fixed)) && mode == "reprompt" {
+		how = "reprompt"
+		fixed = s.repromptJSON(r, path, req, headers, text)
+	}
+	if !json.Valid([]byte(fixed)) {
+		log.Printf("!!! %s: model output is not valid JSON and could not be repaired !!!", path)
+		w.Header().Set(headerJSONRepaired, "failed")
+		return
+	}
+	setOutputText(out, path, fixed)
+	body, err := json.Marshal(out)
+	if err != nil {
+		return
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	w.Header().Set(headerJSONRepaired, how)
+	log.Printf(">>> %s: repaired invalid JSON output (%s) <<<", path, how)
+}
+
+// repromptJSON asks the model once to correct its invalid JSON output and
+// returns the (locally repaired) answer, or "" when the call failed.
+func (s *Server) repromptJSON(r *http.Request, path string, req map[string]interface{}, headers map[string]string, text string) string {
+	retry := make(map[string]interface{}, len(req))
+	for k, v := range req {
+		retry[k] = v
+	}
+	retry["stream"] = false
+	if path == "/api/chat" {
+		msgs := append([]interface{}{}, messagesOf(req["messages"])...)
+		retry["messages"] = append(msgs,
+			map[string]interface{}{"role": "assistant", "content": text},
+			map[string]interface{}{"role": "user", "content": correctivePrompt})
+	} else {
+		prompt, _ := req["prompt"].(string)
+		retry["prompt"] = prompt + "\n\nYour previous reply:\n" + text + "\n\n" + correctivePrompt
+	}
+	body, _ := json.Marshal(retry)
+	resp, err := s.upstreamFor(r, "chat", req).ProxyRequest("POST", path, bytes.NewReader(body), headers)
+	if err != nil {
+		log.Printf("!!! %s: JSON repair re-prompt failed: %v !!!", path, err)
+		return ""
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(tapUsage(r, resp.Body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		log.Printf("!!! %s: JSON repair re-prompt returned %d !!!", path, resp.StatusCode)
+		return ""
+	}
+	var out map[string]interface{}
+	if json.Unmarshal(raw, &out) != nil {
+		return ""
+	}
+	return repairJSON(outputText(out, path))
+}
+
+// outputText returns the generated text of an Ollama chat or generate response.
+func outputText(out map[string]interface{}, path string) string {
+	if path == "/api/chat" {
+		msg, _ := out["message"].(map[string]interface{})
+		text, _ := msg["content"].(string)
+		return text
+	}
+	text, _ := out["response"].(string)
+	return text
+}
+
+func setOutputText(out map[string]interface{}, path, text string) {
+	if path == "/api/chat" {
+		if msg, ok := out["message"].(map[string]interface{}); ok {
+			msg["content"] = text
+		}
+		return
+	}
+	out["response"] = text
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"log"
+
+	"olares-ollama/internal/config"
+	"olares-ollama/internal/events"
+)
+
+// openEventsLog opens EVENTS_LOG. A file that can't be opened disables the
+// audit trail rather than the server.
+func openEventsLog(cfg *config.Config) *events.Log {
+	if cfg.EventsLog == "" || cfg.EventsLog == "off" {
+		return nil
+	}
+	l, err := events.Open(cfg.EventsLog, int64(cfg.EventsLogMaxMB)<<20, cfg.EventsLogKeep)
+	if err != nil {
+		log.Printf("!!! Events log disabled: %v !!!", err)
+		return nil
+	}
+	return l
+}
+
+// Events returns the lifecycle events log (nil, which records nothing, when
+// EVENTS_LOG is off), for main to record startup and shutdown.
+func (s *Server) Events() *events.Log {
+	return s.events
+}
+
+// recordPullEvent is the progress manager's OnEvent hook.
+func (s *Server) recordPullEvent(event, modelName, detail string) {
+	fields := map[string]interface{}{"model": modelName}
+	if detail != "" {
+		fields["error"] = detail
+	}
+	s.events.Record(event, fields)
+}
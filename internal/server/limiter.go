@@ -0,0 +1,289 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fastLaneMarkers are phrases chat UIs put in their auto-generated
+// "title / tags / summary" prompts (OpenWebUI, LobeChat, LibreChat, ...).
+var fastLaneMarkers = []string{
+	"generate a concise",
+	"word title",
+	"title for the",
+	"title for this",
+	"summarize the conversation",
+	"summary of the conversation",
+}
+
+// fastLaneTaskMarkers only count under a "### Task:" header, as in
+// OpenWebUI's task templates; ordinary prompts mention them too.
+var fastLaneTaskMarkers = []string{
+	"chat history",
+}
+
+// limiter caps concurrent inference requests. Requests classified as "fast"
+// (title/summary generation) may additionally use a small set of reserved
+// slots, so they don't queue behind long generations.
+type limiter struct {
+	main chan struct{} // nil = unlimited
+	fast chan struct{} // nil = no reserved fast slots
+
+	waiting atomic.Int64 // requests blocked in acquire
+	mu      sync.Mutex
+	avgHold time.Duration // moving average of how long a slot is held
+}
+
+func newLimiter(mainSlots, fastSlots int) *limiter {
+	l := &limiter{}
+	if mainSlots > 0 {
+		l.main = make(chan struct{}, mainSlots)
+		if fastSlots > 0 {
+			l.fast = make(chan struct{}, fastSlots)
+		}
+	}
+	return l
+}
+
+// acquire blocks until a slot is free (or ctx is done) and returns its release func.
+// Fast requests take whichever of a fast or main slot frees up first.
+func (l *limiter) acquire(ctx context.Context, fast bool) (func(), error) {
+	if l.main == nil {
+		return func() {}, nil
+	}
+	slot, err := l.take(ctx, fast)
+	if err != nil {
+		return nil, err
+	}
+	return l.hold(slot), nil
+}
+
+// tryAcquire takes a main slot if one is free right now; it never queues.
+func (l *limiter) tryAcquire() (func(), bool) {
+	if l.main == nil {
+		return func() {}, true
+	}
+	select {
+	case l.main <- struct{}{}:
+		return l.hold(l.main), true
+	default:
+		return nil, false
+	}
+}
+
+// hold returns the release func of a slot just taken from slot.
+func (l *limiter) hold(slot chan struct{}) func() {
+	start := time.Now()
+	return func() {
+		l.mu.Lock()
+		if held := time.Since(start); l.avgHold == 0 {
+			l.avgHold = held
+		} else {
+			l.avgHold = (4*l.avgHold + held) / 5
+		}
+		l.mu.Unlock()
+		<-slot
+	}
+}
+
+// take returns the channel of the slot it filled, waiting if none is free.
+func (l *limiter) take(ctx context.Context, fast bool) (chan struct{}, error) {
+	fastLane := l.fast // nil (never ready) without reserved slots
+	if !fast {
+		fastLane = nil
+	}
+	select {
+	case fastLane <- struct{}{}:
+		return fastLane, nil
+	case l.main <- struct{}{}:
+		return l.main, nil
+	default:
+	}
+	l.waiting.Add(1)
+	defer l.waiting.Add(-1)
+	select {
+	case fastLane <- struct{}{}:
+		return fastLane, nil
+	case l.main <- struct{}{}:
+		return l.main, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// queue returns how many requests wait for a slot and roughly how long a
+// request arriving now would wait: its place in the queue, spread over the
+// main slots, times the average time a slot is held.
+func (l *limiter) queue() (depth int, wait time.Duration) {
+	if l.main == nil {
+		return 0, 0
+	}
+	depth = int(l.waiting.Load())
+	if depth == 0 && len(l.main) < cap(l.main) {
+		return 0, 0
+	}
+	l.mu.Lock()
+	avg := l.avgHold
+	l.mu.Unlock()
+	return depth, time.Duration(depth+1) * avg / time.Duration(cap(l.main))
+}
+
+// setQueueHeaders reports the queue in X-Queue-Depth and X-Queue-Wait-Ms.
+func setQueueHeaders(w http.ResponseWriter, depth int, wait time.Duration) {
+	w.Header().Set("X-Queue-Depth", strconv.Itoa(depth))
+	w.Header().Set("X-Queue-Wait-Ms", strconv.FormatInt(wait.Milliseconds(), 10))
+}
+
+// isFastLane reports whether a request looks like a tiny UI helper task:
+// a small max_tokens, or a short prompt containing a title/summary marker.
+func (s *Server) isFastLane(maxTokens int, prompt string) bool {
+	if s.config.FastLaneMaxTokens <= 0 {
+		return false
+	}
+	if maxTokens > 0 && maxTokens <= s.config.FastLaneMaxTokens {
+		return true
+	}
+	if len(prompt) > s.config.FastLaneMaxPromptChars {
+		return false
+	}
+	lower := strings.ToLower(prompt)
+	for _, m := range fastLaneMarkers {
+		if strings.Contains(lower, m) {
+			return true
+		}
+	}
+	if strings.Contains(lower, "### task:") {
+		for _, m := range fastLaneTaskMarkers {
+			if strings.Contains(lower, m) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// admitInference sheds low-priority requests on an overloaded host,
+// classifies an Ollama-format request (messages or prompt), swaps in
+// FAST_LANE_MODEL for fast requests and waits for a limiter slot (and
+// the thermal governor). maxTokens is the client's output cap (0 = unknown). ok=false means the
+// request was rejected (shed, queue full) or the client went away while
+// queued, and nothing more should be written.
+func (s *Server) admitInference(w http.ResponseWriter, r *http.Request, req map[string]interface{}, maxTokens int) (release func(), ok bool) {
+	if s.shedLoad(w, r) {
+		return nil, false
+	}
+	prompt, _ := req["prompt"].(string)
+	if msgs := messagesOf(req["messages"]); len(msgs) > 0 {
+		if last, ok := msgs[len(msgs)-1].(map[string]interface{}); ok {
+			prompt = flattenContent(last["content"])
+		}
+	}
+	if maxTokens == 0 {
+		if options, ok := req["options"].(map[string]interface{}); ok {
+			maxTokens = intParam(options["num_predict"])
+		}
+	}
+
+	fast := s.isFastLane(maxTokens, prompt)
+	if fast {
+		w.Header().Set("X-Proxy-Lane", "fast")
+		if meta := metaFrom(r); s.config.FastLaneModel != "" && (meta == nil || !meta.userRouted && meta.routingRule == "") {
+			req["model"] = s.config.FastLaneModel
+		}
+		log.Printf(">>> %s routed to fast lane (max_tokens=%d, prompt=%d chars, model=%v) <<<",
+			r.URL.Path, maxTokens, len(prompt), req["model"])
+	}
+
+	if depth, wait := s.limiter.queue(); depth > 0 || wait > 0 {
+		if s.config.MaxQueueDepth > 0 && depth >= s.config.MaxQueueDepth {
+			s.rejectQueueFull(w, r, depth, wait)
+			return nil, false
+		}
+		if s.config.QueueHintDepth > 0 && depth >= s.config.QueueHintDepth {
+			setQueueHeaders(w, depth, wait)
+			traceDecision(r, "queue", "%d waiting, ~%s", depth, wait.Round(time.Millisecond))
+		}
+	}
+
+	queuedAt := time.Now()
+	slot, err := s.limiter.acquire(r.Context(), fast)
+	var cooled func()
+	if err == nil {
+		if cooled, ok = s.admitThermal(r); !ok {
+			slot()
+		}
+	}
+	if meta := metaFrom(r); meta != nil {
+		meta.update(func(m *requestMeta) {
+			m.queued += time.Since(queuedAt)
+			if model, _ := req["model"].(string); model != "" {
+				m.servedModel = model
+			}
+		})
+	}
+	if err != nil {
+		log.Printf("!!! %s: client gave up while waiting for a slot: %v !!!", r.URL.Path, err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	return func() { cooled(); slot() }, true
+}
+
+// rejectQueueFull answers 429 when MAX_QUEUE_DEPTH requests already wait,
+// telling the client how long to back off.
+func (s *Server) rejectQueueFull(w http.ResponseWriter, r *http.Request, depth int, wait time.Duration) {
+	retry := int(math.Ceil(wait.Seconds()))
+	if retry < 1 {
+		retry = 1
+	}
+	setQueueHeaders(w, depth, wait)
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	log.Printf("!!! %s rejected: %d requests already waiting for a slot (MAX_QUEUE_DEPTH) !!!", r.URL.Path, depth)
+	writeUpstreamError(w, errorFormatForPath(r.URL.Path), &upstreamError{
+		Status:  http.StatusTooManyRequests,
+		Code:    "queue_full",
+		Message: fmt.Sprintf("%d requests are already waiting for Ollama; estimated wait %s", depth, wait.Round(time.Second)),
+		Hint:    "Back off for Retry-After seconds, or raise MAX_QUEUE_DEPTH / MAX_CONCURRENT_REQUESTS.",
+		Details: map[string]interface{}{"queue_depth": depth, "estimated_wait_ms": wait.Milliseconds()},
+	})
+}
+
+// messagesOf returns v as a message list, accepting both decoded JSON
+// ([]interface{}) and locally built ([]map[string]interface{}) slices.
+func messagesOf(v interface{}) []interface{} {
+	switch m := v.(type) {
+	case []interface{}:
+		return m
+	case []map[string]interface{}:
+		out := make([]interface{}, len(m))
+		for i := range m {
+			out[i] = m[i]
+		}
+		return out
+	}
+	return nil
+}
+
+// intParam converts a JSON number (or numeric string) to int; 0 if absent/invalid.
+func intParam(v interface{}) int {
+	switch n := v.(type) {
+	case float64:
+		return int(n)
+	case int:
+		return n
+	case string:
+		i, _ := strconv.Atoi(n)
+		return i
+	}
+	return 0
+}
@@ -0,0 +1,448 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"olares-ollama/internal/config"
+	"olares-ollama/internal/ollama"
+)
+
+const (
+	maintenanceTick          = time.Minute      // how often the window is checked
+	maintenanceModelsTimeout = 30 * time.Second // Ollama model list and delete calls
+	registryTimeout          = 30 * time.Second // one registry manifest request
+)
+
+// maintenanceTaskNames are the MAINTENANCE_TASKS, in the order they run.
+var maintenanceTaskNames = []string{"compact_usage", "rotate_logs", "update_check", "gc_models"}
+
+// maintenanceTask is the outcome of one task of a run.
+type maintenanceTask struct {
+	Name       string      `json:"name"`
+	Status     string      `json:"status"` // "ok", "failed" or "skipped"
+	Summary    string      `json:"summary,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	DurationMs int64       `json:"duration_ms"`
+	Details    interface{} `json:"details,omitempty"`
+}
+
+// maintenanceRun is the report of one run, nightly or from the admin API.
+type maintenanceRun struct {
+	Trigger    string            `json:"trigger"` // "window" or "admin"
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at,omitzero"`
+	Tasks      []maintenanceTask `json:"tasks"`
+}
+
+// modelCheck is the update_check and gc_models verdict on one model.
+type modelCheck struct {
+	Model  string `json:"model"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// maintenanceState holds the last run, persisted to data/maintenance.json
+// so the report and the once-a-night rule survive restarts.
+type maintenanceState struct {
+	mu            sync.Mutex
+	last          *maintenanceRun
+	lastWindowRun time.Time
+	running       *maintenanceRun
+	deferred      string // why the window's run is waiting
+	file          string
+}
+
+// maintenanceRecord is the content of data/maintenance.json.
+type maintenanceRecord struct {
+	Last          *maintenanceRun `json:"last,omitempty"`
+	LastWindowRun time.Time       `json:"last_window_run,omitzero"`
+}
+
+func newMaintenanceState() *maintenanceState {
+	st := &maintenanceState{file: filepath.Join("data", "maintenance.json")}
+	if data, err := os.ReadFile(st.file); err == nil {
+		var rec maintenanceRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			log.Printf("Warning: failed to parse %s: %v", st.file, err)
+		}
+		st.last, st.lastWindowRun = rec.Last, rec.LastWindowRun
+	} else if !os.IsNotExist(err) {
+		log.Printf("Warning: failed to read %s: %v", st.file, err)
+	}
+	return st
+}
+
+func (st *maintenanceState) saveLocked() error {
+	data, err := json.MarshalIndent(maintenanceRecord{Last: st.last, LastWindowRun: st.lastWindowRun}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(st.file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(st.file, data, 0644)
+}
+
+// windowStart returns when the MAINTENANCE_WINDOW now is in opened.
+func windowStart(window string, now time.Time) time.Time {
+	start, end, _ := config.ParseWindow(window)
+	y, m, d := now.Date()
+	opened := time.Date(y, m, d, start/60, start%60, 0, 0, now.Location())
+	if end < start && now.Hour()*60+now.Minute() < end {
+		opened = opened.AddDate(0, 0, -1) // opened yesterday, before midnight
+	}
+	return opened
+}
+
+// runMaintenance runs the maintenance tasks once per MAINTENANCE_WINDOW, as
+// soon as no inference request is in progress in it.
+func (s *Server) runMaintenance() {
+	ticker := time.NewTicker(maintenanceTick)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		st := s.maintenance
+		if !inWindow(s.config.MaintenanceWindow, now) {
+			continue
+		}
+		st.mu.Lock()
+		due := st.running == nil && st.lastWindowRun.Before(windowStart(s.config.MaintenanceWindow, now))
+		if due {
+			if n := s.inflight.Load(); n > 0 {
+				st.deferred = fmt.Sprintf("waiting for idle (%d requests in progress)", n)
+				due = false
+			}
+		}
+		st.mu.Unlock()
+		if due {
+			s.maintain("window")
+		}
+	}
+}
+
+// maintain runs the enabled tasks one after the other and records the
+// report. It returns false when a run is already in progress.
+func (s *Server) maintain(trigger string) bool {
+	st := s.maintenance
+	run := &maintenanceRun{Trigger: trigger, StartedAt: time.Now(), Tasks: []maintenanceTask{}}
+	st.mu.Lock()
+	if st.running != nil {
+		st.mu.Unlock()
+		return false
+	}
+	st.running, st.deferred = run, ""
+	if trigger == "window" {
+		st.lastWindowRun = run.StartedAt
+	}
+	st.mu.Unlock()
+
+	log.Printf("Maintenance: starting (%s)", trigger)
+	for _, name := range maintenanceTaskNames {
+		if len(s.config.MaintenanceTasks) > 0 && !slices.Contains(s.config.MaintenanceTasks, name) {
+			continue
+		}
+		start := time.Now()
+		task := s.maintenanceTask(name)
+		task.Name, task.DurationMs = name, time.Since(start).Milliseconds()
+		if task.Status == "failed" {
+			log.Printf("!!! Maintenance: %s failed: %s !!!", name, task.Error)
+		} else {
+			log.Printf("Maintenance: %s %s: %s", name, task.Status, task.Summary)
+		}
+		st.mu.Lock()
+		run.Tasks = append(run.Tasks, task)
+		st.mu.Unlock()
+	}
+
+	st.mu.Lock()
+	run.FinishedAt = time.Now()
+	st.running, st.last = nil, run
+	if err := st.saveLocked(); err != nil {
+		log.Printf("!!! Failed to save %s: %v !!!", st.file, err)
+	}
+	st.mu.Unlock()
+	failed := 0
+	for _, t := range run.Tasks {
+		if t.Status == "failed" {
+			failed++
+		}
+	}
+	log.Printf("Maintenance: finished in %s (%d of %d tasks failed)", run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond), failed, len(run.Tasks))
+	s.events.Record("maintenance_run", map[string]interface{}{
+		"trigger": trigger, "tasks": len(run.Tasks), "failed": failed,
+		"duration_ms": run.FinishedAt.Sub(run.StartedAt).Milliseconds(),
+	})
+	return true
+}
+
+func (s *Server) maintenanceTask(name string) maintenanceTask {
+	switch name {
+	case "compact_usage":
+		before, after, err := s.usage.compact()
+		if err != nil {
+			return maintenanceTask{Status: "failed", Error: err.Error()}
+		}
+		return maintenanceTask{Status: "ok", Summary: fmt.Sprintf("%d usage buckets compacted to %d", before, after)}
+	case "rotate_logs":
+		if s.events == nil {
+			return maintenanceTask{Status: "skipped", Summary: "EVENTS_LOG is off"}
+		}
+		size, err := s.events.Rotate()
+		switch {
+		case err != nil:
+			return maintenanceTask{Status: "failed", Error: err.Error()}
+		case size == 0:
+			return maintenanceTask{Status: "ok", Summary: "the events log is empty"}
+		}
+		return maintenanceTask{Status: "ok", Summary: fmt.Sprintf("rotated %s (%.1f KB)", s.config.EventsLog, float64(size)/1024)}
+	case "update_check":
+		return s.checkModelUpdates()
+	case "gc_models":
+		return s.collectUnusedModels()
+	}
+	return maintenanceTask{Status: "skipped", Summary: "unknown task"}
+}
+
+// registryClient talks to model registries, through OUTBOUND_PROXY if set.
+func (s *Server) registryClient() *http.Client {
+	registry := &http.Client{Timeout: registryTimeout}
+	if s.config.OutboundProxy != "" {
+		if proxyURL, err := url.Parse(s.config.OutboundProxy); err == nil { // checked at startup
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.Proxy = http.ProxyURL(proxyURL)
+			registry.Transport = transport
+		}
+	}
+	return registry
+}
+
+// checkModelUpdates compares each model's manifest in Ollama's model
+// directory (OLLAMA_MODELS_DIR) with the one in its registry, and pulls the
+// changed models with MAINTENANCE_PULL_UPDATES. Without the directory there
+// is nothing to compare with: the models are re-pulled (which only fetches
+// changed layers) with MAINTENANCE_PULL_UPDATES, else the task is skipped.
+func (s *Server) checkModelUpdates() maintenanceTask {
+	if s.modelStore == nil && !s.config.MaintenancePullUpdates {
+		return maintenanceTask{Status: "skipped", Summary: "set OLLAMA_MODELS_DIR to compare models with their registry, or MAINTENANCE_PULL_UPDATES to re-pull them"}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), maintenanceModelsTimeout)
+	models, err := s.ollamaClient.ListModels(ctx)
+	cancel()
+	if err != nil {
+		return maintenanceTask{Status: "failed", Error: "listing models: " + err.Error()}
+	}
+	registry := s.registryClient()
+
+	checks := []modelCheck{}
+	counts := map[string]int{}
+	for _, m := range models {
+		check := modelCheck{Model: m.Name, Status: "current"}
+		remote, err := ollama.RegistryManifest(context.Background(), registry, m.Name, s.config.PullInsecure)
+		switch {
+		case errors.Is(err, ollama.ErrNotInRegistry):
+			check.Status = "not_in_registry"
+		case err != nil:
+			check.Status, check.Reason = "error", err.Error()
+		case s.modelStore != nil:
+			local, _, err := s.modelStore.Manifest(m.Name)
+			if err != nil {
+				check.Status, check.Reason = "error", err.Error()
+			} else if !local.SameBlobs(remote) {
+				check.Status = "update_available"
+			}
+		default:
+			check.Status = "unknown" // re-pulled below
+		}
+		if s.config.MaintenancePullUpdates && (check.Status == "update_available" || check.Status == "unknown") {
+			start := time.Now()
+			if err := s.ollamaClient.PullModelWithProgress(m.Name, quietProgress{}); err != nil {
+				check.Status, check.Reason = "error", "pull: "+err.Error()
+			} else {
+				check.Status = "pulled"
+				s.events.Record("model_updated", map[string]interface{}{"model": m.Name, "duration_ms": time.Since(start).Milliseconds()})
+			}
+		}
+		counts[check.Status]++
+		checks = append(checks, check)
+	}
+	task := maintenanceTask{Status: "ok", Details: checks, Summary: summarizeCounts(len(checks), "models", counts)}
+	if counts["error"] > 0 && counts["error"] == len(checks) {
+		task.Status, task.Error = "failed", "no model could be checked"
+	}
+	return task
+}
+
+// collectUnusedModels removes the models installed and unused for
+// MAINTENANCE_GC_UNUSED_DAYS: no request counted in the usage store over
+// that time, installed longer ago, and not otherwise in use (served,
+// fast-lane, hot, compare and routing-rule models, user routes, pins,
+// placements and loaded models are kept).
+func (s *Server) collectUnusedModels() maintenanceTask {
+	days := s.config.MaintenanceGCUnusedDays
+	if days == 0 {
+		return maintenanceTask{Status: "skipped", Summary: "set MAINTENANCE_GC_UNUSED_DAYS to remove unused models"}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), maintenanceModelsTimeout)
+	models, err := s.ollamaClient.ListModels(ctx)
+	if err != nil {
+		cancel()
+		return maintenanceTask{Status: "failed", Error: "listing models: " + err.Error()}
+	}
+	running, err := s.ollamaClient.RunningModels(ctx)
+	cancel()
+	if err != nil {
+		return maintenanceTask{Status: "failed", Error: "listing loaded models: " + err.Error()}
+	}
+	keep := s.modelsInUse()
+	for _, m := range running {
+		keep = append(keep, m.Name)
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+	lastUsed := s.usage.lastUsed()
+
+	checks := []modelCheck{}
+	counts := map[string]int{}
+	for _, m := range models {
+		check := modelCheck{Model: m.Name, Status: "kept"}
+		used := time.Time{}
+		for name, t := range lastUsed {
+			if matchesModel(name, m.Name) && t.After(used) {
+				used = t
+			}
+		}
+		switch {
+		case containsModel(keep, m.Name):
+			check.Reason = "in use by the configuration"
+		case used.After(cutoff):
+			check.Reason = "used " + used.Format(time.RFC3339)
+		case m.ModifiedAt.After(cutoff):
+			check.Reason = "installed " + m.ModifiedAt.Format(time.RFC3339)
+		default:
+			ctx, cancel := context.WithTimeout(context.Background(), maintenanceModelsTimeout)
+			err := s.ollamaClient.DeleteModel(ctx, m.Name)
+			cancel()
+			if err != nil {
+				check.Status, check.Reason = "error", err.Error()
+				break
+			}
+			check.Status = "removed"
+			check.Reason = fmt.Sprintf("unused for %d days, freed %.1f GB", days, float64(m.Size)/(1<<30))
+			log.Printf("WARNING: maintenance removed %s, unused for %d days", m.Name, days)
+			s.events.Record("model_removed", map[string]interface{}{"model": m.Name, "reason": "unused", "size": m.Size})
+		}
+		counts[check.Status]++
+		checks = append(checks, check)
+	}
+	return maintenanceTask{Status: "ok", Details: checks, Summary: summarizeCounts(len(checks), "models", counts)}
+}
+
+// modelsInUse lists the models the configuration and the admin API refer
+// to, which gc_models never removes.
+func (s *Server) modelsInUse() []string {
+	models := []string{s.model(), s.config.Model, s.config.FastLaneModel}
+	models = append(models, s.config.HotModels...)
+	models = append(models, s.config.CompareModels...)
+	for _, rule := range s.routingRules {
+		models = append(models, rule.Model)
+	}
+	for _, rt := range s.userRoutes.list() {
+		models = append(models, rt.Model)
+	}
+	for _, p := range s.pins.list() {
+		models = append(models, p.Model)
+	}
+	s.placements.mu.RLock()
+	for m := range s.placements.byModel {
+		models = append(models, m)
+	}
+	s.placements.mu.RUnlock()
+	return slices.DeleteFunc(models, func(m string) bool { return m == "" })
+}
+
+// summarizeCounts renders e.g. "3 models: 2 current, 1 update_available".
+func summarizeCounts(total int, noun string, counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%d %s", counts[k], k)
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("no %s", noun)
+	}
+	return fmt.Sprintf("%d %s: %s", total, noun, strings.Join(parts, ", "))
+}
+
+// handleMaintenanceGet handles GET /admin/maintenance: the window, the
+// tasks, the next run and the report of the last one (and of the one in
+// progress).
+func (s *Server) handleMaintenanceGet(w http.ResponseWriter, r *http.Request) {
+	st := s.maintenance
+	tasks := s.config.MaintenanceTasks
+	if len(tasks) == 0 {
+		tasks = maintenanceTaskNames
+	}
+	out := map[string]interface{}{
+		"window":             s.config.MaintenanceWindow,
+		"tasks":              tasks,
+		"pull_updates":       s.config.MaintenancePullUpdates,
+		"gc_unused_days":     s.config.MaintenanceGCUnusedDays,
+		"models_dir_present": s.modelStore != nil,
+	}
+	st.mu.Lock()
+	if st.last != nil {
+		out["last_run"] = st.last
+	}
+	if st.running != nil {
+		running := *st.running
+		running.Tasks = slices.Clone(running.Tasks) // appended to as tasks finish
+		out["running"] = running
+	}
+	if st.deferred != "" {
+		out["deferred"] = st.deferred
+	}
+	if s.config.MaintenanceWindow != "" {
+		now := time.Now()
+		opened := windowStart(s.config.MaintenanceWindow, now)
+		switch {
+		case opened.After(now):
+			out["next_run"] = opened
+		case inWindow(s.config.MaintenanceWindow, now) && st.lastWindowRun.Before(opened):
+			out["next_run"] = now // due, waiting for the next check or for idle
+		default:
+			out["next_run"] = opened.AddDate(0, 0, 1)
+		}
+	}
+	st.mu.Unlock()
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleMaintenanceRun handles POST /admin/maintenance/run: runs the tasks
+// now, in the background (202; the report is at GET /admin/maintenance).
+func (s *Server) handleMaintenanceRun(w http.ResponseWriter, r *http.Request) {
+	st := s.maintenance
+	st.mu.Lock()
+	busy := st.running != nil
+	st.mu.Unlock()
+	if busy {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "maintenance_running", "A maintenance run is in progress; see GET /admin/maintenance")
+		return
+	}
+	go s.maintain("admin")
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "running"})
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// promWriter builds a response in the Prometheus text exposition format.
+type promWriter struct {
+	b strings.Builder
+}
+
+func (p *promWriter) family(name, typ, help string) {
+	fmt.Fprintf(&p.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one value; labels are name/value pairs.
+func (p *promWriter) sample(name string, value float64, labels ...string) {
+	p.b.WriteString(name)
+	if len(labels) > 0 {
+		p.b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				p.b.WriteByte(',')
+			}
+			fmt.Fprintf(&p.b, "%s=\"%s\"", labels[i], promEscaper.Replace(labels[i+1]))
+		}
+		p.b.WriteByte('}')
+	}
+	p.b.WriteByte(' ')
+	p.b.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	p.b.WriteByte('\n')
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promBool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// handleMetrics serves GET /metrics for Prometheus. It exports the model
+// downloads the proxy runs, so monitoring can alert on a stalled or failing
+// pull without scraping /api/progress: e.g. active == 1 while
+// time() - last_progress_timestamp_seconds grows.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	pulls := s.progressManager.PullMetrics()
+	var p promWriter
+
+	p.family("olares_ollama_pull_completed_bytes", "gauge", "Bytes downloaded in the current or last pull of the model (of the current layer for Ollama pulls).")
+	for _, m := range pulls {
+		p.sample("olares_ollama_pull_completed_bytes", float64(m.Completed), "model", m.Model)
+	}
+	p.family("olares_ollama_pull_size_bytes", "gauge", "Bytes to download, as reported by the source; 0 until known.")
+	for _, m := range pulls {
+		p.sample("olares_ollama_pull_size_bytes", float64(m.Total), "model", m.Model)
+	}
+	p.family("olares_ollama_pull_speed_bytes_per_second", "gauge", "Current download speed; 0 when no bytes are being transferred.")
+	for _, m := range pulls {
+		p.sample("olares_ollama_pull_speed_bytes_per_second", m.SpeedBps, "model", m.Model)
+	}
+	p.family("olares_ollama_pull_active", "gauge", "1 while the model's bytes are being transferred.")
+	for _, m := range pulls {
+		p.sample("olares_ollama_pull_active", promBool(m.Active), "model", m.Model)
+	}
+	p.family("olares_ollama_pull_failed", "gauge", "1 while the model's last pull attempt ended in an error.")
+	for _, m := range pulls {
+		p.sample("olares_ollama_pull_failed", promBool(m.Status == "error"), "model", m.Model)
+	}
+	p.family("olares_ollama_pull_last_progress_timestamp_seconds", "gauge", "Unix time the downloaded byte count last grew; 0 if it never did.")
+	for _, m := range pulls {
+		ts := 0.0
+		if !m.LastProgress.IsZero() {
+			ts = float64(m.LastProgress.Unix())
+		}
+		p.sample("olares_ollama_pull_last_progress_timestamp_seconds", ts, "model", m.Model)
+	}
+	p.family("olares_ollama_pull_retries_total", "counter", "Pull attempts started again after a failure or a network error.")
+	for _, m := range pulls {
+		p.sample("olares_ollama_pull_retries_total", float64(m.Retries), "model", m.Model)
+	}
+	p.family("olares_ollama_pull_failures_total", "counter", "Pull attempts that ended in an error.")
+	for _, m := range pulls {
+		p.sample("olares_ollama_pull_failures_total", float64(m.Failures), "model", m.Model)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(p.b.String()))
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"olares-ollama/internal/ollama"
+)
+
+// modelfileParameters are the PARAMETER names Ollama accepts in a Modelfile.
+var modelfileParameters = map[string]bool{
+	"num_ctx": true, "num_predict": true, "num_keep": true, "num_batch": true, "num_gpu": true, "num_thread": true,
+	"temperature": true, "top_k": true, "top_p": true, "min_p": true, "typical_p": true, "seed": true,
+	"repeat_penalty": true, "repeat_last_n": true, "presence_penalty": true, "frequency_penalty": true,
+	"mirostat": true, "mirostat_eta": true, "mirostat_tau": true, "penalize_newline": true, "use_mmap": true,
+	"stop": true,
+}
+
+// modelSpec is the body of POST /admin/models/create: a custom assistant on
+// top of a model that is already in Ollama.
+type modelSpec struct {
+	Name       string                 `json:"name"`
+	From       string                 `json:"from"`
+	System     string                 `json:"system,omitempty"`
+	Template   string                 `json:"template,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Messages   []modelMessage         `json:"messages,omitempty"` // example conversation
+	Adapters   []string               `json:"adapters,omitempty"` // LoRA files in ADAPTERS_DIR
+	Overwrite  bool                   `json:"overwrite,omitempty"`
+	DryRun     bool                   `json:"dry_run,omitempty"` // only return the Modelfile
+}
+
+type modelMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// quietProgress drops create progress: making a model from one Ollama
+// already has takes seconds and shouldn't take over the progress page.
+type quietProgress struct{}
+
+func (quietProgress) UpdateProgress(string, int64, int64, string) {}
+func (quietProgress) UpdateError(string, int64, int64, string)    {}
+
+// validate normalizes the spec (stop as a list) and returns what's wrong with it.
+func (m *modelSpec) validate() error {
+	m.Name, m.From = strings.TrimSpace(m.Name), strings.TrimSpace(m.From)
+	switch {
+	case m.Name == "" || m.From == "":
+		return fmt.Errorf("'name' and 'from' are required")
+	case matchesModel(m.Name, m.From):
+		return fmt.Errorf("'name' must differ from 'from'")
+	}
+	for key, v := range m.Parameters {
+		if !modelfileParameters[key] {
+			return fmt.Errorf("unknown parameter %q", key)
+		}
+		switch v := v.(type) {
+		case float64, bool:
+		case string:
+			if key != "stop" {
+				return fmt.Errorf("parameter %q must be a number or a boolean", key)
+			}
+			m.Parameters[key] = []interface{}{v}
+		case []interface{}:
+			if key != "stop" {
+				return fmt.Errorf("only 'stop' takes a list")
+			}
+			for _, s := range v {
+				if _, ok := s.(string); !ok {
+					return fmt.Errorf("'stop' must be a list of strings")
+				}
+			}
+		default:
+			return fmt.Errorf("parameter %q has an unsupported value", key)
+		}
+	}
+	for _, a := range m.Adapters {
+		if a == "" || filepath.Base(a) != a {
+			return fmt.Errorf("adapter %q must be a file name in ADAPTERS_DIR", a)
+		}
+	}
+	for _, msg := range m.Messages {
+		if msg.Role != "system" && msg.Role != "user" && msg.Role != "assistant" {
+			return fmt.Errorf("message role %q must be system, user or assistant", msg.Role)
+		}
+	}
+	return nil
+}
+
+// modelfile renders the spec as the Modelfile Ollama will show for the model.
+func (m *modelSpec) modelfile() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "FROM %s\n", m.From)
+	if m.System != "" {
+		fmt.Fprintf(&b, "SYSTEM %s\n", modelfileQuote(m.System))
+	}
+	if m.Template != "" {
+		fmt.Fprintf(&b, "TEMPLATE %s\n", modelfileQuote(m.Template))
+	}
+	for _, a := range m.Adapters {
+		fmt.Fprintf(&b, "ADAPTER ./%s\n", a)
+	}
+	keys := make([]string, 0, len(m.Parameters))
+	for k := range m.Parameters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch v := m.Parameters[k].(type) {
+		case []interface{}:
+			for _, s := range v {
+				fmt.Fprintf(&b, "PARAMETER %s %s\n", k, strconv.Quote(s.(string)))
+			}
+		case float64:
+			fmt.Fprintf(&b, "PARAMETER %s %s\n", k, strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			fmt.Fprintf(&b, "PARAMETER %s %v\n", k, v)
+		}
+	}
+	for _, msg := range m.Messages {
+		fmt.Fprintf(&b, "MESSAGE %s %s\n", msg.Role, modelfileQuote(msg.Content))
+	}
+	return b.String()
+}
+
+// modelfileQuote writes multi-line text in triple quotes, as Ollama does.
+func modelfileQuote(s string) string {
+	if !strings.ContainsAny(s, "\n\"") {
+		return s
+	}
+	return `"""` + s + `"""`
+}
+
+// handleModelCreate serves POST /admin/models/create: it builds a model from
+// one already in Ollama with a system prompt, template, parameters and
+// example messages, and returns the resulting Modelfile. With dry_run the
+// Modelfile is only rendered.
+func (s *Server) handleModelCreate(w http.ResponseWriter, r *http.Request) {
+	var spec modelSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+		return
+	}
+	if err := spec.validate(); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	for _, a := range spec.Adapters {
+		if _, err := s.adapterPath(a); err != nil {
+			writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_adapter", err.Error())
+			return
+		}
+	}
+	modelfile := spec.modelfile()
+	if spec.DryRun {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "dry_run", "model": spec.Name, "modelfile": modelfile})
+		return
+	}
+	var me *modelError
+	if err := s.checkModelTarget(spec.Name, spec.Overwrite); errors.As(err, &me) {
+		writeError(w, ollamaErrorFormat, me.status, me.code, me.message)
+		return
+	} else if err != nil {
+		writeUpstreamError(w, ollamaErrorFormat, upstreamErrorFromTransport(err))
+		return
+	}
+	if ok, err := s.ollamaClient.ModelExists(spec.From); err != nil {
+		writeUpstreamError(w, ollamaErrorFormat, upstreamErrorFromTransport(err))
+		return
+	} else if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "model_not_found", "Base model not found in Ollama: "+spec.From)
+		return
+	}
+
+	req := ollama.CreateRequest{
+		Model:      spec.Name,
+		From:       spec.From,
+		System:     spec.System,
+		Template:   spec.Template,
+		Parameters: spec.Parameters,
+	}
+	if len(spec.Messages) > 0 {
+		req.Messages, _ = json.Marshal(spec.Messages)
+	}
+	if len(spec.Adapters) > 0 {
+		adapters, err := s.pushAdapters(spec.Adapters, spec.Name)
+		if err != nil {
+			log.Printf("!!! Creating %s: %v !!!", spec.Name, err)
+			writeError(w, ollamaErrorFormat, http.StatusBadGateway, "create_failed", err.Error())
+			return
+		}
+		req.Adapters = adapters
+	}
+	log.Printf("Creating model %s from %s", spec.Name, spec.From)
+	if err := s.ollamaClient.CreateModel(req, quietProgress{}); err != nil {
+		log.Printf("!!! Creating %s failed: %v !!!", spec.Name, err)
+		writeError(w, ollamaErrorFormat, http.StatusBadGateway, "create_failed", err.Error())
+		return
+	}
+	s.forgetModelInfo(spec.Name)
+	log.Printf("Model %s created", spec.Name)
+	s.events.Record("model_created", map[string]interface{}{"model": spec.Name, "from": spec.From, "adapters": spec.Adapters})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "success", "model": spec.Name, "modelfile": modelfile})
+}
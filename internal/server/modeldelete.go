@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const modelDeleteTimeout = 2 * time.Minute
+
+// handleModelDelete handles POST /admin/models/{name}/delete: removes the
+// model from Ollama. A model the configuration or the admin API refers to
+// (the served model, pins, routes, placements, ...) is refused unless
+// ?force=true.
+func (s *Server) handleModelDelete(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.PathValue("name"))
+	if name == "" {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Model name is required")
+		return
+	}
+	if containsModel(s.modelsInUse(), name) && r.URL.Query().Get("force") != "true" {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "model_in_use",
+			name+" is served, pinned, routed to or placed; remove that first, or add ?force=true")
+		return
+	}
+	exists, err := s.ollamaClient.ModelExists(name)
+	if err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadGateway, "upstream_error", "Failed to list Ollama's models: "+err.Error())
+		return
+	}
+	if !exists {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "model_not_found", "Ollama has no model "+name)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), modelDeleteTimeout)
+	defer cancel()
+	if err := s.ollamaClient.DeleteModel(ctx, name); err != nil {
+		log.Printf("!!! Deleting %s failed: %v !!!", name, err)
+		writeError(w, ollamaErrorFormat, http.StatusBadGateway, "upstream_error", "Failed to delete "+name+": "+err.Error())
+		return
+	}
+	log.Printf("WARNING: model %s deleted by admin", name)
+	s.events.Record("model_removed", map[string]interface{}{"model": name, "reason": "admin"})
+	w.WriteHeader(http.StatusNoContent)
+}
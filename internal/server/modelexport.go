@@ -0,0 +1,155 @@
+package server
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"olares-ollama/internal/config"
+	"olares-ollama/internal/ollama"
+)
+
+// modelArchiveFormat versions the layout of export archives: model.json,
+// manifest.json (Ollama's manifest as is), Modelfile, and blobs/sha256-<hex>
+// for the config and every layer.
+const modelArchiveFormat = 1
+
+// openModelStore opens OLLAMA_MODELS_DIR. Without it the proxy works as
+// before; only export is unavailable.
+func openModelStore(cfg *config.Config) *ollama.ModelStore {
+	if cfg.OllamaModelsDir == "" {
+		return nil
+	}
+	st, err := ollama.NewModelStore(cfg.OllamaModelsDir)
+	if err != nil {
+		log.Printf("!!! OLLAMA_MODELS_DIR: %v; model export disabled !!!", err)
+		return nil
+	}
+	return st
+}
+
+// modelArchiveInfo is model.json in an export archive.
+type modelArchiveInfo struct {
+	Format     int       `json:"format"`
+	Name       string    `json:"name"`
+	ExportedAt time.Time `json:"exported_at"`
+	Size       int64     `json:"size"` // bytes of all blobs
+}
+
+// handleModelExport streams POST /admin/models/{name}/export: a tar of the
+// model's manifest, Modelfile and blobs, read from OLLAMA_MODELS_DIR, so a
+// tuned model can be backed up before the box is reinstalled. A name with
+// a registry or namespace is sent URL-encoded (hf.co%2Fuser%2Frepo:Q4_K_M).
+func (s *Server) handleModelExport(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if s.modelStore == nil {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "model_store_unavailable",
+			"Exporting reads the model files directly: mount Ollama's model directory into the proxy and set OLLAMA_MODELS_DIR")
+		return
+	}
+	manifest, raw, err := s.modelStore.Manifest(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeError(w, ollamaErrorFormat, http.StatusNotFound, "model_not_found", "Model not found in OLLAMA_MODELS_DIR: "+name)
+			return
+		}
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	// Check every blob before the 200, so a broken store is a clean error.
+	blobs := manifest.Blobs()
+	paths := make([]string, len(blobs))
+	var total int64
+	for i, b := range blobs {
+		path, err := s.modelStore.BlobPath(b.Digest)
+		if err == nil {
+			var info os.FileInfo
+			if info, err = os.Stat(path); err == nil && info.Size() != b.Size {
+				err = fmt.Errorf("%s is %d bytes, the manifest says %d", path, info.Size(), b.Size)
+			}
+		}
+		if err != nil {
+			log.Printf("!!! Export %s: blob %s: %v !!!", name, b.Digest, err)
+			writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "blob_missing", fmt.Sprintf("Blob %s of %s is missing or incomplete: %v", b.Digest, name, err))
+			return
+		}
+		paths[i] = path
+		total += b.Size
+	}
+
+	var modelfile string
+	if show, err := s.ollamaClient.ShowModel(name); err == nil {
+		modelfile = show.Modelfile
+	} else {
+		log.Printf("!!! Export %s: no Modelfile (%v); the archive has the manifest and blobs only !!!", name, err)
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar"`, archiveFileName(name)))
+	http.NewResponseController(w).SetWriteDeadline(time.Time{}) // tens of GB take a while
+	start := time.Now()
+	log.Printf("Exporting %s (%d blobs, %d bytes)", name, len(blobs), total)
+
+	tw := tar.NewWriter(w)
+	info, _ := json.MarshalIndent(modelArchiveInfo{
+		Format: modelArchiveFormat, Name: name, ExportedAt: start.UTC(), Size: total,
+	}, "", "  ")
+	err = writeTarFile(tw, "model.json", info)
+	if err == nil {
+		err = writeTarFile(tw, "manifest.json", raw)
+	}
+	if err == nil && modelfile != "" {
+		err = writeTarFile(tw, "Modelfile", []byte(modelfile))
+	}
+	for i := 0; err == nil && i < len(blobs); i++ {
+		err = writeTarBlob(tw, "blobs/"+strings.Replace(blobs[i].Digest, ":", "-", 1), paths[i], blobs[i].Size)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		// Without the tar trailer the client sees a truncated archive.
+		log.Printf("!!! Export %s aborted: %v !!!", name, err)
+		return
+	}
+	log.Printf("Exported %s in %s", name, time.Since(start).Round(time.Second))
+	s.events.Record("model_exported", map[string]interface{}{"model": name, "size": total})
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func writeTarBlob(tw *tar.Writer, name, path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, ModTime: info.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, size)
+	return err
+}
+
+// archiveFileName turns a model name into a download file name.
+func archiveFileName(name string) string {
+	return strings.NewReplacer("/", "_", ":", "_", `"`, "_", `\`, "_").Replace(name)
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"olares-ollama/internal/ollama"
+)
+
+const (
+	archiveMetaLimit    = 16 << 20 // model.json, manifest.json and text layers are read into memory
+	importProgressEvery = 500 * time.Millisecond
+	mediaTypeModel      = "application/vnd.ollama.image.model"
+	mediaTypeProjector  = "application/vnd.ollama.image.projector"
+	mediaTypeAdapter    = "application/vnd.ollama.image.adapter"
+	mediaTypeTemplate   = "application/vnd.ollama.image.template"
+	mediaTypeSystem     = "application/vnd.ollama.image.system"
+	mediaTypeParams     = "application/vnd.ollama.image.params"
+	mediaTypeMessages   = "application/vnd.ollama.image.messages"
+	mediaTypeLicense    = "application/vnd.ollama.image.license"
+	importStatus        = "importing"
+)
+
+// importing is set while an archive import runs: one at a time, as they
+// share the progress page with the model download.
+var importing atomic.Bool
+
+// refuseDuringImport answers 409 import_in_progress, and reports true, while
+// an archive import runs: a download started now would take over the
+// progress page the import shows on.
+func refuseDuringImport(w http.ResponseWriter) bool {
+	if !importing.Load() {
+		return false
+	}
+	writeError(w, ollamaErrorFormat, http.StatusConflict, "import_in_progress", "A model import is in progress; try again when it is done")
+	return true
+}
+
+// modelError is a failed model operation (import, create) with the status
+// and code to answer with.
+type modelError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *modelError) Error() string { return e.message }
+
+func badArchive(format string, args ...interface{}) error {
+	return &modelError{http.StatusBadRequest, "invalid_archive", fmt.Sprintf(format, args...)}
+}
+
+// importProgress counts the archive bytes read and reports them on the
+// progress page every importProgressEvery.
+type importProgress struct {
+	r        io.Reader
+	s        *Server
+	model    string
+	read     int64
+	total    int64 // Content-Length of the archive, 0 if unknown
+	reported time.Time
+}
+
+func (p *importProgress) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if p.model != "" && time.Since(p.reported) >= importProgressEvery {
+		p.reported = time.Now()
+		p.s.progressManager.UpdateProgress(importStatus, p.read, p.total, p.model)
+	}
+	return n, err
+}
+
+// archiveImport is the state of one import while the archive is read.
+type archiveImport struct {
+	name        string
+	manifest    *ollama.Manifest
+	layers      map[string]ollama.Layer // digest -> layer of the manifest
+	seen        map[string]bool         // digests found in the archive or already on Ollama
+	text        map[string][]byte       // content of the small layers that go into the create request
+	uploaded    int
+	present     int
+	uploadBytes int64
+}
+
+// handleModelImport serves POST /admin/models/import-archive: the body is a
+// tar written by the export endpoint. The blobs are uploaded to Ollama as
+// they are read (those Ollama already has are skipped) and the model is
+// then created from them, with progress on the progress page (/api/progress)
+// like a download. ?name= imports under another name; an existing model is
+// only replaced with ?overwrite=true, and a pinned one never.
+func (s *Server) handleModelImport(w http.ResponseWriter, r *http.Request) {
+	if !importing.CompareAndSwap(false, true) {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "import_in_progress", "Another model import is in progress")
+		return
+	}
+	defer importing.Store(false)
+	if s.progressManager.Busy() {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "download_in_progress", "A model download is in progress; import when it is done")
+		return
+	}
+	overwrite, _ := strconv.ParseBool(r.URL.Query().Get("overwrite"))
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{}) // archives of tens of GB
+	rc.SetWriteDeadline(time.Time{})
+
+	before := s.progressManager.GetProgress()
+	progress := &importProgress{r: r.Body, s: s, total: max(r.ContentLength, 0)}
+	imp, err := s.readModelArchive(tar.NewReader(progress), progress, r.URL.Query().Get("name"), overwrite)
+	if err == nil {
+		err = s.createImportedModel(imp)
+	}
+	if progress.model != "" {
+		if err != nil {
+			s.progressManager.UpdateError("Import failed: "+err.Error(), progress.read, progress.total, progress.model)
+		}
+		if before.ModelName != "" && before.ModelName != progress.model {
+			// Give the progress page back to the configured model.
+			s.progressManager.UpdateProgress(before.Status, before.Completed, before.Total, before.ModelName)
+		}
+	}
+
+	var ie *modelError
+	switch {
+	case errors.As(err, &ie):
+		log.Printf("!!! Import rejected: %s !!!", ie.message)
+		writeError(w, ollamaErrorFormat, ie.status, ie.code, ie.message)
+		return
+	case err != nil:
+		log.Printf("!!! Import failed: %v !!!", err)
+		writeError(w, ollamaErrorFormat, http.StatusBadGateway, "import_failed", err.Error())
+		return
+	}
+	s.forgetModelInfo(imp.name)
+	log.Printf("Imported %s (%d blobs uploaded, %d already on Ollama)", imp.name, imp.uploaded, imp.present)
+	s.events.Record("model_imported", map[string]interface{}{
+		"model": imp.name, "blobs_uploaded": imp.uploaded, "bytes_uploaded": imp.uploadBytes,
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":         "success",
+		"model":          imp.name,
+		"blobs_uploaded": imp.uploaded,
+		"blobs_present":  imp.present,
+		"bytes_uploaded": imp.uploadBytes,
+	})
+}
+
+// readModelArchive reads the archive in export order (model.json, then
+// manifest.json, then the blobs) and uploads the blobs. Problems with the
+// archive or the target are modelErrors; Ollama failures are plain errors.
+func (s *Server) readModelArchive(tr *tar.Reader, progress *importProgress, name string, overwrite bool) (*archiveImport, error) {
+	imp := &archiveImport{name: name, seen: make(map[string]bool), text: make(map[string][]byte)}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imp, badArchive("Reading the archive failed: %v", err)
+		}
+		switch {
+		case hdr.Name == "model.json":
+			var info modelArchiveInfo
+			if err := decodeArchiveJSON(tr, &info); err != nil {
+				return imp, badArchive("model.json: %v", err)
+			}
+			if info.Format > modelArchiveFormat {
+				return imp, badArchive("Archive format %d is newer than this proxy supports (%d)", info.Format, modelArchiveFormat)
+			}
+			if imp.name == "" {
+				imp.name = info.Name
+			}
+		case hdr.Name == "manifest.json":
+			var m ollama.Manifest
+			if err := decodeArchiveJSON(tr, &m); err != nil {
+				return imp, badArchive("manifest.json: %v", err)
+			}
+			if err := s.checkModelTarget(imp.name, overwrite); err != nil {
+				return imp, err
+			}
+			if err := imp.setManifest(&m); err != nil {
+				return imp, err
+			}
+			progress.model = imp.name
+			s.progressManager.UpdateProgress("starting", 0, progress.total, imp.name)
+		case strings.HasPrefix(hdr.Name, "blobs/"):
+			if imp.manifest == nil {
+				return imp, badArchive("%s comes before manifest.json; was the archive written by the export endpoint?", hdr.Name)
+			}
+			if err := s.importBlob(imp, tr, strings.Replace(strings.TrimPrefix(hdr.Name, "blobs/"), "-", ":", 1), hdr.Size); err != nil {
+				return imp, err
+			}
+		}
+		// Modelfile and unknown entries are skipped: the create request is
+		// built from the manifest's layers.
+	}
+	if imp.manifest == nil {
+		return imp, badArchive("The archive has no manifest.json")
+	}
+	return imp, nil
+}
+
+func decodeArchiveJSON(r io.Reader, v interface{}) error {
+	return json.NewDecoder(io.LimitReader(r, archiveMetaLimit)).Decode(v)
+}
+
+// checkModelTarget refuses to replace a pinned model, or an existing one
+// without overwrite.
+func (s *Server) checkModelTarget(name string, overwrite bool) error {
+	if name == "" {
+		return badArchive("The archive has no model name (model.json); pass ?name=")
+	}
+	if s.ModelPinned(name) {
+		return &modelError{http.StatusConflict, "model_pinned", "Model is pinned and can't be replaced: " + name}
+	}
+	exists, err := s.ollamaClient.ModelExists(name)
+	if err != nil {
+		return fmt.Errorf("checking for an existing %s: %w", name, err)
+	}
+	if exists && !overwrite {
+		return &modelError{http.StatusConflict, "model_exists", "Model already exists: " + name + " (set overwrite to replace it)"}
+	}
+	return nil
+}
+
+// setManifest records the layers to expect, refusing the ones the create
+// API can't rebuild a model from.
+func (imp *archiveImport) setManifest(m *ollama.Manifest) error {
+	imp.manifest = m
+	imp.layers = make(map[string]ollama.Layer)
+	for _, l := range m.Layers {
+		switch l.MediaType {
+		case mediaTypeModel, mediaTypeProjector, mediaTypeAdapter,
+			mediaTypeTemplate, mediaTypeSystem, mediaTypeParams, mediaTypeMessages, mediaTypeLicense:
+		default:
+			return &modelError{http.StatusUnprocessableEntity, "unsupported_layer",
+				fmt.Sprintf("Layer %s has media type %s, which can't be imported through Ollama's create API", l.Digest, l.MediaType)}
+		}
+		imp.layers[l.Digest] = l
+	}
+	return nil
+}
+
+// importBlob uploads one blob of the archive, or keeps its content when it
+// is a small layer that goes into the create request.
+func (s *Server) importBlob(imp *archiveImport, r io.Reader, digest string, size int64) error {
+	layer, ok := imp.layers[digest]
+	if !ok {
+		return nil // the config blob: Ollama writes its own
+	}
+	if layer.Size != size {
+		return badArchive("Blob %s is %d bytes, the manifest says %d", digest, size, layer.Size)
+	}
+	imp.seen[digest] = true
+	switch layer.MediaType {
+	case mediaTypeModel, mediaTypeProjector, mediaTypeAdapter:
+	default:
+		data, err := io.ReadAll(io.LimitReader(r, archiveMetaLimit))
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", digest, err)
+		}
+		imp.text[layer.MediaType] = data
+		return nil
+	}
+	if exists, err := s.ollamaClient.BlobExists(digest); err == nil && exists {
+		imp.present++
+		return nil // the tar reader skips the rest of the entry
+	}
+	log.Printf("Import %s: uploading blob %s (%d bytes)", imp.name, digest, size)
+	if err := s.ollamaClient.PushBlobFrom(digest, r, size); err != nil {
+		return fmt.Errorf("uploading %s: %w", digest, err)
+	}
+	imp.uploaded++
+	imp.uploadBytes += size
+	return nil
+}
+
+// createImportedModel builds the create request from the manifest's layers.
+func (s *Server) createImportedModel(imp *archiveImport) error {
+	req := ollama.CreateRequest{Model: imp.name, Files: make(map[string]string)}
+	var projectors, adapters int
+	for _, l := range imp.manifest.Layers {
+		if !imp.seen[l.Digest] {
+			if exists, err := s.ollamaClient.BlobExists(l.Digest); err != nil || !exists {
+				return badArchive("Blob %s (%s) is neither in the archive nor on Ollama", l.Digest, l.MediaType)
+			}
+			imp.present++
+		}
+		switch l.MediaType {
+		case mediaTypeModel:
+			req.Files["model.gguf"] = l.Digest
+		case mediaTypeProjector:
+			projectors++
+			req.Files[fmt.Sprintf("projector-%d.gguf", projectors)] = l.Digest
+		case mediaTypeAdapter:
+			adapters++
+			if req.Adapters == nil {
+				req.Adapters = make(map[string]string)
+			}
+			req.Adapters[fmt.Sprintf("adapter-%d.gguf", adapters)] = l.Digest
+		}
+	}
+	if _, ok := req.Files["model.gguf"]; !ok {
+		return badArchive("The manifest has no model layer")
+	}
+	req.Template = string(imp.text[mediaTypeTemplate])
+	req.System = string(imp.text[mediaTypeSystem])
+	req.License = string(imp.text[mediaTypeLicense])
+	if params := imp.text[mediaTypeParams]; len(params) > 0 {
+		if err := json.Unmarshal(params, &req.Parameters); err != nil {
+			return badArchive("Parameters layer: %v", err)
+		}
+	}
+	if messages := imp.text[mediaTypeMessages]; len(messages) > 0 {
+		req.Messages = json.RawMessage(messages)
+	}
+	log.Printf("Import %s: creating the model", imp.name)
+	return s.ollamaClient.CreateModel(req, s.progressManager)
+}
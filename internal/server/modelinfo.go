@@ -0,0 +1,76 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"olares-ollama/internal/ollama"
+)
+
+const (
+	modelInfoTTL      = 10 * time.Minute
+	modelInfoErrorTTL = 30 * time.Second
+)
+
+// modelInfoCache caches /api/show per server and model so request paths can consult
+// model metadata (context length, capabilities) without a round trip each time.
+type modelInfoCache struct {
+	mu      sync.Mutex
+	entries map[modelInfoKey]modelInfoEntry
+}
+
+type modelInfoKey struct {
+	upstream string // base URL of the Ollama server asked
+	model    string
+}
+
+type modelInfoEntry struct {
+	show    *ollama.ShowResponse // nil when the lookup failed
+	expires time.Time
+}
+
+// showModel returns cached /api/show metadata for model on upstream, or nil
+// if unavailable. Failed lookups are remembered briefly so an unreachable
+// Ollama isn't hammered.
+func (s *Server) showModel(upstream *ollama.Client, model string) *ollama.ShowResponse {
+	if model == "" {
+		return nil
+	}
+	key := modelInfoKey{upstream: upstream.BaseURL(), model: model}
+	c := &s.modelInfo
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok && time.Now().Before(e.expires) {
+		c.mu.Unlock()
+		return e.show
+	}
+	c.mu.Unlock()
+
+	show, err := upstream.ShowModel(model)
+	entry := modelInfoEntry{show: show, expires: time.Now().Add(modelInfoTTL)}
+	if err != nil {
+		log.Printf("Warning: /api/show for %s at %s failed: %v", model, upstream.BaseURL(), err)
+		entry = modelInfoEntry{expires: time.Now().Add(modelInfoErrorTTL)}
+	}
+
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[modelInfoKey]modelInfoEntry)
+	}
+	c.entries[key] = entry
+	c.mu.Unlock()
+	return entry.show
+}
+
+// forgetModelInfo drops the cached metadata of a model that was just
+// created or replaced, on every server.
+func (s *Server) forgetModelInfo(model string) {
+	c := &s.modelInfo
+	c.mu.Lock()
+	for key := range c.entries {
+		if key.model == model {
+			delete(c.entries, key)
+		}
+	}
+	c.mu.Unlock()
+}
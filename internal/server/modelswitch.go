@@ -0,0 +1,432 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	switchWarmKeepAlive = 600 // seconds the target stays loaded after the warm-up, until requests keep it
+	switchStateFile     = "active_model.json"
+	switchDrainTimeout  = 10 * time.Minute // longest wait for the old model's requests before unloading it
+	switchDrainPoll     = time.Second
+)
+
+// States of a model switch, reported as model_switch.state.
+const (
+	switchIdle     = "idle"
+	switchPulling  = "pulling"  // downloading the target while the current model serves
+	switchWarming  = "warming"  // loading the target into memory
+	switchTesting  = "testing"  // smoke test of the target
+	switchDraining = "draining" // serving the target; requests on the old model finish before it is unloaded
+	switchSwitched = "switched"
+	switchFailed   = "failed"
+)
+
+// modelSwitch is the last switch of the served model requested via
+// /admin/model/switch.
+type modelSwitch struct {
+	mu         sync.Mutex
+	State      string       `json:"state"`
+	From       string       `json:"from,omitempty"`
+	To         string       `json:"to,omitempty"`
+	Prefetch   bool         `json:"prefetch,omitempty"`
+	Completed  int64        `json:"completed,omitempty"` // pull progress, bytes
+	Total      int64        `json:"total,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	SmokeTest  *smokeResult `json:"smoke_test,omitempty"`
+	Draining   int          `json:"draining_requests,omitempty"` // requests still running on the old model
+	StartedAt  time.Time    `json:"started_at,omitzero"`
+	FinishedAt time.Time    `json:"finished_at,omitzero"`
+}
+
+// modelUsage counts the requests running per model, so a switch can wait
+// for those on the old model before unloading it.
+type modelUsage struct {
+	mu       sync.Mutex
+	inflight map[string]int
+}
+
+// track counts a request for model until the returned func is called.
+func (mu *modelUsage) track(model string) func() {
+	mu.mu.Lock()
+	if mu.inflight == nil {
+		mu.inflight = make(map[string]int)
+	}
+	mu.inflight[model]++
+	mu.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			mu.mu.Lock()
+			if mu.inflight[model]--; mu.inflight[model] <= 0 {
+				delete(mu.inflight, model)
+			}
+			mu.mu.Unlock()
+		})
+	}
+}
+
+// running returns the requests in progress for model.
+func (mu *modelUsage) running(model string) int {
+	mu.mu.Lock()
+	defer mu.mu.Unlock()
+	n := 0
+	for m, c := range mu.inflight {
+		if matchesModel(m, model) {
+			n += c
+		}
+	}
+	return n
+}
+
+// activeModelRecord is data/active_model.json: the model switched to, kept
+// across restarts as long as OLLAMA_MODEL is still the one it replaced.
+type activeModelRecord struct {
+	Model      string    `json:"model"`
+	Configured string    `json:"configured"` // OLLAMA_MODEL at the time of the switch
+	SwitchedAt time.Time `json:"switched_at"`
+}
+
+// model returns the model requests are served with: OLLAMA_MODEL, or the
+// one an admin switched to.
+func (s *Server) model() string {
+	if m, _ := s.activeModel.Load().(string); m != "" {
+		return m
+	}
+	return s.config.Model
+}
+
+// UpdateProgress and UpdateError record the target's pull progress: a
+// switch doesn't take over the progress page, which is about the model
+// being served.
+func (ms *modelSwitch) UpdateProgress(status string, completed, total int64, model string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.Completed, ms.Total = completed, total
+}
+
+func (ms *modelSwitch) UpdateError(errMsg string, completed, total int64, model string) {}
+
+func (ms *modelSwitch) info() map[string]interface{} {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	data, _ := json.Marshal(ms)
+	var out map[string]interface{}
+	json.Unmarshal(data, &out)
+	if out["state"] == "" {
+		out["state"] = switchIdle
+	}
+	return out
+}
+
+// restoreActiveModel applies a switch persisted by an earlier run. Changing
+// OLLAMA_MODEL since then discards it: the new configuration wins.
+func (s *Server) restoreActiveModel() {
+	if s.config.Model == "" {
+		return
+	}
+	file := filepath.Join("data", switchStateFile)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read %s: %v", file, err)
+		}
+		return
+	}
+	var rec activeModelRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		log.Printf("Warning: failed to parse %s: %v", file, err)
+		return
+	}
+	if rec.Configured != s.config.Model || rec.Model == "" {
+		log.Printf("OLLAMA_MODEL changed since the switch to %s; serving %s", rec.Model, s.config.Model)
+		os.Remove(file)
+		return
+	}
+	s.activeModel.Store(rec.Model)
+	if s.hotModels != nil {
+		s.hotModels.add(rec.Model)
+	}
+	log.Printf("Serving %s (switched from %s on %s)", rec.Model, rec.Configured, rec.SwitchedAt.Format(time.RFC3339))
+}
+
+// setActiveModel flips the served model. Requests already running finish on
+// the model they started with.
+func (s *Server) setActiveModel(model string) error {
+	from := s.model()
+	s.activeModel.Store(model)
+	s.generation.Add(1)
+	if s.hotModels != nil {
+		s.hotModels.add(model)
+	}
+	ml := &s.modelLoad
+	ml.mu.Lock()
+	ml.state, ml.loadedAt, ml.expiresAt, ml.sizeVRAM = modelLoadUnknown, time.Time{}, time.Time{}, 0
+	ml.mu.Unlock()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), modelLoadCheckWait)
+		s.refreshModelLoad(ctx)
+		cancel()
+	}()
+	log.Printf("Now serving %s (was %s)", model, from)
+	s.events.Record("model_switched", map[string]interface{}{"from": from, "to": model})
+
+	if s.config.BaseMode {
+		return saveSetup(model) // switching from the model chosen in the setup
+	}
+	file := filepath.Join("data", switchStateFile)
+	if model == s.config.Model {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, _ := json.MarshalIndent(activeModelRecord{Model: model, Configured: s.config.Model, SwitchedAt: time.Now()}, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+// handleModelSwitch serves POST /admin/model/switch: serve another model
+// from now on. With prefetch (the default) the target is pulled if Ollama
+// doesn't have it and loaded into memory in the background while the
+// current model keeps serving; the switch happens once it is ready (202, the
+// progress is at GET). Without prefetch the target must be in Ollama and
+// the switch is immediate; its first request waits for the load.
+func (s *Server) handleModelSwitch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model    string `json:"model"`
+		Prefetch *bool  `json:"prefetch,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Model == "" {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Body must be {\"model\": \"...\"}")
+		return
+	}
+	if s.setupNeeded() {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "base_mode", "In base mode requests keep the model they ask for; there is nothing to switch (choose a model with POST /api/setup)")
+		return
+	}
+	prefetch := req.Prefetch == nil || *req.Prefetch
+	ms := &s.modelSwitch
+	ms.mu.Lock()
+	if ms.State == switchPulling || ms.State == switchWarming || ms.State == switchTesting || ms.State == switchDraining {
+		ms.mu.Unlock()
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "switch_in_progress", fmt.Sprintf("A switch to %s is in progress", ms.To))
+		return
+	}
+	from := s.model()
+	if matchesModel(req.Model, from) {
+		ms.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "unchanged", "model": from})
+		return
+	}
+	if !prefetch {
+		ms.mu.Unlock()
+		exists, err := s.ollamaClient.ModelExists(req.Model)
+		if err != nil {
+			writeUpstreamError(w, ollamaErrorFormat, upstreamErrorFromTransport(err))
+			return
+		}
+		if !exists {
+			writeError(w, ollamaErrorFormat, http.StatusNotFound, "model_not_found", "Model not found in Ollama: "+req.Model+" (switch with prefetch to pull it)")
+			return
+		}
+		ms.mu.Lock()
+		ms.State, ms.From, ms.To, ms.Prefetch, ms.Error, ms.SmokeTest = switchDraining, from, req.Model, false, "", nil
+		ms.Completed, ms.Total = 0, 0
+		ms.StartedAt, ms.FinishedAt = time.Now(), time.Time{}
+		ms.mu.Unlock()
+		if err := s.setActiveModel(req.Model); err != nil {
+			log.Printf("Warning: failed to persist the model switch: %v", err)
+		}
+		go s.retireModel(from)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "switched", "model": req.Model, "previous": from})
+		return
+	}
+	ms.State, ms.From, ms.To, ms.Prefetch, ms.Error, ms.SmokeTest = switchPulling, from, req.Model, true, "", nil
+	ms.Completed, ms.Total = 0, 0
+	ms.StartedAt, ms.FinishedAt = time.Now(), time.Time{}
+	ms.mu.Unlock()
+
+	log.Printf("Switching to %s: preparing it while %s serves", req.Model, from)
+	go s.runModelSwitch(req.Model)
+	writeJSON(w, http.StatusAccepted, ms.info())
+}
+
+// runModelSwitch pulls, warms and smoke-tests the target while the current
+// model serves, then switches to it and retires the old one.
+func (s *Server) runModelSwitch(model string) {
+	ms := &s.modelSwitch
+	fail := func(err error) {
+		log.Printf("!!! Switch to %s failed: %v; still serving %s !!!", model, err, s.model())
+		s.events.Record("model_switch_failed", map[string]interface{}{"to": model, "error": err.Error()})
+		ms.mu.Lock()
+		ms.State, ms.Error, ms.FinishedAt = switchFailed, err.Error(), time.Now()
+		ms.mu.Unlock()
+	}
+	exists, err := s.ollamaClient.ModelExists(model)
+	if err != nil {
+		fail(fmt.Errorf("checking for %s: %w", model, err))
+		return
+	}
+	if !exists {
+		log.Printf("Switch: pulling %s", model)
+		if err := s.ollamaClient.PullModelWithProgress(model, ms); err != nil {
+			fail(fmt.Errorf("pulling %s: %w", model, err))
+			return
+		}
+	}
+
+	ms.mu.Lock()
+	ms.State = switchWarming
+	ms.mu.Unlock()
+	log.Printf("Switch: loading %s", model)
+	ctx, cancel := context.WithTimeout(context.Background(), hotLoadTimeout)
+	start := time.Now()
+	err = s.ollamaClient.SetKeepAlive(ctx, model, switchWarmKeepAlive)
+	cancel()
+	if err != nil {
+		fail(fmt.Errorf("loading %s: %w", model, err))
+		return
+	}
+	log.Printf("Switch: %s loaded in %s", model, time.Since(start).Round(time.Millisecond))
+
+	ms.mu.Lock()
+	ms.State = switchTesting
+	ms.mu.Unlock()
+	result := s.smokeTest(context.Background(), model)
+	ms.mu.Lock()
+	ms.SmokeTest = &result
+	ms.mu.Unlock()
+	if !result.Passed {
+		fail(fmt.Errorf("smoke test of %s failed: %s", model, result.Error))
+		return
+	}
+	log.Printf("Switch: %s passed the smoke test in %dms", model, result.LatencyMs)
+
+	ms.mu.Lock()
+	ms.State = switchDraining
+	from := ms.From
+	ms.mu.Unlock()
+	if err := s.setActiveModel(model); err != nil {
+		log.Printf("Warning: failed to persist the model switch: %v", err)
+	}
+	s.retireModel(from)
+}
+
+// retireModel waits for the requests still running on the model switched
+// away from (those modelUsage tracks: inference requests, compare sides and
+// eval runs), then unloads it to free its memory for the new one. A model
+// the proxy still has a use for (pinned, managed by HOT_MODELS, or
+// FAST_LANE_MODEL) stays loaded.
+func (s *Server) retireModel(old string) {
+	ms := &s.modelSwitch
+	deadline := time.Now().Add(switchDrainTimeout)
+	for {
+		n := s.modelUsage.running(old)
+		ms.mu.Lock()
+		ms.Draining = n
+		ms.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			log.Printf("!!! Switch: %d requests still on %s after %s; unloading it anyway !!!", n, old, switchDrainTimeout)
+			break
+		}
+		time.Sleep(switchDrainPoll)
+	}
+
+	hot := false
+	if s.hotModels != nil {
+		_, hot = s.hotModels.managed(old)
+	}
+	switch {
+	case old == "":
+	case s.ModelPinned(old) || hot || matchesModel(old, s.config.FastLaneModel):
+		log.Printf("Switch: keeping %s loaded (still in use by the proxy)", old)
+	default:
+		ctx, cancel := context.WithTimeout(context.Background(), upstreamProbeTimeout)
+		if err := s.ollamaClient.SetKeepAlive(ctx, old, 0); err != nil {
+			log.Printf("Warning: unloading %s after the switch failed: %v", old, err)
+		} else {
+			log.Printf("Switch: unloaded %s", old)
+		}
+		cancel()
+	}
+	ms.mu.Lock()
+	ms.State, ms.Draining, ms.FinishedAt = switchSwitched, 0, time.Now()
+	ms.mu.Unlock()
+}
+
+// handleModelSwitchStatus serves GET /admin/model/switch.
+func (s *Server) handleModelSwitchStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"model":      s.model(),
+		"configured": s.config.Model,
+		"switch":     s.modelSwitch.info(),
+	})
+}
+
+// prefetchModels pulls, in the background, the models requests may be sent
+// to besides the served one (ROUTING_RULES targets, FAST_LANE_MODEL,
+// HOT_MODELS, COMPARE_MODELS) when Ollama doesn't have them yet, so the
+// first request routed to one doesn't fail or wait for a download. It runs
+// after the served model is ready, one model at a time.
+func (s *Server) prefetchModels() {
+	seen := map[string]bool{"": true}
+	var models []string
+	candidates := append([]string{s.config.FastLaneModel}, s.config.HotModels...)
+	candidates = append(candidates, s.config.CompareModels...)
+	for _, rule := range s.routingRules {
+		candidates = append(candidates, rule.Model)
+	}
+	for _, m := range candidates {
+		if !seen[m] {
+			seen[m] = true
+			models = append(models, m)
+		}
+	}
+	if len(models) == 0 {
+		return
+	}
+	for {
+		status := s.progressManager.GetProgress().Status
+		if status == "completed" || status == "success" || (s.config.Model == "" && !s.progressManager.Busy()) {
+			break
+		}
+		time.Sleep(hotModelsInterval)
+	}
+	for _, m := range models {
+		if matchesModel(m, s.model()) {
+			continue
+		}
+		if s.upstreams != nil { // onto the server with the most room
+			if _, err := s.placeModel(m); err != nil {
+				log.Printf("!!! Prefetch of %s failed: %v !!!", m, err)
+			}
+			continue
+		}
+		exists, err := s.ollamaClient.ModelExists(m)
+		if err != nil || exists {
+			continue
+		}
+		log.Printf("Prefetch: pulling %s", m)
+		start := time.Now()
+		if err := s.ollamaClient.PullModelWithProgress(m, quietProgress{}); err != nil {
+			log.Printf("!!! Prefetch of %s failed: %v !!!", m, err)
+			continue
+		}
+		log.Printf("Prefetch: %s pulled in %s", m, time.Since(start).Round(time.Second))
+		s.events.Record("model_prefetched", map[string]interface{}{"model": m, "duration_ms": time.Since(start).Milliseconds()})
+	}
+}
@@ -0,0 +1,413 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// headerOutputFilters overrides OUTPUT_FILTERS for one request
+// (comma-separated filter names, or "none").
+const headerOutputFilters = "X-Output-Filters"
+
+// maxHTMLTag bounds how much of a possible HTML tag is held back while
+// waiting for its '>'; longer runs are passed through as text.
+const maxHTMLTag = 1024
+
+// textFilter rewrites generated text as it streams. Chunks may split any
+// construct, so filters keep state and hold back what they can't decide
+// on yet; final flushes it.
+type textFilter interface {
+	filter(chunk string, final bool) string
+}
+
+// newTextFilter returns the filter for a name of OUTPUT_FILTERS.
+func newTextFilter(name string) textFilter {
+	switch name {
+	case "ansi":
+		return &ansiFilter{}
+	case "html":
+		return &htmlFilter{}
+	case "whitespace":
+		return &whitespaceFilter{}
+	}
+	return nil
+}
+
+// codeTracker follows Markdown code: ``` fences and `inline` spans, which
+// the html and whitespace filters leave alone.
+type codeTracker struct {
+	ticks  int // backticks in the current run
+	fence  bool
+	inline bool
+}
+
+// feed advances over one byte and reports whether it is inside code.
+func (ct *codeTracker) feed(c byte) bool {
+	if c == '`' {
+		ct.ticks++
+		return true
+	}
+	if ct.ticks > 0 {
+		switch {
+		case ct.ticks >= 3:
+			ct.fence = !ct.fence
+			ct.inline = false
+		case !ct.fence:
+			ct.inline = !ct.inline
+		}
+		ct.ticks = 0
+	}
+	if c == '\n' {
+		ct.inline = false
+	}
+	return ct.fence || ct.inline
+}
+
+// ansiFilter strips ANSI escape sequences (colors, cursor movement, OSC titles).
+type ansiFilter struct {
+	pending string // an unfinished escape sequence
+}
+
+func (f *ansiFilter) filter(chunk string, final bool) string {
+	s := f.pending + chunk
+	f.pending = ""
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != 0x1b {
+			out.WriteByte(s[i])
+			continue
+		}
+		end := ansiSequenceEnd(s[i:])
+		if end < 0 {
+			if !final {
+				f.pending = s[i:]
+			}
+			break
+		}
+		i += end - 1
+	}
+	return out.String()
+}
+
+// ansiSequenceEnd returns the length of the escape sequence at the start of
+// s, or -1 when s ends before the sequence does.
+func ansiSequenceEnd(s string) int {
+	if len(s) < 2 {
+		return -1
+	}
+	switch s[1] {
+	case '[': // CSI: parameters, then a final byte in @-~
+		for i := 2; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return -1
+	case ']': // OSC: ended by BEL or ESC \
+		for i := 2; i < len(s); i++ {
+			if s[i] == 0x07 {
+				return i + 1
+			}
+			if s[i] == 0x1b && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return -1
+	}
+	return 2
+}
+
+// htmlDropTags are removed outright; script and style also lose their content.
+var htmlDropTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "frame": true, "frameset": true, "object": true,
+	"embed": true, "applet": true, "link": true, "meta": true, "base": true, "form": true,
+	"input": true, "button": true, "textarea": true, "select": true, "svg": true, "math": true,
+}
+
+// htmlFilter sanitizes HTML outside Markdown code: it removes active tags
+// (script, iframe, forms, ...) and the attributes of any tag with an on*
+// handler or a javascript: URL, and drops the content of script and style
+// elements.
+// Other tags, and text that merely contains '<', pass through.
+type htmlFilter struct {
+	code    codeTracker
+	pending string // a possible tag, from its '<'
+	skip    string // inside <script> or <style>: the element whose end tag ends the skip
+}
+
+func (f *htmlFilter) filter(chunk string, final bool) string {
+	s := f.pending + chunk
+	f.pending = ""
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if f.skip == "" && f.code.feed(c) {
+			out.WriteByte(c)
+			continue
+		}
+		if c != '<' {
+			if f.skip == "" {
+				out.WriteByte(c)
+			}
+			continue
+		}
+		end := strings.IndexByte(s[i:], '>')
+		if end < 0 {
+			if !final && len(s)-i < maxHTMLTag {
+				f.pending = s[i:]
+				return out.String()
+			}
+			if f.skip == "" {
+				out.WriteString(s[i:])
+			}
+			return out.String()
+		}
+		tag := s[i : i+end+1]
+		i += end
+		name, closing, ok := htmlTagName(tag)
+		switch {
+		case f.skip != "":
+			if closing && name == f.skip {
+				f.skip = ""
+			}
+		case !ok:
+			out.WriteString(tag) // "a < b > c" is text, not a tag
+		case htmlDropTags[name]:
+			if !closing && (name == "script" || name == "style") && !strings.HasSuffix(tag, "/>") {
+				f.skip = name
+			}
+		case htmlActive(tag):
+			out.WriteString(htmlBareTag(name, closing)) // keep the element, drop its attributes
+		default:
+			out.WriteString(tag)
+		}
+	}
+	return out.String()
+}
+
+// htmlTagName parses "<name ...>" or "</name>"; ok=false when tag doesn't
+// look like an HTML tag (also for comments, which are kept).
+func htmlTagName(tag string) (name string, closing, ok bool) {
+	body := tag[1 : len(tag)-1]
+	if strings.HasPrefix(body, "/") {
+		closing = true
+		body = body[1:]
+	}
+	n := 0
+	for n < len(body) && (body[n] >= 'a' && body[n] <= 'z' || body[n] >= 'A' && body[n] <= 'Z' || n > 0 && body[n] >= '0' && body[n] <= '9') {
+		n++
+	}
+	if n == 0 || n < len(body) && !strings.ContainsRune(" \t\n/", rune(body[n])) {
+		return "", false, false
+	}
+	return strings.ToLower(body[:n]), closing, true
+}
+
+func htmlBareTag(name string, closing bool) string {
+	if closing {
+		return "</" + name + ">"
+	}
+	return "<" + name + ">"
+}
+
+// htmlActive reports an event handler attribute or a javascript: URL.
+func htmlActive(tag string) bool {
+	lower := strings.ToLower(tag)
+	if strings.Contains(lower, "javascript:") || strings.Contains(lower, "vbscript:") {
+		return true
+	}
+	for i := 1; i+2 < len(lower); i++ {
+		if (lower[i-1] == ' ' || lower[i-1] == '\t' || lower[i-1] == '\n' || lower[i-1] == '/') && lower[i] == 'o' && lower[i+1] == 'n' {
+			j := i + 2
+			for j < len(lower) && lower[j] >= 'a' && lower[j] <= 'z' {
+				j++
+			}
+			for j < len(lower) && (lower[j] == ' ' || lower[j] == '\t') {
+				j++
+			}
+			if j > i+2 && j < len(lower) && lower[j] == '=' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// whitespaceFilter collapses runs of spaces and tabs inside lines, drops
+// trailing whitespace, and keeps at most one blank line in a row.
+// Indentation and Markdown code are kept as they are.
+type whitespaceFilter struct {
+	code      codeTracker
+	spaces    string // held spaces: dropped before a newline, collapsed before text
+	newlines  int    // held newlines
+	lineStart bool   // nothing but indentation on this line yet
+	started   bool
+}
+
+func (f *whitespaceFilter) filter(chunk string, final bool) string {
+	var out strings.Builder
+	if !f.started {
+		f.started, f.lineStart = true, true
+	}
+	for i := 0; i < len(chunk); i++ {
+		c := chunk[i]
+		inCode := f.code.feed(c)
+		switch {
+		case c == '\n':
+			f.spaces = ""
+			f.newlines++
+			f.lineStart = true
+			if inCode {
+				f.flushNewlines(&out, true)
+			}
+		case c == ' ' || c == '\t':
+			if inCode || f.lineStart {
+				f.flushNewlines(&out, inCode)
+				out.WriteByte(c) // indentation
+			} else {
+				f.spaces += string(c)
+			}
+		default:
+			f.flushNewlines(&out, inCode)
+			if f.spaces != "" {
+				if inCode {
+					out.WriteString(f.spaces)
+				} else {
+					out.WriteByte(' ')
+				}
+				f.spaces = ""
+			}
+			f.lineStart = false
+			out.WriteByte(c)
+		}
+	}
+	if final {
+		f.spaces, f.newlines = "", 0
+	}
+	return out.String()
+}
+
+// flushNewlines writes the held newlines, at most two (one blank line)
+// outside code.
+func (f *whitespaceFilter) flushNewlines(out *strings.Builder, keepAll bool) {
+	n := f.newlines
+	if !keepAll && n > 2 {
+		n = 2
+	}
+	out.WriteString(strings.Repeat("\n", n))
+	f.newlines = 0
+}
+
+// outputFilterNames returns the filters for the request: X-Output-Filters,
+// else OUTPUT_FILTERS.
+func (s *Server) outputFilterNames(r *http.Request) []string {
+	if v := strings.TrimSpace(r.Header.Get(headerOutputFilters)); v != "" {
+		if strings.EqualFold(v, "none") {
+			return nil
+		}
+		var names []string
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); newTextFilter(name) != nil {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return s.config.OutputFilters
+}
+
+// filterOutput runs the request's output filters over the generated text of
+// an Ollama chat or generate response body (NDJSON stream or a single JSON
+// object), so every response format built from it gets filtered text.
+// JSON-mode requests are left alone: their output is data, not text to render.
+func (s *Server) filterOutput(r *http.Request, req map[string]interface{}, body io.ReadCloser) io.ReadCloser {
+	if wantsJSON(req) {
+		return body
+	}
+	fb := &filteredBody{ReadCloser: body, src: bufio.NewReader(body)}
+	for _, name := range s.outputFilterNames(r) {
+		if f := newTextFilter(strings.ToLower(name)); f != nil {
+			fb.content = append(fb.content, f)
+			fb.thinking = append(fb.thinking, newTextFilter(strings.ToLower(name)))
+		}
+	}
+	if len(fb.content) == 0 {
+		return body
+	}
+	return fb
+}
+
+// filteredBody rewrites an Ollama response body line by line.
+type filteredBody struct {
+	io.ReadCloser
+	src      *bufio.Reader
+	content  []textFilter
+	thinking []textFilter // separate state for the thinking text
+	buf      bytes.Buffer
+	err      error
+}
+
+func (b *filteredBody) Read(p []byte) (int, error) {
+	for b.buf.Len() == 0 && b.err == nil {
+		line, err := b.src.ReadBytes('\n')
+		b.err = err
+		if len(line) > 0 {
+			b.buf.Write(b.rewrite(line))
+		}
+	}
+	if b.buf.Len() > 0 {
+		return b.buf.Read(p)
+	}
+	return 0, b.err
+}
+
+// applyFilters runs the chain over obj[key] and reports whether the text changed.
+// The final chunk is filtered even without text, to flush what is held back.
+func applyFilters(filters []textFilter, obj map[string]interface{}, key string, final bool) bool {
+	text, ok := obj[key].(string)
+	if !ok && !final {
+		return false
+	}
+	out := text
+	for _, f := range filters {
+		out = f.filter(out, final)
+	}
+	if out == text {
+		return false
+	}
+	obj[key] = out
+	return true
+}
+
+// rewrite filters message.content / response (and message.thinking) of one
+// JSON line; lines that aren't JSON objects pass through.
+func (b *filteredBody) rewrite(line []byte) []byte {
+	var chunk map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if dec.Decode(&chunk) != nil {
+		return line
+	}
+	final, _ := chunk["done"].(bool)
+	changed := false
+	if msg, ok := chunk["message"].(map[string]interface{}); ok {
+		changed = applyFilters(b.content, msg, "content", final)
+		if _, ok := msg["thinking"]; ok || final {
+			changed = applyFilters(b.thinking, msg, "thinking", final) || changed
+		}
+	} else if _, ok := chunk["response"]; ok {
+		changed = applyFilters(b.content, chunk, "response", final)
+	}
+	if !changed {
+		return line
+	}
+	out, err := json.Marshal(chunk)
+	if err != nil {
+		return line
+	}
+	return append(out, '\n')
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// headerWarnings lists what the proxy ignored in a request, e.g. OpenAI
+// parameters it has no Ollama mapping for.
+const headerWarnings = "X-Proxy-Warnings"
+
+// samplingParams are the sampling parameters an OpenAI-format request may
+// carry, with the Ollama option each one sets. repeat_penalty, top_k, min_p
+// and typical_p aren't OpenAI parameters, but clients send them anyway, at
+// the top level or in extra_body.
+var samplingParams = map[string]string{
+	"temperature": "temperature", "top_p": "top_p", "seed": "seed",
+	"frequency_penalty": "frequency_penalty", "presence_penalty": "presence_penalty",
+	"repeat_penalty": "repeat_penalty", "top_k": "top_k", "min_p": "min_p", "typical_p": "typical_p",
+}
+
+// Client parameters the OpenAI-format handlers translate, and where they
+// end up in the Ollama request; any other parameter is dropped.
+var (
+	openAIChatParams = withSamplingParams(map[string]string{
+		"model": "model", "messages": "messages", "stream": "stream", "stream_options": "usage chunk",
+		"tools": "tools", "tool_choice": "tools", "think": "think", "extra_body": "think/options",
+		"max_tokens": "options.num_predict", "max_completion_tokens": "options.num_predict", "stop": "options.stop",
+		"response_format": "format",
+	})
+	// Streamed, n > 1 becomes parallel generations (see streamChoices).
+	openAIChatStreamParams = withParam(openAIChatParams, "n", "parallel choices")
+	openAICompletionParams = withSamplingParams(map[string]string{
+		"model": "model", "prompt": "prompt", "stream": "stream", "think": "think", "extra_body": "think/options",
+		"max_tokens": "options.num_predict", "stop": "options.stop", "suffix": "suffix", "echo": "echo",
+		"stream_options": "usage chunk",
+	})
+	responsesParams = withSamplingParams(map[string]string{
+		"model": "model", "input": "messages", "instructions": "messages", "stream": "stream",
+		"max_output_tokens": "options.num_predict", "tools": "tools", "tool_choice": "tools", "reasoning": "think",
+		"text": "format",
+	})
+)
+
+// withSamplingParams adds samplingParams to a handler's mapping.
+func withSamplingParams(mapping map[string]string) map[string]string {
+	for k, option := range samplingParams {
+		mapping[k] = "options." + option
+	}
+	return mapping
+}
+
+// withParam returns a copy of mapping with one more parameter.
+func withParam(mapping map[string]string, key, to string) map[string]string {
+	copied := make(map[string]string, len(mapping)+1)
+	for k, v := range mapping {
+		copied[k] = v
+	}
+	copied[key] = to
+	return copied
+}
+
+// mapSamplingParams copies the sampling parameters of an OpenAI-format
+// request into Ollama options. A top-level value wins over extra_body, and
+// both win over the configured defaults already in options.
+func mapSamplingParams(client, options map[string]interface{}) {
+	extra, _ := client["extra_body"].(map[string]interface{})
+	for k, option := range samplingParams {
+		if v, ok := client[k]; ok && v != nil {
+			options[option] = v
+		} else if v, ok := extra[k]; ok && v != nil {
+			options[option] = v
+		}
+	}
+}
+
+// ollamaOptionAliases are the OpenAI names a native Ollama request may use
+// for an option, with the option they mean.
+var ollamaOptionAliases = map[string]string{
+	"max_tokens": "num_predict", "max_completion_tokens": "num_predict", "stop_sequences": "stop",
+}
+
+// normalizeOllamaOptions is the other direction, for native /api/chat and
+// /api/generate requests: a sampling parameter, num_predict or stop at the
+// top level (where Ollama ignores it) moves into options, and OpenAI names inside options
+// become Ollama's. Values already in options under the Ollama name are
+// kept. Returns the moves, "from->to", for X-Proxy-Trace.
+func normalizeOllamaOptions(req map[string]interface{}) []string {
+	options, _ := req["options"].(map[string]interface{})
+	var moved []string
+	set := func(from, option string, v interface{}) {
+		if options == nil {
+			options = map[string]interface{}{}
+			req["options"] = options
+		}
+		if _, has := options[option]; !has {
+			options[option] = v
+		}
+		moved = append(moved, from+"->options."+option)
+	}
+	for k, v := range req {
+		option, ok := samplingParams[k]
+		if !ok {
+			option, ok = ollamaOptionAliases[k]
+		}
+		if k == "num_predict" || k == "stop" {
+			option, ok = k, true
+		}
+		if ok {
+			delete(req, k)
+			set(k, option, v)
+		}
+	}
+	for k, v := range options {
+		if option, ok := ollamaOptionAliases[k]; ok {
+			delete(options, k)
+			set("options."+k, option, v)
+		}
+	}
+	sort.Strings(moved)
+	return moved
+}
+
+// silentParams are dropped without a warning: they don't change the answer.
+var silentParams = map[string]bool{"user": true, "metadata": true, "store": true, "service_tier": true}
+
+// noteParams checks a client request against the mapping of its handler
+// (client key -> Ollama field). Parameters with no mapping are dropped; the
+// ones that would have changed the answer are listed in X-Proxy-Warnings
+// instead of being ignored silently. Everything is recorded for
+// X-Proxy-Trace.
+func noteParams(w http.ResponseWriter, r *http.Request, client map[string]interface{}, mapping map[string]string) {
+	noteClientUser(r, client)
+	keys := make([]string, 0, len(client))
+	for k := range client {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var mapped, dropped, unsupported, unenforced []string
+	for _, k := range keys {
+		to, ok := mapping[k]
+		if ok && unenforcedParam(k, client[k]) {
+			unenforced = append(unenforced, fmt.Sprintf("%s=%v", k, client[k]))
+		}
+		switch {
+		case ok && to != k:
+			mapped = append(mapped, k+"->"+to)
+		case ok:
+		default:
+			dropped = append(dropped, k)
+			if !silentParams[k] && !defaultParam(k, client[k]) {
+				unsupported = append(unsupported, k)
+			}
+		}
+	}
+	if len(mapped) > 0 {
+		traceDecision(r, "params mapped", "%s", strings.Join(mapped, ", "))
+	}
+	if len(dropped) > 0 {
+		traceDecision(r, "params dropped", "%s", strings.Join(dropped, ", "))
+	}
+	if len(unsupported) > 0 {
+		warning := "unsupported parameters ignored: " + strings.Join(unsupported, ", ")
+		w.Header().Add(headerWarnings, warning)
+		log.Printf(">>> %s: %s <<<", r.URL.Path, warning)
+	}
+	if len(unenforced) > 0 {
+		warning := "parameters not enforced: " + strings.Join(unenforced, ", ")
+		w.Header().Add(headerWarnings, warning)
+		log.Printf(">>> %s: %s <<<", r.URL.Path, warning)
+	}
+}
+
+// unenforcedParam reports whether a mapped parameter asks for something
+// Ollama can't be made to do: tool_choice "required" (the tools are sent,
+// but the model may answer without calling one).
+func unenforcedParam(key string, value interface{}) bool {
+	return key == "tool_choice" && value == "required"
+}
+
+// defaultParam reports whether value asks for nothing beyond the default
+// (null, false, n=1), so dropping it changes nothing.
+func defaultParam(key string, value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case float64:
+		return key == "n" && v == 1
+	}
+	return false
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"olares-ollama/internal/mdns"
+	"olares-ollama/internal/ollama"
+)
+
+const (
+	peerServiceType = "_olares-ollama._tcp"
+	// peerHeader marks a request forwarded by a peer; it is never
+	// forwarded again.
+	peerHeader = "X-Olares-Peer"
+	// peerExpiry is how many refreshes a discovered peer may miss before
+	// it is dropped.
+	peerExpiry = 3
+	// peerTimeout bounds each peer (and local model list) request of a
+	// refresh.
+	peerTimeout = 5 * time.Second
+)
+
+// peerSet is the tiny inference mesh of ENABLE_PEERS: other olares-ollama
+// instances (PEERS, or found over mDNS with PEER_DISCOVERY=mdns) and the
+// models they serve. A request for a model the local Ollama doesn't have
+// goes to a peer that serves it; the peer runs it through its own pipeline
+// (limits, usage, metrics) like any other client request.
+type peerSet struct {
+	id, name string
+
+	mu    sync.RWMutex
+	peers map[string]*peer // by URL
+	local []string         // models of the local Ollama; nil until listed once
+}
+
+type peer struct {
+	URL      string    `json:"url"`
+	Name     string    `json:"name,omitempty"`
+	Source   string    `json:"source"` // "static" or "mdns"
+	Models   []string  `json:"models"`
+	LastSeen time.Time `json:"last_seen,omitempty"`
+	Error    string    `json:"error,omitempty"`
+
+	id     string // from /api/peer
+	client *ollama.Client
+	missed int // mDNS refreshes it didn't answer
+}
+
+// peerInfo is what GET /api/peer returns.
+type peerInfo struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Models []string `json:"models"`
+}
+
+func newPeerSet(name string) *peerSet {
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &peerSet{id: hex.EncodeToString(id), name: name, peers: map[string]*peer{}}
+}
+
+// runPeers announces this instance (mDNS) and refreshes the peers and the
+// local model list every PEER_REFRESH_SEC.
+func (s *Server) runPeers() {
+	if s.config.PeerDiscovery == "mdns" {
+		go func() {
+			err := mdns.Announce(context.Background(), mdns.Service{
+				Instance: s.peers.name,
+				Type:     peerServiceType,
+				Port:     s.config.Port,
+				TXT:      []string{"id=" + s.peers.id},
+			})
+			if err != nil {
+				log.Printf("!!! Peer discovery: can't announce over mDNS: %v !!!", err)
+			}
+		}()
+	}
+	interval := time.Duration(s.config.PeerRefreshSec) * time.Second
+	for {
+		s.refreshPeers()
+		time.Sleep(interval)
+	}
+}
+
+func (s *Server) refreshPeers() {
+	ctx, cancel := context.WithTimeout(context.Background(), peerTimeout)
+	defer cancel()
+	set := s.peers
+	if models, err := s.ollamaClient.ListModels(ctx); err == nil {
+		local := make([]string, 0, len(models))
+		for _, m := range models {
+			local = append(local, m.Name)
+		}
+		set.mu.Lock()
+		set.local = local
+		set.mu.Unlock()
+	}
+
+	sources := map[string]string{}
+	for _, u := range s.config.Peers {
+		if !strings.Contains(u, "://") {
+			u = "http://" + u
+		}
+		sources[strings.TrimSuffix(u, "/")] = "static"
+	}
+	if s.config.PeerDiscovery == "mdns" {
+		entries, err := mdns.Browse(context.Background(), peerServiceType, time.Second)
+		if err != nil {
+			log.Printf("!!! Peer discovery: mDNS browse failed: %v !!!", err)
+		}
+		set.mu.RLock()
+		for _, e := range entries {
+			if id := txtValue(e.TXT, "id"); id == set.id || set.listed(id) {
+				continue // this instance, or a PEERS entry under another address
+			}
+			u := fmt.Sprintf("http://%s:%d", e.Addr, e.Port)
+			if _, static := sources[u]; !static {
+				sources[u] = "mdns"
+			}
+		}
+		set.mu.RUnlock()
+	}
+
+	set.mu.Lock()
+	for u, p := range set.peers {
+		if _, ok := sources[u]; !ok && p.Source == "mdns" {
+			if p.missed++; p.missed >= peerExpiry {
+				log.Printf("Peer %s (%s) is gone", p.Name, u)
+				delete(set.peers, u)
+			}
+		}
+	}
+	var refresh []*peer
+	for u, source := range sources {
+		p, ok := set.peers[u]
+		if !ok {
+			p = &peer{URL: u, Source: source, client: s.ollamaClient.WithBaseURL(u)}
+			p.client.SetHeader(peerHeader, set.id)
+			set.peers[u] = p
+		}
+		p.missed = 0
+		refresh = append(refresh, p)
+	}
+	set.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range refresh {
+		wg.Add(1)
+		go func(p *peer) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), peerTimeout)
+			defer cancel()
+			info, err := fetchPeerInfo(ctx, p.client)
+			set.mu.Lock()
+			defer set.mu.Unlock()
+			switch {
+			case err != nil:
+				if p.Error == "" {
+					log.Printf("!!! Peer %s unreachable: %v !!!", p.URL, err)
+				}
+				p.Error, p.Models = err.Error(), nil
+			case info.ID == set.id:
+				delete(set.peers, p.URL) // PEERS lists this instance
+			default:
+				if p.Error != "" || p.LastSeen.IsZero() {
+					log.Printf("Peer %s (%s) serves %s", info.Name, p.URL, strings.Join(info.Models, ", "))
+				}
+				p.id, p.Name, p.Models, p.LastSeen, p.Error = info.ID, info.Name, info.Models, time.Now(), ""
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+// listed reports whether a static peer has the instance id. Call with mu
+// held.
+func (set *peerSet) listed(id string) bool {
+	for _, p := range set.peers {
+		if p.Source == "static" && p.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func fetchPeerInfo(ctx context.Context, client *ollama.Client) (*peerInfo, error) {
+	resp, err := client.ProxyRequestContext(ctx, "GET", "/api/peer", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("/api/peer returned %d (ENABLE_PEERS off?)", resp.StatusCode)
+	}
+	var info peerInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("invalid /api/peer response: %w", err)
+	}
+	return &info, nil
+}
+
+func txtValue(txt []string, key string) string {
+	for _, kv := range txt {
+		if k, v, ok := strings.Cut(kv, "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// peerFor returns the client of a peer serving the model the client asked
+// for, or the model the request ends up with, when the local Ollama doesn't
+// have it; nil keeps the request local. Requests forwarded by a peer stay
+// local.
+func (s *Server) peerFor(r *http.Request, model string) *ollama.Client {
+	set := s.peers
+	if set == nil || r.Header.Get(peerHeader) != "" {
+		return nil
+	}
+	meta := metaFrom(r)
+	var requested string
+	if meta != nil {
+		meta.update(func(m *requestMeta) { requested = m.requestedModel })
+	}
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	if set.local == nil {
+		return nil
+	}
+	for _, want := range []string{requested, model} {
+		if want == "" || containsModel(set.local, want) {
+			continue
+		}
+		urls := make([]string, 0, len(set.peers))
+		for u := range set.peers {
+			urls = append(urls, u)
+		}
+		sort.Strings(urls)
+		for _, u := range urls {
+			if p := set.peers[u]; p.Error == "" && containsModel(p.Models, want) {
+				traceDecision(r, "upstream", "peer %s %s (no local %s)", p.Name, p.URL, want)
+				log.Printf(">>> Forwarding %s for model %s to peer %s (%s) <<<", r.URL.Path, want, p.Name, p.URL)
+				if meta != nil {
+					meta.update(func(m *requestMeta) { m.servedModel = want })
+				}
+				return p.client
+			}
+		}
+	}
+	return nil
+}
+
+func containsModel(names []string, model string) bool {
+	for _, name := range names {
+		if matchesModel(name, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// servedModels are the models this instance answers for: the configured
+// one, else (base mode) whatever the local Ollama has.
+func (s *Server) servedModels() []string {
+	if m := s.model(); m != "" {
+		return []string{m}
+	}
+	s.peers.mu.RLock()
+	defer s.peers.mu.RUnlock()
+	return append([]string{}, s.peers.local...)
+}
+
+// handlePeerInfo handles GET /api/peer, how peers learn what this
+// instance serves.
+func (s *Server) handlePeerInfo(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, peerInfo{ID: s.peers.id, Name: s.peers.name, Models: s.servedModels()})
+}
+
+// handleAdminPeers handles GET /admin/peers.
+func (s *Server) handleAdminPeers(w http.ResponseWriter, r *http.Request) {
+	set := s.peers
+	set.mu.RLock()
+	peers := make([]peer, 0, len(set.peers))
+	for _, p := range set.peers {
+		peers = append(peers, *p)
+	}
+	set.mu.RUnlock()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].URL < peers[j].URL })
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":      set.name,
+		"discovery": s.config.PeerDiscovery,
+		"served":    s.servedModels(),
+		"peers":     peers,
+	})
+}
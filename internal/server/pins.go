@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pin protects a model the user set up in Ollama from what the proxy does on
+// its own: hot-models unloads, Ollama's idle unload, and re-creating the
+// model in GGUF mode.
+type pin struct {
+	Model    string    `json:"model"`
+	Note     string    `json:"note,omitempty"`
+	Source   string    `json:"source"` // "config" (PINNED_MODELS) or "admin"
+	PinnedAt time.Time `json:"pinned_at,omitzero"`
+}
+
+// pinStore holds the PINNED_MODELS pins and those added via the admin API,
+// which are persisted to data/pinned_models.json.
+type pinStore struct {
+	mu   sync.RWMutex
+	pins map[string]*pin
+	file string
+}
+
+func newPinStore(configured []string) *pinStore {
+	st := &pinStore{
+		pins: make(map[string]*pin),
+		file: filepath.Join("data", "pinned_models.json"),
+	}
+	if data, err := os.ReadFile(st.file); err == nil {
+		var list []*pin
+		if err := json.Unmarshal(data, &list); err != nil {
+			log.Printf("Warning: failed to parse %s: %v", st.file, err)
+		}
+		for _, p := range list {
+			st.pins[p.Model] = p
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("Warning: failed to read %s: %v", st.file, err)
+	}
+	for _, m := range configured {
+		st.pins[m] = &pin{Model: m, Source: "config"}
+	}
+	if len(st.pins) > 0 {
+		log.Printf("Pinned models: %s", strings.Join(st.names(), ", "))
+	}
+	return st
+}
+
+func (st *pinStore) names() []string {
+	names := make([]string, 0, len(st.pins))
+	for m := range st.pins {
+		names = append(names, m)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// find returns the pin matching an Ollama model name, if any.
+func (st *pinStore) find(name string) (*pin, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	if p, ok := st.pins[name]; ok {
+		return p, true
+	}
+	for m, p := range st.pins {
+		if matchesModel(name, m) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+func (st *pinStore) list() []*pin {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	out := make([]*pin, 0, len(st.pins))
+	for _, m := range st.names() {
+		out = append(out, st.pins[m])
+	}
+	return out
+}
+
+func (st *pinStore) put(p *pin) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.pins[p.Model] = p
+	return st.saveLocked()
+}
+
+func (st *pinStore) delete(model string) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.pins[model]; !ok {
+		return false, nil
+	}
+	delete(st.pins, model)
+	return true, st.saveLocked()
+}
+
+// saveLocked writes the admin pins; config pins come from the environment.
+func (st *pinStore) saveLocked() error {
+	list := make([]*pin, 0, len(st.pins))
+	for _, m := range st.names() {
+		if p := st.pins[m]; p.Source == "admin" {
+			list = append(list, p)
+		}
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(st.file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(st.file, data, 0644)
+}
+
+// ModelPinned reports whether name is pinned, for main's model setup.
+func (s *Server) ModelPinned(name string) bool {
+	_, ok := s.pins.find(name)
+	return ok
+}
+
+// applyPinnedKeepAlive keeps a pinned model loaded after the request instead
+// of letting Ollama unload it when idle. A keep_alive the client sent wins.
+func (s *Server) applyPinnedKeepAlive(req map[string]interface{}) {
+	model, _ := req["model"].(string)
+	if _, set := req["keep_alive"]; set || model == "" || !s.ModelPinned(model) {
+		return
+	}
+	req["keep_alive"] = -1
+}
+
+// registerPinRoutes adds the pinned models admin API.
+func (s *Server) registerPinRoutes() {
+	s.adminRoute("/admin/pins", s.handlePinList, "GET")
+	s.adminRoute("/admin/pins/{model...}", s.handlePinPut, "PUT")
+	s.adminRoute("/admin/pins/{model...}", s.handlePinDelete, "DELETE")
+}
+
+func (s *Server) handlePinList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"pins": s.pins.list()})
+}
+
+// handlePinPut pins a model. Body (optional): {"note": "..."}.
+func (s *Server) handlePinPut(w http.ResponseWriter, r *http.Request) {
+	model := strings.TrimSpace(r.PathValue("model"))
+	if model == "" {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Model name is required")
+		return
+	}
+	var p pin
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+			return
+		}
+	}
+	if existing, ok := s.pins.find(model); ok && existing.Source == "config" && existing.Model == model {
+		writeJSON(w, http.StatusOK, existing)
+		return
+	}
+	p.Model, p.Source, p.PinnedAt = model, "admin", time.Now()
+	if err := s.pins.put(&p); err != nil {
+		log.Printf("!!! Failed to save pinned models: %v !!!", err)
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Failed to save pin: "+err.Error())
+		return
+	}
+	log.Printf("Model pinned: %s", model)
+	s.events.Record("model_pinned", map[string]interface{}{"model": model})
+	writeJSON(w, http.StatusOK, &p)
+}
+
+// handlePinDelete unpins a model pinned via the admin API; PINNED_MODELS
+// pins can only be removed from the configuration.
+func (s *Server) handlePinDelete(w http.ResponseWriter, r *http.Request) {
+	model := r.PathValue("model")
+	if p, ok := s.pins.find(model); ok && p.Model == model && p.Source == "config" {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "pinned_by_config", "Model is pinned by PINNED_MODELS: "+model)
+		return
+	}
+	if p, ok := s.pins.find(model); ok && p.Model == model && p.Source == pinSourceDesired {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "pinned_by_desired_state", "Model is kept warm by the desired state: "+model)
+		return
+	}
+	ok, err := s.pins.delete(model)
+	if err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Failed to save pins: "+err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "pin_not_found", "Model is not pinned: "+model)
+		return
+	}
+	log.Printf("Model unpinned: %s", model)
+	s.events.Record("model_unpinned", map[string]interface{}{"model": model})
+	w.WriteHeader(http.StatusNoContent)
+}
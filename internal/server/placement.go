@@ -0,0 +1,282 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"olares-ollama/internal/config"
+	"olares-ollama/internal/ollama"
+)
+
+// placementProbeTimeout bounds the /api/tags and /api/ps calls that size
+// up a server.
+const placementProbeTimeout = 10 * time.Second
+
+// placement is where a model lives when several Ollama servers are
+// configured (OLLAMA_UPSTREAMS): found there, or pulled there because it
+// had the most room.
+type placement struct {
+	Model    string    `json:"model"`
+	Backend  string    `json:"backend"` // the server's configured URL
+	Source   string    `json:"source"`  // "found" or "pulled"
+	PlacedAt time.Time `json:"placed_at"`
+}
+
+// placementStore holds the model -> server assignments, persisted to
+// data/model_placements.json, the pulls in progress and the last failures.
+type placementStore struct {
+	mu      sync.RWMutex
+	byModel map[string]*placement
+	pulling map[string]string // model -> backend
+	failed  map[string]string // model -> error
+	file    string
+}
+
+func newPlacementStore() *placementStore {
+	st := &placementStore{
+		byModel: map[string]*placement{},
+		pulling: map[string]string{},
+		failed:  map[string]string{},
+		file:    filepath.Join("data", "model_placements.json"),
+	}
+	if data, err := os.ReadFile(st.file); err == nil {
+		var list []*placement
+		if err := json.Unmarshal(data, &list); err != nil {
+			log.Printf("Warning: failed to parse %s: %v", st.file, err)
+		}
+		for _, p := range list {
+			st.byModel[p.Model] = p
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("Warning: failed to read %s: %v", st.file, err)
+	}
+	return st
+}
+
+func (st *placementStore) get(model string) (*placement, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	if p, ok := st.byModel[model]; ok {
+		return p, true
+	}
+	for name, p := range st.byModel {
+		if matchesModel(model, name) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+func (st *placementStore) set(p *placement) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.byModel[p.Model] = p
+	delete(st.pulling, p.Model)
+	delete(st.failed, p.Model)
+	if err := st.saveLocked(); err != nil {
+		log.Printf("!!! Failed to save %s: %v !!!", st.file, err)
+	}
+}
+
+func (st *placementStore) saveLocked() error {
+	list := make([]*placement, 0, len(st.byModel))
+	for _, p := range st.byModel {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Model < list[j].Model })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(st.file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(st.file, data, 0644)
+}
+
+// backendRoom is what a server has left, as far as Ollama tells: its
+// OLLAMA_CAPACITY minus the VRAM of the loaded models (/api/ps) and the
+// size of the installed ones (/api/tags). Without a capacity the free
+// figure is minus what is used, so the least used server wins.
+type backendRoom struct {
+	URL      string `json:"backend"`
+	FreeVRAM int64  `json:"free_vram"`
+	FreeDisk int64  `json:"free_disk"`
+	Known    bool   `json:"capacity_known"`
+	Error    string `json:"error,omitempty"`
+
+	hasModel bool
+}
+
+func (s *Server) measureBackend(ctx context.Context, url string, client *ollama.Client, capacity config.Capacity, model string) backendRoom {
+	room := backendRoom{URL: url, FreeVRAM: capacity.VRAM, FreeDisk: capacity.Disk, Known: capacity.VRAM > 0 || capacity.Disk > 0}
+	models, err := client.ListModels(ctx)
+	if err != nil {
+		room.Error = err.Error()
+		return room
+	}
+	for _, m := range models {
+		room.FreeDisk -= m.Size
+		if matchesModel(m.Name, model) {
+			room.hasModel = true
+		}
+	}
+	running, err := client.RunningModels(ctx)
+	if err != nil {
+		room.Error = err.Error()
+		return room
+	}
+	for _, m := range running {
+		room.FreeVRAM -= m.SizeVRAM
+	}
+	return room
+}
+
+// placeModel returns the server of model: its recorded placement, else a
+// server that has it, else the one with the most free VRAM (then disk),
+// which the model is pulled onto. The assignment is remembered and routes
+// the model's requests from then on.
+func (s *Server) placeModel(model string) (*placement, error) {
+	set := s.upstreams
+	if set == nil {
+		return nil, fmt.Errorf("model placement needs several Ollama servers (OLLAMA_UPSTREAMS)")
+	}
+	if p, ok := s.placements.get(model); ok {
+		if _, known := set.byURL[p.Backend]; known {
+			return p, nil
+		}
+	}
+	capacities, _ := config.ParseCapacities(s.config.OllamaCapacity) // checked by Validate
+	ctx, cancel := context.WithTimeout(context.Background(), placementProbeTimeout)
+	rooms := s.measureBackends(ctx, capacities, model)
+	cancel()
+
+	var best *backendRoom
+	for i := range rooms {
+		room := &rooms[i]
+		if room.Error != "" {
+			continue
+		}
+		if room.hasModel {
+			p := &placement{Model: model, Backend: room.URL, Source: "found", PlacedAt: time.Now()}
+			s.placements.set(p)
+			log.Printf("Placement: %s is on %s", model, room.URL)
+			return p, nil
+		}
+		if best == nil || room.FreeVRAM > best.FreeVRAM || (room.FreeVRAM == best.FreeVRAM && room.FreeDisk > best.FreeDisk) {
+			best = room
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no Ollama server reachable to place %s on", model)
+	}
+
+	s.placements.mu.Lock()
+	if backend, busy := s.placements.pulling[model]; busy {
+		s.placements.mu.Unlock()
+		return nil, fmt.Errorf("%s is already being pulled onto %s", model, backend)
+	}
+	s.placements.pulling[model] = best.URL
+	s.placements.mu.Unlock()
+
+	log.Printf("Placement: pulling %s onto %s (free VRAM %.1f GB, disk %.1f GB)", model, best.URL,
+		float64(best.FreeVRAM)/(1<<30), float64(best.FreeDisk)/(1<<30))
+	start := time.Now()
+	if err := set.byURL[best.URL].PullModelWithProgress(model, quietProgress{}); err != nil {
+		err = fmt.Errorf("pulling %s onto %s: %w", model, best.URL, err)
+		s.placements.mu.Lock()
+		delete(s.placements.pulling, model)
+		s.placements.failed[model] = err.Error()
+		s.placements.mu.Unlock()
+		return nil, err
+	}
+	p := &placement{Model: model, Backend: best.URL, Source: "pulled", PlacedAt: time.Now()}
+	s.placements.set(p)
+	log.Printf("Placement: %s pulled onto %s in %s", model, best.URL, time.Since(start).Round(time.Second))
+	s.events.Record("model_placed", map[string]interface{}{"model": model, "backend": best.URL, "duration_ms": time.Since(start).Milliseconds()})
+	return p, nil
+}
+
+func (s *Server) measureBackends(ctx context.Context, capacities map[string]config.Capacity, model string) []backendRoom {
+	urls := make([]string, 0, len(s.upstreams.byURL))
+	for u := range s.upstreams.byURL {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+	rooms := make([]backendRoom, len(urls))
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			rooms[i] = s.measureBackend(ctx, u, s.upstreams.byURL[u], capacities[u], model)
+		}(i, u)
+	}
+	wg.Wait()
+	return rooms
+}
+
+// handlePlacementList handles GET /admin/placements: the assignments, the
+// pulls in progress and the room of each server.
+func (s *Server) handlePlacementList(w http.ResponseWriter, r *http.Request) {
+	st := s.placements
+	st.mu.RLock()
+	list := make([]*placement, 0, len(st.byModel))
+	for _, p := range st.byModel {
+		list = append(list, p)
+	}
+	pulling, failed := make(map[string]string, len(st.pulling)), make(map[string]string, len(st.failed))
+	for m, b := range st.pulling {
+		pulling[m] = b
+	}
+	for m, e := range st.failed {
+		failed[m] = e
+	}
+	st.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Model < list[j].Model })
+
+	out := map[string]interface{}{"placements": list, "pulling": pulling, "failed": failed}
+	if s.upstreams != nil {
+		capacities, _ := config.ParseCapacities(s.config.OllamaCapacity)
+		ctx, cancel := context.WithTimeout(r.Context(), placementProbeTimeout)
+		out["backends"] = s.measureBackends(ctx, capacities, "")
+		cancel()
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handlePlacementPut handles POST /admin/placements {"model": "..."}:
+// places the model, pulling it in the background when no server has it
+// (202; follow the pull in GET /admin/placements).
+func (s *Server) handlePlacementPut(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model string `json:"model"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Model == "" {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", `body must be {"model": "<name>"}`)
+		return
+	}
+	if s.upstreams == nil {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "placement_unavailable",
+			"Model placement needs several Ollama servers: set OLLAMA_UPSTREAMS")
+		return
+	}
+	if p, ok := s.placements.get(req.Model); ok {
+		writeJSON(w, http.StatusOK, p)
+		return
+	}
+	go func() {
+		if _, err := s.placeModel(req.Model); err != nil {
+			log.Printf("!!! Placement of %s failed: %v !!!", req.Model, err)
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"model": req.Model, "status": "placing"})
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// PprofHandler returns a handler serving the net/http/pprof endpoints under
+// /debug/pprof/. It is mounted on the main mux (behind the admin role) or
+// the admin listener when ENABLE_PPROF is set, or served on its own
+// localhost listener when PPROF_PORT is also set.
+func PprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	registerPprof(mux, nil)
+	return mux
+}
+
+// registerPprof adds the pprof routes to mux, each wrapped with wrap when
+// it is not nil.
+func registerPprof(mux *http.ServeMux, wrap func(http.HandlerFunc) http.HandlerFunc) {
+	for path, h := range map[string]http.HandlerFunc{
+		"/debug/pprof/":        pprof.Index,
+		"/debug/pprof/cmdline": pprof.Cmdline,
+		"/debug/pprof/profile": pprof.Profile,
+		"/debug/pprof/symbol":  pprof.Symbol,
+		"/debug/pprof/trace":   pprof.Trace,
+	} {
+		if wrap != nil {
+			h = wrap(h)
+		}
+		mux.HandleFunc(path, h)
+	}
+}
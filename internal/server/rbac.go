@@ -0,0 +1,313 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// role is what an admin credential may do. ADMIN_TOKEN is admin; role keys
+// (/admin/keys) have the role they were created with.
+type role string
+
+const (
+	roleViewer   role = "viewer"   // reads status, usage and settings
+	roleOperator role = "operator" // also pulls, switches and tests models, changes settings
+	roleAdmin    role = "admin"    // also manages keys and links, deletes models, restores state
+)
+
+var roleRank = map[role]int{roleViewer: 1, roleOperator: 2, roleAdmin: 3}
+
+func (r role) atLeast(min role) bool {
+	return roleRank[r] >= roleRank[min]
+}
+
+// adminRoleRules are the admin endpoints that need more than the default:
+// viewer to read (GET), operator for anything else. "*" is any method.
+var adminRoleRules = []struct {
+	method string
+	path   string
+	role   role
+}{
+	{"*", "/admin/keys", roleAdmin},
+	{"*", "/admin/keys/{id}", roleAdmin},
+	{"POST", "/admin/status-link", roleAdmin},
+	{"*", "/admin/state/export", roleAdmin}, // key hashes and the whole usage history
+	{"*", "/admin/state/import", roleAdmin},
+	{"POST", "/admin/models/{name}/delete", roleAdmin},
+	{"PUT", "/admin/desired-state", roleAdmin}, // may prune models
+}
+
+// requiredRole is the role a request to the admin endpoint path needs.
+func requiredRole(method, path string) role {
+	for _, rule := range adminRoleRules {
+		if rule.path == path && (rule.method == "*" || rule.method == method) {
+			return rule.role
+		}
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return roleViewer
+	}
+	return roleOperator
+}
+
+// adminCredential is the token r carries: X-Admin-Token, else a Bearer token.
+func adminCredential(r *http.Request) string {
+	got := r.Header.Get("X-Admin-Token")
+	if auth := r.Header.Get("Authorization"); got == "" && strings.HasPrefix(auth, "Bearer ") {
+		got = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return got
+}
+
+// roleOf returns the role of r's credential: admin for ADMIN_TOKEN (and for
+// everyone when none is set), the key's role for a role key.
+func (s *Server) roleOf(r *http.Request) (role, bool) {
+	want := s.config.AdminToken
+	if want == "" {
+		return roleAdmin, true
+	}
+	got := adminCredential(r)
+	if got == "" {
+		return "", false
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1 {
+		return roleAdmin, true
+	}
+	if k, ok := s.adminKeys.find(got); ok {
+		return k.Role, true
+	}
+	return "", false
+}
+
+// hasRole reports whether r's credential has at least role min.
+func (s *Server) hasRole(r *http.Request, min role) bool {
+	got, ok := s.roleOf(r)
+	return ok && got.atLeast(min)
+}
+
+// requireRole wraps h with the role check: 401 without a valid credential,
+// 403 when its role is too low.
+func (s *Server) requireRole(min role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.authorize(w, r, min, h)
+	}
+}
+
+// pullAction gates the public endpoints that start pulls or change the
+// served model (retry, setup, download confirmations): with ADMIN_TOKEN set
+// they need the operator role. A status link (STATUS_PAGE_AUTH) only shows
+// the progress page, it never passes here.
+func (s *Server) pullAction(h http.HandlerFunc) http.HandlerFunc {
+	return s.requireRole(roleOperator, h)
+}
+
+// authorizeAdmin wraps the handler of the admin endpoint path with the role
+// check adminRoleRules give its method.
+func (s *Server) authorizeAdmin(path string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.authorize(w, r, requiredRole(r.Method, path), h)
+	}
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, min role, h http.HandlerFunc) {
+	got, ok := s.roleOf(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeError(w, ollamaErrorFormat, http.StatusUnauthorized, "unauthorized", "Admin token or role key required")
+		return
+	}
+	if !got.atLeast(min) {
+		writeError(w, ollamaErrorFormat, http.StatusForbidden, "forbidden",
+			"This needs the "+string(min)+" role; the key has "+string(got))
+		return
+	}
+	h(w, r)
+}
+
+// adminKey is a role key. Only a hash of the key is kept; the key itself is
+// shown once, when it is created.
+type adminKey struct {
+	ID        string    `json:"id"` // "key#<hash>", the subject user routes and tenant limits use
+	Name      string    `json:"name,omitempty"`
+	Role      role      `json:"role"`
+	Hash      string    `json:"hash,omitempty"` // SHA-256 of the key
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// adminKeyStore persists role keys to data/admin_keys.json.
+type adminKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]*adminKey // by hash
+	file string
+}
+
+func newAdminKeyStore() *adminKeyStore {
+	st := &adminKeyStore{
+		keys: make(map[string]*adminKey),
+		file: filepath.Join("data", "admin_keys.json"),
+	}
+	data, err := os.ReadFile(st.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read %s: %v", st.file, err)
+		}
+		return st
+	}
+	var list []*adminKey
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Warning: failed to parse %s: %v", st.file, err)
+		return st
+	}
+	for _, k := range list {
+		st.keys[k.Hash] = k
+	}
+	return st
+}
+
+func hashAdminKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (st *adminKeyStore) find(key string) (*adminKey, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	k, ok := st.keys[hashAdminKey(key)]
+	return k, ok
+}
+
+// list returns the keys, oldest first, without their hashes.
+func (st *adminKeyStore) list() []adminKey {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	out := make([]adminKey, 0, len(st.keys))
+	for _, k := range st.keys {
+		view := *k
+		view.Hash = ""
+		out = append(out, view)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+func (st *adminKeyStore) add(k *adminKey) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.keys[k.Hash] = k
+	return st.saveLocked()
+}
+
+func (st *adminKeyStore) delete(id string) (*adminKey, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for hash, k := range st.keys {
+		if k.ID == id {
+			delete(st.keys, hash)
+			return k, st.saveLocked()
+		}
+	}
+	return nil, nil
+}
+
+func (st *adminKeyStore) saveLocked() error {
+	list := make([]*adminKey, 0, len(st.keys))
+	for _, k := range st.keys {
+		list = append(list, k)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(st.file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(st.file, data, 0600)
+}
+
+// registerAdminKeyRoutes adds the role keys admin API.
+func (s *Server) registerAdminKeyRoutes() {
+	s.adminRoute("/admin/keys", s.handleAdminKeyList, "GET")
+	s.adminRoute("/admin/keys", s.handleAdminKeyCreate, "POST")
+	s.adminRoute("/admin/keys/{id}", s.handleAdminKeyDelete, "DELETE")
+}
+
+func (s *Server) handleAdminKeyList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": s.adminKeys.list()})
+}
+
+// handleAdminKeyCreate creates a role key. Body: {"name": "grafana", "role":
+// "viewer"}. The response is the only time the key is shown.
+func (s *Server) handleAdminKeyCreate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+		Role role   `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+		return
+	}
+	if _, ok := roleRank[req.Role]; !ok {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "'role' must be viewer, operator or admin")
+		return
+	}
+	if s.config.AdminToken == "" {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "no_admin_token",
+			"Role keys only apply with ADMIN_TOKEN set; without it the admin endpoints are open")
+		return
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "internal_error", "Failed to generate a key: "+err.Error())
+		return
+	}
+	key := "ook_" + base64.RawURLEncoding.EncodeToString(raw)
+	k := &adminKey{ID: apiKeySubject(key), Name: strings.TrimSpace(req.Name), Role: req.Role, Hash: hashAdminKey(key), CreatedAt: time.Now().UTC()}
+	if err := s.adminKeys.add(k); err != nil {
+		log.Printf("!!! Failed to save role keys: %v !!!", err)
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Failed to save the key: "+err.Error())
+		return
+	}
+	log.Printf("WARNING: %s key %s (%s) created by admin", k.Role, k.ID, k.Name)
+	s.events.Record("admin_key_created", map[string]interface{}{"id": k.ID, "name": k.Name, "role": k.Role})
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":         k.ID,
+		"name":       k.Name,
+		"role":       k.Role,
+		"key":        key,
+		"created_at": k.CreatedAt,
+	})
+}
+
+// handleAdminKeyDelete revokes a role key by id: "key#<hash>" with the "#"
+// sent as %23, or the hash alone.
+func (s *Server) handleAdminKeyDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !strings.HasPrefix(id, "key#") {
+		id = "key#" + id
+	}
+	k, err := s.adminKeys.delete(id)
+	if err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Failed to save role keys: "+err.Error())
+		return
+	}
+	if k == nil {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "key_not_found", "No role key "+id)
+		return
+	}
+	log.Printf("WARNING: %s key %s (%s) revoked by admin", k.Role, k.ID, k.Name)
+	s.events.Record("admin_key_revoked", map[string]interface{}{"id": k.ID, "name": k.Name, "role": k.Role})
+	w.WriteHeader(http.StatusNoContent)
+}
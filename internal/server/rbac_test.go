@@ -0,0 +1,115 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"olares-ollama/internal/config"
+)
+
+func TestRequiredRole(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         role
+	}{
+		// the default: viewer reads, operator for anything else
+		{"GET", "/admin/tenants", roleViewer},
+		{"HEAD", "/admin/pins", roleViewer},
+		{"GET", "/admin/desired-state", roleViewer},
+		{"POST", "/admin/smoketest", roleOperator},
+		{"PUT", "/admin/loglevel", roleOperator},
+		{"DELETE", "/admin/pins/{model...}", roleOperator},
+		// adminRoleRules
+		{"GET", "/admin/keys", roleAdmin},
+		{"POST", "/admin/keys", roleAdmin},
+		{"DELETE", "/admin/keys/{id}", roleAdmin},
+		{"POST", "/admin/status-link", roleAdmin},
+		{"GET", "/admin/state/export", roleAdmin},
+		{"POST", "/admin/state/import", roleAdmin},
+		{"POST", "/admin/models/{name}/delete", roleAdmin},
+		{"PUT", "/admin/desired-state", roleAdmin},
+	}
+	for _, tc := range tests {
+		if got := requiredRole(tc.method, tc.path); got != tc.want {
+			t.Errorf("requiredRole(%s %s) = %s, want %s", tc.method, tc.path, got, tc.want)
+		}
+	}
+}
+
+// rbacServer is a Server with ADMIN_TOKEN "admin-token" and a role key
+// "<role>-key" for viewer and operator.
+func rbacServer(t *testing.T) *Server {
+	st := &adminKeyStore{keys: make(map[string]*adminKey), file: filepath.Join(t.TempDir(), "admin_keys.json")}
+	for _, r := range []role{roleViewer, roleOperator} {
+		key := string(r) + "-key"
+		st.keys[hashAdminKey(key)] = &adminKey{ID: apiKeySubject(key), Role: r, Hash: hashAdminKey(key)}
+	}
+	return &Server{config: &config.Config{AdminToken: "admin-token"}, adminKeys: st}
+}
+
+func TestAuthorize(t *testing.T) {
+	s := rbacServer(t)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		credential []string // header name/value
+		want       int
+	}{
+		{"no credential", "GET", "/admin/tenants", nil, http.StatusUnauthorized},
+		{"unknown key", "GET", "/admin/tenants", []string{"X-Admin-Token", "nope"}, http.StatusUnauthorized},
+		{"viewer reads", "GET", "/admin/tenants", []string{"X-Admin-Token", "viewer-key"}, http.StatusNoContent},
+		{"viewer writes", "POST", "/admin/smoketest", []string{"X-Admin-Token", "viewer-key"}, http.StatusForbidden},
+		{"operator writes", "POST", "/admin/smoketest", []string{"Authorization", "Bearer operator-key"}, http.StatusNoContent},
+		{"operator on keys", "GET", "/admin/keys", []string{"X-Admin-Token", "operator-key"}, http.StatusForbidden},
+		{"operator deletes a model", "POST", "/admin/models/{name}/delete", []string{"X-Admin-Token", "operator-key"}, http.StatusForbidden},
+		{"admin token", "POST", "/admin/state/import", []string{"Authorization", "Bearer admin-token"}, http.StatusNoContent},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.credential != nil {
+				req.Header.Set(tc.credential[0], tc.credential[1])
+			}
+			rec := httptest.NewRecorder()
+			s.authorizeAdmin(tc.path, ok)(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+			if tc.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("a 401 must carry WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestPullActionNeedsOperator(t *testing.T) {
+	s := rbacServer(t)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	for key, want := range map[string]int{
+		"":             http.StatusUnauthorized,
+		"viewer-key":   http.StatusForbidden,
+		"operator-key": http.StatusNoContent,
+		"admin-token":  http.StatusNoContent,
+	} {
+		req := httptest.NewRequest("POST", "/api/retry", nil)
+		if key != "" {
+			req.Header.Set("X-Admin-Token", key)
+		}
+		rec := httptest.NewRecorder()
+		s.pullAction(ok)(rec, req)
+		if rec.Code != want {
+			t.Errorf("POST /api/retry with %q: status %d, want %d", key, rec.Code, want)
+		}
+	}
+
+	s.config.AdminToken = ""
+	rec := httptest.NewRecorder()
+	s.pullAction(ok)(rec, httptest.NewRequest("POST", "/api/retry", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("without ADMIN_TOKEN: status %d, want the endpoint open", rec.Code)
+	}
+}
@@ -0,0 +1,406 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"olares-ollama/internal/grpcwire"
+	"olares-ollama/internal/websocket"
+)
+
+// realtimeMaxMessage bounds one client event on /v1/realtime.
+const realtimeMaxMessage = 1 << 20
+
+// realtimeItem is a conversation item of a realtime session. Only text
+// messages exist: no audio, no function calls.
+type realtimeItem struct {
+	ID      string            `json:"id"`
+	Object  string            `json:"object"`
+	Type    string            `json:"type"`
+	Role    string            `json:"role"`
+	Status  string            `json:"status"` // "completed", or "incomplete" for a reply that was cut off
+	Content []realtimeContent `json:"content"`
+}
+
+type realtimeContent struct {
+	Type string `json:"type"` // "input_text" (user, system) or "text" (assistant)
+	Text string `json:"text"`
+}
+
+// realtimeConfig is what session.update may change.
+type realtimeConfig struct {
+	Model           string      `json:"model,omitempty"`
+	Instructions    string      `json:"instructions"`
+	Temperature     *float64    `json:"temperature,omitempty"`
+	MaxOutputTokens interface{} `json:"max_response_output_tokens,omitempty"` // a number or "inf"
+	Modalities      []string    `json:"modalities"`
+}
+
+// realtimeResponse is the generation in progress.
+type realtimeResponse struct {
+	id     string
+	cancel context.CancelFunc
+	reason string // why it was cancelled: "client_cancelled" or "turn_detected"
+	done   chan struct{}
+}
+
+// realtimeSession is one /v1/realtime connection: the conversation so far,
+// the session settings, the text input being typed and the response being
+// generated, if any.
+type realtimeSession struct {
+	s    *Server
+	conn *websocket.Conn
+	r    *http.Request // the upgrade request; replies run with its headers
+	id   string
+	seq  atomic.Int64 // for event and item ids
+
+	mu     sync.Mutex // guards the fields below
+	config realtimeConfig
+	items  []realtimeItem
+	input  strings.Builder
+	active *realtimeResponse
+}
+
+// handleRealtime serves GET /v1/realtime (ENABLE_REALTIME): an experimental
+// WebSocket session in the shape of OpenAI's Realtime API, text only. The
+// client adds input (input_text_buffer.append/commit or
+// conversation.item.create) and asks for a reply with response.create; the
+// reply streams as response.text.delta events and is generated through
+// /api/chat with the whole conversation. New user input while a reply is
+// streaming interrupts it (barge-in), as does response.cancel.
+func (s *Server) handleRealtime(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r, realtimeMaxMessage)
+	if err != nil {
+		var he *websocket.HandshakeError
+		if errors.As(err, &he) {
+			writeError(w, openAIErrorFormat, he.Status, "invalid_request", he.Message)
+			return
+		}
+		log.Printf("!!! Realtime upgrade failed: %v !!!", err)
+		return
+	}
+	sess := &realtimeSession{
+		s: s, conn: conn, r: r,
+		id:     fmt.Sprintf("sess_%d", time.Now().UnixNano()),
+		config: realtimeConfig{Modalities: []string{"text"}},
+	}
+	log.Printf(">>> Realtime session %s opened from %s <<<", sess.id, r.RemoteAddr)
+	sess.send("session.created", map[string]interface{}{"session": sess.sessionObject()})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if !errors.Is(err, websocket.ErrClosed) {
+				log.Printf(">>> Realtime session %s ended: %v <<<", sess.id, err)
+			}
+			break
+		}
+		sess.handle(data)
+	}
+	sess.interrupt("client_cancelled")
+	conn.Close(websocket.CloseNormal, "")
+	log.Printf(">>> Realtime session %s closed <<<", sess.id)
+}
+
+// handle runs one client event.
+func (sess *realtimeSession) handle(data []byte) {
+	var ev struct {
+		Type     string          `json:"type"`
+		EventID  string          `json:"event_id"`
+		Session  json.RawMessage `json:"session"`
+		Item     *realtimeItem   `json:"item"`
+		Delta    string          `json:"delta"`
+		Response *struct {
+			Instructions string `json:"instructions"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(data, &ev); err != nil {
+		sess.sendError("", "invalid_json", "Invalid JSON: "+err.Error())
+		return
+	}
+	switch ev.Type {
+	case "session.update":
+		sess.mu.Lock()
+		err := json.Unmarshal(ev.Session, &sess.config)
+		sess.mu.Unlock()
+		if err != nil || len(ev.Session) == 0 {
+			sess.sendError(ev.EventID, "invalid_session", "'session' must be an object")
+			return
+		}
+		sess.send("session.updated", map[string]interface{}{"session": sess.sessionObject()})
+
+	case "input_text_buffer.append":
+		sess.interrupt("turn_detected")
+		sess.mu.Lock()
+		sess.input.WriteString(ev.Delta)
+		sess.mu.Unlock()
+
+	case "input_text_buffer.commit":
+		sess.mu.Lock()
+		text := sess.input.String()
+		sess.input.Reset()
+		sess.mu.Unlock()
+		if strings.TrimSpace(text) == "" {
+			sess.sendError(ev.EventID, "input_text_buffer_empty", "The input buffer is empty")
+			return
+		}
+		item := realtimeItem{ID: sess.newID("item"), Type: "message", Role: "user",
+			Content: []realtimeContent{{Type: "input_text", Text: text}}}
+		sess.send("input_text_buffer.committed", map[string]interface{}{"item_id": item.ID})
+		sess.addItem(item)
+
+	case "input_text_buffer.clear":
+		sess.mu.Lock()
+		sess.input.Reset()
+		sess.mu.Unlock()
+		sess.send("input_text_buffer.cleared", nil)
+
+	case "conversation.item.create":
+		item := ev.Item
+		if item == nil || item.Type != "message" {
+			sess.sendError(ev.EventID, "invalid_item", "'item' must be a message (only text messages are supported)")
+			return
+		}
+		switch item.Role {
+		case "user":
+			sess.interrupt("turn_detected")
+		case "system", "assistant":
+		default:
+			sess.sendError(ev.EventID, "invalid_item", "'item.role' must be user, system or assistant")
+			return
+		}
+		if item.ID == "" {
+			item.ID = sess.newID("item")
+		}
+		sess.addItem(*item)
+
+	case "response.create":
+		instructions := ""
+		if ev.Response != nil {
+			instructions = ev.Response.Instructions
+		}
+		if !sess.respond(instructions) {
+			sess.sendError(ev.EventID, "conversation_already_has_active_response",
+				"A response is already in progress; send response.cancel first")
+		}
+
+	case "response.cancel":
+		if !sess.interrupt("client_cancelled") {
+			sess.sendError(ev.EventID, "response_cancel_not_active", "There is no response in progress")
+		}
+
+	default:
+		sess.sendError(ev.EventID, "unknown_event", fmt.Sprintf("Unsupported event type %q", ev.Type))
+	}
+}
+
+// addItem appends an item to the conversation and confirms it.
+func (sess *realtimeSession) addItem(item realtimeItem) {
+	item.Object, item.Status = "realtime.item", "completed"
+	for i, c := range item.Content {
+		if c.Type == "" {
+			item.Content[i].Type = "input_text"
+		}
+	}
+	sess.mu.Lock()
+	previous := ""
+	if n := len(sess.items); n > 0 {
+		previous = sess.items[n-1].ID
+	}
+	sess.items = append(sess.items, item)
+	sess.mu.Unlock()
+	sess.send("conversation.item.created", map[string]interface{}{"previous_item_id": previous, "item": item})
+}
+
+// respond starts a reply to the conversation, unless one is in progress.
+func (sess *realtimeSession) respond(instructions string) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.active != nil {
+		return false
+	}
+	ctx, cancel := context.WithCancel(sess.r.Context())
+	resp := &realtimeResponse{id: sess.newID("resp"), cancel: cancel, done: make(chan struct{})}
+	sess.active = resp
+	go sess.generate(ctx, resp, sess.chatRequest(instructions))
+	return true
+}
+
+// interrupt cancels the reply in progress and waits until its
+// response.done went out. It reports whether there was one.
+func (sess *realtimeSession) interrupt(reason string) bool {
+	sess.mu.Lock()
+	resp := sess.active
+	if resp != nil && resp.reason == "" {
+		resp.reason = reason
+	}
+	sess.mu.Unlock()
+	if resp == nil {
+		return false
+	}
+	resp.cancel()
+	<-resp.done
+	return true
+}
+
+// chatRequest builds the /api/chat request for a reply. Called with mu held.
+func (sess *realtimeSession) chatRequest(instructions string) map[string]interface{} {
+	if instructions == "" {
+		instructions = sess.config.Instructions
+	}
+	var messages []map[string]interface{}
+	if instructions != "" {
+		messages = append(messages, map[string]interface{}{"role": "system", "content": instructions})
+	}
+	for _, item := range sess.items {
+		var text strings.Builder
+		for _, c := range item.Content {
+			text.WriteString(c.Text)
+		}
+		messages = append(messages, map[string]interface{}{"role": item.Role, "content": text.String()})
+	}
+	body := map[string]interface{}{"messages": messages, "stream": true}
+	if sess.config.Model != "" {
+		body["model"] = sess.config.Model
+	}
+	options := map[string]interface{}{}
+	if sess.config.Temperature != nil {
+		options["temperature"] = *sess.config.Temperature
+	}
+	if n := intParam(sess.config.MaxOutputTokens); n > 0 {
+		options["num_predict"] = n
+	}
+	if len(options) > 0 {
+		body["options"] = options
+	}
+	return body
+}
+
+// generate runs one reply through the regular /api/chat handler chain and
+// streams it to the client. Cancelling ctx stops the generation; what was
+// generated until then stays in the conversation, marked incomplete.
+func (sess *realtimeSession) generate(ctx context.Context, resp *realtimeResponse, body map[string]interface{}) {
+	defer close(resp.done)
+	defer resp.cancel()
+	itemID := sess.newID("item")
+	sess.send("response.created", map[string]interface{}{"response": map[string]interface{}{
+		"id": resp.id, "object": "realtime.response", "status": "in_progress", "output": []interface{}{},
+	}})
+	sess.send("response.output_item.added", map[string]interface{}{"response_id": resp.id, "output_index": 0,
+		"item": realtimeItem{ID: itemID, Object: "realtime.item", Type: "message", Role: "assistant", Status: "in_progress", Content: []realtimeContent{}}})
+	sess.send("response.content_part.added", map[string]interface{}{"response_id": resp.id, "item_id": itemID,
+		"output_index": 0, "content_index": 0, "part": realtimeContent{Type: "text"}})
+
+	var text strings.Builder
+	var prompt, completion int
+	var doneReason, streamErr string
+	lw := &grpcLineWriter{header: http.Header{}, onLine: func(raw []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err // a failed write makes the handler drop the upstream stream
+		}
+		var line ollamaStreamLine
+		if err := json.Unmarshal(raw, &line); err != nil {
+			return fmt.Errorf("unexpected upstream line: %v", err)
+		}
+		if line.Error != "" {
+			streamErr = line.Error
+			return nil
+		}
+		if line.Message.Content != "" {
+			text.WriteString(line.Message.Content)
+			sess.send("response.text.delta", map[string]interface{}{"response_id": resp.id, "item_id": itemID,
+				"output_index": 0, "content_index": 0, "delta": line.Message.Content})
+		}
+		if line.Done {
+			prompt, completion, doneReason = line.PromptEvalCount, line.EvalCount, line.DoneReason
+		}
+		return nil
+	}}
+	code, msg := sess.s.grpcServe(lw, sess.r.WithContext(ctx), "POST", "/api/chat", body)
+	if code == grpcwire.OK && lw.err != nil && ctx.Err() == nil {
+		msg = lw.err.Error()
+	} else if code == grpcwire.OK {
+		msg = streamErr
+	}
+
+	sess.mu.Lock()
+	reason := resp.reason
+	sess.mu.Unlock()
+	status, details := "completed", map[string]interface{}(nil)
+	switch {
+	case reason != "":
+		status, details = "cancelled", map[string]interface{}{"type": "cancelled", "reason": reason}
+	case code != grpcwire.OK || msg != "":
+		status, details = "failed", map[string]interface{}{"type": "failed", "error": map[string]interface{}{"type": "server_error", "message": msg}}
+		log.Printf("!!! Realtime session %s: response %s failed: %s !!!", sess.id, resp.id, msg)
+	case doneReason == "length":
+		status, details = "incomplete", map[string]interface{}{"type": "incomplete", "reason": "max_output_tokens"}
+	}
+	itemStatus := "completed"
+	if status != "completed" {
+		itemStatus = "incomplete"
+	}
+	item := realtimeItem{ID: itemID, Object: "realtime.item", Type: "message", Role: "assistant", Status: itemStatus,
+		Content: []realtimeContent{{Type: "text", Text: text.String()}}}
+	sess.send("response.text.done", map[string]interface{}{"response_id": resp.id, "item_id": itemID,
+		"output_index": 0, "content_index": 0, "text": text.String()})
+	sess.send("response.content_part.done", map[string]interface{}{"response_id": resp.id, "item_id": itemID,
+		"output_index": 0, "content_index": 0, "part": item.Content[0]})
+	sess.send("response.output_item.done", map[string]interface{}{"response_id": resp.id, "output_index": 0, "item": item})
+
+	sess.mu.Lock()
+	if text.Len() > 0 {
+		sess.items = append(sess.items, item)
+	}
+	sess.active = nil
+	sess.mu.Unlock()
+	sess.send("response.done", map[string]interface{}{"response": map[string]interface{}{
+		"id": resp.id, "object": "realtime.response", "status": status, "status_details": details,
+		"output": []realtimeItem{item},
+		"usage":  map[string]interface{}{"input_tokens": prompt, "output_tokens": completion, "total_tokens": prompt + completion},
+	}})
+}
+
+// sessionObject is the session as session.created/updated report it.
+func (sess *realtimeSession) sessionObject() map[string]interface{} {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	model := sess.config.Model
+	if model == "" {
+		model = sess.s.model()
+	}
+	return map[string]interface{}{
+		"id": sess.id, "object": "realtime.session", "model": model, "modalities": sess.config.Modalities,
+		"instructions": sess.config.Instructions, "temperature": sess.config.Temperature,
+		"max_response_output_tokens": sess.config.MaxOutputTokens,
+	}
+}
+
+// send writes one server event. A failed write means the client is gone;
+// the read loop notices and ends the session.
+func (sess *realtimeSession) send(eventType string, fields map[string]interface{}) {
+	event := map[string]interface{}{"type": eventType, "event_id": sess.newID("event")}
+	for k, v := range fields {
+		event[k] = v
+	}
+	data, _ := json.Marshal(event)
+	sess.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// sendError reports a client event that could not be run.
+func (sess *realtimeSession) sendError(eventID, code, message string) {
+	sess.send("error", map[string]interface{}{"error": map[string]interface{}{
+		"type": "invalid_request_error", "code": code, "message": message, "event_id": eventID,
+	}})
+}
+
+func (sess *realtimeSession) newID(prefix string) string {
+	return fmt.Sprintf("%s_%s_%d", prefix, strings.TrimPrefix(sess.id, "sess_"), sess.seq.Add(1))
+}
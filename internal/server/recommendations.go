@@ -0,0 +1,243 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	modelMemOverhead     = 512 << 20 // runtime and a default context on top of the weights
+	defaultMinTokensPerS = 5.0       // slowest generation /api/recommendations counts as acceptable
+	gpuBandwidth         = 300e9     // bytes/s of a mid-range GPU; generation reads the weights once per token
+	cpuBandwidth         = 25e9      // bytes/s of dual-channel DDR4/DDR5 with AVX2 (or ARM dot product) kernels
+	cpuBandwidthNoSIMD   = 10e9      // without them llama.cpp is compute bound
+	defaultQuantization  = "q4_K_M"  // what the library's default tags are
+	defaultQuantBPW      = 4.85      // its bits per weight
+)
+
+// catalogModel is a model the setup and /api/recommendations offer. Name
+// and Size are those of the default (Q4_K_M) tag in the Ollama library.
+type catalogModel struct {
+	Name        string
+	Description string
+	Params      float64 // billions
+	Size        int64   // download size, bytes
+	Kind        string  // "chat", "code", "reasoning" or "embedding"
+	QuantTag    string  // tag prefix of the other quantizations, e.g. "llama3.1:8b-instruct-"; "" = only Name
+}
+
+var modelCatalog = []catalogModel{
+	{"qwen2.5:0.5b", "Tiny and quick, for simple tasks on any machine", 0.5, 398 << 20, "chat", "qwen2.5:0.5b-instruct-"},
+	{"llama3.2:1b", "Small general-purpose model", 1.2, 1331 << 20, "chat", "llama3.2:1b-instruct-"},
+	{"gemma2:2b", "Small model with good writing", 2.6, 1638 << 20, "chat", "gemma2:2b-instruct-"},
+	{"llama3.2:3b", "Good quality for its size; a sensible CPU-only choice", 3.2, 2048 << 20, "chat", "llama3.2:3b-instruct-"},
+	{"phi3.5:3.8b", "Strong reasoning for a small model", 3.8, 2253 << 20, "chat", "phi3.5:3.8b-mini-instruct-"},
+	{"qwen2.5:7b", "Capable general-purpose model, multilingual", 7.6, 4813 << 20, "chat", "qwen2.5:7b-instruct-"},
+	{"llama3.1:8b", "Capable general-purpose model", 8.0, 5018 << 20, "chat", "llama3.1:8b-instruct-"},
+	{"qwen2.5-coder:7b", "Code completion and generation", 7.6, 4813 << 20, "code", "qwen2.5-coder:7b-instruct-"},
+	{"deepseek-r1:7b", "Thinks before it answers; slower, better at hard questions", 7.6, 4813 << 20, "reasoning", "deepseek-r1:7b-qwen-distill-"},
+	{"gemma2:9b", "High quality for a mid-size model", 9.2, 5530 << 20, "chat", "gemma2:9b-instruct-"},
+	{"qwen2.5:14b", "High quality; needs a 12 GB GPU", 14.8, 9216 << 20, "chat", "qwen2.5:14b-instruct-"},
+	{"mistral-small:24b", "Near large-model quality; needs a 16 GB GPU", 24, 14336 << 20, "chat", "mistral-small:24b-instruct-2501-"},
+	{"qwen2.5:32b", "Large model; needs a 24 GB GPU", 32.8, 20480 << 20, "chat", "qwen2.5:32b-instruct-"},
+	{"llama3.3:70b", "Largest; needs 48 GB of GPU memory", 70.6, 44032 << 20, "chat", "llama3.3:70b-instruct-"},
+	{"nomic-embed-text", "Embeddings for search and RAG", 0.137, 274 << 20, "embedding", ""},
+}
+
+// quantizations rank by bits per weight (which scale the size) and by how
+// much of the full-precision quality they keep.
+var quantizations = []struct {
+	name    string
+	bpw     float64
+	quality float64
+}{
+	{"q3_K_M", 3.91, 0.90},
+	{defaultQuantization, defaultQuantBPW, 0.96},
+	{"q5_K_M", 5.69, 0.98},
+	{"q8_0", 8.5, 1.0},
+	{"fp16", 16, 1.0},
+}
+
+// Hardware fit of a model, reported as "fit".
+const (
+	fitGPU      = "gpu"       // fits in the memory of the largest GPU
+	fitCPU      = "cpu"       // fits in free RAM; runs on the CPU, slower
+	fitTight    = "tight"     // only fits if other apps free memory
+	fitTooLarge = "too_large" // more than the machine's memory
+	fitUnknown  = "unknown"   // memory not detectable here
+)
+
+// modelMemory is what a model of size bytes needs loaded.
+func modelMemory(size int64) int64 {
+	return size + size/5 + modelMemOverhead
+}
+
+// modelFit tells how a model of size bytes runs on m.
+func modelFit(m *machineInfo, size int64) string {
+	need := modelMemory(size)
+	switch {
+	case m.vram() >= need:
+		return fitGPU
+	case m.MemoryTotal == 0:
+		return fitUnknown
+	case m.MemoryAvailable >= need:
+		return fitCPU
+	case m.MemoryTotal >= need:
+		return fitTight
+	}
+	return fitTooLarge
+}
+
+// recommendation is one model and quantization rated for the machine.
+type recommendation struct {
+	Model        string  `json:"model"`  // tag to pull
+	Family       string  `json:"family"` // the default tag of the model
+	Quantization string  `json:"quantization"`
+	Description  string  `json:"description"`
+	Kind         string  `json:"kind"`
+	Parameters   string  `json:"parameters"`
+	Size         int64   `json:"size"`
+	Memory       int64   `json:"memory"`
+	Fit          string  `json:"fit"`
+	TokensPerSec float64 `json:"est_tokens_per_sec"`
+	Score        float64 `json:"score"` // quality: larger models, then finer quantizations, score higher
+	Acceptable   bool    `json:"acceptable"`
+	Reason       string  `json:"reason,omitempty"` // why it is not acceptable
+}
+
+// estimateTokensPerSec guesses the generation speed from how fast the
+// weights can be read where the model fits.
+func estimateTokensPerSec(m *machineInfo, fit string, size int64) float64 {
+	bandwidth := cpuBandwidthNoSIMD
+	if m.hasCPUFeature("avx2") || m.hasCPUFeature("asimddp") || len(m.CPUFeatures) == 0 && m.Arch == "arm64" {
+		bandwidth = cpuBandwidth
+	}
+	switch fit {
+	case fitGPU:
+		bandwidth = gpuBandwidth
+	case fitTight:
+		bandwidth /= 2 // competing with other apps for memory
+	case fitTooLarge, fitUnknown:
+		return 0
+	}
+	return math.Round(bandwidth/float64(size)*10) / 10
+}
+
+// recommend rates every model and quantization of the catalog for m, the
+// acceptable ones (fitting, and generating at least minTPS tokens/s) first,
+// best score first.
+func recommend(m *machineInfo, minTPS float64) []recommendation {
+	var out []recommendation
+	for _, c := range modelCatalog {
+		for _, q := range quantizations {
+			tag := c.QuantTag + q.name
+			switch {
+			case q.name == defaultQuantization:
+				tag = c.Name
+			case c.QuantTag == "":
+				continue
+			}
+			size := int64(float64(c.Size) * q.bpw / defaultQuantBPW)
+			rec := recommendation{
+				Model: tag, Family: c.Name, Quantization: q.name, Description: c.Description, Kind: c.Kind,
+				Parameters: formatParams(c.Params), Size: size, Memory: modelMemory(size), Fit: modelFit(m, size),
+				Score: math.Round(math.Pow(c.Params, 0.3)*q.quality*1000) / 1000,
+			}
+			rec.TokensPerSec = estimateTokensPerSec(m, rec.Fit, size)
+			switch {
+			case rec.Fit == fitTooLarge:
+				rec.Reason = "needs more memory than the machine has"
+			case rec.Fit == fitUnknown:
+				rec.Reason = "the machine's memory can't be detected"
+			case rec.Fit == fitTight:
+				rec.Reason = "only fits if other apps free memory"
+			case rec.TokensPerSec < minTPS:
+				rec.Reason = fmt.Sprintf("about %.1f tokens/s, under %.1f", rec.TokensPerSec, minTPS)
+			default:
+				rec.Acceptable = true
+			}
+			out = append(out, rec)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Acceptable != b.Acceptable {
+			return a.Acceptable
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Size < b.Size // as good and smaller: faster
+	})
+	return out
+}
+
+// findRecommendation returns the rating of model (a catalog tag).
+func findRecommendation(recs []recommendation, model string) (recommendation, bool) {
+	for _, r := range recs {
+		if matchesModel(r.Model, model) {
+			return r, true
+		}
+	}
+	return recommendation{}, false
+}
+
+func formatParams(billions float64) string {
+	if billions < 1 {
+		return fmt.Sprintf("%.0fM", billions*1000)
+	}
+	return strconv.FormatFloat(billions, 'f', 1, 64) + "B"
+}
+
+// handleRecommendations handles GET /api/recommendations: the models and
+// quantizations that run acceptably on this machine, best first. Query:
+// kind (chat, code, reasoning, embedding), min_tokens_per_sec (default 5),
+// limit (default 10), all=true to list the rejected ones too, with why.
+func (s *Server) handleRecommendations(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	minTPS := defaultMinTokensPerS
+	if v := q.Get("min_tokens_per_sec"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "min_tokens_per_sec must be a number, at least 0")
+			return
+		}
+		minTPS = f
+	}
+	limit := 10
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "limit must be a positive integer")
+			return
+		}
+		limit = n
+	}
+	kind, all := strings.ToLower(q.Get("kind")), q.Get("all") == "true"
+
+	machine := s.machine(r.Context())
+	recs := []recommendation{}
+	var rejected []recommendation
+	for _, rec := range recommend(machine, minTPS) {
+		switch {
+		case kind != "" && rec.Kind != kind:
+		case rec.Acceptable && len(recs) < limit:
+			recs = append(recs, rec)
+		case !rec.Acceptable && all:
+			rejected = append(rejected, rec)
+		}
+	}
+	out := map[string]interface{}{
+		"machine":            machine,
+		"min_tokens_per_sec": minTPS,
+		"recommendations":    recs,
+	}
+	if all {
+		out["rejected"] = append([]recommendation{}, rejected...)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
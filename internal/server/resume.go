@@ -0,0 +1,340 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headerLastEventID is sent by SSE clients reconnecting to a stream.
+const headerLastEventID = "Last-Event-ID"
+
+// headerStreamID names a resumable SSE stream. Its events carry the IDs
+// "<stream id>:<sequence>", which clients send back as Last-Event-ID.
+const headerStreamID = "X-Stream-Id"
+
+// headerStreamResumed is set on responses that continue an earlier stream.
+const headerStreamResumed = "X-Stream-Resumed"
+
+// resumeStore keeps the recent events of SSE responses (STREAM_RESUME_TTL_SEC)
+// so a client whose connection dropped can reconnect with Last-Event-ID and
+// get the rest of the stream instead of generating the whole reply again.
+type resumeStore struct {
+	mu      sync.Mutex
+	streams map[string]*resumableStream
+}
+
+type resumableStream struct {
+	mu        sync.Mutex
+	header    http.Header // response headers, repeated on resume
+	events    [][]byte    // buffered events with their id lines; events[0] is sequence first
+	first     int
+	size      int
+	done      bool
+	expires   time.Time          // set once done
+	changed   chan struct{}      // closed (and replaced) when events arrive or the stream ends
+	listeners int                // connected clients, the original one included
+	idle      *time.Timer        // cancels the generation once nobody has listened for a while
+	cancel    context.CancelFunc // cancels the generation
+}
+
+func newResumeStore() *resumeStore {
+	return &resumeStore{streams: make(map[string]*resumableStream)}
+}
+
+// add registers a new stream under a fresh ID, pruning expired ones.
+func (st *resumeStore) add(rs *resumableStream) string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.pruneLocked()
+	id := newSessionID()[:24]
+	st.streams[id] = rs
+	return id
+}
+
+func (st *resumeStore) get(id string) *resumableStream {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.pruneLocked()
+	return st.streams[id]
+}
+
+func (st *resumeStore) pruneLocked() {
+	now := time.Now()
+	for id, rs := range st.streams {
+		rs.mu.Lock()
+		expired := rs.done && now.After(rs.expires)
+		rs.mu.Unlock()
+		if expired {
+			delete(st.streams, id)
+		}
+	}
+}
+
+// push appends one event, dropping the oldest ones beyond maxSize bytes.
+func (rs *resumableStream) push(event []byte, maxSize int) {
+	rs.mu.Lock()
+	rs.events = append(rs.events, event)
+	rs.size += len(event)
+	for rs.size > maxSize && len(rs.events) > 1 {
+		rs.size -= len(rs.events[0])
+		rs.events = rs.events[1:]
+		rs.first++
+	}
+	close(rs.changed)
+	rs.changed = make(chan struct{})
+	rs.mu.Unlock()
+}
+
+func (rs *resumableStream) finish(ttl time.Duration) {
+	rs.mu.Lock()
+	rs.done = true
+	rs.expires = time.Now().Add(ttl)
+	if rs.idle != nil {
+		rs.idle.Stop()
+	}
+	close(rs.changed)
+	rs.changed = make(chan struct{})
+	rs.mu.Unlock()
+}
+
+// attach and detach count connected clients. When the last one leaves a
+// running stream, the generation keeps going for grace so a reconnect can
+// pick it up, and is cancelled after that.
+func (rs *resumableStream) attach() {
+	rs.mu.Lock()
+	rs.listeners++
+	if rs.idle != nil {
+		rs.idle.Stop()
+	}
+	rs.mu.Unlock()
+}
+
+func (rs *resumableStream) detach(grace time.Duration) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.listeners--
+	if rs.listeners > 0 || rs.done {
+		return
+	}
+	if rs.idle == nil {
+		rs.idle = time.AfterFunc(grace, rs.cancel)
+	} else {
+		rs.idle.Reset(grace)
+	}
+}
+
+// withResume makes SSE responses resumable when STREAM_RESUME_TTL_SEC is
+// set: events get IDs and are buffered, the generation is decoupled from the
+// client connection, and a request carrying a Last-Event-ID from such a
+// stream is answered with the events after it (following the stream live if
+// it is still running) instead of going upstream.
+func (s *Server) withResume(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.StreamResumeTTLSec <= 0 {
+			h(w, r)
+			return
+		}
+		if id, seq, ok := parseResumeEventID(r.Header.Get(headerLastEventID)); ok {
+			s.resumeStream(w, r, id, seq)
+			return
+		}
+		ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+		defer cancel()
+		rw := &resumeWriter{ResponseWriter: w, s: s, cancel: cancel}
+		stop := context.AfterFunc(r.Context(), rw.clientGone)
+		defer stop()
+		h(rw, r.WithContext(ctx))
+		rw.finish()
+	}
+}
+
+// parseResumeEventID splits "<stream id>:<sequence>". IDs of other shapes
+// (e.g. from an SSE source other than this proxy) are ignored.
+func parseResumeEventID(v string) (string, int, bool) {
+	id, seqStr, ok := strings.Cut(v, ":")
+	if !ok || len(id) != 24 {
+		return "", 0, false
+	}
+	seq, err := strconv.Atoi(seqStr)
+	if err != nil || seq < 0 {
+		return "", 0, false
+	}
+	return id, seq, true
+}
+
+// resumeStream replays the events after seq of a buffered stream.
+func (s *Server) resumeStream(w http.ResponseWriter, r *http.Request, id string, seq int) {
+	format := errorFormatForPath(r.URL.Path)
+	rs := s.resume.get(id)
+	if rs == nil {
+		writeError(w, format, http.StatusGone, "stream_expired",
+			"The stream for Last-Event-ID "+id+" is no longer available; send the request again without Last-Event-ID")
+		return
+	}
+	rs.mu.Lock()
+	truncated := seq+1 < rs.first
+	rs.mu.Unlock()
+	if truncated {
+		writeError(w, format, http.StatusGone, "stream_truncated",
+			"Events after Last-Event-ID "+id+":"+strconv.Itoa(seq)+" were dropped from the resume buffer; send the request again without Last-Event-ID")
+		return
+	}
+	rs.attach()
+	defer rs.detach(time.Duration(s.config.StreamResumeTTLSec) * time.Second)
+	log.Printf(">>> %s: resuming stream %s after event %d <<<", r.URL.Path, id, seq)
+
+	rs.mu.Lock()
+	for k, v := range rs.header {
+		w.Header()[k] = append([]string(nil), v...)
+	}
+	rs.mu.Unlock()
+	w.Header().Set(headerStreamResumed, "true")
+	w.WriteHeader(http.StatusOK)
+	next := seq + 1
+	for {
+		rs.mu.Lock()
+		var events [][]byte
+		if next-rs.first < len(rs.events) {
+			events = rs.events[max(next-rs.first, 0):]
+		}
+		next = rs.first + len(rs.events)
+		done, changed := rs.done, rs.changed
+		rs.mu.Unlock()
+		for _, ev := range events {
+			if _, err := w.Write(ev); err != nil {
+				return
+			}
+		}
+		if f, ok := w.(http.Flusher); ok && len(events) > 0 {
+			f.Flush()
+		}
+		if done {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// resumeWriter tags and buffers the events of an SSE response. Other
+// responses pass through, and are cancelled as usual when the client leaves.
+type resumeWriter struct {
+	http.ResponseWriter
+	s           *Server
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	wroteHeader bool
+	gone        bool             // the original client disconnected
+	stream      *resumableStream // nil unless the response is SSE
+	id          string
+	seq         int
+	pending     []byte // start of an event not terminated yet
+}
+
+func (rw *resumeWriter) WriteHeader(code int) {
+	rw.mu.Lock()
+	if rw.wroteHeader {
+		rw.mu.Unlock()
+		return
+	}
+	rw.wroteHeader = true
+	if code == http.StatusOK && !rw.gone && strings.HasPrefix(rw.Header().Get("Content-Type"), "text/event-stream") {
+		rw.stream = &resumableStream{changed: make(chan struct{}), listeners: 1, cancel: rw.cancel}
+		rw.id = rw.s.resume.add(rw.stream)
+		rw.Header().Set(headerStreamID, rw.id)
+	}
+	rw.mu.Unlock()
+	rw.ResponseWriter.WriteHeader(code)
+	if rw.stream != nil {
+		header := rw.Header().Clone()
+		header.Del("Trailer")
+		header.Del("Content-Length")
+		rw.stream.mu.Lock()
+		rw.stream.header = header
+		rw.stream.mu.Unlock()
+	}
+}
+
+func (rw *resumeWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.stream == nil {
+		return rw.ResponseWriter.Write(b)
+	}
+	rw.pending = append(rw.pending, b...)
+	for {
+		i := bytes.Index(rw.pending, []byte("\n\n"))
+		if i < 0 {
+			break
+		}
+		rw.emit(rw.pending[:i+2])
+		rw.pending = rw.pending[i+2:]
+	}
+	// After a disconnect the generation runs on for a resuming client.
+	return len(b), nil
+}
+
+// emit tags one complete event with its ID, buffers it and sends it to the
+// original client while it is connected.
+func (rw *resumeWriter) emit(event []byte) {
+	tagged := make([]byte, 0, len(event)+40)
+	tagged = append(tagged, "id: "+rw.id+":"+strconv.Itoa(rw.seq)+"\n"...)
+	tagged = append(tagged, event...)
+	rw.seq++
+	rw.stream.push(tagged, rw.s.config.StreamResumeBufferKB<<10)
+	rw.mu.Lock()
+	gone := rw.gone
+	rw.mu.Unlock()
+	if !gone {
+		rw.ResponseWriter.Write(tagged)
+	}
+}
+
+func (rw *resumeWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.mu.Lock()
+	gone := rw.gone
+	rw.mu.Unlock()
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok && !gone {
+		f.Flush()
+	}
+}
+
+func (rw *resumeWriter) Unwrap() http.ResponseWriter { return rw.ResponseWriter }
+
+// clientGone runs when the original client disconnects.
+func (rw *resumeWriter) clientGone() {
+	rw.mu.Lock()
+	rw.gone = true
+	rs := rw.stream
+	rw.mu.Unlock()
+	if rs == nil {
+		rw.cancel()
+		return
+	}
+	rs.detach(time.Duration(rw.s.config.StreamResumeTTLSec) * time.Second)
+}
+
+// finish sends an unterminated last event and marks the stream complete.
+func (rw *resumeWriter) finish() {
+	if rw.stream == nil {
+		return
+	}
+	if len(bytes.TrimSpace(rw.pending)) > 0 {
+		rw.emit(append(rw.pending, "\n\n"...))
+	}
+	rw.pending = nil
+	rw.stream.finish(time.Duration(rw.s.config.StreamResumeTTLSec) * time.Second)
+}
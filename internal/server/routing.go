@@ -0,0 +1,102 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"olares-ollama/internal/config"
+)
+
+// routingRule is a compiled ROUTING_RULES entry.
+type routingRule struct {
+	config.RoutingRule
+	pattern *regexp.Regexp // nil = no content condition
+}
+
+func newRoutingRules(s string) []routingRule {
+	parsed, _ := config.ParseRoutingRules(s) // checked by Validate
+	rules := make([]routingRule, 0, len(parsed))
+	for _, rule := range parsed {
+		rr := routingRule{RoutingRule: rule}
+		if rule.Pattern != "" {
+			rr.pattern = regexp.MustCompile(rule.Pattern)
+		}
+		rules = append(rules, rr)
+	}
+	return rules
+}
+
+// promptText is the text a rule's pattern is matched against: the latest
+// message (or prompt), or with scope "all" every message and the system prompt.
+func promptText(req map[string]interface{}, scope string) string {
+	msgs := messagesOf(req["messages"])
+	if scope != "all" {
+		if len(msgs) > 0 {
+			if last, ok := msgs[len(msgs)-1].(map[string]interface{}); ok {
+				return flattenContent(last["content"])
+			}
+			return ""
+		}
+		prompt, _ := req["prompt"].(string)
+		return prompt
+	}
+	var parts []string
+	if system := flattenContent(req["system"]); system != "" {
+		parts = append(parts, system)
+	}
+	for _, m := range msgs {
+		if mm, ok := m.(map[string]interface{}); ok {
+			parts = append(parts, flattenContent(mm["content"]))
+		}
+	}
+	if prompt, _ := req["prompt"].(string); prompt != "" {
+		parts = append(parts, prompt)
+	}
+	return strings.Join(parts, "\n")
+}
+
+func (rule *routingRule) matches(req map[string]interface{}, tokens int) bool {
+	if rule.MinPromptTokens > 0 && tokens < rule.MinPromptTokens {
+		return false
+	}
+	if rule.MaxPromptTokens > 0 && tokens > rule.MaxPromptTokens {
+		return false
+	}
+	return rule.pattern == nil || rule.pattern.MatchString(promptText(req, rule.Scope))
+}
+
+// applyRoutingRules sends the request to the model of the first matching
+// ROUTING_RULES entry. A user route (applyUserRoute) takes precedence, and a
+// routed request keeps its model on the fast lane.
+func (s *Server) applyRoutingRules(r *http.Request, req map[string]interface{}) {
+	if len(s.routingRules) == 0 {
+		return
+	}
+	meta := metaFrom(r)
+	if meta != nil {
+		routed := false
+		meta.update(func(m *requestMeta) { routed = m.userRouted })
+		if routed {
+			return
+		}
+	}
+	tokens := promptTokens(req)
+	for i := range s.routingRules {
+		rule := &s.routingRules[i]
+		if !rule.matches(req, tokens) {
+			continue
+		}
+		from, _ := req["model"].(string)
+		req["model"] = rule.Model
+		if meta != nil {
+			meta.update(func(m *requestMeta) {
+				m.routingRule = rule.Name
+				m.servedModel = rule.Model
+			})
+		}
+		log.Printf(">>> %s: routing rule %q (~%d prompt tokens) -> %s (was %s) <<<", r.URL.Path, rule.Name, tokens, rule.Model, from)
+		return
+	}
+}
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"olares-ollama/internal/config"
+)
+
+const (
+	scheduleTick   = 30 * time.Second // how often due schedules are checked
+	webhookTimeout = 30 * time.Second
+)
+
+// promptSchedule is a recurring prompt (e.g. a nightly summary), run as an
+// async job when it is due and the proxy is idle; the finished job is
+// POSTed to the webhook.
+type promptSchedule struct {
+	Name     string                 `json:"name"`
+	Endpoint string                 `json:"endpoint"`            // "chat" or "generate"
+	Request  map[string]interface{} `json:"request"`             // body for the endpoint
+	At       string                 `json:"at,omitempty"`        // daily "HH:MM", server local time
+	EveryMin int                    `json:"every_min,omitempty"` // or an interval in minutes
+	Webhook  string                 `json:"webhook,omitempty"`
+	Disabled bool                   `json:"disabled,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	NextRun    time.Time  `json:"next_run"`
+	Deferred   string     `json:"deferred,omitempty"` // why a due run is waiting
+	LastRun    *time.Time `json:"last_run,omitempty"`
+	LastJobID  string     `json:"last_job_id,omitempty"`
+	LastStatus string     `json:"last_status,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+// nextAfter returns when the schedule is due next after t.
+func (sc *promptSchedule) nextAfter(t time.Time) time.Time {
+	if sc.EveryMin > 0 {
+		return t.Add(time.Duration(sc.EveryMin) * time.Minute)
+	}
+	at, _ := config.ParseClock(sc.At)
+	y, m, d := t.Date()
+	next := time.Date(y, m, d, at/60, at%60, 0, 0, t.Location())
+	if !next.After(t) {
+		next = time.Date(y, m, d+1, at/60, at%60, 0, 0, t.Location())
+	}
+	return next
+}
+
+// scheduleStore persists schedules, with their run state, to data/schedules.json.
+type scheduleStore struct {
+	mu        sync.Mutex
+	schedules map[string]*promptSchedule
+	file      string
+}
+
+func newScheduleStore() *scheduleStore {
+	st := &scheduleStore{
+		schedules: make(map[string]*promptSchedule),
+		file:      filepath.Join("data", "schedules.json"),
+	}
+	data, err := os.ReadFile(st.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read %s: %v", st.file, err)
+		}
+		return st
+	}
+	var list []*promptSchedule
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Warning: failed to parse %s: %v", st.file, err)
+		return st
+	}
+	for _, sc := range list {
+		st.schedules[sc.Name] = sc
+	}
+	log.Printf("Loaded %d scheduled prompts from %s", len(list), st.file)
+	return st
+}
+
+func (st *scheduleStore) get(name string) (promptSchedule, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	sc, ok := st.schedules[name]
+	if !ok {
+		return promptSchedule{}, false
+	}
+	return *sc, true
+}
+
+func (st *scheduleStore) list() []promptSchedule {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	out := make([]promptSchedule, 0, len(st.schedules))
+	for _, sc := range st.schedules {
+		out = append(out, *sc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (st *scheduleStore) put(sc *promptSchedule) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.schedules[sc.Name] = sc
+	return st.saveLocked()
+}
+
+func (st *scheduleStore) delete(name string) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.schedules[name]; !ok {
+		return false, nil
+	}
+	delete(st.schedules, name)
+	return true, st.saveLocked()
+}
+
+// update applies fn to the stored schedule (if it still exists) and saves.
+func (st *scheduleStore) update(name string, fn func(sc *promptSchedule)) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	sc, ok := st.schedules[name]
+	if !ok {
+		return
+	}
+	fn(sc)
+	if err := st.saveLocked(); err != nil {
+		log.Printf("!!! Failed to save scheduled prompts: %v !!!", err)
+	}
+}
+
+func (st *scheduleStore) saveLocked() error {
+	list := make([]*promptSchedule, 0, len(st.schedules))
+	for _, sc := range st.schedules {
+		list = append(list, sc)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(st.file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(st.file, data, 0644)
+}
+
+// runScheduler starts due schedules, one at a time (ENABLE_SCHEDULES).
+func (s *Server) runScheduler() {
+	ticker := time.NewTicker(scheduleTick)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, sc := range s.schedules.list() {
+			if sc.Disabled || now.Before(sc.NextRun) {
+				continue
+			}
+			if reason := s.scheduleBlocked(now); reason != "" {
+				if sc.Deferred != reason {
+					s.schedules.update(sc.Name, func(stored *promptSchedule) { stored.Deferred = reason })
+				}
+				continue
+			}
+			s.runSchedule(sc)
+		}
+	}
+}
+
+// scheduleBlocked says why due schedules must wait: outside SCHEDULE_WINDOW,
+// the proxy not ready, or inference requests in progress (scheduled prompts
+// only use idle time).
+func (s *Server) scheduleBlocked(now time.Time) string {
+	if s.config.ScheduleWindow != "" && !inWindow(s.config.ScheduleWindow, now) {
+		return "outside SCHEDULE_WINDOW " + s.config.ScheduleWindow
+	}
+	if reasons := s.readinessReasons(); len(reasons) > 0 {
+		return "not ready: " + strings.Join(reasons, "; ")
+	}
+	if n := s.inflight.Load(); n > 0 {
+		return fmt.Sprintf("waiting for idle (%d requests in progress)", n)
+	}
+	return ""
+}
+
+// inWindow reports whether now is inside a daily "HH:MM-HH:MM" window
+// (checked by Validate).
+func inWindow(window string, now time.Time) bool {
+	start, end, _ := config.ParseWindow(window)
+	minute := now.Hour()*60 + now.Minute()
+	if end < start {
+		return minute >= start || minute < end
+	}
+	return start <= minute && minute < end
+}
+
+// runSchedule runs one schedule as an async job, waits for it and delivers
+// the result to the webhook.
+func (s *Server) runSchedule(sc promptSchedule) {
+	var req map[string]interface{}
+	raw, _ := json.Marshal(sc.Request)
+	json.Unmarshal(raw, &req)
+	// Owned by no client: only the schedule and its webhook see the job.
+	job, ok := s.startAsyncJob(sc.Endpoint, req, http.Header{}, "scheduler", "schedule:"+sc.Name)
+	if !ok {
+		s.schedules.update(sc.Name, func(stored *promptSchedule) { stored.Deferred = "ASYNC_MAX_JOBS jobs are running" })
+		return
+	}
+	now := time.Now()
+	log.Printf("Scheduled prompt %s started as job %s", sc.Name, job.ID)
+	s.schedules.update(sc.Name, func(stored *promptSchedule) {
+		stored.LastRun, stored.LastJobID, stored.LastStatus, stored.LastError = &now, job.ID, job.Status, ""
+		stored.Deferred = ""
+		stored.NextRun = stored.nextAfter(now)
+	})
+
+	if _, done, ok := s.asyncJobs.get(job.ID); ok {
+		<-done
+	}
+	job, _, _ = s.asyncJobs.get(job.ID)
+	var lastError string
+	if msg, _ := job.Error["message"].(string); msg != "" {
+		lastError = msg
+	}
+	if sc.Webhook != "" {
+		if err := postScheduleResult(sc, job); err != nil {
+			log.Printf("!!! Scheduled prompt %s: webhook failed: %v !!!", sc.Name, err)
+			lastError = strings.TrimPrefix(lastError+"; webhook: "+err.Error(), "; ")
+		}
+	}
+	log.Printf("Scheduled prompt %s finished: %s", sc.Name, job.Status)
+	s.schedules.update(sc.Name, func(stored *promptSchedule) {
+		stored.LastStatus, stored.LastError = job.Status, lastError
+	})
+}
+
+// postScheduleResult POSTs {"schedule": name, "job": {...}} to the webhook.
+func postScheduleResult(sc promptSchedule, job asyncJob) error {
+	body, err := json.Marshal(map[string]interface{}{"schedule": sc.Name, "job": job})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(sc.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// registerScheduleRoutes adds the scheduled prompt admin API.
+func (s *Server) registerScheduleRoutes() {
+	s.adminRoute("/admin/schedules", s.handleScheduleList, "GET")
+	s.adminRoute("/admin/schedules/{name}", s.handleScheduleGet, "GET")
+	s.adminRoute("/admin/schedules/{name}", s.handleSchedulePut, "PUT")
+	s.adminRoute("/admin/schedules/{name}", s.handleScheduleDelete, "DELETE")
+	s.adminRoute("/admin/schedules/{name}/run", s.handleScheduleRun, "POST")
+}
+
+func (s *Server) handleScheduleList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"schedules": s.schedules.list()})
+}
+
+func (s *Server) handleScheduleGet(w http.ResponseWriter, r *http.Request) {
+	sc, ok := s.schedules.get(r.PathValue("name"))
+	if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "schedule_not_found", "Scheduled prompt not found: "+r.PathValue("name"))
+		return
+	}
+	writeJSON(w, http.StatusOK, sc)
+}
+
+// handleSchedulePut creates or replaces a schedule. Body: {"endpoint": "chat",
+// "request": {...}, "at": "02:00" | "every_min": 60, "webhook": "https://...", "disabled": false}.
+func (s *Server) handleSchedulePut(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var sc promptSchedule
+	if err := json.NewDecoder(r.Body).Decode(&sc); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+		return
+	}
+	if sc.Endpoint == "" {
+		sc.Endpoint = "chat"
+	}
+	var problem string
+	switch {
+	case asyncEndpoints[sc.Endpoint] == "":
+		problem = "'endpoint' must be chat or generate"
+	case len(sc.Request) == 0:
+		problem = "'request' is required"
+	case (sc.At == "") == (sc.EveryMin == 0):
+		problem = "set exactly one of 'at' (HH:MM) and 'every_min'"
+	case sc.EveryMin < 0:
+		problem = "'every_min' must be positive"
+	}
+	if sc.At != "" {
+		if _, err := config.ParseClock(sc.At); err != nil {
+			problem = "'at': " + err.Error()
+		}
+	}
+	if sc.Webhook != "" {
+		if u, err := url.Parse(sc.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem = "'webhook' must be an http(s) URL"
+		}
+	}
+	if problem != "" {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", problem)
+		return
+	}
+
+	now := time.Now()
+	sc.Name, sc.CreatedAt, sc.UpdatedAt = name, now, now
+	sc.LastRun, sc.LastJobID, sc.LastStatus, sc.LastError, sc.Deferred = nil, "", "", "", ""
+	if old, ok := s.schedules.get(name); ok {
+		sc.CreatedAt, sc.LastRun, sc.LastJobID, sc.LastStatus, sc.LastError = old.CreatedAt, old.LastRun, old.LastJobID, old.LastStatus, old.LastError
+	}
+	sc.NextRun = sc.nextAfter(now)
+	if err := s.schedules.put(&sc); err != nil {
+		log.Printf("!!! Failed to save scheduled prompts: %v !!!", err)
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Failed to save schedule: "+err.Error())
+		return
+	}
+	log.Printf("Scheduled prompt saved: %s (next run %s)", name, sc.NextRun.Format(time.RFC3339))
+	writeJSON(w, http.StatusOK, &sc)
+}
+
+func (s *Server) handleScheduleDelete(w http.ResponseWriter, r *http.Request) {
+	ok, err := s.schedules.delete(r.PathValue("name"))
+	if err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Failed to save schedules: "+err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "schedule_not_found", "Scheduled prompt not found: "+r.PathValue("name"))
+		return
+	}
+	log.Printf("Scheduled prompt deleted: %s", r.PathValue("name"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleScheduleRun runs a schedule now, regardless of the window and load.
+func (s *Server) handleScheduleRun(w http.ResponseWriter, r *http.Request) {
+	sc, ok := s.schedules.get(r.PathValue("name"))
+	if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "schedule_not_found", "Scheduled prompt not found: "+r.PathValue("name"))
+		return
+	}
+	go s.runSchedule(sc)
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"schedule": sc.Name, "status": "started"})
+}
@@ -2,21 +2,75 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"olares-ollama/internal/config"
 	"olares-ollama/internal/download"
+	"olares-ollama/internal/events"
 	"olares-ollama/internal/ollama"
+	"olares-ollama/internal/reporting"
 )
 
 // Server 代理服务器
 type Server struct {
 	config          *config.Config
 	ollamaClient    *ollama.Client
+	upstreams       *upstreamSet    // inference on other Ollama servers (OLLAMA_UPSTREAMS); nil = all on ollamaClient
+	placements      *placementStore // model -> server assignments among the upstreams
+	peers           *peerSet        // other olares-ollama instances (ENABLE_PEERS); nil = off
 	progressManager *download.ProgressManager
 	mux             *http.ServeMux
+	adminMux        *http.ServeMux // management endpoints when ADMIN_ADDR splits them off; nil = served on mux
+	routeMu         sync.RWMutex
+	routeMethods    map[*http.ServeMux]map[string][]string // mux -> path -> methods registered via route, for 405 Allow headers
+	probeBody       []byte              // JSON written for GET probes (COMPAT_PROFILE / PROBE_RESPONSE)
+	limiter         *limiter            // concurrency limit for inference requests, with a fast lane
+	sysLoad         *systemLoad         // host CPU/memory/GPU samples (LOAD_SHEDDING); nil = off
+	thermal         *thermalGovernor    // generation cap and cooldowns of thermal mode (THERMAL_MODE)
+	sessions        *sessionStore       // server-side chat histories (ENABLE_SESSIONS)
+	modelInfo       modelInfoCache      // cached /api/show per model
+	templates       *templateStore      // named system prompts (X-Prompt-Template / model alias)
+	userRoutes      *userRouteStore     // per-user / per-API-key default models
+	pins            *pinStore           // models protected from unload and re-creation (PINNED_MODELS + admin)
+	adminKeys       *adminKeyStore      // role keys for the admin endpoints (viewer, operator, admin)
+	modelStore      *ollama.ModelStore  // Ollama's model files (OLLAMA_MODELS_DIR), for export; nil = unset
+	routingRules    []routingRule       // ROUTING_RULES, first match picks the model
+	tenantLimits    *tenantLimitStore   // per-user / per-API-key request size caps
+	upstreamVersion upstreamVersion     // last /api/version result, gates version-dependent features
+	upstream        upstreamState       // reachability from the background prober (upstream_state)
+	modelLoad       modelLoadState      // whether the configured model is in Ollama's memory (model_load)
+	hotModels       *hotModelSet        // LRU residency of several models (HOT_MODELS); nil = off
+	embedBatches    embedBatchTracker   // progress of in-flight batch embedding requests
+	idempotency     *idempotencyStore   // results of requests sent with an Idempotency-Key
+	resume          *resumeStore        // buffered SSE events for Last-Event-ID resumption
+	statusPolls     *statusCache        // recent /api/version and /api/ps answers (STATUS_CACHE_SEC)
+	asyncJobs       *asyncJobStore      // background inference jobs (ENABLE_ASYNC_JOBS)
+	usage           *usageStore         // per-tenant request counts for /admin/tenants
+	costs           costTable           // MODEL_COSTS weights for estimated request cost
+	schedules       *scheduleStore      // recurring prompts run as async jobs (ENABLE_SCHEDULES)
+	maintenance     *maintenanceState   // last report of the nightly tasks (MAINTENANCE_WINDOW)
+	desired         *desiredStateStore  // declarative spec of models, aliases and keep-warm models, and its last reconcile
+	inflight        atomic.Int64        // inference requests in progress
+	generation      atomic.Int64        // configurations run with: 1 at start, +1 per runtime change (observedGeneration)
+	conditions      *conditionTracker   // last transitions of the /status conditions
+	startedAt       time.Time           // for /status
+	machineCache    machineCache        // last hardware detection (/api/recommendations, /api/setup)
+	downloads       downloadGate        // pulls waiting for a confirmation (DOWNLOAD_CONFIRM_THRESHOLD_MB)
+	activeModel     atomic.Value        // model switched to via /admin/model/switch (string); unset = OLLAMA_MODEL
+	modelSwitch     modelSwitch         // state of the last switch (model_switch)
+	modelUsage      modelUsage          // in-flight requests per model, drained before a switched-away model is unloaded
+	lastSmokeTest   atomic.Value        // latest smokeResult, of any model; unset = never tested
+	errorLog        *errorLog           // recent failed requests, served at /api/errors
+	audit           *conformanceAudit   // HTTP checks of every response (CONFORMANCE_AUDIT); nil = off
+	events          *events.Log         // lifecycle audit trail (EVENTS_LOG); nil = off
+	reporter        *reporting.Reporter // optional crash / error reporting (ERROR_REPORTING_DSN)
+	reportedAt      sync.Map            // error code -> time.Time of its last report
 }
 
 // New 创建新的服务器实例
@@ -24,11 +78,80 @@ func New(cfg *config.Config, ollamaClient *ollama.Client) *Server {
 	s := &Server{
 		config:          cfg,
 		ollamaClient:    ollamaClient,
+		upstreams:       newUpstreamSet(cfg.OllamaUpstreams, cfg.OllamaURL, ollamaClient),
+		placements:      newPlacementStore(),
 		progressManager: download.NewProgressManager(cfg.AppURL),
 		mux:             http.NewServeMux(),
+		routeMethods:    make(map[*http.ServeMux]map[string][]string),
+		limiter:         newLimiter(cfg.MaxConcurrentRequests, cfg.FastLaneSlots),
+		thermal:         newThermalGovernor(cfg),
+		sessions:        newSessionStore(cfg.SessionMaxMessages, cfg.SessionTTLMin),
+		templates:       newTemplateStore(),
+		userRoutes:      newUserRouteStore(),
+		pins:            newPinStore(cfg.PinnedModels),
+		adminKeys:       newAdminKeyStore(),
+		modelStore:      openModelStore(cfg),
+		routingRules:    newRoutingRules(cfg.RoutingRules),
+		tenantLimits:    newTenantLimitStore(),
+		idempotency:     newIdempotencyStore(),
+		resume:          newResumeStore(),
+		statusPolls:     newStatusCache(),
+		asyncJobs:       newAsyncJobStore(cfg.AsyncJobTTLSec, cfg.AsyncMaxJobs),
+		usage:           newUsageStore(),
+		costs:           newCostTable(cfg.ModelCosts),
+		hotModels:       newHotModelSet(cfg.Model, cfg.FastLaneModel, cfg.HotModels),
+		schedules:       newScheduleStore(),
+		maintenance:     newMaintenanceState(),
+		desired:         newDesiredStateStore(),
+		errorLog:        newErrorLog(),
+		audit:           newConformanceAudit(cfg.ConformanceAudit),
+		events:          openEventsLog(cfg),
+		upstream:        upstreamState{kick: make(chan struct{}, 1)},
+		conditions:      newConditionTracker(),
+		startedAt:       time.Now().UTC(),
 	}
+	s.generation.Store(1)
 
+	if cfg.AdminAddr != "" {
+		s.adminMux = http.NewServeMux()
+	}
+	if cfg.EnablePeers {
+		s.peers = newPeerSet(cfg.PeerName)
+	}
+	s.restoreActiveModel()
+	s.restoreSetup()
+	s.probeBody = s.probeResponseBody()
+	s.progressManager.OnEvent(s.recordPullEvent)
 	s.setupRoutes()
+	go s.watchUpstreamVersion()
+	go s.usage.saveLoop()
+	if cfg.EnableSchedules {
+		go s.runScheduler()
+	}
+	if cfg.MaintenanceWindow != "" {
+		go s.runMaintenance()
+	}
+	go s.runDesiredState()
+	if s.hotModels != nil {
+		go s.runHotModels()
+	}
+	if cfg.PrefetchModels {
+		go s.prefetchModels()
+	}
+	if s.peers != nil {
+		go s.runPeers()
+	}
+	if cfg.LoadShedding {
+		s.sysLoad = newSystemLoad()
+		go s.watchSystemLoad()
+	}
+	if cfg.UpstreamProbeIntervalSec > 0 {
+		s.progressManager.SetField("upstream_state", func() interface{} { return s.upstreamStateInfo(false) })
+		if cfg.Model != "" {
+			s.progressManager.SetField("model_load", func() interface{} { return s.modelLoadInfo() })
+		}
+		go s.watchUpstream()
+	}
 	return s
 }
 
@@ -37,52 +160,228 @@ func (s *Server) Handler() http.Handler {
 	return s.corsMiddleware(s.mux)
 }
 
+// AdminHandler returns the handler for the ADMIN_ADDR listener, or nil when
+// management endpoints are served on the main port.
+func (s *Server) AdminHandler() http.Handler {
+	if s.adminMux == nil {
+		return nil
+	}
+	return s.corsMiddleware(s.adminMux)
+}
+
+// managementMux is where /admin, /api/errors and /debug routes go: the
+// admin listener's mux when ADMIN_ADDR is set, so they are never reachable
+// through the public port (and the Olares gateway in front of it).
+func (s *Server) managementMux() *http.ServeMux {
+	if s.adminMux != nil {
+		return s.adminMux
+	}
+	return s.mux
+}
+
 // setupRoutes 设置路由
 func (s *Server) setupRoutes() {
 	// 静态文件服务
-	s.mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./web/static/"))))
+	s.mux.Handle("/static/", s.statusPage(http.StripPrefix("/static/", http.FileServer(http.Dir("./web/static/")))))
+	s.mux.Handle("/{$}", s.statusPage(http.HandlerFunc(s.handleIndex)))
 	s.mux.HandleFunc("/", s.handleIndex)
 
 	// Base mode API endpoints (available in both modes)
-	s.mux.HandleFunc("/api/base/info", s.handleBaseInfo)
+	s.route("/api/base/info", s.handleBaseInfo, "GET")
+	s.route("/api/status", s.statusPage(http.HandlerFunc(s.handleStatus)).ServeHTTP, "GET")
+	s.route("/api/setup", s.handleSetupGet, "GET") // first-run setup when OLLAMA_MODEL is empty
+	s.route("/api/setup", s.pullAction(s.handleSetupPost), "POST")
+	s.route("/api/recommendations", s.handleRecommendations, "GET") // models and quantizations that run well on this machine
+	s.route("/api/downloads", s.statusPage(http.HandlerFunc(s.handleDownloadList)).ServeHTTP, "GET") // pulls waiting for a confirmation
+	s.route("/api/downloads/{path...}", s.pullAction(s.handleDownloadDecision), "POST")
+	s.routeOn(s.managementMux(), "/api/errors", s.handleErrors, "GET")
+	s.routeOn(s.managementMux(), "/metrics", s.handleMetrics, "GET")
 
 	// 进度API
-	s.mux.HandleFunc("/api/progress", s.progressManager.HandleProgressAPI)
+	s.mux.Handle("/api/progress", s.statusPage(http.HandlerFunc(s.progressManager.HandleProgressAPI)))
+
+	// Admin: prompt template library, user routes, tenant usage and limits, pinned models, role keys, model deletion, state backup, model export, import and creation, adapters, evaluation, model switch, smoke test, effective configuration, status links, log level
+	s.registerTemplateRoutes()
+	s.registerUserRouteRoutes()
+	s.registerTenantLimitRoutes()
+	s.registerPinRoutes()
+	s.registerAdminKeyRoutes()
+	s.adminRoute("/admin/models/{name}/delete", s.handleModelDelete, "POST")
+	s.adminRoute("/admin/models/{name}/export", s.handleModelExport, "POST")
+	s.adminRoute("/admin/models/import-archive", s.handleModelImport, "POST")
+	s.adminRoute("/admin/state/export", s.handleStateExport, "GET")
+	s.adminRoute("/admin/state/import", s.handleStateImport, "POST")
+	s.adminRoute("/admin/models/create", s.handleModelCreate, "POST")
+	s.adminRoute("/admin/models/{name}/adapters", s.handleModelAdapters, "GET")
+	s.adminRoute("/admin/adapters", s.handleAdapterList, "GET")
+	s.adminRoute("/admin/eval", s.handleEval, "POST")
+	s.adminRoute("/admin/model/switch", s.handleModelSwitchStatus, "GET")
+	s.adminRoute("/admin/model/switch", s.handleModelSwitch, "POST")
+	s.adminRoute("/admin/smoketest", s.handleSmokeTest, "POST")
+	s.adminRoute("/admin/tenants", s.handleAdminTenants, "GET")
+	s.adminRoute("/admin/config", s.handleAdminConfig, "GET")
+	s.adminRoute("/admin/status-link", s.handleStatusLink, "POST") // signed link to the progress page (STATUS_PAGE_AUTH)
+	s.adminRoute("/admin/loglevel", s.handleLogLevelGet, "GET")
+	s.adminRoute("/admin/loglevel", s.handleLogLevelPut, "PUT")
+	s.adminRoute("/admin/thermal", s.handleThermalGet, "GET")
+	s.adminRoute("/admin/thermal", s.handleThermalPut, "PUT")
+
+	// Server-side chat sessions (optional)
+	if s.config.EnableSessions {
+		s.registerSessionRoutes()
+	}
+
+	// Realtime WebSocket sessions (optional, experimental)
+	if s.config.EnableRealtime {
+		s.route("/v1/realtime", s.handleRealtime, "GET")
+	}
+
+	// Other local services behind the same endpoint: audio, images (optional)
+	s.registerBackendRoutes()
+
+	// Model placement across the Ollama servers of OLLAMA_UPSTREAMS
+	s.adminRoute("/admin/placements", s.handlePlacementList, "GET")
+	s.adminRoute("/admin/placements", s.handlePlacementPut, "POST")
+
+	// Inference mesh with other olares-ollama instances (optional)
+	if s.peers != nil {
+		s.route("/api/peer", s.handlePeerInfo, "GET")
+		s.adminRoute("/admin/peers", s.handleAdminPeers, "GET")
+	}
+
+	// Background inference jobs and scheduled prompts (optional)
+	if s.config.EnableAsyncJobs {
+		s.registerAsyncRoutes()
+	}
+	if s.config.EnableSchedules {
+		s.registerScheduleRoutes()
+	}
+
+	// Nightly maintenance: usage compaction, log rotation, model updates and clean-up
+	s.adminRoute("/admin/maintenance", s.handleMaintenanceGet, "GET")
+	s.adminRoute("/admin/maintenance/run", s.handleMaintenanceRun, "POST")
+
+	// Declarative desired state (DESIRED_STATE_FILE or the admin API) and its reconcile loop
+	s.adminRoute("/admin/desired-state", s.handleDesiredStateGet, "GET")
+	s.adminRoute("/admin/desired-state", s.handleDesiredStatePut, "PUT")
+	s.adminRoute("/admin/desired-state/reconcile", s.handleDesiredStateReconcile, "POST")
 
 	// Ollama API路由
-	s.mux.HandleFunc("/api/tags", s.handleTags)
-	s.mux.HandleFunc("/api/generate", s.handleGenerate)
-	s.mux.HandleFunc("/api/chat", s.handleChat)
-	s.mux.HandleFunc("/api/embeddings", s.handleEmbeddings)
-	s.mux.HandleFunc("/api/embed", s.handleEmbeddings)  // OpenWebUI uses /api/embed
-	s.mux.HandleFunc("/api/show", s.handleProxy)
-	s.mux.HandleFunc("/api/version", s.handleProxy)
-	s.mux.HandleFunc("/api/ps", s.handleProxy)
-	s.mux.HandleFunc("/api/stop", s.handleProxy)
-	
+	s.route("/api/tags", s.handleTags, "GET")
+	s.inferenceRoute("/api/generate", s.handleGenerate, "POST")
+	s.inferenceRoute("/api/chat", s.handleChat, "POST")
+	s.inferenceRoute("/api/embeddings", s.handleEmbeddings, "POST")
+	s.inferenceRoute("/api/embed", s.handleEmbeddings, "POST") // OpenWebUI uses /api/embed
+	s.route("/api/show", s.handleProxy, "POST")
+	s.route("/api/version", s.handleStatusPoll, "GET") // cached for STATUS_CACHE_SEC
+	s.route("/api/ps", s.handleStatusPoll, "GET")
+	s.route("/api/stop", s.handleProxy, "POST")
+	s.route("/api/compare", s.handleCompare, "POST") // side-by-side view of two models
+
 	// OpenWebUI uses /api/chat/completions (OpenAI compatible format)
-	s.mux.HandleFunc("/api/chat/completions", s.handleOpenAIChat)
-	s.mux.HandleFunc("/api/chat/completed", s.handleOpenAIChat)  // OpenWebUI completion callback
-	
+	s.inferenceRoute("/api/chat/completions", s.handleOpenAIChat, "POST")
+	s.route("/api/chat/completed", s.handleOpenAIChat, "POST") // OpenWebUI completion callback
+
 	// OpenAI compatible endpoints (some OpenWebUI versions may use these)
-	s.mux.HandleFunc("/v1/chat/completions", s.handleOpenAIChat)
-	s.mux.HandleFunc("/v1/completions", s.handleOpenAICompletions)  // OpenAI text completions
-	s.mux.HandleFunc("/v1/models", s.handleOpenAIModels)
-	s.mux.HandleFunc("/v1/embeddings", s.handleEmbeddings)  // OpenAI embeddings
-	s.mux.HandleFunc("/v1/responses", s.handleOpenAIResponses)
+	s.inferenceRoute("/v1/chat/completions", s.handleOpenAIChat, "POST")
+	s.inferenceRoute("/v1/completions", s.handleOpenAICompletions, "POST") // OpenAI text completions
+	s.route("/v1/models", s.handleOpenAIModels, "GET")
+	s.inferenceRoute("/v1/embeddings", s.handleEmbeddings, "POST") // OpenAI embeddings
+	s.inferenceRoute("/v1/responses", s.handleOpenAIResponses, "POST")
 
 	// Anthropic-compatible Messages API (e.g. Claude Code -> Ollama)
-	s.mux.HandleFunc("/v1/messages", s.handleAnthropicMessages)
-	s.mux.HandleFunc("/v1/messages/count_tokens", s.handleAnthropicMessages)
+	s.inferenceRoute("/v1/messages", s.handleAnthropicMessages, "POST")
+	s.route("/v1/messages/count_tokens", s.handleAnthropicMessages, "POST")
+
+	// Clients probe some inference endpoints with GET before using them
+	// (OpenWebUI expects a JSON object back, not a 405). Which endpoints
+	// answer is driven by COMPAT_PROFILE.
+	for _, path := range s.probePaths() {
+		s.route(path, s.handleProbe, "GET")
+	}
 
 	// 健康检查
-	s.mux.HandleFunc("/health", s.handleHealth)
+	s.route("/health", s.handleHealth, "GET")
+	s.route("/readyz", s.handleReadyz, "GET")
+	s.route("/status", s.handleAppStatus, "GET") // Kubernetes-style status for the Olares app controller
+	// grpc.health.v1 on the main port as well, for load balancers that
+	// health-check over gRPC without the gRPC API enabled (needs ENABLE_H2C)
+	s.route(grpcHealthService+"Check", s.serveGRPC, "POST")
+	s.route(grpcHealthService+"Watch", s.serveGRPC, "POST")
+
+	// Profiling (opt-in). With PPROF_PORT set, main serves it on a separate
+	// localhost listener instead so it never goes through the public port.
+	// On the public port it needs the admin role, so never without ADMIN_TOKEN.
+	if s.config.EnablePprof && s.config.PprofPort == 0 {
+		switch {
+		case s.adminMux != nil:
+			registerPprof(s.adminMux, nil)
+		case s.config.AdminToken != "":
+			registerPprof(s.mux, func(h http.HandlerFunc) http.HandlerFunc { return s.requireRole(roleAdmin, h) })
+		default:
+			log.Printf("Warning: ENABLE_PPROF without PPROF_PORT, ADMIN_ADDR or ADMIN_TOKEN would expose /debug/pprof/ on the public port; not mounted")
+		}
+	}
+}
+
+// route registers handler for path restricted to methods (Go 1.22 method
+// patterns; GET also matches HEAD). A path may be routed several times with
+// different methods/handlers. Any other method gets a 405 with an Allow header,
+// written in the endpoint's error format. OPTIONS never reaches the mux:
+// corsMiddleware answers preflights for every path.
+func (s *Server) route(path string, handler http.HandlerFunc, methods ...string) {
+	s.routeOn(s.mux, path, handler, methods...)
+}
+
+// routeOn is route for a specific mux (see managementMux). Each mux has its
+// own method-not-allowed fallback and Allow list per path.
+func (s *Server) routeOn(mux *http.ServeMux, path string, handler http.HandlerFunc, methods ...string) {
+	for _, m := range methods {
+		mux.HandleFunc(m+" "+path, handler)
+	}
+	s.routeMu.Lock()
+	paths := s.routeMethods[mux]
+	if paths == nil {
+		paths = make(map[string][]string)
+		s.routeMethods[mux] = paths
+	}
+	_, seen := paths[path]
+	paths[path] = append(paths[path], methods...)
+	s.routeMu.Unlock()
+	if seen {
+		return
+	}
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		s.routeMu.RLock()
+		allow := strings.Join(append(append([]string{}, paths[path]...), "OPTIONS"), ", ")
+		s.routeMu.RUnlock()
+		log.Printf("%s received unsupported method: %s from %s", r.URL.Path, r.Method, r.RemoteAddr)
+		w.Header().Set("Allow", allow)
+		writeError(w, errorFormatForPath(r.URL.Path), http.StatusMethodNotAllowed, "method_not_allowed",
+			fmt.Sprintf("Method %s not allowed for %s. Supported methods: %s", r.Method, r.URL.Path, allow))
+	})
+}
+
+// handleProbe answers GET probes on POST-only inference endpoints.
+func (s *Server) handleProbe(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s received GET request from %s (health check), UserAgent: %s", r.URL.Path, r.RemoteAddr, r.UserAgent())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(s.probeBody)
+	w.Write([]byte("\n"))
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
 }
 
 // handleIndex 处理首页请求
 func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path == "/" {
-		if s.config.BaseMode {
+		if s.setupNeeded() {
 			http.Redirect(w, r, "/static/base.html", http.StatusMovedPermanently)
 		} else {
 			http.Redirect(w, r, "/static/index.html", http.StatusMovedPermanently)
@@ -94,11 +393,6 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 
 // handleBaseInfo returns Ollama version and model list for the base mode UI
 func (s *Server) handleBaseInfo(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	result := map[string]interface{}{
 		"base_mode": s.config.BaseMode,
 	}
@@ -150,14 +444,24 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	response := map[string]interface{}{
 		"status": "ok",
-		"model":  s.config.Model,
+		"model":  s.model(),
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
+// exposedHeaders are the proxy's own response headers browsers may read.
+const exposedHeaders = "X-Usage-Prompt-Tokens, X-Usage-Completion-Tokens, X-Usage-Cost, X-Request-Duration-Ms, X-JSON-Repaired, " +
+	"X-Context-Truncated, X-Context-Window, X-Context-Summarized, X-Prompt-Template, X-Proxy-Lane, X-Proxy-Degraded, X-Proxy-Warnings, X-Proxy-Trace, X-Session-Id, Idempotent-Replayed, X-Served-Model, X-Stream-Id, X-Stream-Resumed, X-Queue-Depth, X-Queue-Wait-Ms"
+
 // corsMiddleware CORS中间件
 func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.audit != nil {
+			aw := s.audit.wrap(w, r)
+			defer aw.finish()
+			w = aw
+		}
+
 		// Set CORS headers on all responses, EXCEPT for embeddings endpoints
 		// Embeddings endpoints should match Ollama's response exactly (no CORS headers)
 		isEmbeddingsEndpoint := r.URL.Path == "/api/embed" || r.URL.Path == "/api/embeddings"
@@ -165,7 +469,8 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 		if !isEmbeddingsEndpoint {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-			w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Requested-With")
+			w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Requested-With, X-Prompt-Template, X-Proxy-Envelope, X-Api-Key, Idempotency-Key, X-Request-Id, Last-Event-ID, X-Output-Filters")
+			w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 			w.Header().Set("Access-Control-Max-Age", "3600")
 		}
@@ -176,13 +481,18 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 		}
 
 		// Use a ResponseWriter wrapper to log response status
+		start := time.Now()
 		wrapped := &responseLogger{ResponseWriter: w, statusCode: http.StatusOK}
+		defer s.recoverPanic(wrapped, r, start)
 		next.ServeHTTP(wrapped, r)
 		
-		// 只记录失败的请求（status 不是 200）
-		if strings.HasPrefix(r.URL.Path, "/api/") && wrapped.statusCode != http.StatusOK {
+		// 只记录失败的请求（status >= 400）
+		if strings.HasPrefix(r.URL.Path, "/api/") && wrapped.statusCode >= http.StatusBadRequest {
 			log.Printf("[ERROR] Request failed: %s %s -> Status: %d", r.Method, r.URL.Path, wrapped.statusCode)
 		}
+		if wrapped.statusCode >= http.StatusBadRequest && (s.isAPIPath(r.URL.Path) || strings.HasPrefix(r.URL.Path, "/v1/")) {
+			s.recordResponseError(r, wrapped.statusCode, wrapped.errBody, time.Since(start))
+		}
 	})
 }
 
@@ -192,13 +502,30 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 type responseLogger struct {
 	http.ResponseWriter
 	statusCode int
+	written    bool   // status line sent
+	errBody    []byte // start of the body of error responses, for the error log
 }
 
 func (rl *responseLogger) WriteHeader(code int) {
 	rl.statusCode = code
+	rl.written = true
 	rl.ResponseWriter.WriteHeader(code)
 }
 
+func (rl *responseLogger) Write(b []byte) (int, error) {
+	rl.written = true
+	if rl.statusCode >= http.StatusBadRequest && len(rl.errBody) < errorBodyCapture {
+		rl.errBody = append(rl.errBody, b[:min(len(b), errorBodyCapture-len(rl.errBody))]...)
+	}
+	return rl.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the connection (to lift the
+// write deadline for streaming responses).
+func (rl *responseLogger) Unwrap() http.ResponseWriter {
+	return rl.ResponseWriter
+}
+
 // Flush forwards to the underlying ResponseWriter when it implements
 // http.Flusher. This is required for SSE / chunked streaming through
 // middlewares that wrap the original writer.
@@ -213,12 +540,16 @@ func (s *Server) GetProgressManager() *download.ProgressManager {
 	return s.progressManager
 }
 
+// SetReporter enables crash and repeated-failure reports (nil disables them).
+func (s *Server) SetReporter(r *reporting.Reporter) {
+	s.reporter = r
+}
+
 // RegisterRetryHandler adds a POST /api/retry endpoint that triggers a
 // manual re-download attempt (wakes up the ensureModelLoop).
 func (s *Server) RegisterRetryHandler(retryCh chan<- struct{}) {
-	s.mux.HandleFunc("/api/retry", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "POST only", http.StatusMethodNotAllowed)
+	s.route("/api/retry", s.pullAction(func(w http.ResponseWriter, r *http.Request) {
+		if refuseDuringImport(w) {
 			return
 		}
 		select {
@@ -229,7 +560,7 @@ func (s *Server) RegisterRetryHandler(retryCh chan<- struct{}) {
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "retry_triggered"})
-	})
+	}), "POST")
 }
 
 // isAPIPath 检查是否为API路径
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chatSession is a server-side chat history. Lightweight clients send only
+// the new user message; the proxy rebuilds the context from here.
+type chatSession struct {
+	ID        string                   `json:"id"`
+	System    string                   `json:"system,omitempty"`
+	Messages  []map[string]interface{} `json:"messages"`
+	CreatedAt time.Time                `json:"created_at"`
+	UpdatedAt time.Time                `json:"updated_at"`
+}
+
+// sessionStore keeps sessions in memory. Idle sessions expire after ttl.
+type sessionStore struct {
+	mu          sync.Mutex
+	sessions    map[string]*chatSession
+	maxMessages int
+	ttl         time.Duration
+}
+
+func newSessionStore(maxMessages, ttlMin int) *sessionStore {
+	return &sessionStore{
+		sessions:    make(map[string]*chatSession),
+		maxMessages: maxMessages,
+		ttl:         time.Duration(ttlMin) * time.Minute,
+	}
+}
+
+// pruneLocked drops expired sessions. Caller holds st.mu.
+func (st *sessionStore) pruneLocked() {
+	if st.ttl <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-st.ttl)
+	for id, sess := range st.sessions {
+		if sess.UpdatedAt.Before(cutoff) {
+			delete(st.sessions, id)
+		}
+	}
+}
+
+// get returns a copy of the session, creating it when create is set.
+func (st *sessionStore) get(id string, create bool) (*chatSession, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.pruneLocked()
+	sess, ok := st.sessions[id]
+	if !ok {
+		if !create {
+			return nil, false
+		}
+		now := time.Now()
+		sess = &chatSession{ID: id, Messages: []map[string]interface{}{}, CreatedAt: now, UpdatedAt: now}
+		st.sessions[id] = sess
+	}
+	cp := *sess
+	cp.Messages = append([]map[string]interface{}(nil), sess.Messages...)
+	return &cp, true
+}
+
+// update applies fn to the stored session (if it still exists) and trims history.
+func (st *sessionStore) update(id string, fn func(sess *chatSession)) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	sess, ok := st.sessions[id]
+	if !ok {
+		return
+	}
+	fn(sess)
+	if st.maxMessages > 0 && len(sess.Messages) > st.maxMessages {
+		sess.Messages = append([]map[string]interface{}(nil), sess.Messages[len(sess.Messages)-st.maxMessages:]...)
+	}
+	sess.UpdatedAt = time.Now()
+}
+
+func (st *sessionStore) delete(id string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	_, ok := st.sessions[id]
+	delete(st.sessions, id)
+	return ok
+}
+
+func (st *sessionStore) list() []map[string]interface{} {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.pruneLocked()
+	out := make([]map[string]interface{}, 0, len(st.sessions))
+	for _, sess := range st.sessions {
+		out = append(out, map[string]interface{}{
+			"id":         sess.ID,
+			"messages":   len(sess.Messages),
+			"created_at": sess.CreatedAt,
+			"updated_at": sess.UpdatedAt,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i]["updated_at"].(time.Time).After(out[j]["updated_at"].(time.Time))
+	})
+	return out
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// registerSessionRoutes adds the /api/sessions API (ENABLE_SESSIONS).
+func (s *Server) registerSessionRoutes() {
+	s.route("/api/sessions", s.handleSessionList, "GET")
+	s.route("/api/sessions", s.handleSessionCreate, "POST")
+	s.route("/api/sessions/{id}", s.handleSessionGet, "GET")
+	s.route("/api/sessions/{id}", s.handleSessionDelete, "DELETE")
+	s.inferenceRoute("/api/sessions/{id}/chat", s.handleSessionChat, "POST")
+}
+
+func (s *Server) handleSessionList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"sessions": s.sessions.list()})
+}
+
+// handleSessionCreate creates a session. Body (optional): {"id": "...", "system": "..."}.
+func (s *Server) handleSessionCreate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     string `json:"id"`
+		System string `json:"system"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+			return
+		}
+	}
+	id := strings.TrimSpace(req.ID)
+	if id == "" {
+		id = newSessionID()
+	}
+	if _, exists := s.sessions.get(id, false); exists {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "session_exists", "Session already exists: "+id)
+		return
+	}
+	s.sessions.get(id, true)
+	if req.System != "" {
+		s.sessions.update(id, func(sess *chatSession) { sess.System = req.System })
+	}
+	sess, _ := s.sessions.get(id, false)
+	log.Printf("Session created: %s", id)
+	writeJSON(w, http.StatusCreated, sess)
+}
+
+func (s *Server) handleSessionGet(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.sessions.get(r.PathValue("id"), false)
+	if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "session_not_found", "Session not found: "+r.PathValue("id"))
+		return
+	}
+	writeJSON(w, http.StatusOK, sess)
+}
+
+func (s *Server) handleSessionDelete(w http.ResponseWriter, r *http.Request) {
+	if !s.sessions.delete(r.PathValue("id")) {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "session_not_found", "Session not found: "+r.PathValue("id"))
+		return
+	}
+	log.Printf("Session deleted: %s", r.PathValue("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSessionChat appends one user message to the session, sends the
+// (truncated) history to Ollama /api/chat and records the assistant reply.
+// Body: {"message": "text" | {"role": "user", "content": "...", "images": [...]},
+// "stream": true, "options": {...}, "think": ..., "format": ..., "system": "..."}.
+// Unknown session IDs are created on first use.
+func (s *Server) handleSessionChat(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+		return
+	}
+
+	var userMsg map[string]interface{}
+	switch m := req["message"].(type) {
+	case string:
+		userMsg = map[string]interface{}{"role": "user", "content": m}
+	case map[string]interface{}:
+		userMsg = m
+		if _, ok := userMsg["role"]; !ok {
+			userMsg["role"] = "user"
+		}
+	}
+	if userMsg == nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "'message' must be a string or a message object")
+		return
+	}
+
+	sess, _ := s.sessions.get(id, true)
+	if system, ok := req["system"].(string); ok {
+		sess.System = system
+		s.sessions.update(id, func(cs *chatSession) { cs.System = system })
+	}
+
+	history := append(sess.Messages, userMsg)
+	if s.config.SessionMaxMessages > 0 && len(history) > s.config.SessionMaxMessages {
+		history = history[len(history)-s.config.SessionMaxMessages:]
+	}
+	messages := make([]map[string]interface{}, 0, len(history)+1)
+	if sess.System != "" {
+		messages = append(messages, map[string]interface{}{"role": "system", "content": sess.System})
+	}
+	messages = append(messages, history...)
+
+	stream := true
+	if v, ok := req["stream"].(bool); ok {
+		stream = v
+	}
+	ollamaRequest := map[string]interface{}{
+		"model":    s.model(),
+		"messages": messages,
+		"stream":   stream,
+	}
+	for _, key := range []string{"options", "think", "format", "keep_alive", "tools"} {
+		if v, ok := req[key]; ok {
+			ollamaRequest[key] = v
+		}
+	}
+	if s.config.RepeatPenalty > 0 || s.config.RepeatLastN > 0 {
+		options, _ := ollamaRequest["options"].(map[string]interface{})
+		if options == nil {
+			options = map[string]interface{}{}
+			ollamaRequest["options"] = options
+		}
+		if _, has := options["repeat_penalty"]; !has && s.config.RepeatPenalty > 0 {
+			options["repeat_penalty"] = s.config.RepeatPenalty
+		}
+		if _, has := options["repeat_last_n"]; !has && s.config.RepeatLastN > 0 {
+			options["repeat_last_n"] = s.config.RepeatLastN
+		}
+	}
+	switch strings.ToLower(s.config.ThinkingMode) {
+	case "false", "0", "no":
+		ollamaRequest["think"] = false
+	case "true", "1", "yes":
+		if _, has := ollamaRequest["think"]; !has {
+			ollamaRequest["think"] = true
+		}
+	}
+
+	release, ok := s.prepareInference(w, r, ollamaRequest, "", 0)
+	if !ok {
+		return
+	}
+	defer release()
+
+	body, err := json.Marshal(ollamaRequest)
+	if err != nil {
+		http.Error(w, "Failed to prepare request", http.StatusInternalServerError)
+		return
+	}
+	log.Printf(">>> Session %s: proxying chat with %d messages (stream=%v) <<<", id, len(messages), stream)
+
+	resp, err := s.upstreamFor(r, "chat", ollamaRequest).ProxyRequest("POST", "/api/chat", bytes.NewReader(body),
+		map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		log.Printf("!!! Session %s: failed to proxy to Ollama: %v !!!", id, err)
+		writeUpstreamError(w, ollamaErrorFormat, upstreamErrorFromTransport(err))
+		return
+	}
+	defer resp.Body.Close()
+	resp.Body = tapUsage(r, resp.Body)
+	resp.Body = s.filterOutput(r, ollamaRequest, resp.Body)
+	if resp.StatusCode >= http.StatusBadRequest {
+		writeUpstreamError(w, ollamaErrorFormat, upstreamErrorFromResponse(resp))
+		return
+	}
+
+	w.Header().Set("X-Session-Id", id)
+	var reply strings.Builder
+	var replyMsg map[string]interface{}
+	if stream {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		flusher, _ := w.(http.Flusher)
+		upstream := newUpstreamStream(resp.Body)
+		for upstream.next() {
+			if m, ok := upstream.chunk["message"].(map[string]interface{}); ok {
+				if c, ok := m["content"].(string); ok {
+					reply.WriteString(c)
+				}
+				if tc, ok := m["tool_calls"]; ok {
+					replyMsg = map[string]interface{}{"tool_calls": tc}
+				}
+			}
+			w.Write(upstream.line)
+			w.Write([]byte("\n"))
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if f := upstream.failed(); f != nil {
+			log.Printf("!!! Session %s: stream read error: %v !!!", id, f)
+			s.recordStreamError(r.URL.Path, f)
+			writeNDJSONStreamFault(w, f)
+			return
+		}
+	} else {
+		raw, err := io.ReadAll(resp.Body)
+		if err != nil {
+			writeUpstreamError(w, ollamaErrorFormat, upstreamErrorFromTransport(err))
+			return
+		}
+		var out map[string]interface{}
+		if json.Unmarshal(raw, &out) == nil {
+			if m, ok := out["message"].(map[string]interface{}); ok {
+				if c, ok := m["content"].(string); ok {
+					reply.WriteString(c)
+				}
+				if tc, ok := m["tool_calls"]; ok {
+					replyMsg = map[string]interface{}{"tool_calls": tc}
+				}
+			}
+			out["session_id"] = id
+			writeJSON(w, http.StatusOK, out)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(raw)
+		}
+	}
+
+	if replyMsg == nil {
+		replyMsg = map[string]interface{}{}
+	}
+	replyMsg["role"] = "assistant"
+	replyMsg["content"] = reply.String()
+	s.sessions.update(id, func(cs *chatSession) {
+		cs.Messages = append(cs.Messages, userMsg, replyMsg)
+	})
+	log.Printf("<<< Session %s: recorded reply (%d chars) <<<", id, reply.Len())
+}
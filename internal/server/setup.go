@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	setupStateFile  = "setup.json"
+	setupOllamaWait = 30 * time.Minute // how long a restored choice waits for Ollama before its pull
+)
+
+// setupRecord is data/setup.json: the model chosen in the first-run setup,
+// served from then on while OLLAMA_MODEL is empty.
+type setupRecord struct {
+	Model    string    `json:"model"`
+	ChosenAt time.Time `json:"chosen_at"`
+}
+
+// setupModels lists the default tags of the catalog rated for m, in
+// catalog order, and the best rated chat model (it may be another
+// quantization), if any runs acceptably.
+func setupModels(m *machineInfo) (models []recommendation, recommended string) {
+	recs := recommend(m, defaultMinTokensPerS)
+	for _, rec := range recs {
+		if recommended == "" && rec.Acceptable && rec.Kind == "chat" {
+			recommended = rec.Model
+		}
+	}
+	for _, c := range modelCatalog {
+		if rec, ok := findRecommendation(recs, c.Name); ok {
+			models = append(models, rec)
+		}
+	}
+	return models, recommended
+}
+
+// setupNeeded reports whether the proxy has no model yet: OLLAMA_MODEL is
+// empty (base mode) and none was chosen in the setup.
+func (s *Server) setupNeeded() bool {
+	return s.config.BaseMode && s.model() == ""
+}
+
+// restoreSetup serves the model chosen in an earlier run's setup, pulling
+// it again if Ollama lost it. Only in base mode: OLLAMA_MODEL wins.
+func (s *Server) restoreSetup() {
+	if !s.config.BaseMode {
+		return
+	}
+	file := filepath.Join("data", setupStateFile)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read %s: %v", file, err)
+		}
+		return
+	}
+	var rec setupRecord
+	if err := json.Unmarshal(data, &rec); err != nil || rec.Model == "" {
+		log.Printf("Warning: failed to parse %s: %v", file, err)
+		return
+	}
+	s.activeModel.Store(rec.Model)
+	if s.hotModels != nil {
+		s.hotModels.add(rec.Model)
+	}
+	log.Printf("Serving %s (chosen in the setup on %s)", rec.Model, rec.ChosenAt.Format(time.RFC3339))
+	go s.pullSetupModel(rec.Model, true)
+}
+
+// saveSetup persists the setup's choice.
+func saveSetup(model string) error {
+	file := filepath.Join("data", setupStateFile)
+	data, _ := json.MarshalIndent(setupRecord{Model: model, ChosenAt: time.Now()}, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+// pullSetupModel makes sure Ollama has the chosen model, reporting on the
+// progress page. wait is for a restored choice, which may start before
+// Ollama does. Large downloads wait for a confirmation (ConfirmDownload).
+func (s *Server) pullSetupModel(model string, wait bool) {
+	pm := s.progressManager
+	if wait {
+		pm.UpdateProgress("waiting", 0, 0, model)
+		if err := s.ollamaClient.WaitForOllama(context.Background(), setupOllamaWait, 5*time.Second); err != nil {
+			pm.UpdateError("Ollama not ready: "+err.Error(), 0, 0, model)
+			return
+		}
+	}
+	if exists, err := s.ollamaClient.ModelExists(model); err == nil && exists {
+		pm.UpdateProgress("completed", 0, 0, model)
+		return
+	}
+	if err := s.ConfirmDownload(model); err != nil {
+		if s.model() == model { // else another model was chosen meanwhile
+			pm.UpdateError(err.Error(), 0, 0, model)
+		}
+		return
+	}
+	log.Printf("Setup: pulling %s", model)
+	if err := s.ollamaClient.PullModelWithProgress(model, pm); err != nil {
+		log.Printf("!!! Setup: pulling %s failed: %v !!!", model, err)
+		pm.UpdateError(err.Error(), 0, 0, model)
+	}
+}
+
+// handleSetupGet handles GET /api/setup: whether the first-run setup is
+// needed, the detected machine and the models to choose from.
+func (s *Server) handleSetupGet(w http.ResponseWriter, r *http.Request) {
+	machine := s.machine(r.Context())
+	models, recommended := setupModels(machine)
+	out := map[string]interface{}{
+		"needed":      s.setupNeeded(),
+		"model":       s.model(),
+		"machine":     machine,
+		"models":      models,
+		"recommended": recommended,
+	}
+	switch {
+	case !s.config.BaseMode:
+		out["configured_by"] = "OLLAMA_MODEL"
+	case s.model() != "":
+		out["configured_by"] = "setup"
+		out["progress"] = s.progressManager.GetProgress()
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleSetupPost handles POST /api/setup {"model": "..."}: serve that
+// model from now on and pull it (progress at /api/progress). Any Ollama
+// model may be chosen, not only the listed ones; those of the catalog too
+// large for the machine are refused unless "force" is set. Only while the
+// proxy has no model, or the chosen one isn't downloaded yet: after that
+// changing it is a model switch (/admin/model/switch). Choosing another
+// model declines the previous choice's download if it awaits confirmation.
+func (s *Server) handleSetupPost(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model string `json:"model"`
+		Force bool   `json:"force"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+		return
+	}
+	model := strings.TrimSpace(req.Model)
+	if model == "" {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "'model' is required")
+		return
+	}
+	if !s.config.BaseMode {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "already_configured",
+			"The model is set by OLLAMA_MODEL ("+s.config.Model+"); use /admin/model/switch to serve another one")
+		return
+	}
+	if refuseDuringImport(w) {
+		return
+	}
+	if s.progressManager.Busy() || s.progressManager.GetProgress().Status == "waiting" {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "setup_in_progress", "A model is being downloaded; wait for it or for it to fail")
+		return
+	}
+	if !s.setupNeeded() && len(s.readinessReasons()) == 0 {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "already_configured",
+			"Setup is done (serving "+s.model()+"); use /admin/model/switch to serve another one")
+		return
+	}
+	if rec, ok := findRecommendation(recommend(s.machine(r.Context()), defaultMinTokensPerS), model); ok && rec.Fit == fitTooLarge && !req.Force {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "model_too_large",
+			fmt.Sprintf("%s needs about %.1f GB of memory, more than this machine has; choose a smaller model, or set \"force\": true", model, float64(rec.Memory)/(1<<30)))
+		return
+	}
+	if err := saveSetup(model); err != nil {
+		log.Printf("!!! Failed to save the setup: %v !!!", err)
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Failed to save the setup: "+err.Error())
+		return
+	}
+	prev := s.model()
+	s.activeModel.Store(model)
+	if s.hotModels != nil {
+		s.hotModels.add(model)
+	}
+	if prev != "" {
+		s.downloads.decide(prev, false) // a download of the previous choice awaiting confirmation
+	}
+	s.configChanged(map[string]interface{}{"setting": "setup_model", "value": model})
+	log.Printf("Setup: serving %s", model)
+	go s.pullSetupModel(model, false)
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"status":       "pulling",
+		"model":        model,
+		"progress_url": "/api/progress",
+	})
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	smokeTestPrompt  = "Reply with the single word OK."
+	smokeTestTimeout = 2 * time.Minute // a cold model loads within this
+)
+
+// smokeResult is the outcome of one smoke test. A failed test of the
+// served model makes /readyz report not ready until one passes.
+type smokeResult struct {
+	Model     string    `json:"model"`
+	Passed    bool      `json:"passed"`
+	LatencyMs int64     `json:"latency_ms"`
+	Answer    string    `json:"answer,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	TestedAt  time.Time `json:"tested_at"`
+}
+
+// handleSmokeTest serves POST /admin/smoketest: the fixed prompt goes to
+// the served model (or the "model" of an optional body) and the answer is
+// the result, 200 when it passed and 503 when it didn't.
+func (s *Server) handleSmokeTest(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model string `json:"model"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+			return
+		}
+	}
+	if req.Model == "" {
+		req.Model = s.model()
+	}
+	if req.Model == "" {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "'model' is required in base mode")
+		return
+	}
+	res := s.smokeTest(r.Context(), req.Model)
+	status := http.StatusOK
+	if !res.Passed {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, res)
+}
+
+// smokeTest sends a tiny fixed prompt to model, straight to Ollama, and
+// passes when an answer comes back. What the answer says doesn't matter:
+// it checks the model loads and generates, not how well. The result is
+// kept for readiness.
+func (s *Server) smokeTest(ctx context.Context, model string) (res smokeResult) {
+	res = smokeResult{Model: model, TestedAt: time.Now()}
+	defer func() {
+		if !res.Passed {
+			log.Printf("!!! Smoke test of %s failed: %s !!!", model, res.Error)
+		}
+		s.lastSmokeTest.Store(res)
+	}()
+	ctx, cancel := context.WithTimeout(ctx, smokeTestTimeout)
+	defer cancel()
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":    model,
+		"messages": []map[string]interface{}{{"role": "user", "content": smokeTestPrompt}},
+		"stream":   false,
+		"options":  map[string]interface{}{"temperature": 0, "num_predict": 32},
+	})
+	start := time.Now()
+	resp, err := s.ollamaClient.ProxyRequestContext(ctx, "POST", "/api/chat", bytes.NewReader(body),
+		map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		res.LatencyMs = time.Since(start).Milliseconds()
+		res.Error = upstreamErrorFromTransport(err).Message
+		return res
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		res.LatencyMs = time.Since(start).Milliseconds()
+		res.Error = upstreamErrorFromResponse(resp).Message
+		return res
+	}
+	var reply ollamaChatReply
+	raw, err := io.ReadAll(resp.Body)
+	res.LatencyMs = time.Since(start).Milliseconds()
+	if err == nil {
+		err = json.Unmarshal(raw, &reply)
+	}
+	if err != nil {
+		res.Error = fmt.Sprintf("Reading the answer failed: %v", err)
+		return res
+	}
+	res.Answer = strings.TrimSpace(reply.Message.Content)
+	if res.Answer == "" && strings.TrimSpace(reply.Message.Thinking) == "" { // a thinking model may not get past thinking in 32 tokens
+		res.Error = "The model returned an empty answer"
+		return res
+	}
+	res.Passed = true
+	return res
+}
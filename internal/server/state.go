@@ -0,0 +1,302 @@
+package server
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	// stateArchiveFormat versions the layout of state archives: state.json,
+	// then data/<file> for each state file the proxy had.
+	stateArchiveFormat = 1
+	stateArchiveLimit  = 256 << 20 // a whole archive, usage history included
+)
+
+// stateArchiveInfo is state.json in a state archive.
+type stateArchiveInfo struct {
+	Format     int       `json:"format"`
+	ExportedAt time.Time `json:"exported_at"`
+	Model      string    `json:"model"` // OLLAMA_MODEL of the exporting proxy
+	Files      []string  `json:"files"`
+}
+
+// stateFile is one file of the proxy state under data/, with the shape it
+// must parse into to be restored and the mode it is restored with.
+type stateFile struct {
+	path  string
+	shape func() interface{}
+	mode  os.FileMode
+}
+
+// stateFiles are what a state archive carries: the served model switched
+// to or chosen in the setup, pins, prompt templates (model aliases), user
+// routes and tenant limits (by Olares user or API key hash), usage history,
+// scheduled prompts, model placements, the desired state put via the admin
+// API and the role keys (hashed). Download progress, the events log and the
+// maintenance report describe this box rather than the app's setup and stay
+// out.
+func (s *Server) stateFiles() []stateFile {
+	return []stateFile{
+		{filepath.Join("data", switchStateFile), func() interface{} { return &activeModelRecord{} }, 0644},
+		{s.pins.file, func() interface{} { return &[]*pin{} }, 0644},
+		{s.templates.file, func() interface{} { return &[]*promptTemplate{} }, 0644},
+		{s.userRoutes.file, func() interface{} { return &[]*userRoute{} }, 0644},
+		{s.tenantLimits.file, func() interface{} { return &[]*tenantLimit{} }, 0644},
+		{s.usage.file, func() interface{} { return &[]usageRecord{} }, 0644},
+		{s.schedules.file, func() interface{} { return &[]*promptSchedule{} }, 0644},
+		{s.placements.file, func() interface{} { return &[]*placement{} }, 0644},
+		{s.desired.file, func() interface{} { return &desiredState{} }, 0644},
+		{s.adminKeys.file, func() interface{} { return &[]*adminKey{} }, 0600}, // as adminKeyStore writes it
+		{filepath.Join("data", setupStateFile), func() interface{} { return &setupRecord{} }, 0644},
+	}
+}
+
+// handleStateExport serves GET /admin/state/export: a tar of the proxy
+// state, for Olares backups. API keys are only in it as the hashes the
+// stores keep.
+func (s *Server) handleStateExport(w http.ResponseWriter, r *http.Request) {
+	if err := s.usage.save(); err != nil {
+		log.Printf("!!! Failed to save usage: %v !!!", err)
+	}
+	info := stateArchiveInfo{Format: stateArchiveFormat, ExportedAt: time.Now().UTC(), Model: s.config.Model, Files: []string{}}
+	contents := map[string][]byte{}
+	for _, f := range s.stateFiles() {
+		data, err := os.ReadFile(f.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.Printf("!!! State export: %v !!!", err)
+			writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Failed to read "+f.path+": "+err.Error())
+			return
+		}
+		name := filepath.Base(f.path)
+		info.Files = append(info.Files, name)
+		contents[name] = data
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="olares-ollama-state-%s.tar"`, info.ExportedAt.Format("20060102-150405")))
+	tw := tar.NewWriter(w)
+	meta, _ := json.MarshalIndent(info, "", "  ")
+	err := writeTarFile(tw, "state.json", meta)
+	for _, name := range info.Files {
+		if err == nil {
+			err = writeTarFile(tw, "data/"+name, contents[name])
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		log.Printf("!!! State export aborted: %v !!!", err)
+		return
+	}
+	log.Printf("Exported the proxy state (%d files)", len(info.Files))
+	s.events.Record("state_exported", map[string]interface{}{"files": info.Files})
+}
+
+// handleStateImport serves POST /admin/state/import: the body is an archive
+// of the export endpoint. The archive replaces the whole state: its files
+// are written, the state files it doesn't have are removed (they didn't
+// exist on the exporting proxy), and the stores are reloaded without a
+// restart. Nothing is changed unless every file is valid.
+func (s *Server) handleStateImport(w http.ResponseWriter, r *http.Request) {
+	info, contents, err := s.readStateArchive(tar.NewReader(http.MaxBytesReader(w, r.Body, stateArchiveLimit)))
+	if err != nil {
+		var ie *modelError
+		if errors.As(err, &ie) {
+			log.Printf("!!! State import rejected: %s !!!", ie.message)
+			writeError(w, ollamaErrorFormat, ie.status, ie.code, ie.message)
+			return
+		}
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_archive", "Reading the archive: "+err.Error())
+		return
+	}
+
+	restored, removed := []string{}, []string{}
+	for _, f := range s.stateFiles() {
+		name := filepath.Base(f.path)
+		if data, ok := contents[name]; ok {
+			err = os.MkdirAll(filepath.Dir(f.path), 0755)
+			if err == nil {
+				err = os.WriteFile(f.path, data, f.mode)
+			}
+			if err == nil {
+				err = os.Chmod(f.path, f.mode) // WriteFile keeps the mode of an existing file
+			}
+			restored = append(restored, name)
+		} else if err = os.Remove(f.path); err == nil {
+			removed = append(removed, name)
+		} else if os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			log.Printf("!!! State import: %v !!!", err)
+			writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Failed to restore "+f.path+": "+err.Error())
+			return
+		}
+	}
+	if err := s.reloadState(); err != nil {
+		log.Printf("!!! State import: %v !!!", err)
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", err.Error())
+		return
+	}
+	log.Printf("WARNING: proxy state restored from an archive exported %s (%d files restored, %d removed)",
+		info.ExportedAt.Format(time.RFC3339), len(restored), len(removed))
+	s.events.Record("state_imported", map[string]interface{}{
+		"exported_at": info.ExportedAt, "restored": restored, "removed": removed,
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":      "success",
+		"exported_at": info.ExportedAt,
+		"restored":    restored,
+		"removed":     removed,
+	})
+}
+
+// readStateArchive reads state.json, then the state files, each checked to
+// parse as the store it belongs to.
+func (s *Server) readStateArchive(tr *tar.Reader) (*stateArchiveInfo, map[string][]byte, error) {
+	shapes := map[string]func() interface{}{}
+	for _, f := range s.stateFiles() {
+		shapes[filepath.Base(f.path)] = f.shape
+	}
+	var info *stateArchiveInfo
+	contents := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if hdr.Name == "state.json" {
+			info = &stateArchiveInfo{}
+			if err := json.Unmarshal(data, info); err != nil {
+				return nil, nil, badArchive("state.json: %v", err)
+			}
+			if info.Format < 1 || info.Format > stateArchiveFormat {
+				return nil, nil, badArchive("Archive format %d is not supported (this proxy reads format %d)", info.Format, stateArchiveFormat)
+			}
+			continue
+		}
+		if info == nil {
+			return nil, nil, badArchive("%s comes before state.json; was the archive written by /admin/state/export?", hdr.Name)
+		}
+		dir, name := path.Split(hdr.Name)
+		shape, known := shapes[name]
+		if dir != "data/" || !known {
+			return nil, nil, badArchive("Unexpected file %s in the archive", hdr.Name)
+		}
+		if err := json.Unmarshal(data, shape()); err != nil {
+			return nil, nil, badArchive("%s: %v", hdr.Name, err)
+		}
+		contents[name] = data
+	}
+	if info == nil {
+		return nil, nil, badArchive("The archive has no state.json")
+	}
+	for _, name := range info.Files {
+		if _, ok := contents[name]; !ok {
+			return nil, nil, badArchive("state.json lists %s, which the archive lacks", name)
+		}
+	}
+	for name := range contents {
+		if !slices.Contains(info.Files, name) {
+			return nil, nil, badArchive("data/%s is not listed in state.json", name)
+		}
+	}
+	return info, contents, nil
+}
+
+// reloadState re-reads the stores from the files just restored; they keep
+// their identity, only their contents change.
+func (s *Server) reloadState() error {
+	pins := newPinStore(s.config.PinnedModels)
+	s.pins.mu.Lock()
+	s.pins.pins = pins.pins
+	s.pins.mu.Unlock()
+
+	templates := newTemplateStore()
+	s.templates.mu.Lock()
+	s.templates.templates = templates.templates
+	s.templates.mu.Unlock()
+
+	routes := newUserRouteStore()
+	s.userRoutes.mu.Lock()
+	s.userRoutes.routes = routes.routes
+	s.userRoutes.mu.Unlock()
+
+	limits := newTenantLimitStore()
+	s.tenantLimits.mu.Lock()
+	s.tenantLimits.limits = limits.limits
+	s.tenantLimits.mu.Unlock()
+
+	usage := newUsageStore()
+	s.usage.mu.Lock()
+	s.usage.series = usage.series
+	s.usage.dirty = true // rewritten on the next save, over one that raced with the import
+	s.usage.mu.Unlock()
+
+	schedules := newScheduleStore()
+	s.schedules.mu.Lock()
+	s.schedules.schedules = schedules.schedules
+	s.schedules.mu.Unlock()
+
+	placements := newPlacementStore()
+	s.placements.mu.Lock()
+	s.placements.byModel = placements.byModel
+	s.placements.mu.Unlock()
+
+	keys := newAdminKeyStore()
+	s.adminKeys.mu.Lock()
+	s.adminKeys.keys = keys.keys
+	s.adminKeys.mu.Unlock()
+
+	s.desired.load()
+	s.desired.wake()
+	s.generation.Add(1)
+
+	// The served model: the one switched to on the exporting proxy, if it
+	// was switched from the same OLLAMA_MODEL, else OLLAMA_MODEL.
+	model := s.config.Model
+	if data, err := os.ReadFile(filepath.Join("data", switchStateFile)); err == nil {
+		var rec activeModelRecord
+		if json.Unmarshal(data, &rec) == nil && rec.Configured == s.config.Model && rec.Model != "" {
+			model = rec.Model
+		}
+	}
+	if model != s.model() && s.config.Model != "" {
+		if err := s.setActiveModel(model); err != nil {
+			return fmt.Errorf("switching to the restored model %s: %w", model, err)
+		}
+	}
+	if from := s.model(); s.config.BaseMode {
+		// Without OLLAMA_MODEL: the one chosen in the restored setup, if any.
+		s.activeModel.Store("")
+		s.restoreSetup()
+		if s.model() != from {
+			s.events.Record("model_switched", map[string]interface{}{"from": from, "to": s.model()})
+		}
+	}
+	return nil
+}
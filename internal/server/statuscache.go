@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusCache keeps Ollama's last answer to the cheap status endpoints
+// (/api/version, /api/ps) for STATUS_CACHE_SEC, so dashboards polling them
+// every second don't add upstream calls while Ollama is busy generating.
+// Pollers arriving while the answer is being fetched wait for that call
+// instead of making their own.
+type statusCache struct {
+	mu      sync.Mutex
+	entries map[string]*statusAnswer // by path
+}
+
+type statusAnswer struct {
+	done    chan struct{} // closed once the fields below are filled in
+	status  int
+	header  http.Header
+	body    []byte
+	err     error
+	fetched time.Time
+}
+
+func newStatusCache() *statusCache {
+	return &statusCache{entries: make(map[string]*statusAnswer)}
+}
+
+// get returns the answer for path that is at most ttl old, waiting for one
+// being fetched, or a new entry the caller must fill (owner=true).
+func (c *statusCache) get(path string, ttl time.Duration) (a *statusAnswer, owner bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if a, ok := c.entries[path]; ok {
+		select {
+		case <-a.done:
+			if a.err == nil && a.status == http.StatusOK && time.Since(a.fetched) < ttl {
+				return a, false
+			}
+		default:
+			return a, false // being fetched
+		}
+	}
+	a = &statusAnswer{done: make(chan struct{})}
+	c.entries[path] = a
+	return a, true
+}
+
+// handleStatusPoll handles GET /api/version and /api/ps: Ollama's answer,
+// shared by the requests within STATUS_CACHE_SEC. Age tells how old it is.
+func (s *Server) handleStatusPoll(w http.ResponseWriter, r *http.Request) {
+	ttl := time.Duration(s.config.StatusCacheSec) * time.Second
+	if ttl <= 0 {
+		s.handleProxy(w, r)
+		return
+	}
+	a, owner := s.statusPolls.get(r.URL.Path, ttl)
+	if owner {
+		resp, err := s.ollamaClient.ProxyRequest("GET", r.URL.Path, nil, s.upstreamHeaders(r))
+		if err == nil {
+			a.status, a.header = resp.StatusCode, resp.Header
+			a.body, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		a.err, a.fetched = err, time.Now()
+		close(a.done)
+	} else {
+		select {
+		case <-a.done:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if a.err != nil {
+		log.Printf("Failed to proxy request: %v", a.err)
+		writeUpstreamError(w, ollamaErrorFormat, upstreamErrorFromTransport(a.err))
+		return
+	}
+	if a.status >= http.StatusBadRequest {
+		writeUpstreamError(w, ollamaErrorFormat, upstreamErrorFromResponse(&http.Response{
+			StatusCode: a.status,
+			Header:     a.header,
+			Body:       io.NopCloser(bytes.NewReader(a.body)),
+		}))
+		return
+	}
+	for key, values := range a.header {
+		if strings.HasPrefix(strings.ToLower(key), "access-control-") {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(a.fetched).Seconds())))
+	w.WriteHeader(a.status)
+	w.Write(a.body)
+}
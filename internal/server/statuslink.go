@@ -0,0 +1,120 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	statusLinkParam  = "status_token" // query parameter of a status link
+	statusLinkCookie = "status_link"  // keeps the link's token for the page's own requests
+	statusLinkMaxTTL = 24 * time.Hour
+)
+
+// signStatusLink returns a status link token valid until expires:
+// "<unix expiry>.<HMAC-SHA256 of it, keyed with ADMIN_TOKEN>". Changing
+// ADMIN_TOKEN revokes every link.
+func (s *Server) signStatusLink(expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + s.statusLinkMAC(exp)
+}
+
+func (s *Server) statusLinkMAC(exp string) string {
+	mac := hmac.New(sha256.New, []byte(s.config.AdminToken))
+	mac.Write([]byte("status-link\n" + exp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkStatusLink returns when token expires, or false if it is forged,
+// malformed or expired.
+func (s *Server) checkStatusLink(token string) (time.Time, bool) {
+	exp, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(s.statusLinkMAC(exp))) {
+		return time.Time{}, false
+	}
+	expires := time.Unix(unix, 0)
+	return expires, time.Now().Before(expires)
+}
+
+// statusPage gates the progress page and the status APIs it reads when
+// STATUS_PAGE_AUTH is set: they need ADMIN_TOKEN or a role key (any role),
+// or a status link. A link's token given as ?status_token= is kept in a
+// cookie until it expires, so the page's own requests carry it too.
+func (s *Server) statusPage(h http.Handler) http.Handler {
+	if !s.config.StatusPageAuth {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.hasRole(r, roleViewer) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if token := r.URL.Query().Get(statusLinkParam); token != "" {
+			if expires, ok := s.checkStatusLink(token); ok {
+				http.SetCookie(w, &http.Cookie{
+					Name: statusLinkCookie, Value: token, Path: "/", Expires: expires,
+					HttpOnly: true, Secure: r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https", SameSite: http.SameSiteLaxMode,
+				})
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		if c, err := r.Cookie(statusLinkCookie); err == nil {
+			if _, ok := s.checkStatusLink(c.Value); ok {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		writeError(w, ollamaErrorFormat, http.StatusUnauthorized, "unauthorized",
+			"The status page needs a status link (POST /admin/status-link), the admin token or a role key; the link may have expired")
+	})
+}
+
+// handleStatusLink handles POST /admin/status-link {"ttl_min": 30}: a link
+// to the progress page, under APP_URL, that opens it without the admin
+// token until it expires (STATUS_LINK_TTL_MIN by default, at most 24h).
+func (s *Server) handleStatusLink(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TTLMin int `json:"ttl_min"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+		return
+	}
+	if s.config.AdminToken == "" {
+		writeError(w, ollamaErrorFormat, http.StatusConflict, "no_admin_token",
+			"Status links are signed with ADMIN_TOKEN; without it the status page is open anyway")
+		return
+	}
+	ttl := time.Duration(s.config.StatusLinkTTLMin) * time.Minute
+	if req.TTLMin < 0 {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "ttl_min must not be negative")
+		return
+	} else if req.TTLMin > 0 {
+		ttl = time.Duration(req.TTLMin) * time.Minute
+	}
+	ttl = min(ttl, statusLinkMaxTTL)
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	token := s.signStatusLink(expires)
+	link := strings.TrimSuffix(s.config.AppURL, "/") + "/?" + statusLinkParam + "=" + token
+	log.Printf("Signed a status link valid until %s", expires.UTC().Format(time.RFC3339))
+	s.events.Record("status_link_signed", map[string]interface{}{"expires_at": expires.UTC()})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"url":        link,
+		"token":      token,
+		"expires_at": expires.UTC(),
+	})
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// Once the 200 and the first chunks are out, a failing Ollama stream can no
+// longer become an error status. The stream readers below turn the ways it
+// fails (a dropped connection, a body that ends before the done chunk, a line
+// that isn't JSON, an {"error": ...} line) into a streamFault, and the
+// handlers end the client's stream with an error in its own protocol, so a
+// client never mistakes a truncated answer for a complete one.
+
+const maxStreamLine = 4 * 1024 * 1024 // Ollama can emit long lines with thinking + tool_calls
+
+// streamFault is why an upstream stream ended badly.
+type streamFault struct {
+	code    string
+	message string
+}
+
+func (f *streamFault) Error() string {
+	return f.message
+}
+
+// upstreamStream reads an Ollama NDJSON stream chunk by chunk.
+type upstreamStream struct {
+	sc    *bufio.Scanner
+	line  []byte // raw current line, valid until the next call to next
+	chunk map[string]interface{}
+	done  bool
+	fault *streamFault
+}
+
+func newUpstreamStream(body io.Reader) *upstreamStream {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 0, 64*1024), maxStreamLine)
+	return &upstreamStream{sc: sc}
+}
+
+// next advances to the next chunk. It returns false after the done chunk
+// and when the stream fails; fault then says why.
+func (us *upstreamStream) next() bool {
+	if us.done || us.fault != nil {
+		return false
+	}
+	for us.sc.Scan() {
+		line := bytes.TrimSpace(us.sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk map[string]interface{}
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			us.fault = &streamFault{code: "upstream_malformed_stream", message: "Ollama sent a malformed stream line: " + err.Error()}
+			return false
+		}
+		if e, ok := chunk["error"]; ok && e != nil {
+			// Ollama reports a generation that fails after the 200 this way.
+			ue := translateUpstreamError(http.StatusInternalServerError, line)
+			us.fault = &streamFault{code: ue.Code, message: ue.Message}
+			return false
+		}
+		us.line, us.chunk = line, chunk
+		us.done, _ = chunk["done"].(bool)
+		return true
+	}
+	if err := us.sc.Err(); err != nil {
+		us.fault = &streamFault{code: "stream_interrupted", message: "Ollama stream interrupted: " + err.Error()}
+	} else {
+		us.fault = &streamFault{code: "stream_interrupted", message: "Ollama stream ended before the response was complete"}
+	}
+	return false
+}
+
+// failed returns the fault that ended the stream, or nil when it ended with
+// its done chunk or the handler stopped reading early (client gone).
+func (us *upstreamStream) failed() *streamFault {
+	return us.fault
+}
+
+// relayNDJSON copies a native Ollama stream line by line, flushing each one,
+// and ends it with an {"error": ..., "code": ...} line when it fails.
+func (s *Server) relayNDJSON(w http.ResponseWriter, flusher http.Flusher, body io.Reader, path string) int64 {
+	us := newUpstreamStream(body)
+	var total int64
+	for us.next() {
+		n, err := w.Write(append(us.line, '\n'))
+		if err != nil {
+			log.Printf("!!! Error writing response for %s: %v !!!", path, err)
+			return total
+		}
+		total += int64(n)
+		flusher.Flush()
+	}
+	if f := us.failed(); f != nil {
+		s.recordStreamError(path, f)
+		writeNDJSONStreamFault(w, f)
+	}
+	return total
+}
+
+func flushStream(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// writeNDJSONStreamFault ends a native NDJSON stream the way Ollama does.
+func writeNDJSONStreamFault(w http.ResponseWriter, f *streamFault) {
+	line, _ := json.Marshal(map[string]interface{}{"error": f.message, "code": f.code})
+	w.Write(append(line, '\n'))
+	flushStream(w)
+}
+
+// writeOpenAIStreamFault ends a chat or completions SSE stream with an error
+// event, the shape OpenAI uses for errors mid-stream. No [DONE] follows.
+func writeOpenAIStreamFault(w http.ResponseWriter, f *streamFault) {
+	data, _ := json.Marshal(map[string]interface{}{"error": map[string]interface{}{
+		"message": f.message, "type": "server_error", "code": f.code, "param": nil,
+	}})
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	flushStream(w)
+}
+
+// writeResponsesStreamFault ends a Responses API stream with an error event
+// followed by response.failed.
+func writeResponsesStreamFault(w http.ResponseWriter, f *streamFault, responseID, modelName string, createdAt int64) {
+	errEvent, _ := json.Marshal(map[string]interface{}{
+		"type": "error", "code": f.code, "message": f.message, "param": nil,
+	})
+	failed, _ := json.Marshal(map[string]interface{}{
+		"type": "response.failed",
+		"response": map[string]interface{}{
+			"id": responseID, "object": "response", "created_at": createdAt,
+			"status": "failed", "model": modelName, "output": []interface{}{},
+			"error": map[string]interface{}{"code": f.code, "message": f.message},
+		},
+	})
+	fmt.Fprintf(w, "data: %s\n\ndata: %s\n\n", errEvent, failed)
+	flushStream(w)
+}
+
+// writeAnthropicStreamFault ends an Anthropic Messages stream with an error event.
+func writeAnthropicStreamFault(w http.ResponseWriter, f *streamFault) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type": "error", "error": map[string]interface{}{"type": "api_error", "message": f.message},
+	})
+	fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+	flushStream(w)
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headerPriority lets a client mark a request as background work ("low"),
+// which LOAD_SHEDDING rejects first when the host is overloaded.
+const headerPriority = "X-Priority"
+
+// systemLoad is the latest sample of the host's CPU, memory and GPU use,
+// in percent (-1 = not measurable here). Olares runs other apps on the
+// same machine; with LOAD_SHEDDING low-priority inference gives way to
+// them when one of the figures is over its limit.
+type systemLoad struct {
+	mu        sync.RWMutex
+	cpu       float64
+	memory    float64
+	gpu       float64 // utilization of the busiest GPU
+	sampledAt time.Time
+	shed      int64 // requests rejected
+
+	prevBusy, prevTotal uint64 // /proc/stat counters of the previous sample
+	gpuTool             string // nvidia-smi path; "" = no GPU figures
+}
+
+func newSystemLoad() *systemLoad {
+	l := &systemLoad{cpu: -1, memory: -1, gpu: -1}
+	if path, err := exec.LookPath("nvidia-smi"); err == nil {
+		l.gpuTool = path
+	}
+	return l
+}
+
+// watchSystemLoad samples the load every LOAD_SAMPLE_SEC.
+func (s *Server) watchSystemLoad() {
+	interval := time.Duration(s.config.LoadSampleSec) * time.Second
+	for {
+		s.sysLoad.sample()
+		time.Sleep(interval)
+	}
+}
+
+func (l *systemLoad) sample() {
+	cpu := -1.0
+	busy, total, err := readCPUCounters()
+	l.mu.RLock()
+	if err == nil && l.prevTotal > 0 && total > l.prevTotal {
+		cpu = 100 * float64(busy-l.prevBusy) / float64(total-l.prevTotal)
+	}
+	l.mu.RUnlock()
+	mem, memErr := readMemoryUse()
+	if memErr != nil {
+		mem = -1
+	}
+	gpu := -1.0
+	if l.gpuTool != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if g, err := readGPUUse(ctx, l.gpuTool); err == nil {
+			gpu = g
+		}
+		cancel()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err == nil {
+		l.prevBusy, l.prevTotal = busy, total
+	}
+	l.cpu, l.memory, l.gpu, l.sampledAt = cpu, mem, gpu, time.Now()
+}
+
+// overloads lists the figures over their limits, e.g. "CPU at 97%, over
+// 90%".
+func (s *Server) overloads() []string {
+	l := s.sysLoad
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	over := []string{}
+	for _, f := range []struct {
+		name  string
+		value float64
+		limit int
+	}{
+		{"CPU", l.cpu, s.config.LoadMaxCPUPct},
+		{"memory", l.memory, s.config.LoadMaxMemPct},
+		{"GPU", l.gpu, s.config.LoadMaxGPUPct},
+	} {
+		if f.value >= 0 && f.value > float64(f.limit) {
+			over = append(over, fmt.Sprintf("%s at %.0f%%, over %d%%", f.name, f.value, f.limit))
+		}
+	}
+	return over
+}
+
+// shedLoad rejects a low-priority request with 503 while the host is
+// overloaded. Requests of normal priority always pass.
+func (s *Server) shedLoad(w http.ResponseWriter, r *http.Request) bool {
+	if s.sysLoad == nil || !strings.EqualFold(strings.TrimSpace(r.Header.Get(headerPriority)), "low") {
+		return false
+	}
+	over := s.overloads()
+	if len(over) == 0 {
+		return false
+	}
+	reason := strings.Join(over, "; ")
+	s.sysLoad.mu.Lock()
+	s.sysLoad.shed++
+	s.sysLoad.mu.Unlock()
+	log.Printf("!!! %s shed: the host is overloaded (%s) !!!", r.URL.Path, reason)
+	traceDecision(r, "load", "shed (%s)", reason)
+	w.Header().Set("Retry-After", strconv.Itoa(s.config.LoadSampleSec))
+	writeUpstreamError(w, errorFormatForPath(r.URL.Path), &upstreamError{
+		Status:  http.StatusServiceUnavailable,
+		Code:    "system_overloaded",
+		Message: "The host is overloaded (" + reason + "); low-priority requests are rejected until it recovers",
+		Hint:    "Retry later, or send the request without X-Priority: low.",
+	})
+	return true
+}
+
+// systemLoadInfo returns the latest sample, for /api/status.
+func (s *Server) systemLoadInfo() map[string]interface{} {
+	l := s.sysLoad
+	l.mu.RLock()
+	info := map[string]interface{}{
+		"cpu_pct":       math.Round(l.cpu*10) / 10,
+		"memory_pct":    math.Round(l.memory*10) / 10,
+		"gpu_pct":       math.Round(l.gpu*10) / 10,
+		"sampled_at":    l.sampledAt,
+		"shed_requests": l.shed,
+	}
+	l.mu.RUnlock()
+	info["overloaded"] = s.overloads()
+	return info
+}
+
+// readCPUCounters returns the busy and total jiffies of all CPUs
+// (/proc/stat).
+func readCPUCounters() (busy, total uint64, err error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		return 0, 0, fmt.Errorf("/proc/stat is empty")
+	}
+	fields := strings.Fields(sc.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, fmt.Errorf("unexpected /proc/stat line %q", sc.Text())
+	}
+	for i, v := range fields[1:] {
+		n, _ := strconv.ParseUint(v, 10, 64)
+		total += n
+		if i != 3 && i != 4 { // idle, iowait
+			busy += n
+		}
+	}
+	return busy, total, nil
+}
+
+// readMemoryUse returns the share of memory not available (/proc/meminfo).
+func readMemoryUse() (float64, error) {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	var total, available float64
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total, _ = strconv.ParseFloat(fields[1], 64)
+		case "MemAvailable:":
+			available, _ = strconv.ParseFloat(fields[1], 64)
+		}
+	}
+	if total == 0 {
+		return 0, fmt.Errorf("no MemTotal in /proc/meminfo")
+	}
+	return 100 * (total - available) / total, nil
+}
+
+// readGPUUse returns the utilization of the busiest GPU from nvidia-smi.
+// GPU memory is not counted: Ollama keeps its models loaded in it.
+func readGPUUse(ctx context.Context, tool string) (float64, error) {
+	out, err := exec.CommandContext(ctx, tool, "--query-gpu=utilization.gpu", "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return 0, err
+	}
+	use := -1.0
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if util, err := strconv.ParseFloat(strings.TrimSpace(line), 64); err == nil && util > use {
+			use = util
+		}
+	}
+	if use < 0 {
+		return 0, fmt.Errorf("no GPU in nvidia-smi output")
+	}
+	return use, nil
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// promptTemplate is a named system prompt that clients select with the
+// X-Prompt-Template header or by using its name as the model (an alias).
+type promptTemplate struct {
+	Name        string    `json:"name"`
+	System      string    `json:"system"`
+	Description string    `json:"description,omitempty"`
+	Replace     bool      `json:"replace,omitempty"` // drop the client's own system messages instead of prepending
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// templateStore persists templates to data/prompt_templates.json.
+type templateStore struct {
+	mu        sync.RWMutex
+	templates map[string]*promptTemplate
+	file      string
+}
+
+func newTemplateStore() *templateStore {
+	ts := &templateStore{
+		templates: make(map[string]*promptTemplate),
+		file:      filepath.Join("data", "prompt_templates.json"),
+	}
+	data, err := os.ReadFile(ts.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read %s: %v", ts.file, err)
+		}
+		return ts
+	}
+	var list []*promptTemplate
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Warning: failed to parse %s: %v", ts.file, err)
+		return ts
+	}
+	for _, t := range list {
+		ts.templates[t.Name] = t
+	}
+	log.Printf("Loaded %d prompt templates from %s", len(list), ts.file)
+	return ts
+}
+
+func (ts *templateStore) get(name string) (*promptTemplate, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	t, ok := ts.templates[name]
+	return t, ok
+}
+
+func (ts *templateStore) list() []*promptTemplate {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	out := make([]*promptTemplate, 0, len(ts.templates))
+	for _, t := range ts.templates {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (ts *templateStore) put(t *promptTemplate) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.templates[t.Name] = t
+	return ts.saveLocked()
+}
+
+func (ts *templateStore) delete(name string) (bool, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if _, ok := ts.templates[name]; !ok {
+		return false, nil
+	}
+	delete(ts.templates, name)
+	return true, ts.saveLocked()
+}
+
+func (ts *templateStore) saveLocked() error {
+	list := make([]*promptTemplate, 0, len(ts.templates))
+	for _, t := range ts.templates {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ts.file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(ts.file, data, 0644)
+}
+
+// registerTemplateRoutes adds the prompt template admin API.
+func (s *Server) registerTemplateRoutes() {
+	s.adminRoute("/admin/templates", s.handleTemplateList, "GET")
+	s.adminRoute("/admin/templates/{name}", s.handleTemplateGet, "GET")
+	s.adminRoute("/admin/templates/{name}", s.handleTemplatePut, "PUT")
+	s.adminRoute("/admin/templates/{name}", s.handleTemplateDelete, "DELETE")
+}
+
+func (s *Server) handleTemplateList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"templates": s.templates.list()})
+}
+
+func (s *Server) handleTemplateGet(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.templates.get(r.PathValue("name"))
+	if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "template_not_found", "Prompt template not found: "+r.PathValue("name"))
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// handleTemplatePut creates or replaces a template. Body: {"system": "...", "description": "...", "replace": false}.
+func (s *Server) handleTemplatePut(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var t promptTemplate
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(t.System) == "" {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "'system' is required")
+		return
+	}
+	t.Name = name
+	t.UpdatedAt = time.Now()
+	if err := s.templates.put(&t); err != nil {
+		log.Printf("!!! Failed to save prompt templates: %v !!!", err)
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Failed to save template: "+err.Error())
+		return
+	}
+	log.Printf("Prompt template saved: %s", name)
+	writeJSON(w, http.StatusOK, &t)
+}
+
+func (s *Server) handleTemplateDelete(w http.ResponseWriter, r *http.Request) {
+	ok, err := s.templates.delete(r.PathValue("name"))
+	if err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Failed to save templates: "+err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "template_not_found", "Prompt template not found: "+r.PathValue("name"))
+		return
+	}
+	log.Printf("Prompt template deleted: %s", r.PathValue("name"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyPromptTemplate injects the template selected by X-Prompt-Template or,
+// failing that, by the client's requested model name. Chat requests get a
+// system message; generate requests get the "system" field.
+func (s *Server) applyPromptTemplate(w http.ResponseWriter, r *http.Request, req map[string]interface{}, requestedModel string) {
+	name := strings.TrimSpace(r.Header.Get("X-Prompt-Template"))
+	if name == "" {
+		name = requestedModel
+	}
+	if name == "" {
+		return
+	}
+	t, ok := s.templates.get(name)
+	if !ok {
+		if r.Header.Get("X-Prompt-Template") != "" {
+			log.Printf("Warning: unknown prompt template %q requested, ignoring", name)
+		}
+		return
+	}
+
+	if msgs := messagesOf(req["messages"]); msgs != nil {
+		out := make([]interface{}, 0, len(msgs)+1)
+		out = append(out, map[string]interface{}{"role": "system", "content": t.System})
+		for _, m := range msgs {
+			if t.Replace && roleOf(m) == "system" {
+				continue
+			}
+			out = append(out, m)
+		}
+		req["messages"] = out
+	} else if existing, _ := req["system"].(string); existing != "" && !t.Replace {
+		req["system"] = t.System + "\n\n" + existing
+	} else {
+		req["system"] = t.System
+	}
+	w.Header().Set("X-Prompt-Template", t.Name)
+	log.Printf(">>> Applied prompt template %q to %s <<<", t.Name, r.URL.Path)
+}
+
+// templateAliases returns template names to advertise as models.
+func (s *Server) templateAliases() []string {
+	var names []string
+	for _, t := range s.templates.list() {
+		names = append(names, t.Name)
+	}
+	return names
+}
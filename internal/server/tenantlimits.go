@@ -0,0 +1,274 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// tenantLimit caps the requests of one Olares user or API key, so one
+// tenant's giant RAG prompts can't monopolize prompt processing. Zero means
+// no cap.
+type tenantLimit struct {
+	Subject         string    `json:"subject"` // "user:<name>" or "key#<hash>"
+	MaxRequestBytes int64     `json:"max_request_bytes,omitempty"`
+	MaxPromptTokens int       `json:"max_prompt_tokens,omitempty"` // estimated, see estimateTokens
+	MaxTokens       int       `json:"max_tokens,omitempty"`        // output cap; also the default when the client sets none
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// tenantLimitStore persists limits to data/tenant_limits.json.
+type tenantLimitStore struct {
+	mu     sync.RWMutex
+	limits map[string]*tenantLimit
+	file   string
+}
+
+func newTenantLimitStore() *tenantLimitStore {
+	st := &tenantLimitStore{
+		limits: make(map[string]*tenantLimit),
+		file:   filepath.Join("data", "tenant_limits.json"),
+	}
+	data, err := os.ReadFile(st.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read %s: %v", st.file, err)
+		}
+		return st
+	}
+	var list []*tenantLimit
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Warning: failed to parse %s: %v", st.file, err)
+		return st
+	}
+	for _, lim := range list {
+		st.limits[lim.Subject] = lim
+	}
+	log.Printf("Loaded %d tenant limits from %s", len(list), st.file)
+	return st
+}
+
+func (st *tenantLimitStore) get(subject string) (*tenantLimit, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	lim, ok := st.limits[subject]
+	return lim, ok
+}
+
+func (st *tenantLimitStore) list() []*tenantLimit {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	out := make([]*tenantLimit, 0, len(st.limits))
+	for _, lim := range st.limits {
+		out = append(out, lim)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Subject < out[j].Subject })
+	return out
+}
+
+func (st *tenantLimitStore) put(lim *tenantLimit) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.limits[lim.Subject] = lim
+	return st.saveLocked()
+}
+
+func (st *tenantLimitStore) delete(subject string) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.limits[subject]; !ok {
+		return false, nil
+	}
+	delete(st.limits, subject)
+	return true, st.saveLocked()
+}
+
+func (st *tenantLimitStore) saveLocked() error {
+	list := make([]*tenantLimit, 0, len(st.limits))
+	for _, lim := range st.limits {
+		list = append(list, lim)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Subject < list[j].Subject })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(st.file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(st.file, data, 0644)
+}
+
+// limitFor returns the limit of the request's most specific subject that
+// has one (the API key before the user), like applyUserRoute.
+func (s *Server) limitFor(r *http.Request) (*tenantLimit, bool) {
+	for _, subject := range s.subjectsOf(r) {
+		if lim, ok := s.tenantLimits.get(subject); ok {
+			return lim, true
+		}
+	}
+	return nil, false
+}
+
+// countingBody counts the request body bytes the handler reads.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// checkRequestSize rejects a body over the tenant's max_request_bytes, by
+// Content-Length up front or while it is read otherwise.
+func (s *Server) checkRequestSize(w http.ResponseWriter, r *http.Request) bool {
+	lim, ok := s.limitFor(r)
+	if !ok || lim.MaxRequestBytes <= 0 {
+		return true
+	}
+	if r.ContentLength > lim.MaxRequestBytes {
+		log.Printf("!!! %s: %s sent %d bytes, over its limit of %d !!!", r.URL.Path, lim.Subject, r.ContentLength, lim.MaxRequestBytes)
+		writeError(w, errorFormatForPath(r.URL.Path), http.StatusRequestEntityTooLarge, "request_too_large",
+			fmt.Sprintf("Request body is %d bytes, over the %d-byte limit for %s", r.ContentLength, lim.MaxRequestBytes, lim.Subject))
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, lim.MaxRequestBytes)
+	return true
+}
+
+// promptTokens estimates the prompt of an Ollama or Anthropic request:
+// messages, prompt and a top-level system string.
+func promptTokens(req map[string]interface{}) int {
+	n := 0
+	for _, m := range messagesOf(req["messages"]) {
+		if mm, ok := m.(map[string]interface{}); ok {
+			n += messageTokens(mm)
+		}
+	}
+	if prompt, ok := req["prompt"].(string); ok {
+		n += estimateTokens(prompt)
+	}
+	n += estimateTokens(flattenContent(req["system"]))
+	return n
+}
+
+// enforceTenantLimits applies the tenant's prompt and output caps to a
+// request before admission. A prompt or an explicit max_tokens over the cap
+// is rejected with an error naming both numbers. maxTokens is the client's
+// cap when it is not already in options.num_predict.
+func (s *Server) enforceTenantLimits(w http.ResponseWriter, r *http.Request, req map[string]interface{}, maxTokens int) bool {
+	lim, ok := s.limitFor(r)
+	if !ok {
+		return true
+	}
+	format := errorFormatForPath(r.URL.Path)
+	if lim.MaxPromptTokens > 0 {
+		if n := promptTokens(req); n > lim.MaxPromptTokens {
+			log.Printf("!!! %s: %s prompt of ~%d tokens is over its limit of %d !!!", r.URL.Path, lim.Subject, n, lim.MaxPromptTokens)
+			writeError(w, format, http.StatusRequestEntityTooLarge, "prompt_too_large",
+				fmt.Sprintf("Prompt is about %d tokens, over the %d-token limit for %s; send less context", n, lim.MaxPromptTokens, lim.Subject))
+			return false
+		}
+	}
+	if client := clientMaxTokens(req, maxTokens); lim.MaxTokens > 0 && client > lim.MaxTokens {
+		writeError(w, format, http.StatusBadRequest, "max_tokens_exceeded",
+			fmt.Sprintf("max_tokens %d is over the %d limit for %s", client, lim.MaxTokens, lim.Subject))
+		return false
+	}
+	return true
+}
+
+// applyTenantMaxTokens gives a request without an output cap the tenant's.
+// It runs after admission, so the fast lane classifies on what the client
+// asked for rather than on the tenant default.
+func (s *Server) applyTenantMaxTokens(r *http.Request, req map[string]interface{}, maxTokens int) {
+	lim, ok := s.limitFor(r)
+	// Ollama treats num_predict <= 0 (-1, -2) as unlimited.
+	if !ok || lim.MaxTokens <= 0 || clientMaxTokens(req, maxTokens) > 0 {
+		return
+	}
+	options, _ := req["options"].(map[string]interface{})
+	if options == nil {
+		options = map[string]interface{}{}
+		req["options"] = options
+	}
+	delete(req, "num_predict")
+	options["num_predict"] = lim.MaxTokens
+}
+
+// clientMaxTokens is the output cap the client sent: maxTokens, else
+// options.num_predict, else a top-level num_predict.
+func clientMaxTokens(req map[string]interface{}, maxTokens int) int {
+	if maxTokens != 0 {
+		return maxTokens
+	}
+	options, _ := req["options"].(map[string]interface{})
+	if n := intParam(options["num_predict"]); n != 0 {
+		return n
+	}
+	return intParam(req["num_predict"])
+}
+
+// registerTenantLimitRoutes adds the per-tenant limits admin API.
+func (s *Server) registerTenantLimitRoutes() {
+	s.adminRoute("/admin/tenant-limits", s.handleTenantLimitList, "GET")
+	s.adminRoute("/admin/tenant-limits/{subject}", s.handleTenantLimitPut, "PUT")
+	s.adminRoute("/admin/tenant-limits/{subject}", s.handleTenantLimitDelete, "DELETE")
+}
+
+func (s *Server) handleTenantLimitList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"limits": s.tenantLimits.list()})
+}
+
+// handleTenantLimitPut creates or replaces a tenant's limits.
+// Body: {"max_request_bytes": ..., "max_prompt_tokens": ..., "max_tokens": ...}.
+func (s *Server) handleTenantLimitPut(w http.ResponseWriter, r *http.Request) {
+	subject, ok := normalizeSubject(r.PathValue("subject"))
+	if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Subject must be user:<name> or key:<api key>")
+		return
+	}
+	var lim tenantLimit
+	if err := json.NewDecoder(r.Body).Decode(&lim); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+		return
+	}
+	if lim.MaxRequestBytes < 0 || lim.MaxPromptTokens < 0 || lim.MaxTokens < 0 {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Limits must be positive (0 = no cap)")
+		return
+	}
+	lim.Subject = subject
+	lim.UpdatedAt = time.Now()
+	if err := s.tenantLimits.put(&lim); err != nil {
+		log.Printf("!!! Failed to save tenant limits: %v !!!", err)
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Failed to save limits: "+err.Error())
+		return
+	}
+	log.Printf("Tenant limits saved: %s", subject)
+	writeJSON(w, http.StatusOK, &lim)
+}
+
+func (s *Server) handleTenantLimitDelete(w http.ResponseWriter, r *http.Request) {
+	subject, _ := normalizeSubject(r.PathValue("subject"))
+	ok, err := s.tenantLimits.delete(subject)
+	if err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Failed to save limits: "+err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "limits_not_found", "Tenant limits not found: "+r.PathValue("subject"))
+		return
+	}
+	log.Printf("Tenant limits deleted: %s", subject)
+	w.WriteHeader(http.StatusNoContent)
+}
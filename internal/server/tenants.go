@@ -0,0 +1,379 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	usageBucket    = 5 * time.Minute     // resolution of the usage store
+	usageRetention = 30 * 24 * time.Hour // longest window /admin/tenants can report
+	usageSaveEvery = time.Minute
+
+	// The nightly maintenance merges buckets older than usageCompactAfter
+	// into usageCompactBucket ones.
+	usageCompactAfter  = 24 * time.Hour
+	usageCompactBucket = time.Hour
+)
+
+// usageCounts are the totals of one tenant and model over one bucket.
+type usageCounts struct {
+	Requests         int64   `json:"requests"`
+	ClientErrors     int64   `json:"client_errors"` // 4xx
+	ServerErrors     int64   `json:"server_errors"` // 5xx
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	LatencyMs        int64   `json:"latency_ms"` // sum, for the average
+	RequestBytes     int64   `json:"request_bytes"`
+	ResponseBytes    int64   `json:"response_bytes"`
+	MaxRequestBytes  int64   `json:"max_request_bytes"` // largest single request body
+	Cost             float64 `json:"cost"`              // MODEL_COSTS estimate at the time of the request
+}
+
+func (c *usageCounts) add(o *usageCounts) {
+	c.Requests += o.Requests
+	c.ClientErrors += o.ClientErrors
+	c.ServerErrors += o.ServerErrors
+	c.PromptTokens += o.PromptTokens
+	c.CompletionTokens += o.CompletionTokens
+	c.LatencyMs += o.LatencyMs
+	c.RequestBytes += o.RequestBytes
+	c.ResponseBytes += o.ResponseBytes
+	c.MaxRequestBytes = max(c.MaxRequestBytes, o.MaxRequestBytes)
+	c.Cost += o.Cost
+}
+
+// usageSample is one finished request.
+type usageSample struct {
+	status                      int // 0 if nothing was written (client went away)
+	latency                     time.Duration
+	prompt, completion          int
+	cost                        float64
+	requestBytes, responseBytes int64
+}
+
+type usageKey struct {
+	Tenant string
+	Model  string
+}
+
+// usageStore counts inference requests per tenant (API key or Olares user,
+// see subjectsOf) and served model in 5-minute buckets, kept for 30 days
+// and saved to data/usage.json so the numbers survive restarts.
+type usageStore struct {
+	mu     sync.Mutex
+	series map[usageKey]map[int64]*usageCounts // bucket start (unix seconds) -> counts
+	dirty  bool
+	file   string
+}
+
+// usageRecord is one line of data/usage.json.
+type usageRecord struct {
+	Tenant string `json:"tenant"`
+	Model  string `json:"model"`
+	Bucket int64  `json:"bucket"`
+	usageCounts
+}
+
+func newUsageStore() *usageStore {
+	st := &usageStore{
+		series: make(map[usageKey]map[int64]*usageCounts),
+		file:   filepath.Join("data", "usage.json"),
+	}
+	data, err := os.ReadFile(st.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read %s: %v", st.file, err)
+		}
+		return st
+	}
+	var records []usageRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		log.Printf("Warning: failed to parse %s: %v", st.file, err)
+		return st
+	}
+	for _, rec := range records {
+		counts := rec.usageCounts
+		st.bucketsLocked(usageKey{rec.Tenant, rec.Model})[rec.Bucket] = &counts
+	}
+	return st
+}
+
+func (st *usageStore) bucketsLocked(key usageKey) map[int64]*usageCounts {
+	buckets := st.series[key]
+	if buckets == nil {
+		buckets = make(map[int64]*usageCounts)
+		st.series[key] = buckets
+	}
+	return buckets
+}
+
+// record adds one finished request.
+func (st *usageStore) record(tenant, model string, sample usageSample) {
+	bucket := time.Now().Truncate(usageBucket).Unix()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	buckets := st.bucketsLocked(usageKey{tenant, model})
+	c := buckets[bucket]
+	if c == nil {
+		c = &usageCounts{}
+		buckets[bucket] = c
+	}
+	c.Requests++
+	switch {
+	case sample.status >= http.StatusInternalServerError:
+		c.ServerErrors++
+	case sample.status >= http.StatusBadRequest:
+		c.ClientErrors++
+	}
+	c.PromptTokens += int64(sample.prompt)
+	c.CompletionTokens += int64(sample.completion)
+	c.LatencyMs += sample.latency.Milliseconds()
+	c.RequestBytes += sample.requestBytes
+	c.ResponseBytes += sample.responseBytes
+	c.MaxRequestBytes = max(c.MaxRequestBytes, sample.requestBytes)
+	c.Cost += sample.cost
+	st.dirty = true
+}
+
+// totals sums every tenant's counts per model since the given time.
+func (st *usageStore) totals(since time.Time) map[string]map[string]*usageCounts {
+	from := since.Truncate(usageBucket).Unix()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	out := make(map[string]map[string]*usageCounts)
+	for key, buckets := range st.series {
+		for start, c := range buckets {
+			if start < from {
+				continue
+			}
+			models := out[key.Tenant]
+			if models == nil {
+				models = make(map[string]*usageCounts)
+				out[key.Tenant] = models
+			}
+			sum := models[key.Model]
+			if sum == nil {
+				sum = &usageCounts{}
+				models[key.Model] = sum
+			}
+			sum.add(c)
+		}
+	}
+	return out
+}
+
+// compact drops the buckets past the retention, merges those older than a
+// day into hourly ones and saves the store. It returns the number of
+// buckets before and after.
+func (st *usageStore) compact() (before, after int, err error) {
+	now := time.Now()
+	cutoff := now.Add(-usageRetention).Unix()
+	coarse := now.Add(-usageCompactAfter).Unix()
+	st.mu.Lock()
+	for key, buckets := range st.series {
+		before += len(buckets)
+		kept := make(map[int64]*usageCounts, len(buckets))
+		for start, c := range buckets {
+			switch {
+			case start < cutoff:
+				continue
+			case start < coarse:
+				start = time.Unix(start, 0).Truncate(usageCompactBucket).Unix()
+			}
+			if sum := kept[start]; sum != nil {
+				sum.add(c)
+			} else {
+				kept[start] = c
+			}
+		}
+		if len(kept) == 0 {
+			delete(st.series, key)
+			continue
+		}
+		st.series[key] = kept
+		after += len(kept)
+	}
+	st.dirty = true
+	st.mu.Unlock()
+	return before, after, st.save()
+}
+
+// lastUsed returns the start of the latest bucket of each model, across
+// tenants.
+func (st *usageStore) lastUsed() map[string]time.Time {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	out := make(map[string]time.Time)
+	for key, buckets := range st.series {
+		for start := range buckets {
+			if t := time.Unix(start, 0); t.After(out[key.Model]) {
+				out[key.Model] = t
+			}
+		}
+	}
+	return out
+}
+
+// saveLoop prunes buckets past the retention and writes the store when it changed.
+func (st *usageStore) saveLoop() {
+	ticker := time.NewTicker(usageSaveEvery)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := st.save(); err != nil {
+			log.Printf("!!! Failed to save usage: %v !!!", err)
+		}
+	}
+}
+
+func (st *usageStore) save() error {
+	cutoff := time.Now().Add(-usageRetention).Unix()
+	st.mu.Lock()
+	if !st.dirty {
+		st.mu.Unlock()
+		return nil
+	}
+	var records []usageRecord
+	for key, buckets := range st.series {
+		for start, c := range buckets {
+			if start < cutoff {
+				delete(buckets, start)
+				continue
+			}
+			records = append(records, usageRecord{Tenant: key.Tenant, Model: key.Model, Bucket: start, usageCounts: *c})
+		}
+		if len(buckets) == 0 {
+			delete(st.series, key)
+		}
+	}
+	st.dirty = false
+	st.mu.Unlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Bucket != records[j].Bucket {
+			return records[i].Bucket < records[j].Bucket
+		}
+		return records[i].Tenant+records[i].Model < records[j].Tenant+records[j].Model
+	})
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(st.file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(st.file, data, 0644)
+}
+
+// tenantOf names the tenant a request is counted under: its most specific
+// subject, or "anonymous".
+func (s *Server) tenantOf(r *http.Request) string {
+	if subjects := s.subjectsOf(r); len(subjects) > 0 {
+		return subjects[0]
+	}
+	return "anonymous"
+}
+
+// parseUsageWindow accepts Go durations ("90m", "24h") and days ("7d").
+func parseUsageWindow(v string) (time.Duration, bool) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		return time.Duration(n) * 24 * time.Hour, err == nil && n > 0
+	}
+	d, err := time.ParseDuration(v)
+	return d, err == nil && d > 0
+}
+
+// handleAdminTenants summarizes usage per tenant over ?window= (default 24h,
+// at most 30d): requests, tokens, estimated cost, body sizes, average latency
+// and error rates, with a per-model breakdown and the tenant's limits.
+func (s *Server) handleAdminTenants(w http.ResponseWriter, r *http.Request) {
+	window := 24 * time.Hour
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, ok := parseUsageWindow(v)
+		if !ok || d > usageRetention {
+			writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "window must be a duration like 1h, 24h or 7d, at most 30d")
+			return
+		}
+		window = d
+	}
+	since := time.Now().Add(-window)
+
+	summarize := func(c *usageCounts) map[string]interface{} {
+		out := map[string]interface{}{
+			"requests":          c.Requests,
+			"prompt_tokens":     c.PromptTokens,
+			"completion_tokens": c.CompletionTokens,
+			"client_errors":     c.ClientErrors,
+			"server_errors":     c.ServerErrors,
+			"error_rate":        0.0,
+			"avg_latency_ms":    0.0,
+			"request_bytes":     c.RequestBytes,
+			"response_bytes":    c.ResponseBytes,
+			"max_request_bytes": c.MaxRequestBytes,
+			"avg_request_bytes": 0.0,
+			"cost":              c.Cost,
+		}
+		if c.Requests > 0 {
+			out["error_rate"] = float64(c.ClientErrors+c.ServerErrors) / float64(c.Requests)
+			out["avg_latency_ms"] = float64(c.LatencyMs) / float64(c.Requests)
+			out["avg_request_bytes"] = float64(c.RequestBytes) / float64(c.Requests)
+		}
+		return out
+	}
+
+	var total usageCounts
+	var tenants []map[string]interface{}
+	for tenant, models := range s.usage.totals(since) {
+		var sum usageCounts
+		perModel := make(map[string]interface{}, len(models))
+		for model, c := range models {
+			sum.add(c)
+			perModel[model] = summarize(c)
+		}
+		total.add(&sum)
+		entry := summarize(&sum)
+		entry["tenant"] = tenant
+		entry["models"] = perModel
+		if rt, ok := s.userRoutes.get(tenant); ok && rt.Description != "" {
+			entry["label"] = rt.Description
+		}
+		if lim, ok := s.tenantLimits.get(tenant); ok {
+			entry["limits"] = lim
+		}
+		tenants = append(tenants, entry)
+	}
+	// cost_share is the tenant's part of the window's estimated cost, the
+	// figure for comparing consumption between people sharing the box.
+	for _, entry := range tenants {
+		share := 0.0
+		if total.Cost > 0 {
+			share = entry["cost"].(float64) / total.Cost
+		}
+		entry["cost_share"] = share
+	}
+	sort.Slice(tenants, func(i, j int) bool {
+		ri, rj := tenants[i]["requests"].(int64), tenants[j]["requests"].(int64)
+		if ri != rj {
+			return ri > rj
+		}
+		return tenants[i]["tenant"].(string) < tenants[j]["tenant"].(string)
+	})
+	if tenants == nil {
+		tenants = []map[string]interface{}{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"window":  window.String(),
+		"since":   since,
+		"tenants": tenants,
+		"totals":  summarize(&total),
+	})
+}
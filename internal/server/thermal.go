@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"olares-ollama/internal/config"
+)
+
+// thermalSettings are the knobs of thermal mode, from THERMAL_* and
+// PUT /admin/thermal.
+type thermalSettings struct {
+	Enabled       bool `json:"enabled"`
+	MaxConcurrent int  `json:"max_concurrent"`
+	DutyCyclePct  int  `json:"duty_cycle_pct"`
+	WindowSec     int  `json:"window_sec"`
+	CooldownSec   int  `json:"cooldown_sec"`
+}
+
+// thermalGovernor keeps a fanless or battery-backed device from running
+// hot: at most MaxConcurrent generations at once, and once generations
+// have kept it busy for more than DutyCyclePct of the last WindowSec, a
+// CooldownSec pause before the next one starts (repeated until the duty
+// cycle is back under the limit).
+type thermalGovernor struct {
+	mu       sync.Mutex
+	settings thermalSettings
+	active   int        // generations running
+	spans    []busySpan // busy periods within the window, oldest first
+	wake     chan struct{}
+
+	cooldownUntil time.Time
+	cooldowns     int64         // pauses inserted
+	delayed       time.Duration // total time requests waited
+}
+
+// busySpan is a period when at least one generation ran; end is zero
+// while it goes on.
+type busySpan struct {
+	start, end time.Time
+}
+
+func newThermalGovernor(cfg *config.Config) *thermalGovernor {
+	return &thermalGovernor{
+		settings: thermalSettings{
+			Enabled:       cfg.ThermalMode,
+			MaxConcurrent: cfg.ThermalMaxConcurrent,
+			DutyCyclePct:  cfg.ThermalDutyCyclePct,
+			WindowSec:     cfg.ThermalWindowSec,
+			CooldownSec:   cfg.ThermalCooldownSec,
+		},
+		wake: make(chan struct{}),
+	}
+}
+
+// acquire waits until a generation may start and returns its release func.
+func (g *thermalGovernor) acquire(ctx context.Context) (release func(), waited time.Duration, err error) {
+	start := time.Now()
+	for {
+		g.mu.Lock()
+		st := g.settings
+		if !st.Enabled {
+			g.mu.Unlock()
+			return func() {}, time.Since(start), nil
+		}
+		now := time.Now()
+		if now.After(g.cooldownUntil) && g.active < st.MaxConcurrent {
+			if duty := g.dutyLocked(now); duty > float64(st.DutyCyclePct) && st.CooldownSec > 0 {
+				g.cooldownUntil = now.Add(time.Duration(st.CooldownSec) * time.Second)
+				g.cooldowns++
+				log.Printf("Thermal: busy %.0f%% of the last %ds (limit %d%%), pausing generations for %ds",
+					duty, st.WindowSec, st.DutyCyclePct, st.CooldownSec)
+			} else {
+				if g.active == 0 {
+					g.spans = append(g.spans, busySpan{start: now})
+				}
+				g.active++
+				waited = time.Since(start)
+				g.delayed += waited
+				g.mu.Unlock()
+				var once sync.Once
+				return func() { once.Do(g.release) }, waited, nil
+			}
+		}
+		wake, until := g.wake, time.Until(g.cooldownUntil)
+		g.mu.Unlock()
+
+		if until <= 0 {
+			until = time.Minute // woken by a release or a settings change
+		}
+		timer := time.NewTimer(until)
+		select {
+		case <-wake:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, time.Since(start), ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+func (g *thermalGovernor) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.active--; g.active == 0 && len(g.spans) > 0 {
+		g.spans[len(g.spans)-1].end = time.Now()
+	}
+	g.wakeLocked()
+}
+
+func (g *thermalGovernor) wakeLocked() {
+	close(g.wake)
+	g.wake = make(chan struct{})
+}
+
+// dutyLocked returns the share of the window (percent) generations kept
+// the device busy, dropping the spans that ended before it.
+func (g *thermalGovernor) dutyLocked(now time.Time) float64 {
+	window := time.Duration(g.settings.WindowSec) * time.Second
+	from := now.Add(-window)
+	var busy time.Duration
+	kept := g.spans[:0]
+	for _, sp := range g.spans {
+		end := sp.end
+		if end.IsZero() {
+			end = now
+		}
+		if end.Before(from) {
+			continue
+		}
+		kept = append(kept, sp)
+		if sp.start.Before(from) {
+			busy += end.Sub(from)
+		} else {
+			busy += end.Sub(sp.start)
+		}
+	}
+	g.spans = kept
+	return 100 * float64(busy) / float64(window)
+}
+
+func (g *thermalGovernor) info() map[string]interface{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	info := map[string]interface{}{
+		"settings":       g.settings,
+		"active":         g.active,
+		"duty_cycle_pct": int(g.dutyLocked(now) + 0.5),
+		"cooldowns":      g.cooldowns,
+		"total_delay_ms": g.delayed.Milliseconds(),
+		"cooling_down":   now.Before(g.cooldownUntil),
+	}
+	if now.Before(g.cooldownUntil) {
+		info["cooldown_ends_at"] = g.cooldownUntil
+	}
+	return info
+}
+
+// admitThermal waits for the thermal governor before a generation. ok=false
+// means the client went away meanwhile.
+func (s *Server) admitThermal(r *http.Request) (release func(), ok bool) {
+	release, waited, err := s.thermal.acquire(r.Context())
+	if err != nil {
+		log.Printf("!!! %s: client gave up during a thermal pause: %v !!!", r.URL.Path, err)
+		return nil, false
+	}
+	if waited >= time.Second {
+		traceDecision(r, "thermal", "waited %s", waited.Round(time.Second))
+	}
+	return release, true
+}
+
+// handleThermalGet handles GET /admin/thermal.
+func (s *Server) handleThermalGet(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.thermal.info())
+}
+
+// handleThermalPut handles PUT /admin/thermal: the fields given replace
+// the current settings, e.g. {"enabled": true, "duty_cycle_pct": 50}.
+// Changes last until the next restart.
+func (s *Server) handleThermalPut(w http.ResponseWriter, r *http.Request) {
+	g := s.thermal
+	g.mu.Lock()
+	st := g.settings
+	g.mu.Unlock()
+	if err := json.NewDecoder(r.Body).Decode(&st); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+		return
+	}
+	switch {
+	case st.MaxConcurrent < 1:
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "max_concurrent must be at least 1")
+		return
+	case st.DutyCyclePct < 1 || st.DutyCyclePct > 100:
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "duty_cycle_pct must be 1-100")
+		return
+	case st.WindowSec < 1 || st.CooldownSec < 0:
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "window_sec must be at least 1 and cooldown_sec not negative")
+		return
+	}
+	g.mu.Lock()
+	g.settings = st
+	if !st.Enabled {
+		g.cooldownUntil = time.Time{}
+	}
+	g.wakeLocked()
+	g.mu.Unlock()
+	s.configChanged(map[string]interface{}{
+		"setting": "THERMAL_MODE", "value": st,
+	})
+	log.Printf("WARNING: thermal mode set to %+v by admin", st)
+	writeJSON(w, http.StatusOK, g.info())
+}
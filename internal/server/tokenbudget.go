@@ -0,0 +1,81 @@
+package server
+
+import (
+	"log"
+	"net/http"
+)
+
+// tokenBudget holds a streamed OpenAI-format response to the client's
+// max_tokens (or MAX_TOKENS_CAP) proxy-side. The limit goes upstream as
+// num_predict, so Ollama normally stops on its own; the budget is the
+// backstop for a runner that ignores the option and streams on. Tokens are
+// counted from Ollama's eval_count when a chunk carries it, else one per
+// chunk (Ollama streams one token per chunk); a chunk past the budget is
+// not sent and the response ends with finish_reason "length". Returning
+// from the handler closes the upstream body, which makes Ollama stop
+// generating.
+type tokenBudget struct {
+	limit int // completion tokens allowed; 0 = no budget
+	used  int
+	meta  *requestMeta
+}
+
+// streamTokenBudget returns the budget of an OpenAI-format stream: the
+// num_predict the request ends up with (client value clamped to the cap),
+// else the client's maxTokens.
+func streamTokenBudget(r *http.Request, req map[string]interface{}, maxTokens int) *tokenBudget {
+	limit := maxTokens
+	if options, ok := req["options"].(map[string]interface{}); ok {
+		if n := intParam(options["num_predict"]); n > 0 {
+			limit = n
+		}
+	}
+	if limit < 0 { // Ollama's -1/-2: unlimited
+		limit = 0
+	}
+	return &tokenBudget{limit: limit, meta: metaFrom(r)}
+}
+
+// overrun counts the tokens of a chunk that isn't the done chunk (its
+// eval_count, when Ollama sends one, is the total so far) and reports
+// whether it goes past the budget, which ends the stream. The budget is
+// recorded as the completion usage: the done chunk that would carry
+// Ollama's counts never arrives.
+func (b *tokenBudget) overrun(chunk map[string]interface{}) bool {
+	if b == nil || b.limit <= 0 {
+		return false
+	}
+	text, _ := chunk["response"].(string)
+	thinking, _ := chunk["thinking"].(string)
+	if message, ok := chunk["message"].(map[string]interface{}); ok {
+		text, _ = message["content"].(string)
+		thinking, _ = message["thinking"].(string)
+	}
+	counted, ok := chunk["eval_count"].(float64)
+	if ok {
+		b.used = int(counted)
+	}
+	if text == "" && thinking == "" {
+		return false
+	}
+	if !ok {
+		b.used++
+	}
+	if b.used <= b.limit {
+		return false
+	}
+	log.Printf(">>> Model streamed past max_tokens %d; ending the response with finish_reason \"length\" <<<", b.limit)
+	if b.meta != nil {
+		b.meta.addUsage(0, b.limit)
+	}
+	return true
+}
+
+// doneFinishReason maps the done_reason of a done chunk to an OpenAI
+// finish_reason: "length" when Ollama hit num_predict, else fallback.
+func doneFinishReason(chunk map[string]interface{}, fallback string) string {
+	if reason, _ := chunk["done_reason"].(string); reason == "length" {
+		return "length"
+	}
+	return fallback
+}
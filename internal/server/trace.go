@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// headerTrace opts an inference request into the decision trace: with
+// "X-Proxy-Trace: 1" the response carries, in the same header, the steps
+// the proxy took on the way to Ollama (format detected, model substitution,
+// parameters mapped and dropped, streaming mode, ...), separated by "; ".
+// Decisions made after the status is written are not included.
+const headerTrace = "X-Proxy-Trace"
+
+// traceDecision records one step for X-Proxy-Trace. A no-op unless the
+// request asked for the trace.
+func traceDecision(r *http.Request, step, format string, args ...interface{}) {
+	meta := metaFrom(r)
+	if meta == nil || !meta.tracing {
+		return
+	}
+	entry := step + ": " + fmt.Sprintf(format, args...)
+	meta.update(func(m *requestMeta) { m.trace = append(m.trace, entry) })
+}
+
+// traceRequestFormat names the API dialect of an inference path.
+func traceRequestFormat(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/v1/messages"):
+		return "anthropic"
+	case errorFormatForPath(path) == openAIErrorFormat:
+		return "openai"
+	}
+	return "ollama"
+}
+
+// traceOutcome records what prepareInference settled on: the model (and
+// why it differs from the requested one), streaming mode, lane and options.
+func (s *Server) traceOutcome(w http.ResponseWriter, r *http.Request, req map[string]interface{}, requestedModel string) {
+	meta := metaFrom(r)
+	if meta == nil || !meta.tracing {
+		return
+	}
+	model, _ := req["model"].(string)
+	var reason string
+	meta.update(func(m *requestMeta) {
+		switch {
+		case m.userRouted:
+			reason = "user route"
+		case m.routingRule != "":
+			reason = "routing rule " + m.routingRule
+		}
+	})
+	lane := w.Header().Get("X-Proxy-Lane")
+	if reason == "" && lane == "fast" && s.config.FastLaneModel != "" {
+		reason = "fast lane"
+	}
+	switch {
+	case requestedModel == "" || requestedModel == model:
+		traceDecision(r, "model", "%s", model)
+	case reason != "":
+		traceDecision(r, "model", "%s -> %s (%s)", requestedModel, model, reason)
+	default:
+		traceDecision(r, "model", "%s -> %s (served model)", requestedModel, model)
+	}
+	stream := true // Ollama's default
+	if v, ok := req["stream"].(bool); ok {
+		stream = v
+	}
+	traceDecision(r, "stream", "%s", strconv.FormatBool(stream))
+	if lane != "" {
+		traceDecision(r, "lane", "%s", lane)
+	}
+	if think, ok := req["think"]; ok {
+		traceDecision(r, "think", "%v", think)
+	}
+	if t := w.Header().Get("X-Prompt-Template"); t != "" {
+		traceDecision(r, "prompt template", "%s", t)
+	}
+	if n := w.Header().Get("X-Context-Truncated"); n != "" {
+		traceDecision(r, "context", "%s messages truncated", n)
+	}
+	if options, ok := req["options"].(map[string]interface{}); ok && len(options) > 0 {
+		keys := make([]string, 0, len(options))
+		for k, v := range options {
+			keys = append(keys, fmt.Sprintf("%s=%v", k, v))
+		}
+		sort.Strings(keys)
+		traceDecision(r, "options", "%s", strings.Join(keys, " "))
+	}
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	upstreamProbeTimeout = 3 * time.Second
+	upstreamHistorySize  = 120 // probes kept for latency / availability figures
+)
+
+// Upstream states reported as upstream_state.state.
+const (
+	upstreamConnected    = "connected"
+	upstreamReconnecting = "reconnecting" // probes failing, not yet UPSTREAM_UNREACHABLE_AFTER in a row
+	upstreamUnreachable  = "unreachable"  // circuit open: inference requests fail fast
+)
+
+// upstreamState is the background prober's view of Ollama. It drives the UI
+// banner (/api/progress), /api/status, /readyz and the circuit breaker.
+type upstreamState struct {
+	mu        sync.RWMutex
+	state     string
+	since     time.Time // first failed probe of the current outage, or when connected
+	failures  int       // consecutive failed probes
+	lastError string
+	checkedAt time.Time
+	history   []upstreamProbe // ring buffer, next is the oldest slot
+	next      int
+	rejected  int64 // requests failed fast while the circuit was open
+	kick      chan struct{}
+}
+
+type upstreamProbe struct {
+	Time      time.Time `json:"time"`
+	OK        bool      `json:"ok"`
+	LatencyMs int64     `json:"latency_ms"`
+}
+
+// watchUpstream pings Ollama every UPSTREAM_PROBE_INTERVAL_SEC, or sooner
+// when a request hits a transport error (see kickUpstreamProbe).
+func (s *Server) watchUpstream() {
+	interval := time.Duration(s.config.UpstreamProbeIntervalSec) * time.Second
+	for {
+		s.probeUpstream()
+		select {
+		case <-time.After(interval):
+		case <-s.upstream.kick:
+		}
+	}
+}
+
+// kickUpstreamProbe asks the prober to check now instead of waiting for
+// its next tick; extra kicks while one is pending are dropped.
+func (s *Server) kickUpstreamProbe() {
+	select {
+	case s.upstream.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Server) probeUpstream() {
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamProbeTimeout)
+	start := time.Now()
+	_, err := s.ollamaClient.Version(ctx)
+	latency := time.Since(start)
+	if err == nil {
+		s.refreshModelLoad(ctx)
+	}
+	cancel()
+
+	now := time.Now()
+	us := &s.upstream
+	us.mu.Lock()
+	previous, outageStart := us.state, us.since
+	us.checkedAt = now
+	probe := upstreamProbe{Time: now, OK: err == nil, LatencyMs: latency.Milliseconds()}
+	if len(us.history) < upstreamHistorySize {
+		us.history = append(us.history, probe)
+	} else {
+		us.history[us.next] = probe
+		us.next = (us.next + 1) % upstreamHistorySize
+	}
+	if err == nil {
+		if previous != upstreamConnected {
+			us.since = now
+		}
+		us.state, us.failures, us.lastError = upstreamConnected, 0, ""
+	} else {
+		if us.failures == 0 {
+			us.since = now
+		}
+		us.failures++
+		us.lastError = err.Error()
+		us.state = upstreamReconnecting
+		if us.failures >= s.config.UpstreamUnreachableAfter {
+			us.state = upstreamUnreachable
+		}
+	}
+	state, since, rejected := us.state, us.since, us.rejected
+	if state == upstreamConnected {
+		us.rejected = 0
+	}
+	us.mu.Unlock()
+
+	// Log transitions only; individual failed probes stay quiet
+	switch {
+	case state == previous:
+	case state == upstreamUnreachable:
+		log.Printf("!!! Ollama unreachable since %s: %v; failing inference requests fast until it answers again !!!", since.Format(time.RFC3339), err)
+		s.events.Record("upstream_down", map[string]interface{}{"since": since, "error": err.Error()})
+	case state == upstreamConnected && previous == upstreamUnreachable:
+		log.Printf("Ollama reachable again after %s (%d requests rejected meanwhile)", now.Sub(outageStart).Round(time.Second), rejected)
+		s.events.Record("upstream_up", map[string]interface{}{
+			"outage_sec": int64(now.Sub(outageStart).Seconds()), "rejected_requests": rejected,
+		})
+	case state == upstreamConnected && previous == upstreamReconnecting:
+		log.Printf("Ollama reachable")
+	}
+}
+
+// upstreamUnavailable reports whether the circuit is open.
+func (s *Server) upstreamUnavailable() bool {
+	s.upstream.mu.RLock()
+	defer s.upstream.mu.RUnlock()
+	return s.upstream.state == upstreamUnreachable
+}
+
+// withBreaker fails inference requests with 503 while the prober considers
+// Ollama unreachable, instead of letting each one wait for a connect error
+// or timeout. It closes again as soon as a probe succeeds.
+func (s *Server) withBreaker(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.config.CircuitBreaker || !s.upstreamUnavailable() {
+			h(w, r)
+			return
+		}
+		s.upstream.mu.Lock()
+		s.upstream.rejected++
+		since, lastError := s.upstream.since, s.upstream.lastError
+		s.upstream.mu.Unlock()
+		w.Header().Set("Retry-After", strconv.Itoa(s.config.UpstreamProbeIntervalSec))
+		writeUpstreamError(w, errorFormatForPath(r.URL.Path), &upstreamError{
+			Status:  http.StatusServiceUnavailable,
+			Code:    "upstream_unreachable",
+			Message: "Ollama has been unreachable since " + since.Format(time.RFC3339) + ": " + lastError,
+			Hint:    "Check that Ollama is running and OLLAMA_URL is correct; requests are accepted again as soon as a health probe succeeds (see /api/status).",
+		})
+	}
+}
+
+// upstreamStateInfo returns the upstream_state object; with history it adds
+// latency and availability over the recent probes (for /api/status). Before
+// the first probe completes the state is "reconnecting".
+func (s *Server) upstreamStateInfo(history bool) map[string]interface{} {
+	us := &s.upstream
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+	if us.checkedAt.IsZero() {
+		return map[string]interface{}{"state": upstreamReconnecting}
+	}
+	info := map[string]interface{}{
+		"state":      us.state,
+		"since":      us.since,
+		"checked_at": us.checkedAt,
+	}
+	if us.failures > 0 {
+		info["consecutive_failures"] = us.failures
+		info["last_error"] = us.lastError
+	}
+	if !history {
+		return info
+	}
+
+	probes := make([]upstreamProbe, 0, len(us.history))
+	ok, totalLatency := 0, int64(0)
+	for i := len(us.history) - 1; i >= 0; i-- {
+		p := us.history[(us.next+i)%len(us.history)]
+		probes = append(probes, p)
+		if p.OK {
+			ok++
+			totalLatency += p.LatencyMs
+		}
+	}
+	info["availability"] = float64(ok) / float64(len(probes))
+	if ok > 0 {
+		info["latency_ms"] = probes[0].LatencyMs
+		info["avg_latency_ms"] = totalLatency / int64(ok)
+	}
+	if us.rejected > 0 {
+		info["rejected_requests"] = us.rejected
+	}
+	info["history"] = probes
+	return info
+}
+
+// handleReadyz is the readiness probe: 200 once the model is installed and
+// Ollama answers, 503 (with the reason) otherwise. Unlike /health it fails
+// while Ollama is down, so orchestrators stop routing traffic here.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if reasons := s.readinessReasons(); len(reasons) > 0 {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "not_ready", "reasons": reasons})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ready"})
+}
+
+// readinessReasons lists why the proxy cannot serve inference right now
+// (empty when ready). Shared by /readyz and the gRPC health service.
+func (s *Server) readinessReasons() []string {
+	var reasons []string
+	if s.model() != "" {
+		switch status := s.progressManager.GetProgress().Status; status {
+		case "completed", "success", "complete":
+		default:
+			reasons = append(reasons, "model not ready: "+status)
+		}
+		if last, ok := s.lastSmokeTest.Load().(smokeResult); ok && !last.Passed && matchesModel(last.Model, s.model()) {
+			reasons = append(reasons, "smoke test failed: "+last.Error)
+		}
+	}
+	if s.config.UpstreamProbeIntervalSec > 0 {
+		upstream := s.upstreamStateInfo(false)
+		if upstream["state"] != upstreamConnected {
+			reason := "ollama " + upstream["state"].(string)
+			if msg, _ := upstream["last_error"].(string); msg != "" {
+				reason += ": " + msg
+			}
+			reasons = append(reasons, reason)
+		}
+	}
+	return reasons
+}
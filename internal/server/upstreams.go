@@ -0,0 +1,221 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"olares-ollama/internal/config"
+	"olares-ollama/internal/ollama"
+)
+
+// upstreamSet sends inference to other Ollama servers than OLLAMA_URL by
+// request kind or model (OLLAMA_UPSTREAMS), e.g. embeddings to a CPU box
+// and chat to the GPU box. The clients share the settings of the main one.
+// Models are pulled, listed and health-checked on OLLAMA_URL only; they
+// must be present on the servers they are routed to, unless placed there
+// (placement.go).
+//
+// A kind or model listed with several servers is a pool: the requests of
+// one conversation, session or user go to the same server of the pool
+// (stickyKey), so its prompt cache and loaded models keep being used.
+type upstreamSet struct {
+	byKind  map[string][]*ollama.Client
+	byModel map[string][]*ollama.Client
+	byURL   map[string]*ollama.Client // every server, OLLAMA_URL included, by its configured URL
+}
+
+func newUpstreamSet(entries []string, mainURL string, main *ollama.Client) *upstreamSet {
+	parsed, _ := config.ParseUpstreams(entries) // checked by Validate
+	if len(parsed) == 0 {
+		return nil
+	}
+	set := &upstreamSet{
+		byKind:  map[string][]*ollama.Client{},
+		byModel: map[string][]*ollama.Client{},
+		byURL:   map[string]*ollama.Client{mainURL: main}, // one client (and connection pool) per URL
+	}
+	for _, up := range parsed {
+		client, ok := set.byURL[up.URL]
+		if !ok {
+			client = main.WithBaseURL(up.URL)
+			set.byURL[up.URL] = client
+		}
+		if up.Model != "" {
+			if !slices.Contains(set.byModel[up.Model], client) {
+				set.byModel[up.Model] = append(set.byModel[up.Model], client)
+			}
+			log.Printf("Routing requests for model %s to Ollama at %s", up.Model, client.BaseURL())
+		} else {
+			if !slices.Contains(set.byKind[up.Kind], client) {
+				set.byKind[up.Kind] = append(set.byKind[up.Kind], client)
+			}
+			log.Printf("Routing %s requests to Ollama at %s", up.Kind, client.BaseURL())
+		}
+	}
+	return set
+}
+
+// upstreamFor returns the Ollama server for an inference request of kind
+// ("chat" or "embeddings") with body req: the server of its model, else
+// the one it was placed on (/admin/placements), else of "vision" for a chat
+// request with images, else of its kind, else a peer serving a model the
+// local Ollama lacks (ENABLE_PEERS), else OLLAMA_URL.
+func (s *Server) upstreamFor(r *http.Request, kind string, req map[string]interface{}) *ollama.Client {
+	model, _ := req["model"].(string)
+	if set := s.upstreams; set != nil {
+		sticky := func() string { return s.stickyKey(r, req) }
+		if client := set.lookup(r, kind, model, req, s.placements, sticky); client != nil {
+			return client
+		}
+	}
+	if client := s.peerFor(r, model); client != nil {
+		return client
+	}
+	return s.ollamaClient
+}
+
+func (set *upstreamSet) lookup(r *http.Request, kind, model string, req map[string]interface{}, placements *placementStore, sticky func() string) *ollama.Client {
+	if pool, ok := set.byModel[model]; ok {
+		return set.pick(r, pool, "model "+model, sticky)
+	}
+	for name, pool := range set.byModel {
+		if model != "" && matchesModel(model, name) {
+			return set.pick(r, pool, "model "+name, sticky)
+		}
+	}
+	if p, ok := placements.get(model); ok && model != "" {
+		if client, ok := set.byURL[p.Backend]; ok {
+			return set.pick(r, []*ollama.Client{client}, "placed "+p.Model, sticky)
+		}
+	}
+	if kind == "chat" && hasImages(req) {
+		if pool, ok := set.byKind["vision"]; ok {
+			return set.pick(r, pool, "vision", sticky)
+		}
+	}
+	if pool, ok := set.byKind[kind]; ok {
+		return set.pick(r, pool, kind, sticky)
+	}
+	return nil
+}
+
+// pick returns the server of the pool for the request: the only one, or
+// the one the request's sticky key hashes to. Rendezvous hashing keeps
+// the other keys where they are when a server is added or removed.
+func (set *upstreamSet) pick(r *http.Request, pool []*ollama.Client, reason string, sticky func() string) *ollama.Client {
+	client := pool[0]
+	if len(pool) > 1 {
+		key := sticky()
+		var best uint64
+		for _, c := range pool {
+			h := fnv.New64a()
+			h.Write([]byte(key))
+			h.Write([]byte{0})
+			h.Write([]byte(c.BaseURL()))
+			if sum := h.Sum64(); sum >= best {
+				client, best = c, sum
+			}
+		}
+		reason += ", sticky to " + key
+	}
+	traceDecision(r, "upstream", "%s (%s)", client.BaseURL(), reason)
+	log.Printf(">>> Sending %s to Ollama at %s (%s) <<<", r.URL.Path, client.BaseURL(), reason)
+	return client
+}
+
+// stickyKey names what a request belongs to, for the pools: its session
+// (/api/sessions/{id}/chat, X-Session-Id), else its user (OpenAI "user",
+// Anthropic metadata.user_id, the API key or USER_HEADER), else the
+// conversation (its first messages), else the client address.
+func (s *Server) stickyKey(r *http.Request, req map[string]interface{}) string {
+	if id := r.PathValue("id"); id != "" && strings.HasPrefix(r.URL.Path, "/api/sessions/") {
+		return "session:" + id
+	}
+	if id := strings.TrimSpace(r.Header.Get("X-Session-Id")); id != "" {
+		return "session:" + id
+	}
+	user := clientUserOf(req) // requests passed through as they are (/v1/messages)
+	if meta := metaFrom(r); meta != nil && user == "" {
+		meta.update(func(m *requestMeta) { user = m.clientUser })
+	}
+	if user != "" {
+		return "user:" + user
+	}
+	if subjects := s.subjectsOf(r); len(subjects) > 0 {
+		return subjects[0]
+	}
+	if messages := messagesOf(req["messages"]); len(messages) > 0 {
+		// Up to the first user turn: what stays the same as the
+		// conversation grows.
+		for i, m := range messages {
+			if mm, ok := m.(map[string]interface{}); ok && mm["role"] == "user" {
+				messages = messages[:i+1]
+				break
+			}
+		}
+		data, _ := json.Marshal(messages)
+		h := fnv.New64a()
+		h.Write(data)
+		return fmt.Sprintf("conversation#%016x", h.Sum64())
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "client:" + host
+}
+
+// noteClientUser remembers the user a client request names, which the
+// Ollama request made of it no longer carries.
+func noteClientUser(r *http.Request, client map[string]interface{}) {
+	if meta := metaFrom(r); meta != nil {
+		if user := clientUserOf(client); user != "" {
+			meta.update(func(m *requestMeta) { m.clientUser = user })
+		}
+	}
+}
+
+// clientUserOf returns the OpenAI "user" or Anthropic metadata.user_id of
+// a request.
+func clientUserOf(req map[string]interface{}) string {
+	if user, _ := req["user"].(string); user != "" {
+		return user
+	}
+	metadata, _ := req["metadata"].(map[string]interface{})
+	user, _ := metadata["user_id"].(string)
+	return user
+}
+
+// hasImages reports whether a request carries images: Ollama's "images"
+// (on /api/generate or a chat message) or image content blocks of the
+// Anthropic and OpenAI formats that are passed through.
+func hasImages(req map[string]interface{}) bool {
+	if images, ok := req["images"].([]interface{}); ok && len(images) > 0 {
+		return true
+	}
+	for _, m := range messagesOf(req["messages"]) {
+		mm, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if images, ok := mm["images"].([]interface{}); ok && len(images) > 0 {
+			return true
+		}
+		blocks, _ := mm["content"].([]interface{})
+		for _, b := range blocks {
+			if block, ok := b.(map[string]interface{}); ok {
+				switch block["type"] {
+				case "image", "image_url", "input_image":
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,413 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Usage headers attached to inference responses. Non-streaming responses get
+// them as regular headers; streaming responses (where usage is only known at
+// the end) get them as HTTP trailers.
+const (
+	headerPromptTokens     = "X-Usage-Prompt-Tokens"
+	headerCompletionTokens = "X-Usage-Completion-Tokens"
+	headerDurationMs       = "X-Request-Duration-Ms"
+)
+
+// headerEnvelope opts a request into the debug metadata envelope.
+const headerEnvelope = "X-Proxy-Envelope"
+
+// headerServedModel names the local model that answered, which differs from
+// the requested one when aliasing or the fast lane rerouted the request.
+const headerServedModel = "X-Served-Model"
+
+// maxUsageLine bounds how much of one upstream JSON line is buffered for parsing.
+const maxUsageLine = 8 << 20
+
+type metaKey struct{}
+
+// requestMeta is per-request bookkeeping for inference endpoints: token
+// counts seen in upstream responses (several upstream calls, e.g. batch
+// embeddings, add up) plus timings and routing facts for the envelope.
+type requestMeta struct {
+	mu             sync.Mutex
+	start          time.Time
+	seen           bool
+	prompt         int
+	completion     int
+	requestedModel string
+	servedModel    string
+	queued         time.Duration // time spent waiting for a limiter slot
+	upstreamCalls  int
+	firstByte      time.Duration // request start -> first upstream response headers
+	upstreamDone   time.Duration // request start -> last upstream body fully read
+	retries        int
+	cacheHit       bool
+	userRouted     bool   // a user route picked the model (the fast lane keeps it)
+	routingRule    string // the ROUTING_RULES entry that picked the model (the fast lane keeps it)
+	clientUser     string // OpenAI "user" or Anthropic metadata.user_id, for sticky upstreams
+	onUpstream     func() // called when the first upstream response arrives (see withWarmup)
+	tracing        bool     // X-Proxy-Trace requested; set before the handler runs
+	trace          []string // decisions recorded by traceDecision
+}
+
+func (m *requestMeta) addUsage(prompt, completion int) {
+	m.mu.Lock()
+	m.prompt += prompt
+	m.completion += completion
+	m.seen = true
+	m.mu.Unlock()
+}
+
+func (m *requestMeta) usage() (prompt, completion int, seen bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.prompt, m.completion, m.seen
+}
+
+// served returns the model the request was sent to.
+func (m *requestMeta) served() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.servedModel
+}
+
+// update runs fn with the lock held.
+func (m *requestMeta) update(fn func(m *requestMeta)) {
+	m.mu.Lock()
+	fn(m)
+	m.mu.Unlock()
+}
+
+// metaFrom returns the request's metadata, or nil outside inference routes.
+func metaFrom(r *http.Request) *requestMeta {
+	m, _ := r.Context().Value(metaKey{}).(*requestMeta)
+	return m
+}
+
+// inferenceRoute registers an inference endpoint that reports usage headers
+// and supports the X-Proxy-Envelope debug envelope, Idempotency-Key and
+// Last-Event-ID stream resumption.
+func (s *Server) inferenceRoute(path string, handler http.HandlerFunc, methods ...string) {
+	s.route(path, s.withMeta(s.withResume(s.withBreaker(s.withWarmup(s.withIdempotency(handler))))), methods...)
+}
+
+// withMeta installs a requestMeta for the request and a ResponseWriter that
+// emits the usage headers (or trailers), or the envelope, around the handler.
+func (s *Server) withMeta(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.ServerWriteTimeoutSec > 0 {
+			// Inference responses stream for as long as generation takes;
+			// SERVER_WRITE_TIMEOUT_SEC only bounds the other endpoints.
+			http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		}
+		s.inflight.Add(1)
+		defer s.inflight.Add(-1)
+		meta := &requestMeta{start: time.Now(), servedModel: s.model()}
+		envelope, _ := strconv.ParseBool(r.Header.Get(headerEnvelope))
+		meta.tracing, _ = strconv.ParseBool(r.Header.Get(headerTrace))
+		if meta.tracing {
+			meta.trace = append(meta.trace, "format: "+traceRequestFormat(r.URL.Path)+" ("+r.Method+" "+r.URL.Path+")")
+		}
+		mw := &metaWriter{ResponseWriter: w, meta: meta, envelope: envelope, costs: s.costs}
+		body := &countingBody{ReadCloser: r.Body}
+		r.Body = body
+		if s.checkRequestSize(mw, r) {
+			h(mw, r.WithContext(context.WithValue(r.Context(), metaKey{}, meta)))
+		}
+		mw.finish()
+		prompt, completion, _ := meta.usage()
+		model := meta.served()
+		if s.hotModels != nil {
+			s.hotModels.use(model)
+		}
+		s.usage.record(s.tenantOf(r), model, usageSample{
+			status:        mw.status,
+			latency:       time.Since(meta.start),
+			prompt:        prompt,
+			completion:    completion,
+			cost:          s.costs.estimate(model, prompt, completion),
+			requestBytes:  max(body.n, r.ContentLength), // rejected bodies are never read
+			responseBytes: mw.written,
+		})
+	}
+}
+
+// metaWriter sets usage headers when the status is written: as headers if
+// the upstream usage is already known, otherwise it declares trailers that
+// finish fills in. With the envelope requested, JSON responses are buffered
+// and wrapped instead (streaming responses pass through untouched).
+type metaWriter struct {
+	http.ResponseWriter
+	meta        *requestMeta
+	costs       costTable
+	envelope    bool
+	wroteHeader bool
+	trailers    bool
+	status      int
+	written     int64         // body bytes written by the handler
+	buf         *bytes.Buffer // non-nil while buffering for the envelope
+}
+
+func (mw *metaWriter) WriteHeader(code int) {
+	if mw.wroteHeader {
+		return
+	}
+	mw.wroteHeader = true
+	mw.status = code
+	if model := mw.meta.served(); model != "" {
+		mw.Header().Set(headerServedModel, model)
+	}
+	if mw.meta.tracing {
+		mw.meta.mu.Lock()
+		mw.Header().Set(headerTrace, strings.Join(mw.meta.trace, "; "))
+		mw.meta.mu.Unlock()
+	}
+	if mw.envelope && strings.HasPrefix(mw.Header().Get("Content-Type"), "application/json") {
+		mw.buf = &bytes.Buffer{}
+		return
+	}
+	if code < http.StatusBadRequest {
+		if _, _, seen := mw.meta.usage(); seen {
+			mw.setUsageHeaders(mw.Header())
+		} else {
+			h := mw.Header()
+			h.Add("Trailer", headerPromptTokens)
+			h.Add("Trailer", headerCompletionTokens)
+			h.Add("Trailer", headerCost)
+			h.Add("Trailer", headerDurationMs)
+			mw.trailers = true
+		}
+	}
+	mw.ResponseWriter.WriteHeader(code)
+}
+
+func (mw *metaWriter) Write(b []byte) (int, error) {
+	if !mw.wroteHeader {
+		mw.WriteHeader(http.StatusOK)
+	}
+	mw.written += int64(len(b))
+	if mw.buf != nil {
+		return mw.buf.Write(b)
+	}
+	return mw.ResponseWriter.Write(b)
+}
+
+func (mw *metaWriter) Flush() {
+	if !mw.wroteHeader {
+		mw.WriteHeader(http.StatusOK)
+	}
+	if mw.buf != nil {
+		return
+	}
+	if f, ok := mw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (mw *metaWriter) setUsageHeaders(h http.Header) {
+	prompt, completion, _ := mw.meta.usage()
+	h.Set(headerPromptTokens, strconv.Itoa(prompt))
+	h.Set(headerCompletionTokens, strconv.Itoa(completion))
+	h.Set(headerCost, strconv.FormatFloat(mw.costs.estimate(mw.meta.served(), prompt, completion), 'f', -1, 64))
+	h.Set(headerDurationMs, strconv.FormatInt(time.Since(mw.meta.start).Milliseconds(), 10))
+}
+
+// finish fills in trailers or writes the buffered envelope.
+func (mw *metaWriter) finish() {
+	if mw.trailers {
+		mw.setUsageHeaders(mw.Header())
+		return
+	}
+	if mw.buf == nil {
+		return
+	}
+	h := mw.Header()
+	mw.setUsageHeaders(h)
+	body := mw.buf.Bytes()
+	var original interface{}
+	if err := json.Unmarshal(body, &original); err == nil {
+		if wrapped, err := json.Marshal(map[string]interface{}{
+			"response": original,
+			"proxy":    mw.envelopeMeta(),
+		}); err == nil {
+			body = append(wrapped, '\n')
+		}
+	}
+	h.Del("Content-Length")
+	mw.ResponseWriter.WriteHeader(mw.status)
+	mw.ResponseWriter.Write(body)
+}
+
+// envelopeMeta is the "proxy" object of the X-Proxy-Envelope response.
+func (mw *metaWriter) envelopeMeta() map[string]interface{} {
+	m := mw.meta
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	lane := mw.Header().Get("X-Proxy-Lane")
+	if lane == "" {
+		lane = "normal"
+	}
+	latency := map[string]interface{}{
+		"total": ms(time.Since(m.start)),
+		"queue": ms(m.queued),
+	}
+	if m.upstreamCalls > 0 {
+		latency["upstream_first_byte"] = ms(m.firstByte - m.queued)
+		if m.upstreamDone > 0 {
+			latency["upstream"] = ms(m.upstreamDone - m.queued)
+		}
+	}
+	meta := map[string]interface{}{
+		"served_model":   m.servedModel,
+		"status":         mw.status,
+		"lane":           lane,
+		"latency_ms":     latency,
+		"usage":          map[string]interface{}{"prompt_tokens": m.prompt, "completion_tokens": m.completion, "cost": mw.costs.estimate(m.servedModel, m.prompt, m.completion)},
+		"upstream_calls": m.upstreamCalls,
+		"retries":        m.retries,
+		"cache_hit":      m.cacheHit,
+	}
+	if m.requestedModel != "" {
+		meta["requested_model"] = m.requestedModel
+	}
+	if n, err := strconv.Atoi(mw.Header().Get("X-Context-Truncated")); err == nil {
+		meta["context_truncated_messages"] = n
+	}
+	if t := mw.Header().Get("X-Prompt-Template"); t != "" {
+		meta["prompt_template"] = t
+	}
+	if m.routingRule != "" {
+		meta["routing_rule"] = m.routingRule
+	}
+	if m.tracing {
+		meta["trace"] = m.trace
+	}
+	return meta
+}
+
+// bufferUpstreamBody reads a non-streaming upstream body up front (through
+// the usage tap) so usage headers can be set before the status is written.
+func bufferUpstreamBody(resp *http.Response) error {
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+	return nil
+}
+
+// tapUsage wraps an upstream Ollama response body so token counts and
+// upstream timings are recorded as the handler reads it. Call it right after
+// the upstream response headers arrive. Outside inference routes it returns
+// body as is.
+func tapUsage(r *http.Request, body io.ReadCloser) io.ReadCloser {
+	meta := metaFrom(r)
+	if meta == nil {
+		return body
+	}
+	var onUpstream func()
+	meta.update(func(m *requestMeta) {
+		if m.upstreamCalls == 0 {
+			m.firstByte = time.Since(m.start)
+			onUpstream = m.onUpstream
+		}
+		m.upstreamCalls++
+	})
+	if onUpstream != nil {
+		onUpstream()
+	}
+	return &usageTap{ReadCloser: body, meta: meta}
+}
+
+type usageTap struct {
+	io.ReadCloser
+	meta    *requestMeta
+	pending []byte
+}
+
+func (t *usageTap) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		t.pending = append(t.pending, p[:n]...)
+		for {
+			i := bytes.IndexByte(t.pending, '\n')
+			if i < 0 {
+				break
+			}
+			t.parse(t.pending[:i])
+			t.pending = t.pending[i+1:]
+		}
+		// A non-streaming body is one JSON object, often without a trailing
+		// newline; parse it as soon as it is complete, before the handler
+		// writes the response headers.
+		if trimmed := bytes.TrimSpace(t.pending); len(trimmed) > 0 && trimmed[len(trimmed)-1] == '}' && json.Valid(trimmed) {
+			t.parse(trimmed)
+			t.pending = t.pending[:0]
+		}
+		if len(t.pending) > maxUsageLine {
+			t.pending = t.pending[:0]
+		}
+	}
+	if err == io.EOF {
+		if len(t.pending) > 0 {
+			t.parse(t.pending)
+			t.pending = nil
+		}
+		t.meta.update(func(m *requestMeta) { m.upstreamDone = time.Since(m.start) })
+	}
+	return n, err
+}
+
+// parse extracts token counts from one Ollama (native or Anthropic-compatible)
+// JSON object or SSE data line.
+func (t *usageTap) parse(line []byte) {
+	line = bytes.TrimSpace(line)
+	line = bytes.TrimPrefix(line, []byte("data:"))
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] != '{' {
+		return
+	}
+	var v struct {
+		PromptEvalCount *int `json:"prompt_eval_count"`
+		EvalCount       *int `json:"eval_count"`
+		Usage           *struct {
+			InputTokens      int `json:"input_tokens"`
+			OutputTokens     int `json:"output_tokens"`
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+		Message *struct {
+			Usage *struct {
+				InputTokens int `json:"input_tokens"`
+			} `json:"usage"`
+		} `json:"message"`
+	}
+	if json.Unmarshal(line, &v) != nil {
+		return
+	}
+	switch {
+	case v.PromptEvalCount != nil || v.EvalCount != nil:
+		prompt, completion := 0, 0
+		if v.PromptEvalCount != nil {
+			prompt = *v.PromptEvalCount
+		}
+		if v.EvalCount != nil {
+			completion = *v.EvalCount
+		}
+		t.meta.addUsage(prompt, completion)
+	case v.Usage != nil:
+		t.meta.addUsage(v.Usage.InputTokens+v.Usage.PromptTokens, v.Usage.OutputTokens+v.Usage.CompletionTokens)
+	case v.Message != nil && v.Message.Usage != nil:
+		// Anthropic message_start carries the input token count.
+		t.meta.addUsage(v.Message.Usage.InputTokens, 0)
+	}
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// userRoute sends the requests of one Olares user or API key to its own
+// default model, or to a prompt template alias, through the same endpoints.
+type userRoute struct {
+	Subject     string    `json:"subject"` // "user:<name>" or "key#<hash>"
+	Model       string    `json:"model"`   // a local model, or a prompt template name (alias)
+	Description string    `json:"description,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// subjectsOf returns who sent the request, most specific first: the API
+// key (Authorization: Bearer or X-Api-Key, hashed) and the Olares user
+// (USER_HEADER, set by the Olares gateway).
+func (s *Server) subjectsOf(r *http.Request) []string {
+	var subjects []string
+	key := r.Header.Get("X-Api-Key")
+	if auth := r.Header.Get("Authorization"); key == "" && strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key = strings.TrimSpace(key); key != "" {
+		subjects = append(subjects, apiKeySubject(key))
+	}
+	if s.config.UserHeader != "" {
+		if user := strings.TrimSpace(r.Header.Get(s.config.UserHeader)); user != "" {
+			subjects = append(subjects, "user:"+user)
+		}
+	}
+	return subjects
+}
+
+// apiKeySubject identifies an API key without keeping the key itself.
+func apiKeySubject(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key#" + hex.EncodeToString(sum[:8])
+}
+
+// normalizeSubject accepts "user:<name>", "key:<api key>" or "key#<hash>".
+func normalizeSubject(subject string) (string, bool) {
+	switch {
+	case strings.HasPrefix(subject, "key:") && len(subject) > len("key:"):
+		return apiKeySubject(strings.TrimPrefix(subject, "key:")), true
+	case strings.HasPrefix(subject, "key#"), strings.HasPrefix(subject, "user:") && len(subject) > len("user:"):
+		return subject, true
+	}
+	return "", false
+}
+
+// userRouteStore persists routes to data/user_routes.json.
+type userRouteStore struct {
+	mu     sync.RWMutex
+	routes map[string]*userRoute
+	file   string
+}
+
+func newUserRouteStore() *userRouteStore {
+	st := &userRouteStore{
+		routes: make(map[string]*userRoute),
+		file:   filepath.Join("data", "user_routes.json"),
+	}
+	data, err := os.ReadFile(st.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read %s: %v", st.file, err)
+		}
+		return st
+	}
+	var list []*userRoute
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Warning: failed to parse %s: %v", st.file, err)
+		return st
+	}
+	for _, rt := range list {
+		st.routes[rt.Subject] = rt
+	}
+	log.Printf("Loaded %d user routes from %s", len(list), st.file)
+	return st
+}
+
+func (st *userRouteStore) get(subject string) (*userRoute, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	rt, ok := st.routes[subject]
+	return rt, ok
+}
+
+func (st *userRouteStore) list() []*userRoute {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	out := make([]*userRoute, 0, len(st.routes))
+	for _, rt := range st.routes {
+		out = append(out, rt)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Subject < out[j].Subject })
+	return out
+}
+
+func (st *userRouteStore) put(rt *userRoute) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.routes[rt.Subject] = rt
+	return st.saveLocked()
+}
+
+func (st *userRouteStore) delete(subject string) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.routes[subject]; !ok {
+		return false, nil
+	}
+	delete(st.routes, subject)
+	return true, st.saveLocked()
+}
+
+func (st *userRouteStore) saveLocked() error {
+	list := make([]*userRoute, 0, len(st.routes))
+	for _, rt := range st.routes {
+		list = append(list, rt)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Subject < list[j].Subject })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(st.file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(st.file, data, 0644)
+}
+
+// applyUserRoute points the request at the sender's routed model. A route
+// to a prompt template works like the client requesting that alias (unless
+// the client asked for a template itself); otherwise the model is replaced.
+// It returns the requested model name to use for template selection.
+func (s *Server) applyUserRoute(r *http.Request, req map[string]interface{}, requestedModel string) string {
+	for _, subject := range s.subjectsOf(r) {
+		rt, ok := s.userRoutes.get(subject)
+		if !ok {
+			continue
+		}
+		if _, isTemplate := s.templates.get(rt.Model); isTemplate {
+			if _, clientAlias := s.templates.get(requestedModel); !clientAlias && r.Header.Get("X-Prompt-Template") == "" {
+				requestedModel = rt.Model
+			}
+		} else {
+			req["model"] = rt.Model
+		}
+		if meta := metaFrom(r); meta != nil {
+			meta.update(func(m *requestMeta) {
+				m.userRouted = true
+				if model, _ := req["model"].(string); model != "" {
+					m.servedModel = model
+				}
+			})
+		}
+		log.Printf(">>> %s: user route %s -> %s <<<", r.URL.Path, subject, rt.Model)
+		return requestedModel
+	}
+	return requestedModel
+}
+
+// registerUserRouteRoutes adds the per-user routing admin API.
+func (s *Server) registerUserRouteRoutes() {
+	s.adminRoute("/admin/user-routes", s.handleUserRouteList, "GET")
+	s.adminRoute("/admin/user-routes/{subject}", s.handleUserRoutePut, "PUT")
+	s.adminRoute("/admin/user-routes/{subject}", s.handleUserRouteDelete, "DELETE")
+}
+
+func (s *Server) handleUserRouteList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"routes": s.userRoutes.list(), "user_header": s.config.UserHeader})
+}
+
+// handleUserRoutePut creates or replaces a route. Body: {"model": "...", "description": "..."}.
+func (s *Server) handleUserRoutePut(w http.ResponseWriter, r *http.Request) {
+	subject, ok := normalizeSubject(r.PathValue("subject"))
+	if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Subject must be user:<name> or key:<api key>")
+		return
+	}
+	var rt userRoute
+	if err := json.NewDecoder(r.Body).Decode(&rt); err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(rt.Model) == "" {
+		writeError(w, ollamaErrorFormat, http.StatusBadRequest, "invalid_request", "'model' is required")
+		return
+	}
+	rt.Subject = subject
+	rt.UpdatedAt = time.Now()
+	if err := s.userRoutes.put(&rt); err != nil {
+		log.Printf("!!! Failed to save user routes: %v !!!", err)
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Failed to save route: "+err.Error())
+		return
+	}
+	log.Printf("User route saved: %s -> %s", subject, rt.Model)
+	writeJSON(w, http.StatusOK, &rt)
+}
+
+func (s *Server) handleUserRouteDelete(w http.ResponseWriter, r *http.Request) {
+	subject, _ := normalizeSubject(r.PathValue("subject"))
+	ok, err := s.userRoutes.delete(subject)
+	if err != nil {
+		writeError(w, ollamaErrorFormat, http.StatusInternalServerError, "storage_error", "Failed to save routes: "+err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, ollamaErrorFormat, http.StatusNotFound, "route_not_found", "User route not found: "+r.PathValue("subject"))
+		return
+	}
+	log.Printf("User route deleted: %s", subject)
+	w.WriteHeader(http.StatusNoContent)
+}
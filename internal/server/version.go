@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"olares-ollama/internal/ollama"
+)
+
+// upstreamFeatures maps proxy features to the first Ollama release that
+// supports them. When the upstream is older the feature is disabled (see
+// capabilities) instead of letting Ollama fail with a confusing error.
+var upstreamFeatures = []struct {
+	name       string
+	minVersion string
+	desc       string
+}{
+	{"embed", "0.3.0", "/api/embed batch embeddings"},
+	{"tools", "0.3.0", "tool / function calling"},
+	{"structured_outputs", "0.5.0", "JSON schema in format / response_format"},
+	{"think", "0.9.0", "separate thinking output"},
+}
+
+// modelKeyVersion is the first Ollama release that names the model "model"
+// in show / delete / pull requests; older ones only read "name".
+const modelKeyVersion = "0.3.0"
+
+// upstreamAPI picks the request dialect for an Ollama version. While the
+// version is unknown renamed fields are sent both ways. (The /api/embed vs
+// /api/embeddings choice is made per model, see capabilities.)
+func upstreamAPI(version string) ollama.API {
+	api := ollama.API{Version: version}
+	if version = comparableVersion(version); version != "" {
+		api.ModelKey = "name"
+		if compareVersions(version, modelKeyVersion) >= 0 {
+			api.ModelKey = "model"
+		}
+	}
+	return api
+}
+
+// adaptModelKey rewrites a client's JSON body naming a model to the field
+// the upstream reads (see upstreamAPI). Other bodies pass unchanged.
+func (s *Server) adaptModelKey(body []byte) []byte {
+	var req map[string]interface{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return body
+	}
+	s.ollamaClient.API().AdaptModelKey(req)
+	if adapted, err := json.Marshal(req); err == nil {
+		return adapted
+	}
+	return body
+}
+
+// upstreamVersion is the last /api/version result, refreshed periodically.
+type upstreamVersion struct {
+	mu        sync.RWMutex
+	version   string
+	err       string
+	checkedAt time.Time
+}
+
+// watchUpstreamVersion checks /api/version now and every VERSION_CHECK_INTERVAL_SEC.
+func (s *Server) watchUpstreamVersion() {
+	interval := time.Duration(s.config.VersionCheckIntervalSec) * time.Second
+	for {
+		s.checkUpstreamVersion()
+		s.probeCapabilities()
+		if interval <= 0 {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (s *Server) checkUpstreamVersion() {
+	version, err := s.fetchUpstreamVersion()
+	uv := &s.upstreamVersion
+	uv.mu.Lock()
+	previous := uv.version
+	uv.checkedAt = time.Now()
+	if err != nil {
+		uv.err = err.Error()
+		uv.mu.Unlock()
+		return
+	}
+	uv.version, uv.err = version, ""
+	uv.mu.Unlock()
+
+	if version == previous {
+		return
+	}
+	log.Printf("Ollama version: %s", version)
+	api := upstreamAPI(version)
+	s.ollamaClient.SetAPI(api)
+	if api.ModelKey != "" {
+		log.Printf("Ollama API: model named by %q in requests", api.ModelKey)
+	}
+	fields := map[string]interface{}{"version": version}
+	if previous != "" {
+		fields["previous"] = previous
+	}
+	s.events.Record("upstream_version", fields)
+	if version = comparableVersion(version); version == "" {
+		return
+	}
+	if min := s.config.MinOllamaVersion; min != "" && compareVersions(version, min) < 0 {
+		log.Printf("!!! WARNING: Ollama %s is older than MIN_OLLAMA_VERSION %s; some features are disabled, see /api/status !!!", version, min)
+	}
+	for _, f := range upstreamFeatures {
+		if compareVersions(version, f.minVersion) < 0 {
+			log.Printf("!!! WARNING: Ollama %s lacks %s (needs %s); disabled !!!", version, f.desc, f.minVersion)
+		}
+	}
+}
+
+func (s *Server) fetchUpstreamVersion() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return s.ollamaClient.Version(ctx)
+}
+
+// upstreamVersionString returns the last known Ollama version ("" if unknown).
+func (s *Server) upstreamVersionString() string {
+	s.upstreamVersion.mu.RLock()
+	defer s.upstreamVersion.mu.RUnlock()
+	return s.upstreamVersion.version
+}
+
+// comparableVersion returns version, or "" for versions that can't be gated
+// on: unknown, or "0.0.0" as reported by Ollama builds from source.
+func comparableVersion(version string) string {
+	if version == "0.0.0" {
+		return ""
+	}
+	return version
+}
+
+// featureAvailable reports whether the upstream supports feature. While the
+// version is unknown every feature is assumed available.
+func (s *Server) featureAvailable(feature string) (ok bool, minVersion string) {
+	version := comparableVersion(s.upstreamVersionString())
+	for _, f := range upstreamFeatures {
+		if f.name == feature {
+			return version == "" || compareVersions(version, f.minVersion) >= 0, f.minVersion
+		}
+	}
+	return true, ""
+}
+
+// handleStatus reports the upstream version and which features are degraded.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	uv := &s.upstreamVersion
+	uv.mu.RLock()
+	version, verr, checkedAt := uv.version, uv.err, uv.checkedAt
+	uv.mu.RUnlock()
+
+	var degraded []string
+	if verr != "" {
+		degraded = append(degraded, "ollama unreachable: "+verr)
+	}
+	ollamaInfo := map[string]interface{}{
+		"url":         s.config.OllamaURL,
+		"version":     version,
+		"min_version": s.config.MinOllamaVersion,
+		"reachable":   verr == "" && !checkedAt.IsZero(),
+		"api":         s.ollamaClient.API(),
+	}
+	if !checkedAt.IsZero() {
+		ollamaInfo["checked_at"] = checkedAt
+	}
+	if verr != "" {
+		ollamaInfo["error"] = verr
+	}
+	if v, min := comparableVersion(version), s.config.MinOllamaVersion; v != "" && min != "" && compareVersions(v, min) < 0 {
+		degraded = append(degraded, fmt.Sprintf("ollama %s is older than MIN_OLLAMA_VERSION %s", version, min))
+	}
+
+	features := make(map[string]interface{}, len(upstreamFeatures))
+	for _, f := range upstreamFeatures {
+		ok, _ := s.featureAvailable(f.name)
+		features[f.name] = map[string]interface{}{
+			"available":   ok,
+			"min_version": f.minVersion,
+			"description": f.desc,
+		}
+		if !ok {
+			degraded = append(degraded, fmt.Sprintf("%s disabled (needs ollama %s)", f.name, f.minVersion))
+		}
+	}
+
+	status := "ok"
+	if len(degraded) > 0 {
+		status = "degraded"
+	}
+	resp := map[string]interface{}{
+		"status":   status,
+		"model":    s.model(),
+		"ollama":   ollamaInfo,
+		"features": features,
+		"degraded": append([]string{}, degraded...),
+	}
+	if s.config.UpstreamProbeIntervalSec > 0 {
+		upstream := s.upstreamStateInfo(true)
+		resp["upstream_state"] = upstream
+		if upstream["state"] == upstreamUnreachable && verr == "" {
+			resp["status"] = "degraded"
+			resp["degraded"] = append(degraded, fmt.Sprintf("ollama unreachable since %s: %s",
+				upstream["since"].(time.Time).Format(time.RFC3339), upstream["last_error"]))
+		}
+	}
+	if s.model() != "" {
+		resp["capabilities"] = s.capabilities(s.model())
+		resp["model_load"] = s.modelLoadInfo()
+	}
+	if s.hotModels != nil {
+		resp["hot_models"] = s.hotModelsInfo()
+	}
+	if s.sysLoad != nil {
+		resp["system_load"] = s.systemLoadInfo()
+	}
+	if thermal := s.thermal.info(); thermal["settings"].(thermalSettings).Enabled {
+		resp["thermal"] = thermal
+	}
+	if sw := s.modelSwitch.info(); sw["state"] != switchIdle {
+		resp["model_switch"] = sw
+	}
+	if s.audit != nil {
+		resp["conformance"] = s.audit.info()
+	}
+	if batches := s.embedBatches.snapshot(); len(batches) > 0 {
+		resp["embedding_batches"] = batches
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// compareVersions compares dotted versions like "0.5.7" or "v0.6.0-rc1"
+// numerically (pre-release suffixes are ignored). Returns -1, 0 or 1.
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) []int {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+ "); i >= 0 {
+		v = v[:i]
+	}
+	var parts []int
+	for _, p := range strings.Split(v, ".") {
+		n, _ := strconv.Atoi(p)
+		parts = append(parts, n)
+	}
+	return parts
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Load states of the configured model, reported as model_load.state.
+const (
+	modelLoadUnknown   = "unknown"    // /api/ps not checked yet
+	modelNotLoaded     = "not_loaded" // downloaded, but not in memory (never used, or unloaded after keep_alive)
+	modelWarming       = "warming"    // a request is waiting for Ollama to load it
+	modelLoaded        = "loaded"
+	modelLoadCheckWait = 5 * time.Second
+)
+
+// modelLoadState tracks whether the configured model is in Ollama's memory,
+// so the UI can tell "ready but cold" from "loaded", and show a warming-up
+// phase while the first request after an idle unload waits for the load.
+type modelLoadState struct {
+	mu           sync.Mutex
+	state        string
+	checkedAt    time.Time
+	loadedAt     time.Time
+	expiresAt    time.Time
+	sizeVRAM     int64
+	warmers      int // requests currently waiting for the load
+	warmingSince time.Time
+	lastWarmup   time.Duration
+}
+
+// refreshModelLoad updates the load state from /api/ps.
+func (s *Server) refreshModelLoad(ctx context.Context) {
+	model := s.model()
+	if model == "" {
+		return
+	}
+	running, err := s.ollamaClient.RunningModels(ctx)
+	if err != nil {
+		return // reachability is the prober's business; keep the last known state
+	}
+	ml := &s.modelLoad
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.checkedAt = time.Now()
+	for _, m := range running {
+		if matchesModel(m.Name, model) || matchesModel(m.Model, model) {
+			if ml.state != modelLoaded {
+				ml.loadedAt = ml.checkedAt
+				if ml.state != modelLoadUnknown && ml.state != "" {
+					s.events.Record("model_loaded", map[string]interface{}{"model": model, "reason": "seen in /api/ps"})
+				}
+			}
+			ml.state, ml.expiresAt, ml.sizeVRAM = modelLoaded, m.ExpiresAt, m.SizeVRAM
+			return
+		}
+	}
+	ml.expiresAt, ml.sizeVRAM = time.Time{}, 0
+	if ml.warmers > 0 {
+		ml.state = modelWarming
+	} else {
+		ml.state = modelNotLoaded
+	}
+}
+
+// withWarmup marks the model as warming while a request for it runs and the
+// model was not loaded, until Ollama's first response arrives (the load is
+// done by then, for streaming and non-streaming requests alike).
+func (s *Server) withWarmup(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ml := &s.modelLoad
+		ml.mu.Lock()
+		cold := s.model() != "" && (ml.state == modelNotLoaded || ml.state == modelWarming)
+		if !cold {
+			ml.mu.Unlock()
+			h(w, r)
+			return
+		}
+		if ml.warmers == 0 {
+			ml.warmingSince = time.Now()
+			log.Printf("Model %s is not loaded; warming up", s.model())
+		}
+		ml.warmers++
+		ml.state = modelWarming
+		ml.mu.Unlock()
+
+		var once sync.Once
+		done := func() { once.Do(func() { s.warmupDone() }) }
+		if meta := metaFrom(r); meta != nil {
+			meta.update(func(m *requestMeta) { m.onUpstream = done })
+		}
+		h(w, r)
+		done()
+	}
+}
+
+// warmupDone ends one request's wait; the last one marks the model loaded
+// (confirmed against /api/ps in the background).
+func (s *Server) warmupDone() {
+	ml := &s.modelLoad
+	ml.mu.Lock()
+	ml.warmers--
+	if ml.warmers == 0 && ml.state == modelWarming {
+		ml.lastWarmup = time.Since(ml.warmingSince)
+		ml.state, ml.loadedAt = modelLoaded, time.Now()
+		log.Printf("Model %s warmed up in %s", s.model(), ml.lastWarmup.Round(time.Millisecond))
+		s.events.Record("model_loaded", map[string]interface{}{
+			"model": s.model(), "reason": "request", "duration_ms": ml.lastWarmup.Milliseconds(),
+		})
+	}
+	ml.mu.Unlock()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), modelLoadCheckWait)
+		defer cancel()
+		s.refreshModelLoad(ctx)
+	}()
+}
+
+// modelLoadInfo returns the model_load object for /api/status and /api/progress.
+func (s *Server) modelLoadInfo() map[string]interface{} {
+	ml := &s.modelLoad
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	state := ml.state
+	if state == "" {
+		state = modelLoadUnknown
+	}
+	info := map[string]interface{}{"state": state}
+	if !ml.checkedAt.IsZero() {
+		info["checked_at"] = ml.checkedAt
+	}
+	switch state {
+	case modelLoaded:
+		info["loaded_at"] = ml.loadedAt
+		if !ml.expiresAt.IsZero() {
+			info["expires_at"] = ml.expiresAt
+		}
+		if ml.sizeVRAM > 0 {
+			info["size_vram"] = ml.sizeVRAM
+		}
+	case modelWarming:
+		info["warming_since"] = ml.warmingSince
+		info["waiting_requests"] = ml.warmers
+	}
+	if ml.lastWarmup > 0 {
+		info["last_warmup_ms"] = ml.lastWarmup.Milliseconds()
+	}
+	return info
+}
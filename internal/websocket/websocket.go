@@ -0,0 +1,280 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455) as far as the proxy's realtime endpoint needs it: the upgrade
+// handshake, text and binary messages (fragmented or not), ping/pong and
+// the closing handshake, on net/http's Hijacker. Extensions and
+// subprotocols are not negotiated.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Message types.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
+
+const (
+	opContinuation = 0
+	opClose        = 8
+	opPing         = 9
+	opPong         = 10
+)
+
+// Close status codes.
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseInvalidData   = 1007
+	CloseTooBig        = 1009
+)
+
+// acceptGUID is appended to the client's key for Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrClosed is returned by ReadMessage once the peer closed the connection.
+var ErrClosed = errors.New("websocket: connection closed")
+
+// HandshakeError is a request that can't be upgraded; Status is the HTTP
+// status to answer it with.
+type HandshakeError struct {
+	Status  int
+	Message string
+}
+
+func (e *HandshakeError) Error() string { return e.Message }
+
+// IsUpgrade reports whether r asks for a WebSocket connection.
+func IsUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// Conn is an upgraded connection. Reads must come from one goroutine;
+// writes may come from several.
+type Conn struct {
+	conn       net.Conn
+	br         *bufio.Reader
+	mu         sync.Mutex // serializes frame writes
+	maxMessage int
+	closeSent  bool
+}
+
+// Upgrade answers the handshake of r and takes over the connection.
+// Messages larger than maxMessage bytes are refused with CloseTooBig. On a
+// *HandshakeError nothing was written; the caller answers the request.
+func Upgrade(w http.ResponseWriter, r *http.Request, maxMessage int) (*Conn, error) {
+	switch {
+	case r.Method != http.MethodGet:
+		return nil, &HandshakeError{http.StatusMethodNotAllowed, "WebSocket upgrade requires GET"}
+	case !IsUpgrade(r):
+		return nil, &HandshakeError{http.StatusUpgradeRequired, "this endpoint only speaks WebSocket"}
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, &HandshakeError{http.StatusUpgradeRequired, "unsupported Sec-WebSocket-Version (want 13)"}
+	}
+	key := strings.TrimSpace(r.Header.Get("Sec-WebSocket-Key"))
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, &HandshakeError{http.StatusBadRequest, "missing or malformed Sec-WebSocket-Key"}
+	}
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: hijack: %w", err)
+	}
+	conn.SetDeadline(time.Time{}) // the server's read/write timeouts don't apply to a session
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: handshake: %w", err)
+	}
+	return &Conn{conn: conn, br: brw.Reader, maxMessage: maxMessage}, nil
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered
+// and pongs skipped on the way. A close from the peer is answered and
+// reported as ErrClosed; a protocol violation closes the connection with
+// the matching status and returns an error.
+func (c *Conn) ReadMessage() (messageType int, data []byte, err error) {
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			code := CloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.Close(code, "")
+			return 0, nil, ErrClosed
+		case TextMessage, BinaryMessage:
+		default:
+			return 0, nil, c.fail(CloseProtocolError, fmt.Sprintf("unexpected opcode %d", op))
+		}
+		messageType, data = op, payload
+		for !fin {
+			var next []byte
+			fin, op, next, err = c.readFrame()
+			if err != nil {
+				return 0, nil, err
+			}
+			switch op {
+			case opPing:
+				if err := c.writeFrame(opPong, next); err != nil {
+					return 0, nil, err
+				}
+				fin = false
+				continue
+			case opPong:
+				fin = false
+				continue
+			case opClose:
+				c.Close(CloseNormal, "")
+				return 0, nil, ErrClosed
+			case opContinuation:
+			default:
+				return 0, nil, c.fail(CloseProtocolError, "data frame inside a fragmented message")
+			}
+			if len(data)+len(next) > c.maxMessage {
+				return 0, nil, c.fail(CloseTooBig, "message too big")
+			}
+			data = append(data, next...)
+		}
+		if messageType == TextMessage && !utf8.Valid(data) {
+			return 0, nil, c.fail(CloseInvalidData, "text message is not valid UTF-8")
+		}
+		return messageType, data, nil
+	}
+}
+
+// readFrame reads one frame and unmasks its payload.
+func (c *Conn) readFrame() (fin bool, op int, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = head[0]&0x80 != 0, int(head[0]&0x0f)
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "client frames must be masked")
+	}
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= opClose && (length > 125 || !fin) {
+		return false, 0, nil, c.fail(CloseProtocolError, "malformed control frame")
+	}
+	if length > uint64(c.maxMessage) {
+		return false, 0, nil, c.fail(CloseTooBig, "message too big")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// WriteMessage sends data as one unfragmented message.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	return c.writeFrame(messageType, data)
+}
+
+func (c *Conn) writeFrame(op int, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	if op == opClose {
+		c.closeSent = true
+	}
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|byte(op))
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame with code and reason (once) and closes the
+// connection.
+func (c *Conn) Close(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	c.writeFrame(opClose, append(payload, reason...))
+	return c.conn.Close()
+}
+
+// fail closes the connection for a protocol violation.
+func (c *Conn) fail(code int, reason string) error {
+	c.Close(code, reason)
+	return fmt.Errorf("websocket: %s", reason)
+}
+
+// headerHasToken reports whether the comma-separated header contains token
+// (case-insensitive), e.g. "Connection: keep-alive, Upgrade".
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
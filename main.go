@@ -3,11 +3,14 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"runtime/debug"
 	"strings"
 	"syscall"
 	"time"
@@ -15,13 +18,18 @@ import (
 	"olares-ollama/internal/config"
 	"olares-ollama/internal/download"
 	"olares-ollama/internal/huggingface"
+	"olares-ollama/internal/logging"
 	"olares-ollama/internal/ollama"
+	"olares-ollama/internal/reporting"
 	"olares-ollama/internal/server"
 )
 
 func main() {
 	// Load configuration
 	cfg := config.Load()
+	level, _ := logging.ParseLevel(cfg.LogLevel) // an invalid value is reported by Validate
+	logging.Install(level)
+	logging.SetDedupWindow(time.Duration(cfg.LogDedupWindowSec) * time.Second)
 
 	log.Printf("Starting Olares-Ollama proxy server...")
 	if cfg.GGUFMode {
@@ -31,20 +39,50 @@ func main() {
 	} else {
 		log.Printf("Target model: %s", cfg.Model)
 	}
-	log.Printf("Ollama server: %s", cfg.OllamaURL)
+	log.Printf("Ollama server: %s", config.Mask("OLLAMA_URL", cfg.OllamaURL))
 	log.Printf("Download timeout: %d minutes", cfg.DownloadTimeout)
+	logEffectiveConfig(cfg)
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("%v", err)
+	}
 
 	// Create Ollama client
 	ollamaClient := ollama.NewClientWithTimeout(cfg.OllamaURL, cfg.DownloadTimeout)
+	ollamaClient.SetConnMaxAge(time.Duration(cfg.UpstreamConnMaxAgeSec) * time.Second)
+	ollamaClient.SetInsecurePull(cfg.PullInsecure)
+	ollamaClient.SetQuietPull(cfg.PullQuiet)
+	if cfg.UpstreamFirstByteTimeoutSec > 0 {
+		ollamaClient.SetFirstByteTimeout(time.Duration(cfg.UpstreamFirstByteTimeoutSec) * time.Second)
+	}
+	if cfg.OutboundProxy != "" {
+		proxyURL, err := parseOutboundProxy(cfg.OutboundProxy)
+		if err != nil {
+			log.Fatalf("Invalid OUTBOUND_PROXY: %v", err)
+		}
+		log.Printf("Outbound proxy: %s (scope: %s)", proxyURL.Redacted(), cfg.OutboundProxyScope)
+		ollamaClient.SetRegistryProxy(proxyURL) // manifest lookups are download traffic
+		switch cfg.OutboundProxyScope {
+		case "all":
+			ollamaClient.SetOutboundProxy(proxyURL)
+		case "downloads":
+		default:
+			log.Fatalf("Invalid OUTBOUND_PROXY_SCOPE %q (want \"downloads\" or \"all\")", cfg.OutboundProxyScope)
+		}
+	}
 
 	// Create and start server
 	srv := server.New(cfg, ollamaClient)
+	if cfg.ErrorReportingDSN != "" {
+		reporter, err := reporting.New(cfg.ErrorReportingDSN, cfg.ErrorReportingEnv, buildRelease())
+		if err != nil {
+			log.Fatalf("Invalid ERROR_REPORTING_DSN: %v", err)
+		}
+		srv.SetReporter(reporter)
+		log.Printf("Error reporting enabled (environment: %s)", cfg.ErrorReportingEnv)
+	}
 
 	// Start HTTP server
-	httpServer := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.Port),
-		Handler: srv.Handler(),
-	}
+	httpServer := newHTTPServer(fmt.Sprintf(":%d", cfg.Port), srv.Handler(), cfg)
 
 	// Start HTTP server immediately (in background)
 	go func() {
@@ -55,6 +93,51 @@ func main() {
 	}()
 
 	log.Printf("Server started on port %d", cfg.Port)
+	srv.Events().Record("server_started", map[string]interface{}{
+		"release": buildRelease(), "model": cfg.Model, "port": cfg.Port,
+	})
+
+	// Management endpoints on their own listener, e.g. localhost-only, so
+	// they are never reachable through the public port
+	if adminHandler := srv.AdminHandler(); adminHandler != nil {
+		adminServer := newHTTPServer(cfg.AdminAddr, adminHandler, cfg)
+		go func() {
+			log.Printf("Admin endpoints (/admin, /api/errors, /debug) listening on %s", cfg.AdminAddr)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start admin server: %v", err)
+			}
+		}()
+	}
+
+	// gRPC API: HTTP/2 without TLS only, as gRPC clients connect with prior knowledge
+	if cfg.GRPCPort > 0 {
+		grpcServer := newHTTPServer(fmt.Sprintf(":%d", cfg.GRPCPort), srv.GRPCHandler(), cfg)
+		var protocols http.Protocols
+		protocols.SetUnencryptedHTTP2(true)
+		grpcServer.Protocols = &protocols
+		go func() {
+			log.Printf("gRPC API (olares.ollama.v1.Inference, grpc.health.v1.Health) listening on port %d", cfg.GRPCPort)
+			if err := grpcServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start gRPC server: %v", err)
+			}
+		}()
+	}
+
+	if cfg.EnablePprof {
+		if cfg.PprofPort > 0 {
+			pprofAddr := fmt.Sprintf("127.0.0.1:%d", cfg.PprofPort)
+			go func() {
+				log.Printf("pprof listening on http://%s/debug/pprof/", pprofAddr)
+				if err := newHTTPServer(pprofAddr, server.PprofHandler(), cfg).ListenAndServe(); err != nil {
+					log.Printf("pprof listener stopped: %v", err)
+				}
+			}()
+		} else if cfg.AdminAddr != "" {
+			log.Printf("pprof enabled at http://%s/debug/pprof/", cfg.AdminAddr)
+		} else if cfg.AdminToken != "" {
+			log.Printf("pprof enabled at http://localhost:%d/debug/pprof/ (admin role required)", cfg.Port)
+		}
+	}
 
 	if !cfg.BaseMode {
 		log.Printf("You can now view download progress at: http://localhost:%d", cfg.Port)
@@ -74,7 +157,7 @@ func main() {
 		}
 
 		// Check and download model in background with infinite retry
-		go ensureModelLoop(ollamaClient, cfg, pm, retryCh)
+		go ensureModelLoop(ollamaClient, cfg, pm, retryCh, srv.ModelPinned, srv.ConfirmDownload)
 	} else {
 		log.Printf("Base mode UI at: http://localhost:%d", cfg.Port)
 	}
@@ -92,16 +175,101 @@ func main() {
 	if err := httpServer.Shutdown(ctx); err != nil {
 		log.Fatal("Server forced to shutdown:", err)
 	}
+	srv.Events().Record("server_stopped", nil)
+	srv.Events().Close()
 
 	log.Println("Server exited")
 }
 
+// newHTTPServer creates a listener with the SERVER_*_TIMEOUT_SEC settings,
+// accepting HTTP/2 without TLS (h2c) next to HTTP/1.1 when ENABLE_H2C is set.
+func newHTTPServer(addr string, handler http.Handler, cfg *config.Config) *http.Server {
+	sec := func(n int) time.Duration { return time.Duration(n) * time.Second }
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: sec(cfg.ServerReadHeaderTimeoutSec),
+		ReadTimeout:       sec(cfg.ServerReadTimeoutSec),
+		WriteTimeout:      sec(cfg.ServerWriteTimeoutSec),
+		IdleTimeout:       sec(cfg.ServerIdleTimeoutSec),
+	}
+	if cfg.EnableH2C {
+		var protocols http.Protocols
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		srv.Protocols = &protocols
+	}
+	return srv
+}
+
+// logEffectiveConfig logs every setting as resolved (defaults included,
+// secrets masked; also served at /admin/config).
+func logEffectiveConfig(cfg *config.Config) {
+	log.Printf("Effective configuration:")
+	for _, st := range cfg.Settings() {
+		value := st.Value
+		if len(value) > 80 {
+			value = value[:77] + "..."
+		}
+		log.Printf("  %-30s %q (%s)", st.Env, value, st.Source)
+	}
+}
+
+// buildRelease identifies this build in error reports: the VCS revision
+// stamped by go build, or "dev".
+func buildRelease() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+			return setting.Value[:12]
+		}
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	return "dev"
+}
+
+// parseOutboundProxy validates an OUTBOUND_PROXY URL.
+func parseOutboundProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q (want http, https, socks5 or socks5h)", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in %q", raw)
+	}
+	return u, nil
+}
+
+// setDownloadProxy applies OUTBOUND_PROXY to a Hugging Face downloader
+// (downloads use it in both scopes; the URL was validated at startup).
+func setDownloadProxy(dl *huggingface.Downloader, cfg *config.Config) {
+	if cfg.OutboundProxy == "" {
+		return
+	}
+	if proxyURL, err := parseOutboundProxy(cfg.OutboundProxy); err == nil {
+		dl.SetProxy(proxyURL)
+	}
+}
+
 // ensureModelLoop wraps ensureModel with infinite retry: on failure it waits
 // with exponential backoff (up to 5 min) and retries. A signal on retryCh
 // (from /api/retry) wakes it up immediately.
 // After success, it monitors Ollama health; if Ollama goes down, it re-enters
 // the retry loop so the frontend always reflects the real state.
-func ensureModelLoop(client *ollama.Client, cfg *config.Config, progressManager *download.ProgressManager, retryCh <-chan struct{}) {
+// pinned reports the models the user protected (PINNED_MODELS, /admin/pins).
+// confirm holds a large download until the user confirms it
+// (DOWNLOAD_CONFIRM_THRESHOLD_MB); a declined one is only retried on /api/retry.
+func ensureModelLoop(client *ollama.Client, cfg *config.Config, progressManager *download.ProgressManager, retryCh <-chan struct{}, pinned func(string) bool, confirm func(string) error) {
 	modelName := cfg.Model
 	backoff := 30 * time.Second
 	const maxBackoff = 5 * time.Minute
@@ -109,9 +277,9 @@ func ensureModelLoop(client *ollama.Client, cfg *config.Config, progressManager
 	for {
 		var err error
 		if cfg.GGUFMode {
-			err = ensureModelGGUF(client, cfg, progressManager)
+			err = ensureModelGGUF(client, cfg, progressManager, pinned)
 		} else {
-			err = ensureModel(client, modelName, cfg.OllamaPullDelaySec, progressManager)
+			err = ensureModel(client, modelName, cfg.OllamaPullDelaySec, progressManager, confirm)
 		}
 		if err == nil {
 			monitorOllamaHealth(client, modelName, progressManager, retryCh)
@@ -124,6 +292,13 @@ func ensureModelLoop(client *ollama.Client, cfg *config.Config, progressManager
 		log.Printf("Failed to ensure model: %v", err)
 		progressManager.UpdateError(err.Error(), 0, 0, modelName)
 
+		if errors.Is(err, server.ErrDownloadDeclined) {
+			log.Printf("Download declined; waiting for /api/retry")
+			<-retryCh
+			backoff = 30 * time.Second
+			continue
+		}
+
 		log.Printf("Will retry in %v (or immediately on /api/retry)...", backoff)
 		select {
 		case <-time.After(backoff):
@@ -184,7 +359,7 @@ func monitorOllamaHealth(client *ollama.Client, modelName string, progressManage
 
 // ensureModelGGUF downloads a GGUF from Hugging Face, pushes it as an Ollama
 // blob, and registers the model via POST /api/create with the files field.
-func ensureModelGGUF(client *ollama.Client, cfg *config.Config, progressManager *download.ProgressManager) error {
+func ensureModelGGUF(client *ollama.Client, cfg *config.Config, progressManager *download.ProgressManager, pinned func(string) bool) error {
 	modelName := cfg.Model
 	if modelName == "" {
 		modelName = strings.TrimSuffix(cfg.HFFile, ".gguf")
@@ -200,14 +375,21 @@ func ensureModelGGUF(client *ollama.Client, cfg *config.Config, progressManager
 	}
 
 	// Check current state (informational only; we always (re-)create to
-	// ensure template/params updates take effect).
+	// ensure template/params updates take effect), unless the model is
+	// pinned: then the user's own setup in Ollama is kept as is.
 	exists, _ := client.ModelExists(modelName)
+	if exists && pinned(modelName) {
+		log.Printf("GGUF model %s is pinned, keeping it instead of re-creating", modelName)
+		progressManager.UpdateProgress("completed", 0, 0, modelName)
+		return nil
+	}
 	if exists {
 		log.Printf("GGUF model %s already registered, will re-create to apply latest config", modelName)
 	}
 
 	// Download GGUF
 	dl := huggingface.New(cfg.HFEndpoint, cfg.HFRepo, cfg.HFFile, cfg.HFToken, cfg.GGUFDir)
+	setDownloadProxy(dl, cfg)
 	if !dl.AlreadyDone() {
 		log.Printf("Downloading GGUF: %s/%s -> %s", cfg.HFRepo, cfg.HFFile, dl.DestPath())
 		if err := dl.Download(ctx, modelName, progressManager); err != nil {
@@ -247,6 +429,7 @@ func ensureModelGGUF(client *ollama.Client, cfg *config.Config, progressManager
 	// Download mmproj (vision projector) if configured
 	if cfg.HFMMProjFile != "" {
 		mmDl := huggingface.New(cfg.HFEndpoint, cfg.HFRepo, cfg.HFMMProjFile, cfg.HFToken, cfg.GGUFDir)
+		setDownloadProxy(mmDl, cfg)
 		if !mmDl.AlreadyDone() {
 			log.Printf("Downloading mmproj: %s/%s -> %s", cfg.HFRepo, cfg.HFMMProjFile, mmDl.DestPath())
 			if err := mmDl.Download(ctx, modelName, progressManager); err != nil {
@@ -311,7 +494,7 @@ func ensureModelGGUF(client *ollama.Client, cfg *config.Config, progressManager
 	return nil
 }
 
-func ensureModel(client *ollama.Client, modelName string, ollamaPullDelaySec int, progressManager *download.ProgressManager) error {
+func ensureModel(client *ollama.Client, modelName string, ollamaPullDelaySec int, progressManager *download.ProgressManager, confirm func(string) error) error {
 	// Wait for Ollama to be reachable (e.g. when proxy and Ollama run in separate pods)
 	ctx := context.Background()
 	const ollamaWaitTimeout = 30 * time.Minute
@@ -347,6 +530,10 @@ func ensureModel(client *ollama.Client, modelName string, ollamaPullDelaySec int
 		return nil
 	}
 
+	if err := confirm(modelName); err != nil {
+		return err
+	}
+
 	log.Printf("Model %s not found, starting download...", modelName)
 	progressManager.UpdateProgress("downloading", 0, 0, modelName)
 
@@ -0,0 +1,234 @@
+// Package proxytest runs the proxy against a fake Ollama for end-to-end
+// tests: a Harness wires a real server (configured from environment
+// variables, as in production) to a scriptable fake, and the helpers send
+// requests and decode JSON, NDJSON and SSE responses.
+package proxytest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"olares-ollama/internal/config"
+	"olares-ollama/internal/fakeollama"
+	"olares-ollama/internal/ollama"
+	"olares-ollama/internal/server"
+)
+
+// Model is the model the harness configures (OLLAMA_MODEL) unless the
+// environment passed to New sets another one.
+const Model = "test-model"
+
+type (
+	// Reply scripts one answer of the fake Ollama.
+	Reply = fakeollama.Reply
+	// ToolCall is a function call in a scripted reply.
+	ToolCall = fakeollama.ToolCall
+	// Request is a request the fake Ollama received.
+	Request = fakeollama.Request
+	// Fault is a way a scripted reply breaks (Reply.Fault).
+	Fault = fakeollama.Fault
+)
+
+// The faults a Reply can script; see package fakeollama.
+const (
+	Disconnect    = fakeollama.Disconnect
+	Truncate      = fakeollama.Truncate
+	MalformedLine = fakeollama.MalformedLine
+	ErrorLine     = fakeollama.ErrorLine
+)
+
+// Harness is a proxy serving on URL, backed by the fake Ollama.
+type Harness struct {
+	t      testing.TB
+	Ollama *fakeollama.Server
+	Server *server.Server
+	Config *config.Config
+	URL    string
+}
+
+// New starts a fake Ollama and a proxy in front of it. env sets
+// configuration variables on top of the harness defaults; the test runs
+// in a temporary directory so the proxy's data files stay out of the tree.
+// Tests using a Harness can't run in parallel (they change the environment).
+func New(t testing.TB, env map[string]string) *Harness {
+	t.Helper()
+	fake := fakeollama.New()
+	t.Cleanup(fake.Close)
+	t.Chdir(t.TempDir())
+
+	settings := map[string]string{
+		"OLLAMA_URL":   fake.URL,
+		"OLLAMA_MODEL": Model,
+		"LOG_LEVEL":    "warn",
+	}
+	for k, v := range env {
+		settings[k] = v
+	}
+	for k, v := range settings {
+		t.Setenv(k, v)
+	}
+	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("proxytest: %v", err)
+	}
+
+	client := ollama.NewClient(cfg.OllamaURL)
+	if cfg.UpstreamFirstByteTimeoutSec > 0 {
+		client.SetFirstByteTimeout(time.Duration(cfg.UpstreamFirstByteTimeoutSec) * time.Second)
+	}
+	srv := server.New(cfg, client)
+	proxy := httptest.NewServer(srv.Handler())
+	t.Cleanup(proxy.Close)
+	return &Harness{t: t, Ollama: fake, Server: srv, Config: cfg, URL: proxy.URL}
+}
+
+// Do sends a request to the proxy. body is sent as is when it is a string
+// or []byte and JSON-encoded otherwise; headers are name/value pairs.
+func (h *Harness) Do(method, path string, body interface{}, headers ...string) *http.Response {
+	h.t.Helper()
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r = strings.NewReader(b)
+	case []byte:
+		r = bytes.NewReader(b)
+	default:
+		raw, err := json.Marshal(b)
+		if err != nil {
+			h.t.Fatalf("proxytest: encoding request body: %v", err)
+		}
+		r = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequest(method, h.URL+path, r)
+	if err != nil {
+		h.t.Fatalf("proxytest: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatalf("proxytest: %s %s: %v", method, path, err)
+	}
+	h.t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// PostJSON posts body and decodes the JSON object the proxy answers with.
+func (h *Harness) PostJSON(path string, body interface{}, headers ...string) (int, map[string]interface{}) {
+	h.t.Helper()
+	resp := h.Do(http.MethodPost, path, body, headers...)
+	return resp.StatusCode, DecodeJSON(h.t, resp.Body)
+}
+
+// GetJSON gets path and decodes the JSON object the proxy answers with.
+func (h *Harness) GetJSON(path string, headers ...string) (int, map[string]interface{}) {
+	h.t.Helper()
+	resp := h.Do(http.MethodGet, path, nil, headers...)
+	return resp.StatusCode, DecodeJSON(h.t, resp.Body)
+}
+
+// LastUpstream returns the body of the latest request the proxy sent to
+// Ollama on path, failing the test when there was none.
+func (h *Harness) LastUpstream(path string) map[string]interface{} {
+	h.t.Helper()
+	req, ok := h.Ollama.LastRequest(path)
+	if !ok {
+		h.t.Fatalf("proxytest: no upstream request to %s", path)
+	}
+	return req.Body
+}
+
+// DecodeJSON reads one JSON object.
+func DecodeJSON(t testing.TB, r io.Reader) map[string]interface{} {
+	t.Helper()
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("proxytest: reading response: %v", err)
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		t.Fatalf("proxytest: response is not a JSON object: %v\n%s", err, raw)
+	}
+	return out
+}
+
+// DecodeNDJSON reads newline-delimited JSON objects.
+func DecodeNDJSON(t testing.TB, r io.Reader) []map[string]interface{} {
+	t.Helper()
+	var out []map[string]interface{}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var obj map[string]interface{}
+		if err := json.Unmarshal(line, &obj); err != nil {
+			t.Fatalf("proxytest: NDJSON line is not a JSON object: %v\n%s", err, line)
+		}
+		out = append(out, obj)
+	}
+	return out
+}
+
+// Event is one server-sent event. JSON is its data decoded, when the data
+// is a JSON object.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	JSON  map[string]interface{}
+}
+
+// DecodeSSE reads a server-sent event stream.
+func DecodeSSE(t testing.TB, r io.Reader) []Event {
+	t.Helper()
+	var out []Event
+	var ev Event
+	var data []string
+	flush := func() {
+		if len(data) == 0 && ev.Event == "" {
+			return
+		}
+		ev.Data = strings.Join(data, "\n")
+		if json.Unmarshal([]byte(ev.Data), &ev.JSON) != nil {
+			ev.JSON = nil
+		}
+		out = append(out, ev)
+		ev, data = Event{}, nil
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "data":
+			data = append(data, value)
+		case "event":
+			ev.Event = value
+		case "id":
+			ev.ID = value
+		}
+	}
+	flush()
+	return out
+}